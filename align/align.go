@@ -0,0 +1,126 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: align/align.go
+// Alignment of reads to multigenomes (references with known variant loci marked by '*'). An Aligner
+// holds the multigenome, the alignment parameters and the few things it needs from the variant caller
+// (positions of candidate variants, reference context costs, debug output) behind small interfaces, so
+// that it does not depend on global state of the caller.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package align
+
+import "math"
+
+//---------------------------------------------------------------------------------------------------
+// Params represents parameters of alignment.
+//---------------------------------------------------------------------------------------------------
+type Params struct {
+	Dist_thres     float64 // threshold of alignment distances
+	Sub_cost       float64 // cost of substitutions
+	Gap_open       float64 // cost of gap open
+	Gap_ext        float64 // cost of gap extension
+	Ts_cost        float64 // change of substitution costs of transitions
+	Tv_cost        float64 // change of substitution costs of transversions
+	Indel_err_rate float64 // probability of indel errors (cost of mismatching alleles at known variant loci)
+	Ham_backup     int     // number of bases backed up from mismatches of the Hamming part
+	Indel_backup   int     // number of bases backed up from known variants of different lengths
+	Aln_band       int     // width of the band of edit parts (0: no band)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Genome represents a multigenome: the reference with '*' at known variant loci and the known variants.
+//---------------------------------------------------------------------------------------------------
+type Genome struct {
+	Seq        []byte            // multigenome sequence
+	SeqLen     int               // length of the multigenome sequence
+	Variants   map[int][][]byte  // alleles of known variants (the first allele is the reference)
+	VarAF      map[int][]float32 // allele frequencies of known variants
+	SameLenVar map[int]int       // known variants whose alleles have the same length (and the length)
+	DelVar     map[int]int       // known deletions (and the length)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Sites reports positions of candidate variants, at which bases matching the reference are also
+// recorded as evidence.
+//---------------------------------------------------------------------------------------------------
+type Sites interface {
+	HasSite(pos int) bool
+}
+
+//---------------------------------------------------------------------------------------------------
+// Context gives gap open and substitution costs depending on the reference context of positions.
+//---------------------------------------------------------------------------------------------------
+type Context interface {
+	ContextGapOpen(pos int) float64
+	ContextSubCost(pos int) float64
+}
+
+//---------------------------------------------------------------------------------------------------
+// Tracer gets inputs, matrices and results of alignments in debug mode.
+//---------------------------------------------------------------------------------------------------
+type Tracer interface {
+	EditDisInput(mess string, pos int, str_val ...[]byte)
+	DisInfo(mess string, i, j int, d float64)
+	EditDisMat(mess string, D [][]float64, m, n int, read, ref []byte)
+	EditTraceMat(mess string, BT [][][]int, m, n int)
+	EditAlignInfo(mess string, aligned_read, aligned_qual, aligned_ref []byte)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Aligner represents an aligner of reads to a multigenome. Context (constant costs Para.Gap_open and
+// Para.Sub_cost) and Trace (no debug output) can be nil.
+//---------------------------------------------------------------------------------------------------
+type Aligner struct {
+	Genome
+	Para      Params
+	Sites     Sites
+	Context   Context
+	Trace     Tracer
+	log_indel float64 // log10 of Para.Indel_err_rate
+}
+
+//---------------------------------------------------------------------------------------------------
+// New creates an aligner of reads to a multigenome with parameters and positions of candidate variants.
+//---------------------------------------------------------------------------------------------------
+func New(para Params, genome Genome, sites Sites) *Aligner {
+	return &Aligner{Genome: genome, Para: para, Sites: sites, log_indel: math.Log10(para.Indel_err_rate)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsTransition checks if the substitution of base a by base b is a transition (A<->G or C<->T).
+//---------------------------------------------------------------------------------------------------
+func IsTransition(a, b byte) bool {
+	a, b = a|0x20, b|0x20 // lower case
+	return (a == 'a' && b == 'g') || (a == 'g' && b == 'a') || (a == 'c' && b == 't') || (a == 't' && b == 'c')
+}
+
+//---------------------------------------------------------------------------------------------------
+// SubTypeCost returns the change of the cost of substituting ref_base by read_base.
+//---------------------------------------------------------------------------------------------------
+func (A *Aligner) SubTypeCost(ref_base, read_base byte) float64 {
+	if IsTransition(ref_base, read_base) {
+		return A.Para.Ts_cost
+	}
+	return A.Para.Tv_cost
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
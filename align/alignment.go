@@ -0,0 +1,1246 @@
+//-------------------------------------------------------------------------------------------------
+// IVC: align/alignment.go
+// Calculating alignment between reads and multigenomes, take into account known variants.
+// Alignment is performed for left and right extensions of seeds on reads and multigenomes.
+// Copyright 2015 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package align
+
+import (
+	"bytes"
+	"math"
+)
+
+//-------------------------------------------------------------------------------------------------
+// AlignCostVarLoci calculates cost of alignment between a read and the reference at known loci.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) AlignCostVarLoci(read, ref, qual []byte, prob float64) float64 {
+	//do not consider qual at this time
+	if string(read) == string(ref) {
+		return -0.1 * math.Log10(prob)
+	} else {
+		return -float64(len(ref)) * A.log_indel
+	}
+}
+
+//-------------------------------------------------------------------------------------------------
+// DelQual returns the quality of deletion evidence of a read given qualities of its two bases
+// anchoring the deletion (the base before and the base after the deleted bases): a deletion is only
+// as reliable as the less reliable of its anchors, so the lower quality is taken.
+//-------------------------------------------------------------------------------------------------
+func DelQual(q_before, q_after byte) byte {
+	if q_after < q_before {
+		return q_after
+	}
+	return q_before
+}
+
+//-------------------------------------------------------------------------------------------------
+// HamKnownLocus aligns a read at a known variant locus whose alleles have different lengths in the
+// Hamming phase of extensions: the only allele which matches the read exactly is taken, as DP would
+// take the allele of the smallest cost. Bases of the read end at the locus (left extensions) or start
+// at it (right extensions). It returns the cost, the number of read bases of the allele, and the
+// variant, its qualities and its type as recorded by DP traceback; it returns false if no allele or
+// several alleles match the read, the locus is then aligned by DP.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) HamKnownLocus(read, qual []byte, pos int, left, del_ref bool, arena *Arena) (float64, int, []byte, []byte, int, bool) {
+	sel := -1
+	for k, var_val := range A.Variants[pos] {
+		if len(var_val) > len(read) {
+			continue
+		}
+		b := read[:len(var_val)]
+		if left {
+			b = read[len(read)-len(var_val):]
+		}
+		if bytes.Equal(b, var_val) {
+			if sel >= 0 {
+				return 0, 0, nil, nil, 0, false
+			}
+			sel = k
+		}
+	}
+	if sel < 0 {
+		return 0, 0, nil, nil, 0, false
+	}
+	var_val, ref_val := A.Variants[pos][sel], A.Variants[pos][0]
+	var_len := len(var_val)
+	read_bases, read_qual := read[:var_len], qual[:var_len]
+	if left {
+		read_bases, read_qual = read[len(read)-var_len:], qual[len(qual)-var_len:]
+	}
+	var_prob := float64(A.VarAF[pos][sel])
+	_, is_del := A.DelVar[pos]
+	if is_del && del_ref { //convert prob with reduced-ref for known DEL
+		var_prob = 1.0 - var_prob
+	}
+	cost := A.AlignCostVarLoci(read_bases, var_val, read_qual, var_prob)
+	v := append(append(append(arena.Alloc(len(ref_val)+maxInt(len(ref_val), var_len)+1)[:0], ref_val...), '|'), read_bases...)
+	var_type := 1
+	if is_del {
+		if !del_ref { //known DEL with non-reduced ref
+			v = append(v[:len(ref_val)+1], ref_val...)
+		}
+		var_type = 2
+	}
+	q := arena.Bytes(read_qual...)
+	return cost, var_len, v, q, var_type, true
+}
+
+//-------------------------------------------------------------------------------------------------
+// ContextCosts returns gap open and substitution costs for columns 1..n of the alignment matrices,
+// idx maps a column to its index in ref_pos_map. Costs are constant if the aligner has no context costs,
+// in this case nil is returned (no allocation) and the constant costs Para.Gap_open and Para.Sub_cost
+// apply to all columns.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) ContextCosts(ref_pos_map []int, n int, idx func(int) int) ([]float64, []float64) {
+	if A.Context == nil {
+		return nil, nil
+	}
+	gap_open, sub_cost := make([]float64, n+1), make([]float64, n+1)
+	for j := 1; j <= n; j++ {
+		gap_open[j], sub_cost[j] = A.Context.ContextGapOpen(ref_pos_map[idx(j)]), A.Context.ContextSubCost(ref_pos_map[idx(j)])
+	}
+	return gap_open, sub_cost
+}
+
+//-------------------------------------------------------------------------------------------------
+// LeftAlign calculates the distance between a read and a ref in backward direction.
+// The read include standard bases, the ref includes standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) LeftAlign(read, qual, ref []byte, pos int, D, IS, IT [][]float64,
+	BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, drop_rows int, del_ref bool, cyc_cost []float64, arena *Arena) (float64, float64,
+	int, int, int, []int, [][]byte, [][]byte, []int) {
+
+	aln_dist, m, n, var_pos, var_base, var_qual, var_type := A.LeftAlignHam(read, qual, ref, pos, ref_pos_map, del_ref, arena)
+	if aln_dist > A.Para.Dist_thres || m == 0 || n == 0 {
+		return aln_dist, 0, -1, m, n, var_pos, var_base, var_qual, var_type
+	}
+	min_dist, bt_mat, ok := A.LeftAlignEdit(read, qual, ref, m, n, pos, aln_dist, D, IS, IT, BT_D, BT_IS, BT_IT, BT_K, ref_pos_map, drop_rows, del_ref, cyc_cost)
+	if !ok {
+		return A.Para.Dist_thres + 1, 0, -1, m, n, var_pos, var_base, var_qual, var_type
+	}
+	return aln_dist, min_dist, bt_mat, m, n, var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// LeftAlignHam aligns a read and a ref in backward direction one-to-one (Hamming part), known variant
+// loci are aligned with their alleles. It returns the distance, the lengths of the read and the ref
+// left to DP (edit part), and variants of the Hamming part; the distance is Para.Dist_thres + 1 if it
+// exceeds the threshold.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) LeftAlignHam(read, qual, ref []byte, pos int, ref_pos_map []int, del_ref bool, arena *Arena) (float64,
+	int, int, []int, [][]byte, [][]byte, []int) {
+
+	var var_len, var_t int
+	var var_val, var_q []byte
+	var is_var, is_same_len_var, is_ham_var bool
+	var p, min_p, var_prob float64
+
+	aln_dist := 0.0
+	m, n := len(read), len(ref)
+	diag_num := 0 // number of bases aligned one-to-one since the last known variant locus
+
+	if A.Trace != nil {
+		A.Trace.EditDisInput("LeftAlign input: read, qual, ref", pos, read, qual, ref)
+	}
+	var var_pos, var_type []int
+	var var_base, var_qual [][]byte
+	var_pos_trace := make(map[int]bool)
+	var k int
+	for m > 0 && n > 0 {
+		if A.Seq[ref_pos_map[n-1]] != '*' {
+			if read[m-1] != ref[n-1] {
+				// bases are backed up one-to-one, not beyond known variants of different lengths
+				backup_num := A.Para.Ham_backup
+				if backup_num >= diag_num {
+					backup_num = diag_num
+				}
+				for i := 0; i < backup_num; i++ {
+					if _, is_var = var_pos_trace[n+i]; is_var {
+						var_pos = var_pos[:len(var_pos)-1]
+						var_base = var_base[:len(var_base)-1]
+						var_qual = var_qual[:len(var_qual)-1]
+						var_type = var_type[:len(var_type)-1]
+					}
+				}
+				m += backup_num
+				n += backup_num
+				break
+			}
+			if is_var = A.Sites.HasSite(ref_pos_map[n-1]); is_var {
+				var_pos_trace[n-1] = true
+				var_pos = append(var_pos, ref_pos_map[n-1])
+				var_base = append(var_base, arena.Bytes(ref[n-1], '|', read[m-1]))
+				var_qual = append(var_qual, arena.Bytes(qual[m-1]))
+				var_type = append(var_type, 0)
+			}
+			m--
+			n--
+			diag_num++
+		} else if var_len, is_same_len_var = A.SameLenVar[ref_pos_map[n-1]]; is_same_len_var {
+			min_p = math.MaxFloat64
+			for k, var_val = range A.Variants[ref_pos_map[n-1]] {
+				var_prob = float64(A.VarAF[ref_pos_map[n-1]][k])
+				if m >= var_len {
+					p = A.AlignCostVarLoci(read[m-var_len:m], var_val, qual[m-var_len:m], var_prob)
+					if min_p > p {
+						min_p = p
+					}
+				}
+			}
+			if min_p < math.MaxFloat64 {
+				aln_dist = aln_dist + min_p
+				var_pos_trace[n-1] = true
+				var_pos = append(var_pos, ref_pos_map[n-1])
+				v, q := arena.Alloc(2*var_len+1), arena.Alloc(var_len)
+				copy(v[:var_len], A.Variants[ref_pos_map[n-1]][0])
+				copy(v[var_len:var_len+1], []byte{'|'})
+				copy(v[var_len+1:], read[m-var_len:m])
+				copy(q, qual[m-var_len:m])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 0)
+				m -= var_len
+				n--
+				if var_len == 1 {
+					diag_num++
+				} else {
+					diag_num = 0
+				}
+			} else {
+				break
+			}
+		} else if p, var_len, var_val, var_q, var_t, is_ham_var = A.HamKnownLocus(read[:m], qual[:m], ref_pos_map[n-1], true, del_ref, arena); is_ham_var {
+			// Known variants of different lengths are aligned with the only allele matching the read
+			aln_dist = aln_dist + p
+			var_pos_trace[n-1] = true
+			var_pos = append(var_pos, ref_pos_map[n-1])
+			var_base = append(var_base, var_val)
+			var_qual = append(var_qual, var_q)
+			var_type = append(var_type, var_t)
+			m -= var_len
+			n--
+			diag_num = 0
+		} else {
+			// Bases next to known variants which are left to DP are also realigned by DP
+			backup_num := minInt(A.Para.Indel_backup, diag_num)
+			for i := 0; i < backup_num; i++ {
+				if _, is_var = var_pos_trace[n+i]; is_var {
+					var_pos = var_pos[:len(var_pos)-1]
+					var_base = var_base[:len(var_base)-1]
+					var_qual = var_qual[:len(var_qual)-1]
+					var_type = var_type[:len(var_type)-1]
+				}
+			}
+			m += backup_num
+			n += backup_num
+			break
+		}
+		if aln_dist > A.Para.Dist_thres {
+			return A.Para.Dist_thres + 1, m, n, var_pos, var_base, var_qual, var_type
+		}
+	}
+	if A.Trace != nil {
+		A.Trace.DisInfo("LeftAlnHam dis", m, n, aln_dist)
+	}
+	return aln_dist, m, n, var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// LeftAlignEdit fills the DP matrices of the edit part of LeftAlign (the first m bases of the read and
+// the first n bases of the ref, in backward direction) and returns the distance of the edit part and the
+// matrix to trace back from; it returns false if the fill is stopped by X-drop (aln_dist is the distance
+// of the Hamming part).
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) LeftAlignEdit(read, qual, ref []byte, m, n int, pos int, aln_dist float64, D, IS, IT [][]float64,
+	BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, drop_rows int, del_ref bool, cyc_cost []float64) (float64, int, bool) {
+
+	var var_len, k int
+	var var_val []byte
+	var var_prob float64
+
+	if A.Trace != nil {
+		A.Trace.EditDisInput("LeftAlnEdit: read, qual, ref", pos, read[:m], qual[:m], ref[:n])
+	}
+	/*
+		Backtrace info matrices:
+		BT_K[i][j][2]: represents number of shifted bases (equal to length of called variants) at known variant locations,
+			can be any integer number, e.g. 5 means back to i-5,j-1.
+		For each BT_x[i][j] (x can be D, IS, or IT):
+		BT_x[i][j][0]: represents direction to trace back to, can be 0: diagonal arrow (back to i-1,j-1), 1: up arrow (back to i-1,j),
+		 	2: left arrow (back to i,j-1).
+		BT_x[i][j][1]: represents matrix to trace back to, can be 0: trace back to matrix D, 1: trace back to matrix IS, 2: trace back to matrix IT.
+	*/
+	var i, j int
+	for i := 0; i <= m; i++ {
+		for j := 0; j <= n; j++ {
+			BT_K[i][j] = nil
+			BT_D[i][j][0], BT_D[i][j][1] = -1, -1
+			BT_IS[i][j][0], BT_IS[i][j][1] = -1, -1
+			BT_IT[i][j][0], BT_IT[i][j][1] = -1, -1
+		}
+	}
+
+	D[0][0] = 0.0
+	IS[0][0] = float64(math.MaxFloat32)
+	IT[0][0] = float64(math.MaxFloat32)
+	IS[1][0] = A.Para.Gap_open
+	BT_IS[1][0][0], BT_IS[1][0][1] = 1, 1
+
+	for i = 1; i <= m; i++ {
+		D[i][0] = float64(math.MaxFloat32)
+		IT[i][0] = float64(math.MaxFloat32)
+	}
+	for i = 2; i <= m; i++ {
+		IS[i][0] = A.Para.Gap_ext
+		BT_IS[i][0][0], BT_IS[i][0][1] = 1, 1
+	}
+
+	for j = 1; j <= n; j++ {
+		D[0][j] = float64(math.MaxFloat32)
+		IS[0][j] = float64(math.MaxFloat32)
+		IT[0][j] = 0.0
+		BT_IT[0][j][0], BT_IT[0][j][1] = 2, 2
+	}
+
+	var sel_var []byte
+	var prob_i, sub_i, mis_i float64
+	var is_del bool
+	// Gap open and substitution costs depend on reference context if context error model is used
+	gap_open, sub_cost := A.ContextCosts(ref_pos_map, n, func(j int) int { return j - 1 })
+	// Substitution costs also depend on sequencing cycles of read bases if per-cycle error model is used,
+	// and on the type of substitutions (transitions or transversions) if a Ts/Tv ratio is given
+	// X-drop: costs never decrease along alignment paths, so the fill stops early when minimum costs of
+	// drop_rows consecutive rows (known variants let paths skip rows) exceed the remaining threshold
+	drop_thres, high_rows := A.Para.Dist_thres-aln_dist, 0
+	var row_min, cyc_i float64
+	gap_j, sub_j := A.Para.Gap_open, A.Para.Sub_cost
+	for i = 1; i <= m; i++ {
+		row_min = float64(math.MaxFloat32)
+		if cyc_cost != nil {
+			cyc_i = cyc_cost[i-1]
+		}
+		for j = 1; j <= n; j++ {
+			// Cells outside the band around the diagonal of the seed (m, n) are not reached in banded alignment
+			if A.Para.Aln_band > 0 && absInt(j-i-n+m) > A.Para.Aln_band {
+				D[i][j], IS[i][j], IT[i][j] = float64(math.MaxFloat32), float64(math.MaxFloat32), float64(math.MaxFloat32)
+				continue
+			}
+			if gap_open != nil {
+				gap_j, sub_j = gap_open[j], sub_cost[j]
+			}
+			mis_i = sub_j + cyc_i // + Q2C[qual[i-1]]
+			if mis_i < 0 {
+				mis_i = 0
+			}
+			if A.Seq[ref_pos_map[j-1]] != '*' {
+				if read[i-1] == ref[j-1] {
+					sub_i = 0.0
+				} else if sub_i = mis_i + A.SubTypeCost(ref[j-1], read[i-1]); sub_i < 0 {
+					sub_i = 0.0
+				}
+				D[i][j] = D[i-1][j-1] + sub_i
+				BT_D[i][j][0], BT_D[i][j][1] = 0, 0
+				if D[i][j] > IS[i-1][j-1]+sub_i {
+					D[i][j] = IS[i-1][j-1] + sub_i
+					BT_D[i][j][0], BT_D[i][j][1] = 0, 1
+				}
+				if D[i][j] > IT[i-1][j-1]+sub_i {
+					D[i][j] = IT[i-1][j-1] + sub_i
+					BT_D[i][j][0], BT_D[i][j][1] = 0, 2
+				}
+
+				IS[i][j] = D[i-1][j] + gap_j
+				BT_IS[i][j][0], BT_IS[i][j][1] = 1, 0
+				if IS[i][j] > IS[i-1][j]+A.Para.Gap_ext {
+					IS[i][j] = IS[i-1][j] + A.Para.Gap_ext
+					BT_IS[i][j][0], BT_IS[i][j][1] = 1, 1
+				}
+
+				IT[i][j] = D[i][j-1] + gap_j
+				BT_IT[i][j][0], BT_IT[i][j][1] = 2, 0
+				if IT[i][j] > IT[i][j-1]+A.Para.Gap_ext {
+					IT[i][j] = IT[i][j-1] + A.Para.Gap_ext
+					BT_IT[i][j][0], BT_IT[i][j][1] = 2, 2
+				}
+			} else {
+				// Transitions at known variant loci: D from D, IS and IT through an allele, IS (insertions
+				// after the locus) as at other columns; the locus is not deleted as a whole (IT)
+				D[i][j] = float64(math.MaxFloat32)
+				IT[i][j] = float64(math.MaxFloat32)
+				sel_var = nil
+				for k, var_val = range A.Variants[ref_pos_map[j-1]] {
+					var_prob = float64(A.VarAF[ref_pos_map[j-1]][k])
+					var_len = len(var_val)
+					if i-var_len >= 0 {
+						if _, is_del = A.DelVar[ref_pos_map[j-1]]; is_del && del_ref {
+							prob_i = A.AlignCostVarLoci(read[i-var_len:i], var_val, qual[i-var_len:i], 1.0-var_prob)
+						} else {
+							prob_i = A.AlignCostVarLoci(read[i-var_len:i], var_val, qual[i-var_len:i], var_prob)
+						}
+						if D[i][j] > D[i-var_len][j-1]+prob_i {
+							D[i][j] = D[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 0
+							sel_var = var_val
+						}
+						if D[i][j] > IS[i-var_len][j-1]+prob_i {
+							D[i][j] = IS[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 1
+							sel_var = var_val
+						}
+						if D[i][j] > IT[i-var_len][j-1]+prob_i {
+							D[i][j] = IT[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 2
+							sel_var = var_val
+						}
+					}
+				}
+				if sel_var != nil {
+					BT_K[i][j] = sel_var
+				}
+				IS[i][j] = D[i-1][j] + gap_j
+				BT_IS[i][j][0], BT_IS[i][j][1] = 1, 0
+				if IS[i][j] > IS[i-1][j]+A.Para.Gap_ext {
+					IS[i][j] = IS[i-1][j] + A.Para.Gap_ext
+					BT_IS[i][j][0], BT_IS[i][j][1] = 1, 1
+				}
+			}
+			row_min = math.Min(row_min, math.Min(D[i][j], math.Min(IS[i][j], IT[i][j])))
+		}
+		if row_min <= drop_thres {
+			high_rows = 0
+		} else if high_rows++; high_rows >= drop_rows {
+			return 0, -1, false
+		}
+	}
+	if A.Trace != nil {
+		A.Trace.DisInfo("LeftAlnEditDist, D dis", m, n, D[m][n])
+		A.Trace.DisInfo("LeftAlnEditDist, IS dis", m, n, IS[m][n])
+		A.Trace.DisInfo("LeftAlnEditDist, IT dis", m, n, IT[m][n])
+
+		A.Trace.EditDisMat("LeftAlnEditDist, D mat", D, m, n, read[:m], ref[:n])
+		A.Trace.EditDisMat("LeftAlnEditDist, IS mat", IS, m, n, read[:m], ref[:n])
+		A.Trace.EditDisMat("LeftAlnEditDist, IT mat", IT, m, n, read[:m], ref[:n])
+
+		A.Trace.EditTraceMat("LeftAlnEditDist, D trace mat", BT_D, m, n)
+		A.Trace.EditTraceMat("LeftAlnEditDist, IS trace mat", BT_IS, m, n)
+		A.Trace.EditTraceMat("LeftAlnEditDist, IT trace mat", BT_IT, m, n)
+	}
+	min_dist := D[m][n]
+	bt_mat := 0
+	if min_dist > IS[m][n] {
+		min_dist = IS[m][n]
+		bt_mat = 1
+	}
+	if min_dist > IT[m][n] {
+		min_dist = IT[m][n]
+		bt_mat = 2
+	}
+
+	return min_dist, bt_mat, true
+}
+
+//-------------------------------------------------------------------------------------------------
+// LeftAlignEditTraceBack constructs alignment between a read and a ref from LeftAlign.
+// The read includes standard bases, the ref include standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) LeftAlignEditTraceBack(read, qual, ref []byte, m, n int, pos int,
+	BT_Mat int, BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, del_ref bool, arena *Arena) ([]int, [][]byte, [][]byte, []int) {
+
+	var var_len, ref_len int
+	var var_pos, var_type []int
+	var var_base, var_qual [][]byte
+	var is_same_len_var, is_del bool
+	if A.Trace != nil {
+		A.Trace.EditDisInput("LeftAlnEditTraceBack, read, qual, ref", pos, read[:m], qual[:m], ref[:n])
+	}
+	aln_read, aln_qual, aln_ref := arena.Alloc(m+n)[:0], arena.Alloc(m+n)[:0], arena.Alloc(m+n)[:0]
+	bt_mat := BT_Mat
+	i, j, k := m, n, 0
+	for i > 0 || j > 0 {
+		if j == 0 || A.Seq[ref_pos_map[j-1]] != '*' { //unknown VARIANT location
+			if bt_mat == 0 {
+				if read[i-1] != ref[j-1] {
+					var_pos = append(var_pos, ref_pos_map[j-1])
+					var_base = append(var_base, arena.Bytes(ref[j-1], '|', read[i-1]))
+					var_qual = append(var_qual, arena.Bytes(qual[i-1]))
+					var_type = append(var_type, 0)
+				}
+				aln_read = append(aln_read, read[i-1])
+				aln_qual = append(aln_qual, qual[i-1])
+				aln_ref = append(aln_ref, ref[j-1])
+				//GetEditTrace("0", i, j, read[i-1], ref[j-1])
+				bt_mat = BT_D[i][j][1]
+				i, j = i-1, j-1
+			} else if bt_mat == 1 {
+				aln_read = append(aln_read, read[i-1])
+				aln_qual = append(aln_qual, qual[i-1])
+				aln_ref = append(aln_ref, '-')
+				//GetEditTrace("1", i, j, read[i-1], '-')
+				bt_mat = BT_IS[i][j][1]
+				i--
+			} else if bt_mat == 2 {
+				aln_read = append(aln_read, '-')
+				aln_qual = append(aln_qual, '-')
+				aln_ref = append(aln_ref, ref[j-1])
+				//GetEditTrace("2", i, j, '-', ref[j-1])
+				bt_mat = BT_IT[i][j][1]
+				j--
+			}
+		} else { //known VARIANT location
+			if bt_mat == 0 {
+				if BT_K[i][j] != nil {
+					var_len = len(BT_K[i][j])
+					var_pos = append(var_pos, ref_pos_map[j-1])
+					ref_len = len(A.Variants[ref_pos_map[j-1]][0])
+					var v []byte
+					if _, is_del = A.DelVar[ref_pos_map[j-1]]; is_del && !del_ref { //known DEL with non-reduced ref
+						v = arena.Alloc(ref_len + ref_len + 1)
+						copy(v[:ref_len], A.Variants[ref_pos_map[j-1]][0])
+						copy(v[ref_len:ref_len+1], []byte{'|'})
+						copy(v[ref_len+1:], A.Variants[ref_pos_map[j-1]][0])
+					} else {
+						v = arena.Alloc(ref_len + var_len + 1)
+						copy(v[:ref_len], A.Variants[ref_pos_map[j-1]][0])
+						copy(v[ref_len:ref_len+1], []byte{'|'})
+						copy(v[ref_len+1:], BT_K[i][j])
+					}
+					var_base = append(var_base, v)
+					q := arena.Alloc(var_len)
+					copy(q, qual[i-var_len:i])
+					var_qual = append(var_qual, q)
+					if _, is_del = A.DelVar[ref_pos_map[j-1]]; is_del {
+						var_type = append(var_type, 2)
+					} else if _, is_same_len_var = A.SameLenVar[ref_pos_map[j-1]]; is_same_len_var {
+						var_type = append(var_type, 0)
+					} else {
+						var_type = append(var_type, 1)
+					}
+					for k = 0; k < var_len-1; k++ {
+						aln_read = append(aln_read, read[i-1-k])
+						aln_qual = append(aln_qual, qual[i-1-k])
+						aln_ref = append(aln_ref, '+')
+					}
+					aln_read = append(aln_read, read[i-var_len])
+					aln_qual = append(aln_qual, qual[i-var_len])
+					aln_ref = append(aln_ref, ref[j-1])
+					//GetEditTraceKnownLoc("3", i, j, read[i-var_len:i], ref[j-1])
+					bt_mat = BT_D[i][j][1]
+					i, j = i-var_len, j-1
+				} else {
+					aln_read = append(aln_read, '-')
+					aln_qual = append(aln_qual, '-')
+					aln_ref = append(aln_ref, ref[j-1])
+					//GetEditTraceKnownLoc("4", i, j, []byte{'-'}, ref[j-1])
+					bt_mat = BT_IT[i][j][1]
+					j--
+				}
+			} else if bt_mat == 1 {
+				aln_read = append(aln_read, read[i-1])
+				aln_qual = append(aln_qual, qual[i-1])
+				aln_ref = append(aln_ref, '-')
+				//GetEditTrace("1", i, j, read[i-1], '-')
+				bt_mat = BT_IS[i][j][1]
+				i--
+			} else {
+				aln_read = append(aln_read, '-')
+				aln_qual = append(aln_qual, '-')
+				aln_ref = append(aln_ref, ref[j-1])
+				//GetEditTraceKnownLoc("4", i, j, []byte{'-'}, ref[j-1])
+				bt_mat = BT_IT[i][j][1]
+				j--
+			}
+		}
+	}
+
+	//Put the alignment in original direction
+	for i, j = 0, len(aln_read)-1; i < j; i, j = i+1, j-1 {
+		aln_read[i], aln_read[j] = aln_read[j], aln_read[i]
+		aln_qual[i], aln_qual[j] = aln_qual[j], aln_qual[i]
+		aln_ref[i], aln_ref[j] = aln_ref[j], aln_ref[i]
+	}
+	if A.Trace != nil {
+		A.Trace.EditAlignInfo("LeftAlnEditTraceBack, aligned read/qual/ref", aln_read, aln_qual, aln_ref)
+	}
+	//Get variants
+	ref_ori_pos := 0
+	read_ori_pos := 0
+	i = 0
+	for i < len(aln_ref) {
+		if aln_read[i] == '-' && aln_ref[i] != '-' {
+			ref_ori_pos++
+			i++
+		} else if aln_read[i] != '-' && aln_ref[i] == '-' {
+			read_ori_pos++
+			i++
+		} else {
+			break
+		}
+	}
+	for i < len(aln_ref) {
+		if aln_read[i] != '-' && aln_ref[i] == '-' { //Insertions
+			v, q := arena.Alloc(len(aln_ref)-i+3)[:0], arena.Alloc(len(aln_ref)-i+1)[:0]
+			v = append(v, aln_ref[i-1])
+			v = append(v, '|')
+			v = append(v, aln_read[i-1])
+			q = append(q, aln_qual[i-1])
+			for j = i; j < len(aln_ref) && aln_ref[j] == '-'; j++ {
+				v = append(v, aln_read[j])
+				q = append(q, aln_qual[j])
+			}
+			if j < len(aln_ref)-1 && read_ori_pos > 1 {
+				var_pos = append(var_pos, ref_pos_map[ref_ori_pos-1])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 1)
+			}
+			read_ori_pos += j - i
+			i = j
+		} else if aln_read[i] == '-' && aln_ref[i] != '-' { //Deletions
+			v, q := arena.Alloc(len(aln_ref)-i+3)[:0], arena.Alloc(len(aln_ref)-i+1)[:0]
+			v = append(v, aln_ref[i-1])
+			for j = i; j < len(aln_read) && aln_read[j] == '-'; j++ {
+				v = append(v, aln_ref[j])
+			}
+			if j < len(aln_read)-1 && read_ori_pos < m-1 {
+				q = append(q, DelQual(aln_qual[i-1], aln_qual[j]))
+				var_pos = append(var_pos, ref_pos_map[ref_ori_pos-1])
+				v = append(v, '|')
+				v = append(v, aln_read[i-1])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 2)
+			}
+			ref_ori_pos += j - i
+			i = j
+		} else if aln_ref[i] == '+' {
+			read_ori_pos++
+			i++
+		} else {
+			if aln_read[i] == aln_ref[i] && i+1 < len(aln_read) && aln_read[i+1] != '-' && aln_ref[i+1] != '-' {
+				if ref_pos_map != nil {
+					if is_prof_new_var := A.Sites.HasSite(ref_pos_map[ref_ori_pos]); is_prof_new_var {
+						var_pos = append(var_pos, ref_pos_map[ref_ori_pos])
+						var_base = append(var_base, arena.Bytes(aln_ref[i], '|', aln_read[i]))
+						var_qual = append(var_qual, arena.Bytes(aln_qual[i]))
+						var_type = append(var_type, 0)
+					}
+				}
+			}
+			ref_ori_pos++
+			read_ori_pos++
+			i++
+		}
+	}
+	return var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// RightAlign calculates the distance between a read and a ref in forward direction.
+// The read includes standard bases, the ref includes standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) RightAlign(read, qual, ref []byte, pos int, D, IS, IT [][]float64,
+	BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, drop_rows int, del_ref bool, cyc_cost []float64, arena *Arena) (float64, float64,
+	int, int, int, []int, [][]byte, [][]byte, []int) {
+
+	aln_dist, m, n, var_pos, var_base, var_qual, var_type := A.RightAlignHam(read, qual, ref, pos, ref_pos_map, del_ref, arena)
+	if aln_dist > A.Para.Dist_thres || m == 0 || n == 0 {
+		return aln_dist, 0, -1, m, n, var_pos, var_base, var_qual, var_type
+	}
+	min_dist, bt_mat, ok := A.RightAlignEdit(read, qual, ref, m, n, pos, aln_dist, D, IS, IT, BT_D, BT_IS, BT_IT, BT_K, ref_pos_map, drop_rows, del_ref, cyc_cost)
+	if !ok {
+		return A.Para.Dist_thres + 1, 0, -1, m, n, var_pos, var_base, var_qual, var_type
+	}
+	return aln_dist, min_dist, bt_mat, m, n, var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// RightAlignHam aligns a read and a ref in forward direction one-to-one (Hamming part), known variant
+// loci are aligned with their alleles. It returns the distance, the lengths of the read and the ref
+// left to DP (edit part), and variants of the Hamming part; the distance is Para.Dist_thres + 1 if it
+// exceeds the threshold.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) RightAlignHam(read, qual, ref []byte, pos int, ref_pos_map []int, del_ref bool, arena *Arena) (float64,
+	int, int, []int, [][]byte, [][]byte, []int) {
+
+	var var_len, var_t int
+	var is_var, is_same_len_var, is_ham_var bool
+	var var_val, var_q []byte
+	var p, min_p, var_prob float64
+	var var_pos, var_type []int
+	var var_base, var_qual [][]byte
+	var k int
+
+	if A.Trace != nil {
+		A.Trace.EditDisInput("RightAlign input: read, qual, ref", pos, read, qual, ref)
+	}
+	aln_dist := 0.0
+	M, N := len(read), len(ref)
+	m, n := M, N
+	diag_num := 0 // number of bases aligned one-to-one since the last known variant locus
+	var_pos_trace := make(map[int]bool)
+	for m > 0 && n > 0 {
+		if A.Seq[ref_pos_map[N-n]] != '*' {
+			if read[M-m] != ref[N-n] {
+				// bases are backed up one-to-one, not beyond known variants of different lengths
+				backup_num := 2 * A.Para.Ham_backup
+				if backup_num >= diag_num {
+					backup_num = diag_num
+				}
+				for i := 0; i < backup_num; i++ {
+					if _, is_var = var_pos_trace[N-(n+i+1)]; is_var {
+						var_pos = var_pos[:len(var_pos)-1]
+						var_base = var_base[:len(var_base)-1]
+						var_qual = var_qual[:len(var_qual)-1]
+						var_type = var_type[:len(var_type)-1]
+					}
+				}
+				m += backup_num
+				n += backup_num
+				break
+			}
+			if is_var = A.Sites.HasSite(ref_pos_map[N-n]); is_var {
+				var_pos_trace[N-n] = true
+				var_pos = append(var_pos, ref_pos_map[N-n])
+				var_base = append(var_base, arena.Bytes(ref[N-n], '|', read[M-m]))
+				var_qual = append(var_qual, arena.Bytes(qual[M-m]))
+				var_type = append(var_type, 0)
+			}
+			m--
+			n--
+			diag_num++
+		} else if var_len, is_same_len_var = A.SameLenVar[ref_pos_map[N-n]]; is_same_len_var {
+			min_p = math.MaxFloat64
+			for k, var_val = range A.Variants[ref_pos_map[N-n]] {
+				var_prob = float64(A.VarAF[ref_pos_map[N-n]][k])
+				if m >= var_len {
+					p = A.AlignCostVarLoci(read[M-m:M-m+var_len], var_val, qual[M-m:M-m+var_len], var_prob)
+					if min_p > p {
+						min_p = p
+					}
+				}
+			}
+			if min_p < math.MaxFloat64 {
+				aln_dist = aln_dist + min_p
+				var_pos_trace[N-n] = true
+				var_pos = append(var_pos, ref_pos_map[N-n])
+				v, q := arena.Alloc(2*var_len+1), arena.Alloc(var_len)
+				copy(v[:var_len], A.Variants[ref_pos_map[N-n]][0])
+				copy(v[var_len:var_len+1], []byte{'|'})
+				copy(v[var_len+1:], read[M-m:M-(m-var_len)])
+				copy(q, qual[M-m:M-(m-var_len)])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 0)
+				m -= var_len
+				n--
+				if var_len == 1 {
+					diag_num++
+				} else {
+					diag_num = 0
+				}
+			} else {
+				break
+			}
+		} else if p, var_len, var_val, var_q, var_t, is_ham_var = A.HamKnownLocus(read[M-m:], qual[M-m:], ref_pos_map[N-n], false, del_ref, arena); is_ham_var {
+			// Known variants of different lengths are aligned with the only allele matching the read
+			aln_dist = aln_dist + p
+			var_pos_trace[N-n] = true
+			var_pos = append(var_pos, ref_pos_map[N-n])
+			var_base = append(var_base, var_val)
+			var_qual = append(var_qual, var_q)
+			var_type = append(var_type, var_t)
+			m -= var_len
+			n--
+			diag_num = 0
+		} else {
+			// Bases next to known variants which are left to DP are also realigned by DP
+			backup_num := minInt(A.Para.Indel_backup, diag_num)
+			for i := 0; i < backup_num; i++ {
+				if _, is_var = var_pos_trace[N-(n+i+1)]; is_var {
+					var_pos = var_pos[:len(var_pos)-1]
+					var_base = var_base[:len(var_base)-1]
+					var_qual = var_qual[:len(var_qual)-1]
+					var_type = var_type[:len(var_type)-1]
+				}
+			}
+			m += backup_num
+			n += backup_num
+			break
+		}
+		if aln_dist > A.Para.Dist_thres {
+			return A.Para.Dist_thres + 1, m, n, var_pos, var_base, var_qual, var_type
+		}
+	}
+	if A.Trace != nil {
+		A.Trace.DisInfo("RightAlnHam dis", m, n, aln_dist)
+	}
+	return aln_dist, m, n, var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// RightAlignEdit fills the DP matrices of the edit part of RightAlign (the last m bases of the read and
+// the last n bases of the ref, in forward direction) and returns the distance of the edit part and the
+// matrix to trace back from; it returns false if the fill is stopped by X-drop (aln_dist is the distance
+// of the Hamming part).
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) RightAlignEdit(read, qual, ref []byte, m, n int, pos int, aln_dist float64, D, IS, IT [][]float64,
+	BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, drop_rows int, del_ref bool, cyc_cost []float64) (float64, int, bool) {
+
+	var var_len, k int
+	var var_val []byte
+	var var_prob float64
+	M, N := len(read), len(ref)
+
+	if A.Trace != nil {
+		A.Trace.EditDisInput("RightAlnEdit: read, qual, ref", pos, read[M-m:M], qual[M-m:M], ref[N-n:N])
+	}
+	//	Backtrace info matrices:
+	//	BT_K[i][j]: represents number of shifted bases (equal to length of called variants) at known variant locations,
+	//		can be any integer number, e.g. 5 means back to i-5,j-1.
+	//  For each BT_x[i][j] (x can be D, IS, or IT):
+	//	BT_x[i][j][0]: represents direction to trace back to, can be 0: diagonal arrow (back to i-1,j-1), 1: up arrow (back to i-1,j),
+	//	 	2: left arrow (back to i,j-1).
+	//	BT_x[i][j][1]: represents matrix to trace back to, can be 0: trace back to matrix D, 1: trace back to matrix IS, 2: trace back to matrix IT.
+	var i, j int
+	for i := 0; i <= m; i++ {
+		for j := 0; j <= n; j++ {
+			BT_K[i][j] = nil
+			BT_D[i][j][0], BT_D[i][j][1] = -1, -1
+			BT_IS[i][j][0], BT_IS[i][j][1] = -1, -1
+			BT_IT[i][j][0], BT_IT[i][j][1] = -1, -1
+		}
+	}
+
+	D[0][0] = 0.0
+	for i = 1; i <= m; i++ {
+		D[i][0] = float64(math.MaxFloat32)
+		IT[i][0] = float64(math.MaxFloat32)
+	}
+	IS[0][0] = float64(math.MaxFloat32)
+	IS[1][0] = A.Para.Gap_open
+	BT_IS[1][0][0], BT_IS[1][0][1] = 1, 1
+	for i = 2; i <= m; i++ {
+		IS[i][0] = A.Para.Gap_ext
+		BT_IS[i][0][0], BT_IS[i][0][1] = 1, 1
+	}
+
+	IT[0][0] = float64(math.MaxFloat32)
+	for j = 1; j <= n; j++ {
+		D[0][j] = float64(math.MaxFloat32)
+		IS[0][j] = float64(math.MaxFloat32)
+		IT[0][j] = 0.0
+		BT_IT[0][j][0], BT_IT[0][j][1] = 2, 2
+	}
+
+	var sel_var []byte
+	var prob_i, sub_i, mis_i float64
+	var is_del bool
+	// Gap open and substitution costs depend on reference context if context error model is used
+	gap_open, sub_cost := A.ContextCosts(ref_pos_map, n, func(j int) int { return N - j })
+	// Substitution costs also depend on sequencing cycles of read bases if per-cycle error model is used,
+	// and on the type of substitutions (transitions or transversions) if a Ts/Tv ratio is given
+	// X-drop: costs never decrease along alignment paths, so the fill stops early when minimum costs of
+	// drop_rows consecutive rows (known variants let paths skip rows) exceed the remaining threshold
+	drop_thres, high_rows := A.Para.Dist_thres-aln_dist, 0
+	var row_min, cyc_i float64
+	gap_j, sub_j := A.Para.Gap_open, A.Para.Sub_cost
+	for i = 1; i <= m; i++ {
+		row_min = float64(math.MaxFloat32)
+		if cyc_cost != nil {
+			cyc_i = cyc_cost[M-i]
+		}
+		for j = 1; j <= n; j++ {
+			// Cells outside the band around the diagonal of the seed (m, n) are not reached in banded alignment
+			if A.Para.Aln_band > 0 && absInt(j-i-n+m) > A.Para.Aln_band {
+				D[i][j], IS[i][j], IT[i][j] = float64(math.MaxFloat32), float64(math.MaxFloat32), float64(math.MaxFloat32)
+				continue
+			}
+			if gap_open != nil {
+				gap_j, sub_j = gap_open[j], sub_cost[j]
+			}
+			mis_i = sub_j + cyc_i // + Q2C[qual[M-i]]
+			if mis_i < 0 {
+				mis_i = 0
+			}
+			if N-j < 0 || N-j >= len(ref_pos_map) {
+				panic("ref_pos_map index problem")
+			}
+			if ref_pos_map[N-j] < 0 || ref_pos_map[N-j] > len(A.Seq) {
+				panic("A.Seq index problem")
+			}
+			if A.Seq[ref_pos_map[N-j]] != '*' {
+				if read[M-i] == ref[N-j] {
+					sub_i = 0.0
+				} else if sub_i = mis_i + A.SubTypeCost(ref[N-j], read[M-i]); sub_i < 0 {
+					sub_i = 0.0
+				}
+				D[i][j] = IT[i-1][j-1] + sub_i
+				BT_D[i][j][0], BT_D[i][j][1] = 0, 2
+				if D[i][j] > IS[i-1][j-1]+sub_i {
+					D[i][j] = IS[i-1][j-1] + sub_i
+					BT_D[i][j][0], BT_D[i][j][1] = 0, 1
+				}
+				if D[i][j] > D[i-1][j-1]+sub_i {
+					D[i][j] = D[i-1][j-1] + sub_i
+					BT_D[i][j][0], BT_D[i][j][1] = 0, 0
+				}
+				IS[i][j] = D[i-1][j] + gap_j
+				BT_IS[i][j][0], BT_IS[i][j][1] = 1, 0
+				if IS[i][j] > IS[i-1][j]+A.Para.Gap_ext {
+					IS[i][j] = IS[i-1][j] + A.Para.Gap_ext
+					BT_IS[i][j][0], BT_IS[i][j][1] = 1, 1
+				}
+				IT[i][j] = D[i][j-1] + gap_j
+				BT_IT[i][j][0], BT_IT[i][j][1] = 2, 0
+				if IT[i][j] > IT[i][j-1]+A.Para.Gap_ext {
+					IT[i][j] = IT[i][j-1] + A.Para.Gap_ext
+					BT_IT[i][j][0], BT_IT[i][j][1] = 2, 2
+				}
+			} else {
+				// Transitions at known variant loci: D from D, IS and IT through an allele, IS (insertions
+				// after the locus) as at other columns; the locus is not deleted as a whole (IT)
+				D[i][j] = float64(math.MaxFloat32)
+				IT[i][j] = float64(math.MaxFloat32)
+				sel_var = nil
+				for k, var_val = range A.Variants[ref_pos_map[N-j]] {
+					var_prob = float64(A.VarAF[ref_pos_map[N-j]][k])
+					var_len = len(var_val)
+					if i-var_len >= 0 {
+						if _, is_del = A.DelVar[ref_pos_map[N-j]]; is_del && del_ref { //convert prob with reduced-ref for known DEL
+							prob_i = A.AlignCostVarLoci(read[M-i:M-i+var_len], var_val, qual[M-i:M-i+var_len], 1.0-var_prob)
+						} else {
+							prob_i = A.AlignCostVarLoci(read[M-i:M-i+var_len], var_val, qual[M-i:M-i+var_len], var_prob)
+						}
+						if D[i][j] > D[i-var_len][j-1]+prob_i {
+							D[i][j] = D[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 0
+							sel_var = var_val
+						}
+						if D[i][j] > IS[i-var_len][j-1]+prob_i {
+							D[i][j] = IS[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 1
+							sel_var = var_val
+						}
+						if D[i][j] > IT[i-var_len][j-1]+prob_i {
+							D[i][j] = IT[i-var_len][j-1] + prob_i
+							BT_D[i][j][0], BT_D[i][j][1] = 0, 2
+							sel_var = var_val
+						}
+					}
+				}
+				if sel_var != nil {
+					BT_K[i][j] = sel_var
+				}
+				IS[i][j] = D[i-1][j] + gap_j
+				BT_IS[i][j][0], BT_IS[i][j][1] = 1, 0
+				if IS[i][j] > IS[i-1][j]+A.Para.Gap_ext {
+					IS[i][j] = IS[i-1][j] + A.Para.Gap_ext
+					BT_IS[i][j][0], BT_IS[i][j][1] = 1, 1
+				}
+			}
+			row_min = math.Min(row_min, math.Min(D[i][j], math.Min(IS[i][j], IT[i][j])))
+		}
+		if row_min <= drop_thres {
+			high_rows = 0
+		} else if high_rows++; high_rows >= drop_rows {
+			return 0, -1, false
+		}
+	}
+	if A.Trace != nil {
+		A.Trace.DisInfo("RightAlnEditDist, D dis", m, n, D[m][n])
+		A.Trace.DisInfo("RightAlnEditDist, IS dis", m, n, IS[m][n])
+		A.Trace.DisInfo("RightAlnEditDist, IT dis", m, n, IT[m][n])
+
+		A.Trace.EditDisMat("RightAlnEditDist, D mat", D, m, n, read[M-m:M], ref[N-n:N])
+		A.Trace.EditDisMat("RightAlnEditDist, IS mat", IS, m, n, read[M-m:M], ref[N-n:N])
+		A.Trace.EditDisMat("RightAlnEditDist, IT mat", IT, m, n, read[M-m:M], ref[N-n:N])
+
+		A.Trace.EditTraceMat("RightAlnEditDist, D trace mat", BT_D, m, n)
+		A.Trace.EditTraceMat("RightAlnEditDist, IS trace mat", BT_IS, m, n)
+		A.Trace.EditTraceMat("RightAlnEditDist, IT trace mat", BT_IT, m, n)
+	}
+	min_dist := D[m][n]
+	bt_mat := 0
+	if min_dist > IS[m][n] {
+		min_dist = IS[m][n]
+		bt_mat = 1
+	}
+	if min_dist > IT[m][n] {
+		min_dist = IT[m][n]
+		bt_mat = 2
+	}
+	return min_dist, bt_mat, true
+}
+
+//-------------------------------------------------------------------------------------------------
+// RightAlignEditTraceBack constructs alignment between a read and a ref from RightAlign.
+// The read includes standard bases, the ref include standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) RightAlignEditTraceBack(read, qual, ref []byte, m, n int, pos int,
+	BT_Mat int, BT_D, BT_IS, BT_IT [][][]int, BT_K [][][]byte, ref_pos_map []int, del_ref bool, arena *Arena) ([]int, [][]byte, [][]byte, []int) {
+
+	if A.Trace != nil {
+		A.Trace.EditDisInput("RightAlnEditTraceBack, read, qual, ref", pos, read, qual, ref)
+	}
+	var var_len, ref_len int
+	var var_pos, var_type []int
+	var var_base, var_qual [][]byte
+	var is_same_len_var, is_del bool
+
+	aln_read, aln_qual, aln_ref := arena.Alloc(m+n)[:0], arena.Alloc(m+n)[:0], arena.Alloc(m+n)[:0]
+	M, N := len(read), len(ref)
+	bt_mat := BT_Mat
+	i, j, k := m, n, 0
+	for i > 0 || j > 0 {
+		if j == 0 || A.Seq[ref_pos_map[N-j]] != '*' { //unknown VARIANT location
+			if bt_mat == 0 {
+				if read[M-i] != ref[N-j] {
+					var_pos = append(var_pos, ref_pos_map[N-j])
+					var_base = append(var_base, arena.Bytes(ref[N-j], '|', read[M-i]))
+					var_qual = append(var_qual, arena.Bytes(qual[M-i]))
+					var_type = append(var_type, 0)
+				}
+				aln_read = append(aln_read, read[M-i])
+				aln_qual = append(aln_qual, qual[M-i])
+				aln_ref = append(aln_ref, ref[N-j])
+				//GetEditTrace("0", M-i, N-j, read[M-i], ref[N-j])
+				bt_mat = BT_D[i][j][1]
+				i, j = i-1, j-1
+			} else if bt_mat == 1 {
+				aln_read = append(aln_read, read[M-i])
+				aln_qual = append(aln_qual, qual[M-i])
+				aln_ref = append(aln_ref, '-')
+				//GetEditTrace("1", M-i, N-j, read[M-i], '-')
+				bt_mat = BT_IS[i][j][1]
+				i--
+			} else if bt_mat == 2 {
+				aln_read = append(aln_read, '-')
+				aln_qual = append(aln_qual, '-')
+				aln_ref = append(aln_ref, ref[N-j])
+				//GetEditTrace("2", M-i, N-j, '-', ref[N-j])
+				bt_mat = BT_IT[i][j][1]
+				j--
+			}
+		} else { //known VARIANT location
+			if bt_mat == 0 {
+				if BT_K[i][j] != nil {
+					var_len = len(BT_K[i][j])
+					var_pos = append(var_pos, ref_pos_map[N-j])
+					ref_len = len(A.Variants[ref_pos_map[N-j]][0])
+					var v []byte
+					if _, is_del = A.DelVar[ref_pos_map[N-j]]; is_del && !del_ref { //known DEL with non-reduced ref
+						v = arena.Alloc(ref_len + ref_len + 1)
+						copy(v[:ref_len], A.Variants[ref_pos_map[N-j]][0])
+						copy(v[ref_len:ref_len+1], []byte{'|'})
+						copy(v[ref_len+1:], A.Variants[ref_pos_map[N-j]][0])
+					} else {
+						v = arena.Alloc(ref_len + var_len + 1)
+						copy(v[:ref_len], A.Variants[ref_pos_map[N-j]][0])
+						copy(v[ref_len:ref_len+1], []byte{'|'})
+						copy(v[ref_len+1:], BT_K[i][j])
+					}
+					var_base = append(var_base, v)
+					q := arena.Alloc(var_len)
+					copy(q, qual[M-i:M-(i-var_len)])
+					var_qual = append(var_qual, q)
+					if _, is_del = A.DelVar[ref_pos_map[N-j]]; is_del {
+						var_type = append(var_type, 2)
+					} else if _, is_same_len_var = A.SameLenVar[ref_pos_map[N-j]]; is_same_len_var {
+						var_type = append(var_type, 0)
+					} else {
+						var_type = append(var_type, 1)
+					}
+					aln_read = append(aln_read, read[M-i])
+					aln_qual = append(aln_qual, qual[M-i])
+					aln_ref = append(aln_ref, ref[N-j])
+					for k = 1; k < var_len; k++ {
+						aln_read = append(aln_read, read[M-i+k])
+						aln_qual = append(aln_qual, qual[M-i+k])
+						aln_ref = append(aln_ref, '+')
+					}
+					//GetEditTraceKnownLoc("3", M-i, N-j, read[M-i:M-i+var_len], ref[N-j])
+					bt_mat = BT_D[i][j][1]
+					i, j = i-var_len, j-1
+				} else {
+					aln_read = append(aln_read, '-')
+					aln_qual = append(aln_qual, '-')
+					aln_ref = append(aln_ref, ref[N-j])
+					//GetEditTrace("4", M-i, N-j, '-', ref[N-j])
+					bt_mat = BT_IT[i][j][1]
+					j--
+				}
+			} else if bt_mat == 1 {
+				aln_read = append(aln_read, read[M-i])
+				aln_qual = append(aln_qual, qual[M-i])
+				aln_ref = append(aln_ref, '-')
+				//GetEditTrace("1", M-i, N-j, read[M-i], '-')
+				bt_mat = BT_IS[i][j][1]
+				i--
+			} else {
+				aln_read = append(aln_read, '-')
+				aln_qual = append(aln_qual, '-')
+				aln_ref = append(aln_ref, ref[N-j])
+				//GetEditTrace("4", M-i, N-j, '-', ref[N-j])
+				bt_mat = BT_IT[i][j][1]
+				j--
+			}
+		}
+	}
+	if A.Trace != nil {
+		A.Trace.EditAlignInfo("RightAlnEditTraceBack, aligned read/qual/ref", aln_read, aln_qual, aln_ref)
+	}
+	//Get variants
+	ref_ori_pos := N - n
+	read_ori_pos := M - m
+	i = 0
+	for i < len(aln_ref) {
+		if aln_read[i] == '-' && aln_ref[i] != '-' {
+			ref_ori_pos++
+			i++
+		} else if aln_read[i] != '-' && aln_ref[i] == '-' {
+			read_ori_pos++
+			i++
+		} else {
+			break
+		}
+	}
+	for i < len(aln_ref) {
+		if aln_read[i] != '-' && aln_ref[i] == '-' { //Insertions
+			v, q := arena.Alloc(len(aln_ref)-i+3)[:0], arena.Alloc(len(aln_ref)-i+1)[:0]
+			v = append(v, aln_ref[i-1])
+			v = append(v, '|')
+			v = append(v, aln_read[i-1])
+			q = append(q, aln_qual[i-1])
+			for j = i; j < len(aln_ref) && aln_ref[j] == '-'; j++ {
+				v = append(v, aln_read[j])
+				q = append(q, aln_qual[j])
+			}
+			if j < len(aln_ref)-1 && read_ori_pos+j-i < M-1 && read_ori_pos > M-m+1 {
+				var_pos = append(var_pos, ref_pos_map[ref_ori_pos-1])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 1)
+			}
+			read_ori_pos += j - i
+			i = j
+		} else if aln_read[i] == '-' && aln_ref[i] != '-' { //Deletions
+			v, q := arena.Alloc(len(aln_ref)-i+3)[:0], arena.Alloc(len(aln_ref)-i+1)[:0]
+			v = append(v, aln_ref[i-1])
+			for j = i; j < len(aln_read) && aln_read[j] == '-'; j++ {
+				v = append(v, aln_ref[j])
+			}
+			if j < len(aln_read)-1 && read_ori_pos < M-1 && read_ori_pos > M-m+1 {
+				q = append(q, DelQual(aln_qual[i-1], aln_qual[j]))
+				var_pos = append(var_pos, ref_pos_map[ref_ori_pos-1])
+				v = append(v, '|')
+				v = append(v, aln_read[i-1])
+				var_base = append(var_base, v)
+				var_qual = append(var_qual, q)
+				var_type = append(var_type, 2)
+			}
+			ref_ori_pos += j - i
+			i = j
+		} else if aln_ref[i] == '+' {
+			read_ori_pos++
+			i++
+		} else {
+			if aln_read[i] == aln_ref[i] && i+1 < len(aln_read) && aln_read[i+1] != '-' && aln_ref[i+1] != '-' {
+				if ref_pos_map != nil {
+					if is_prof_new_var := A.Sites.HasSite(ref_pos_map[ref_ori_pos]); is_prof_new_var {
+						var_pos = append(var_pos, ref_pos_map[ref_ori_pos])
+						var_base = append(var_base, arena.Bytes(aln_ref[i], '|', aln_read[i]))
+						var_qual = append(var_qual, arena.Bytes(aln_qual[i]))
+						var_type = append(var_type, 0)
+					}
+				}
+			}
+			ref_ori_pos++
+			read_ori_pos++
+			i++
+		}
+	}
+	return var_pos, var_base, var_qual, var_type
+}
+
+//-------------------------------------------------------------------------------------------------
+// DiagonalMatch aligns a read to the reference without extension on the diagonal of its seed, allowing
+// known SNPs and, if with_mis is true, mismatches (no-gaps mode). Reads near known indels or other known
+// variants are left to the extension. It returns evidence at known SNP loci, mismatches and candidate
+// variant positions (positions, bases, qualities and indexes of the read bases), the alignment
+// distance, and whether the read is aligned.
+//-------------------------------------------------------------------------------------------------
+func (A *Aligner) DiagonalMatch(s_pos, m_pos int, read, qual []byte, cyc_cost []float64, with_mis bool, arena *Arena) ([]int, [][]byte, [][]byte,
+	[]int, float64, bool) {
+
+	start := m_pos - s_pos
+	if start-A.Para.Indel_backup < 0 || start+len(read)+A.Para.Indel_backup > A.SeqLen {
+		return nil, nil, nil, nil, 0, false
+	}
+	for pos := start - A.Para.Indel_backup; pos < start+len(read)+A.Para.Indel_backup; pos++ {
+		if A.Seq[pos] == '*' {
+			if var_len, is_snp := A.SameLenVar[pos]; !is_snp || var_len != 1 {
+				return nil, nil, nil, nil, 0, false
+			}
+		}
+	}
+	var var_pos, read_pos []int
+	var var_base, var_qual [][]byte
+	aln_dist := 0.0
+	for i, pos := 0, start; i < len(read); i, pos = i+1, pos+1 {
+		ref_base := A.Seq[pos]
+		if ref_base == '*' {
+			min_p := math.MaxFloat64
+			for k, var_val := range A.Variants[pos] {
+				if var_val[0] == read[i] || with_mis {
+					min_p = math.Min(min_p, A.AlignCostVarLoci(read[i:i+1], var_val, qual[i:i+1], float64(A.VarAF[pos][k])))
+				}
+			}
+			if min_p == math.MaxFloat64 {
+				return nil, nil, nil, nil, 0, false
+			}
+			aln_dist += min_p
+			ref_base = A.Variants[pos][0][0]
+		} else if read[i] != ref_base {
+			if !with_mis {
+				return nil, nil, nil, nil, 0, false
+			}
+			mis_cost := A.Para.Sub_cost
+			if A.Context != nil {
+				mis_cost = A.Context.ContextSubCost(pos)
+			}
+			if cyc_cost != nil {
+				mis_cost += cyc_cost[i]
+			}
+			aln_dist += math.Max(0, mis_cost+A.SubTypeCost(ref_base, read[i]))
+		} else if is_var := A.Sites.HasSite(pos); !is_var {
+			continue
+		}
+		if aln_dist > A.Para.Dist_thres {
+			return nil, nil, nil, nil, 0, false
+		}
+		var_pos = append(var_pos, pos)
+		var_base = append(var_base, arena.Bytes(ref_base, '|', read[i]))
+		var_qual = append(var_qual, arena.Bytes(qual[i]))
+		read_pos = append(read_pos, i)
+	}
+	return var_pos, var_base, var_qual, read_pos, aln_dist, true
+}
+
+//-------------------------------------------------------------------------------------------------
+// XDropRows returns the number of consecutive rows of alignment matrices whose minimum costs must
+// exceed the threshold to stop the fill: known variants of length L let alignment paths skip L-1 rows.
+//-------------------------------------------------------------------------------------------------
+func (G *Genome) XDropRows(ref_pos_map []int) int {
+	rows := 1
+	for _, pos := range ref_pos_map {
+		if pos >= 0 && pos < G.SeqLen && G.Seq[pos] == '*' {
+			for _, var_val := range G.Variants[pos] {
+				rows = maxInt(rows, len(var_val))
+			}
+		}
+	}
+	return rows
+}
@@ -0,0 +1,66 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: align/arena.go
+// Per-goroutine arenas of per-read temporaries. Bases and qualities of variants, aligned read/qual/ref
+// buffers of tracebacks and copies of SNP bases are allocated from slabs of the arena of the aligning
+// goroutine, which is reset for each read, instead of being allocated on the heap one by one.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package align
+
+const ARENA_SLAB_SIZE = 64 * 1024 // size of slabs of arenas (larger allocations get their own slabs)
+
+//---------------------------------------------------------------------------------------------------
+// Arena represents slabs of bytes allocated one after another until the arena is reset, slabs are kept
+// for reuse after resets. Methods of a nil Arena allocate on the heap, so that functions using arenas
+// can also be called without one.
+//---------------------------------------------------------------------------------------------------
+type Arena struct {
+	slabs [][]byte // slabs of the arena
+	cur   int      // index of the slab in use
+	off   int      // number of used bytes of the slab in use
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewArena creates an arena with one slab.
+//---------------------------------------------------------------------------------------------------
+func NewArena() *Arena {
+	return &Arena{slabs: [][]byte{make([]byte, ARENA_SLAB_SIZE)}}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Alloc returns a slice of n bytes (with capacity n, so that appending to it never overwrites other
+// allocations). Bytes are not cleared, they must be set by the caller.
+//---------------------------------------------------------------------------------------------------
+func (A *Arena) Alloc(n int) []byte {
+	if A == nil {
+		return make([]byte, n)
+	}
+	for A.off+n > len(A.slabs[A.cur]) {
+		A.cur, A.off = A.cur+1, 0
+		if A.cur == len(A.slabs) {
+			A.slabs = append(A.slabs, make([]byte, maxInt(n, ARENA_SLAB_SIZE)))
+		}
+	}
+	b := A.slabs[A.cur][A.off : A.off+n : A.off+n]
+	A.off += n
+	return b
+}
+
+//---------------------------------------------------------------------------------------------------
+// Bytes returns a copy of bases allocated from the arena.
+//---------------------------------------------------------------------------------------------------
+func (A *Arena) Bytes(bases ...byte) []byte {
+	b := A.Alloc(len(bases))
+	copy(b, bases)
+	return b
+}
+
+//---------------------------------------------------------------------------------------------------
+// Reset frees all allocations of the arena, slices allocated before must not be used after it.
+//---------------------------------------------------------------------------------------------------
+func (A *Arena) Reset() {
+	if A != nil {
+		A.cur, A.off = 0, 0
+	}
+}
@@ -0,0 +1,80 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: aligner.go
+// Aligners of reads to the multigenome of a variant caller (see package align). Parameters of alignment
+// are taken from the parameters of the run, positions of candidate variants from the variant calls, and
+// costs from the context error model if it is used. Each aligning goroutine creates its aligner once.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import "github.com/namsyvo/IVC/align"
+
+//---------------------------------------------------------------------------------------------------
+// AlnPara returns parameters of alignment of the run.
+//---------------------------------------------------------------------------------------------------
+func AlnPara() align.Params {
+	return align.Params{Dist_thres: PARA.Dist_thres, Sub_cost: PARA.Sub_cost, Gap_open: PARA.Gap_open, Gap_ext: PARA.Gap_ext,
+		Ts_cost: TS_COST, Tv_cost: TV_COST, Indel_err_rate: INDEL_ERR_RATE, Ham_backup: PARA.Ham_backup,
+		Indel_backup: PARA.Indel_backup, Aln_band: PARA.Aln_band}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Genome returns the multigenome of a variant caller.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) Genome() *align.Genome {
+	return &align.Genome{Seq: VC.Seq, SeqLen: VC.SeqLen, Variants: VC.Variants, VarAF: VC.VarAF, SameLenVar: VC.SameLenVar,
+		DelVar: VC.DelVar}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Aligner creates an aligner of reads to the multigenome with parameters of the run.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) Aligner() *align.Aligner {
+	A := align.New(AlnPara(), *VC.Genome(), VC)
+	if CONTEXT != nil {
+		A.Context = VC
+	}
+	if PARA.Debug_mode {
+		A.Trace = EditTracer{}
+	}
+	return A
+}
+
+//---------------------------------------------------------------------------------------------------
+// HasSite checks if a position of the multigenome is a candidate variant position. Candidate positions
+// of each shard are added under the lock of the shard (see UpdateVariantProb), so that lookups only
+// wait for updates of the same shard.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HasSite(pos int) bool {
+	calls := VC.Calls[PARA.Proc_num*pos/VC.SeqLen]
+	calls.mut.RLock()
+	is_var := calls.Sites.Get(uint32(pos)) != nil
+	calls.mut.RUnlock()
+	return is_var
+}
+
+//---------------------------------------------------------------------------------------------------
+// EditTracer prints inputs, matrices and results of alignments in debug mode.
+//---------------------------------------------------------------------------------------------------
+type EditTracer struct{}
+
+func (EditTracer) EditDisInput(mess string, pos int, str_val ...[]byte) {
+	PrintEditDisInput(mess, pos, str_val...)
+}
+
+func (EditTracer) DisInfo(mess string, i, j int, d float64) {
+	PrintDisInfo(mess, i, j, d)
+}
+
+func (EditTracer) EditDisMat(mess string, D [][]float64, m, n int, read, ref []byte) {
+	PrintEditDisMat(mess, D, m, n, read, ref)
+}
+
+func (EditTracer) EditTraceMat(mess string, BT [][][]int, m, n int) {
+	PrintEditTraceMat(mess, BT, m, n)
+}
+
+func (EditTracer) EditAlignInfo(mess string, aligned_read, aligned_qual, aligned_ref []byte) {
+	PrintEditAlignInfo(mess, aligned_read, aligned_qual, aligned_ref)
+}
@@ -0,0 +1,94 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: altaln.go
+// Alternative alignment positions of reads. Alignment candidates of a read pair whose paired distances
+// are within a given delta of the best one are kept and reported with the evidence of the read as an
+// XA-style list, so that calls in paralogous regions can be audited.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum number of reported alternative alignments of a read
+const ALT_ALN_MAX = 5
+
+//---------------------------------------------------------------------------------------------------
+// AltAln represents an alignment candidate of a paired-end read and its paired distance.
+//---------------------------------------------------------------------------------------------------
+type AltAln struct {
+	CovStart1, CovStart2 int     // start positions of the two aligned ends
+	Strand1, Strand2     bool    // strands of the two aligned ends
+	Dist                 float64 // paired distance of the alignment
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddAltAln adds an alignment candidate, a candidate at the same positions as a previous one (e.g. found
+// again in later iterations) only updates its distance if it is smaller.
+//---------------------------------------------------------------------------------------------------
+func AddAltAln(alns []*AltAln, cand *AltAln) []*AltAln {
+	for _, a := range alns {
+		if a.CovStart1 == cand.CovStart1 && a.CovStart2 == cand.CovStart2 {
+			if cand.Dist < a.Dist {
+				a.Dist = cand.Dist
+			}
+			return alns
+		}
+	}
+	return append(alns, cand)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AltAlnString returns alternative alignments of a read (other than the one at cov_start1, cov_start2)
+// whose paired distances are within PARA.Alt_delta of the best distance, ordered by distances, as
+// "chr,±pos1,±pos2,dist;" entries (1-based start positions of the two ends with their strands).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AltAlnString(alns []*AltAln, best_dist float64, cov_start1, cov_start2 int) string {
+	var alts []*AltAln
+	for _, a := range alns {
+		if a.Dist <= best_dist+PARA.Alt_delta && (a.CovStart1 != cov_start1 || a.CovStart2 != cov_start2) {
+			alts = append(alts, a)
+		}
+	}
+	sort.SliceStable(alts, func(i, j int) bool { return alts[i].Dist < alts[j].Dist })
+	if len(alts) > ALT_ALN_MAX {
+		alts = alts[:ALT_ALN_MAX]
+	}
+	strand_pos := func(pos int, strand bool) string {
+		if strand {
+			return "+" + strconv.Itoa(pos)
+		}
+		return "-" + strconv.Itoa(pos)
+	}
+	str := make([]string, len(alts))
+	for i, a := range alts {
+		chr_name, pos1 := VC.ChrLoc(a.CovStart1)
+		_, pos2 := VC.ChrLoc(a.CovStart2)
+		str[i] = chr_name + "," + strand_pos(pos1, a.Strand1) + "," + strand_pos(pos2, a.Strand2) + "," +
+			strconv.FormatFloat(a.Dist, 'f', 2, 64) + ";"
+	}
+	return strings.Join(str, "")
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetAltAln records alternative alignments of a read with its evidence.
+//---------------------------------------------------------------------------------------------------
+func SetAltAln(vars []*VarInfo, alt_aln string) {
+	for _, v := range vars {
+		v.AltAln = alt_aln
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AltAlnField returns the field of alternative alignments in the evidence file ("." if there are none).
+//---------------------------------------------------------------------------------------------------
+func AltAlnField(alt_aln string) string {
+	if alt_aln == "" {
+		return "."
+	}
+	return alt_aln
+}
@@ -0,0 +1,205 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: amplicon.go
+// Targeted amplicon mode. Primers are given in a BED file (chrom, start, end, name[, score, strand]);
+// names of primers of an amplicon share a prefix followed by _LEFT or _RIGHT (e.g. nCoV_1_LEFT,
+// nCoV_1_RIGHT, as in ARTIC primer schemes), or strands are given in the sixth column. After alignment,
+// each read pair is assigned to the amplicon which its fragment overlaps most, and bases of the read
+// ends within the primers of that amplicon are soft-clipped (variants at primer positions are not used
+// as evidence, since they come from primer sequences rather than the sample); evidence is restricted to
+// the insert of the amplicon (between its left and right primers). Primers of other amplicons which
+// overlap the insert (as in tiled schemes) do not clip the read pair.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// Amplicon represents an amplicon on the multigenome: its left primers span [Start, LeftEnd) and its
+// right primers span [RightStart, End), the insert is [LeftEnd, RightStart).
+//---------------------------------------------------------------------------------------------------
+type Amplicon struct {
+	Start, LeftEnd, RightStart, End int
+}
+
+//---------------------------------------------------------------------------------------------------
+// AmpliconInfo represents amplicons of a targeted panel, sorted by start.
+//---------------------------------------------------------------------------------------------------
+type AmpliconInfo struct {
+	Amplicons []Amplicon // amplicons sorted by start
+	MaxLen    int        // maximum length of amplicons
+}
+
+// Amplicons of a targeted panel (nil: amplicon mode is off)
+var AMPLICONS *AmpliconInfo
+
+//---------------------------------------------------------------------------------------------------
+// LoadAmplicons reads a primer BED file and determines primer and insert intervals on the multigenome.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) LoadAmplicons(file_name string) *AmpliconInfo {
+	f, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	chr_idx := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		chr_idx[string(chr_name)] = i
+	}
+	A := new(AmpliconInfo)
+	left, right := make(map[string][2]int), make(map[string][2]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 4 {
+			log.Panicf("Error: invalid line in primer file %s (chrom, start, end and name are required): %s", file_name, line)
+		}
+		chr_id, ok := chr_idx[tokens[0]]
+		if !ok {
+			log.Panicf("Error: unknown chromosome %s in primer file %s", tokens[0], file_name)
+		}
+		start, e1 := strconv.Atoi(tokens[1])
+		end, e2 := strconv.Atoi(tokens[2])
+		if e1 != nil || e2 != nil || start < 0 || end <= start {
+			log.Panicf("Error: invalid interval in primer file %s: %s", file_name, line)
+		}
+		start, end = VC.ChrPos[chr_id]+start, VC.ChrPos[chr_id]+end
+		// Alternative primers of an amplicon extend the span of its left or right primers
+		name, strand := PrimerAmplicon(tokens)
+		if strand == "+" {
+			left[name] = SpanPrimer(left, name, start, end)
+		} else if strand == "-" {
+			right[name] = SpanPrimer(right, name, start, end)
+		}
+	}
+	if e = scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	for name, l := range left {
+		if r, ok := right[name]; ok && l[1] < r[0] {
+			A.Amplicons = append(A.Amplicons, Amplicon{l[0], l[1], r[0], r[1]})
+			A.MaxLen = MaxInt(A.MaxLen, r[1]-l[0])
+		}
+	}
+	if len(A.Amplicons) == 0 {
+		log.Panicf("Error: no amplicons (pairs of left and right primers) in primer file %s", file_name)
+	}
+	sort.Slice(A.Amplicons, func(i, j int) bool {
+		if A.Amplicons[i].Start != A.Amplicons[j].Start {
+			return A.Amplicons[i].Start < A.Amplicons[j].Start
+		}
+		return A.Amplicons[i].End < A.Amplicons[j].End
+	})
+	log.Printf("Number of amplicons:\t%d", len(A.Amplicons))
+	return A
+}
+
+//---------------------------------------------------------------------------------------------------
+// SpanPrimer returns the span of primers of an amplicon on one side extended by a primer [start, end).
+//---------------------------------------------------------------------------------------------------
+func SpanPrimer(spans map[string][2]int, name string, start, end int) [2]int {
+	if s, ok := spans[name]; ok {
+		return [2]int{MinInt(s[0], start), MaxInt(s[1], end)}
+	}
+	return [2]int{start, end}
+}
+
+//---------------------------------------------------------------------------------------------------
+// PrimerAmplicon returns name of the amplicon and strand ("+" for left primers, "-" for right primers,
+// "" if unknown) of a primer from tokens of its BED line.
+//---------------------------------------------------------------------------------------------------
+func PrimerAmplicon(tokens []string) (string, string) {
+	name, strand := tokens[3], ""
+	if len(tokens) >= 6 {
+		strand = tokens[5]
+	}
+	upper := strings.ToUpper(name)
+	if i := strings.LastIndex(upper, "_LEFT"); i > 0 {
+		return name[:i], "+"
+	}
+	if i := strings.LastIndex(upper, "_RIGHT"); i > 0 {
+		return name[:i], "-"
+	}
+	return name, strand
+}
+
+//---------------------------------------------------------------------------------------------------
+// MergeIntervals sorts intervals and merges overlapping ones.
+//---------------------------------------------------------------------------------------------------
+func MergeIntervals(ivs [][2]int) [][2]int {
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i][0] < ivs[j][0] })
+	merged := make([][2]int, 0, len(ivs))
+	for _, iv := range ivs {
+		if n := len(merged); n > 0 && iv[0] <= merged[n-1][1] {
+			merged[n-1][1] = MaxInt(merged[n-1][1], iv[1])
+		} else {
+			merged = append(merged, iv)
+		}
+	}
+	return merged
+}
+
+//---------------------------------------------------------------------------------------------------
+// InIntervals determines whether a position is inside sorted and merged intervals.
+//---------------------------------------------------------------------------------------------------
+func InIntervals(ivs [][2]int, pos int) bool {
+	i := sort.Search(len(ivs), func(i int) bool { return ivs[i][1] > pos })
+	return i < len(ivs) && ivs[i][0] <= pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// Assign returns the index of the amplicon of a fragment [start, end): the amplicon overlapping the
+// fragment most, with ties broken by the distance between ends of the fragment and of the amplicon
+// (primers at both ends of a fragment identify its amplicon); -1 if the fragment overlaps no amplicon.
+//---------------------------------------------------------------------------------------------------
+func (A *AmpliconInfo) Assign(start, end int) int {
+	best, best_ovl, best_dist := -1, 0, 0
+	i := sort.Search(len(A.Amplicons), func(i int) bool { return A.Amplicons[i].Start >= end })
+	for j := i - 1; j >= 0 && A.Amplicons[j].Start+A.MaxLen > start; j-- {
+		amp := A.Amplicons[j]
+		ovl := MinInt(end, amp.End) - MaxInt(start, amp.Start)
+		dist := AbsInt(start-amp.Start) + AbsInt(end-amp.End)
+		if ovl > 0 && (ovl > best_ovl || ovl == best_ovl && dist < best_dist) {
+			best, best_ovl, best_dist = j, ovl, dist
+		}
+	}
+	return best
+}
+
+//---------------------------------------------------------------------------------------------------
+// Trim assigns an aligned read pair (read-ends starting at start1 and start2, of lengths len1 and len2)
+// to its amplicon, and removes variants of the read-ends within primers of the amplicon (soft-clipping
+// primer bases at the ends of the fragment) and outside its insert. Variants of read pairs which
+// overlap no amplicon are all removed.
+//---------------------------------------------------------------------------------------------------
+func (A *AmpliconInfo) Trim(vars1, vars2 []*VarInfo, start1, len1, start2, len2 int) ([]*VarInfo, []*VarInfo) {
+	i := A.Assign(MinInt(start1, start2), MaxInt(start1+len1, start2+len2))
+	if i < 0 {
+		return vars1[:0], vars2[:0]
+	}
+	return A.Amplicons[i].Trim(vars1), A.Amplicons[i].Trim(vars2)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Trim removes variants of an aligned read-end outside the insert of an amplicon.
+//---------------------------------------------------------------------------------------------------
+func (amp Amplicon) Trim(vars []*VarInfo) []*VarInfo {
+	trimmed := vars[:0]
+	for _, v := range vars {
+		if int(v.Pos) >= amp.LeftEnd && int(v.Pos) < amp.RightStart {
+			trimmed = append(trimmed, v)
+		}
+	}
+	return trimmed
+}
@@ -0,0 +1,140 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: annotation.go
+// Per-site statistics of aligned reads and standard INFO annotations of variant calls
+// (MQ, QD, BaseQRankSum, ReadPosRankSum), which are commonly used by hard filters.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"math"
+	"strconv"
+)
+
+const MAX_MAP_QUAL = 60 // maximum Phred-scale mapping quality of a read
+
+//---------------------------------------------------------------------------------------------------
+// SiteStat represents statistics of aligned reads at a variant call position.
+// Reads are separated into reads supporting the reference and reads supporting other alleles.
+//---------------------------------------------------------------------------------------------------
+type SiteStat struct {
+	MQSquareSum float64 // sum of squares of Phred-scale mapping qualities of aligned reads
+	ReadNum     int     // number of aligned reads
+	RefBQual    []int   // base qualities of reads supporting the reference
+	AltBQual    []int   // base qualities of reads supporting other alleles
+	RefRPos     []int   // distances from aligned bases to the nearest read ends of reads supporting the reference
+	AltRPos     []int   // distances from aligned bases to the nearest read ends of reads supporting other alleles
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add updates statistics of a site with information of a variant obtained from an aligned read.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStat) Add(var_info *VarInfo, is_ref bool) {
+	mq := MapQual(var_info.MProb)
+	S.MQSquareSum += mq * mq
+	S.ReadNum++
+	bq := 0
+	for _, q := range var_info.BQual {
+		bq += int(q) - 33
+	}
+	if len(var_info.BQual) > 0 {
+		bq = bq / len(var_info.BQual)
+	}
+	if is_ref {
+		S.RefBQual = append(S.RefBQual, bq)
+		S.RefRPos = append(S.RefRPos, var_info.RPos)
+	} else {
+		S.AltBQual = append(S.AltBQual, bq)
+		S.AltRPos = append(S.AltRPos, var_info.RPos)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Annotations returns INFO annotations of a site in VCF format, given quality of the variant call.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStat) Annotations(qual float64) string {
+	if S.ReadNum == 0 {
+		return ""
+	}
+	str_info := ";MQ=" + strconv.FormatFloat(math.Sqrt(S.MQSquareSum/float64(S.ReadNum)), 'f', 2, 64)
+	str_info += ";QD=" + strconv.FormatFloat(qual/float64(S.ReadNum), 'f', 2, 64)
+	if len(S.RefBQual) > 0 && len(S.AltBQual) > 0 {
+		str_info += ";BaseQRankSum=" + strconv.FormatFloat(RankSum(S.AltBQual, S.RefBQual), 'f', 3, 64)
+		str_info += ";ReadPosRankSum=" + strconv.FormatFloat(RankSum(S.AltRPos, S.RefRPos), 'f', 3, 64)
+	}
+	return str_info
+}
+
+//---------------------------------------------------------------------------------------------------
+// MapQual converts probability of mapping read correctly to Phred-scale mapping quality.
+//---------------------------------------------------------------------------------------------------
+func MapQual(map_prob float64) float64 {
+	if map_prob >= 1 {
+		return MAX_MAP_QUAL
+	}
+	return math.Min(-10*math.Log10(1-map_prob), MAX_MAP_QUAL)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadEndDist returns distance from a position on a read to the nearest end of the read.
+//---------------------------------------------------------------------------------------------------
+func ReadEndDist(read_pos, read_len int) int {
+	if read_pos < 0 {
+		return 0
+	}
+	if read_pos >= read_len {
+		return 0
+	}
+	if read_pos < read_len-1-read_pos {
+		return read_pos
+	}
+	return read_len - 1 - read_pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// RankSum computes z-score of Mann-Whitney-Wilcoxon rank sum test of values of alternative alleles
+// against values of the reference allele (ties get average ranks, normal approximation is used).
+//---------------------------------------------------------------------------------------------------
+func RankSum(alt, ref []int) float64 {
+	n1, n2 := float64(len(alt)), float64(len(ref))
+	if n1 == 0 || n2 == 0 {
+		return 0
+	}
+	// Values are small integers (qualities and read positions), so ranks are computed from counts
+	max_val := 0
+	for _, v := range alt {
+		if max_val < v {
+			max_val = v
+		}
+	}
+	for _, v := range ref {
+		if max_val < v {
+			max_val = v
+		}
+	}
+	alt_count, all_count := make([]int, max_val+1), make([]int, max_val+1)
+	for _, v := range alt {
+		alt_count[v]++
+		all_count[v]++
+	}
+	for _, v := range ref {
+		all_count[v]++
+	}
+	var u, tie_sum, rank float64
+	for v, c := range all_count {
+		if c == 0 {
+			continue
+		}
+		u += float64(alt_count[v]) * (rank + float64(c+1)/2.0)
+		tie_sum += float64(c*c*c - c)
+		rank += float64(c)
+	}
+	u -= n1 * (n1 + 1) / 2
+	n := n1 + n2
+	sd := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tie_sum/(n*(n-1))))
+	if sd == 0 {
+		return 0
+	}
+	return (u - n1*n2/2) / sd
+}
@@ -0,0 +1,35 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: arena.go
+// Per-goroutine arenas of per-read temporaries. Bases and qualities of variants, aligned read/qual/ref
+// buffers of tracebacks and copies of SNP bases are allocated from slabs of the arena of the aligning
+// goroutine, which is reset for each read, instead of being allocated on the heap one by one. Evidence
+// which outlives its read (buffered for realignment, cached, or written asynchronously) is detached from
+// the arena before it is kept.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import "github.com/namsyvo/IVC/align"
+
+// Arenas of per-read temporaries (see align.Arena)
+type Arena = align.Arena
+
+const ARENA_SLAB_SIZE = align.ARENA_SLAB_SIZE
+
+//---------------------------------------------------------------------------------------------------
+// NewArena creates an arena with one slab.
+//---------------------------------------------------------------------------------------------------
+func NewArena() *Arena {
+	return align.NewArena()
+}
+
+//---------------------------------------------------------------------------------------------------
+// DetachVars copies bases and qualities of variants from the arena to the heap, so that they can be kept
+// after the arena is reset.
+//---------------------------------------------------------------------------------------------------
+func DetachVars(vars []*VarInfo) {
+	for _, v := range vars {
+		v.Bases, v.BQual = append([]byte(nil), v.Bases...), append([]byte(nil), v.BQual...)
+	}
+}
@@ -0,0 +1,433 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: assemble.go
+// Local haplotype assembly for dense variant regions. Regions with many candidate variants close to
+// each other (where seed-and-extend alignments are often inconsistent) are assembled from buffered
+// reads: a de Bruijn graph of k-mers of the reference and the reads covering the region is built,
+// haplotypes are enumerated as paths from the first to the last reference k-mer, and the pair of
+// haplotypes which best explains the reads is selected. Variants of reads in the region are replaced
+// by variants of their best haplotype (and reference alleles at variants of the other haplotype),
+// which are then used to update variant probabilities as usual.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+const (
+	ASM_WINDOW         = 60    // candidate variants within a window of this length are considered as dense
+	ASM_MIN_VARS       = 3     // minimum number of candidate variants in a window of a dense region
+	ASM_MAX_LEN        = 300   // regions longer than this length are not assembled
+	ASM_KMER           = 15    // initial k-mer length of de Bruijn graphs
+	ASM_MAX_KMER       = 45    // maximum k-mer length (k is increased if the reference has repeated k-mers)
+	ASM_MIN_KMER_COUNT = 2     // minimum number of reads containing a non-reference k-mer
+	ASM_MAX_HAPS       = 16    // maximum number of enumerated haplotypes (including the reference)
+	ASM_MAX_STEPS      = 50000 // maximum number of steps of haplotype enumeration
+	ASM_MAX_INDEL      = 50    // maximum difference of lengths of haplotypes and the reference
+)
+
+//---------------------------------------------------------------------------------------------------
+// AssembleRegions assembles dense variant regions of read-ends which start in a window, replaces
+// variants of read-ends in assembled regions, and returns the numbers of dense and assembled regions.
+// Read-ends must include those of the window and of its previous and next windows.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AssembleRegions(reads []*RealnRead, win int) (int, int) {
+	regions := make([][2]int, 0)
+	for _, reg := range VC.DenseRegions(reads) {
+		if reg[0]/REALN_WIN_LEN == win {
+			regions = append(regions, reg)
+		}
+	}
+	if len(regions) == 0 {
+		return 0, 0
+	}
+	sort.Slice(reads, func(i, j int) bool { return reads[i].Start < reads[j].Start })
+	starts := make([]int, len(reads))
+	max_len := 0
+	for i, r := range reads {
+		starts[i] = r.Start
+		if max_len < len(r.Read) {
+			max_len = len(r.Read)
+		}
+	}
+	asm_num := 0
+	for _, reg := range regions {
+		reg_reads := make([]*RealnRead, 0)
+		for i := sort.SearchInts(starts, reg[0]-max_len); i < len(reads) && starts[i] < reg[1]; i++ {
+			r := reads[i]
+			if MinInt(r.Start+len(r.Read), reg[1])-MaxInt(r.Start, reg[0]) >= ASM_KMER {
+				reg_reads = append(reg_reads, r)
+			}
+		}
+		if VC.AssembleRegion(reg[0], reg[1], reg_reads) {
+			asm_num++
+		}
+	}
+	return len(regions), asm_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// DenseRegions returns regions [start, end) which have at least ASM_MIN_VARS candidate variants
+// (supported by at least REALN_MIN_READS read-ends) within ASM_WINDOW bases, padded by ASM_KMER bases
+// (after deleted bases of the last variants, so that the last reference k-mer is kept by haplotypes).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) DenseRegions(reads []*RealnRead) [][2]int {
+	pos_num, ref_len := make(map[uint32]int), make(map[int]int)
+	for _, r := range reads {
+		for _, v := range r.Vars {
+			if var_arr := strings.Split(string(v.Bases), "|"); len(var_arr) == 2 && var_arr[0] != var_arr[1] {
+				pos_num[v.Pos]++
+				ref_len[int(v.Pos)] = MaxInt(ref_len[int(v.Pos)], len(var_arr[0]))
+			}
+		}
+	}
+	cand_pos := make([]int, 0)
+	for pos, num := range pos_num {
+		if num >= REALN_MIN_READS {
+			cand_pos = append(cand_pos, int(pos))
+		}
+	}
+	sort.Ints(cand_pos)
+	regions := make([][2]int, 0)
+	for i := 0; i < len(cand_pos); i++ {
+		j := sort.SearchInts(cand_pos, cand_pos[i]+ASM_WINDOW)
+		if j-i < ASM_MIN_VARS {
+			continue
+		}
+		start, end := MaxInt(0, cand_pos[i]-ASM_KMER), 0
+		for _, pos := range cand_pos[i:j] {
+			end = MaxInt(end, MinInt(VC.SeqLen, pos+ref_len[pos]+ASM_KMER))
+		}
+		if n := len(regions); n > 0 && start <= regions[n-1][1] {
+			regions[n-1][1] = MaxInt(regions[n-1][1], end)
+		} else {
+			regions = append(regions, [2]int{start, end})
+		}
+	}
+	// Long regions are not assembled
+	dense_regions := make([][2]int, 0, len(regions))
+	for _, reg := range regions {
+		if reg[1]-reg[0] <= ASM_MAX_LEN {
+			dense_regions = append(dense_regions, reg)
+		}
+	}
+	return dense_regions
+}
+
+//---------------------------------------------------------------------------------------------------
+// AssembleRegion assembles a region [start, end) from read-ends covering it, and replaces variants of
+// the read-ends in the region. It returns false if the region is explained by the reference.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AssembleRegion(start, end int, reads []*RealnRead) bool {
+	ref := VC.RefSegment(start, end)
+	segs := make([][]byte, len(reads))
+	for k, r := range reads {
+		segs[k] = r.Read[MaxInt(0, start-r.Start):MinInt(len(r.Read), end-r.Start)]
+	}
+	haps := AssembleHaplotypes(ref, segs)
+	if len(haps) < 2 {
+		return false
+	}
+	// Costs of aligning reads to haplotypes (-log10 of likelihoods)
+	costs := make([][]float64, len(haps))
+	for h, hap := range haps {
+		costs[h] = make([]float64, len(segs))
+		for k, seg := range segs {
+			costs[h][k] = SemiGlobalCost(seg, hap)
+		}
+	}
+	// Select the pair of haplotypes with the highest likelihood of the reads
+	h1, h2, best_like := 0, 0, math.Inf(-1)
+	for i := 0; i < len(haps); i++ {
+		for j := i; j < len(haps); j++ {
+			like := 0.0
+			for k := range segs {
+				like += math.Log10(0.5*math.Pow(10, -costs[i][k]) + 0.5*math.Pow(10, -costs[j][k]))
+			}
+			if like > best_like {
+				h1, h2, best_like = i, j, like
+			}
+		}
+	}
+	if h1 == 0 && h2 == 0 {
+		return false
+	}
+	hap_vars := [2]map[int]*RealnCand{make(map[int]*RealnCand), make(map[int]*RealnCand)}
+	var_pos := make(map[int]bool)
+	for t, h := range []int{h1, h2} {
+		for _, cand := range HapVariants(ref, haps[h], start) {
+			hap_vars[t][cand.Pos] = cand
+			var_pos[cand.Pos] = true
+		}
+	}
+	for k, r := range reads {
+		t := 0
+		if costs[h2][k] < costs[h1][k] {
+			t = 1
+		}
+		r.Vars = VC.HapEvidence(r, start, end, hap_vars[t], var_pos)
+		r.Asm = true
+	}
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// HapEvidence returns variants of a read-end assigned to a haplotype: variants of the read outside
+// the region [start, end), variants of the haplotype covered by the read, and reference alleles at
+// other variant positions of the region covered by the read.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HapEvidence(r *RealnRead, start, end int, hap_vars map[int]*RealnCand, var_pos map[int]bool) []*VarInfo {
+	template := &VarInfo{MProb: r.MProb, Rev: r.Rev, RSeed: r.Seed}
+	vars := make([]*VarInfo, 0, len(r.Vars))
+	for _, v := range r.Vars {
+		template = v
+		if int(v.Pos) < start || int(v.Pos) >= end {
+			vars = append(vars, v)
+		}
+	}
+	for pos := range var_pos {
+		read_pos := pos - r.Start // index of the read base aligned to the position (ungapped)
+		if read_pos < 1 || read_pos >= len(r.Read)-1 {
+			continue
+		}
+		ev := *template
+		ev.Pos, ev.RPos, ev.Cycle = uint32(pos), ReadEndDist(read_pos, len(r.Read)), ReadCycle(read_pos, len(r.Read), r.Rev)
+		if cand, ok := hap_vars[pos]; ok {
+			ev.Bases, ev.Type = []byte(cand.Ref+"|"+cand.Alt), cand.Type
+			ev.BQual = r.Qual[read_pos:MinInt(len(r.Qual), read_pos+len(cand.Alt))]
+			if cand.Type == 2 {
+				ev.BQual = []byte{align.DelQual(r.Qual[read_pos], r.Qual[read_pos+1])}
+			}
+		} else {
+			ref_base := VC.RefBase(pos)[:1]
+			ev.Bases, ev.Type = []byte(ref_base+"|"+ref_base), 0
+			ev.BQual = r.Qual[read_pos : read_pos+1]
+		}
+		vars = append(vars, &ev)
+	}
+	return vars
+}
+
+//---------------------------------------------------------------------------------------------------
+// RefSegment returns the reference sequence of a region [start, end), known variant locations ('*'
+// on the multigenome) are replaced by the first base of their reference alleles.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) RefSegment(start, end int) []byte {
+	ref := make([]byte, end-start)
+	copy(ref, VC.Seq[start:end])
+	for i := range ref {
+		if ref[i] == '*' {
+			ref[i] = VC.RefBase(start + i)[0]
+		}
+	}
+	return ref
+}
+
+//---------------------------------------------------------------------------------------------------
+// AssembleHaplotypes builds a de Bruijn graph from k-mers of the reference and read segments of a
+// region, and enumerates haplotypes as paths from the first to the last reference k-mer. Non-reference
+// k-mers must occur at least ASM_MIN_KMER_COUNT times in reads; k is increased until the reference has
+// no repeated k-mers. The reference is the first haplotype; it returns nil if k cannot be chosen.
+//---------------------------------------------------------------------------------------------------
+func AssembleHaplotypes(ref []byte, segs [][]byte) [][]byte {
+	k := ASM_KMER
+	var ref_kmers map[string]bool
+	for ; k <= ASM_MAX_KMER; k += 10 {
+		ref_kmers = make(map[string]bool)
+		for i := 0; i+k <= len(ref); i++ {
+			if ref_kmers[string(ref[i:i+k])] {
+				ref_kmers = nil
+				break
+			}
+			ref_kmers[string(ref[i:i+k])] = true
+		}
+		if ref_kmers != nil {
+			break
+		}
+	}
+	if ref_kmers == nil || len(ref) <= k {
+		return nil
+	}
+	kmer_count := make(map[string]int)
+	for _, seg := range segs {
+		for i := 0; i+k <= len(seg); i++ {
+			kmer_count[string(seg[i:i+k])]++
+		}
+	}
+	haps := [][]byte{ref}
+	sink := string(ref[len(ref)-k:])
+	path := make([]byte, k, len(ref)+ASM_MAX_INDEL)
+	copy(path, ref[:k])
+	visited := map[string]bool{string(path): true}
+	steps := 0
+	var extend func()
+	extend = func() {
+		if len(haps) >= ASM_MAX_HAPS || steps >= ASM_MAX_STEPS {
+			return
+		}
+		steps++
+		if string(path[len(path)-k:]) == sink {
+			if string(path) != string(ref) {
+				hap := make([]byte, len(path))
+				copy(hap, path)
+				haps = append(haps, hap)
+			}
+			return
+		}
+		if len(path) >= len(ref)+ASM_MAX_INDEL {
+			return
+		}
+		// Next k-mers are visited in decreasing order of their counts
+		next := make([]string, 0, len(STD_BASES))
+		for _, b := range STD_BASES {
+			kmer := string(path[len(path)-k+1:]) + string(b)
+			if !visited[kmer] && (ref_kmers[kmer] || kmer_count[kmer] >= ASM_MIN_KMER_COUNT) {
+				next = append(next, kmer)
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool { return kmer_count[next[i]] > kmer_count[next[j]] })
+		for _, kmer := range next {
+			visited[kmer] = true
+			path = append(path, kmer[k-1])
+			extend()
+			path = path[:len(path)-1]
+			delete(visited, kmer)
+		}
+	}
+	extend()
+	return haps
+}
+
+//---------------------------------------------------------------------------------------------------
+// SemiGlobalCost returns cost of aligning a read segment entirely to any part of a haplotype,
+// with substitution cost PARA.Sub_cost and linear gap cost PARA.Gap_open.
+//---------------------------------------------------------------------------------------------------
+func SemiGlobalCost(seg, hap []byte) float64 {
+	prev, curr := make([]float64, len(hap)+1), make([]float64, len(hap)+1)
+	for i := 1; i <= len(seg); i++ {
+		curr[0] = float64(i) * PARA.Gap_open
+		for j := 1; j <= len(hap); j++ {
+			sub := prev[j-1]
+			if seg[i-1] != hap[j-1] {
+				sub += PARA.Sub_cost
+			}
+			curr[j] = math.Min(sub, math.Min(prev[j], curr[j-1])+PARA.Gap_open)
+		}
+		prev, curr = curr, prev
+	}
+	cost := prev[0]
+	for j := 1; j <= len(hap); j++ {
+		cost = math.Min(cost, prev[j])
+	}
+	return cost
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlignHaplotype globally aligns a haplotype to the reference with affine gap costs (PARA.Sub_cost,
+// PARA.Gap_open, PARA.Gap_ext). It returns the alignment as operations 'M' (match or substitution),
+// 'I' (insertion in the haplotype) and 'D' (deletion in the haplotype); gaps are left-aligned.
+//---------------------------------------------------------------------------------------------------
+func AlignHaplotype(ref, hap []byte) []byte {
+	n, m := len(ref), len(hap)
+	inf := math.MaxFloat64 / 4
+	// M, D, I: costs of alignments ending with a match, a deletion and an insertion
+	M, D, I := make([][]float64, n+1), make([][]float64, n+1), make([][]float64, n+1)
+	for i := 0; i <= n; i++ {
+		M[i], D[i], I[i] = make([]float64, m+1), make([]float64, m+1), make([]float64, m+1)
+		for j := 0; j <= m; j++ {
+			M[i][j], D[i][j], I[i][j] = inf, inf, inf
+		}
+	}
+	M[0][0] = 0
+	for i := 1; i <= n; i++ {
+		D[i][0] = PARA.Gap_open + float64(i-1)*PARA.Gap_ext
+	}
+	for j := 1; j <= m; j++ {
+		I[0][j] = PARA.Gap_open + float64(j-1)*PARA.Gap_ext
+	}
+	// argmin returns the state (0: M, 1: D, 2: I) with the lowest cost, preferring M on ties
+	argmin := func(c_m, c_d, c_i float64) (int, float64) {
+		if c_m <= c_d && c_m <= c_i {
+			return 0, c_m
+		}
+		if c_d <= c_i {
+			return 1, c_d
+		}
+		return 2, c_i
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			_, M[i][j] = argmin(M[i-1][j-1], D[i-1][j-1], I[i-1][j-1])
+			if ref[i-1] != hap[j-1] {
+				M[i][j] += PARA.Sub_cost
+			}
+			_, D[i][j] = argmin(M[i-1][j]+PARA.Gap_open, D[i-1][j]+PARA.Gap_ext, I[i-1][j]+PARA.Gap_open)
+			_, I[i][j] = argmin(M[i][j-1]+PARA.Gap_open, D[i][j-1]+PARA.Gap_open, I[i][j-1]+PARA.Gap_ext)
+		}
+	}
+	ops := make([]byte, 0, n+m)
+	i, j := n, m
+	state, _ := argmin(M[n][m], D[n][m], I[n][m])
+	for i > 0 || j > 0 {
+		switch state {
+		case 0:
+			ops = append(ops, 'M')
+			state, _ = argmin(M[i-1][j-1], D[i-1][j-1], I[i-1][j-1])
+			i, j = i-1, j-1
+		case 1:
+			ops = append(ops, 'D')
+			state, _ = argmin(M[i-1][j]+PARA.Gap_open, D[i-1][j]+PARA.Gap_ext, I[i-1][j]+PARA.Gap_open)
+			i--
+		case 2:
+			ops = append(ops, 'I')
+			state, _ = argmin(M[i][j-1]+PARA.Gap_open, D[i][j-1]+PARA.Gap_open, I[i][j-1]+PARA.Gap_ext)
+			j--
+		}
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
+
+//---------------------------------------------------------------------------------------------------
+// HapVariants returns variants of a haplotype against the reference of a region starting at start.
+// Indels are reported at the reference base before inserted/deleted bases (indels at the start of
+// the region are ignored).
+//---------------------------------------------------------------------------------------------------
+func HapVariants(ref, hap []byte, start int) []*RealnCand {
+	ops := AlignHaplotype(ref, hap)
+	vars := make([]*RealnCand, 0)
+	i, j := 0, 0
+	for k := 0; k < len(ops); {
+		l := 1
+		for k+l < len(ops) && ops[k+l] == ops[k] && ops[k] != 'M' {
+			l++
+		}
+		switch ops[k] {
+		case 'M':
+			if ref[i] != hap[j] {
+				vars = append(vars, &RealnCand{Pos: start + i, Ref: string(ref[i]), Alt: string(hap[j]), Type: 0})
+			}
+			i, j = i+1, j+1
+		case 'I':
+			if i > 0 {
+				vars = append(vars, &RealnCand{Pos: start + i - 1, Ref: string(ref[i-1]), Alt: string(ref[i-1]) + string(hap[j:j+l]), Type: 1})
+			}
+			j += l
+		case 'D':
+			if i > 0 {
+				vars = append(vars, &RealnCand{Pos: start + i - 1, Ref: string(ref[i-1 : i+l]), Alt: string(ref[i-1]), Type: 2})
+			}
+			i += l
+		}
+		k += l
+	}
+	return vars
+}
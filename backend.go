@@ -0,0 +1,102 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: backend.go
+// Backends of the alignment DP. Distances between read flanks and reference flanks (backward alignment
+// of left flanks and forward alignment of right flanks) are computed by a backend in batches of tasks.
+// A batch holds the four flank alignments of one seed candidate of a read (left and right flanks, with
+// reduced and original reference flanks), whose tasks are reused by the goroutine, and is computed with
+// the aligner of the goroutine (see VC.Aligner). The CPU backend computes tasks one by one with
+// LeftAlign and RightAlign of the aligner; other backends are registered with RegisterAlnBackend and
+// selected by name. The AVX2 backend (backend_avx2.go) is included in builds with cgo and the build tag
+// avx2.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+//---------------------------------------------------------------------------------------------------
+// AlnTask represents an alignment task between a read flank and a reference flank, and its result.
+// Matrices of the DP are taken from Info (left or right matrices depending on direction).
+//---------------------------------------------------------------------------------------------------
+type AlnTask struct {
+	Read, Qual, Ref []byte       // read flank, its qualities and reference flank
+	CycCost         []float64    // changes of substitution costs of bases of the read flank (nil: no per-cycle model)
+	Pos             int          // position of the reference flank on the multigenome
+	RefPosMap       []int        // positions of bases of the reference flank on the multigenome
+	DropRows        int          // X-drop rows of known variants of the reference flank (see XDropRows)
+	DelRef          bool         // reference flank is reduced at known deletions
+	Left            bool         // backward alignment of a left flank (forward alignment of a right flank otherwise)
+	Info            *EditAlnInfo // matrices of the DP
+
+	HamDist, EditDist float64  // distances of the Hamming and the edit part of the alignment
+	BtMat, M, N       int      // backtrace matrix and lengths of the edit part
+	VarPos            []int    // positions of variants of the Hamming part
+	VarBase, VarQual  [][]byte // bases and qualities of variants of the Hamming part
+	VarType           []int    // types of variants of the Hamming part
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlnBackend represents a backend computing alignment tasks in batches.
+//---------------------------------------------------------------------------------------------------
+type AlnBackend interface {
+	AlignBatch(A *align.Aligner, tasks []*AlnTask)
+}
+
+// Available backends (name, constructor) and the backend in use
+var (
+	ALN_BACKENDS            = map[string]func() AlnBackend{"cpu": func() AlnBackend { return CPUBackend{} }}
+	ALN_BACKEND  AlnBackend = CPUBackend{}
+)
+
+//---------------------------------------------------------------------------------------------------
+// RegisterAlnBackend registers a backend of the alignment DP.
+//---------------------------------------------------------------------------------------------------
+func RegisterAlnBackend(name string, new_backend func() AlnBackend) {
+	ALN_BACKENDS[name] = new_backend
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupAlnBackend selects the backend of the alignment DP by name.
+//---------------------------------------------------------------------------------------------------
+func SetupAlnBackend(name string) {
+	if name == "" {
+		name = "cpu"
+	}
+	new_backend, ok := ALN_BACKENDS[name]
+	if !ok {
+		names := make([]string, 0, len(ALN_BACKENDS))
+		for n, _ := range ALN_BACKENDS {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		log.Panicf("Error: unknown alignment backend %s (available backends: %s)", name, strings.Join(names, ", "))
+	}
+	ALN_BACKEND = new_backend()
+	log.Printf("Alignment backend:\t%s", name)
+}
+
+//---------------------------------------------------------------------------------------------------
+// CPUBackend computes alignment tasks one by one on the CPU.
+//---------------------------------------------------------------------------------------------------
+type CPUBackend struct{}
+
+func (CPUBackend) AlignBatch(A *align.Aligner, tasks []*AlnTask) {
+	for _, t := range tasks {
+		if t.Left {
+			t.HamDist, t.EditDist, t.BtMat, t.M, t.N, t.VarPos, t.VarBase, t.VarQual, t.VarType =
+				A.LeftAlign(t.Read, t.Qual, t.Ref, t.Pos, t.Info.l_Dist_D, t.Info.l_Dist_IS, t.Info.l_Dist_IT,
+					t.Info.l_Trace_D, t.Info.l_Trace_IS, t.Info.l_Trace_IT, t.Info.l_Trace_K, t.RefPosMap, t.DropRows, t.DelRef, t.CycCost, t.Info.arena)
+		} else {
+			t.HamDist, t.EditDist, t.BtMat, t.M, t.N, t.VarPos, t.VarBase, t.VarQual, t.VarType =
+				A.RightAlign(t.Read, t.Qual, t.Ref, t.Pos, t.Info.r_Dist_D, t.Info.r_Dist_IS, t.Info.r_Dist_IT,
+					t.Info.r_Trace_D, t.Info.r_Trace_IS, t.Info.r_Trace_IT, t.Info.r_Trace_K, t.RefPosMap, t.DropRows, t.DelRef, t.CycCost, t.Info.arena)
+		}
+	}
+}
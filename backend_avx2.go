@@ -0,0 +1,343 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: backend_avx2.go
+// AVX2 backend of the alignment DP (built with cgo and the build tag avx2, selected with -aln-backend
+// avx2). Hamming parts of flank alignments are computed in Go as by the CPU backend; edit parts without
+// known variant loci are filled by a C kernel which computes the four tasks of a batch at once, one task
+// in each lane of AVX2 registers, keeping only two rows of the DP matrices. Most seed candidates are
+// rejected by their distances, which the kernel computes exactly as LeftAlignEdit and RightAlignEdit do
+// (same additions and comparisons in double precision, same band and X-drop); edit parts which are
+// within the distance threshold are filled again in Go, so that their backtrace matrices are available
+// for tracing back alignments, and results are the same as results of the CPU backend.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+//go:build cgo && avx2
+// +build cgo,avx2
+
+package ivc
+
+/*
+#cgo CFLAGS: -O2
+
+#include <float.h>
+#include <immintrin.h>
+
+#define LANES 4
+#define RD_FIELDS 4 // base, class, lower-case base and cycle cost of read bases
+#define RF_FIELDS 5 // base, class, lower-case base, gap open and substitution costs of ref bases
+
+typedef struct {
+	int m, n;          // lengths of the read and the ref of the edit part (0: empty lane)
+	int drop_rows;     // X-drop rows
+	double drop_thres; // remaining distance threshold of the edit part
+} ivc_lane;
+
+static int ivc_has_avx2(void) {
+	__builtin_cpu_init();
+	return __builtin_cpu_supports("avx2");
+}
+
+// ivc_dp_batch fills the edit parts of up to 4 tasks. Field f of read base i (1..max_m) of lane l is
+// rd[((i-1)*RD_FIELDS+f)*LANES+l], field f of ref base j (1..max_n) is rf[((j-1)*RF_FIELDS+f)*LANES+l].
+// buf holds 6 rows of (max_n+1)*LANES doubles. For each lane, out gets the distance, the matrix to trace
+// back from and 1 (0 if the fill is stopped by X-drop).
+__attribute__((target("avx2")))
+static void ivc_dp_batch(const ivc_lane *lanes, int max_m, int max_n, const double *rd, const double *rf,
+	double gap_open, double gap_ext, double ts_cost, double tv_cost, int band, double *buf, double *out) {
+
+	const double F = (double)FLT_MAX;
+	const int w = (max_n + 1) * LANES;
+	double *Dp = buf, *ISp = buf + w, *ITp = buf + 2*w, *Dc = buf + 3*w, *ISc = buf + 4*w, *ITc = buf + 5*w, *t;
+	double n_v[LANES], off_v[LANES];
+	int high_rows[LANES], active = 0;
+	for (int l = 0; l < LANES; l++) {
+		n_v[l] = lanes[l].n;
+		off_v[l] = lanes[l].n - lanes[l].m;
+		high_rows[l] = 0;
+		out[3*l] = 0;
+		out[3*l+1] = -1;
+		out[3*l+2] = 0;
+		if (lanes[l].m > 0) {
+			active++;
+		}
+	}
+	const __m256d vF = _mm256_set1_pd(F), v0 = _mm256_setzero_pd(), vext = _mm256_set1_pd(gap_ext);
+	const __m256d vts = _mm256_set1_pd(ts_cost), vtv = _mm256_set1_pd(tv_cost), vband = _mm256_set1_pd(band);
+	const __m256d vn = _mm256_loadu_pd(n_v), voff = _mm256_loadu_pd(off_v);
+	const __m256d sign = _mm256_set1_pd(-0.0);
+	for (int l = 0; l < LANES; l++) {
+		Dp[l] = 0;
+		ISp[l] = F;
+		ITp[l] = F;
+	}
+	for (int j = 1; j <= max_n; j++) {
+		_mm256_storeu_pd(Dp + j*LANES, vF);
+		_mm256_storeu_pd(ISp + j*LANES, vF);
+		_mm256_storeu_pd(ITp + j*LANES, v0);
+	}
+	for (int i = 1; i <= max_m && active > 0; i++) {
+		const double *r = rd + (i-1)*RD_FIELDS*LANES;
+		const __m256d rb = _mm256_loadu_pd(r), rc = _mm256_loadu_pd(r + LANES), rl = _mm256_loadu_pd(r + 2*LANES);
+		const __m256d cyc = _mm256_loadu_pd(r + 3*LANES);
+		_mm256_storeu_pd(Dc, vF);
+		_mm256_storeu_pd(ITc, vF);
+		_mm256_storeu_pd(ISc, _mm256_set1_pd(i == 1 ? gap_open : gap_ext));
+		__m256d row_min = vF;
+		for (int j = 1; j <= max_n; j++) {
+			const double *f = rf + (j-1)*RF_FIELDS*LANES;
+			const __m256d gap = _mm256_loadu_pd(f + 3*LANES);
+			__m256d mis = _mm256_max_pd(_mm256_add_pd(_mm256_loadu_pd(f + 4*LANES), cyc), v0);
+			__m256d ts = _mm256_andnot_pd(_mm256_cmp_pd(rl, _mm256_loadu_pd(f + 2*LANES), _CMP_EQ_OQ),
+				_mm256_cmp_pd(rc, _mm256_loadu_pd(f + LANES), _CMP_EQ_OQ));
+			__m256d sub = _mm256_max_pd(_mm256_add_pd(mis, _mm256_blendv_pd(vtv, vts, ts)), v0);
+			sub = _mm256_andnot_pd(_mm256_cmp_pd(rb, _mm256_loadu_pd(f), _CMP_EQ_OQ), sub);
+
+			__m256d d = _mm256_min_pd(_mm256_min_pd(_mm256_add_pd(_mm256_loadu_pd(Dp + (j-1)*LANES), sub),
+				_mm256_add_pd(_mm256_loadu_pd(ISp + (j-1)*LANES), sub)), _mm256_add_pd(_mm256_loadu_pd(ITp + (j-1)*LANES), sub));
+			__m256d is = _mm256_min_pd(_mm256_add_pd(_mm256_loadu_pd(Dp + j*LANES), gap), _mm256_add_pd(_mm256_loadu_pd(ISp + j*LANES), vext));
+			__m256d it = _mm256_min_pd(_mm256_add_pd(_mm256_loadu_pd(Dc + (j-1)*LANES), gap), _mm256_add_pd(_mm256_loadu_pd(ITc + (j-1)*LANES), vext));
+
+			// Cells outside the band are not reached, columns beyond n of a lane are not in its matrices
+			__m256d in_band = _mm256_castsi256_pd(_mm256_set1_epi64x(-1));
+			if (band > 0) {
+				__m256d diag = _mm256_andnot_pd(sign, _mm256_sub_pd(_mm256_set1_pd(j - i), voff));
+				in_band = _mm256_cmp_pd(diag, vband, _CMP_LE_OQ);
+			}
+			d = _mm256_blendv_pd(vF, d, in_band);
+			is = _mm256_blendv_pd(vF, is, in_band);
+			it = _mm256_blendv_pd(vF, it, in_band);
+			_mm256_storeu_pd(Dc + j*LANES, d);
+			_mm256_storeu_pd(ISc + j*LANES, is);
+			_mm256_storeu_pd(ITc + j*LANES, it);
+			__m256d cell_min = _mm256_min_pd(d, _mm256_min_pd(is, it));
+			__m256d used = _mm256_and_pd(in_band, _mm256_cmp_pd(_mm256_set1_pd(j), vn, _CMP_LE_OQ));
+			row_min = _mm256_blendv_pd(row_min, _mm256_min_pd(row_min, cell_min), used);
+		}
+		double mins[LANES];
+		_mm256_storeu_pd(mins, row_min);
+		for (int l = 0; l < LANES; l++) {
+			if (i > lanes[l].m || out[3*l+1] == -2) {
+				continue;
+			}
+			if (mins[l] <= lanes[l].drop_thres) {
+				high_rows[l] = 0;
+			} else if (++high_rows[l] >= lanes[l].drop_rows) {
+				out[3*l+1] = -2; // dropped
+				active--;
+				continue;
+			}
+			if (i == lanes[l].m) {
+				int n = lanes[l].n;
+				double min_dist = Dc[n*LANES+l];
+				int bt_mat = 0;
+				if (min_dist > ISc[n*LANES+l]) {
+					min_dist = ISc[n*LANES+l];
+					bt_mat = 1;
+				}
+				if (min_dist > ITc[n*LANES+l]) {
+					min_dist = ITc[n*LANES+l];
+					bt_mat = 2;
+				}
+				out[3*l] = min_dist;
+				out[3*l+1] = bt_mat;
+				out[3*l+2] = 1;
+				active--;
+			}
+		}
+		t = Dp; Dp = Dc; Dc = t;
+		t = ISp; ISp = ISc; ISc = t;
+		t = ITp; ITp = ITc; ITc = t;
+	}
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+func init() {
+	if C.ivc_has_avx2() != 0 {
+		RegisterAlnBackend("avx2", func() AlnBackend { return new(AVX2Backend) })
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AVX2Backend computes edit parts of alignment tasks of a batch at once with AVX2 instructions.
+//---------------------------------------------------------------------------------------------------
+type AVX2Backend struct {
+	bufs sync.Pool // buffers of kernel inputs and DP rows (*AVX2Buf)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AVX2Buf represents inputs and DP rows of the kernel, reused across batches.
+//---------------------------------------------------------------------------------------------------
+type AVX2Buf struct {
+	lanes    [C.LANES]C.ivc_lane  // lengths and X-drop parameters of edit parts of tasks
+	rd, rf   []float64            // fields of read bases and ref bases of edit parts (see ivc_dp_batch)
+	rows     []float64            // rows of the DP matrices
+	out      [3 * C.LANES]float64 // distances, matrices to trace back from and X-drop indicators
+	tasks    [C.LANES]*AlnTask    // tasks in the lanes
+	task_num int                  // number of tasks in the lanes
+}
+
+func (B *AVX2Backend) AlignBatch(A *align.Aligner, tasks []*AlnTask) {
+	buf, _ := B.bufs.Get().(*AVX2Buf)
+	if buf == nil {
+		buf = new(AVX2Buf)
+	}
+	defer B.bufs.Put(buf)
+	buf.task_num = 0
+	for _, t := range tasks {
+		if t.Left {
+			t.HamDist, t.M, t.N, t.VarPos, t.VarBase, t.VarQual, t.VarType = A.LeftAlignHam(t.Read, t.Qual, t.Ref, t.Pos, t.RefPosMap, t.DelRef, t.Info.arena)
+		} else {
+			t.HamDist, t.M, t.N, t.VarPos, t.VarBase, t.VarQual, t.VarType = A.RightAlignHam(t.Read, t.Qual, t.Ref, t.Pos, t.RefPosMap, t.DelRef, t.Info.arena)
+		}
+		t.EditDist, t.BtMat = 0, -1
+		if t.HamDist > A.Para.Dist_thres || t.M == 0 || t.N == 0 {
+			continue
+		}
+		// Edit parts with known variant loci (and all edit parts in debug mode) are filled in Go
+		if A.Trace != nil || HasKnownLoci(A, t) {
+			AlignEdit(A, t)
+			continue
+		}
+		buf.tasks[buf.task_num] = t
+		if buf.task_num++; buf.task_num == C.LANES {
+			buf.Align(A)
+		}
+	}
+	if buf.task_num > 0 {
+		buf.Align(A)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// HasKnownLoci checks if the ref of the edit part of a task has known variant loci.
+//---------------------------------------------------------------------------------------------------
+func HasKnownLoci(A *align.Aligner, t *AlnTask) bool {
+	ref_pos_map := t.RefPosMap[:t.N]
+	if !t.Left {
+		ref_pos_map = t.RefPosMap[len(t.Ref)-t.N : len(t.Ref)]
+	}
+	for _, pos := range ref_pos_map {
+		if A.Seq[pos] == '*' {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlignEdit fills the edit part of a task in Go (with backtrace matrices).
+//---------------------------------------------------------------------------------------------------
+func AlignEdit(A *align.Aligner, t *AlnTask) {
+	var ok bool
+	if t.Left {
+		t.EditDist, t.BtMat, ok = A.LeftAlignEdit(t.Read, t.Qual, t.Ref, t.M, t.N, t.Pos, t.HamDist, t.Info.l_Dist_D, t.Info.l_Dist_IS, t.Info.l_Dist_IT,
+			t.Info.l_Trace_D, t.Info.l_Trace_IS, t.Info.l_Trace_IT, t.Info.l_Trace_K, t.RefPosMap, t.DropRows, t.DelRef, t.CycCost)
+	} else {
+		t.EditDist, t.BtMat, ok = A.RightAlignEdit(t.Read, t.Qual, t.Ref, t.M, t.N, t.Pos, t.HamDist, t.Info.r_Dist_D, t.Info.r_Dist_IS, t.Info.r_Dist_IT,
+			t.Info.r_Trace_D, t.Info.r_Trace_IS, t.Info.r_Trace_IT, t.Info.r_Trace_K, t.RefPosMap, t.DropRows, t.DelRef, t.CycCost)
+	}
+	if !ok {
+		t.HamDist, t.EditDist, t.BtMat = A.Para.Dist_thres+1, 0, -1
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Align fills edit parts of the tasks of a buffer with the kernel. Tasks within the distance threshold
+// are filled again in Go for their backtrace matrices.
+//---------------------------------------------------------------------------------------------------
+func (buf *AVX2Buf) Align(A *align.Aligner) {
+	max_m, max_n := 0, 0
+	for l := 0; l < C.LANES; l++ {
+		buf.lanes[l] = C.ivc_lane{}
+		if l < buf.task_num {
+			t := buf.tasks[l]
+			buf.lanes[l] = C.ivc_lane{m: C.int(t.M), n: C.int(t.N), drop_rows: C.int(t.DropRows), drop_thres: C.double(A.Para.Dist_thres - t.HamDist)}
+			max_m, max_n = MaxInt(max_m, t.M), MaxInt(max_n, t.N)
+		}
+	}
+	buf.rd = Resize(buf.rd, max_m*C.RD_FIELDS*C.LANES)
+	buf.rf = Resize(buf.rf, max_n*C.RF_FIELDS*C.LANES)
+	buf.rows = Resize(buf.rows, 6*(max_n+1)*C.LANES)
+	for l := 0; l < buf.task_num; l++ {
+		buf.SetLane(A, l, buf.tasks[l])
+	}
+	C.ivc_dp_batch(&buf.lanes[0], C.int(max_m), C.int(max_n), (*C.double)(unsafe.Pointer(&buf.rd[0])), (*C.double)(unsafe.Pointer(&buf.rf[0])),
+		C.double(A.Para.Gap_open), C.double(A.Para.Gap_ext), C.double(A.Para.Ts_cost), C.double(A.Para.Tv_cost), C.int(A.Para.Aln_band),
+		(*C.double)(unsafe.Pointer(&buf.rows[0])), (*C.double)(unsafe.Pointer(&buf.out[0])))
+	for l := 0; l < buf.task_num; l++ {
+		t := buf.tasks[l]
+		if buf.out[3*l+2] == 0 {
+			t.HamDist, t.EditDist, t.BtMat = A.Para.Dist_thres+1, 0, -1
+		} else if t.HamDist+buf.out[3*l] <= A.Para.Dist_thres {
+			AlignEdit(A, t)
+		} else {
+			t.EditDist, t.BtMat = buf.out[3*l], int(buf.out[3*l+1])
+		}
+		buf.tasks[l] = nil
+	}
+	buf.task_num = 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetLane sets inputs of lane l of the kernel from the edit part of a task in the order of DP (bases of
+// the read and the ref are taken backward for left flanks and forward for right flanks).
+//---------------------------------------------------------------------------------------------------
+func (buf *AVX2Buf) SetLane(A *align.Aligner, l int, t *AlnTask) {
+	M, N := len(t.Read), len(t.Ref)
+	for i := 1; i <= t.M; i++ {
+		k := i - 1
+		if !t.Left {
+			k = M - i
+		}
+		cyc := 0.0
+		if t.CycCost != nil {
+			cyc = t.CycCost[k]
+		}
+		rd := buf.rd[(i-1)*C.RD_FIELDS*C.LANES+l:]
+		rd[0], rd[C.LANES], rd[2*C.LANES], rd[3*C.LANES] = float64(t.Read[k]), BaseClass(t.Read[k], -1), float64(t.Read[k]|0x20), cyc
+	}
+	for j := 1; j <= t.N; j++ {
+		k := j - 1
+		if !t.Left {
+			k = N - j
+		}
+		gap, sub := A.Para.Gap_open, A.Para.Sub_cost
+		if A.Context != nil {
+			gap, sub = A.Context.ContextGapOpen(t.RefPosMap[k]), A.Context.ContextSubCost(t.RefPosMap[k])
+		}
+		rf := buf.rf[(j-1)*C.RF_FIELDS*C.LANES+l:]
+		rf[0], rf[C.LANES], rf[2*C.LANES], rf[3*C.LANES], rf[4*C.LANES] = float64(t.Ref[k]), BaseClass(t.Ref[k], -2), float64(t.Ref[k]|0x20), gap, sub
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// BaseClass returns the class of a base for transitions (1: A or G, 2: C or T, other: other bases), so
+// that substitutions between different bases of the same class are transitions (see IsTransition).
+//---------------------------------------------------------------------------------------------------
+func BaseClass(b byte, other float64) float64 {
+	switch b | 0x20 {
+	case 'a', 'g':
+		return 1
+	case 'c', 't':
+		return 2
+	}
+	return other
+}
+
+//---------------------------------------------------------------------------------------------------
+// Resize returns a slice of length n, reusing the memory of s if it is large enough.
+//---------------------------------------------------------------------------------------------------
+func Resize(s []float64, n int) []float64 {
+	if cap(s) < n {
+		return make([]float64, n)
+	}
+	return s[:n]
+}
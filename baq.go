@@ -0,0 +1,228 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: baq.go
+// Base alignment quality (BAQ, as in samtools). A read-end with mismatches is aligned to a window of
+// the reference around its alignment with a profile HMM (match, insertion and deletion states, the
+// read is aligned end-to-end and the reference locally); the forward-backward algorithm gives the
+// posterior probability that each read base is aligned to the position of its alignment. Qualities of
+// mismatches are capped by the Phred-scaled probability that they are misaligned, so mismatches near
+// indels (which are often due to misalignment) have little weight when updating variant probabilities.
+// Only the band of the HMM matrices around the alignment is stored, in buffers reused by goroutines.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	BAQ_BAND     = 7     // number of reference bases added to each side of the alignment window
+	BAQ_GAP_OPEN = 0.001 // probability of opening a gap in the HMM
+	BAQ_GAP_EXT  = 0.1   // probability of extending a gap in the HMM
+	BAQ_INS_EMIT = 0.25  // emission probability of inserted bases
+)
+
+//---------------------------------------------------------------------------------------------------
+// BAQBuf represents bands of matrices of the HMM (forward and backward probabilities of match,
+// insertion and deletion states), stored row by row: row i holds window positions lo+i to lo+i+Width-1.
+// Buffers are reused for read-ends processed by a goroutine.
+//---------------------------------------------------------------------------------------------------
+type BAQBuf struct {
+	fM, fI, fD []float64 // forward probabilities (fM holds posterior probabilities of match states after BAQPosterior)
+	bM, bI, bD []float64 // backward probabilities
+	scale      []float64 // scaling factors of rows
+	Rows       int       // number of rows (read bases)
+	Width      int       // width of the band
+	lo         int       // window position of the first column of row 0 (can be negative)
+}
+
+//---------------------------------------------------------------------------------------------------
+// reset sets up the band of a read of l_read bases, and zeroes the matrices.
+//---------------------------------------------------------------------------------------------------
+func (B *BAQBuf) reset(l_read, width, lo int) {
+	n := l_read * width
+	for _, m := range []*[]float64{&B.fM, &B.fI, &B.fD, &B.bM, &B.bI, &B.bD} {
+		if cap(*m) < n {
+			*m = make([]float64, n)
+		} else {
+			*m = (*m)[:n]
+			for j := range *m {
+				(*m)[j] = 0
+			}
+		}
+	}
+	if cap(B.scale) < l_read {
+		B.scale = make([]float64, l_read)
+	}
+	B.scale = B.scale[:l_read]
+	B.Rows, B.Width, B.lo = l_read, width, lo
+}
+
+//---------------------------------------------------------------------------------------------------
+// idx returns the index of row i and window position k in a matrix, or -1 if it is outside the band.
+//---------------------------------------------------------------------------------------------------
+func (B *BAQBuf) idx(i, k int) int {
+	j := k - B.lo - i
+	if i < 0 || i >= B.Rows || j < 0 || j >= B.Width {
+		return -1
+	}
+	return i*B.Width + j
+}
+
+//---------------------------------------------------------------------------------------------------
+// at returns the value of a matrix at row i and window position k (0 outside the band).
+//---------------------------------------------------------------------------------------------------
+func (B *BAQBuf) at(m []float64, i, k int) float64 {
+	if p := B.idx(i, k); p >= 0 {
+		return m[p]
+	}
+	return 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// Post returns the posterior probability that read base i is aligned to window position k.
+//---------------------------------------------------------------------------------------------------
+func (B *BAQBuf) Post(i, k int) float64 {
+	return B.at(B.fM, i, k)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ApplyBAQ caps qualities of mismatches of an aligned read-end (in the strand aligned to the reference,
+// starting at start on the reference) by their base alignment qualities, with matrices in buffers B.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ApplyBAQ(B *BAQBuf, read, qual []byte, start int, vars []*VarInfo) {
+	ins_len, del_len, has_sub := 0, 0, false
+	for _, v := range vars {
+		var_arr := strings.Split(string(v.Bases), "|")
+		if v.Type == 1 {
+			ins_len += len(var_arr[1]) - len(var_arr[0])
+		} else if v.Type == 2 {
+			del_len += len(var_arr[0]) - len(var_arr[1])
+		} else {
+			has_sub = true
+		}
+	}
+	if !has_sub {
+		return
+	}
+	ref_start := MaxInt(start-BAQ_BAND, 0)
+	ref_end := MinInt(start+len(read)-ins_len+del_len+BAQ_BAND, VC.SeqLen)
+	if ref_end <= ref_start {
+		return
+	}
+	BAQPosterior(B, read, qual, VC.Seq[ref_start:ref_end], start-ref_start, ins_len, del_len)
+	for _, v := range vars {
+		if v.Type != 0 {
+			continue
+		}
+		// Index of the read base aligned to the variant, shifted by indels before the variant
+		i := int(v.Pos) - start
+		for _, u := range vars {
+			if u.Type != 0 && u.Pos < v.Pos {
+				var_arr := strings.Split(string(u.Bases), "|")
+				i += len(var_arr[1]) - len(var_arr[0])
+			}
+		}
+		k := int(v.Pos) - ref_start
+		if i < 0 || i >= len(read) || k < 0 || k >= ref_end-ref_start || len(v.BQual) != 1 {
+			continue
+		}
+		baq := byte(33 + math.Min(-10*math.Log10(math.Max(1-B.Post(i, k), 1e-10)), 93))
+		if baq < v.BQual[0] {
+			v.BQual = []byte{baq}
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// BAQPosterior computes posterior probabilities that read bases are aligned (in match states) to bases
+// of a reference window, kept in buffers B (see Post). Read bases are expected to be aligned near the
+// diagonal starting at offset, within ins_len and del_len plus the band.
+//---------------------------------------------------------------------------------------------------
+func BAQPosterior(B *BAQBuf, read, qual, ref []byte, offset, ins_len, del_len int) {
+	l_read, l_ref := len(read), len(ref)
+	mm, mi, md := 1-2*BAQ_GAP_OPEN, BAQ_GAP_OPEN, BAQ_GAP_OPEN
+	im, ii := 1-BAQ_GAP_EXT, BAQ_GAP_EXT
+	dm, dd := 1-BAQ_GAP_EXT, BAQ_GAP_EXT
+	B.reset(l_read, ins_len+del_len+2*BAQ_BAND+1, offset-ins_len-BAQ_BAND)
+	band_start := func(i int) int { return MaxInt(offset+i-ins_len-BAQ_BAND, 0) }
+	band_end := func(i int) int { return MinInt(offset+i+del_len+BAQ_BAND+1, l_ref) }
+	emit := func(i, k int) float64 {
+		if ref[k] == '*' || ref[k] == read[i] {
+			return Q2P[qual[i]]
+		}
+		return Q2E[qual[i]]
+	}
+	fM, fI, fD := B.fM, B.fI, B.fD
+	bM, bI, bD := B.bM, B.bI, B.bD
+
+	// Forward, each row is scaled to sum 1
+	for i := 0; i < l_read; i++ {
+		sum := 0.0
+		for k := band_start(i); k < band_end(i); k++ {
+			p := B.idx(i, k)
+			if i == 0 {
+				fM[p] = emit(i, k) * mm / float64(l_ref)
+				fI[p] = BAQ_INS_EMIT * mi / float64(l_ref)
+			} else {
+				if k > 0 {
+					fM[p] = emit(i, k) * (B.at(fM, i-1, k-1)*mm + B.at(fI, i-1, k-1)*im + B.at(fD, i-1, k-1)*dm)
+				}
+				fI[p] = BAQ_INS_EMIT * (B.at(fM, i-1, k)*mi + B.at(fI, i-1, k)*ii)
+			}
+			if k > 0 {
+				fD[p] = B.at(fM, i, k-1)*md + B.at(fD, i, k-1)*dd
+			}
+			sum += fM[p] + fI[p] + fD[p]
+		}
+		if sum == 0 {
+			sum = 1
+		}
+		B.scale[i] = sum
+		for k := band_start(i); k < band_end(i); k++ {
+			p := B.idx(i, k)
+			fM[p] /= sum
+			fI[p] /= sum
+			fD[p] /= sum
+		}
+	}
+	// Probability of the read (scaled), alignments end in match or insertion states
+	end_prob := 0.0
+	for k := band_start(l_read - 1); k < band_end(l_read-1); k++ {
+		end_prob += B.at(fM, l_read-1, k) + B.at(fI, l_read-1, k)
+	}
+	if end_prob == 0 {
+		end_prob = 1
+	}
+
+	// Backward, rows are scaled by the same factors as forward rows
+	for i := l_read - 1; i >= 0; i-- {
+		for k := band_end(i) - 1; k >= band_start(i); k-- {
+			p := B.idx(i, k)
+			if i == l_read-1 {
+				bM[p], bI[p] = 1/end_prob, 1/end_prob
+				continue
+			}
+			next_m := 0.0
+			if k+1 < l_ref {
+				next_m = emit(i+1, k+1) * B.at(bM, i+1, k+1) / B.scale[i+1]
+			}
+			next_i := BAQ_INS_EMIT * B.at(bI, i+1, k) / B.scale[i+1]
+			bM[p] = mm*next_m + mi*next_i
+			bI[p] = im*next_m + ii*next_i
+			bD[p] = dm * next_m
+			if k+1 < l_ref {
+				bM[p] += md * B.at(bD, i, k+1)
+				bD[p] += dd * B.at(bD, i, k+1)
+			}
+		}
+	}
+	for i := 0; i < l_read; i++ {
+		for k := band_start(i); k < band_end(i); k++ {
+			p := B.idx(i, k)
+			fM[p] *= bM[p]
+		}
+	}
+}
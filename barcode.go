@@ -0,0 +1,278 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: barcode.go
+// Linked reads (10x-style). Reads of a long DNA molecule share a barcode, given as a BX:Z: tag in
+// comments of FASTQ headers. Barcodes are grouped into identifiers, and placements of reads which are
+// not ambiguous are binned by barcode; ties of repetitive placements are then resolved in favor of the
+// placement co-located with other reads of the same barcode. Barcodes of aligned reads are kept with
+// their alleles, and heterozygous calls are phased by barcodes shared with the previous calls of their
+// phase block (before calls of a region are written, so that calls which are not linked to other calls
+// are not phased).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	BARCODE_TAG        = "BX:Z:" // tag of barcodes in comments of FASTQ headers
+	BARCODE_BIN        = 50000   // length of bins of placements of reads of barcodes (about the length of molecules)
+	BARCODE_MIN_READS  = 2       // minimum number of co-located reads of a barcode for resolving a tie
+	BARCODE_PHASE_DIST = 100000  // maximum distance between consecutive calls of a phase block
+	BARCODE_PHASE_MIN  = 2       // minimum number of shared barcodes for linking a call to a phase block
+	BARCODE_PHASE_FOLD = 2       // minimum ratio of shared barcodes of the linked phase over the other phase
+)
+
+//---------------------------------------------------------------------------------------------------
+// BarcodeIndex represents identifiers of barcodes and placements of their reads.
+//---------------------------------------------------------------------------------------------------
+type BarcodeIndex struct {
+	ids  map[string]uint32 // identifiers of barcodes (from 1, 0: no barcode)
+	bins []map[int]int     // number of placed reads of each barcode in each bin
+	mut  sync.Mutex
+}
+
+// Barcodes of linked reads (nil if reads are not linked reads)
+var BARCODES *BarcodeIndex
+
+// Number of ambiguous reads resolved by co-location of barcodes (updated atomically by aligning goroutines)
+var BARCODE_RESOLVED_NUM int64
+
+//---------------------------------------------------------------------------------------------------
+// SetupBarcodes sets up the barcode index if reads are linked reads.
+//---------------------------------------------------------------------------------------------------
+func SetupBarcodes(linked_reads bool) {
+	BARCODES, BARCODE_RESOLVED_NUM = nil, 0
+	if linked_reads {
+		BARCODES = &BarcodeIndex{ids: make(map[string]uint32), bins: []map[int]int{nil}}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// ParseBarcode returns the barcode of a FASTQ header (the value of its BX:Z: tag), or nil.
+//---------------------------------------------------------------------------------------------------
+func ParseBarcode(info []byte) []byte {
+	for i := bytes.IndexAny(info, " \t"); i >= 0 && i < len(info); {
+		start := i + 1
+		end := bytes.IndexAny(info[start:], " \t")
+		if end < 0 {
+			end = len(info)
+		} else {
+			end += start
+		}
+		if bytes.HasPrefix(info[start:end], []byte(BARCODE_TAG)) {
+			return info[start+len(BARCODE_TAG) : end]
+		}
+		i = end
+	}
+	return nil
+}
+
+//---------------------------------------------------------------------------------------------------
+// ID returns the identifier of the barcode of a read pair (taken from the first end, or from the
+// second end), 0 if the read has no barcode.
+//---------------------------------------------------------------------------------------------------
+func (B *BarcodeIndex) ID(info1, info2 []byte) uint32 {
+	if B == nil {
+		return 0
+	}
+	bc := ParseBarcode(info1)
+	if len(bc) == 0 {
+		bc = ParseBarcode(info2)
+	}
+	if len(bc) == 0 {
+		return 0
+	}
+	B.mut.Lock()
+	defer B.mut.Unlock()
+	id, ok := B.ids[string(bc)]
+	if !ok {
+		id = uint32(len(B.bins))
+		B.ids[string(bc)] = id
+		B.bins = append(B.bins, make(map[int]int))
+	}
+	return id
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds the placement of a read of barcode id at pos.
+//---------------------------------------------------------------------------------------------------
+func (B *BarcodeIndex) Add(id uint32, pos int) {
+	if B == nil || id == 0 {
+		return
+	}
+	B.mut.Lock()
+	B.bins[id][pos/BARCODE_BIN]++
+	B.mut.Unlock()
+}
+
+//---------------------------------------------------------------------------------------------------
+// Support returns the number of placed reads of barcode id in the bin of pos and its neighbor bins.
+//---------------------------------------------------------------------------------------------------
+func (B *BarcodeIndex) Support(id uint32, pos int) int {
+	B.mut.Lock()
+	defer B.mut.Unlock()
+	bin, n := pos/BARCODE_BIN, 0
+	for b := bin - 1; b <= bin+1; b++ {
+		n += B.bins[id][b]
+	}
+	return n
+}
+
+//---------------------------------------------------------------------------------------------------
+// Resolve returns the index of the tied alignment candidate of a read of barcode id which is co-located
+// with at least BARCODE_MIN_READS reads of the barcode, more than any other candidate (-1 if there is
+// no such candidate).
+//---------------------------------------------------------------------------------------------------
+func (B *BarcodeIndex) Resolve(id uint32, cands []*AlnCand) int {
+	if B == nil || id == 0 {
+		return -1
+	}
+	best, best_num, second_num := -1, 0, 0
+	for c, cand := range cands {
+		n := B.Support(id, MinInt(cand.CovStart1, cand.CovStart2))
+		if n > best_num {
+			best, best_num, second_num = c, n, best_num
+		} else if n > second_num {
+			second_num = n
+		}
+	}
+	if best_num < BARCODE_MIN_READS || best_num == second_num {
+		return -1
+	}
+	atomic.AddInt64(&BARCODE_RESOLVED_NUM, 1)
+	return best
+}
+
+//---------------------------------------------------------------------------------------------------
+// LogBarcodes reports numbers of barcodes and of ambiguous reads resolved by barcodes.
+//---------------------------------------------------------------------------------------------------
+func (B *BarcodeIndex) LogBarcodes() {
+	if B == nil {
+		return
+	}
+	log.Printf("Number of barcodes of linked reads:\t%d", len(B.ids))
+	log.Printf("Number of ambiguous reads resolved by co-location of barcodes:\t%d", BARCODE_RESOLVED_NUM)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddBarcode keeps the barcode of the read of an aligned base with the aligned bases of its allele.
+//---------------------------------------------------------------------------------------------------
+func AddBarcode(var_bcs map[string][]uint32, var_info *VarInfo) {
+	if var_info.Barcode == 0 {
+		return
+	}
+	var_str := string(var_info.Bases)
+	var_bcs[var_str] = append(var_bcs[var_str], var_info.Barcode)
+}
+
+//---------------------------------------------------------------------------------------------------
+// PhasedGT represents the phased genotype of a heterozygous call and its phase set.
+//---------------------------------------------------------------------------------------------------
+type PhasedGT struct {
+	GT       string // phased genotype (0|1 or 1|0)
+	PhaseSet int    // position (on its chromosome) of the first call of the phase block
+}
+
+//---------------------------------------------------------------------------------------------------
+// PhaseRegion phases heterozygous calls at sorted positions Var_Pos of a region in order of positions.
+// A call is linked to the phase block of the previous calls if enough barcodes of its alleles are
+// shared with barcodes of the haplotypes of the block, otherwise it starts a new block. Calls of blocks
+// of at least two calls are returned by positions.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) PhaseRegion(Var_Pos []int) map[int]*PhasedGT {
+	var haps [2]map[uint32]bool // barcodes of the two haplotypes of the current block
+	var block []int             // positions of calls of the current block
+	phased := make(map[int]*PhasedGT)
+	block_chr, block_start, last_pos := -1, 0, 0
+	gts := make(map[int]string)
+	end_block := func() {
+		if len(block) >= 2 {
+			for _, pos := range block {
+				phased[pos] = &PhasedGT{gts[pos], block_start}
+			}
+		}
+		block = block[:0]
+	}
+	for _, pos := range Var_Pos {
+		rid := PARA.Proc_num * pos / VC.SeqLen
+		var_bcs := VC.Calls[rid].VarBarcode[uint32(pos)]
+		if var_bcs == nil || IsHaploid(pos) || IsHeteroplasmic(pos) {
+			continue
+		}
+		// Genotype of the call: the genotype with maximum probability
+		var_call, var_call_prob := "", 0.0
+		var_probs, _ := VC.Calls[rid].VarProb.Get(uint32(pos))
+		for i, gt := range var_probs.Gts {
+			if var_probs.Vals[i] > var_call_prob {
+				var_call, var_call_prob = gt, var_probs.Vals[i]
+			}
+		}
+		hap_arr := strings.Split(var_call, "|")
+		if len(hap_arr) != 2 || hap_arr[0] == hap_arr[1] {
+			continue
+		}
+		var alleles [2]map[uint32]bool
+		alleles[0], alleles[1] = make(map[uint32]bool), make(map[uint32]bool)
+		for var_base, bcs := range var_bcs {
+			if a := AlleleIndex(var_base, hap_arr[1]); a >= 0 {
+				for _, bc := range bcs {
+					alleles[a][bc] = true
+				}
+			}
+		}
+		chr_id := sort.SearchInts(VC.ChrPos, pos+1) - 1
+		// cis: the alternative allele is on the second haplotype of the block (0|1), trans: on the first (1|0)
+		cis, trans := 0, 0
+		if len(block) > 0 && chr_id == block_chr && pos-last_pos <= BARCODE_PHASE_DIST {
+			cis = SharedNum(alleles[0], haps[0]) + SharedNum(alleles[1], haps[1])
+			trans = SharedNum(alleles[1], haps[0]) + SharedNum(alleles[0], haps[1])
+		}
+		last_pos = pos
+		if cis >= BARCODE_PHASE_MIN && cis >= BARCODE_PHASE_FOLD*trans {
+			UnionBarcodes(haps[0], alleles[0])
+			UnionBarcodes(haps[1], alleles[1])
+			gts[pos] = "0|1"
+		} else if trans >= BARCODE_PHASE_MIN && trans >= BARCODE_PHASE_FOLD*cis {
+			UnionBarcodes(haps[0], alleles[1])
+			UnionBarcodes(haps[1], alleles[0])
+			gts[pos] = "1|0"
+		} else {
+			end_block()
+			haps, block_chr, block_start = alleles, chr_id, pos+1-VC.ChrPos[chr_id]
+			gts[pos] = "0|1"
+		}
+		block = append(block, pos)
+	}
+	end_block()
+	return phased
+}
+
+//---------------------------------------------------------------------------------------------------
+// SharedNum returns the number of barcodes of a set which are also in another set.
+//---------------------------------------------------------------------------------------------------
+func SharedNum(bcs, other map[uint32]bool) int {
+	n := 0
+	for bc := range bcs {
+		if other[bc] {
+			n++
+		}
+	}
+	return n
+}
+
+//---------------------------------------------------------------------------------------------------
+// UnionBarcodes adds barcodes of a set to another set.
+//---------------------------------------------------------------------------------------------------
+func UnionBarcodes(dst, src map[uint32]bool) {
+	for bc := range src {
+		dst[bc] = true
+	}
+}
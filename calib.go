@@ -0,0 +1,222 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: calib.go
+// Calibration report of QUAL of variant calls. Calls are binned by QUAL, and the accuracy predicted
+// by QUAL (1 - 10^(-QUAL/10), averaged over calls of a bin) is compared with the empirical accuracy of
+// the bin: the fraction of calls in a truth set (within confident regions) if a truth set is given,
+// otherwise the fraction of calls whose allele balance fits their genotypes (a heuristic which needs
+// no truth set). Well-calibrated calls have empirical accuracies close to predicted ones.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	CALIB_BIN_WIDTH = 10  // width of QUAL bins
+	CALIB_BIN_NUM   = 10  // number of QUAL bins (the last bin is open-ended)
+	CALIB_HET_MIN   = 0.2 // minimum allele balance of heterozygous calls fitting their genotypes
+	CALIB_HET_MAX   = 0.8 // maximum allele balance of heterozygous calls fitting their genotypes
+	CALIB_HOM_MIN   = 0.8 // minimum allele balance of homozygous calls fitting their genotypes
+)
+
+//---------------------------------------------------------------------------------------------------
+// CalibBin represents calls of a QUAL bin: numbers of calls and of correct calls, and the sum of
+// accuracies predicted by QUAL.
+//---------------------------------------------------------------------------------------------------
+type CalibBin struct {
+	Num, Correct int
+	PredSum      float64
+}
+
+//---------------------------------------------------------------------------------------------------
+// PredAcc and EmpAcc return predicted and empirical accuracies of calls of a bin (0 if empty).
+//---------------------------------------------------------------------------------------------------
+func (B *CalibBin) PredAcc() float64 {
+	if B.Num == 0 {
+		return 0
+	}
+	return B.PredSum / float64(B.Num)
+}
+
+func (B *CalibBin) EmpAcc() float64 {
+	if B.Num == 0 {
+		return 0
+	}
+	return float64(B.Correct) / float64(B.Num)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AccPhred returns the Phred-scaled error of an accuracy (capped at 1000 for accuracy 1).
+//---------------------------------------------------------------------------------------------------
+func AccPhred(acc float64) float64 {
+	return math.Min(-10*math.Log10(1-acc), 1000)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Calibrate bins variant calls of call_file by QUAL and counts correct calls of bins: calls in the
+// truth set within confident regions (all regions if bed_file is empty) if truth_file is given, calls
+// whose allele balance fits their genotypes otherwise. Homozygous-reference records are ignored.
+//---------------------------------------------------------------------------------------------------
+func Calibrate(call_file, truth_file, bed_file string) []*CalibBin {
+	var regions map[string][][2]int
+	var truths map[string]string
+	if truth_file != "" {
+		if bed_file != "" {
+			regions = LoadEvalRegions(bed_file)
+		}
+		truths = LoadEvalVariants(truth_file, regions, false)
+	}
+	bins := make([]*CalibBin, CALIB_BIN_NUM)
+	for i := range bins {
+		bins[i] = new(CalibBin)
+	}
+	scanner, done := OpenTextInput(call_file)
+	defer done()
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 8 {
+			log.Panicf("Error: invalid line in VCF file %s: %s", call_file, line)
+		}
+		if tokens[4] == "." {
+			continue
+		}
+		qual, e := strconv.ParseFloat(tokens[5], 64)
+		if e != nil {
+			continue
+		}
+		format := make(map[string]string)
+		if len(tokens) > 9 {
+			values := strings.Split(tokens[9], ":")
+			for i, key := range strings.Split(tokens[8], ":") {
+				if i < len(values) {
+					format[key] = values[i]
+				}
+			}
+		}
+		if gt := format["GT"]; gt != "" && strings.Trim(gt, "0/|.") == "" {
+			continue
+		}
+		var correct bool
+		if truths != nil {
+			if pos, e := strconv.Atoi(tokens[1]); e != nil || (regions != nil && !InIntervals(regions[tokens[0]], pos-1)) {
+				continue
+			}
+			correct = IsKnownCall(truths, tokens)
+		} else {
+			fits, ok := AlleleBalanceFits(format["GT"], format["AD"], format["DP"])
+			if !ok {
+				continue
+			}
+			correct = fits
+		}
+		b := MaxInt(0, MinInt(int(math.Min(qual, CALIB_BIN_WIDTH*CALIB_BIN_NUM)/CALIB_BIN_WIDTH), CALIB_BIN_NUM-1))
+		bins[b].Num++
+		bins[b].PredSum += 1 - math.Pow(10, -qual/10)
+		if correct {
+			bins[b].Correct++
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return bins
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlleleBalanceFits checks if the allele balance of a call fits its genotype gt: heterozygous calls
+// have balances in [CALIB_HET_MIN, CALIB_HET_MAX], homozygous (and haploid) calls at least
+// CALIB_HOM_MIN. AD is the depth of the alternative allele(s) with depth DP, or depths of all alleles
+// (reference first). It returns false as the second value if the balance cannot be computed.
+//---------------------------------------------------------------------------------------------------
+func AlleleBalanceFits(gt, ad, dp string) (bool, bool) {
+	if gt == "" || ad == "" {
+		return false, false
+	}
+	depths := strings.Split(ad, ",")
+	alt_depth, depth := 0, 0
+	for i, d := range depths {
+		n, e := strconv.Atoi(d)
+		if e != nil {
+			return false, false
+		}
+		if i > 0 || len(depths) == 1 {
+			alt_depth += n
+		}
+		depth += n
+	}
+	if len(depths) == 1 {
+		var e error
+		if depth, e = strconv.Atoi(dp); e != nil {
+			return false, false
+		}
+	}
+	if depth <= 0 {
+		return false, false
+	}
+	balance := float64(alt_depth) / float64(depth)
+	alleles := strings.FieldsFunc(gt, func(r rune) bool { return r == '/' || r == '|' })
+	if len(alleles) == 2 && alleles[0] != alleles[1] {
+		return balance >= CALIB_HET_MIN && balance <= CALIB_HET_MAX, true
+	}
+	return balance >= CALIB_HOM_MIN, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteCalibReport writes calls, predicted and empirical accuracies and Phred scores of QUAL bins in
+// tab-delimited format, followed by the expected calibration error (mean absolute difference of
+// predicted and empirical accuracies of bins, weighted by numbers of calls).
+//---------------------------------------------------------------------------------------------------
+func WriteCalibReport(w io.Writer, bins []*CalibBin, with_truth bool) {
+	if with_truth {
+		fmt.Fprintln(w, "#Empirical accuracy: fraction of calls in the truth set")
+	} else {
+		fmt.Fprintln(w, "#Empirical accuracy: fraction of calls whose allele balance fits their genotypes")
+	}
+	fmt.Fprintln(w, "QUAL\tCALLS\tCORRECT\tPRED_ACC\tEMP_ACC\tPRED_PHRED\tEMP_PHRED")
+	total, ece := 0, 0.0
+	for i, b := range bins {
+		qual_range := strconv.Itoa(i*CALIB_BIN_WIDTH) + "-" + strconv.Itoa((i+1)*CALIB_BIN_WIDTH)
+		if i == len(bins)-1 {
+			qual_range = ">=" + strconv.Itoa(i*CALIB_BIN_WIDTH)
+		}
+		if b.Num == 0 {
+			fmt.Fprintf(w, "%s\t0\t0\t.\t.\t.\t.\n", qual_range)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.4f\t%.4f\t%.1f\t%.1f\n", qual_range, b.Num, b.Correct, b.PredAcc(), b.EmpAcc(),
+			AccPhred(b.PredAcc()), AccPhred(b.EmpAcc()))
+		total += b.Num
+		ece += float64(b.Num) * math.Abs(b.PredAcc()-b.EmpAcc())
+	}
+	if total > 0 {
+		ece /= float64(total)
+	}
+	fmt.Fprintf(w, "ECE\t%d\t.\t.\t%.4f\t.\t.\n", total, ece)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteCalibration writes the calibration report of variant calls of call_file to report_file.
+//---------------------------------------------------------------------------------------------------
+func WriteCalibration(call_file, truth_file, bed_file, report_file string) {
+	bins := Calibrate(call_file, truth_file, bed_file)
+	f, e := os.Create(report_file)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	WriteCalibReport(f, bins, truth_file != "")
+	log.Printf("Calibration report of QUAL is written to:\t%s", report_file)
+}
@@ -0,0 +1,196 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: caller/like.go
+// Order-independent likelihoods of genotypes. The likelihood of an aligned base given a genotype only
+// depends on whether the key allele of the base is on both, one or none of the two haplotypes, so log10
+// likelihoods of aligned bases are summed per key allele for these three cases. Sums are kept in fixed
+// point, so they do not depend on the order in which reads are added. Most locations only get key
+// alleles A, C, G and T, their sums are kept in a fixed-size array indexed by bases, so updating them
+// with an aligned base needs neither a string key nor a map lookup; a map of other key alleles (indels)
+// is only created when such alleles appear at the location. The model does not depend on alignments or
+// parameters of the variant caller, it only gets likelihoods of aligned bases and priors of genotypes.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package caller
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LIKE_SCALE    = 1e9   // fixed-point scale of sums of log10 likelihoods
+	MIN_READ_LIKE = 1e-10 // minimum likelihood of an aligned base given a genotype (used for PL)
+)
+
+// Key alleles of the fixed-size array of SiteLike
+var SNV_ALLELES = [4]string{"A", "C", "G", "T"}
+
+// Indexes of bases in the fixed-size array of SiteLike (-1: other key alleles)
+var snv_code = func() [256]int8 {
+	var code [256]int8
+	for i := range code {
+		code[i] = -1
+	}
+	for c, allele := range SNV_ALLELES {
+		code[allele[0]] = int8(c)
+	}
+	return code
+}()
+
+//---------------------------------------------------------------------------------------------------
+// AlleleLike represents sums of log10 likelihoods (in fixed point) of aligned bases of a key allele,
+// given genotypes whose both, one or none of haplotypes are the key allele.
+//---------------------------------------------------------------------------------------------------
+type AlleleLike struct {
+	Both, One, None int64
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds the likelihoods of an aligned base, the likelihood given genotypes with one haplotype of the
+// key allele is the average of the other two; likelihoods of reads with tied alignments are shared
+// among the alignments. Sums are updated atomically, so aligned bases of a key allele can be added
+// concurrently without holding the lock of the variant location.
+//---------------------------------------------------------------------------------------------------
+func (A *AlleleLike) Add(p_both, p_none float64, tie_num int) {
+	share := 1.0
+	if tie_num > 1 {
+		share = 1 / float64(tie_num)
+	}
+	atomic.AddInt64(&A.Both, ScaledLike(p_both, share))
+	atomic.AddInt64(&A.One, ScaledLike(p_both/2.0+p_none/2.0, share))
+	atomic.AddInt64(&A.None, ScaledLike(p_none, share))
+}
+
+//---------------------------------------------------------------------------------------------------
+// ScaledLike returns the fixed-point log10 of a (shared) likelihood.
+//---------------------------------------------------------------------------------------------------
+func ScaledLike(p, share float64) int64 {
+	return int64(math.Round(math.Log10(math.Max(math.Pow(p, share), MIN_READ_LIKE)) * LIKE_SCALE))
+}
+
+//---------------------------------------------------------------------------------------------------
+// SiteLike represents sums of likelihoods of aligned bases of key alleles at a variant location.
+//---------------------------------------------------------------------------------------------------
+type SiteLike struct {
+	SNV   [4]AlleleLike          // sums of aligned bases of key alleles A, C, G and T
+	mask  uint8                  // bits of key alleles A, C, G and T with aligned bases
+	Other map[string]*AlleleLike // sums of aligned bases of other key alleles (nil until they appear)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Allele returns the sums of a key allele, which are added if the allele has no aligned bases yet.
+//---------------------------------------------------------------------------------------------------
+func (L *SiteLike) Allele(key string) *AlleleLike {
+	if len(key) == 1 {
+		if c := snv_code[key[0]]; c >= 0 {
+			L.mask |= 1 << uint(c)
+			return &L.SNV[c]
+		}
+	}
+	if L.Other == nil {
+		L.Other = make(map[string]*AlleleLike)
+	}
+	a, allele_exist := L.Other[key]
+	if !allele_exist {
+		a = new(AlleleLike)
+		L.Other[key] = a
+	}
+	return a
+}
+
+//---------------------------------------------------------------------------------------------------
+// Each calls f with each key allele with aligned bases and its sums.
+//---------------------------------------------------------------------------------------------------
+func (L *SiteLike) Each(f func(key string, a *AlleleLike)) {
+	if L == nil {
+		return
+	}
+	for c := 0; c < 4; c++ {
+		if L.mask&(1<<uint(c)) != 0 {
+			f(SNV_ALLELES[c], &L.SNV[c])
+		}
+	}
+	for key, a := range L.Other {
+		f(key, a)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Map returns sums of key alleles as a map (used in variant call states).
+//---------------------------------------------------------------------------------------------------
+func (L *SiteLike) Map() map[string]*AlleleLike {
+	m := make(map[string]*AlleleLike)
+	L.Each(func(key string, a *AlleleLike) {
+		m[key] = &AlleleLike{a.Both, a.One, a.None}
+	})
+	return m
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddMap adds sums of key alleles given as a map.
+//---------------------------------------------------------------------------------------------------
+func (L *SiteLike) AddMap(src map[string]*AlleleLike) {
+	for key, like := range src {
+		a := L.Allele(key)
+		a.Both += like.Both
+		a.One += like.One
+		a.None += like.None
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// GenotypeLike returns the log10 likelihood of aligned bases given a genotype.
+//---------------------------------------------------------------------------------------------------
+func GenotypeLike(allele_like *SiteLike, gt string) float64 {
+	hap_arr := strings.Split(gt, "|")
+	var sum int64
+	allele_like.Each(func(key string, a *AlleleLike) {
+		if key == hap_arr[0] && key == hap_arr[1] {
+			sum += a.Both
+		} else if key != hap_arr[0] && key != hap_arr[1] {
+			sum += a.None
+		} else {
+			sum += a.One
+		}
+	})
+	return float64(sum) / LIKE_SCALE
+}
+
+//---------------------------------------------------------------------------------------------------
+// Genotypes returns genotypes of priors in sorted order.
+//---------------------------------------------------------------------------------------------------
+func Genotypes(priors map[string]float64) []string {
+	gts := make([]string, 0, len(priors))
+	for gt, _ := range priors {
+		gts = append(gts, gt)
+	}
+	sort.Strings(gts)
+	return gts
+}
+
+//---------------------------------------------------------------------------------------------------
+// Posteriors turns prior probabilities of genotypes into posterior probabilities given sums of
+// likelihoods of aligned bases (in place), and returns log10 likelihoods of the genotypes. Genotypes
+// are processed in sorted order, so that posteriors do not depend on the order of map iteration.
+//---------------------------------------------------------------------------------------------------
+func Posteriors(priors map[string]float64, allele_like *SiteLike) map[string]float64 {
+	gts := Genotypes(priors)
+	like := make(map[string]float64)
+	max_post := math.Inf(-1)
+	for _, gt := range gts {
+		like[gt] = GenotypeLike(allele_like, gt)
+		max_post = math.Max(max_post, math.Log10(priors[gt])+like[gt])
+	}
+	post_sum := 0.0
+	for _, gt := range gts {
+		priors[gt] = math.Pow(10, math.Log10(priors[gt])+like[gt]-max_post)
+		post_sum += priors[gt]
+	}
+	for _, gt := range gts {
+		priors[gt] /= post_sum
+	}
+	return like
+}
@@ -0,0 +1,161 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: caller/prior.go
+// Prior probabilities of genotypes at variant locations. Priors at a location form a normalized
+// distribution over genotypes of known alleles (from allele frequencies in the variant profile),
+// and genotypes of novel SNPs and novel indels (from rates of novel alleles, see Rates). Haploid
+// locations only have homozygous genotypes.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package caller
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// Rates gives prior probabilities of novel alleles, given bases of the reference and a novel allele.
+//---------------------------------------------------------------------------------------------------
+type Rates interface {
+	NovelRate(ref_base, read_base string) float64
+}
+
+//---------------------------------------------------------------------------------------------------
+// FixedRates are rates of novel SNPs and indels which do not depend on bases.
+//---------------------------------------------------------------------------------------------------
+type FixedRates struct {
+	SNP, Indel float64
+}
+
+//---------------------------------------------------------------------------------------------------
+// NovelRate returns the novel SNP rate for substitutions, the novel indel rate for indels.
+//---------------------------------------------------------------------------------------------------
+func (R FixedRates) NovelRate(ref_base, read_base string) float64 {
+	if len(ref_base) == len(read_base) {
+		return R.SNP
+	}
+	return R.Indel
+}
+
+//---------------------------------------------------------------------------------------------------
+// KnownGenotypePriors returns prior probabilities of genotypes of a known biallelic variant from
+// allele frequencies of the reference and alternative alleles. Priors are normalized to sum to 1;
+// if allele frequencies are not available, priors of a novel variant (from rates) are used. Priors from allele
+// frequencies are tempered by the exponent trust (see TemperPriors).
+//---------------------------------------------------------------------------------------------------
+func KnownGenotypePriors(ref, alt string, af []float32, trust float64, rates Rates) map[string]float64 {
+	priors := make(map[string]float64)
+	if len(af) < 2 || af[0]+af[1] <= 0 {
+		priors[ref+"|"+ref] = 1
+		AddNovelAllele(priors, alt, false, rates.NovelRate(ref, alt))
+		return priors
+	}
+	af_sum := float64(af[0] + af[1])
+	priors[ref+"|"+ref] = float64(af[0]) * 2.0 / 3.0 / af_sum
+	priors[ref+"|"+alt] = (float64(af[0])/3.0 + float64(af[1])/3.0) / af_sum
+	priors[alt+"|"+alt] = float64(af[1]) * 2.0 / 3.0 / af_sum
+	TemperPriors(priors, trust)
+	return priors
+}
+
+//---------------------------------------------------------------------------------------------------
+// TemperPriors raises prior probabilities of genotypes to the power trust and renormalizes them:
+// trust 1 keeps priors, smaller values flatten them (0: all genotypes are equally likely), so that
+// aligned reads weigh more against allele frequencies of population-mismatched or low-quality
+// variant profiles.
+//---------------------------------------------------------------------------------------------------
+func TemperPriors(priors map[string]float64, trust float64) {
+	if trust == 1 {
+		return
+	}
+	prob_sum := 0.0
+	for gt, p := range priors {
+		priors[gt] = math.Pow(p, trust)
+		prob_sum += priors[gt]
+	}
+	if prob_sum <= 0 {
+		return
+	}
+	for gt, p := range priors {
+		priors[gt] = p / prob_sum
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// NovelGenotypePriors returns prior probabilities of genotypes at a location without known variants,
+// given bases of the reference and a novel allele obtained from an aligned read, and rates of novel
+// alleles.
+// For deletions, the reference allele is the base before the deletion (the shorter one).
+//---------------------------------------------------------------------------------------------------
+func NovelGenotypePriors(ref_base, read_base string, rates Rates) map[string]float64 {
+	priors := make(map[string]float64)
+	if len(ref_base) > len(read_base) { // DEL
+		priors[read_base+"|"+read_base] = 1
+		AddNovelAllele(priors, ref_base, true, rates.NovelRate(ref_base, read_base))
+	} else { // SUB or INS
+		priors[ref_base+"|"+ref_base] = 1
+		AddNovelAllele(priors, read_base, false, rates.NovelRate(ref_base, read_base))
+	}
+	return priors
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddNovelAllele adds genotypes of a novel allele to prior probabilities of genotypes at a location,
+// keeping them normalized. Existing genotypes are scaled by (1 - 1.5*rate); heterozygous genotypes of
+// the novel allele get total probability rate (shared by existing alleles according to their
+// frequencies), and the homozygous genotype of the novel allele gets probability 0.5*rate.
+// Keys of heterozygous genotypes are "novel|allele" if novel_first is true, "allele|novel" otherwise.
+//---------------------------------------------------------------------------------------------------
+func AddNovelAllele(priors map[string]float64, novel string, novel_first bool, rate float64) {
+	allele_freq := make(map[string]float64)
+	prob_sum := 0.0
+	// Genotypes are summed in sorted order, so that priors do not depend on the order of map iteration
+	gts := make([]string, 0, len(priors))
+	for gt, _ := range priors {
+		gts = append(gts, gt)
+	}
+	sort.Strings(gts)
+	for _, gt := range gts {
+		p := priors[gt]
+		hap_arr := strings.Split(gt, "|")
+		allele_freq[hap_arr[0]] += p / 2
+		allele_freq[hap_arr[1]] += p / 2
+		prob_sum += p
+	}
+	if _, exist := allele_freq[novel]; exist || prob_sum <= 0 {
+		return
+	}
+	for gt, p := range priors {
+		priors[gt] = p / prob_sum * (1 - 1.5*rate)
+	}
+	for allele, f := range allele_freq {
+		if novel_first {
+			priors[novel+"|"+allele] = rate * f / prob_sum
+		} else {
+			priors[allele+"|"+novel] = rate * f / prob_sum
+		}
+	}
+	priors[novel+"|"+novel] = 0.5 * rate
+}
+
+//---------------------------------------------------------------------------------------------------
+// HaploidPriors sets priors of heterozygous genotypes to 0 and normalizes priors of the others.
+//---------------------------------------------------------------------------------------------------
+func HaploidPriors(priors map[string]float64) {
+	prob_sum := 0.0
+	for gt, p := range priors {
+		if hap_arr := strings.Split(gt, "|"); hap_arr[0] != hap_arr[1] {
+			priors[gt] = 0
+		} else {
+			prob_sum += p
+		}
+	}
+	if prob_sum <= 0 {
+		return
+	}
+	for gt, p := range priors {
+		priors[gt] = p / prob_sum
+	}
+}
@@ -0,0 +1,175 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: cnv.go
+// Coverage-based copy number variant candidates. Numbers of aligned bases are accumulated in windows
+// of the multigenome while calling variants. After calling, log2 ratios of window depths to the
+// genome-wide median depth are segmented (binary segmentation) on each chromosome, and segments
+// whose estimated copy numbers differ from 2 are reported as CNV candidate regions.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CNV_WINDOW      = 1000 // size of windows for accumulating depth
+	CNV_MIN_WINDOWS = 3    // minimum number of windows of a segment
+	CNV_MIN_T       = 5.0  // minimum t-statistic of a change point between two segments
+)
+
+//---------------------------------------------------------------------------------------------------
+// Number of aligned bases in each window of the multigenome (allocated if CNV report is required).
+//---------------------------------------------------------------------------------------------------
+var WindowDepth []uint32
+
+//---------------------------------------------------------------------------------------------------
+// AddWindowCoverage adds bases of an aligned read to windows covered by the read.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddWindowCoverage(start, length int) {
+	end := MinInt(start+length, VC.SeqLen)
+	start = MaxInt(start, 0)
+	MUT.Lock()
+	for start < end {
+		win := start / CNV_WINDOW
+		win_end := MinInt(end, (win+1)*CNV_WINDOW)
+		WindowDepth[win] += uint32(win_end - start)
+		start = win_end
+	}
+	MUT.Unlock()
+}
+
+//---------------------------------------------------------------------------------------------------
+// CNVSegment represents a segment of consecutive windows of a chromosome with similar depth.
+//---------------------------------------------------------------------------------------------------
+type CNVSegment struct {
+	Start, End int     // first and last windows (inclusive)
+	MeanDepth  float64 // mean depth of windows
+	Log2Ratio  float64 // mean log2 ratio of window depths to the median depth
+	CopyNum    int     // estimated copy number
+}
+
+//---------------------------------------------------------------------------------------------------
+// CNVSegments segments window depths of all chromosomes and returns segments of each chromosome.
+// Windows which are not entirely inside a chromosome are not used.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CNVSegments() [][]*CNVSegment {
+	depths := make([]float64, 0, len(WindowDepth))
+	for _, bases := range WindowDepth {
+		if bases > 0 {
+			depths = append(depths, float64(bases)/CNV_WINDOW)
+		}
+	}
+	if len(depths) == 0 {
+		return nil
+	}
+	sort.Float64s(depths)
+	median := depths[len(depths)/2]
+	chr_segs := make([][]*CNVSegment, len(VC.ChrPos))
+	for chr_id, chr_start := range VC.ChrPos {
+		chr_end := VC.SeqLen
+		if chr_id+1 < len(VC.ChrPos) {
+			chr_end = VC.ChrPos[chr_id+1]
+		}
+		first, last := (chr_start+CNV_WINDOW-1)/CNV_WINDOW, chr_end/CNV_WINDOW-1
+		if last-first+1 < CNV_MIN_WINDOWS {
+			continue
+		}
+		ratios := make([]float64, last-first+1)
+		for w := first; w <= last; w++ {
+			ratios[w-first] = math.Log2((float64(WindowDepth[w])/CNV_WINDOW + 0.5) / (median + 0.5))
+		}
+		bounds := make([][2]int, 0)
+		SegmentRatios(ratios, 0, len(ratios), NoiseVariance(ratios), &bounds)
+		sort.Slice(bounds, func(i, j int) bool { return bounds[i][0] < bounds[j][0] })
+		for _, b := range bounds {
+			seg := &CNVSegment{Start: first + b[0], End: first + b[1] - 1}
+			for w := seg.Start; w <= seg.End; w++ {
+				seg.MeanDepth += float64(WindowDepth[w]) / CNV_WINDOW
+				seg.Log2Ratio += ratios[w-first]
+			}
+			seg.MeanDepth /= float64(b[1] - b[0])
+			seg.Log2Ratio /= float64(b[1] - b[0])
+			seg.CopyNum = int(math.Floor(2*math.Pow(2, seg.Log2Ratio) + 0.5))
+			chr_segs[chr_id] = append(chr_segs[chr_id], seg)
+		}
+	}
+	return chr_segs
+}
+
+//---------------------------------------------------------------------------------------------------
+// NoiseVariance estimates variance of noise of ratios from median absolute differences of adjacent
+// values, which are not affected by change points.
+//---------------------------------------------------------------------------------------------------
+func NoiseVariance(ratios []float64) float64 {
+	diffs := make([]float64, 0, len(ratios))
+	for i := 1; i < len(ratios); i++ {
+		diffs = append(diffs, math.Abs(ratios[i]-ratios[i-1]))
+	}
+	if len(diffs) == 0 {
+		return 1e-6
+	}
+	sort.Float64s(diffs)
+	sd := diffs[len(diffs)/2] / (0.6745 * math.Sqrt2)
+	return math.Max(sd*sd, 1e-6)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SegmentRatios recursively splits ratios in [start, end) at the change point with the largest
+// t-statistic (if it is at least CNV_MIN_T) and appends bounds of final segments.
+//---------------------------------------------------------------------------------------------------
+func SegmentRatios(ratios []float64, start, end int, variance float64, bounds *[][2]int) {
+	n := end - start
+	sum := make([]float64, n+1)
+	for i := 0; i < n; i++ {
+		sum[i+1] = sum[i] + ratios[start+i]
+	}
+	best_k, best_t := -1, CNV_MIN_T
+	for k := CNV_MIN_WINDOWS; k <= n-CNV_MIN_WINDOWS; k++ {
+		n_l, n_r := float64(k), float64(n-k)
+		diff := sum[k]/n_l - (sum[n]-sum[k])/n_r
+		if t := math.Abs(diff) / math.Sqrt(variance*(1/n_l+1/n_r)); t >= best_t {
+			best_k, best_t = k, t
+		}
+	}
+	if best_k < 0 {
+		*bounds = append(*bounds, [2]int{start, end})
+		return
+	}
+	SegmentRatios(ratios, start, start+best_k, variance, bounds)
+	SegmentRatios(ratios, start+best_k, end, variance, bounds)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteCNVCandidates writes segments whose copy numbers differ from 2 to a tab-delimited file.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteCNVCandidates(file_name string) {
+	w := CreateOutput(file_name)
+	defer w.Close()
+	w.WriteString("#CHROM\tSTART\tEND\tWINDOWS\tMEAN_DEPTH\tLOG2_RATIO\tCN\tTYPE\n")
+	cnv_num := 0
+	for chr_id, segs := range VC.CNVSegments() {
+		for _, seg := range segs {
+			if seg.CopyNum == 2 {
+				continue
+			}
+			cnv_type := "DUP"
+			if seg.CopyNum < 2 {
+				cnv_type = "DEL"
+			}
+			chr_start := VC.ChrPos[chr_id]
+			w.WriteString(strings.Join([]string{string(VC.ChrName[chr_id]), strconv.Itoa(seg.Start*CNV_WINDOW - chr_start + 1),
+				strconv.Itoa((seg.End+1)*CNV_WINDOW - chr_start), strconv.Itoa(seg.End - seg.Start + 1),
+				strconv.FormatFloat(seg.MeanDepth, 'f', 2, 64), strconv.FormatFloat(seg.Log2Ratio, 'f', 3, 64),
+				strconv.Itoa(seg.CopyNum), cnv_type}, "\t") + "\n")
+			cnv_num++
+		}
+	}
+	w.Flush()
+	log.Printf("Number of CNV candidate regions:\t%d, written to:\t%s", cnv_num, file_name)
+}
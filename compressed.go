@@ -0,0 +1,45 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: compressed.go
+// Gzip-compressed inputs. The multigenome (.mgf and its .idx file), the variant profile index and the
+// reference genome and variant profile of indexing can be stored gzip-compressed: if a file does not
+// exist, the file with the suffix .gz is used instead, and gzip-compressed files (detected by their
+// magic bytes, see seqio/gzip.go) are decompressed on load.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"io"
+	"os"
+
+	"github.com/namsyvo/IVC/seqio"
+)
+
+//---------------------------------------------------------------------------------------------------
+// CompressedName returns the name of the file with the suffix .gz if a local file does not exist but
+// its compressed file does, the name unchanged otherwise.
+//---------------------------------------------------------------------------------------------------
+func CompressedName(file_name string) string {
+	if IsRemote(file_name) {
+		return file_name
+	}
+	if _, e := os.Stat(file_name); os.IsNotExist(e) {
+		if _, e = os.Stat(file_name + ".gz"); e == nil {
+			return file_name + ".gz"
+		}
+	}
+	return file_name
+}
+
+//---------------------------------------------------------------------------------------------------
+// OpenCompressedInput opens a (local or remote) input file for reading, it is decompressed if it is
+// gzip-compressed, and its compressed file is used if it does not exist.
+//---------------------------------------------------------------------------------------------------
+func OpenCompressedInput(file_name string) (io.ReadCloser, error) {
+	f, e := OpenInput(CompressedName(file_name))
+	if e != nil {
+		return nil, e
+	}
+	return seqio.Decompress(f)
+}
@@ -0,0 +1,154 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: contam.go
+// Cross-sample contamination estimation. At known biallelic SNPs with common alleles, reads of the
+// other allele at sites where the sample is homozygous come from sequencing errors or from a
+// contaminating sample, whose alleles follow allele frequencies of the variant profile (blended with
+// population frequencies). The contamination fraction is estimated by maximum likelihood from these
+// allele balances; genotype likelihoods can be adjusted for it by removing the expected contaminant
+// share of aligned bases of each allele at known variant locations before posteriors are computed.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+
+	"github.com/namsyvo/IVC/caller"
+)
+
+const (
+	CONTAM_MIN_DEPTH = 10    // minimum number of aligned reads of a site used for estimation
+	CONTAM_MAX_MINOR = 0.2   // maximum fraction of reads of the other allele at homozygous sites
+	CONTAM_MIN_AF    = 0.05  // minimum allele frequency of alleles of sites used for estimation
+	CONTAM_MIN_SITES = 100   // minimum number of homozygous sites for an estimate
+	CONTAM_MAX       = 0.5   // maximum contamination fraction
+	CONTAM_STEP      = 0.001 // step of the grid of contamination fractions
+	CONTAM_AF_BINS   = 100   // number of bins of expected allele frequencies of contaminant reads
+)
+
+// Estimated contamination fraction (0: not estimated or no contamination)
+var CONTAM_FRAC float64
+
+//---------------------------------------------------------------------------------------------------
+// KnownAltAF returns the reference and alternative alleles of a known biallelic SNP and the frequency
+// of the alternative allele (frequencies of the variant profile blended with the population frequency
+// if available); false if the location is not a biallelic SNP or has no frequencies.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) KnownAltAF(pos int) (string, string, float64, bool) {
+	var_prof, is_known_var := VC.Variants[pos]
+	if !is_known_var || len(var_prof) != 2 || len(var_prof[0]) != 1 || len(var_prof[1]) != 1 {
+		return "", "", 0, false
+	}
+	af := VC.VarAF[pos]
+	if pop_af, ok := POP_AF[pos][string(var_prof[1])]; ok {
+		af = BlendAF(af, pop_af, PARA.AF_weight)
+	}
+	if len(af) < 2 || af[0]+af[1] <= 0 {
+		return "", "", 0, false
+	}
+	return string(var_prof[0]), string(var_prof[1]), float64(af[1] / (af[0] + af[1])), true
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateContamination estimates the contamination fraction from numbers of aligned reads of the
+// reference and alternative alleles at homozygous known SNPs. A read of the other allele at a
+// homozygous site is expected with probability c*q + (1-c)*e, where q is the frequency of the other
+// allele in the population and e is the rate of sequencing errors to a given base; sites are grouped
+// by q, and the binomial likelihood of the groups is maximized over a grid of fractions c.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) EstimateContamination() float64 {
+	var other_num, read_num [CONTAM_AF_BINS]float64
+	site_num := 0
+	for rid := 0; rid < PARA.Proc_num; rid++ {
+		VC.Calls[rid].Sites.EachAligned(func(pos uint32, site *CallSite) {
+			ref, alt, f, ok := VC.KnownAltAF(int(pos))
+			if !ok || f < CONTAM_MIN_AF || f > 1-CONTAM_MIN_AF {
+				return
+			}
+			ref_num, alt_num := site.RNum.Get(ref+"|"+ref), site.RNum.Get(ref+"|"+alt)
+			n := ref_num + alt_num
+			if n < CONTAM_MIN_DEPTH {
+				return
+			}
+			k, q := alt_num, f
+			if float64(alt_num) > float64(n)*(1-CONTAM_MAX_MINOR) { // homozygous ALT
+				k, q = ref_num, 1-f
+			} else if float64(alt_num) > float64(n)*CONTAM_MAX_MINOR { // heterozygous
+				return
+			}
+			bin := MinInt(int(q*CONTAM_AF_BINS), CONTAM_AF_BINS-1)
+			other_num[bin] += float64(k)
+			read_num[bin] += float64(n)
+			site_num++
+		})
+	}
+	SUMMARY.ContamSiteNum = int64(site_num)
+	if site_num < CONTAM_MIN_SITES {
+		log.Printf("Too few homozygous known SNPs (%d) for estimating contamination, it is not estimated.", site_num)
+		return 0
+	}
+	e := math.Max(float64(PARA.Err_rate)/3.0, MIN_ERR_RATE)
+	best_c, best_like := 0.0, math.Inf(-1)
+	for i := 0; float64(i)*CONTAM_STEP <= CONTAM_MAX; i++ {
+		c := float64(i) * CONTAM_STEP
+		like := 0.0
+		for bin := 0; bin < CONTAM_AF_BINS; bin++ {
+			if read_num[bin] == 0 {
+				continue
+			}
+			q := (float64(bin) + 0.5) / CONTAM_AF_BINS
+			p := c*q + (1-c)*e
+			like += other_num[bin]*math.Log(p) + (read_num[bin]-other_num[bin])*math.Log(1-p)
+		}
+		if like > best_like {
+			best_c, best_like = c, like
+		}
+	}
+	log.Printf("Number of homozygous known SNPs used for estimating contamination:\t%d", site_num)
+	log.Printf("Estimated contamination fraction:\t%g", best_c)
+	return best_c
+}
+
+//---------------------------------------------------------------------------------------------------
+// ContamAdjustedLike returns sums of likelihoods of aligned bases at a known biallelic SNP with the
+// expected share of contaminant reads of each allele removed (c*depth*q reads of an allele with
+// frequency q), given numbers of aligned reads of alleles. Sums are returned unchanged at other
+// locations or if no contamination is estimated.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ContamAdjustedLike(pos uint32, like *caller.SiteLike, var_num map[string]int) *caller.SiteLike {
+	ref, alt, f, ok := VC.KnownAltAF(int(pos))
+	if CONTAM_FRAC == 0 || !ok {
+		return like
+	}
+	depth := 0
+	for _, n := range var_num {
+		depth += n
+	}
+	keep := map[string]float64{
+		ref: ContamKeep(var_num[ref+"|"+ref], CONTAM_FRAC*float64(depth)*(1-f)),
+		alt: ContamKeep(var_num[ref+"|"+alt], CONTAM_FRAC*float64(depth)*f),
+	}
+	adj_like := new(caller.SiteLike)
+	like.Each(func(key string, a *caller.AlleleLike) {
+		w, ok := keep[key]
+		if !ok {
+			w = 1
+		}
+		*adj_like.Allele(key) = caller.AlleleLike{Both: int64(math.Round(float64(a.Both) * w)),
+			One: int64(math.Round(float64(a.One) * w)), None: int64(math.Round(float64(a.None) * w))}
+	})
+	return adj_like
+}
+
+//---------------------------------------------------------------------------------------------------
+// ContamKeep returns the fraction of aligned reads of an allele kept after removing the expected
+// number of its contaminant reads.
+//---------------------------------------------------------------------------------------------------
+func ContamKeep(read_num int, contam_num float64) float64 {
+	if read_num == 0 {
+		return 1
+	}
+	return math.Max(1-contam_num/float64(read_num), 0)
+}
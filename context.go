@@ -0,0 +1,262 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: context.go
+// Context-aware sequencing error model. Indel error rates depend on length of the homopolymer at
+// the reference position, substitution error rates depend on the reference dinucleotide (preceding
+// base and base at the position). Factors multiply the base error rates in variant probability
+// updates and reduce gap open and substitution costs in alignment (costs are -log10 of rates).
+// Factors are taken from a default table or a table file, which can be learned from a previous run.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_HOMOPOLYMER_LEN  = 10     // homopolymers longer than this length are considered as this length
+	STD_BASES            = "ACGT" // standard bases of dinucleotide contexts
+	CONTEXT_REF_FRACTION = 0.8    // minimum fraction of reads agreeing with the reference at sites used for learning
+	CONTEXT_PSEUDO_COUNT = 10     // number of pseudo reads with the baseline error rate added to each context when learning
+)
+
+//---------------------------------------------------------------------------------------------------
+// ContextModel represents factors of sequencing error rates in reference contexts.
+//---------------------------------------------------------------------------------------------------
+type ContextModel struct {
+	HPFactor []float64          // indel error factors indexed by homopolymer length (1..MAX_HOMOPOLYMER_LEN)
+	DNFactor map[string]float64 // substitution error factors of dinucleotides (missing ones are 1)
+}
+
+// Context error model used for alignment and variant probability updates (nil: context-free model)
+var CONTEXT *ContextModel
+
+//---------------------------------------------------------------------------------------------------
+// DefaultContextModel returns the default table: indel errors increase roughly exponentially with
+// homopolymer length (saturating for long homopolymers), substitution errors are elevated after GG.
+//---------------------------------------------------------------------------------------------------
+func DefaultContextModel() *ContextModel {
+	C := new(ContextModel)
+	C.HPFactor = []float64{1, 1, 1.5, 3, 6, 10, 15, 20, 30, 40, 50}
+	C.DNFactor = map[string]float64{"GG": 2}
+	return C
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadContextModel reads a context table file. Each line is "HP<tab>length<tab>factor" or
+// "DN<tab>dinucleotide<tab>factor"; contexts which are not given take factors from the default table.
+//---------------------------------------------------------------------------------------------------
+func LoadContextModel(file_name string) *ContextModel {
+	C := DefaultContextModel()
+	f, e := os.Open(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) != 3 {
+			log.Panicf("Error: invalid line in context table %s: %s", file_name, line)
+		}
+		factor, e := strconv.ParseFloat(tokens[2], 64)
+		if e != nil || factor <= 0 {
+			log.Panicf("Error: invalid factor in context table %s: %s", file_name, line)
+		}
+		switch tokens[0] {
+		case "HP":
+			hp_len, e := strconv.Atoi(tokens[1])
+			if e != nil || hp_len < 1 || hp_len > MAX_HOMOPOLYMER_LEN {
+				log.Panicf("Error: invalid homopolymer length in context table %s: %s", file_name, line)
+			}
+			C.HPFactor[hp_len] = factor
+		case "DN":
+			C.DNFactor[strings.ToUpper(tokens[1])] = factor
+		default:
+			log.Panicf("Error: invalid context type in context table %s: %s", file_name, line)
+		}
+	}
+	if e = scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return C
+}
+
+//---------------------------------------------------------------------------------------------------
+// Save writes the context table to a file (in the format read by LoadContextModel). Factors of all
+// dinucleotides are written (1 for missing ones), so that they do not take factors of the default table
+// when the file is loaded.
+//---------------------------------------------------------------------------------------------------
+func (C *ContextModel) Save(file_name string) {
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString("#TYPE\tCONTEXT\tFACTOR\n")
+	for hp_len := 1; hp_len <= MAX_HOMOPOLYMER_LEN; hp_len++ {
+		w.WriteString("HP\t" + strconv.Itoa(hp_len) + "\t" + strconv.FormatFloat(C.HPFactor[hp_len], 'f', 4, 64) + "\n")
+	}
+	for _, b1 := range STD_BASES {
+		for _, b2 := range STD_BASES {
+			factor, ok := C.DNFactor[string(b1)+string(b2)]
+			if !ok {
+				factor = 1
+			}
+			w.WriteString("DN\t" + string(b1) + string(b2) + "\t" + strconv.FormatFloat(factor, 'f', 4, 64) + "\n")
+		}
+	}
+	w.Flush()
+}
+
+//---------------------------------------------------------------------------------------------------
+// HomopolymerLen returns length of the longest homopolymer of the reference which contains the
+// position or starts right after it (indels are reported at the base before inserted/deleted bases).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HomopolymerLen(pos int) int {
+	run_len := func(p int) int {
+		if p < 0 || p >= VC.SeqLen || VC.Seq[p] == '*' {
+			return 0
+		}
+		l, r := p, p
+		for l > 0 && VC.Seq[l-1] == VC.Seq[p] && p-l < MAX_HOMOPOLYMER_LEN {
+			l--
+		}
+		for r < VC.SeqLen-1 && VC.Seq[r+1] == VC.Seq[p] && r-l < MAX_HOMOPOLYMER_LEN {
+			r++
+		}
+		return r - l + 1
+	}
+	hp_len := run_len(pos)
+	if l := run_len(pos + 1); hp_len < l {
+		hp_len = l
+	}
+	if hp_len > MAX_HOMOPOLYMER_LEN {
+		hp_len = MAX_HOMOPOLYMER_LEN
+	}
+	if hp_len < 1 {
+		hp_len = 1
+	}
+	return hp_len
+}
+
+//---------------------------------------------------------------------------------------------------
+// Dinucleotide returns the reference dinucleotide ending at the position.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) Dinucleotide(pos int) string {
+	if pos < 1 || pos >= VC.SeqLen {
+		return ""
+	}
+	return strings.ToUpper(string(VC.Seq[pos-1 : pos+1]))
+}
+
+//---------------------------------------------------------------------------------------------------
+// IndelErrFactor returns the factor of indel error rate at a position of the reference.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) IndelErrFactor(pos int) float64 {
+	if CONTEXT == nil {
+		return 1
+	}
+	return CONTEXT.HPFactor[VC.HomopolymerLen(pos)]
+}
+
+//---------------------------------------------------------------------------------------------------
+// SubErrFactor returns the factor of substitution error rate at a position of the reference.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SubErrFactor(pos int) float64 {
+	if CONTEXT == nil {
+		return 1
+	}
+	if factor, ok := CONTEXT.DNFactor[VC.Dinucleotide(pos)]; ok {
+		return factor
+	}
+	return 1
+}
+
+//---------------------------------------------------------------------------------------------------
+// ContextGapOpen returns cost of gap open at a position of the reference (not less than gap extension cost).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ContextGapOpen(pos int) float64 {
+	if CONTEXT == nil {
+		return PARA.Gap_open
+	}
+	return math.Max(PARA.Gap_ext, PARA.Gap_open-math.Log10(VC.IndelErrFactor(pos)))
+}
+
+//---------------------------------------------------------------------------------------------------
+// ContextSubCost returns cost of substitution at a position of the reference (not less than 0).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ContextSubCost(pos int) float64 {
+	if CONTEXT == nil {
+		return PARA.Sub_cost
+	}
+	return math.Max(0, PARA.Sub_cost-math.Log10(VC.SubErrFactor(pos)))
+}
+
+//---------------------------------------------------------------------------------------------------
+// LearnContextModel estimates a context table from aligned reads at variant call positions. Positions
+// where at least CONTEXT_REF_FRACTION of reads agree with the reference are considered as non-variant
+// sites, other reads at these positions are considered as sequencing errors. Error rates in each
+// context are divided by rates of the baseline context (homopolymer length 1, all dinucleotides) to
+// get factors. CONTEXT_PSEUDO_COUNT reads with the baseline rate are added to each context, so that
+// factors of contexts with few observations are close to 1.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) LearnContextModel() *ContextModel {
+	hp_err, hp_total := make([]float64, MAX_HOMOPOLYMER_LEN+1), make([]float64, MAX_HOMOPOLYMER_LEN+1)
+	dn_err, dn_total := make(map[string]float64), make(map[string]float64)
+	var all_sub_err, all_sub_total float64
+	for rid := 0; rid < len(VC.Calls); rid++ {
+		VC.Calls[rid].Sites.EachAligned(func(pos uint32, site *CallSite) {
+			ref_num, indel_num, sub_num := 0, 0, 0
+			for k, var_num := range site.RNum.Vals {
+				var_arr := strings.Split(site.RNum.Keys[k], "|")
+				if var_arr[0] == var_arr[1] {
+					ref_num += var_num
+				} else if len(var_arr[0]) != len(var_arr[1]) {
+					indel_num += var_num
+				} else {
+					sub_num += var_num
+				}
+			}
+			total := ref_num + indel_num + sub_num
+			if total == 0 || float64(ref_num) < CONTEXT_REF_FRACTION*float64(total) {
+				return
+			}
+			hp_len := VC.HomopolymerLen(int(pos))
+			hp_err[hp_len] += float64(indel_num)
+			hp_total[hp_len] += float64(total)
+			dn := VC.Dinucleotide(int(pos))
+			dn_err[dn] += float64(sub_num)
+			dn_total[dn] += float64(total)
+			all_sub_err += float64(sub_num)
+			all_sub_total += float64(total)
+		})
+	}
+	// Baseline rates are estimated with one error in two pseudo reads (they are not 0 without errors),
+	// pseudo reads with baseline rates make contexts without observations get factors of 1
+	C := DefaultContextModel()
+	base_rate := (hp_err[1] + 1) / (hp_total[1] + 2)
+	C.HPFactor[1] = 1
+	for hp_len := 2; hp_len <= MAX_HOMOPOLYMER_LEN; hp_len++ {
+		C.HPFactor[hp_len] = ((hp_err[hp_len] + CONTEXT_PSEUDO_COUNT*base_rate) / (hp_total[hp_len] + CONTEXT_PSEUDO_COUNT)) / base_rate
+	}
+	base_rate = (all_sub_err + 1) / (all_sub_total + 2)
+	C.DNFactor = make(map[string]float64)
+	for dn, total := range dn_total {
+		if len(dn) == 2 && strings.Trim(dn, STD_BASES) == "" {
+			C.DNFactor[dn] = ((dn_err[dn] + CONTEXT_PSEUDO_COUNT*base_rate) / (total + CONTEXT_PSEUDO_COUNT)) / base_rate
+		}
+	}
+	return C
+}
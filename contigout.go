@@ -0,0 +1,116 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: contigout.go
+// Per-contig output of variant calls. Calls of each contig (chromosome) are written to their own VCF
+// file, named by the variant call file and the contig (e.g. calls.chr1.vcf for calls.vcf), with the
+// header of the variant call file. Regions of the multigenome are split at starts of contigs and
+// formatted in parallel as usual; the file of a contig is closed as soon as its last region is written,
+// so that downstream per-contig processing can start before calls of the other contigs are written.
+// Files of contigs can be concatenated into the variant call file at the end.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Characters of contig names which are replaced in names of per-contig files
+var CONTIG_NAME_UNSAFE = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+//---------------------------------------------------------------------------------------------------
+// ContigFilePattern returns the pattern of names of per-contig files, with * in place of contig names.
+//---------------------------------------------------------------------------------------------------
+func ContigFilePattern(var_call_file string) string {
+	base_name := strings.TrimSuffix(var_call_file, ".gz")
+	base_name = strings.TrimSuffix(base_name, path.Ext(base_name))
+	return OutputName(base_name+".*.vcf", PARA.Gzip_output)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ContigFileName returns the name of the per-contig file of calls of a contig.
+//---------------------------------------------------------------------------------------------------
+func ContigFileName(var_call_file, chr_name string) string {
+	return strings.Replace(ContigFilePattern(var_call_file), "*", CONTIG_NAME_UNSAFE.ReplaceAllString(chr_name, "_"), 1)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadVCFHeader returns header lines of a (possibly gzip-compressed) VCF file.
+//---------------------------------------------------------------------------------------------------
+func ReadVCFHeader(file_name string) []string {
+	scanner, done := OpenTextInput(file_name)
+	defer done()
+	header := make([]string, 0)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] != '#' {
+			break
+		}
+		header = append(header, line)
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return header
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteContigCalls determines variant calls and writes calls of each contig to its per-contig file,
+// with header lines of the variant call file. It returns names of per-contig files in order of contigs.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteContigCalls() []string {
+	header := ReadVCFHeader(PARA.Var_call_file)
+	regions := VC.OutputRegions(true)
+	contig_files := make([]string, len(VC.ChrPos))
+	region_chr := func(r int) int {
+		return sort.SearchInts(VC.ChrPos, regions[r][0]+1) - 1
+	}
+	var w *OutputFile
+	chr_id := -1
+	VC.FormatRegions(VC.CallPositions(), regions, func(r int, calls []byte) {
+		if region_chr(r) != chr_id {
+			chr_id = region_chr(r)
+			contig_files[chr_id] = ContigFileName(PARA.Var_call_file, string(VC.ChrName[chr_id]))
+			w = CreateOutput(contig_files[chr_id])
+			for _, line := range header {
+				w.WriteString(line + "\n")
+			}
+		}
+		w.Write(calls)
+		// The file is closed after the last region of the contig
+		if r+1 == len(regions) || region_chr(r+1) != chr_id {
+			w.Close()
+		}
+	})
+	return contig_files
+}
+
+//---------------------------------------------------------------------------------------------------
+// MergeContigCalls appends calls of per-contig files (without their headers) to the variant call file.
+//---------------------------------------------------------------------------------------------------
+func MergeContigCalls(var_call_file string, contig_files []string) {
+	w := AppendOutput(var_call_file)
+	defer w.Close()
+	file_num := 0
+	for _, file_name := range contig_files {
+		if file_name == "" {
+			continue
+		}
+		file_num++
+		scanner, done := OpenTextInput(file_name)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" && line[0] != '#' {
+				w.WriteString(line + "\n")
+			}
+		}
+		if e := scanner.Err(); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		done()
+	}
+	log.Printf("Variant calls of %d contigs are merged into:\t%s", file_num, var_call_file)
+}
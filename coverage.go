@@ -0,0 +1,47 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: coverage.go
+// Coverage track output: depth of aligned reads at each position of the multigenome is written in
+// BedGraph format (runs of positions with the same non-zero depth), which can be converted to BigWig
+// with bedGraphToBigWig.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"strconv"
+)
+
+//---------------------------------------------------------------------------------------------------
+// WriteBedGraph writes depth of aligned reads to a file in BedGraph format (0-based, half-open
+// intervals). Runs do not span chromosome boundaries; positions without aligned reads are omitted.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteBedGraph(file_name string) {
+	w := CreateOutput(file_name)
+	defer w.Close()
+	w.WriteString("track type=bedGraph name=\"IVC coverage\" description=\"Depth of aligned reads\"\n")
+	run_num := 0
+	for chr_id, chr_start := range VC.ChrPos {
+		chr_end := VC.SeqLen
+		if chr_id+1 < len(VC.ChrPos) {
+			chr_end = VC.ChrPos[chr_id+1]
+		}
+		chr_end = MinInt(chr_end, len(SiteDepth))
+		chr_name := string(VC.ChrName[chr_id])
+		for pos := chr_start; pos < chr_end; {
+			end := pos + 1
+			for end < chr_end && SiteDepth[end] == SiteDepth[pos] {
+				end++
+			}
+			if SiteDepth[pos] > 0 {
+				w.WriteString(chr_name + "\t" + strconv.Itoa(pos-chr_start) + "\t" + strconv.Itoa(end-chr_start) + "\t" +
+					strconv.Itoa(int(SiteDepth[pos])) + "\n")
+				run_num++
+			}
+			pos = end
+		}
+	}
+	w.Flush()
+	log.Printf("Number of coverage intervals:\t%d, written to:\t%s", run_num, file_name)
+}
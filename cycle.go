@@ -0,0 +1,153 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: cycle.go
+// Per-cycle sequencing error model. Error rates of Illumina-like reads depend on the sequencing cycle
+// (the position of a base in the read as it was sequenced), typically rising towards the 3' end,
+// which reported base qualities only partly capture. In the warm-up phase, mismatches at positions
+// without known variants are counted by cycle, and the error rate of each cycle is divided by the
+// overall error rate to get its factor. Factors multiply error rates of substitutions in variant
+// probability updates and reduce or raise substitution costs in alignment (costs are -log10 of rates).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	CYCLE_MAX_LEN    = 1024   // maximum read length of the per-cycle model (longer reads use the context-free model)
+	CYCLE_PSEUDO     = 1000.0 // pseudo-count of bases at the overall error rate added to each cycle
+	CYCLE_MIN_FACTOR = 0.25   // minimum error factor of cycles
+	CYCLE_MAX_FACTOR = 10.0   // maximum error factor of cycles
+	CYCLE_WARM_UP    = 10000  // number of read pairs of the warm-up phase if it is not given
+)
+
+//---------------------------------------------------------------------------------------------------
+// CycleModel represents factors of sequencing error rates of cycles.
+//---------------------------------------------------------------------------------------------------
+type CycleModel struct {
+	Factor   []float64 // error factors of cycles (cycles beyond the table take the factor of its last cycle)
+	cost     []float64 // changes of substitution costs of cycles 0..CYCLE_MAX_LEN-1
+	rev_cost []float64 // cost in reverse order, for reads aligned to the reverse strand
+}
+
+// Per-cycle error model used for alignment and variant probability updates (nil: no per-cycle term)
+var CYCLE *CycleModel
+
+//---------------------------------------------------------------------------------------------------
+// NewCycleModel creates a per-cycle model from factors of cycles.
+//---------------------------------------------------------------------------------------------------
+func NewCycleModel(factor []float64) *CycleModel {
+	C := &CycleModel{Factor: factor}
+	C.cost, C.rev_cost = make([]float64, CYCLE_MAX_LEN), make([]float64, CYCLE_MAX_LEN)
+	for c := 0; c < CYCLE_MAX_LEN; c++ {
+		C.cost[c] = -math.Log10(C.ErrFactor(c))
+		C.rev_cost[CYCLE_MAX_LEN-1-c] = C.cost[c]
+	}
+	return C
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateCycleModel computes factors of cycles from numbers of read-ends of each length and numbers of
+// mismatches at each cycle, relative to the overall error rate err. Counts of cycles are smoothed
+// with CYCLE_PSEUDO bases at the overall rate, so that sparse cycles stay close to factor 1. It returns
+// nil if no read-ends or no mismatches were counted.
+//---------------------------------------------------------------------------------------------------
+func EstimateCycleModel(len_num, mis_num []int64, err float64) *CycleModel {
+	max_len := 0
+	for l, n := range len_num {
+		if n > 0 {
+			max_len = l
+		}
+	}
+	if max_len == 0 || err <= 0 {
+		return nil
+	}
+	factor := make([]float64, max_len)
+	base_num := int64(0) // number of read-ends covering the cycle (read-ends longer than the cycle)
+	for c := max_len - 1; c >= 0; c-- {
+		base_num += len_num[c+1]
+		rate := (float64(mis_num[c]) + CYCLE_PSEUDO*err) / (float64(base_num) + CYCLE_PSEUDO)
+		factor[c] = math.Min(math.Max(rate/err, CYCLE_MIN_FACTOR), CYCLE_MAX_FACTOR)
+	}
+	return NewCycleModel(factor)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ErrFactor returns the factor of error rate of a cycle (1 without per-cycle model).
+//---------------------------------------------------------------------------------------------------
+func (C *CycleModel) ErrFactor(cycle int) float64 {
+	if C == nil || cycle < 0 || len(C.Factor) == 0 {
+		return 1
+	}
+	if cycle >= len(C.Factor) {
+		return C.Factor[len(C.Factor)-1]
+	}
+	return C.Factor[cycle]
+}
+
+//---------------------------------------------------------------------------------------------------
+// Costs returns changes of substitution costs of bases of a read-end of length read_len, indexed by
+// positions in the read-end as it is aligned (reversed if it is aligned to the reverse strand). It
+// returns nil without per-cycle model or for reads longer than CYCLE_MAX_LEN.
+//---------------------------------------------------------------------------------------------------
+func (C *CycleModel) Costs(read_len int, rev bool) []float64 {
+	if C == nil || read_len > CYCLE_MAX_LEN {
+		return nil
+	}
+	if rev {
+		return C.rev_cost[CYCLE_MAX_LEN-read_len:]
+	}
+	return C.cost[:read_len]
+}
+
+//---------------------------------------------------------------------------------------------------
+// SubCosts returns changes of substitution costs of bases i..j-1 of a read-end (nil if costs is nil).
+//---------------------------------------------------------------------------------------------------
+func SubCosts(costs []float64, i, j int) []float64 {
+	if costs == nil {
+		return nil
+	}
+	return costs[i:j]
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadCycle returns the sequencing cycle of the base at index read_pos of a read-end of length read_len
+// as it is aligned (rev: the read-end is aligned to the reverse strand).
+//---------------------------------------------------------------------------------------------------
+func ReadCycle(read_pos, read_len int, rev bool) int {
+	if rev {
+		return read_len - 1 - read_pos
+	}
+	return read_pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetCycles converts indices of variants in a read-end as it is aligned (see ExtendSeeds) to
+// sequencing cycles.
+//---------------------------------------------------------------------------------------------------
+func SetCycles(vars []*VarInfo, read_len int, rev bool) {
+	for _, v := range vars {
+		v.Cycle = ReadCycle(v.Cycle, read_len, rev)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// LogCycles reports factors of error rates of cycles (every tenth cycle and the last one).
+//---------------------------------------------------------------------------------------------------
+func (C *CycleModel) LogCycles() {
+	if C == nil {
+		return
+	}
+	factors := make([]string, 0)
+	for c := 0; c < len(C.Factor); c++ {
+		if c%10 == 0 || c == len(C.Factor)-1 {
+			factors = append(factors, strconv.Itoa(c+1)+":"+strconv.FormatFloat(C.Factor[c], 'f', 2, 64))
+		}
+	}
+	log.Printf("Error factors of sequencing cycles:\t%s", strings.Join(factors, " "))
+}
@@ -7,6 +7,7 @@
 package ivc
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"math"
@@ -60,6 +61,37 @@ func ProcessNoAlignReadInfo() {
 	}
 }
 
+//--------------------------------------------------------------------------------------------------
+// Writing evidence of variant calls. Evidence is sent to a buffered channel and written to the debug file
+// by a dedicated goroutine, one aligned base per line in tab-separated format.
+//--------------------------------------------------------------------------------------------------
+
+// Channel of evidence being written (nil if the debug file is not required)
+var EVIDENCE chan *VarInfo
+
+const EVIDENCE_HEADER = "#CHROM\tPOS\tBASES\tBASE_QUAL\tTYPE\tCHR_DIS\tCHR_DIFF\tMAP_PROB\tALN_PROB\tPAIR_PROB\t" +
+	"S_POS1\tBRANCH1\tS_POS2\tBRANCH2\tREAD_HEADER\tREAD_GROUP\tALT_ALN\n"
+
+func (VC *VarCallIndex) WriteEvidence(evidence chan *VarInfo, done chan bool) {
+	f, e := os.Create(PARA.Debug_file)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(EVIDENCE_HEADER)
+	for vi := range evidence {
+		chr_name, chr_pos := VC.ChrLoc(int(vi.Pos))
+		w.WriteString(chr_name + "\t" + strconv.Itoa(chr_pos) + "\t" + string(vi.Bases) + "\t" + string(vi.BQual) + "\t" +
+			strconv.Itoa(vi.Type) + "\t" + strconv.Itoa(vi.CDis) + "\t" + strconv.Itoa(vi.CDiff) + "\t" +
+			strconv.FormatFloat(vi.MProb, 'f', 5, 64) + "\t" + strconv.FormatFloat(vi.AProb, 'f', 5, 64) + "\t" +
+			strconv.FormatFloat(vi.IProb, 'f', 5, 64) + "\t" + strconv.Itoa(vi.SPos1) + "\t" + strconv.FormatBool(vi.Strand1) + "\t" +
+			strconv.Itoa(vi.SPos2) + "\t" + strconv.FormatBool(vi.Strand2) + "\t" + string(vi.RInfo) + "\t" + RGID(vi.RGroup) + "\t" + AltAlnField(vi.AltAln) + "\n")
+	}
+	w.Flush()
+	f.Close()
+	done <- true
+}
+
 //--------------------------------------------------------------------------------------------------
 // Printing Alignment info
 //--------------------------------------------------------------------------------------------------
@@ -0,0 +1,24 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: diskspace.go
+// Free disk space of directories (Unix-like systems).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+//go:build !windows
+// +build !windows
+
+package ivc
+
+import "syscall"
+
+//---------------------------------------------------------------------------------------------------
+// FreeSpace returns free disk space (bytes) of the file system of a directory available to users,
+// false if it cannot be checked.
+//---------------------------------------------------------------------------------------------------
+func FreeSpace(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if e := syscall.Statfs(dir, &st); e != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
@@ -0,0 +1,14 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: diskspace_windows.go
+// Free disk space of directories (Windows, not checked).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+//---------------------------------------------------------------------------------------------------
+// FreeSpace returns false, free disk space is not checked on Windows.
+//---------------------------------------------------------------------------------------------------
+func FreeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
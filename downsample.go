@@ -0,0 +1,114 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: downsample.go
+// Downsampling of aligned reads at positions with too many of them (e.g. collapsed repeats, PCR
+// stacks). Aligned bases of each position are kept in a reservoir of at most DepthCap() reads while
+// reads are aligned, and statistics and likelihoods of the position are only updated with the kept
+// bases when alignment is done. Each read gets a priority at each position from a hash of its seed
+// (see ReadSeed) and the position, and the reservoir keeps the bases of the reads with the smallest
+// priorities: every read is kept with the same probability wherever it is in the input, and the kept
+// reads depend neither on the order in which goroutines align reads nor on their number.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+//---------------------------------------------------------------------------------------------------
+// KeptBase represents an aligned base kept in a reservoir and its priority.
+//---------------------------------------------------------------------------------------------------
+type KeptBase struct {
+	Prio uint64   // priority of the read at the position (the smallest priorities are kept)
+	Var  *VarInfo // aligned base
+}
+
+//---------------------------------------------------------------------------------------------------
+// Reservoir represents aligned bases kept at a position, as a heap of the largest priority first
+// (the base which is replaced when a base with a smaller priority comes in).
+//---------------------------------------------------------------------------------------------------
+type Reservoir struct {
+	bases []KeptBase
+}
+
+func (R *Reservoir) Len() int           { return len(R.bases) }
+func (R *Reservoir) Less(i, j int) bool { return R.bases[i].Prio > R.bases[j].Prio }
+func (R *Reservoir) Swap(i, j int)      { R.bases[i], R.bases[j] = R.bases[j], R.bases[i] }
+func (R *Reservoir) Push(x interface{}) { R.bases = append(R.bases, x.(KeptBase)) }
+func (R *Reservoir) Pop() interface{} {
+	b := R.bases[len(R.bases)-1]
+	R.bases = R.bases[:len(R.bases)-1]
+	return b
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds an aligned base with a priority to a reservoir of at most max_num bases. If the reservoir
+// is full, the base replaces the kept base with the largest priority if its priority is smaller. It
+// returns false if the base is not kept.
+//---------------------------------------------------------------------------------------------------
+func (R *Reservoir) Add(prio uint64, var_info *VarInfo, max_num int) bool {
+	if len(R.bases) < max_num {
+		heap.Push(R, KeptBase{prio, var_info})
+		return true
+	}
+	if len(R.bases) == 0 || prio >= R.bases[0].Prio {
+		return false
+	}
+	R.bases[0] = KeptBase{prio, var_info}
+	heap.Fix(R, 0)
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// Kept returns aligned bases of a reservoir by increasing priorities (bases of the two ends of a read
+// pair have the same priority, they are ordered by cycles).
+//---------------------------------------------------------------------------------------------------
+func (R *Reservoir) Kept() []*VarInfo {
+	sort.Slice(R.bases, func(i, j int) bool {
+		if R.bases[i].Prio != R.bases[j].Prio {
+			return R.bases[i].Prio < R.bases[j].Prio
+		}
+		return R.bases[i].Var.Cycle < R.bases[j].Var.Cycle
+	})
+	vars := make([]*VarInfo, len(R.bases))
+	for i, b := range R.bases {
+		vars[i] = b.Var
+	}
+	return vars
+}
+
+//---------------------------------------------------------------------------------------------------
+// BasePriority returns the priority of a read with a seed (see ReadSeed) at a position (splitmix64
+// finalizer of the seed and the position), so that reads kept at different positions are independent.
+//---------------------------------------------------------------------------------------------------
+func BasePriority(seed int64, pos uint32) uint64 {
+	z := uint64(seed) + (uint64(pos)+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ApplyKeptBases updates statistics and likelihoods of variant locations with the aligned bases kept in
+// their reservoirs, and frees the reservoirs. Shards are updated concurrently.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ApplyKeptBases() {
+	defer TIMING.Add(TIMING_POSTERIOR, TIMING.Start(), 0)
+	var wg sync.WaitGroup
+	for rid := 0; rid < len(VC.Calls); rid++ {
+		wg.Add(1)
+		go func(rid int) {
+			defer wg.Done()
+			for pos, R := range VC.Calls[rid].Kept {
+				for _, var_info := range R.Kept() {
+					VC.ApplyBase(rid, pos, var_info)
+				}
+			}
+			VC.Calls[rid].Kept = make(map[uint32]*Reservoir)
+		}(rid)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,438 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: dryrun.go
+// Dry run. Input files and options are checked as in a normal run, headers of the index are loaded
+// (positions of chromosomes, the length of the multigenome, the header of the FM-index and the
+// variant profile index) to check that the index, the multigenome and the variant profile were built
+// together, and the peak memory of the run is estimated. The plan of the run is printed without
+// loading the index or processing reads.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/namsyvo/IVC/fmi"
+	"github.com/namsyvo/IVC/index"
+)
+
+const (
+	DRY_RUN_VAR_BYTES      = 256  // estimated memory of each known variant (alleles, allele frequencies and lengths in maps)
+	DRY_RUN_CALL_BYTES     = 2048 // estimated memory of each position with aligned variant bases (probabilities, counts and statistics)
+	DRY_RUN_EVIDENCE_BYTES = 64   // estimated memory of each aligned base at positions of variant calls
+	DRY_RUN_GZIP_RATIO     = 4    // estimated compression ratio of gzip-compressed read files
+)
+
+// Types of known variants of the variant profile
+var VAR_TYPES = []string{"SNP", "MNP", "INS", "DEL", "COMPLEX"}
+
+//---------------------------------------------------------------------------------------------------
+// IndexInfo represents headers of the index: chromosomes and length of the multigenome, the size and
+// sampling rate of the index of seeds, numbers of known variants, and problems of compatibility.
+//---------------------------------------------------------------------------------------------------
+type IndexInfo struct {
+	ChrPos     []int                  // positions of chromosomes on the multigenome
+	ChrName    [][]byte               // chromosome names
+	SeqLen     int                    // length of the multigenome
+	IndexBytes int64                  // size of files of the index of seeds
+	SA_rate    int                    // sampling rate of suffix array of the FM-index (0: k-mer index)
+	MarkNum    int                    // number of known variant positions marked on the multigenome (0: k-mer index)
+	Bidir      bool                   // the FM-index is bidirectional (with tables of the multigenome, see fmi.BiIndex)
+	VarNum     int                    // number of known variants of the variant profile
+	VarTypeNum map[string]int         // numbers of known variants by type
+	VarLenNum  map[string]map[int]int // numbers of known variants by type and length (see VarLen)
+	Problems   []string               // problems of compatibility of the index, the multigenome and the variant profile
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadIndexInfo loads headers of the multigenome (ref_file), the variant profile index and the index
+// of seeds (the FM-index in index_file, or the k-mer index of the multigenome), and checks that they
+// are compatible: the FM-index has the length of the multigenome, all of its files are complete, and
+// known variants are within the multigenome and not fewer than positions marked on it.
+//---------------------------------------------------------------------------------------------------
+func LoadIndexInfo(ref_file, var_prof_file, index_file, seed_index string) *IndexInfo {
+	I := new(IndexInfo)
+	I.ChrPos, I.ChrName = LoadChrInfo(ref_file)
+	I.SeqLen = MultiSeqLen(ref_file)
+	if len(I.ChrPos) == 0 {
+		I.Problems = append(I.Problems, "no chromosomes in the index of the multigenome "+ref_file+".idx")
+	}
+	for i, pos := range I.ChrPos {
+		if (i == 0 && pos != 0) || (i > 0 && pos <= I.ChrPos[i-1]) || pos >= I.SeqLen {
+			I.Problems = append(I.Problems, fmt.Sprintf("position %d of chromosome %s does not fit the multigenome "+
+				"(length %d)", pos, I.ChrName[i], I.SeqLen))
+			break
+		}
+	}
+	if seed_index == SEED_INDEX_KMER {
+		kmer_file := index.KmerIndexFile(ref_file)
+		if fi, e := os.Stat(kmer_file); e == nil {
+			I.IndexBytes = fi.Size()
+		}
+	} else {
+		fm := fmi.LoadHeader(index_file)
+		I.SA_rate, I.MarkNum = int(fm.SA_rate), int(fm.Freq['Y'])
+		if int(fm.LEN) != I.SeqLen+1 {
+			I.Problems = append(I.Problems, fmt.Sprintf("the FM-index has length %d, but the multigenome has length %d "+
+				"(the FM-index has one more symbol): they were not built together", fm.LEN, I.SeqLen))
+		}
+		// Expected sizes of files (-1: not checked, the sampled suffix array has a variable size)
+		file_names := []string{"sa", "occ.A", "occ.C", "occ.G", "occ.T"}
+		file_sizes := []int64{int64(fm.LEN) * 4, int64(fm.LEN) * 4, int64(fm.LEN) * 4, int64(fm.LEN) * 4, int64(fm.LEN) * 4}
+		if fm.SA_rate > 1 {
+			file_names, file_sizes = append(file_names, fmi.SA_MARK_FILE), append(file_sizes, int64((fm.LEN+31)/32)*4)
+			file_sizes[0] = -1
+		}
+		if I.Bidir = fmi.HasFwd(index_file); I.Bidir {
+			for _, symb := range "ACGT" {
+				file_names, file_sizes = append(file_names, fmi.FWD_PREFIX+"occ."+string(symb)), append(file_sizes, int64(fm.LEN)*4)
+			}
+		}
+		for i, file_name := range file_names {
+			fi, e := os.Stat(path.Join(index_file, file_name))
+			if e != nil {
+				I.Problems = append(I.Problems, "missing file of the FM-index: "+path.Join(index_file, file_name))
+				continue
+			}
+			if file_sizes[i] >= 0 && fi.Size() != file_sizes[i] {
+				I.Problems = append(I.Problems, fmt.Sprintf("file of the FM-index %s has %d bytes, expected %d bytes "+
+					"(incomplete index)", path.Join(index_file, file_name), fi.Size(), file_sizes[i]))
+			}
+			I.IndexBytes += fi.Size()
+		}
+	}
+	out_num := I.LoadVarTypes(var_prof_file)
+	if out_num > 0 {
+		I.Problems = append(I.Problems, fmt.Sprintf("%d known variants of the variant profile index are beyond the "+
+			"multigenome (length %d): they were not built together", out_num, I.SeqLen))
+	}
+	if I.VarNum < I.MarkNum {
+		I.Problems = append(I.Problems, fmt.Sprintf("the variant profile index has %d known variants, but %d positions of "+
+			"known variants are marked on the indexed multigenome: they were not built together", I.VarNum, I.MarkNum))
+	}
+	return I
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiSeqLen returns the length of the multigenome (size of its file if it is not compressed).
+//---------------------------------------------------------------------------------------------------
+func MultiSeqLen(file_name string) int {
+	if !IsRemote(file_name) && CompressedName(file_name) == file_name {
+		fi, e := os.Stat(file_name)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		return int(fi.Size())
+	}
+	f, e := OpenCompressedInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	seq_len := 0
+	r := bufio.NewReader(f)
+	for {
+		line, e := r.ReadBytes('\n')
+		seq_len += len(bytes.Trim(line, "\n\r"))
+		if e != nil { //reach EOF
+			break
+		}
+	}
+	return seq_len
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadVarTypes counts known variants of the variant profile index by type and length, and returns the
+// number of known variants beyond the multigenome.
+//---------------------------------------------------------------------------------------------------
+func (I *IndexInfo) LoadVarTypes(var_prof_file string) int {
+	scanner, done := OpenTextInput(var_prof_file)
+	defer done()
+	I.VarTypeNum, I.VarLenNum = make(map[string]int), make(map[string]map[int]int)
+	for _, var_type := range VAR_TYPES {
+		I.VarLenNum[var_type] = make(map[int]int)
+	}
+	out_num := 0
+	for scanner.Scan() {
+		tokens := strings.Split(scanner.Text(), "\t")
+		if len(tokens) < 3 {
+			continue
+		}
+		pos, e := strconv.Atoi(tokens[0])
+		if e != nil {
+			continue
+		}
+		alleles := make([]string, 0)
+		for i := 1; i < len(tokens)-1; i += 2 {
+			alleles = append(alleles, tokens[i])
+		}
+		var_type := VarType(alleles)
+		I.VarNum++
+		I.VarTypeNum[var_type]++
+		I.VarLenNum[var_type][VarLen(alleles)]++
+		if pos < 0 || pos+len(alleles[0]) > I.SeqLen {
+			out_num++
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return out_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// VarType returns the type of a known variant from its alleles (reference first): SNP or MNP if all
+// alleles have the same length (1 or more), INS or DEL if all alternative alleles are longer or
+// shorter than the reference allele and share its first base, COMPLEX otherwise.
+//---------------------------------------------------------------------------------------------------
+func VarType(alleles []string) string {
+	ref := alleles[0]
+	same_len, ins, del := true, true, true
+	for _, alt := range alleles[1:] {
+		if len(alt) != len(ref) {
+			same_len = false
+		}
+		if len(alt) <= len(ref) || alt[0] != ref[0] {
+			ins = false
+		}
+		if len(alt) >= len(ref) || len(alt) == 0 || alt[0] != ref[0] {
+			del = false
+		}
+	}
+	switch {
+	case same_len && len(ref) == 1:
+		return "SNP"
+	case same_len:
+		return "MNP"
+	case ins:
+		return "INS"
+	case del:
+		return "DEL"
+	}
+	return "COMPLEX"
+}
+
+//---------------------------------------------------------------------------------------------------
+// VarLen returns the length of a known variant from its alleles (reference first): the length of the
+// reference allele for SNPs and MNPs, the maximum difference of lengths of alternative alleles and the
+// reference allele for indels, the length of the longest allele otherwise.
+//---------------------------------------------------------------------------------------------------
+func VarLen(alleles []string) int {
+	ref_len, var_len := len(alleles[0]), 0
+	switch VarType(alleles) {
+	case "SNP", "MNP":
+		return ref_len
+	case "INS", "DEL":
+		for _, alt := range alleles[1:] {
+			var_len = MaxInt(var_len, MaxInt(len(alt)-ref_len, ref_len-len(alt)))
+		}
+		return var_len
+	}
+	for _, allele := range alleles {
+		var_len = MaxInt(var_len, len(allele))
+	}
+	return var_len
+}
+
+//---------------------------------------------------------------------------------------------------
+// EditAlnBytes returns the memory of alignment matrices of InitEditAlnInfo(arr_len): six distance and
+// trace matrices (float64 and 2-int slices) and two trace matrices of known variants (slice headers).
+//---------------------------------------------------------------------------------------------------
+func EditAlnBytes(arr_len int) int64 {
+	n := int64(arr_len+1) * int64(arr_len+1)
+	return 6*n*(8+24+16) + 2*n*24
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadFileBases estimates the number of bases of a read file from its size, with records of read_len
+// bases and headers of header_len bytes (gzip-compressed files are DRY_RUN_GZIP_RATIO times larger).
+//---------------------------------------------------------------------------------------------------
+func ReadFileBases(file_name string, read_len, header_len int) int64 {
+	fi, e := os.Stat(file_name)
+	if e != nil {
+		return 0
+	}
+	size := fi.Size()
+	if strings.HasSuffix(file_name, ".gz") {
+		size *= DRY_RUN_GZIP_RATIO
+	}
+	return size / int64(2*read_len+header_len+4) * int64(read_len)
+}
+
+//---------------------------------------------------------------------------------------------------
+// MemEstimate represents the estimated peak memory of a run, by component (in bytes).
+//---------------------------------------------------------------------------------------------------
+type MemEstimate struct {
+	Index, Genome, VarProf int64 // index of seeds, multigenome and variant profile
+	Align                  int64 // alignment matrices, arenas and reference windows of all alignment goroutines
+	Calls                  int64 // variant probabilities and evidence of positions with aligned variant bases
+	CallPos                int64 // expected number of positions with aligned variant bases
+	ReadBases              int64 // estimated number of bases of reads
+}
+
+//---------------------------------------------------------------------------------------------------
+// Total returns the estimated peak memory.
+//---------------------------------------------------------------------------------------------------
+func (M *MemEstimate) Total() int64 {
+	return M.Index + M.Genome + M.VarProf + M.Align + M.Calls
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateMem estimates the peak memory of a run with parameters P on the index I. Variant calls are
+// expected at known variants, novel variants (the multigenome times rates of novel SNPs and indels),
+// and positions of sequencing errors (bases of reads times the error rate, at most the multigenome),
+// each with the average depth of reads (capped by the maximum depth) of aligned bases.
+//---------------------------------------------------------------------------------------------------
+func EstimateMem(I *IndexInfo, P *ParaInfo) *MemEstimate {
+	M := new(MemEstimate)
+	M.Index, M.Genome = I.IndexBytes, int64(I.SeqLen)
+	M.VarProf = int64(I.VarNum) * DRY_RUN_VAR_BYTES
+	// Each alignment goroutine has two sets of alignment matrices, an arena and a cache of reference windows
+	window_bytes := int64(2*P.Read_len)*9 + 64
+	M.Align = int64(P.Proc_num) * (2*EditAlnBytes(2*P.Read_len) + ARENA_SLAB_SIZE + REF_WINDOW_CACHE_SIZE*window_bytes)
+	// Lengths of headers are padded by 20 bytes in SetupPara
+	read_files_2, header_len := ReadFiles(P.Read_file_2), MaxInt(P.Info_len-20, 1)
+	for i, read_file := range ReadFiles(P.Read_file_1) {
+		M.ReadBases += ReadFileBases(read_file, P.Read_len_1, header_len)
+		if i < len(read_files_2) && P.Preset == "" {
+			M.ReadBases += ReadFileBases(read_files_2[i], P.Read_len_2, header_len)
+		}
+	}
+	seq_len := math.Max(float64(I.SeqLen), 1)
+	novel_num := seq_len * (P.New_snp_rate + P.New_indel_rate)
+	err_num := math.Min(float64(M.ReadBases)*float64(P.Err_rate), seq_len)
+	M.CallPos = int64(float64(I.VarNum) + novel_num + err_num)
+	max_depth := P.Max_depth
+	if max_depth <= 0 || max_depth > MAX_EVIDENCE_NUM {
+		max_depth = MAX_EVIDENCE_NUM
+	}
+	depth := math.Min(float64(M.ReadBases)/seq_len, float64(max_depth))
+	M.Calls = int64(float64(M.CallPos) * (DRY_RUN_CALL_BYTES + depth*DRY_RUN_EVIDENCE_BYTES))
+	return M
+}
+
+//---------------------------------------------------------------------------------------------------
+// FormatBytes formats a memory size in GB (MB for sizes below 1 GB).
+//---------------------------------------------------------------------------------------------------
+func FormatBytes(n int64) string {
+	if n < 1<<30 {
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.2f GB", float64(n)/(1<<30))
+}
+
+//---------------------------------------------------------------------------------------------------
+// RunStages returns names of optional stages which are enabled by parameters P.
+//---------------------------------------------------------------------------------------------------
+func RunStages(P *ParaInfo) []string {
+	stages := make([]string, 0)
+	add := func(on bool, stage string) {
+		if on {
+			stages = append(stages, stage)
+		}
+	}
+	add(P.Load_state != "", "load state "+P.Load_state)
+	add(P.Warm_up > 0, "warm-up ("+strconv.Itoa(P.Warm_up)+" read pairs)")
+	add(P.Cycle_err, "per-cycle error rates")
+	add(P.Prescreen, "k-mer prescreen")
+	add(P.Merge_pairs, "merging read pairs")
+	add(P.Realign, "indel realignment")
+	add(P.Assemble, "haplotype assembly")
+	add(P.BAQ, "base alignment qualities")
+	add(P.Context_model || P.Context_file != "", "context error model")
+	add(P.Primer_file != "", "amplicon primers "+P.Primer_file)
+	add(P.Mask_file != "", "masked regions "+P.Mask_file+" ("+P.Mask_mode+")")
+	add(P.Two_pass > 0, "two-pass calling (QUAL >= "+strconv.FormatFloat(P.Two_pass, 'g', -1, 64)+")")
+	add(P.Contam || P.Contam_adjust, "contamination estimation")
+	add(P.Trio != "", "trio calling "+P.Trio)
+	add(P.Shard != "", "shard "+P.Shard)
+	add(P.Save_state != "", "save state "+P.Save_state)
+	return stages
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteRunPlan writes the plan of a run with parameters P: inputs, headers of the index, parameters
+// of alignment, enabled stages, outputs and the estimated peak memory.
+//---------------------------------------------------------------------------------------------------
+func WriteRunPlan(w io.Writer, I *IndexInfo, P *ParaInfo, M *MemEstimate) {
+	fmt.Fprintf(w, "Multigenome:\t%s (%d bases, %d chromosomes)\n", P.Ref_file, I.SeqLen, len(I.ChrPos))
+	if I.SA_rate == 0 {
+		fmt.Fprintf(w, "Index of seeds:\t%s (k-mer index, %s)\n", index.KmerIndexFile(P.Ref_file), FormatBytes(I.IndexBytes))
+	} else {
+		fmt.Fprintf(w, "Index of seeds:\t%s (%s, SA sampling rate %d, %s)\n", P.Rev_index_file, I.FMIName(), I.SA_rate, FormatBytes(I.IndexBytes))
+	}
+	var_types := make([]string, 0)
+	for _, var_type := range VAR_TYPES {
+		var_types = append(var_types, var_type+" "+strconv.Itoa(I.VarTypeNum[var_type]))
+	}
+	fmt.Fprintf(w, "Variant profile:\t%s (%d known variants: %s)\n", P.Var_prof_file, I.VarNum, strings.Join(var_types, ", "))
+	fmt.Fprintf(w, "Reads:\t%s", P.Read_file_1)
+	if P.Preset == "" {
+		fmt.Fprintf(w, " %s", P.Read_file_2)
+	} else {
+		fmt.Fprintf(w, " (preset %s)", P.Preset)
+	}
+	fmt.Fprintf(w, " (about %d bases, read length %d/%d)\n", M.ReadBases, P.Read_len_1, P.Read_len_2)
+	dist_thres, iter_num := strconv.FormatFloat(P.Dist_thres, 'f', 1, 64), strconv.Itoa(P.Iter_num)
+	if P.Auto_dist_thres {
+		dist_thres = "estimated from known variants"
+	}
+	if P.Auto_iter_num {
+		iter_num = "estimated from known variants"
+	}
+	fmt.Fprintf(w, "Alignment:\t%d goroutines, distance threshold %s, iterations %s, seed length %d-%d\n", P.Proc_num,
+		dist_thres, iter_num, P.Min_slen, P.Max_slen)
+	if stages := RunStages(P); len(stages) > 0 {
+		fmt.Fprintf(w, "Stages:\t%s\n", strings.Join(stages, ", "))
+	}
+	if P.Per_contig {
+		fmt.Fprintf(w, "Variant calls:\t%s (merged: %t)\n", ContigFilePattern(P.Var_call_file), P.Merge_contigs)
+	} else {
+		fmt.Fprintf(w, "Variant calls:\t%s\n", P.Var_call_file)
+	}
+	for _, out := range [][2]string{{"SV candidates", P.SV_file}, {"CNV candidates", P.CNV_file}, {"Pileup", P.Pileup_file},
+		{"BedGraph", P.Bedgraph_file}, {"Unaligned reads", P.Unaligned_file}, {"Evidence", P.Debug_file},
+		{"Read support", P.Support_file}, {"Calibration report", P.Calib_report}, {"Summary", P.Summary_file},
+		{"Timing", P.Timing_file}} {
+		if out[1] != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", out[0], out[1])
+		}
+	}
+	fmt.Fprintf(w, "Memory:\tindex %s, multigenome %s, variant profile %s, alignment %s, variant calls %s (%d positions)\n",
+		FormatBytes(M.Index), FormatBytes(M.Genome), FormatBytes(M.VarProf), FormatBytes(M.Align), FormatBytes(M.Calls), M.CallPos)
+	fmt.Fprintf(w, "Estimated peak memory:\t%s\n", FormatBytes(M.Total()))
+	for _, problem := range I.Problems {
+		fmt.Fprintf(w, "Problem:\t%s\n", problem)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// DryRun checks input files and options, loads headers of the index, and writes the plan of the run
+// to the standard output without loading the index or processing reads. It returns false if the
+// index, the multigenome and the variant profile are not compatible.
+//---------------------------------------------------------------------------------------------------
+func DryRun(input_para *ParaInfo) bool {
+	log.Printf("----------------------------------------------------------------------------------------")
+	log.Printf("Dry run: checking input information and planning the run...")
+	CheckSetupInput(input_para)
+	PARA = SetupPara(input_para)
+	PARA.Var_call_file = OutputName(PARA.Var_call_file, PARA.Gzip_output)
+	I := LoadIndexInfo(PARA.Ref_file, PARA.Var_prof_file, PARA.Rev_index_file, PARA.Seed_index)
+	WriteRunPlan(os.Stdout, I, PARA, EstimateMem(I, PARA))
+	if len(I.Problems) > 0 {
+		log.Printf("Found %d problem(s) with the index, nothing is processed.", len(I.Problems))
+		return false
+	}
+	log.Printf("Finish dry run, nothing is processed.")
+	return true
+}
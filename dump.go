@@ -0,0 +1,225 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: dump.go
+// Human-readable alignment dump. Alignments of selected read pairs (by read names, or by a region of
+// the reference) are pretty-printed read against reference to a file, with alignment distances,
+// mapping quality and the aligned bases which are used as evidence of variant calls, so that users can
+// see why a specific call was made.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/namsyvo/IVC/seqio"
+)
+
+//---------------------------------------------------------------------------------------------------
+// AlnDump represents the selection of read pairs whose alignments are dumped and the dump file.
+//---------------------------------------------------------------------------------------------------
+type AlnDump struct {
+	names      map[string]bool // names of selected read pairs (nil: read pairs are selected by region)
+	chr        string          // chromosome of the region
+	start, end int             // 1-based positions of the region (inclusive)
+	out        *OutputFile     // dump file
+	num        int             // number of dumped read pairs
+	mut        sync.Mutex
+}
+
+// Alignment dump of the current run (nil: alignments are not dumped)
+var ALN_DUMP *AlnDump
+
+//---------------------------------------------------------------------------------------------------
+// SetupAlnDump sets up the selection of dumped read pairs: a file of read names (one per line, with
+// or without '@' and /1, /2), or a region "chr", "chr:pos" or "chr:start-end" (1-based).
+//---------------------------------------------------------------------------------------------------
+func SetupAlnDump(spec string) {
+	ALN_DUMP = nil
+	if spec == "" {
+		return
+	}
+	D := new(AlnDump)
+	if f, e := os.Open(spec); e == nil {
+		D.names = make(map[string]bool)
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if line := bytes.TrimSpace(s.Bytes()); len(line) > 0 && line[0] != '#' {
+				D.names[string(seqio.MateName(line))] = true
+			}
+		}
+		f.Close()
+		if e = s.Err(); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		log.Printf("Alignment dump:\t%d selected reads from %s", len(D.names), spec)
+	} else {
+		var ok bool
+		if D.chr, D.start, D.end, ok = ParseRegion(spec); !ok {
+			log.Panicf("Error: %s is neither a file of read names nor a region chr, chr:pos or chr:start-end", spec)
+		}
+		log.Printf("Alignment dump:\treads overlapping %s", spec)
+	}
+	ALN_DUMP = D
+}
+
+//---------------------------------------------------------------------------------------------------
+// ParseRegion parses a region "chr", "chr:pos" or "chr:start-end" (1-based, end inclusive; 0 as
+// the end of a whole chromosome).
+//---------------------------------------------------------------------------------------------------
+func ParseRegion(region string) (string, int, int, bool) {
+	i := strings.LastIndexByte(region, ':')
+	if i < 0 {
+		return region, 1, 0, region != ""
+	}
+	chr, rng := region[:i], strings.Replace(region[i+1:], ",", "", -1)
+	s, e := rng, rng
+	if j := strings.IndexByte(rng, '-'); j >= 0 {
+		s, e = rng[:j], rng[j+1:]
+	}
+	start, e1 := strconv.Atoi(s)
+	end, e2 := strconv.Atoi(e)
+	if chr == "" || e1 != nil || e2 != nil || start < 1 || end < start {
+		return "", 0, 0, false
+	}
+	return chr, start, end, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// Open creates the dump file.
+//---------------------------------------------------------------------------------------------------
+func (D *AlnDump) Open(file_name string) {
+	D.out, D.num = CreateOutput(file_name), 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// Close closes the dump file.
+//---------------------------------------------------------------------------------------------------
+func (D *AlnDump) Close() {
+	D.out.Close()
+	log.Printf("Alignments of %d selected read pairs are dumped to:\t%s", D.num, PARA.Dump_file)
+}
+
+//---------------------------------------------------------------------------------------------------
+// DumpSelected checks if a read pair is selected by its name (the header of the first end) or if one
+// of its ends (starting at start1 and start2 on the multigenome) overlaps the region.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) DumpSelected(info []byte, start1, len1, start2, len2 int) bool {
+	D := ALN_DUMP
+	if D.names != nil {
+		return D.names[string(seqio.MateName(info))]
+	}
+	return VC.InRegion(start1, len1) || VC.InRegion(start2, len2)
+}
+
+//---------------------------------------------------------------------------------------------------
+// InRegion checks if an aligned read-end overlaps the region of the dump.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) InRegion(start, length int) bool {
+	D := ALN_DUMP
+	if start < 0 || start >= VC.SeqLen {
+		return false
+	}
+	chr, s := VC.ChrLoc(start)
+	if chr != D.chr {
+		return false
+	}
+	return D.end == 0 || (s <= D.end && s+length-1 >= D.start)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlignedStrings returns the reference, the match line and the read (and its qualities) of an
+// aligned read-end starting at start on the multigenome, with gaps ('-') at indels of its variants
+// (as they are laid out in pileups).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AlignedStrings(read, qual []byte, start int, vars []*VarInfo) ([]byte, []byte, []byte, []byte) {
+	indels := make(map[int]*VarInfo)
+	for _, v := range vars {
+		if v.Type == 1 || v.Type == 2 {
+			indels[int(v.Pos)] = v
+		}
+	}
+	var aln_ref, aln_match, aln_read, aln_qual []byte
+	for i, pos := 0, start; i < len(read) && pos < VC.SeqLen; i, pos = i+1, pos+1 {
+		if pos < 0 {
+			continue
+		}
+		ref_base := VC.RefBase(pos)[0]
+		aln_ref, aln_read, aln_qual = append(aln_ref, ref_base), append(aln_read, read[i]), append(aln_qual, qual[i])
+		if bytes.EqualFold([]byte{ref_base}, []byte{read[i]}) {
+			aln_match = append(aln_match, '|')
+		} else {
+			aln_match = append(aln_match, '.')
+		}
+		v, ok := indels[pos]
+		if !ok {
+			continue
+		}
+		if var_arr := strings.Split(string(v.Bases), "|"); v.Type == 1 {
+			ins_len := MinInt(len(var_arr[1])-1, len(read)-i-1)
+			for k := 1; k <= ins_len; k++ {
+				aln_ref, aln_match = append(aln_ref, '-'), append(aln_match, ' ')
+				aln_read, aln_qual = append(aln_read, read[i+k]), append(aln_qual, qual[i+k])
+			}
+			i += ins_len
+		} else {
+			del := var_arr[0][1:]
+			for k := 1; k <= len(del) && pos+k < VC.SeqLen; k++ {
+				aln_ref, aln_match = append(aln_ref, VC.RefBase(pos+k)[0]), append(aln_match, ' ')
+				aln_read, aln_qual = append(aln_read, '-'), append(aln_qual, ' ')
+			}
+			pos += len(del)
+		}
+	}
+	return aln_ref, aln_match, aln_read, aln_qual
+}
+
+//---------------------------------------------------------------------------------------------------
+// DumpEnd writes the alignment of a read-end and its aligned bases used as evidence.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) DumpEnd(w *bytes.Buffer, end int, read, qual []byte, start int, strand bool, aln_dist float64, vars []*VarInfo) {
+	aln_ref, aln_match, aln_read, aln_qual := VC.AlignedStrings(read, qual, start, vars)
+	chr, s := VC.ChrLoc(MaxInt(start, 0))
+	ref_len := len(aln_ref) - bytes.Count(aln_ref, []byte{'-'})
+	fmt.Fprintf(w, "end %d: %s:%d-%d (%s), alignment distance %.2f\n", end, chr, s, s+ref_len-1, string(StrandBase('+', '-', strand)), aln_dist)
+	fmt.Fprintf(w, "  ref   %s\n        %s\n  read  %s\n  qual  %s\n", aln_ref, aln_match, aln_read, aln_qual)
+	for _, v := range vars {
+		v_chr, v_pos := VC.ChrLoc(int(v.Pos))
+		fmt.Fprintf(w, "  evidence  %s:%d  %s  %s  qual %s\n", v_chr, v_pos, VAR_TYPE_NAMES[MinInt(v.Type, len(VAR_TYPE_NAMES)-1)], v.Bases, v.BQual)
+	}
+}
+
+// Names of types of aligned bases
+var VAR_TYPE_NAMES = []string{"sub", "ins", "del", "other"}
+
+//---------------------------------------------------------------------------------------------------
+// DumpAlignment writes the alignment of a selected read pair to the dump file.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) DumpAlignment(read_info *ReadInfo, info []byte, cov_start1, cov_start2 int, strand1, strand2 bool,
+	aln_dist1, aln_dist2, map_qual float64, tie_num int, vars1, vars2 []*VarInfo) {
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "%s\tpaired distance %.2f, mapping quality %.2f, %d tied alignments\n", bytes.TrimSpace(info), aln_dist1+aln_dist2, map_qual, tie_num)
+	if strand1 {
+		VC.DumpEnd(&w, 1, read_info.Read1, read_info.Qual1, cov_start1, strand1, aln_dist1, vars1)
+	} else {
+		VC.DumpEnd(&w, 1, read_info.Rev_comp_read1, read_info.Rev_qual1, cov_start1, strand1, aln_dist1, vars1)
+	}
+	if strand2 {
+		VC.DumpEnd(&w, 2, read_info.Read2, read_info.Qual2, cov_start2, strand2, aln_dist2, vars2)
+	} else {
+		VC.DumpEnd(&w, 2, read_info.Rev_comp_read2, read_info.Rev_qual2, cov_start2, strand2, aln_dist2, vars2)
+	}
+	w.WriteString("\n")
+	D := ALN_DUMP
+	D.mut.Lock()
+	defer D.mut.Unlock()
+	D.out.Writer.Write(w.Bytes())
+	D.num++
+}
@@ -0,0 +1,196 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: errrate.go
+// Estimation of the sequencing error rate and the mutation rate. The mutation rate is estimated from
+// the density of known variants of the variant profile over the multigenome. In a warm-up phase, the
+// first read pairs are aligned (without calling variants), and the error rate is estimated as the rate
+// of mismatches at positions without known variants among aligned bases. The threshold of alignment
+// distances and the number of random iterations are computed from the estimated rates (if they are not
+// given in input). Insert sizes of mate-pair libraries and error rates of sequencing cycles (see
+// cycle.go) are also estimated in the warm-up phase.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	MIN_ERR_RATE = 0.0001 // minimum estimated sequencing error rate
+	MAX_ERR_RATE = 0.05   // maximum estimated sequencing error rate
+)
+
+//---------------------------------------------------------------------------------------------------
+// WarmUpStat represents numbers of aligned bases and mismatches (overall and by sequencing cycle), and
+// insert sizes (of mate-pair libraries) of reads aligned in the warm-up phase.
+//---------------------------------------------------------------------------------------------------
+type WarmUpStat struct {
+	BaseNum   int64      // number of aligned bases
+	MisNum    int64      // number of mismatches at positions without known variants
+	LenNum    []int64    // number of aligned read-ends of each length (up to CYCLE_MAX_LEN)
+	CycMisNum []int64    // number of mismatches at positions without known variants at each sequencing cycle
+	InsSizes  [][]int    // insert sizes of aligned read pairs of each regime of proper read pairs
+	ins_lock  sync.Mutex // lock for InsSizes
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewWarmUpStat creates empty statistics of the warm-up phase.
+//---------------------------------------------------------------------------------------------------
+func NewWarmUpStat() *WarmUpStat {
+	return &WarmUpStat{LenNum: make([]int64, CYCLE_MAX_LEN+1), CycMisNum: make([]int64, CYCLE_MAX_LEN)}
+}
+
+// Statistics of the warm-up phase (nil: not in the warm-up phase, aligned reads are used for calling)
+var WARM_UP *WarmUpStat
+
+//---------------------------------------------------------------------------------------------------
+// AddWarmUp counts aligned bases and mismatches of an aligned read pair whose ends have lengths len1
+// and len2, mismatches are also counted by sequencing cycle.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddWarmUp(len1, len2 int, vars1, vars2 []*VarInfo) {
+	mis_num, read_lens := 0, [2]int{len1, len2}
+	for e, vars := range [][]*VarInfo{vars1, vars2} {
+		read_len := read_lens[e]
+		if read_len <= CYCLE_MAX_LEN {
+			atomic.AddInt64(&WARM_UP.LenNum[read_len], 1)
+		}
+		for _, v := range vars {
+			if _, is_known_var := VC.Variants[int(v.Pos)]; !is_known_var && v.Type == 0 {
+				mis_num++
+				if read_len <= CYCLE_MAX_LEN && v.Cycle >= 0 && v.Cycle < read_len {
+					atomic.AddInt64(&WARM_UP.CycMisNum[v.Cycle], 1)
+				}
+			}
+		}
+	}
+	atomic.AddInt64(&WARM_UP.BaseNum, int64(len1+len2))
+	atomic.AddInt64(&WARM_UP.MisNum, int64(mis_num))
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddInsSize adds the insert size of an aligned read pair whose ends start at pos1 and pos2 to the
+// insert sizes of its regime.
+//---------------------------------------------------------------------------------------------------
+func (S *WarmUpStat) AddInsSize(pos1, pos2, len1, len2 int, strand1, strand2 bool) {
+	r_idx, ins := PairInsert(pos1, pos2, len1, len2, strand1, strand2, true)
+	if r_idx < 0 {
+		return
+	}
+	S.ins_lock.Lock()
+	for len(S.InsSizes) <= r_idx {
+		S.InsSizes = append(S.InsSizes, nil)
+	}
+	S.InsSizes[r_idx] = append(S.InsSizes[r_idx], ins)
+	S.ins_lock.Unlock()
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateErrRate aligns the first Warm_up read pairs of the first input FASTQ pair, estimates the
+// sequencing error rate and recomputes the threshold of alignment distances and the number of random
+// iterations from it if they are not given in input.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) EstimateErrRate() {
+	log.Printf("Estimating sequencing error rate from the first %d reads...", PARA.Warm_up)
+	files_1, files_2 := ReadFiles(PARA.Read_file_1), ReadFiles(PARA.Read_file_2)
+	reads_1, reads_2 := HeadRecords(files_1[0], files_2[0], PARA.Warm_up)
+
+	WARM_UP = NewWarmUpStat()
+	read_data := make(chan *ReadInfo, READ_POOL_MAX)
+	read_pool := NewReadPool(PARA.Proc_num)
+	go func() {
+		if e := VC.ReadPairedReads(bytes.NewReader(reads_1), bytes.NewReader(reads_2), files_1[0], files_2[0], 0, read_data, read_pool); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		close(read_data)
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < PARA.Proc_num; i++ {
+		wg.Add(1)
+		go VC.SearchVariants(read_data, read_pool, nil, &wg)
+	}
+	wg.Wait()
+	stat := WARM_UP
+	WARM_UP = nil
+	if PARA.Mate_pair {
+		EstimateInsSizes(stat.InsSizes)
+	}
+
+	if stat.BaseNum == 0 {
+		log.Printf("No reads are aligned in the warm-up phase, use default sequencing error rate (%g).", PARA.Err_rate)
+		return
+	}
+	err := math.Min(math.Max(float64(stat.MisNum)/float64(stat.BaseNum), MIN_ERR_RATE), MAX_ERR_RATE)
+	PARA.Err_rate = float32(err)
+	log.Printf("Number of aligned bases:\t%d, mismatches at positions without known variants:\t%d, estimated error rate:\t%g",
+		stat.BaseNum, stat.MisNum, err)
+	// Error rates of sequencing cycles are relative to the overall rate of mismatches
+	if PARA.Cycle_err {
+		CYCLE = EstimateCycleModel(stat.LenNum, stat.CycMisNum, float64(stat.MisNum)/float64(stat.BaseNum))
+		CYCLE.LogCycles()
+	}
+	SetupAlnThres()
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateMutRate estimates the mutation rate as the density of known variant locations over the
+// multigenome, and logs densities of chromosomes.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) EstimateMutRate() float32 {
+	chr_var_num := make([]int, len(VC.ChrPos))
+	for var_pos, _ := range VC.Variants {
+		chr_var_num[sort.SearchInts(VC.ChrPos, var_pos+1)-1]++
+	}
+	for chr_id, chr_start := range VC.ChrPos {
+		chr_end := VC.SeqLen
+		if chr_id+1 < len(VC.ChrPos) {
+			chr_end = VC.ChrPos[chr_id+1]
+		}
+		if chr_end > chr_start {
+			log.Printf("Density of known variants of %s:\t%g", VC.ChrName[chr_id], float64(chr_var_num[chr_id])/float64(chr_end-chr_start))
+		}
+	}
+	if VC.SeqLen == 0 {
+		return 0
+	}
+	return float32(len(VC.Variants)) / float32(VC.SeqLen)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupAlnThres computes the threshold of alignment distances and the number of random iterations
+// from the error and mutation rates, if they are not given in input.
+//---------------------------------------------------------------------------------------------------
+func SetupAlnThres() {
+	dist_thres, iter_num := AlnDistThres(PARA)
+	if PARA.Auto_dist_thres {
+		PARA.Dist_thres = dist_thres
+		log.Printf("Threshold of alignment distance is computed from error rate %g and mutation rate %g (%.1f).",
+			PARA.Err_rate, PARA.Mut_rate, PARA.Dist_thres)
+	}
+	if PARA.Auto_iter_num {
+		PARA.Iter_num = iter_num
+		log.Printf("Number of random iterations is computed from error rate %g and mutation rate %g (%d).",
+			PARA.Err_rate, PARA.Mut_rate, PARA.Iter_num)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlnDistThres computes the threshold of alignment distances and the number of random iterations from
+// the expected maximum number of differences (sequencing errors and mutations) between reads and the
+// multigenome, based on error and mutation rates and their standard variations.
+//---------------------------------------------------------------------------------------------------
+func AlnDistThres(para *ParaInfo) (float64, int) {
+	err := float64(para.Err_rate)
+	rlen := float64(para.Read_len)
+	mut := float64(para.Mut_rate)
+	k1 := float64(para.Err_var_factor)
+	k2 := float64(para.Mut_var_factor)
+	var_dist := int(math.Ceil(err*rlen+k1*math.Sqrt(rlen*err*(1-err)))) + int(math.Ceil(mut*rlen+k2*math.Sqrt(rlen*mut*(1-mut))))
+	dist_thres := -float64(var_dist)*math.Log10(1-err) - float64(var_dist)*math.Log10(NEW_INDEL_RATE)
+	return dist_thres, para.Iter_num_factor * (var_dist + 1)
+}
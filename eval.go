@@ -0,0 +1,215 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: eval.go
+// Concordance of variant calls with a truth set (e.g. GIAB). Variants of both VCF files are
+// normalized (common suffixes and prefixes of alleles are trimmed) and split into SNPs (alleles of the
+// same length) and indels; variants are compared by chromosome, position and alleles within confident
+// regions (BED file, optional). Precision, recall and F1 are reported for SNPs and indels.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var EVAL_TYPES = []string{"SNP", "INDEL"} // variant types of evaluation
+
+//---------------------------------------------------------------------------------------------------
+// EvalStat represents concordance counts of a variant type.
+//---------------------------------------------------------------------------------------------------
+type EvalStat struct {
+	TP, FP, FN int // numbers of true positives, false positives and false negatives
+}
+
+//---------------------------------------------------------------------------------------------------
+// Precision, Recall and F1 return concordance metrics (0 if undefined).
+//---------------------------------------------------------------------------------------------------
+func (S *EvalStat) Precision() float64 {
+	if S.TP+S.FP == 0 {
+		return 0
+	}
+	return float64(S.TP) / float64(S.TP+S.FP)
+}
+
+func (S *EvalStat) Recall() float64 {
+	if S.TP+S.FN == 0 {
+		return 0
+	}
+	return float64(S.TP) / float64(S.TP+S.FN)
+}
+
+func (S *EvalStat) F1() float64 {
+	p, r := S.Precision(), S.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Evaluate compares variant calls with a truth set within confident regions (all regions if bed_file
+// is empty) and returns concordance counts of each variant type.
+//---------------------------------------------------------------------------------------------------
+func Evaluate(call_file, truth_file, bed_file string) map[string]*EvalStat {
+	var regions map[string][][2]int
+	if bed_file != "" {
+		regions = LoadEvalRegions(bed_file)
+	}
+	calls, truths := LoadEvalVariants(call_file, regions, true), LoadEvalVariants(truth_file, regions, false)
+	stats := make(map[string]*EvalStat)
+	for _, t := range EVAL_TYPES {
+		stats[t] = new(EvalStat)
+	}
+	for key, t := range calls {
+		if _, ok := truths[key]; ok {
+			stats[t].TP++
+		} else {
+			stats[t].FP++
+		}
+	}
+	for key, t := range truths {
+		if _, ok := calls[key]; !ok {
+			stats[t].FN++
+		}
+	}
+	return stats
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteEvalReport writes concordance counts and metrics of each variant type in tab-delimited format.
+//---------------------------------------------------------------------------------------------------
+func WriteEvalReport(w io.Writer, stats map[string]*EvalStat) {
+	fmt.Fprintln(w, "TYPE\tTP\tFP\tFN\tPRECISION\tRECALL\tF1")
+	for _, t := range EVAL_TYPES {
+		s := stats[t]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.4f\t%.4f\t%.4f\n", t, s.TP, s.FP, s.FN, s.Precision(), s.Recall(), s.F1())
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// OpenTextInput opens a (possibly gzip-compressed) text input file.
+//---------------------------------------------------------------------------------------------------
+func OpenTextInput(file_name string) (*bufio.Scanner, func()) {
+	f, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(file_name, ".gz") {
+		if r, e = gzip.NewReader(f); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_LINE_SIZE)
+	return scanner, func() { f.Close() }
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadEvalRegions reads confident regions from a BED file, as sorted and merged intervals of each
+// chromosome.
+//---------------------------------------------------------------------------------------------------
+func LoadEvalRegions(file_name string) map[string][][2]int {
+	scanner, done := OpenTextInput(file_name)
+	defer done()
+	regions := make(map[string][][2]int)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 3 {
+			log.Panicf("Error: invalid line in region file %s (chrom, start and end are required): %s", file_name, line)
+		}
+		start, e1 := strconv.Atoi(tokens[1])
+		end, e2 := strconv.Atoi(tokens[2])
+		if e1 != nil || e2 != nil || start < 0 || end <= start {
+			log.Panicf("Error: invalid interval in region file %s: %s", file_name, line)
+		}
+		regions[tokens[0]] = append(regions[tokens[0]], [2]int{start, end})
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	for chr_name, ivs := range regions {
+		regions[chr_name] = MergeIntervals(ivs)
+	}
+	return regions
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadEvalVariants reads variants of a VCF file within regions (all variants if regions is nil), and
+// returns types of variants indexed by keys "chrom:pos:ref:alt" of normalized alleles. Records with
+// non-passing filters are skipped for variant calls, and homozygous-reference genotypes are skipped.
+//---------------------------------------------------------------------------------------------------
+func LoadEvalVariants(file_name string, regions map[string][][2]int, pass_only bool) map[string]string {
+	scanner, done := OpenTextInput(file_name)
+	defer done()
+	vars := make(map[string]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 5 {
+			log.Panicf("Error: invalid line in VCF file %s: %s", file_name, line)
+		}
+		if pass_only && len(tokens) > 6 && tokens[6] != "PASS" && tokens[6] != "." {
+			continue
+		}
+		if len(tokens) > 9 && strings.HasPrefix(tokens[8], "GT") {
+			gt := strings.Split(tokens[9], ":")[0]
+			if strings.Trim(gt, "0/|.") == "" {
+				continue
+			}
+		}
+		pos, e := strconv.Atoi(tokens[1])
+		if e != nil {
+			log.Panicf("Error: invalid position in VCF file %s: %s", file_name, line)
+		}
+		if regions != nil && !InIntervals(regions[tokens[0]], pos-1) {
+			continue
+		}
+		for _, alt := range strings.Split(tokens[4], ",") {
+			if alt == "." || alt == "*" || strings.HasPrefix(alt, "<") {
+				continue
+			}
+			v_pos, ref, alt := NormalizeAlleles(pos, strings.ToUpper(tokens[3]), strings.ToUpper(alt))
+			if ref == alt {
+				continue
+			}
+			v_type := "INDEL"
+			if len(ref) == len(alt) {
+				v_type = "SNP"
+			}
+			vars[tokens[0]+":"+strconv.Itoa(v_pos)+":"+ref+":"+alt] = v_type
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return vars
+}
+
+//---------------------------------------------------------------------------------------------------
+// NormalizeAlleles trims the common suffix and then the common prefix of two alleles (keeping at
+// least one base of each allele), and returns the adjusted position and alleles.
+//---------------------------------------------------------------------------------------------------
+func NormalizeAlleles(pos int, ref, alt string) (int, string, string) {
+	for len(ref) > 1 && len(alt) > 1 && ref[len(ref)-1] == alt[len(alt)-1] {
+		ref, alt = ref[:len(ref)-1], alt[:len(alt)-1]
+	}
+	for len(ref) > 1 && len(alt) > 1 && ref[0] == alt[0] {
+		ref, alt, pos = ref[1:], alt[1:], pos+1
+	}
+	return pos, ref, alt
+}
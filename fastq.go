@@ -0,0 +1,118 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: fastq.go
+// Reading FASTQ files with the validating parser (see seqio/fastq.go). Names of paired records of the
+// two FASTQ files are checked to match, to catch read files of different samples or runs. Malformed
+// records can be skipped; paired records are then re-paired by their names.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/namsyvo/IVC/seqio"
+)
+
+const (
+	MATE_CHECK_ERROR = "error" // stop at the first pair of records with different names
+	MATE_CHECK_WARN  = "warn"  // report pairs of records with different names and keep them
+	MATE_CHECK_OFF   = "off"   // do not check names of paired records
+	MATE_WARN_NUM    = 10      // maximum number of reported pairs of records with different names
+	MATE_RESYNC_NUM  = 1000    // maximum number of records dropped from a read file to re-pair records after skipped ones
+)
+
+//---------------------------------------------------------------------------------------------------
+// CheckMateCheck checks the mode of checking names of paired records.
+//---------------------------------------------------------------------------------------------------
+func CheckMateCheck(mode string) {
+	switch mode {
+	case MATE_CHECK_ERROR, MATE_CHECK_WARN, MATE_CHECK_OFF:
+	default:
+		log.Panicf("Error: unknown mode %s of checking mate names (supported modes: error, warn, off)", mode)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// HeadRecords returns the first pairs of records of two (local or remote) FASTQ files, as 4-line
+// records. Malformed records are skipped if required, and records are then re-paired by their names.
+//---------------------------------------------------------------------------------------------------
+func HeadRecords(file_name_1, file_name_2 string, rec_num int) ([]byte, []byte) {
+	f1, e := OpenInput(file_name_1)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f1.Close()
+	f2, e := OpenInput(file_name_2)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f2.Close()
+	var buf1, buf2 bytes.Buffer
+	F1, F2 := seqio.NewFastqReader(f1, file_name_1), seqio.NewFastqReader(f2, file_name_2)
+	F1.SetResync(PARA.Skip_bad_reads)
+	F2.SetResync(PARA.Skip_bad_reads)
+	for pair_num := 0; pair_num < rec_num; pair_num++ {
+		skip_num1, skip_num2 := F1.SkipNum(), F2.SkipNum()
+		if !F1.Next() || !F2.Next() {
+			break
+		}
+		if skip1, skip2 := F1.SkipNum() > skip_num1, F2.SkipNum() > skip_num2; skip1 || skip2 {
+			if _, ok := RepairMates(F1, F2, skip1, skip2); !ok {
+				break
+			}
+		}
+		WriteRecord(&buf1, F1)
+		WriteRecord(&buf2, F2)
+	}
+	if e = F1.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if e = F2.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return buf1.Bytes(), buf2.Bytes()
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteRecord writes the current record of a FASTQ parser as a 4-line record.
+//---------------------------------------------------------------------------------------------------
+func WriteRecord(buf *bytes.Buffer, F *seqio.FastqReader) {
+	buf.Write(F.Info)
+	buf.WriteString("\n")
+	buf.Write(F.Read)
+	buf.WriteString("\n+\n")
+	buf.Write(F.Qual)
+	buf.WriteString("\n")
+}
+
+//---------------------------------------------------------------------------------------------------
+// RepairMates re-pairs the current records of two FASTQ parsers by their names after malformed records
+// were skipped by one of them (skip1, skip2): records of the other parser (of the second parser if both
+// skipped records) are dropped until names match. It returns the number of dropped records, and false
+// if records cannot be re-paired within MATE_RESYNC_NUM records.
+//---------------------------------------------------------------------------------------------------
+func RepairMates(F1, F2 *seqio.FastqReader, skip1, skip2 bool) (int, bool) {
+	behind := F2
+	if skip2 && !skip1 {
+		behind = F1
+	}
+	drop_num := 0
+	for seqio.MateError(F1, F2) != nil {
+		if drop_num == MATE_RESYNC_NUM || !behind.Next() {
+			return drop_num, false
+		}
+		drop_num++
+	}
+	return drop_num, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// LogSkipped reports a malformed record skipped by a FASTQ parser (the first MATE_WARN_NUM ones).
+//---------------------------------------------------------------------------------------------------
+func LogSkipped(F *seqio.FastqReader) {
+	if F.SkipNum() <= MATE_WARN_NUM {
+		log.Printf("Warning: skipped %s", F.SkipErr())
+	}
+}
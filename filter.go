@@ -0,0 +1,219 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: filter.go
+// Hard filters of variant calls. Each filter is a simple expression "[NAME:]KEY OP VALUE" (e.g.
+// "LowQual:QUAL<20", "DP<5", "FS>60", "AD[1]<3"), where KEY is QUAL or an INFO or FORMAT field written
+// by IVC, and OP is one of <, <=, >, >=, ==, !=. Values of multi-valued fields are selected by index
+// (KEY[i]); for AD, ADF, ADR and AF, KEY alone is the sum of the alternate allele values. Unknown keys
+// are rejected when filters are set up. A variant call fails a filter if the expression is true;
+// filters on optional annotations (e.g. MQ, BaseQRankSum, flags) are not applied to sites without them.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Operators of filter expressions, two-character operators are checked first
+var FILTER_OPS = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// Words replacing operators in default filter names (VCF header lines do not allow '<' and '>' in IDs)
+var FILTER_OP_NAMES = strings.NewReplacer("<=", "_le_", ">=", "_ge_", "==", "_eq_", "!=", "_ne_", "<", "_lt_", ">", "_gt_")
+
+//---------------------------------------------------------------------------------------------------
+// FilterInfo represents a hard filter of variant calls.
+//---------------------------------------------------------------------------------------------------
+type FilterInfo struct {
+	Name  string  // filter name reported in FILTER column
+	Expr  string  // filter expression (without name)
+	Key   string  // key of the value to be compared (QUAL, INFO or FORMAT field)
+	Op    string  // comparison operator
+	Value float64 // threshold
+}
+
+// Keys of filters with the VCF numbers of their values (0: flags, R: one per allele, A: one per alternate
+// allele, G: one per genotype, n: fixed number)
+var FILTER_KEYS = map[string]string{
+	"QUAL": "1", "MQ": "1", "QD": "1", "FS": "1", "BaseQRankSum": "1", "ReadPosRankSum": "1", "DNP": "1", "RCS": "1",
+	"GQ": "1", "DP": "1", "KV": "0", "VP": "0", "MP": "0", "CP": "0", "DS": "0", "MV": "0", "DN": "0", "HP": "0",
+	"AD": "R", "ADF": "R", "ADR": "R", "AF": "A", "PL": "G", "SB": "4", "AFCI": "2",
+}
+
+// Hard filters applied to variant calls, set up from input parameters
+var FILTERS []*FilterInfo
+
+//---------------------------------------------------------------------------------------------------
+// ParseFilter parses a filter expression "[NAME:]KEY OP VALUE". If NAME is not given, the
+// expression with operators replaced by words (e.g. QUAL_lt_20) is used as the filter name.
+//---------------------------------------------------------------------------------------------------
+func ParseFilter(expr string) *FilterInfo {
+	filter := new(FilterInfo)
+	expr = strings.Replace(strings.TrimSpace(expr), " ", "", -1)
+	if i := strings.Index(expr, ":"); i >= 0 {
+		filter.Name, expr = expr[:i], expr[i+1:]
+	} else {
+		filter.Name = FILTER_OP_NAMES.Replace(expr)
+	}
+	filter.Expr = expr
+	for _, op := range FILTER_OPS {
+		if i := strings.Index(expr, op); i > 0 {
+			filter.Key, filter.Op = expr[:i], op
+			value, e := strconv.ParseFloat(expr[i+len(op):], 64)
+			if e != nil {
+				log.Panicf("Error: invalid value in filter expression %s: %s", expr, e)
+			}
+			filter.Value = value
+			break
+		}
+	}
+	if filter.Op == "" || filter.Name == "" || strings.ContainsAny(filter.Name, ";,<>=\t") {
+		log.Panicf("Error: invalid filter expression %s (format: [NAME:]KEY OP VALUE)", expr)
+	}
+	key, number := filter.Key, ""
+	if i := strings.Index(key, "["); i > 0 && key[len(key)-1] == ']' {
+		if idx, e := strconv.Atoi(key[i+1 : len(key)-1]); e != nil || idx < 0 {
+			log.Panicf("Error: invalid index in filter expression %s", expr)
+		}
+		if number = FILTER_KEYS[key[:i]]; number == "0" || number == "1" {
+			log.Panicf("Error: %s has a single value, it cannot be indexed in filter expression %s", key[:i], expr)
+		}
+	} else if number = FILTER_KEYS[key]; number == "G" || number == "2" || number == "4" {
+		log.Panicf("Error: %s has multiple values, use an index (%s[i]) in filter expression %s", key, key, expr)
+	}
+	if number == "" {
+		log.Panicf("Error: unknown key in filter expression %s", expr)
+	}
+	return filter
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupFilters sets up hard filters from comma-separated expressions and a filter file (one expression
+// per line, empty lines and lines starting with '#' are ignored).
+//---------------------------------------------------------------------------------------------------
+func SetupFilters(filter_expr, filter_file string) []*FilterInfo {
+	var exprs []string
+	if filter_expr != "" {
+		exprs = append(exprs, strings.Split(filter_expr, ",")...)
+	}
+	if filter_file != "" {
+		f, e := os.Open(filter_file)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && line[0] != '#' {
+				exprs = append(exprs, line)
+			}
+		}
+		if e = scanner.Err(); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	filters := make([]*FilterInfo, 0)
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) != "" {
+			filters = append(filters, ParseFilter(expr))
+		}
+	}
+	return filters
+}
+
+//---------------------------------------------------------------------------------------------------
+// Fail determines whether a variant call fails the filter, given values of the call.
+//---------------------------------------------------------------------------------------------------
+func (F *FilterInfo) Fail(values map[string]float64) bool {
+	value, ok := values[F.Key]
+	if !ok {
+		return false
+	}
+	switch F.Op {
+	case "<":
+		return value < F.Value
+	case "<=":
+		return value <= F.Value
+	case ">":
+		return value > F.Value
+	case ">=":
+		return value >= F.Value
+	case "==":
+		return value == F.Value
+	case "!=":
+		return value != F.Value
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// FilterValues collects values of a variant call used by filters from QUAL, INFO and FORMAT fields.
+// Values of multi-valued fields are stored as KEY[i], and the sums of alternate allele values of
+// per-allele fields as KEY.
+//---------------------------------------------------------------------------------------------------
+func FilterValues(qual float64, str_info, format_keys, format_values string) map[string]float64 {
+	values := make(map[string]float64)
+	values["QUAL"] = qual
+	for _, field := range strings.Split(str_info, ";") {
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			AddFilterValues(values, kv[0], kv[1])
+		} else if field != "" {
+			values[field] = 1 // flags
+		}
+	}
+	keys, fields := strings.Split(format_keys, ":"), strings.Split(format_values, ":")
+	for i := 0; i < len(keys) && i < len(fields); i++ {
+		AddFilterValues(values, keys[i], fields[i])
+	}
+	return values
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddFilterValues adds numeric values of a field (comma-separated for multi-valued fields) to values
+// used by filters. Missing values (".") are skipped.
+//---------------------------------------------------------------------------------------------------
+func AddFilterValues(values map[string]float64, key, str_value string) {
+	number := FILTER_KEYS[key]
+	if number == "" || number == "0" || number == "1" {
+		if value, e := strconv.ParseFloat(str_value, 64); e == nil {
+			values[key] = value
+		}
+		return
+	}
+	sum, n := 0.0, 0
+	for i, s := range strings.Split(str_value, ",") {
+		value, e := strconv.ParseFloat(s, 64)
+		if e != nil {
+			continue
+		}
+		values[key+"["+strconv.Itoa(i)+"]"] = value
+		if (number == "R" && i > 0) || number == "A" {
+			sum += value
+			n++
+		}
+	}
+	if n > 0 {
+		values[key] = sum
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// ApplyFilters returns value of FILTER column of a variant call: "PASS" or names of failed filters.
+//---------------------------------------------------------------------------------------------------
+func ApplyFilters(filters []*FilterInfo, values map[string]float64) string {
+	failed := make([]string, 0)
+	for _, filter := range filters {
+		if filter.Fail(values) {
+			failed = append(failed, filter.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return "PASS"
+	}
+	return strings.Join(failed, ";")
+}
@@ -0,0 +1,136 @@
+//----------------------------------------------------------------------------------------
+// IVC: bidir.go
+// Bidirectional FM-index. The FM-index of the reverse text (with its suffix array) is
+// combined with count and occurrence tables of the BWT of the text itself (without a
+// suffix array). Matches of a pattern are kept as synchronized ranges of rows of the
+// two: the pattern is extended to the right by backward search on the reverse text and
+// to the left by backward search on the text, and matches are located with the suffix
+// array of the reverse text. Tables of the text are saved with the prefix FWD_PREFIX
+// in the directory of the FM-index of the reverse text.
+// Copyright 2015 Nam Sy Vo.
+//----------------------------------------------------------------------------------------
+
+package fmi
+
+import (
+	"os"
+	"path"
+)
+
+const FWD_PREFIX = "fwd." // prefix of files of tables of the text in the index directory
+
+//-----------------------------------------------------------------------------
+// BiIndex represents a bidirectional FM-index.
+
+type BiIndex struct {
+	Rev *Index // FM-index of the reverse text
+	Fwd *Index // count and occurrence tables of the text (no suffix array)
+}
+
+//-----------------------------------------------------------------------------
+// BiRange represents matches of a pattern: rows Sp..Ep of the reverse FM-index
+// (suffixes starting with the reversed pattern) and the first row FwdSp of the range
+// of the same size of the text (suffixes starting with the pattern).
+
+type BiRange struct {
+	Sp, Ep, FwdSp uint32
+}
+
+//-----------------------------------------------------------------------------
+// NewFwd builds count and occurrence tables of a text (its suffix array is dropped).
+
+func NewFwd(seq []byte) *Index {
+	I := New(seq)
+	I.SA = nil
+	return I
+}
+
+//-----------------------------------------------------------------------------
+// SaveFwd saves tables of the text into the directory of the reverse FM-index.
+
+func (I *Index) SaveFwd(dir string) {
+	I.save(dir, FWD_PREFIX)
+}
+
+//-----------------------------------------------------------------------------
+// LoadFwd loads tables of the text from the directory of the reverse FM-index.
+
+func LoadFwd(dirname string) *Index {
+	return load(dirname, FWD_PREFIX, false)
+}
+
+//-----------------------------------------------------------------------------
+// HasFwd determines whether the FM-index in a directory is bidirectional.
+
+func HasFwd(dirname string) bool {
+	_, err := os.Stat(path.Join(dirname, FWD_PREFIX+"others"))
+	return err == nil
+}
+
+//-----------------------------------------------------------------------------
+// Start returns the range of matches of a symbol.
+
+func (B *BiIndex) Start(c byte) (BiRange, bool) {
+	sp, ok := B.Rev.C[c]
+	if !ok {
+		return BiRange{}, false
+	}
+	return BiRange{sp, B.Rev.EP[c], B.Fwd.C[c]}, true
+}
+
+//-----------------------------------------------------------------------------
+// ExtendRight extends matches of a pattern P to matches of Pc. Matches of P followed
+// by the end of the text or by smaller symbols precede those of Pc in the text.
+
+func (B *BiIndex) ExtendRight(r BiRange, c byte) (BiRange, bool) {
+	sp, ep, ok := B.Rev.step(r.Sp, r.Ep, c)
+	if !ok {
+		return r, false
+	}
+	return BiRange{sp, ep, r.FwdSp + B.Rev.count_smaller(r.Sp, r.Ep, c)}, true
+}
+
+//-----------------------------------------------------------------------------
+// ExtendLeft extends matches of a pattern P to matches of cP (as ExtendRight, with
+// roles of the two indexes swapped).
+
+func (B *BiIndex) ExtendLeft(r BiRange, c byte) (BiRange, bool) {
+	fwd_ep := r.FwdSp + r.Ep - r.Sp
+	fwd_sp, fwd_ep0, ok := B.Fwd.step(r.FwdSp, fwd_ep, c)
+	if !ok {
+		return r, false
+	}
+	sp := r.Sp + B.Fwd.count_smaller(r.FwdSp, fwd_ep, c)
+	return BiRange{sp, sp + fwd_ep0 - fwd_sp, fwd_sp}, true
+}
+
+//-----------------------------------------------------------------------------
+// step returns the range of rows of a backward search step from rows sp..ep with a
+// symbol (rows of non-empty ranges are never 0, which is the row of "$").
+
+func (I *Index) step(sp, ep uint32, c byte) (uint32, uint32, bool) {
+	offset, ok := I.C[c]
+	if !ok {
+		return sp, ep, false
+	}
+	sp0, ep0 := offset+I.OCC[c][sp-1], offset+I.OCC[c][ep]-1
+	return sp0, ep0, sp0 <= ep0
+}
+
+//-----------------------------------------------------------------------------
+// count_smaller returns the number of rows sp..ep preceded by "$" or by bases smaller
+// than a symbol (other symbols are larger than bases).
+
+func (I *Index) count_smaller(sp, ep uint32, c byte) uint32 {
+	var n uint32
+	if I.END_POS >= sp && I.END_POS <= ep {
+		n++
+	}
+	for _, symb := range I.SYMBOLS[0:4] {
+		if byte(symb) >= c {
+			break
+		}
+		n += I.OCC[byte(symb)][ep] - I.OCC[byte(symb)][sp-1]
+	}
+	return n
+}
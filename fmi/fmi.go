@@ -25,11 +25,15 @@ import (
 var SEQ []byte
 
 type Index struct {
-	SA  []uint32          // suffix array
+	SA  []uint32          // suffix array (entries of sampled rows if the suffix array is sampled)
 	OCC map[byte][]uint32 // occurence table
 	C   map[byte]uint32   // count table
 	EP  map[byte]uint32   // ending row/position of each symbol
 
+	SA_rate uint32   // sampling rate of suffix array (1: full suffix array)
+	SA_mark []uint32 // bit vector of sampled rows
+	SA_rank []uint32 // number of sampled rows before every SA_BLOCK words of SA_mark
+
 	LEN     uint32
 	END_POS uint32          // position of "$" in the text
 	SYMBOLS []int           // sorted symbols
@@ -49,6 +53,7 @@ func check_for_error(e error) {
 
 func New(seq []byte) *Index {
 	I := new(Index)
+	I.SA_rate = 1
 	GetSeq(seq)
 	log.Println("Building suffix array...")
 	I.build_suffix_array()
@@ -66,11 +71,54 @@ type Symb_OCC struct {
 	OCC  []uint32
 }
 
+//-----------------------------------------------------------------------------
+// Load header of FM index (length, sampling rate and symbols, from "others"),
+// without loading suffix array and OCC. Usage:  idx := LoadHeader(index_file)
+func LoadHeader(dirname string) *Index {
+	return load_header(dirname, "")
+}
+
+//-----------------------------------------------------------------------------
+// load_header loads the header from the file "others" with a prefix (see FWD_PREFIX).
+func load_header(dirname, prefix string) *Index {
+
+	I := new(Index)
+	f, err := os.Open(path.Join(dirname, prefix+"others"))
+	check_for_error(err)
+	defer f.Close()
+
+	var symb byte
+	var freq, c, ep uint32
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	// Sampling rate of suffix array is not given in indexes with full suffix arrays
+	if n, _ := fmt.Sscanf(scanner.Text(), "%d%d%d", &I.LEN, &I.END_POS, &I.SA_rate); n < 3 || I.SA_rate < 1 {
+		I.SA_rate = 1
+	}
+
+	I.Freq = make(map[byte]uint32)
+	I.C = make(map[byte]uint32)
+	I.EP = make(map[byte]uint32)
+	for scanner.Scan() {
+		fmt.Sscanf(scanner.Text(), "%c%d%d%d", &symb, &freq, &c, &ep)
+		I.SYMBOLS = append(I.SYMBOLS, int(symb))
+		I.Freq[symb], I.C[symb], I.EP[symb] = freq, c, ep
+	}
+	return I
+}
+
 //-----------------------------------------------------------------------------
 // Load FM index. Usage:  idx := Load(index_file)
 func Load(dirname string) *Index {
+	return load(dirname, "", true)
+}
 
-	I := new(Index)
+//-----------------------------------------------------------------------------
+// load loads an FM index from files with a prefix, with or without suffix array.
+func load(dirname, prefix string, with_sa bool) *Index {
+
+	// First, load "others"
+	I := load_header(dirname, prefix)
 
 	_load_slice := func(filename string, length uint32) []uint32 {
 		f, err := os.Open(filename)
@@ -98,39 +146,30 @@ func Load(dirname string) *Index {
 		return v
 	}
 
-	// First, load "others"
-	f, err := os.Open(path.Join(dirname, "others"))
-	check_for_error(err)
-	defer f.Close()
-
-	var symb byte
-	var freq, c, ep uint32
-	scanner := bufio.NewScanner(f)
-	scanner.Scan()
-	fmt.Sscanf(scanner.Text(), "%d%d\n", &I.LEN, &I.END_POS)
-
-	I.Freq = make(map[byte]uint32)
-	I.C = make(map[byte]uint32)
-	I.EP = make(map[byte]uint32)
-	for scanner.Scan() {
-		fmt.Sscanf(scanner.Text(), "%c%d%d%d", &symb, &freq, &c, &ep)
-		I.SYMBOLS = append(I.SYMBOLS, int(symb))
-		I.Freq[symb], I.C[symb], I.EP[symb] = freq, c, ep
-	}
-
 	// Second, load Suffix array and OCC
 	I.OCC = make(map[byte][]uint32)
 	var wg sync.WaitGroup
 	wg.Add(5)
 	go func() {
 		defer wg.Done()
-		I.SA = _load_slice(path.Join(dirname, "sa"), I.LEN)
+		if !with_sa {
+			return
+		}
+		if I.SA_rate > 1 {
+			fi, err := os.Stat(path.Join(dirname, "sa"))
+			check_for_error(err)
+			I.SA = _load_slice(path.Join(dirname, "sa"), uint32(fi.Size()/4))
+			I.SA_mark = _load_slice(path.Join(dirname, SA_MARK_FILE), (I.LEN+31)/32)
+			I.build_sa_rank()
+		} else {
+			I.SA = _load_slice(path.Join(dirname, "sa"), I.LEN)
+		}
 	}()
 	Symb_OCC_chan := make(chan Symb_OCC)
 	for _, symb := range I.SYMBOLS[0:4] {
 		go func(symb int) {
 			defer wg.Done()
-			Symb_OCC_chan <- Symb_OCC{symb, _load_slice(path.Join(dirname, "occ."+string(symb)), I.LEN)}
+			Symb_OCC_chan <- Symb_OCC{symb, _load_slice(path.Join(dirname, prefix+"occ."+string(symb)), I.LEN)}
 		}(symb)
 	}
 	go func() {
@@ -146,6 +185,14 @@ func Load(dirname string) *Index {
 
 //-----------------------------------------------------------------------------
 func (I *Index) Save(dirname string) {
+	dir := dirname + ".index"
+	os.Mkdir(dir, 0777)
+	I.save(dir, "")
+}
+
+//-----------------------------------------------------------------------------
+// save saves an FM index into files with a prefix, the suffix array is saved if it is kept.
+func (I *Index) save(dir, prefix string) {
 
 	_save_slice := func(s []uint32, filename string) {
 		f, err := os.Create(filename)
@@ -156,29 +203,36 @@ func (I *Index) Save(dirname string) {
 		w.Flush()
 	}
 
-	dir := dirname + ".index"
-	os.Mkdir(dir, 0777)
-
 	var wg sync.WaitGroup
 	wg.Add(5)
 
 	go func() {
 		defer wg.Done()
+		if I.SA == nil {
+			return
+		}
 		_save_slice(I.SA, path.Join(dir, "sa"))
+		if I.SA_rate > 1 {
+			_save_slice(I.SA_mark, path.Join(dir, SA_MARK_FILE))
+		}
 	}()
 
 	for symb := range I.OCC {
 		go func(symb byte) {
 			defer wg.Done()
-			_save_slice(I.OCC[symb], path.Join(dir, "occ."+string(symb)))
+			_save_slice(I.OCC[symb], path.Join(dir, prefix+"occ."+string(symb)))
 		}(symb)
 	}
 
-	f, err := os.Create(path.Join(dir, "others"))
+	f, err := os.Create(path.Join(dir, prefix+"others"))
 	check_for_error(err)
 	defer f.Close()
 	w := bufio.NewWriter(f)
-	fmt.Fprintf(w, "%d %d\n", I.LEN, I.END_POS)
+	if I.SA_rate > 1 {
+		fmt.Fprintf(w, "%d %d %d\n", I.LEN, I.END_POS, I.SA_rate)
+	} else {
+		fmt.Fprintf(w, "%d %d\n", I.LEN, I.END_POS)
+	}
 	for i := 0; i < len(I.SYMBOLS); i++ {
 		symb := byte(I.SYMBOLS[i])
 		fmt.Fprintf(w, "%s %d %d %d\n", string(symb), I.Freq[symb], I.C[symb], I.EP[symb])
@@ -0,0 +1,126 @@
+//----------------------------------------------------------------------------------------
+// IVC: sample.go
+// Sampled suffix array. Only suffix array entries of rows whose text positions are
+// multiples of the sampling rate are kept; other entries are recovered by LF-mapping
+// walks to a sampled row. Rows which start with a base but are preceded by a symbol
+// without occurrence table (N, '*', '$' or other symbols) are also sampled, so that walks
+// never need to go through these symbols. Sampled rows are marked in a bit vector with
+// rank counts of every SA_BLOCK words.
+// Copyright 2015 Nam Sy Vo.
+//----------------------------------------------------------------------------------------
+
+package fmi
+
+import (
+	"bufio"
+	"fmt"
+	"math/bits"
+	"os"
+	"path"
+)
+
+const (
+	SA_MARK_FILE = "sa.mark" // file of the bit vector of sampled rows
+	SA_BLOCK     = 8         // number of 32-bit words of the bit vector between two rank counts
+)
+
+//-----------------------------------------------------------------------------
+// SampleSA keeps suffix array entries of sampled rows (no sampling if rate <= 1).
+
+func (I *Index) SampleSA(rate int) {
+	I.SA_rate = 1
+	if rate <= 1 {
+		return
+	}
+	I.SA_rate = uint32(rate)
+	I.SA_mark = make([]uint32, (I.LEN+31)/32)
+	sa := make([]uint32, 0, I.LEN/I.SA_rate+1)
+	for i := uint32(0); i < I.LEN; i++ {
+		prev := SEQ[I.LEN-1]
+		if I.SA[i] > 0 {
+			prev = SEQ[I.SA[i]-1]
+		}
+		if I.SA[i]%I.SA_rate == 0 || (IsBase(SEQ[I.SA[i]]) && !IsBase(prev)) {
+			I.SA_mark[i/32] |= 1 << (i % 32)
+			sa = append(sa, I.SA[i])
+		}
+	}
+	I.SA = sa
+	I.build_sa_rank()
+}
+
+//-----------------------------------------------------------------------------
+// Locate returns the text position of the suffix at a row.
+
+func (I *Index) Locate(i uint32) uint32 {
+	if I.SA_rate <= 1 {
+		return I.SA[i]
+	}
+	var steps uint32
+	for I.SA_mark[i/32]&(1<<(i%32)) == 0 {
+		c := I.bwt(i)
+		i = I.C[c] + I.OCC[c][i] - 1
+		steps++
+	}
+	return I.SA[I.sa_rank(i)] + steps
+}
+
+//-----------------------------------------------------------------------------
+// bwt returns the BWT symbol of a row which is preceded by a base.
+
+func (I *Index) bwt(i uint32) byte {
+	for _, symb := range I.SYMBOLS[0:4] {
+		c := byte(symb)
+		if (i == 0 && I.OCC[c][0] == 1) || (i > 0 && I.OCC[c][i] != I.OCC[c][i-1]) {
+			return c
+		}
+	}
+	panic(fmt.Sprintf("row %d is not preceded by a base", i))
+}
+
+//-----------------------------------------------------------------------------
+// build_sa_rank counts sampled rows before every SA_BLOCK words of the bit vector.
+
+func (I *Index) build_sa_rank() {
+	I.SA_rank = make([]uint32, (len(I.SA_mark)+SA_BLOCK-1)/SA_BLOCK)
+	var count uint32
+	for w, word := range I.SA_mark {
+		if w%SA_BLOCK == 0 {
+			I.SA_rank[w/SA_BLOCK] = count
+		}
+		count += uint32(bits.OnesCount32(word))
+	}
+}
+
+//-----------------------------------------------------------------------------
+// sa_rank returns the number of sampled rows before a row.
+
+func (I *Index) sa_rank(i uint32) uint32 {
+	w := i / 32
+	r := I.SA_rank[w/SA_BLOCK]
+	for k := w - w%SA_BLOCK; k < w; k++ {
+		r += uint32(bits.OnesCount32(I.SA_mark[k]))
+	}
+	return r + uint32(bits.OnesCount32(I.SA_mark[w]&(1<<(i%32)-1)))
+}
+
+//-----------------------------------------------------------------------------
+// IsBase determines whether a symbol is one of the bases A, C, G, T.
+
+func IsBase(c byte) bool {
+	return c == 'A' || c == 'C' || c == 'G' || c == 'T'
+}
+
+//-----------------------------------------------------------------------------
+// Sampled determines whether the FM-index in a directory has a sampled suffix array.
+
+func Sampled(dirname string) bool {
+	f, err := os.Open(path.Join(dirname, "others"))
+	check_for_error(err)
+	defer f.Close()
+	var seq_len, end_pos, rate uint32
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	n, _ := fmt.Sscanf(scanner.Text(), "%d%d%d", &seq_len, &end_pos, &rate)
+	return n == 3 && rate > 1
+}
@@ -0,0 +1,361 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: genostore.go
+// Compact storage of genotype values (prior/posterior probabilities and likelihoods) of variant
+// locations. Instead of nested maps (a map of positions to a map of genotypes per location), positions
+// are grouped in blocks of GENO_BLOCK_LEN consecutive positions; a block keeps sorted offsets of its
+// variant locations and, in the same order, small tables of genotypes with their values (two parallel
+// arrays sorted by genotypes). This cuts the overhead of a hash map per location on whole-genome runs
+// and keeps values of nearby locations together in memory. Statistics of aligned reads at variant
+// locations (types of genotypes, numbers of aligned reads of alleles, annotations and likelihoods) are
+// stored by blocks of positions in the same way (see SiteStore), with counts of alleles in small
+// sorted tables.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"sort"
+
+	"github.com/namsyvo/IVC/caller"
+)
+
+// Number of consecutive positions of a block of the genotype store
+const GENO_BLOCK_BITS = 12
+const GENO_BLOCK_LEN = 1 << GENO_BLOCK_BITS
+
+//---------------------------------------------------------------------------------------------------
+// GenoTable represents genotypes of a variant location (keys "hap1|hap2") and their values, sorted by
+// genotypes.
+//---------------------------------------------------------------------------------------------------
+type GenoTable struct {
+	Gts  []string  // genotypes in sorted order
+	Vals []float64 // values of genotypes
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewGenoTable returns the table of genotypes and their values from a map of genotypes.
+//---------------------------------------------------------------------------------------------------
+func NewGenoTable(m map[string]float64) GenoTable {
+	var T GenoTable
+	if len(m) == 0 {
+		return T
+	}
+	T.Gts, T.Vals = make([]string, 0, len(m)), make([]float64, len(m))
+	for gt, _ := range m {
+		T.Gts = append(T.Gts, gt)
+	}
+	sort.Strings(T.Gts)
+	for i, gt := range T.Gts {
+		T.Vals[i] = m[gt]
+	}
+	return T
+}
+
+//---------------------------------------------------------------------------------------------------
+// Map returns a map of genotypes and their values of the table (for updating them).
+//---------------------------------------------------------------------------------------------------
+func (T GenoTable) Map() map[string]float64 {
+	m := make(map[string]float64, len(T.Gts))
+	for i, gt := range T.Gts {
+		m[gt] = T.Vals[i]
+	}
+	return m
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns the value of a genotype, and false if the genotype is not in the table.
+//---------------------------------------------------------------------------------------------------
+func (T GenoTable) Get(gt string) (float64, bool) {
+	i := sort.SearchStrings(T.Gts, gt)
+	if i < len(T.Gts) && T.Gts[i] == gt {
+		return T.Vals[i], true
+	}
+	return 0, false
+}
+
+//---------------------------------------------------------------------------------------------------
+// GenoBlock represents variant locations of a block of positions and their tables of genotypes.
+//---------------------------------------------------------------------------------------------------
+type GenoBlock struct {
+	offs   []uint16    // sorted offsets of variant locations in the block
+	tables []GenoTable // tables of genotypes of variant locations
+}
+
+//---------------------------------------------------------------------------------------------------
+// GenoStore represents tables of genotypes of variant locations stored by blocks of positions.
+//---------------------------------------------------------------------------------------------------
+type GenoStore struct {
+	blocks map[uint32]*GenoBlock // blocks of positions (by positions divided by GENO_BLOCK_LEN)
+	num    int                   // number of variant locations
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewGenoStore creates an empty genotype store.
+//---------------------------------------------------------------------------------------------------
+func NewGenoStore() *GenoStore {
+	return &GenoStore{blocks: make(map[uint32]*GenoBlock)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// find returns the block of a position and the index of the position in the block (where it would be
+// inserted if it is not a variant location), and whether the position is a variant location.
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) find(pos uint32) (*GenoBlock, int, bool) {
+	B := S.blocks[pos>>GENO_BLOCK_BITS]
+	if B == nil {
+		return nil, 0, false
+	}
+	off := uint16(pos & (GENO_BLOCK_LEN - 1))
+	i := sort.Search(len(B.offs), func(k int) bool { return B.offs[k] >= off })
+	return B, i, i < len(B.offs) && B.offs[i] == off
+}
+
+//---------------------------------------------------------------------------------------------------
+// Has checks if a position is a variant location of the store.
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) Has(pos uint32) bool {
+	_, _, ok := S.find(pos)
+	return ok
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns the table of genotypes of a variant location, and false if the position is not a
+// variant location of the store.
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) Get(pos uint32) (GenoTable, bool) {
+	B, i, ok := S.find(pos)
+	if !ok {
+		return GenoTable{}, false
+	}
+	return B.tables[i], true
+}
+
+//---------------------------------------------------------------------------------------------------
+// Set sets genotypes and their values of a position (an empty table if m is nil), the position is
+// added to variant locations if it is not one of them.
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) Set(pos uint32, m map[string]float64) {
+	B, i, ok := S.find(pos)
+	if ok {
+		B.tables[i] = NewGenoTable(m)
+		return
+	}
+	if B == nil {
+		B = new(GenoBlock)
+		S.blocks[pos>>GENO_BLOCK_BITS] = B
+	}
+	B.offs = append(B.offs, 0)
+	copy(B.offs[i+1:], B.offs[i:])
+	B.offs[i] = uint16(pos & (GENO_BLOCK_LEN - 1))
+	B.tables = append(B.tables, GenoTable{})
+	copy(B.tables[i+1:], B.tables[i:])
+	B.tables[i] = NewGenoTable(m)
+	S.num++
+}
+
+//---------------------------------------------------------------------------------------------------
+// Len returns the number of variant locations of the store.
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) Len() int {
+	return S.num
+}
+
+//---------------------------------------------------------------------------------------------------
+// Positions appends variant locations of the store to Pos (in no particular order of blocks).
+//---------------------------------------------------------------------------------------------------
+func (S *GenoStore) Positions(Pos []int) []int {
+	for b, B := range S.blocks {
+		for _, off := range B.offs {
+			Pos = append(Pos, int(b<<GENO_BLOCK_BITS)+int(off))
+		}
+	}
+	return Pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// CountTable represents integer values of keys of a variant location (alleles "ref|alt" or genotypes
+// "hap1|hap2"), sorted by keys.
+//---------------------------------------------------------------------------------------------------
+type CountTable struct {
+	Keys []string // keys in sorted order
+	Vals []int    // values of keys
+}
+
+//---------------------------------------------------------------------------------------------------
+// find returns the index of a key in the table (where it would be inserted if it is not in the table),
+// and whether the key is in the table.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) find(key string) (int, bool) {
+	i := sort.SearchStrings(T.Keys, key)
+	return i, i < len(T.Keys) && T.Keys[i] == key
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns the value of a key (0 if the key is not in the table).
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) Get(key string) int {
+	if i, ok := T.find(key); ok {
+		return T.Vals[i]
+	}
+	return 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// Set sets the value of a key, the key is added if it is not in the table.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) Set(key string, v int) {
+	i, ok := T.find(key)
+	if !ok {
+		T.Keys = append(T.Keys, "")
+		copy(T.Keys[i+1:], T.Keys[i:])
+		T.Keys[i] = key
+		T.Vals = append(T.Vals, 0)
+		copy(T.Vals[i+1:], T.Vals[i:])
+	}
+	T.Vals[i] = v
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds n to the value of a key.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) Add(key string, n int) {
+	T.Set(key, T.Get(key)+n)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddMap adds values of keys given as a map.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) AddMap(m map[string]int) {
+	for key, n := range m {
+		T.Add(key, n)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Len returns the number of keys of the table.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) Len() int {
+	return len(T.Keys)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Map returns a map of keys and their values of the table.
+//---------------------------------------------------------------------------------------------------
+func (T *CountTable) Map() map[string]int {
+	m := make(map[string]int, len(T.Keys))
+	for i, key := range T.Keys {
+		m[key] = T.Vals[i]
+	}
+	return m
+}
+
+//---------------------------------------------------------------------------------------------------
+// CallSite represents statistics of aligned reads at a variant location.
+//---------------------------------------------------------------------------------------------------
+type CallSite struct {
+	Type   CountTable      // types of genotypes (0: sub, 1: ins, 2: del)
+	RNum   CountTable      // numbers of aligned reads of alleles (empty: no aligned reads)
+	RevNum CountTable      // numbers of aligned reads on the reverse strand of alleles
+	Stat   SiteStat        // statistics of aligned reads (used for INFO annotations)
+	Like   caller.SiteLike // sums of likelihoods of aligned bases of key alleles of all samples
+}
+
+//---------------------------------------------------------------------------------------------------
+// Aligned checks if a variant location has aligned reads.
+//---------------------------------------------------------------------------------------------------
+func (C *CallSite) Aligned() bool {
+	return C != nil && C.RNum.Len() > 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// SiteBlock represents variant locations of a block of positions and their statistics.
+//---------------------------------------------------------------------------------------------------
+type SiteBlock struct {
+	offs  []uint16    // sorted offsets of variant locations in the block
+	sites []*CallSite // statistics of variant locations
+}
+
+//---------------------------------------------------------------------------------------------------
+// SiteStore represents statistics of aligned reads at variant locations stored by blocks of positions.
+// Statistics are kept by pointers, which stay valid when locations are added to their blocks.
+//---------------------------------------------------------------------------------------------------
+type SiteStore struct {
+	blocks map[uint32]*SiteBlock // blocks of positions (by positions divided by GENO_BLOCK_LEN)
+	num    int                   // number of variant locations
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewSiteStore creates an empty store of statistics of variant locations.
+//---------------------------------------------------------------------------------------------------
+func NewSiteStore() *SiteStore {
+	return &SiteStore{blocks: make(map[uint32]*SiteBlock)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns statistics of a variant location, or nil if the position is not a variant location.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStore) Get(pos uint32) *CallSite {
+	B := S.blocks[pos>>GENO_BLOCK_BITS]
+	if B == nil {
+		return nil
+	}
+	off := uint16(pos & (GENO_BLOCK_LEN - 1))
+	i := sort.Search(len(B.offs), func(k int) bool { return B.offs[k] >= off })
+	if i < len(B.offs) && B.offs[i] == off {
+		return B.sites[i]
+	}
+	return nil
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add returns statistics of a position, the position is added to variant locations if it is not one
+// of them.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStore) Add(pos uint32) *CallSite {
+	B := S.blocks[pos>>GENO_BLOCK_BITS]
+	if B == nil {
+		B = new(SiteBlock)
+		S.blocks[pos>>GENO_BLOCK_BITS] = B
+	}
+	off := uint16(pos & (GENO_BLOCK_LEN - 1))
+	i := sort.Search(len(B.offs), func(k int) bool { return B.offs[k] >= off })
+	if i < len(B.offs) && B.offs[i] == off {
+		return B.sites[i]
+	}
+	B.offs = append(B.offs, 0)
+	copy(B.offs[i+1:], B.offs[i:])
+	B.offs[i] = off
+	B.sites = append(B.sites, nil)
+	copy(B.sites[i+1:], B.sites[i:])
+	B.sites[i] = new(CallSite)
+	S.num++
+	return B.sites[i]
+}
+
+//---------------------------------------------------------------------------------------------------
+// Len returns the number of variant locations of the store.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStore) Len() int {
+	return S.num
+}
+
+//---------------------------------------------------------------------------------------------------
+// EachAligned calls f with each variant location with aligned reads and its statistics, in increasing
+// order of positions.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStore) EachAligned(f func(pos uint32, site *CallSite)) {
+	bs := make([]uint32, 0, len(S.blocks))
+	for b, _ := range S.blocks {
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i] < bs[j] })
+	for _, b := range bs {
+		B := S.blocks[b]
+		for i, off := range B.offs {
+			if B.sites[i].Aligned() {
+				f(b<<GENO_BLOCK_BITS+uint32(off), B.sites[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: hetero.go
+// Heteroplasmy mode. Variants of mitochondrial contigs (and other contigs given by users) are called
+// with a continuous allele-fraction model instead of diploid genotypes: the fraction of reads of the
+// most supported alternative allele is reported with a 95% (Wilson score) confidence interval, and
+// QUAL is the Phred-scaled likelihood ratio of the fraction against no alternative allele (reads of
+// the alternative allele from sequencing errors only).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	HETERO_MIN_READS = 2    // minimum number of reads of the alternative allele of calls
+	HETERO_MIN_AF    = 0.01 // minimum allele fraction of calls
+	HETERO_HOMO_AF   = 0.95 // minimum allele fraction of homoplasmic calls (GT 1)
+	HETERO_Z         = 1.96 // z-score of 95% confidence intervals of allele fractions
+)
+
+// Sorted intervals [start, end) of contigs called with the continuous allele-fraction model
+var HETERO_REGIONS [][2]int
+
+//---------------------------------------------------------------------------------------------------
+// SetupHeteroplasmy sets up regions of comma-separated contigs called with the continuous
+// allele-fraction model. Contigs which are not in the multigenome are ignored.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SetupHeteroplasmy(contigs string) {
+	HETERO_REGIONS = nil
+	if contigs == "" {
+		return
+	}
+	chr_idx := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		chr_idx[string(chr_name)] = i
+	}
+	for _, contig := range strings.Split(contigs, ",") {
+		i, ok := chr_idx[contig]
+		if !ok {
+			log.Printf("Warning: contig %s of heteroplasmy mode is not in the multigenome, it is ignored.", contig)
+			continue
+		}
+		end := VC.SeqLen
+		if i+1 < len(VC.ChrPos) {
+			end = VC.ChrPos[i+1]
+		}
+		HETERO_REGIONS = append(HETERO_REGIONS, [2]int{VC.ChrPos[i], end})
+	}
+	sort.Slice(HETERO_REGIONS, func(i, j int) bool { return HETERO_REGIONS[i][0] < HETERO_REGIONS[j][0] })
+	log.Printf("Number of contigs called with the continuous allele-fraction model:\t%d", len(HETERO_REGIONS))
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsHeteroplasmic checks if a position of the multigenome is called with the continuous
+// allele-fraction model.
+//---------------------------------------------------------------------------------------------------
+func IsHeteroplasmic(pos int) bool {
+	i := sort.Search(len(HETERO_REGIONS), func(i int) bool { return HETERO_REGIONS[i][1] > pos })
+	return i < len(HETERO_REGIONS) && HETERO_REGIONS[i][0] <= pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// WilsonInterval returns the Wilson score interval of the fraction of k in n trials.
+//---------------------------------------------------------------------------------------------------
+func WilsonInterval(k, n int) (float64, float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	f, z2 := float64(k)/float64(n), HETERO_Z*HETERO_Z
+	center := (f + z2/(2*float64(n))) / (1 + z2/float64(n))
+	width := HETERO_Z * math.Sqrt(f*(1-f)/float64(n)+z2/(4*float64(n)*float64(n))) / (1 + z2/float64(n))
+	return math.Max(center-width, 0), math.Min(center+width, 1)
+}
+
+//---------------------------------------------------------------------------------------------------
+// HeteroQual returns the Phred-scaled likelihood ratio of k reads of an allele in n reads given the
+// allele fraction k/n, against allele fraction 0 (reads of the allele are errors with rate e).
+//---------------------------------------------------------------------------------------------------
+func HeteroQual(k, n int, e float64) float64 {
+	log_like := func(f float64) float64 {
+		p := f*(1-e) + (1-f)*e
+		return float64(k)*math.Log10(p) + float64(n-k)*math.Log10(1-p)
+	}
+	return math.Min(10*(log_like(float64(k)/float64(n))-log_like(0)), 1000)
+}
+
+//---------------------------------------------------------------------------------------------------
+// HeteroAlleles returns the key of the most supported alternative allele ("ref|alt" keys of aligned
+// reads, ties are broken by keys) and the number of its reads.
+//---------------------------------------------------------------------------------------------------
+func HeteroAlleles(var_num map[string]int) (string, int) {
+	alt_key, alt_num := "", 0
+	for key, n := range var_num {
+		if arr := strings.Split(key, "|"); arr[0] != arr[1] && (n > alt_num || (n == alt_num && key < alt_key)) {
+			alt_key, alt_num = key, n
+		}
+	}
+	return alt_key, alt_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// HeteroFormat returns FORMAT values (GT:AD:DP:AF:AFCI) of the alternative allele alt_key given
+// numbers of aligned reads of alleles.
+//---------------------------------------------------------------------------------------------------
+func HeteroFormat(var_num map[string]int, alt_key string) string {
+	ref_num, alt_num, depth := 0, var_num[alt_key], 0
+	for key, n := range var_num {
+		depth += n
+		if arr := strings.Split(key, "|"); arr[0] == arr[1] {
+			ref_num += n
+		}
+	}
+	str_ad := strconv.Itoa(ref_num) + "," + strconv.Itoa(alt_num)
+	if depth == 0 {
+		return "./.:" + str_ad + ":0:.:."
+	}
+	af := float64(alt_num) / float64(depth)
+	lo, hi := WilsonInterval(alt_num, depth)
+	str_gt := "0/1"
+	if af >= HETERO_HOMO_AF {
+		str_gt = "1"
+	}
+	return str_gt + ":" + str_ad + ":" + strconv.Itoa(depth) + ":" + strconv.FormatFloat(af, 'f', 4, 64) + ":" +
+		strconv.FormatFloat(lo, 'f', 4, 64) + "," + strconv.FormatFloat(hi, 'f', 4, 64)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteHeteroCall writes the call of the continuous allele-fraction model at a position, it returns
+// false (nothing is written) if the alternative allele has too few reads or a too small fraction.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteHeteroCall(w *bufio.Writer, rid int, pos uint32) bool {
+	var_num := VC.Calls[rid].Sites.Get(pos).RNum.Map()
+	alt_key, alt_num := HeteroAlleles(var_num)
+	depth := 0
+	for _, n := range var_num {
+		depth += n
+	}
+	if alt_num < HETERO_MIN_READS || float64(alt_num) < HETERO_MIN_AF*float64(depth) {
+		return false
+	}
+	alleles := strings.Split(alt_key, "|")
+	chr_name, chr_pos := VC.ChrLoc(int(pos))
+	qual := HeteroQual(alt_num, depth, math.Max(float64(PARA.Err_rate)/3.0, MIN_ERR_RATE))
+	if qual < PARA.Min_qual {
+		atomic.AddInt64(&SUMMARY.LowQualNum, 1)
+		return false
+	}
+	str_qual := strconv.FormatFloat(qual, 'f', 5, 64)
+	str_info := "HP;DP=" + strconv.Itoa(depth)
+	if VC.Downsampled(rid, pos) {
+		str_info += ";DS"
+	}
+	str_format := HeteroFormat(var_num, alt_key)
+	if MultiSample() {
+		sample_formats := make([]string, len(SAMPLES))
+		for s := 0; s < len(SAMPLES); s++ {
+			sample_formats[s] = HeteroFormat(VC.Calls[rid].SampleRNum[pos][s], alt_key)
+		}
+		str_format = strings.Join(sample_formats, "\t")
+	}
+	str_filter := "."
+	if len(FILTERS) > 0 {
+		str_filter = ApplyFilters(FILTERS, FilterValues(qual, str_info, "GT:AD:DP:AF:AFCI", HeteroFormat(var_num, alt_key)))
+	}
+	str_filter = MASK.Filter(int(pos), str_filter)
+	w.WriteString(strings.Join([]string{chr_name, strconv.Itoa(chr_pos), ".", alleles[0], alleles[1], str_qual, str_filter,
+		str_info, "GT:AD:DP:AF:AFCI", str_format}, "\t") + "\n")
+	atomic.AddInt64(&SUMMARY.EmittedNum, 1)
+	if SUPPORT != nil {
+		SUPPORT.Add(pos, VC.Calls[rid].VarReads[pos], []string{chr_name, strconv.Itoa(chr_pos), alleles[0], alleles[1]}, func(var_base string) int {
+			if var_base == alt_key {
+				return 1
+			}
+			return AlleleIndex(var_base, "")
+		})
+	}
+	return true
+}
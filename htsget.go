@@ -0,0 +1,330 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: htsget.go
+// Remote reads from htsget servers (GA4GH htsget protocol). An htsget URL of reads (htsget://host/
+// reads/id?referenceName=chr1&start=...&end=..., or htsget+http:// for plain HTTP servers) is resolved
+// to a ticket, whose data blocks (BAM format) are fetched and concatenated, so that only reads of the
+// requested region are transferred. Primary alignments are paired by read names and written as a pair
+// of FASTQ files into the cache directory, which are then used as input read files.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	HTSGET_SCHEME      = "htsget://"      // scheme of htsget URLs (HTTPS servers)
+	HTSGET_HTTP_SCHEME = "htsget+http://" // scheme of htsget URLs (HTTP servers)
+	BAM_SEQ_CODES      = "=ACMGRSVTWYHKDBN"
+)
+
+//---------------------------------------------------------------------------------------------------
+// HtsgetTicket represents the response of an htsget server to a reads request.
+//---------------------------------------------------------------------------------------------------
+type HtsgetTicket struct {
+	Htsget struct {
+		Format string `json:"format"`
+		URLs   []struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		} `json:"urls"`
+	} `json:"htsget"`
+}
+
+//---------------------------------------------------------------------------------------------------
+// BamRecord represents sequence and quality of a read-end from a BAM record (in the read strand).
+//---------------------------------------------------------------------------------------------------
+type BamRecord struct {
+	Name string
+	Seq  []byte
+	Qual []byte
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsHtsget determines whether a file name is an htsget URL.
+//---------------------------------------------------------------------------------------------------
+func IsHtsget(file_name string) bool {
+	return strings.HasPrefix(file_name, HTSGET_SCHEME) || strings.HasPrefix(file_name, HTSGET_HTTP_SCHEME)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ResolveHtsgetReads replaces htsget URLs in lists of first-end and second-end read files by local
+// FASTQ files of reads fetched from htsget servers. The second-end file of an htsget URL is the same
+// URL or omitted (if the second-end list is empty).
+//---------------------------------------------------------------------------------------------------
+func ResolveHtsgetReads(read_file_1, read_file_2, cache_dir string) (string, string) {
+	files_1, files_2 := ReadFiles(read_file_1), ReadFiles(read_file_2)
+	has_htsget := false
+	for _, fn := range files_1 {
+		has_htsget = has_htsget || IsHtsget(fn)
+	}
+	if !has_htsget {
+		return read_file_1, read_file_2
+	}
+	if len(files_2) == 0 {
+		files_2 = make([]string, len(files_1))
+	}
+	if len(files_1) != len(files_2) {
+		log.Panicf("Error: numbers of first-end and second-end read files are different")
+	}
+	for i, fn := range files_1 {
+		if !IsHtsget(fn) {
+			continue
+		}
+		if files_2[i] != "" && files_2[i] != fn {
+			log.Panicf("Error: second-end read file of htsget reads %s must be the same URL or omitted", fn)
+		}
+		files_1[i], files_2[i] = FetchHtsgetReads(fn, cache_dir)
+	}
+	return strings.Join(files_1, ","), strings.Join(files_2, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// FetchHtsgetReads fetches reads of an htsget URL and writes paired read-ends to two FASTQ files in
+// the cache directory (if they are not cached yet), and returns names of the files.
+//---------------------------------------------------------------------------------------------------
+func FetchHtsgetReads(htsget_url, cache_dir string) (string, string) {
+	h := fnv.New64a()
+	h.Write([]byte(htsget_url))
+	prefix := filepath.Join(cache_dir, "htsget", fmt.Sprintf("%016x", h.Sum64()))
+	fq_file_1, fq_file_2 := prefix+"_1.fq", prefix+"_2.fq"
+	if _, e := os.Stat(fq_file_2); e == nil {
+		log.Printf("Using cached files %s, %s for %s", fq_file_1, fq_file_2, htsget_url)
+		return fq_file_1, fq_file_2
+	}
+	if e := os.MkdirAll(filepath.Dir(prefix), 0777); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Fetching reads from htsget server %s...", htsget_url)
+	ticket := GetHtsgetTicket(htsget_url)
+	if ticket.Htsget.Format != "" && ticket.Htsget.Format != "BAM" {
+		log.Panicf("Error: unsupported format of htsget reads %s (only BAM is supported)", ticket.Htsget.Format)
+	}
+	readers := make([]io.Reader, 0, len(ticket.Htsget.URLs))
+	for _, u := range ticket.Htsget.URLs {
+		data, e := GetHtsgetBlock(u.URL, u.Headers)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		readers = append(readers, bytes.NewReader(data))
+	}
+	// BAM files are BGZF files (concatenated gzip members), blocks of a ticket form a BAM file
+	bam, e := gzip.NewReader(io.MultiReader(readers...))
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	// Write to temporary files first, so that incomplete files are not used as cached files
+	f1, f2 := CreateTmpFile(filepath.Base(fq_file_1)+".part"), CreateTmpFile(filepath.Base(fq_file_2)+".part")
+	w1, w2 := bufio.NewWriter(f1), bufio.NewWriter(f2)
+	pair_num, unpaired_num := WriteBamPairs(bufio.NewReader(bam), w1, w2)
+	w1.Flush()
+	w2.Flush()
+	f1.Close()
+	f2.Close()
+	if e = MoveFile(f1.Name(), fq_file_1); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if e = MoveFile(f2.Name(), fq_file_2); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Number of read pairs from htsget server:\t%d, unpaired read-ends (ignored):\t%d", pair_num, unpaired_num)
+	return fq_file_1, fq_file_2
+}
+
+//---------------------------------------------------------------------------------------------------
+// GetHtsgetTicket requests the ticket of an htsget URL (reads are requested in BAM format).
+//---------------------------------------------------------------------------------------------------
+func GetHtsgetTicket(htsget_url string) *HtsgetTicket {
+	url := "https://" + strings.TrimPrefix(htsget_url, HTSGET_SCHEME)
+	if strings.HasPrefix(htsget_url, HTSGET_HTTP_SCHEME) {
+		url = "http://" + strings.TrimPrefix(htsget_url, HTSGET_HTTP_SCHEME)
+	}
+	if !strings.Contains(url, "format=") {
+		if strings.Contains(url, "?") {
+			url += "&format=BAM"
+		} else {
+			url += "?format=BAM"
+		}
+	}
+	data, e := GetHtsgetBlock(url, nil)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	ticket := new(HtsgetTicket)
+	if e = json.Unmarshal(data, ticket); e != nil {
+		log.Panicf("Error: invalid htsget ticket from %s: %s", url, e)
+	}
+	return ticket
+}
+
+//---------------------------------------------------------------------------------------------------
+// GetHtsgetBlock gets data of an URL (or a data: URI) of an htsget ticket with the given headers.
+//---------------------------------------------------------------------------------------------------
+func GetHtsgetBlock(url string, headers map[string]string) ([]byte, error) {
+	if strings.HasPrefix(url, "data:") {
+		i := strings.Index(url, ",")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid data URI in htsget ticket")
+		}
+		if strings.HasSuffix(url[:i], ";base64") {
+			return base64.StdEncoding.DecodeString(url[i+1:])
+		}
+		return []byte(url[i+1:]), nil
+	}
+	req, e := http.NewRequest("GET", url, nil)
+	if e != nil {
+		return nil, e
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("cannot get %s (%s)", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteBamPairs reads records of an uncompressed BAM stream, pairs primary alignments of paired reads
+// by names and writes them in FASTQ format (first ends to w1, second ends to w2). It returns numbers
+// of written pairs and of read-ends whose mates are not in the stream.
+//---------------------------------------------------------------------------------------------------
+func WriteBamPairs(r io.Reader, w1, w2 io.Writer) (int, int) {
+	var magic [4]byte
+	if _, e := io.ReadFull(r, magic[:]); e != nil || string(magic[:]) != "BAM\x01" {
+		log.Panicf("Error: invalid BAM data from htsget server")
+	}
+	var l_text, n_ref, l_name, l_ref int32
+	binary.Read(r, binary.LittleEndian, &l_text)
+	io.CopyN(ioutil.Discard, r, int64(l_text))
+	binary.Read(r, binary.LittleEndian, &n_ref)
+	for i := int32(0); i < n_ref; i++ {
+		binary.Read(r, binary.LittleEndian, &l_name)
+		io.CopyN(ioutil.Discard, r, int64(l_name))
+		binary.Read(r, binary.LittleEndian, &l_ref)
+	}
+	pending := [2]map[string]*BamRecord{make(map[string]*BamRecord), make(map[string]*BamRecord)}
+	pair_num := 0
+	var block_size int32
+	for {
+		if e := binary.Read(r, binary.LittleEndian, &block_size); e == io.EOF {
+			break
+		} else if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		data := make([]byte, block_size)
+		if _, e := io.ReadFull(r, data); e != nil {
+			log.Panicf("Error: truncated BAM record from htsget server: %s", e)
+		}
+		rec, end := ParseBamRecord(data)
+		if rec == nil {
+			continue
+		}
+		if mate, ok := pending[1-end][rec.Name]; ok {
+			delete(pending[1-end], rec.Name)
+			if end == 1 {
+				rec, mate = mate, rec
+			}
+			WriteFastq(w1, rec)
+			WriteFastq(w2, mate)
+			pair_num++
+		} else {
+			pending[end][rec.Name] = rec
+		}
+	}
+	return pair_num, len(pending[0]) + len(pending[1])
+}
+
+//---------------------------------------------------------------------------------------------------
+// ParseBamRecord parses a BAM record (without block size) and returns the read-end in the read strand
+// and its end (0: first, 1: second). It returns nil for unpaired reads and non-primary alignments.
+//---------------------------------------------------------------------------------------------------
+func ParseBamRecord(data []byte) (*BamRecord, int) {
+	if len(data) < 32 {
+		log.Panicf("Error: invalid BAM record from htsget server")
+	}
+	l_read_name := int(data[8])
+	n_cigar_op := int(binary.LittleEndian.Uint16(data[12:14]))
+	flag := binary.LittleEndian.Uint16(data[14:16])
+	l_seq := int(binary.LittleEndian.Uint32(data[16:20]))
+	if flag&0x1 == 0 || flag&0x900 != 0 || (flag&0x40 == 0) == (flag&0x80 == 0) {
+		return nil, 0
+	}
+	p := 32
+	if p+l_read_name+4*n_cigar_op+(l_seq+1)/2+l_seq > len(data) {
+		log.Panicf("Error: invalid BAM record from htsget server")
+	}
+	rec := &BamRecord{Name: string(bytes.TrimRight(data[p:p+l_read_name], "\x00"))}
+	p += l_read_name + 4*n_cigar_op
+	rec.Seq, rec.Qual = make([]byte, l_seq), make([]byte, l_seq)
+	for i := 0; i < l_seq; i++ {
+		rec.Seq[i] = BAM_SEQ_CODES[(data[p+i/2]>>(4*uint(1-i%2)))&0xf]
+	}
+	p += (l_seq + 1) / 2
+	for i := 0; i < l_seq; i++ {
+		if data[p] == 0xff { // qualities are not available
+			rec.Qual[i] = 'I'
+		} else {
+			rec.Qual[i] = data[p+i] + 33
+		}
+	}
+	// Reads aligned to the reverse strand are stored reverse complemented
+	if flag&0x10 != 0 {
+		for i, j := 0, l_seq-1; i < j; i, j = i+1, j-1 {
+			rec.Seq[i], rec.Seq[j] = rec.Seq[j], rec.Seq[i]
+			rec.Qual[i], rec.Qual[j] = rec.Qual[j], rec.Qual[i]
+		}
+		for i, b := range rec.Seq {
+			rec.Seq[i] = BamComplement(b)
+		}
+	}
+	if flag&0x80 != 0 {
+		return rec, 1
+	}
+	return rec, 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// BamComplement returns the complement of a base.
+//---------------------------------------------------------------------------------------------------
+func BamComplement(b byte) byte {
+	switch b {
+	case 'A':
+		return 'T'
+	case 'C':
+		return 'G'
+	case 'G':
+		return 'C'
+	case 'T':
+		return 'A'
+	}
+	return 'N'
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteFastq writes a read-end in FASTQ format.
+//---------------------------------------------------------------------------------------------------
+func WriteFastq(w io.Writer, rec *BamRecord) {
+	fmt.Fprintf(w, "@%s\n%s\n+\n%s\n", rec.Name, rec.Seq, rec.Qual)
+}
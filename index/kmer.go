@@ -0,0 +1,137 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: index/kmer.go
+// K-mer hash-table index of a sequence. Positions of all k-mers of the sequence (without non-standard
+// bases) are kept in a table sorted by k-mers, so that positions of a k-mer are found by a binary
+// search. The index does not depend on parameters of the variant caller, it can be used by other tools
+// for seeding.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package index
+
+import (
+	"encoding/gob"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	KMER_K     = 15 // default length of k-mers of the index
+	KMER_MAX_K = 16 // maximum length of k-mers of the index (k-mers and positions are packed in 64 bits)
+)
+
+// 2-bit codes of bases (-1: non-standard bases, including '*')
+var BASE_CODE = func() [256]int8 {
+	var code [256]int8
+	for i := range code {
+		code[i] = -1
+	}
+	code['A'], code['C'], code['G'], code['T'] = 0, 1, 2, 3
+	return code
+}()
+
+//---------------------------------------------------------------------------------------------------
+// KmerIndex represents a k-mer index: positions of the k-mer Keys[i] are Pos[Offs[i]:Offs[i+1]].
+//---------------------------------------------------------------------------------------------------
+type KmerIndex struct {
+	K    int      // length of k-mers
+	Keys []uint64 // sorted 2-bit codes of k-mers
+	Offs []uint32 // offsets of positions of k-mers
+	Pos  []uint32 // sorted positions of k-mers on the sequence
+}
+
+//---------------------------------------------------------------------------------------------------
+// KmerIndexFile returns the name of the k-mer index file of a sequence file.
+//---------------------------------------------------------------------------------------------------
+func KmerIndexFile(seq_file string) string {
+	return seq_file + ".kmer"
+}
+
+//---------------------------------------------------------------------------------------------------
+// BuildKmerIndex builds the k-mer index of a sequence, k-mers with non-standard bases (including '*'
+// of known variants) are not indexed.
+//---------------------------------------------------------------------------------------------------
+func BuildKmerIndex(seq []byte, k int) *KmerIndex {
+	if k < 1 || k > KMER_MAX_K {
+		log.Panicf("Error: length of k-mers of the index must be from 1 to %d", KMER_MAX_K)
+	}
+	start_time := time.Now()
+	mask := uint64(1)<<uint(2*k) - 1
+	entries := make([]uint64, 0, len(seq))
+	var code uint64
+	l := 0 // length of the current run of standard bases
+	for i, b := range seq {
+		c := BASE_CODE[b]
+		if c < 0 {
+			l = 0
+			continue
+		}
+		code = (code<<2 | uint64(c)) & mask
+		if l++; l >= k {
+			entries = append(entries, code<<32|uint64(i-k+1))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+	KI := &KmerIndex{K: k, Pos: make([]uint32, len(entries))}
+	for i, e := range entries {
+		if i == 0 || e>>32 != entries[i-1]>>32 {
+			KI.Keys = append(KI.Keys, e>>32)
+			KI.Offs = append(KI.Offs, uint32(i))
+		}
+		KI.Pos[i] = uint32(e)
+	}
+	KI.Offs = append(KI.Offs, uint32(len(entries)))
+	log.Printf("Time for building k-mer index (k = %d, %d k-mers):\t%s", k, len(KI.Keys), time.Since(start_time))
+	return KI
+}
+
+//---------------------------------------------------------------------------------------------------
+// Save saves the k-mer index to a file.
+//---------------------------------------------------------------------------------------------------
+func (KI *KmerIndex) Save(file_name string) {
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	if e = gob.NewEncoder(f).Encode(KI); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadKmerIndex reads a k-mer index saved by Save from an input.
+//---------------------------------------------------------------------------------------------------
+func ReadKmerIndex(r io.Reader) (*KmerIndex, error) {
+	KI := new(KmerIndex)
+	if e := gob.NewDecoder(r).Decode(KI); e != nil {
+		return nil, e
+	}
+	return KI, nil
+}
+
+//---------------------------------------------------------------------------------------------------
+// Lookup returns positions of the k-mer starting at position i of a read (nil if the k-mer is not in
+// the index or has non-standard bases).
+//---------------------------------------------------------------------------------------------------
+func (KI *KmerIndex) Lookup(read []byte, i int) []uint32 {
+	if i+KI.K > len(read) {
+		return nil
+	}
+	var code uint64
+	for _, b := range read[i : i+KI.K] {
+		c := BASE_CODE[b]
+		if c < 0 {
+			return nil
+		}
+		code = code<<2 | uint64(c)
+	}
+	j := sort.Search(len(KI.Keys), func(j int) bool { return KI.Keys[j] >= code })
+	if j == len(KI.Keys) || KI.Keys[j] != code {
+		return nil
+	}
+	return KI.Pos[KI.Offs[j]:KI.Offs[j+1]]
+}
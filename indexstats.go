@@ -0,0 +1,101 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: indexstats.go
+// Statistics of the index: the length and contigs of the multigenome, known variants of the variant
+// profile by type and length, the size and sampling rate of the index of seeds, and the memory which
+// loading the index takes, with problems of compatibility found in headers of the index (see
+// LoadIndexInfo). They are used to sanity-check a freshly built index.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const STATS_MAX_VAR_LEN = 50 // known variants of at least this length are counted together
+
+//---------------------------------------------------------------------------------------------------
+// MemBytes returns the estimated memory of the loaded index: the index of seeds, the multigenome and
+// known variants.
+//---------------------------------------------------------------------------------------------------
+func (I *IndexInfo) MemBytes() int64 {
+	return I.IndexBytes + int64(I.SeqLen) + int64(I.VarNum)*DRY_RUN_VAR_BYTES
+}
+
+//---------------------------------------------------------------------------------------------------
+// FMIName returns the name of the kind of FM-index of the index.
+//---------------------------------------------------------------------------------------------------
+func (I *IndexInfo) FMIName() string {
+	if I.Bidir {
+		return "bidirectional FM-index"
+	}
+	return "FM-index"
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChrLen returns the length of the i-th chromosome on the multigenome.
+//---------------------------------------------------------------------------------------------------
+func (I *IndexInfo) ChrLen(i int) int {
+	if i+1 < len(I.ChrPos) {
+		return I.ChrPos[i+1] - I.ChrPos[i]
+	}
+	return I.SeqLen - I.ChrPos[i]
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteIndexStats writes statistics of the index in tab-delimited format, followed by numbers of known
+// variants by type and length, and problems of compatibility of the index.
+//---------------------------------------------------------------------------------------------------
+func WriteIndexStats(w io.Writer, I *IndexInfo, index_file string) {
+	fmt.Fprintf(w, "Genome length:\t%d\n", I.SeqLen)
+	fmt.Fprintf(w, "Contigs:\t%d\n", len(I.ChrPos))
+	if len(I.ChrPos) > 0 {
+		longest, shortest := 0, 0
+		for i := range I.ChrPos {
+			if I.ChrLen(i) > I.ChrLen(longest) {
+				longest = i
+			}
+			if I.ChrLen(i) < I.ChrLen(shortest) {
+				shortest = i
+			}
+		}
+		fmt.Fprintf(w, "Longest contig:\t%s (%d bases)\n", I.ChrName[longest], I.ChrLen(longest))
+		fmt.Fprintf(w, "Shortest contig:\t%s (%d bases)\n", I.ChrName[shortest], I.ChrLen(shortest))
+	}
+	fmt.Fprintf(w, "Known variants:\t%d\n", I.VarNum)
+	if I.SA_rate == 0 {
+		fmt.Fprintf(w, "Index of seeds:\tk-mer index %s\n", index_file)
+	} else {
+		fmt.Fprintf(w, "Index of seeds:\t%s %s\n", I.FMIName(), index_file)
+		fmt.Fprintf(w, "Known variant positions marked on the multigenome:\t%d\n", I.MarkNum)
+		fmt.Fprintf(w, "SA sampling rate:\t%d\n", I.SA_rate)
+	}
+	fmt.Fprintf(w, "Index size:\t%s\n", FormatBytes(I.IndexBytes))
+	fmt.Fprintf(w, "Memory footprint:\t%s (index %s, multigenome %s, known variants %s)\n", FormatBytes(I.MemBytes()),
+		FormatBytes(I.IndexBytes), FormatBytes(int64(I.SeqLen)), FormatBytes(int64(I.VarNum)*DRY_RUN_VAR_BYTES))
+	fmt.Fprintln(w, "TYPE\tLENGTH\tVARIANTS")
+	for _, var_type := range VAR_TYPES {
+		var_lens := make([]int, 0)
+		long_num := 0
+		for var_len, num := range I.VarLenNum[var_type] {
+			if var_len >= STATS_MAX_VAR_LEN {
+				long_num += num
+			} else {
+				var_lens = append(var_lens, var_len)
+			}
+		}
+		sort.Ints(var_lens)
+		for _, var_len := range var_lens {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", var_type, var_len, I.VarLenNum[var_type][var_len])
+		}
+		if long_num > 0 {
+			fmt.Fprintf(w, "%s\t>=%d\t%d\n", var_type, STATS_MAX_VAR_LEN, long_num)
+		}
+	}
+	for _, problem := range I.Problems {
+		fmt.Fprintf(w, "Problem:\t%s\n", problem)
+	}
+}
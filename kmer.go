@@ -0,0 +1,161 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: kmer.go
+// K-mer prescreen of reads. A Bloom filter of (canonical) k-mers of the multigenome is built when the
+// variant caller is initialized; before seeding, a few k-mers of each read-end are checked against it,
+// and read pairs without any k-mer of the multigenome (e.g. contaminants or adapter-only reads) are
+// skipped and counted instead of running all random iterations of seeding.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/namsyvo/IVC/index"
+)
+
+const (
+	PRESCREEN_K        = 20 // length of k-mers of the prescreen
+	PRESCREEN_KMER_NUM = 8  // number of k-mers checked on each read-end
+	PRESCREEN_MIN_HIT  = 2  // minimum number of checked k-mers of a read pair found in the filter
+	BLOOM_BITS_PER_POS = 12 // number of bits of the Bloom filter per position of the multigenome
+	BLOOM_HASH_NUM     = 4  // number of hash functions of the Bloom filter
+)
+
+//---------------------------------------------------------------------------------------------------
+// KmerFilter represents a Bloom filter of k-mers.
+//---------------------------------------------------------------------------------------------------
+type KmerFilter struct {
+	bits []uint64 // bit array
+	size uint64   // number of bits
+	k    int      // length of k-mers
+}
+
+// K-mer filter of the multigenome (nil: the prescreen is off)
+var KMER_FILTER *KmerFilter
+
+//---------------------------------------------------------------------------------------------------
+// NewKmerFilter builds a Bloom filter of canonical k-mers of a sequence (k-mers with non-standard
+// bases are not included). Chunks of the sequence are processed in parallel.
+//---------------------------------------------------------------------------------------------------
+func NewKmerFilter(seq []byte, k, proc_num int) *KmerFilter {
+	start_time := time.Now()
+	F := &KmerFilter{k: k}
+	F.size = uint64(len(seq))*BLOOM_BITS_PER_POS + 64
+	F.bits = make([]uint64, (F.size+63)/64)
+	chunk_len := (len(seq) + proc_num - 1) / proc_num
+	var wg sync.WaitGroup
+	for start := 0; start < len(seq); start += chunk_len {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			// chunks overlap by k-1 bases so that k-mers across boundaries are included
+			F.AddKmers(seq[start:MinInt(end+k-1, len(seq))])
+		}(start, MinInt(start+chunk_len, len(seq)))
+	}
+	wg.Wait()
+	log.Printf("Time for building k-mer filter of the multigenome (k = %d):\t%s", k, time.Since(start_time))
+	return F
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddKmers adds all k-mers of a sequence to the filter.
+//---------------------------------------------------------------------------------------------------
+func (F *KmerFilter) AddKmers(seq []byte) {
+	mask := uint64(1)<<uint(2*F.k) - 1
+	var fwd, rev uint64
+	l := 0 // length of the current run of standard bases
+	for _, b := range seq {
+		c := index.BASE_CODE[b]
+		if c < 0 {
+			l = 0
+			continue
+		}
+		fwd = (fwd<<2 | uint64(c)) & mask
+		rev = rev>>2 | uint64(3-c)<<uint(2*(F.k-1))
+		if l++; l >= F.k {
+			for _, h := range F.hashes(Canonical(fwd, rev)) {
+				atomic.OrUint64(&F.bits[h/64], 1<<(h%64))
+			}
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Contains checks if a read contains the k-mer starting at position i (false if it has non-standard
+// bases).
+//---------------------------------------------------------------------------------------------------
+func (F *KmerFilter) Contains(read []byte, i int) bool {
+	var fwd, rev uint64
+	for _, b := range read[i : i+F.k] {
+		c := index.BASE_CODE[b]
+		if c < 0 {
+			return false
+		}
+		fwd = fwd<<2 | uint64(c)
+		rev = rev>>2 | uint64(3-c)<<uint(2*(F.k-1))
+	}
+	for _, h := range F.hashes(Canonical(fwd, rev)) {
+		if F.bits[h/64]&(1<<(h%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// KmerHits returns the number of kmer_num k-mers evenly spaced on a read which are in the filter
+// (reads shorter than k are not checked, -1 is returned).
+//---------------------------------------------------------------------------------------------------
+func (F *KmerFilter) KmerHits(read []byte, kmer_num int) int {
+	if len(read) < F.k {
+		return -1
+	}
+	hit_num := 0
+	step := MaxInt((len(read)-F.k)/MaxInt(kmer_num-1, 1), 1)
+	for i := 0; i+F.k <= len(read); i += step {
+		if F.Contains(read, i) {
+			hit_num++
+		}
+	}
+	return hit_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// hashes returns positions of bits of a k-mer (double hashing of a 64-bit mix of the k-mer).
+//---------------------------------------------------------------------------------------------------
+func (F *KmerFilter) hashes(kmer uint64) [BLOOM_HASH_NUM]uint64 {
+	h := kmer + 0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h ^= h >> 31
+	h1, h2 := h&0xffffffff, h>>32|1
+	var pos [BLOOM_HASH_NUM]uint64
+	for i := uint64(0); i < BLOOM_HASH_NUM; i++ {
+		pos[i] = (h1 + i*h2) % F.size
+	}
+	return pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// Canonical returns the smaller of the codes of a k-mer and its reverse complement.
+//---------------------------------------------------------------------------------------------------
+func Canonical(fwd, rev uint64) uint64 {
+	if rev < fwd {
+		return rev
+	}
+	return fwd
+}
+
+//---------------------------------------------------------------------------------------------------
+// PassPrescreen checks if a read pair has enough k-mers of the multigenome on its ends (requiring
+// more than one k-mer reduces false positives of the Bloom filter).
+//---------------------------------------------------------------------------------------------------
+func PassPrescreen(read_info *ReadInfo) bool {
+	hit_num1, hit_num2 := KMER_FILTER.KmerHits(read_info.Read1, PRESCREEN_KMER_NUM), KMER_FILTER.KmerHits(read_info.Read2, PRESCREEN_KMER_NUM)
+	return hit_num1 < 0 || hit_num2 < 0 || hit_num1+hit_num2 >= PRESCREEN_MIN_HIT
+}
@@ -0,0 +1,88 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: kmerindex.go
+// Seeding with the k-mer index of the multigenome (see index/kmer.go), an alternative to the FM-index.
+// A seed is looked up by the k-mer at its start and extended forwardly by comparing the read with the
+// multigenome at the positions of the k-mer. It uses more memory than a sampled FM-index, but lookups
+// are faster, and the FM-index is not needed at all (built by ivc-index -kmer).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+
+	"github.com/namsyvo/IVC/index"
+)
+
+const (
+	SEED_INDEX_FM       = "fm"    // seeds are searched with the FM-index of the reverse multigenome
+	SEED_INDEX_KMER     = "kmer"  // seeds are searched with the k-mer index of the multigenome
+	KMER_INDEX_MAX_HITS = 1 << 16 // maximum number of positions of a k-mer which are extended for seeds
+)
+
+//---------------------------------------------------------------------------------------------------
+// CheckSeedIndex checks the index of seeds.
+//---------------------------------------------------------------------------------------------------
+func CheckSeedIndex(seed_index string) {
+	switch seed_index {
+	case SEED_INDEX_FM, SEED_INDEX_KMER:
+	default:
+		log.Panicf("Error: unknown index of seeds %s (supported indexes: fm, kmer)", seed_index)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadKmerIndex loads a k-mer index from a (local or remote) file.
+//---------------------------------------------------------------------------------------------------
+func LoadKmerIndex(file_name string) *index.KmerIndex {
+	f, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	KI, e := index.ReadKmerIndex(f)
+	if e != nil {
+		log.Panicf("Error: %s: %s", file_name, e)
+	}
+	return KI
+}
+
+//---------------------------------------------------------------------------------------------------
+// SearchKmerSeeds returns positions and distances of seeds between a read and the reference using the
+// k-mer index, as SearchSeeds does with the FM-index: the seed starting at s_pos is extended as long
+// as it matches the multigenome at some position (up to Max_slen bases), and the positions of the
+// longest matches are its positions. Seeds are at least k bases long.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SearchKmerSeeds(read []byte, s_pos int, m_pos []int) (int, int, int, bool) {
+	hits := VC.KmerIdx.Lookup(read, s_pos)
+	if len(hits) == 0 {
+		return -1, -1, -1, false
+	}
+	if len(hits) > KMER_INDEX_MAX_HITS {
+		return s_pos, s_pos + VC.KmerIdx.K - 1, len(hits), false
+	}
+	// Lengths of matches beyond k-mers, seeds end at the longest ones
+	k, max_ext := VC.KmerIdx.K, MinInt(len(read)-s_pos, PARA.Max_slen+1)
+	best_ext, m_num := 0, 0
+	for _, p := range hits {
+		ext := k
+		for ext < max_ext && int(p)+ext < VC.SeqLen && VC.Seq[int(p)+ext] == read[s_pos+ext] {
+			ext++
+		}
+		if ext > best_ext {
+			best_ext, m_num = ext, 0
+		}
+		if ext == best_ext {
+			if m_num < PARA.Max_snum {
+				m_pos[m_num] = int(p)
+			}
+			m_num++
+		}
+	}
+	e_pos := s_pos + best_ext - 1
+	if m_num > PARA.Max_snum || e_pos-s_pos < PARA.Min_slen {
+		return s_pos, e_pos, m_num, false
+	}
+	return s_pos, e_pos, m_num, true
+}
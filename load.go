@@ -0,0 +1,120 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: load.go
+// Concurrent loading of the FM-index (or the k-mer index), the reference multigenome and the variant profile. Loading
+// is started as soon as input files are checked, so that it is overlapped with setting up other
+// parameters (e.g. peeking read files), and the variant caller waits for it to finish.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/namsyvo/IVC/fmi"
+	"github.com/namsyvo/IVC/index"
+)
+
+//---------------------------------------------------------------------------------------------------
+// IndexLoader represents data which are loaded concurrently in goroutines.
+//---------------------------------------------------------------------------------------------------
+type IndexLoader struct {
+	RevFMI     *fmi.Index        // FM-index of reverse multi-sequence
+	BiFMI      *fmi.BiIndex      // bidirectional FM-index (nil: the FM-index has no tables of the multi-sequence)
+	KmerIdx    *index.KmerIndex  // k-mer index of multi-sequence (k-mer index of seeds only)
+	ChrPos     []int             // positions of chromosomes on the multi-sequence
+	ChrName    [][]byte          // chromosome names
+	Seq        []byte            // multi-sequence
+	Variants   map[int][][]byte  // variants of the variant profile
+	VarAF      map[int][]float32 // allele frequency of variants
+	SameLenVar map[int]int       // lengths of variants with same length
+	DelVar     map[int]int       // lengths of deletions
+	wg         sync.WaitGroup    // wait group of loading goroutines
+}
+
+// Loader started when input files are checked (nil: loading has not been started)
+var LOADER *IndexLoader
+
+//---------------------------------------------------------------------------------------------------
+// StartLoading starts loading the index of seeds (the FM-index or the k-mer index), the reference and
+// the variant profile in goroutines. Auxiliary data structures of the variant profile are also
+// created in its goroutine.
+//---------------------------------------------------------------------------------------------------
+func StartLoading(ref_file, var_prof_file, index_file, seed_index string) *IndexLoader {
+	L := new(IndexLoader)
+	L.wg.Add(3)
+	go func() {
+		defer L.wg.Done()
+		start_time := time.Now()
+		if seed_index == SEED_INDEX_KMER {
+			log.Printf("Loading k-mer index of the reference...")
+			L.KmerIdx = LoadKmerIndex(index.KmerIndexFile(ref_file))
+			log.Printf("Finish loading k-mer index of the reference.")
+			TIMING.Record("index load", time.Since(start_time), int64(len(L.KmerIdx.Pos)), "k-mers")
+			return
+		}
+		log.Printf("Loading FM-index of the reference...")
+		L.RevFMI = fmi.Load(index_file)
+		if fmi.HasFwd(index_file) {
+			L.BiFMI = &fmi.BiIndex{Rev: L.RevFMI, Fwd: fmi.LoadFwd(index_file)}
+		}
+		log.Printf("Finish loading FM-index of the reference.")
+		TIMING.Record("index load", time.Since(start_time), int64(L.RevFMI.LEN), "bases")
+	}()
+	go func() {
+		defer L.wg.Done()
+		start_time := time.Now()
+		log.Printf("Loading the reference...")
+		L.ChrPos, L.ChrName, L.Seq = LoadMultiSeq(ref_file)
+		log.Printf("Finish loading the reference.")
+		TIMING.Record("reference load", time.Since(start_time), int64(len(L.Seq)), "bases")
+	}()
+	go func() {
+		defer L.wg.Done()
+		start_time := time.Now()
+		log.Printf("Loading the variant profile...")
+		L.Variants, L.VarAF = LoadVarProf(var_prof_file)
+		log.Printf("Finish loading the variant profile.")
+		TIMING.Record("variant profile load", time.Since(start_time), int64(len(L.Variants)), "variants")
+		L.SameLenVar, L.DelVar = VarLenInfo(L.Variants)
+	}()
+	return L
+}
+
+//---------------------------------------------------------------------------------------------------
+// Wait waits for all loading goroutines to finish.
+//---------------------------------------------------------------------------------------------------
+func (L *IndexLoader) Wait() {
+	L.wg.Wait()
+}
+
+//---------------------------------------------------------------------------------------------------
+// VarLenInfo returns lengths of variants whose alleles have the same length (SNPs or MNPs) and
+// lengths of deletions of variants whose alternative alleles are all shorter than the reference.
+//---------------------------------------------------------------------------------------------------
+func VarLenInfo(variants map[int][][]byte) (map[int]int, map[int]int) {
+	same_len_var, del_var := make(map[int]int), make(map[int]int)
+	var same_len_flag, del_flag bool
+	var var_len int
+	for var_pos, var_bases := range variants {
+		var_len = len(var_bases[0])
+		same_len_flag, del_flag = true, true
+		for _, val := range var_bases[1:] {
+			if var_len != len(val) {
+				same_len_flag = false
+			}
+			if var_len <= len(val) {
+				del_flag = false
+			}
+		}
+		if same_len_flag {
+			same_len_var[var_pos] = var_len
+		}
+		if del_flag {
+			del_var[var_pos] = var_len - 1
+		}
+	}
+	return same_len_var, del_var
+}
@@ -0,0 +1,203 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: longread.go
+// Chunks and seed chains of long reads. Long reads are split into pairs of chunks which are aligned as
+// pairs of read-ends. Seeds of the whole long read (and of its reverse complement) are chained into
+// co-linear anchors on the multigenome, and chunk pairs are only aligned near the position expected
+// from the best chain, so that chunks in repeats are placed consistently with the rest of the read.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"sort"
+)
+
+const (
+	LONG_SEED_STEP      = 40   // distance between start positions of seeds of long reads
+	LONG_SEED_MAX_HITS  = 8    // maximum number of hits of seeds of long reads used as anchors
+	LONG_CHAIN_LOOKBACK = 50   // maximum number of preceding anchors considered for chaining an anchor
+	LONG_CHAIN_MAX_GAP  = 5000 // maximum distance on the read between consecutive anchors of a chain
+	LONG_CHAIN_BAND     = 100  // maximum difference of diagonals of consecutive anchors of a chain
+	LONG_CHAIN_MIN_NUM  = 2    // minimum number of anchors of a chain used for alignment of chunks
+)
+
+//---------------------------------------------------------------------------------------------------
+// ChainAnchor represents an exact match between a long read (or its reverse complement) and the
+// multigenome.
+//---------------------------------------------------------------------------------------------------
+type ChainAnchor struct {
+	Q, R, Len int // start position on the read, start position on the multigenome and length
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChunkAnchor represents the expected alignment of the first chunk of a chunk pair, from the chain of
+// seeds of its long read.
+//---------------------------------------------------------------------------------------------------
+type ChunkAnchor struct {
+	Pos    int  // expected position of the first chunk (of its aligned strand) on the multigenome
+	Strand bool // strand of the chain ("true" if the read has same strand with ref)
+}
+
+//---------------------------------------------------------------------------------------------------
+// LongReadChain represents the best chain of seeds of a long read.
+//---------------------------------------------------------------------------------------------------
+type LongReadChain struct {
+	Anchors []ChainAnchor // anchors of the chain, by increasing positions on the read
+	Strand  bool          // strand of the chain ("true": the read, "false": its reverse complement)
+	Len     int           // length of the read
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChunkStarts returns start positions of chunk pairs of a long read of length read_len. Chunk pairs
+// of pair_len bases are taken one after another; the rest of the read, if any, is covered by a last
+// chunk pair at the end of the read which overlaps its predecessor. Reads shorter than a chunk pair
+// have no chunk pairs.
+//---------------------------------------------------------------------------------------------------
+func ChunkStarts(read_len, pair_len int) []int {
+	var starts []int
+	s_pos := 0
+	for ; s_pos+pair_len <= read_len; s_pos += pair_len {
+		starts = append(starts, s_pos)
+	}
+	if len(starts) > 0 && s_pos < read_len {
+		starts = append(starts, read_len-pair_len)
+	}
+	return starts
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChunkCycles returns ranges [start, end) of cycles of the two chunks of a chunk pair starting at
+// s_pos whose bases are used as evidence: bases which are already covered by the chunk pair ending at
+// prev_end (the last chunk pair of a read overlapping its predecessor) are used only once. The second
+// chunk is reverse complemented, so that its cycles count from the end of the chunk pair.
+//---------------------------------------------------------------------------------------------------
+func ChunkCycles(s_pos, prev_end, chunk_len, pair_len int) ([2]int, [2]int) {
+	overlap := MaxInt(prev_end-s_pos, 0)
+	return [2]int{MinInt(overlap, chunk_len), chunk_len}, [2]int{0, MinInt(MaxInt(pair_len-overlap, 0), chunk_len)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// TrimCycles removes variants of an aligned read-end outside a range of cycles (see ChunkCycles).
+//---------------------------------------------------------------------------------------------------
+func TrimCycles(vars []*VarInfo, cycles [2]int) []*VarInfo {
+	trimmed := vars[:0]
+	for _, v := range vars {
+		if v.Cycle >= cycles[0] && v.Cycle < cycles[1] {
+			trimmed = append(trimmed, v)
+		}
+	}
+	return trimmed
+}
+
+//---------------------------------------------------------------------------------------------------
+// SearchChainAnchors searches for seeds of a long read (or its reverse complement) every LONG_SEED_STEP
+// bases, seeds with at most LONG_SEED_MAX_HITS hits are returned as anchors.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SearchChainAnchors(read []byte, m_pos []int) []ChainAnchor {
+	var anchors []ChainAnchor
+	for r_pos := 0; r_pos+PARA.Min_slen <= len(read); r_pos += LONG_SEED_STEP {
+		s_pos, e_pos, m_num, has_seeds := VC.SearchSeeds(read, r_pos, m_pos)
+		if !has_seeds || m_num > LONG_SEED_MAX_HITS {
+			continue
+		}
+		for k := 0; k < m_num; k++ {
+			anchors = append(anchors, ChainAnchor{s_pos, m_pos[k], e_pos - s_pos})
+		}
+	}
+	return anchors
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChainAnchors returns the chain of co-linear anchors with the most read bases covered, and the number
+// of covered bases. Consecutive anchors of a chain increase on both the read and the multigenome, are at
+// most LONG_CHAIN_MAX_GAP bases apart on the read and their diagonals differ by at most LONG_CHAIN_BAND
+// bases (indels of the read).
+//---------------------------------------------------------------------------------------------------
+func ChainAnchors(anchors []ChainAnchor) ([]ChainAnchor, int) {
+	if len(anchors) == 0 {
+		return nil, 0
+	}
+	sort.Slice(anchors, func(i, j int) bool {
+		if anchors[i].Q != anchors[j].Q {
+			return anchors[i].Q < anchors[j].Q
+		}
+		return anchors[i].R < anchors[j].R
+	})
+	score, prev := make([]int, len(anchors)), make([]int, len(anchors))
+	best := 0
+	for i, a := range anchors {
+		score[i], prev[i] = a.Len, -1
+		for j := MaxInt(0, i-LONG_CHAIN_LOOKBACK); j < i; j++ {
+			b := anchors[j]
+			if b.Q >= a.Q || b.R >= a.R || a.Q-b.Q > LONG_CHAIN_MAX_GAP || AbsInt((a.R-a.Q)-(b.R-b.Q)) > LONG_CHAIN_BAND {
+				continue
+			}
+			// Bases of overlapping anchors are counted once
+			if s := score[j] + MinInt(a.Len, a.Q-b.Q); s > score[i] {
+				score[i], prev[i] = s, j
+			}
+		}
+		if score[i] > score[best] {
+			best = i
+		}
+	}
+	var chain []ChainAnchor
+	for i := best; i >= 0; i = prev[i] {
+		chain = append(chain, anchors[i])
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, score[best]
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChainLongRead returns the best chain of seeds of a long read on either strand, or nil if the best
+// chain has less than LONG_CHAIN_MIN_NUM anchors.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ChainLongRead(read, rev_comp_read []byte, m_pos []int) *LongReadChain {
+	chain, score := ChainAnchors(VC.SearchChainAnchors(read, m_pos))
+	rc_chain, rc_score := ChainAnchors(VC.SearchChainAnchors(rev_comp_read, m_pos))
+	C := &LongReadChain{Anchors: chain, Strand: true, Len: len(read)}
+	if rc_score > score {
+		C.Anchors, C.Strand = rc_chain, false
+	}
+	if len(C.Anchors) < LONG_CHAIN_MIN_NUM {
+		return nil
+	}
+	return C
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChunkAnchor returns the expected alignment of the chunk of chunk_len bases starting at s_pos of the
+// read, from the diagonal of the nearest anchor of the chain. Chunks more than LONG_CHAIN_MAX_GAP bases
+// away from the chain (e.g. parts of chimeric reads) have no expected alignment (nil).
+//---------------------------------------------------------------------------------------------------
+func (C *LongReadChain) ChunkAnchor(s_pos, chunk_len int) *ChunkAnchor {
+	if C == nil {
+		return nil
+	}
+	// Start of the chunk on the strand of the chain
+	q := s_pos
+	if !C.Strand {
+		q = C.Len - s_pos - chunk_len
+	}
+	first, last := C.Anchors[0], C.Anchors[len(C.Anchors)-1]
+	if q < first.Q-LONG_CHAIN_MAX_GAP || q > last.Q+last.Len+LONG_CHAIN_MAX_GAP {
+		return nil
+	}
+	i := sort.Search(len(C.Anchors), func(i int) bool { return C.Anchors[i].Q >= q })
+	if i == len(C.Anchors) || (i > 0 && q-C.Anchors[i-1].Q < C.Anchors[i].Q-q) {
+		i--
+	}
+	return &ChunkAnchor{Pos: C.Anchors[i].R - C.Anchors[i].Q + q, Strand: C.Strand}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Near checks if an alignment of the first chunk (at pos, on strand) is consistent with the chain of
+// its long read. It is true for chunks without expected alignments.
+//---------------------------------------------------------------------------------------------------
+func (A *ChunkAnchor) Near(pos int, strand bool) bool {
+	return A == nil || (strand == A.Strand && AbsInt(pos-A.Pos) <= LONG_CHAIN_BAND)
+}
@@ -10,9 +10,9 @@ import (
 	"flag"
 	"github.com/namsyvo/IVC"
 	"github.com/namsyvo/IVC/fmi"
+	"github.com/namsyvo/IVC/index"
 	"log"
 	"os"
-	"path"
 	"runtime"
 	"time"
 )
@@ -20,71 +20,113 @@ import (
 func main() {
 
 	log.Printf("IVC - Integrated Variant Caller using next-generation sequencing data.")
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		Stats(os.Args[2:])
+		return
+	}
 	log.Printf("IVC-index: Indexing reference genomes and variant profiles.")
 
 	var genome_file = flag.String("R", "", "reference genome file")
 	var var_prof_file = flag.String("V", "", "variant profile file")
-	var idx_dir = flag.String("I", "", "index directory")
+	var idx_dir = flag.String("I", "", "index directory (the FM-index is written to <genome>.mgf.index/, directories <genome>.rev.mgf.index/ of earlier versions are obsolete)")
+	var sa_rate = flag.Int("sa-rate", 1, "sampling rate of suffix array (1: full suffix array)")
+	var bidir = flag.Bool("bi", false, "build bidirectional FM-index (with tables of multi-sequence, seeds are also extended backwardly)")
+	var kmer = flag.Bool("kmer", false, "build k-mer index of multi-sequence instead of FM-index (for ivc -seed-index kmer)")
+	var kmer_len = flag.Int("k", index.KMER_K, "length of k-mers of k-mer index (at most 16)")
 	var debug_mode = flag.Bool("debug", false, "turn on debug mode.")
 	flag.Parse()
 
-	if _, err := os.Stat(*idx_dir); err != nil {
-		if os.IsNotExist(err) {
-			if err := os.Mkdir(*idx_dir, 0777); err != nil {
-				log.Panicf("Error: %s", err)
-			}
-		} else {
-			log.Panicf("Error: %s", err)
-		}
-	}
-
 	// Creating multi-sequence and variant profile index
 	log.Printf("----------------------------------------------------------------------------------------")
 	log.Printf("Creating multi-sequence and variant profile index...")
 	ivc.MEM_STATS = new(runtime.MemStats)
 
 	start_time := time.Now()
-	chr_pos, chr_name, multi_seq, var_prof := ivc.BuildMultiGenome(*genome_file, *var_prof_file, *debug_mode)
 	if *debug_mode {
 		log.Printf("Memstats (golang name):\tAlloc\tTotalAlloc\tSys\tHeapAlloc\tHeapSys")
-		ivc.PrintMemStats("Memstats after building multi-sequence")
-	}
-
-	multi_seq_len := len(multi_seq)
-	rev_multi_seq := make([]byte, multi_seq_len)
-	for i := range multi_seq {
-		rev_multi_seq[i] = multi_seq[multi_seq_len-1-i]
 	}
-	_, genome_file_name := path.Split(*genome_file)
-	multi_seq_file_name := path.Join(*idx_dir, genome_file_name) + ".mgf"
-	rev_multi_seq_file_name := path.Join(*idx_dir, genome_file_name) + ".rev.mgf"
-	_, var_prof_file_name := path.Split(*var_prof_file)
-	var_prof_idx_file_name := path.Join(*idx_dir, var_prof_file_name) + ".idx"
-
-	ivc.SaveMultiSeq(multi_seq_file_name, chr_pos, chr_name, multi_seq)
-	ivc.SaveVarProf(var_prof_idx_file_name, chr_pos, chr_name, var_prof)
+	rev_multi_seq := ivc.BuildMultiGenomeFiles(*genome_file, *var_prof_file, *idx_dir, *debug_mode)
+	multi_seq_file_name, fm_index_dir, _ := ivc.IndexFileNames(*genome_file, *var_prof_file, *idx_dir)
 	gen_time := time.Since(start_time)
 
-	log.Printf("Multi-sequence file: %s", multi_seq_file_name)
-	log.Printf("Variant profile index file: %s", var_prof_idx_file_name)
-
 	log.Printf("Time for creating multi-sequence and variant profile index:\t%s", gen_time)
 	if *debug_mode {
 		ivc.PrintMemStats("Memstats after creating multi-sequence and variant profile index")
 	}
 	log.Printf("Finish creating multi-sequence and variant profile index.")
 
+	// Creating k-mer index of multi-sequence
+	if *kmer {
+		log.Printf("----------------------------------------------------------------------------------------")
+		log.Printf("Indexing k-mers of multi-sequence...")
+		start_time = time.Now()
+		multi_seq := make([]byte, len(rev_multi_seq))
+		for i := range rev_multi_seq {
+			multi_seq[i] = rev_multi_seq[len(rev_multi_seq)-1-i]
+		}
+		index.BuildKmerIndex(multi_seq, *kmer_len).Save(index.KmerIndexFile(multi_seq_file_name))
+		log.Printf("Time for indexing k-mers of multi-sequence:\t%s", time.Since(start_time))
+		if *debug_mode {
+			ivc.PrintMemStats("Memstats after indexing k-mers of multi-sequence")
+		}
+		log.Printf("K-mer index file of multi-sequence: %s", index.KmerIndexFile(multi_seq_file_name))
+		log.Printf("Finish indexing k-mers of multi-sequence.")
+		return
+	}
+
 	// Creating FM-index of multi-sequence
 	log.Printf("----------------------------------------------------------------------------------------")
 	log.Printf("Indexing multi-sequence...")
 	start_time = time.Now()
 	fmindex := fmi.New(rev_multi_seq)
-	fmindex.Save(rev_multi_seq_file_name)
+	fmindex.SampleSA(*sa_rate)
+	fmindex.Save(multi_seq_file_name) // saved to fm_index_dir
+	// Tables of the multi-sequence (without suffix array) are added to the FM-index directory
+	if *bidir {
+		multi_seq := make([]byte, len(rev_multi_seq))
+		for i := range rev_multi_seq {
+			multi_seq[i] = rev_multi_seq[len(rev_multi_seq)-1-i]
+		}
+		fmi.NewFwd(multi_seq).SaveFwd(fm_index_dir)
+	}
 	index_time := time.Since(start_time)
 	log.Printf("Time for indexing multi-sequence:\t%s", index_time)
 	if *debug_mode {
 		ivc.PrintMemStats("Memstats after indexing multi-sequence")
 	}
-	log.Printf("Index directory for multi-sequence: %s", rev_multi_seq_file_name+".index/")
+	log.Printf("Index directory for multi-sequence: %s", fm_index_dir)
 	log.Printf("Finish indexing multi-sequence.")
 }
+
+//----------------------------------------------------------------------------------------
+// Stats runs the stats subcommand, which reports statistics of an index built by ivc-index
+// (genome, contigs, known variants by type and length, index of seeds and memory footprint).
+//----------------------------------------------------------------------------------------
+func Stats(args []string) {
+	log.Printf("IVC-index-stats: Reporting statistics of the index.")
+	cmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	var genome_file = cmd.String("R", "", "reference genome file")
+	var var_prof_file = cmd.String("V", "", "variant profile file")
+	var idx_dir = cmd.String("I", "", "index directory")
+	var kmer = cmd.Bool("kmer", false, "report the k-mer index of multi-sequence instead of the FM-index")
+	cmd.Parse(args)
+	if *genome_file == "" || *var_prof_file == "" || *idx_dir == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	multi_seq_file_name, fm_index_dir, var_prof_index_file_name := ivc.IndexFileNames(*genome_file, *var_prof_file, *idx_dir)
+	seed_index, index_file := ivc.SEED_INDEX_FM, ivc.FMIndexDir(fm_index_dir)
+	if *kmer {
+		seed_index, index_file = ivc.SEED_INDEX_KMER, index.KmerIndexFile(multi_seq_file_name)
+	}
+	if _, e := os.Stat(index_file); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	index_info := ivc.LoadIndexInfo(multi_seq_file_name, var_prof_index_file_name, index_file, seed_index)
+	ivc.WriteIndexStats(os.Stdout, index_info, index_file)
+	if len(index_info.Problems) > 0 {
+		log.Printf("Found %d problem(s) with the index.", len(index_info.Problems))
+		os.Exit(1)
+	}
+	log.Printf("Finish reporting statistics of the index.")
+}
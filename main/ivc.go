@@ -10,15 +10,64 @@ import (
 	"flag"
 	"github.com/namsyvo/IVC"
 	"log"
-	"path"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
 )
 
 func main() {
 	log.Printf("IVC - Integrated Variant Caller using next-generation sequencing data.")
+	if len(os.Args) > 1 && os.Args[1] == "build-genome" {
+		BuildGenome(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		Serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		Eval(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "calib" {
+		Calib(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tune" {
+		Tune(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "recal" {
+		Recal(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		Merge(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		State(os.Args[2:])
+		return
+	}
 	log.Printf("IVC-main: Calling variants based on alignment between reads and reference multi-genomes.")
 
 	// Setting up all para_infometers
-	input_para_info := ReadInputInfo()
+	profiling := ProfilingFlags(flag.CommandLine)
+	input_para_info := ReadInputInfo(flag.CommandLine, os.Args[1:])
+	CheckInput(input_para_info, true)
+	if input_para_info.Dry_run {
+		if !ivc.DryRun(input_para_info) {
+			os.Exit(1)
+		}
+		return
+	}
+	defer profiling.Start()()
+	defer ivc.CleanupTmpDir()
 	ivc.Setup(input_para_info)
 
 	// Initializing indexes and para_infometers
@@ -29,47 +78,213 @@ func main() {
 
 	// Outputing variant calls
 	variant_caller.OutputVarCalls()
+	ivc.TIMING.Close()
 
 	log.Printf("Finish whole variant calling process.")
 }
 
-func ReadInputInfo() *ivc.ParaInfo {
-	var genome_file = flag.String("R", "", "reference genome file")
-	var var_prof_file = flag.String("V", "", "variant profile file")
-	var idx_dir = flag.String("I", "", "index directory")
-	var read_file_1 = flag.String("1", "", "pairend read file, first end")
-	var read_file_2 = flag.String("2", "", "pairend read file, second end")
-	var var_call_file = flag.String("O", "", "variant call output file")
-	var search_mode = flag.Int("mode", 0, "searching mode for finding seeds (1: random (default), 2: deterministic)")
-	var start_pos = flag.Int("start", 0, "starting position on reads for finding seeds")
-	var search_step = flag.Int("step", 0, "step for searching in deterministic mode")
-	var max_snum = flag.Int("maxs", 0, "maximum number of seeds")
-	var max_psnum = flag.Int("maxp", 0, "maximum number of paired-seeds")
-	var min_slen = flag.Int("lmin", 0, "minimum length of seeds")
-	var max_slen = flag.Int("lmax", 0, "maximum length of seeds")
-	var dist_thres = flag.Float64("d", 0, "threshold of alignment distances")
-	var iter_num = flag.Int("r", 0, "maximum number of iterations")
-	var sub_cost = flag.Float64("s", 0, "substitution cost")
-	var gap_open = flag.Float64("o", 0, "gap open cost")
-	var gap_ext = flag.Float64("e", 0, "gap extension cost")
-	var proc_num = flag.Int("t", 0, "maximum number of CPUs")
-	var debug_mode = flag.Bool("debug", false, "turn on debug mode.")
-	flag.Parse()
-
-	_, genome_file_name := path.Split(*genome_file)
-	multi_seq_file_name := path.Join(*idx_dir, genome_file_name) + ".mgf"
-	rev_multi_seq_file_name := path.Join(*idx_dir, genome_file_name) + ".rev.mgf"
-	_, var_prof_file_name := path.Split(*var_prof_file)
-	var_prof_index_file_name := path.Join(*idx_dir, var_prof_file_name) + ".idx"
+//----------------------------------------------------------------------------------------
+// StringList is a flag which can be given several times, its values are kept in the given order.
+//----------------------------------------------------------------------------------------
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+//----------------------------------------------------------------------------------------
+// Profiling represents profiling options of long runs, which do not need debug mode or
+// recompiling.
+//----------------------------------------------------------------------------------------
+type Profiling struct {
+	addr     *string // address of the pprof HTTP endpoint (empty: no endpoint)
+	cpu_file *string // file for writing the CPU profile of the run
+	mem_file *string // file for writing the heap profile at the end of the run
+}
+
+func ProfilingFlags(cmd *flag.FlagSet) *Profiling {
+	P := new(Profiling)
+	P.addr = cmd.String("pprof", "", "address of a pprof HTTP endpoint for profiling the run (e.g. :6060)")
+	P.cpu_file = cmd.String("cpuprofile", "", "file for writing the CPU profile of the run")
+	P.mem_file = cmd.String("memprofile", "", "file for writing the heap profile at the end of the run")
+	return P
+}
+
+//----------------------------------------------------------------------------------------
+// Start starts the pprof HTTP endpoint and CPU profiling, it returns the function which
+// stops CPU profiling and writes the heap profile at the end of the run.
+//----------------------------------------------------------------------------------------
+func (P *Profiling) Start() func() {
+	if *P.addr != "" {
+		go func() {
+			log.Printf("Serving pprof at http://%s/debug/pprof/", *P.addr)
+			if e := http.ListenAndServe(*P.addr, nil); e != nil {
+				log.Printf("Warning: pprof endpoint is not served: %s", e)
+			}
+		}()
+	}
+	var cpu_f *os.File
+	if *P.cpu_file != "" {
+		var e error
+		if cpu_f, e = os.Create(*P.cpu_file); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		if e = pprof.StartCPUProfile(cpu_f); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	return func() {
+		if cpu_f != nil {
+			pprof.StopCPUProfile()
+			cpu_f.Close()
+			log.Printf("CPU profile is written to:\t%s", *P.cpu_file)
+		}
+		if *P.mem_file != "" {
+			f, e := os.Create(*P.mem_file)
+			if e != nil {
+				log.Panicf("Error: %s", e)
+			}
+			runtime.GC()
+			if e = pprof.WriteHeapProfile(f); e != nil {
+				log.Panicf("Error: %s", e)
+			}
+			f.Close()
+			log.Printf("Heap profile is written to:\t%s", *P.mem_file)
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+// CheckInput checks the command line and exits with all problems found and hints on fixing them.
+//--------------------------------------------------------------------------------------------------
+func CheckInput(para_info *ivc.ParaInfo, with_reads bool) {
+	errs := ivc.CheckInput(para_info, with_reads)
+	if len(errs) == 0 {
+		return
+	}
+	for _, e := range errs {
+		log.Printf("Error: %s", e)
+	}
+	log.Printf("Found %d problem(s) with the command line, nothing is written. Run %s -h for all options.", len(errs), os.Args[0])
+	os.Exit(2)
+}
+
+func ReadInputInfo(cmd *flag.FlagSet, args []string) *ivc.ParaInfo {
+	var genome_file = cmd.String("R", "", "reference genome file")
+	var var_prof_file = cmd.String("V", "", "variant profile file")
+	var idx_dir = cmd.String("I", "", "index directory")
+	var read_file_1 = cmd.String("1", "", "pairend read file, first end (comma-separated files for several FASTQ pairs)")
+	var read_file_2 = cmd.String("2", "", "pairend read file, second end (comma-separated files for several FASTQ pairs)")
+	var var_call_file = cmd.String("O", "", "variant call output file")
+	var preset = cmd.String("preset", "", "preset for long reads (ont or pacbio), reads are taken from the first read file")
+	var search_mode = cmd.Int("mode", 0, "searching mode for finding seeds (1: random (default), 2: deterministic)")
+	var start_pos = cmd.Int("start", 0, "starting position on reads for finding seeds")
+	var search_step = cmd.Int("step", 0, "step for searching in deterministic mode")
+	var max_snum = cmd.Int("maxs", 0, "maximum number of seeds")
+	var max_psnum = cmd.Int("maxp", 0, "maximum number of paired-seeds")
+	var min_slen = cmd.Int("lmin", 0, "minimum length of seeds")
+	var max_slen = cmd.Int("lmax", 0, "maximum length of seeds")
+	var dist_thres = cmd.Float64("d", 0, "threshold of alignment distances")
+	var iter_num = cmd.Int("r", 0, "maximum number of iterations")
+	var sub_cost = cmd.Float64("s", 0, "substitution cost")
+	var gap_open = cmd.Float64("o", 0, "gap open cost")
+	var gap_ext = cmd.Float64("e", 0, "gap extension cost")
+	var new_snp_rate = cmd.Float64("snp-rate", 0, "prior probability of novel SNPs")
+	var ts_tv = cmd.Float64("ts-tv", 0, "Ts/Tv ratio of novel SNPs for priors and substitution costs (e.g. 2.1 for human genomes, 0: all substitutions are equally likely)")
+	var new_indel_rate = cmd.Float64("indel-rate", 0, "prior probability of novel indels")
+	var indel_err_rate = cmd.Float64("indel-err-rate", 0, "probability of indel sequencing errors")
+	var proc_num = cmd.Int("t", 0, "maximum number of CPUs")
+	var auto_tune = cmd.Bool("auto-tune", false, "tune the number of alignment goroutines and read buffering by profiling the first seconds of calling")
+	var max_depth = cmd.Int("max-depth", 0, "maximum number of aligned reads used at each position, reads of deeper positions are sampled by seeded priorities (0: the hard cap of 10000)")
+	var all_sites = cmd.Bool("all-sites", false, "output homozygous-reference calls at all covered positions (emit-all-sites mode)")
+	var context_model = cmd.Bool("context-model", false, "use context error model (homopolymer and dinucleotide contexts) with the default table")
+	var context_file = cmd.String("context-table", "", "context error table file (turns on context error model)")
+	var learn_context_file = cmd.String("learn-context", "", "file for writing context error table learned from aligned reads")
+	var realign = cmd.Bool("realign", false, "realign reads around candidate indels before updating variant probabilities")
+	var assemble = cmd.Bool("assemble", false, "assemble haplotypes of dense variant regions before updating variant probabilities")
+	var sv_file = cmd.String("sv-file", "", "file for writing structural variant breakpoint candidates (split reads and discordant pairs)")
+	var cnv_file = cmd.String("cnv-file", "", "file for writing copy number variant candidate regions (depth segmentation)")
+	var pileup_file = cmd.String("pileup", "", "file for writing pileup (mpileup-like) of observed bases and qualities of aligned reads")
+	var bedgraph_file = cmd.String("bedgraph", "", "file for writing depth of aligned reads in BedGraph format")
+	var summary_file = cmd.String("summary", "", "file for writing the run summary in JSON format")
+	var timing_file = cmd.String("timing-log", "", "file for writing timing records of stages in JSON lines format")
+	var dump_aln = cmd.String("dump-alignments", "", "dump alignments of selected read pairs: file of read names (one per line) or region chr:start-end")
+	var dump_file = cmd.String("dump-file", "", "file for writing the alignment dump (default: variant call file with suffix .aln.txt)")
+	var support_file = cmd.String("support-reads", "", "file for writing names of reads supporting alleles of emitted variant calls")
+	var calib_report = cmd.String("calib-report", "", "file for writing the calibration report of QUAL of emitted variant calls")
+	var calib_truth = cmd.String("calib-truth", "", "truth variant file of the calibration report (VCF format, default: allele balance of calls is used)")
+	var calib_bed = cmd.String("calib-bed", "", "confident regions of the truth set of the calibration report (BED format)")
+	var unaligned_file = cmd.String("unaligned", "", "file for writing unaligned read pairs in FASTQ format (both ends interleaved)")
+	var read_groups StringList
+	cmd.Var(&read_groups, "rg", "read group of a FASTQ pair \"ID:id,SM:sample,LB:library,PL:platform\" (once per FASTQ pair, in the same order)")
+	var primer_file = cmd.String("primers", "", "BED file of amplicon primers (soft-clip primers, call variants in amplicon inserts only)")
+	var mask_file = cmd.String("mask", "", "BED file of masked regions, e.g. centromeres, low-complexity regions or known artifacts")
+	var mask_mode = cmd.String("mask-mode", "drop", "handling of masked regions: drop (alignment evidence is dropped) or filter (calls are marked with the filter MASKED)")
+	var qual_bins = cmd.Int("qual-bins", 0, "number of bins of base qualities, e.g. 8 (0: no binning)")
+	var no_gaps = cmd.Bool("no-gaps", false, "no-gaps mode for quick scans: align reads with mismatches only, skip reads requiring gapped alignment")
+	var read_cache = cmd.Int("read-cache", 0, "maximum number of reads in the identical-read cache (0: no cache)")
+	var multi_map = cmd.String("multi-map", "first", "multi-mapping policy for reads with tied best alignments (first, discard, random, fractional)")
+	var seed = cmd.Int64("seed", 0, "seed of random choices of reads for reproducible runs (0: taken from the current time)")
+	var trio = cmd.String("trio", "", "samples of a trio (father,mother,child) for joint calling with Mendelian priors")
+	var af_file = cmd.String("af-file", "", "population allele-frequency resource (VCF with AF, or table chrom/pos/ref/alt/af) for priors at known variant locations")
+	var af_weight = cmd.Float64("af-weight", 0.5, "weight of population allele frequencies against allele frequencies of the variant profile in priors (0..1)")
+	var prof_trust = cmd.Float64("prof-trust", 1, "trust in allele frequencies of the variant profile: exponent tempering priors at known variant locations (1: as given, 0: flat priors)")
+	var baq = cmd.Bool("baq", false, "cap qualities of mismatches by base alignment qualities (BAQ) to reduce false SNPs around indels")
+	var compress_output = cmd.Bool("compress-output", false, "gzip-compress variant calls and auxiliary reports (.gz is added to file names)")
+	var per_contig = cmd.Bool("per-contig", false, "write variant calls of each contig to its own file (named by the variant call file and the contig)")
+	var merge_contigs = cmd.Bool("merge-contigs", false, "concatenate per-contig files into the variant call file at the end (with -per-contig)")
+	var warm_up = cmd.Int("warm-up", 0, "number of read pairs aligned in a warm-up phase to estimate the sequencing error rate (0: use the default rate)")
+	var two_pass = cmd.Float64("two-pass", 0, "call variants in two passes, novel calls of the first pass with QUAL >= this value are added to known variants (0: one pass)")
+	var save_state = cmd.String("save-state", "", "file for saving the variant call state (sufficient statistics of aligned reads, binary format)")
+	var load_state = cmd.String("load-state", "", "variant call state saved by a previous run, used as the starting point of calling")
+	var shard = cmd.String("shard", "", "shard k/n of the multigenome: only read pairs aligned to the k-th of n equal parts are accepted (use with -save-state and merge)")
+	var aln_backend = cmd.String("aln-backend", "cpu", "backend of the alignment DP (cpu, avx2 in builds with the build tag avx2, or another accelerated backend registered in the build)")
+	var prescreen = cmd.Bool("prescreen", false, "skip read pairs without k-mers of the multigenome (checked with a Bloom filter) before seeding")
+	var contam = cmd.Bool("contam", false, "estimate the cross-sample contamination fraction from allele balances at homozygous known SNPs")
+	var contam_adjust = cmd.Bool("contam-adjust", false, "adjust genotype likelihoods at known SNPs for the estimated contamination (turns on -contam)")
+	var heteroplasmy = cmd.String("heteroplasmy", "", "comma-separated contigs (e.g. chrM) called with allele fractions and confidence intervals instead of diploid genotypes")
+	var sex = cmd.String("sex", "", "sex of the sample (female, male, or auto: inferred from depth on chrX/chrY); chrX/chrY outside PARs are haploid in males")
+	var qual_seeds = cmd.Bool("qual-seeds", false, "weight random start positions of seeds by base qualities, so that seeds rarely start in low-quality tails")
+	var mismatch_seeds = cmd.Bool("mismatch-seeds", false, "rescue seeds which are too short (e.g. because of an early sequencing error) by seeds with one mismatch")
+	var alt_delta = cmd.Float64("alt-delta", 0, "report alternative alignments within this paired-distance delta of the best one in the evidence file (0: not reported)")
+	var merge_pairs = cmd.Bool("merge-pairs", false, "merge overlapping read pairs (short inserts) into consensus fragments before alignment")
+	var mate_check = cmd.String("mate-check", "error", "check that paired records of the two read files have the same names, modulo /1 and /2 (error, warn, off)")
+	var pair_orient = cmd.String("pair-orient", "fr", "expected orientation of the two ends of read pairs (fr: paired-end, rf or ff: mate-pair libraries)")
+	var mate_pair = cmd.Bool("mate-pair", false, "mate-pair library with multi-kb inserts (paired-end read pairs in the opposite orientation are also aligned, insert sizes are scored)")
+	var cycle_err = cmd.Bool("cycle-err", false, "estimate error rates of sequencing cycles in the warm-up phase and use them in alignment and variant probabilities")
+	var linked_reads = cmd.Bool("linked-reads", false, "linked reads with barcodes in BX:Z: tags of read comments (barcodes resolve ambiguous reads and phase heterozygous calls)")
+	var skip_bad_reads = cmd.Bool("skip-bad-reads", false, "skip malformed FASTQ records, resuming at the next header, and end truncated read files at their last complete record")
+	var seed_index = cmd.String("seed-index", "fm", "index of seeds (fm: FM-index, kmer: k-mer index built by ivc-index -kmer)")
+	var spliced = cmd.Bool("spliced", false, "spliced mode for RNA-seq reads (align read-ends across exon junctions with reference skips)")
+	var dry_run = cmd.Bool("dry-run", false, "check inputs and index compatibility, estimate peak memory and print the plan of the run without processing reads")
+	var debug_mode = cmd.Bool("debug", false, "turn on debug mode.")
+	var debug_file = cmd.String("debug-file", "", "file for writing evidence of variant calls (aligned bases and read info)")
+	var min_qual = cmd.Float64("min-qual", 0, "minimum QUAL of written variant calls, calls below it are omitted (0: all calls)")
+	var filter_expr = cmd.String("filter", "", "comma-separated hard-filter expressions [NAME:]KEY OP VALUE (e.g. \"LowQual:QUAL<20,DP<5\")")
+	var filter_file = cmd.String("filter-file", "", "file of hard-filter expressions, one per line")
+	var tmp_dir = cmd.String("tmp-dir", os.TempDir(), "directory for intermediate files of the run (removed at the end of the run)")
+	var cache_dir = cmd.String("cache-dir", filepath.Join(os.TempDir(), "ivc-cache"), "directory for caching remote index files")
+	cmd.Parse(args)
+
+	multi_seq_file_name, fm_index_dir, var_prof_index_file_name := ivc.IndexFileNames(*genome_file, *var_prof_file, *idx_dir)
 
 	para_info := new(ivc.ParaInfo)
+	para_info.Genome_file = *genome_file
+	para_info.Var_file = *var_prof_file
+	para_info.Index_dir = *idx_dir
 	para_info.Ref_file = multi_seq_file_name
 	para_info.Var_prof_file = var_prof_index_file_name
 	para_info.Index_file = multi_seq_file_name + ".index/"
-	para_info.Rev_index_file = rev_multi_seq_file_name + ".index/"
+	para_info.Rev_index_file = ivc.FMIndexDir(fm_index_dir)
 	para_info.Read_file_1 = *read_file_1
 	para_info.Read_file_2 = *read_file_2
 	para_info.Var_call_file = *var_call_file
+	para_info.Preset = *preset
 	para_info.Search_mode = *search_mode
 	para_info.Start_pos = *start_pos
 	para_info.Search_step = *search_step
@@ -82,8 +297,300 @@ func ReadInputInfo() *ivc.ParaInfo {
 	para_info.Sub_cost = *sub_cost
 	para_info.Gap_open = *gap_open
 	para_info.Gap_ext = *gap_ext
+	para_info.New_snp_rate = *new_snp_rate
+	para_info.Ts_tv = *ts_tv
+	para_info.New_indel_rate = *new_indel_rate
+	para_info.Indel_err_rate = *indel_err_rate
 	para_info.Proc_num = *proc_num
+	para_info.Auto_tune = *auto_tune
+	para_info.Max_depth = *max_depth
+	para_info.All_sites = *all_sites
+	para_info.Context_model = *context_model
+	para_info.Context_file = *context_file
+	para_info.Learn_context_file = *learn_context_file
+	para_info.Realign = *realign
+	para_info.Assemble = *assemble
+	para_info.SV_file = *sv_file
+	para_info.CNV_file = *cnv_file
+	para_info.Pileup_file = *pileup_file
+	para_info.Bedgraph_file = *bedgraph_file
+	para_info.Summary_file = *summary_file
+	para_info.Timing_file = *timing_file
+	para_info.Dump_aln = *dump_aln
+	para_info.Dump_file = *dump_file
+	para_info.Support_file = *support_file
+	para_info.Calib_report = *calib_report
+	para_info.Calib_truth = *calib_truth
+	para_info.Calib_bed = *calib_bed
+	para_info.Unaligned_file = *unaligned_file
+	para_info.Read_groups = read_groups
+	para_info.Primer_file = *primer_file
+	para_info.Mask_file = *mask_file
+	para_info.Mask_mode = *mask_mode
+	para_info.Qual_bins = *qual_bins
+	para_info.No_gaps = *no_gaps
+	para_info.Read_cache = *read_cache
+	para_info.Multi_map = *multi_map
+	para_info.Seed = *seed
+	para_info.Trio = *trio
+	para_info.AF_file = *af_file
+	para_info.AF_weight = *af_weight
+	para_info.Prof_trust = *prof_trust
+	para_info.BAQ = *baq
+	para_info.Gzip_output = *compress_output
+	para_info.Per_contig = *per_contig
+	para_info.Merge_contigs = *merge_contigs
+	para_info.Warm_up = *warm_up
+	para_info.Two_pass = *two_pass
+	para_info.Save_state = *save_state
+	para_info.Load_state = *load_state
+	para_info.Shard = *shard
+	para_info.Aln_backend = *aln_backend
+	para_info.Prescreen = *prescreen
+	para_info.Contam = *contam || *contam_adjust
+	para_info.Contam_adjust = *contam_adjust
+	para_info.Heteroplasmy = *heteroplasmy
+	para_info.Sex = *sex
+	para_info.Qual_seeds = *qual_seeds
+	para_info.Mismatch_seeds = *mismatch_seeds
+	para_info.Seed_index = *seed_index
+	para_info.Alt_delta = *alt_delta
+	para_info.Merge_pairs = *merge_pairs
+	para_info.Mate_check = *mate_check
+	para_info.Pair_orient = *pair_orient
+	para_info.Mate_pair = *mate_pair
+	para_info.Cycle_err = *cycle_err
+	para_info.Linked_reads = *linked_reads
+	para_info.Skip_bad_reads = *skip_bad_reads
+	para_info.Spliced = *spliced
+	para_info.Dry_run = *dry_run
 	para_info.Debug_mode = *debug_mode
+	para_info.Debug_file = *debug_file
+	para_info.Cache_dir = *cache_dir
+	para_info.Tmp_dir = *tmp_dir
+	para_info.Min_qual = *min_qual
+	para_info.Filter_expr = *filter_expr
+	para_info.Filter_file = *filter_file
 
 	return para_info
 }
+
+//--------------------------------------------------------------------------------------------------
+// BuildGenome runs the build-genome subcommand, which builds the multi-sequence, its reverse, and
+// the variant profile index from a reference genome and a variant profile (without FM-index).
+//--------------------------------------------------------------------------------------------------
+func BuildGenome(args []string) {
+	log.Printf("IVC-build-genome: Building multi-sequence and variant profile index.")
+	cmd := flag.NewFlagSet("build-genome", flag.ExitOnError)
+	var genome_file = cmd.String("R", "", "reference genome file")
+	var var_prof_file = cmd.String("V", "", "variant profile file")
+	var idx_dir = cmd.String("I", "", "index directory")
+	cmd.Parse(args)
+	if *genome_file == "" || *var_prof_file == "" || *idx_dir == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	start_time := time.Now()
+	ivc.BuildMultiGenomeFiles(*genome_file, *var_prof_file, *idx_dir, false)
+	log.Printf("Time for building multi-sequence and variant profile index:\t%s", time.Since(start_time))
+	log.Printf("Finish building multi-sequence and variant profile index.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// Serve runs the serve subcommand, which loads the index once and calls variants for batches
+// of reads sent over HTTP (see ivc.Serve for the API).
+//--------------------------------------------------------------------------------------------------
+func Serve(args []string) {
+	log.Printf("IVC-serve: Calling variants for batches of reads sent over HTTP.")
+	cmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	var addr = cmd.String("addr", ":8080", "address for listening to requests")
+	var read_len = cmd.Int("read-len", 250, "maximum length of reads in requests")
+	profiling := ProfilingFlags(cmd)
+	para_info := ReadInputInfo(cmd, args)
+	CheckInput(para_info, false)
+	defer profiling.Start()()
+	para_info.Read_file_1, para_info.Read_file_2 = "", ""
+	para_info.Read_len, para_info.Info_len = *read_len, 256
+	ivc.Setup(para_info)
+	variant_caller := ivc.NewVariantCaller()
+	variant_caller.Serve(*addr)
+}
+
+//--------------------------------------------------------------------------------------------------
+// Eval runs the eval subcommand, which compares variant calls with a truth VCF within confident
+// regions and reports precision, recall and F1 for SNPs and indels.
+//--------------------------------------------------------------------------------------------------
+func Eval(args []string) {
+	log.Printf("IVC-eval: Evaluating variant calls against a truth set.")
+	cmd := flag.NewFlagSet("eval", flag.ExitOnError)
+	var call_file = cmd.String("O", "", "variant call file (VCF format)")
+	var truth_file = cmd.String("truth", "", "truth variant file (VCF format, can be gzip-compressed)")
+	var bed_file = cmd.String("bed", "", "confident regions (BED format, optional)")
+	var report_file = cmd.String("report", "", "file for writing the report (default: standard output)")
+	cmd.Parse(args)
+	if *call_file == "" || *truth_file == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	stats := ivc.Evaluate(*call_file, *truth_file, *bed_file)
+	w := os.Stdout
+	if *report_file != "" {
+		f, e := os.Create(*report_file)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		defer f.Close()
+		w = f
+	}
+	ivc.WriteEvalReport(w, stats)
+	log.Printf("Finish evaluating variant calls.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// Calib runs the calib subcommand, which compares accuracies predicted by QUAL of variant calls with
+// empirical accuracies in bins of QUAL.
+//--------------------------------------------------------------------------------------------------
+func Calib(args []string) {
+	log.Printf("IVC-calib: Checking calibration of QUAL of variant calls.")
+	cmd := flag.NewFlagSet("calib", flag.ExitOnError)
+	var call_file = cmd.String("O", "", "variant call file (VCF format)")
+	var truth_file = cmd.String("truth", "", "truth variant file (VCF format, can be gzip-compressed, default: allele balance of calls is used)")
+	var bed_file = cmd.String("bed", "", "confident regions of the truth set (BED format, optional)")
+	var report_file = cmd.String("report", "", "file for writing the report (default: standard output)")
+	cmd.Parse(args)
+	if *call_file == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bins := ivc.Calibrate(*call_file, *truth_file, *bed_file)
+	w := os.Stdout
+	if *report_file != "" {
+		f, e := os.Create(*report_file)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		defer f.Close()
+		w = f
+	}
+	ivc.WriteCalibReport(w, bins, *truth_file != "")
+	log.Printf("Finish checking calibration of variant calls.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// Tune runs the tune subcommand, which sweeps hard-filter thresholds of annotations of variant calls
+// against a truth set, reports the precision/recall frontier and recommends a hard-filter preset.
+//--------------------------------------------------------------------------------------------------
+func Tune(args []string) {
+	log.Printf("IVC-tune: Sweeping hard-filter thresholds against a truth set.")
+	cmd := flag.NewFlagSet("tune", flag.ExitOnError)
+	var call_file = cmd.String("O", "", "variant call file (VCF format)")
+	var truth_file = cmd.String("truth", "", "truth variant file (VCF format, can be gzip-compressed)")
+	var bed_file = cmd.String("bed", "", "confident regions (BED format, optional)")
+	var min_prec = cmd.Float64("min-precision", 0, "minimum precision of the recommended preset (0: the preset of maximum F1)")
+	var report_file = cmd.String("report", "", "file for writing the report (default: standard output)")
+	var preset_file = cmd.String("preset", "", "file for writing filters of the recommended preset (for -filter-file, optional)")
+	cmd.Parse(args)
+	if *call_file == "" || *truth_file == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if *min_prec < 0 || *min_prec > 1 {
+		log.Panicf("Error: invalid minimum precision %g (must be in [0, 1])", *min_prec)
+	}
+	frontier := ivc.SweepFilters(*call_file, *truth_file, *bed_file)
+	best := ivc.RecommendFilters(frontier, *min_prec)
+	w := os.Stdout
+	if *report_file != "" {
+		f, e := os.Create(*report_file)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		defer f.Close()
+		w = f
+	}
+	ivc.WriteSweepReport(w, frontier, best)
+	if *preset_file != "" && best != nil {
+		ivc.WritePresetFile(*preset_file, best)
+	}
+	log.Printf("Finish sweeping hard-filter thresholds.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// Recal runs the recal subcommand, which recalibrates QUAL and FILTER of variant calls with a model
+// trained on annotations of calls at known sites.
+//--------------------------------------------------------------------------------------------------
+func Recal(args []string) {
+	log.Printf("IVC-recal: Recalibrating scores of variant calls.")
+	cmd := flag.NewFlagSet("recal", flag.ExitOnError)
+	var call_file = cmd.String("O", "", "variant call file (VCF format)")
+	var known_file = cmd.String("known", "", "known variant sites (VCF format, can be gzip-compressed, optional; KV calls are always known sites)")
+	var out_file = cmd.String("out", "", "file for writing recalibrated variant calls (VCF format)")
+	var sens = cmd.Float64("sens", 0.99, "sensitivity on known sites of the filtering threshold")
+	cmd.Parse(args)
+	if *call_file == "" || *out_file == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	ivc.Recalibrate(*call_file, *known_file, *out_file, *sens)
+	log.Printf("Finish recalibrating variant calls.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// State runs the state subcommand, which writes a variant call state saved by -save-state in text
+// format for inspection.
+//--------------------------------------------------------------------------------------------------
+func State(args []string) {
+	log.Printf("IVC-state: Inspecting a variant call state.")
+	cmd := flag.NewFlagSet("state", flag.ExitOnError)
+	var state_file = cmd.String("i", "", "variant call state file (saved by -save-state)")
+	var out_file = cmd.String("out", "", "file for writing the state in text format (default: standard output)")
+	cmd.Parse(args)
+	if *state_file == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	S := ivc.LoadVarCallState(*state_file)
+	w := os.Stdout
+	if *out_file != "" {
+		f, e := os.Create(*out_file)
+		if e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		defer f.Close()
+		w = f
+	}
+	ivc.WriteStateTable(w, S)
+	log.Printf("Finish inspecting the variant call state.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// Merge runs the merge subcommand, which merges variant call states of parallel runs (e.g. runs with
+// parts of reads on different nodes). Merged statistics are saved as a state (-save-state), and/or
+// variant calls are written from them if the multigenome and an output file are given.
+//--------------------------------------------------------------------------------------------------
+func Merge(args []string) {
+	log.Printf("IVC-merge: Merging variant call states.")
+	cmd := flag.NewFlagSet("merge", flag.ExitOnError)
+	var state_files = cmd.String("states", "", "comma-separated variant call state files (saved by -save-state)")
+	para_info := ReadInputInfo(cmd, args)
+	if *state_files == "" || (para_info.Var_call_file == "" && para_info.Save_state == "") {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	S := ivc.MergeVarCallStates(strings.Split(*state_files, ","))
+	if para_info.Var_call_file == "" {
+		ivc.SaveState(S, para_info.Save_state)
+		log.Printf("Finish merging variant call states.")
+		return
+	}
+	para_info.Read_file_1, para_info.Read_file_2, para_info.Load_state = "", "", ""
+	para_info.Read_len, para_info.Info_len = 100, 100
+	ivc.Setup(para_info)
+	variant_caller := ivc.NewVariantCaller()
+	variant_caller.AddVarCallState(S)
+	if para_info.Save_state != "" {
+		ivc.SaveState(S, para_info.Save_state)
+	}
+	variant_caller.OutputVarCalls()
+	log.Printf("Finish merging variant call states.")
+}
@@ -0,0 +1,116 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: mask.go
+// Masked regions (blacklist). Problematic regions of the genome (e.g. centromeres, low-complexity
+// regions, regions of known artifacts) are given in a BED file. In drop mode, alignment evidence at
+// positions (of the best alignment of reads) within masked regions is dropped before variant
+// probabilities are updated, so that no calls are made there; in filter mode, evidence is kept and
+// calls within masked regions are marked with the filter MASKED.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	MASK_MODE_DROP   = "drop"   // evidence within masked regions is dropped
+	MASK_MODE_FILTER = "filter" // calls within masked regions are filtered
+	MASK_FILTER      = "MASKED" // filter name of calls within masked regions
+)
+
+//---------------------------------------------------------------------------------------------------
+// MaskInfo represents masked regions, as sorted and merged intervals [start, end) on the multigenome.
+//---------------------------------------------------------------------------------------------------
+type MaskInfo struct {
+	Regions [][2]int
+	Drop    bool  // drop mode (filter mode otherwise)
+	DropNum int64 // number of dropped aligned bases of variants (updated atomically by aligning goroutines)
+}
+
+// Masked regions (nil: no regions are masked)
+var MASK *MaskInfo
+
+//---------------------------------------------------------------------------------------------------
+// LoadMask reads masked regions from a BED file (can be gzip-compressed). Regions of chromosomes which
+// are not in the multigenome are ignored.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) LoadMask(file_name, mode string) *MaskInfo {
+	if mode != MASK_MODE_DROP && mode != MASK_MODE_FILTER {
+		log.Panicf("Error: unknown mask mode %s (supported modes: %s, %s)", mode, MASK_MODE_DROP, MASK_MODE_FILTER)
+	}
+	chr_idx := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		chr_idx[string(chr_name)] = i
+	}
+	M := &MaskInfo{Drop: mode == MASK_MODE_DROP}
+	masked_len, skipped_num := 0, 0
+	for chr_name, ivs := range LoadEvalRegions(file_name) {
+		chr_id, ok := chr_idx[chr_name]
+		if !ok {
+			skipped_num += len(ivs)
+			continue
+		}
+		chr_end := VC.SeqLen
+		if chr_id+1 < len(VC.ChrPos) {
+			chr_end = VC.ChrPos[chr_id+1]
+		}
+		for _, iv := range ivs {
+			start, end := VC.ChrPos[chr_id]+iv[0], MinInt(VC.ChrPos[chr_id]+iv[1], chr_end)
+			if start < end {
+				M.Regions = append(M.Regions, [2]int{start, end})
+				masked_len += end - start
+			}
+		}
+	}
+	M.Regions = MergeIntervals(M.Regions)
+	if skipped_num > 0 {
+		log.Printf("Warning: %d masked regions of chromosomes which are not in the reference are ignored", skipped_num)
+	}
+	log.Printf("Number of masked regions:\t%d, masked bases:\t%d (mode: %s)", len(M.Regions), masked_len, mode)
+	return M
+}
+
+//---------------------------------------------------------------------------------------------------
+// Trim removes variants of an aligned read-end within masked regions in drop mode.
+//---------------------------------------------------------------------------------------------------
+func (M *MaskInfo) Trim(vars []*VarInfo) []*VarInfo {
+	if M == nil || !M.Drop {
+		return vars
+	}
+	trimmed := vars[:0]
+	for _, v := range vars {
+		if !InIntervals(M.Regions, int(v.Pos)) {
+			trimmed = append(trimmed, v)
+		}
+	}
+	if n := len(vars) - len(trimmed); n > 0 {
+		atomic.AddInt64(&M.DropNum, int64(n))
+	}
+	return trimmed
+}
+
+//---------------------------------------------------------------------------------------------------
+// Filter adds the filter MASKED to the FILTER value of a call at a masked position in filter mode.
+//---------------------------------------------------------------------------------------------------
+func (M *MaskInfo) Filter(pos int, str_filter string) string {
+	if M == nil || M.Drop || !InIntervals(M.Regions, pos) {
+		return str_filter
+	}
+	if str_filter == "." || str_filter == "PASS" {
+		return MASK_FILTER
+	}
+	return str_filter + ";" + MASK_FILTER
+}
+
+//---------------------------------------------------------------------------------------------------
+// LogMask reports the number of dropped aligned bases within masked regions.
+//---------------------------------------------------------------------------------------------------
+func (M *MaskInfo) LogMask() {
+	if M == nil || !M.Drop {
+		return
+	}
+	log.Printf("Number of aligned bases dropped within masked regions:\t%d", M.DropNum)
+}
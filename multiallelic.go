@@ -0,0 +1,165 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: multiallelic.go
+// Multi-allelic records. Genotypes of all alleles of aligned reads at a location are considered, so a
+// call can carry two non-reference alleles (or a second non-reference allele can have substantial
+// posterior probability besides the called one). Such calls are written as a single record with all
+// these alleles in ALT, the genotype given by allele indexes and per-allele depths and likelihoods,
+// instead of only the most probable alternative allele.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Minimum posterior probability of genotypes carrying a non-reference allele for the allele to be
+// reported besides alleles of the called genotype
+const MULTI_ALLELE_MIN_PROB = 0.1
+
+//---------------------------------------------------------------------------------------------------
+// MultiAlleles returns alleles of a multi-allelic record of the call at a variant location given its
+// REF allele and haplotypes: REF first, then non-reference alleles of the called genotype, then other
+// alleles of aligned reads by decreasing posterior probability of genotypes carrying them (at least
+// MULTI_ALLELE_MIN_PROB). It returns nil if there are less than two non-reference alleles, for calls
+// of deletions (whose REF alleles differ between alleles), and in multi-sample and trio modes.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) MultiAlleles(rid int, pos uint32, ref string, hap_arr []string) []string {
+	site := VC.Calls[rid].Sites.Get(pos)
+	if MultiSample() || TRIO != nil || site.Type.Get(hap_arr[0]+"|"+hap_arr[1]) == 2 {
+		return nil
+	}
+	// Non-reference alleles of substitutions and insertions of aligned reads at the location
+	read_allele := make(map[string]bool)
+	for _, var_base := range site.RNum.Keys {
+		if var_arr := strings.Split(var_base, "|"); var_arr[0] == ref && var_arr[1] != ref && len(var_arr[0]) <= len(var_arr[1]) {
+			read_allele[var_arr[1]] = true
+		}
+	}
+	alleles := []string{ref}
+	for _, hap := range hap_arr {
+		if hap == ref || hap == alleles[len(alleles)-1] {
+			continue
+		}
+		if !read_allele[hap] {
+			return nil
+		}
+		alleles = append(alleles, hap)
+	}
+	allele_prob := make(map[string]float64)
+	var_probs, _ := VC.Calls[rid].VarProb.Get(pos)
+	for i, gt := range var_probs.Gts {
+		gt_arr := strings.Split(gt, "|")
+		allele_prob[gt_arr[0]] += var_probs.Vals[i]
+		if gt_arr[1] != gt_arr[0] {
+			allele_prob[gt_arr[1]] += var_probs.Vals[i]
+		}
+	}
+	others := make([]string, 0)
+	for allele, _ := range read_allele {
+		if allele != hap_arr[0] && allele != hap_arr[1] && allele_prob[allele] >= MULTI_ALLELE_MIN_PROB {
+			others = append(others, allele)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if allele_prob[others[i]] != allele_prob[others[j]] {
+			return allele_prob[others[i]] > allele_prob[others[j]]
+		}
+		return others[i] < others[j]
+	})
+	alleles = append(alleles, others...)
+	if len(alleles) < 3 {
+		return nil
+	}
+	return alleles
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiAlleleIndex returns the index of the allele of a multi-allelic record which aligned bases
+// var_base support (0 for the reference), and -1 for other alleles.
+//---------------------------------------------------------------------------------------------------
+func MultiAlleleIndex(var_base string, alleles []string) int {
+	var_arr := strings.Split(var_base, "|")
+	if var_arr[0] == var_arr[1] {
+		return 0
+	}
+	if len(var_arr[0]) > len(var_arr[1]) {
+		return -1
+	}
+	for i := 1; i < len(alleles); i++ {
+		if var_arr[1] == alleles[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiGT returns the genotype (FORMAT GT) of haplotypes given alleles of a multi-allelic record.
+//---------------------------------------------------------------------------------------------------
+func MultiGT(alleles, hap_arr []string) string {
+	gt := make([]int, 2)
+	for h, hap := range hap_arr {
+		for i, allele := range alleles {
+			if hap == allele {
+				gt[h] = i
+			}
+		}
+	}
+	sort.Ints(gt)
+	return strconv.Itoa(gt[0]) + "/" + strconv.Itoa(gt[1])
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiAlleleCounts returns FORMAT values AD:DP:ADF:ADR:SB of a multi-allelic record given numbers of
+// aligned reads (on the reverse strand) of alleles; SB sums reads of all alternative alleles.
+//---------------------------------------------------------------------------------------------------
+func MultiAlleleCounts(var_num, rev_num map[string]int, alleles []string) string {
+	ad, adr := make([]int, len(alleles)), make([]int, len(alleles))
+	depth := 0
+	for var_base, n := range var_num {
+		depth += n
+		if i := MultiAlleleIndex(var_base, alleles); i >= 0 {
+			ad[i] += n
+			adr[i] += rev_num[var_base]
+		}
+	}
+	str_ad, str_adf, str_adr := make([]string, len(alleles)), make([]string, len(alleles)), make([]string, len(alleles))
+	for i, _ := range alleles {
+		str_ad[i], str_adf[i], str_adr[i] = strconv.Itoa(ad[i]), strconv.Itoa(ad[i]-adr[i]), strconv.Itoa(adr[i])
+	}
+	sb := MultiStrandCounts(var_num, rev_num, alleles)
+	return strings.Join(str_ad, ",") + ":" + strconv.Itoa(depth) + ":" + strings.Join(str_adf, ",") + ":" + strings.Join(str_adr, ",") + ":" +
+		strconv.Itoa(sb[0]) + "," + strconv.Itoa(sb[1]) + "," + strconv.Itoa(sb[2]) + "," + strconv.Itoa(sb[3])
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiPhredLikelihoods returns PL of all genotypes of alleles of a multi-allelic record in the order
+// of the VCF specification (j/k at k*(k+1)/2+j), homozygous genotypes only at haploid positions; empty
+// if likelihoods of some genotypes are not available.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) MultiPhredLikelihoods(rid int, pos uint32, alleles []string) string {
+	var_like, ok := VC.Calls[rid].VarLike.Get(pos)
+	if !ok {
+		return ""
+	}
+	likes := make([]float64, 0)
+	for k := 0; k < len(alleles); k++ {
+		for j := 0; j <= k; j++ {
+			if j != k && IsHaploid(int(pos)) {
+				continue
+			}
+			l, ok := var_like.Get(alleles[j] + "|" + alleles[k])
+			if !ok {
+				if l, ok = var_like.Get(alleles[k] + "|" + alleles[j]); !ok {
+					return ""
+				}
+			}
+			likes = append(likes, l)
+		}
+	}
+	return PLString(likes)
+}
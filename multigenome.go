@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -70,50 +71,124 @@ func BuildMultiGenome(genome_file, var_prof_file string, debug_mode bool) (chr_p
 	return chr_pos, chr_name, seq, var_prof
 }
 
+//-------------------------------------------------------------------------------------------------
+// BuildMultiGenomeFiles builds multi-sequence from a standard reference genome and a variant profile,
+// then saves the multi-sequence, its reverse, and the variant profile index to the index directory.
+// It returns reverse of the multi-sequence, which is used to build the FM-index.
+//-------------------------------------------------------------------------------------------------
+func BuildMultiGenomeFiles(genome_file, var_prof_file, idx_dir string, debug_mode bool) []byte {
+	if _, e := os.Stat(idx_dir); e != nil {
+		if os.IsNotExist(e) {
+			if e = os.Mkdir(idx_dir, 0777); e != nil {
+				log.Panicf("Error: %s", e)
+			}
+		} else {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	chr_pos, chr_name, multi_seq, var_prof := BuildMultiGenome(genome_file, var_prof_file, debug_mode)
+	if debug_mode {
+		PrintMemStats("Memstats after building multi-sequence")
+	}
+	multi_seq_len := len(multi_seq)
+	rev_multi_seq := make([]byte, multi_seq_len)
+	for i := range multi_seq {
+		rev_multi_seq[i] = multi_seq[multi_seq_len-1-i]
+	}
+	// Only the FM-index of the reverse multi-sequence is used (seeds are searched forwardly on reads),
+	// the reverse multi-sequence itself is not saved, its FM-index directory is named after the multi-sequence
+	multi_seq_file, _, var_prof_idx_file := IndexFileNames(genome_file, var_prof_file, idx_dir)
+	SaveMultiSeq(multi_seq_file, chr_pos, chr_name, multi_seq)
+	SaveVarProf(var_prof_idx_file, chr_pos, chr_name, var_prof)
+	log.Printf("Multi-sequence file: %s", multi_seq_file)
+	log.Printf("Variant profile index file: %s", var_prof_idx_file)
+	return rev_multi_seq
+}
+
+//-------------------------------------------------------------------------------------------------
+// IndexFileNames returns names of multi-sequence, FM-index directory (of the reverse multi-sequence, named
+// after the multi-sequence), and variant profile index files which are created from a reference genome and
+// a variant profile in the index directory.
+//-------------------------------------------------------------------------------------------------
+func IndexFileNames(genome_file, var_prof_file, idx_dir string) (string, string, string) {
+	genome_file_name, var_prof_file_name := path.Base(genome_file), path.Base(var_prof_file)
+	multi_seq_file := JoinPath(idx_dir, genome_file_name) + ".mgf"
+	return multi_seq_file, multi_seq_file + ".index/", JoinPath(idx_dir, var_prof_file_name) + ".idx"
+}
+
+//-------------------------------------------------------------------------------------------------
+// FMIndexDir returns the FM-index directory to be loaded. Earlier versions named it after the reverse
+// multi-sequence file (.rev.mgf.index instead of .mgf.index), which is not saved anymore; such a local
+// directory is used if the new one does not exist.
+//-------------------------------------------------------------------------------------------------
+func FMIndexDir(dir string) string {
+	if IsRemote(dir) {
+		return dir
+	}
+	if _, e := os.Stat(dir); os.IsNotExist(e) {
+		old_dir := strings.TrimSuffix(dir, ".mgf.index/") + ".rev.mgf.index/"
+		if _, e = os.Stat(old_dir); e == nil {
+			log.Printf("Warning: using FM-index directory %s of an earlier version (.rev.mgf.index names are obsolete, rename it to %s)",
+				old_dir, dir)
+			return old_dir
+		}
+	}
+	return dir
+}
+
 //-------------------------------------------------------------------------------------------------
 // LoadMultiSeq loads multi-sequence from file.
 //-------------------------------------------------------------------------------------------------
 func LoadMultiSeq(file_name string) (chr_pos []int, chr_name [][]byte, multi_seq []byte) {
-	f, e := os.Open(file_name + ".idx")
+	chr_pos, chr_name = LoadChrInfo(file_name)
+
+	f, e := OpenCompressedInput(file_name)
 	if e != nil {
 		log.Panicf("Error: %s", e)
 	}
-	chr_pos = make([]int, 0)
-	chr_name = make([][]byte, 0)
 	r := bufio.NewReader(f)
-	var pos int
 	var line []byte
+	multi_seq = make([]byte, 0)
 	for {
 		line, e = r.ReadBytes('\n')
 		sline := bytes.Trim(line, "\n\r")
-		if len(sline) != 0 && sline[0] == '>' {
-			split := bytes.Split(sline, []byte("\t"))
-			pos, _ = strconv.Atoi(string(split[1]))
-			chr_pos = append(chr_pos, pos)
-			chr_name = append(chr_name, split[0][1:])
-		}
+		multi_seq = append(multi_seq, sline...)
 		if e != nil { //reach EOF
 			break
 		}
 	}
 	f.Close()
+	return chr_pos, chr_name, multi_seq
+}
 
-	f, e = os.Open(file_name)
+//-------------------------------------------------------------------------------------------------
+// LoadChrInfo loads positions and names of chromosomes on multi-sequence from its index file.
+//-------------------------------------------------------------------------------------------------
+func LoadChrInfo(file_name string) (chr_pos []int, chr_name [][]byte) {
+	f, e := OpenCompressedInput(file_name + ".idx")
 	if e != nil {
 		log.Panicf("Error: %s", e)
 	}
-	r = bufio.NewReader(f)
-	multi_seq = make([]byte, 0)
+	defer f.Close()
+	chr_pos = make([]int, 0)
+	chr_name = make([][]byte, 0)
+	r := bufio.NewReader(f)
+	var pos int
+	var line []byte
 	for {
 		line, e = r.ReadBytes('\n')
 		sline := bytes.Trim(line, "\n\r")
-		multi_seq = append(multi_seq, sline...)
+		if len(sline) != 0 && sline[0] == '>' {
+			split := bytes.Split(sline, []byte("\t"))
+			pos, _ = strconv.Atoi(string(split[1]))
+			chr_pos = append(chr_pos, pos)
+			chr_name = append(chr_name, split[0][1:])
+		}
 		if e != nil { //reach EOF
 			break
 		}
 	}
-	f.Close()
-	return chr_pos, chr_name, multi_seq
+	return chr_pos, chr_name
 }
 
 //-------------------------------------------------------------------------------------------------
@@ -146,7 +221,7 @@ func SaveMultiSeq(file_name string, chr_pos []int, chr_name [][]byte, multi_seq
 //-------------------------------------------------------------------------------------------------
 func LoadVarProf(file_name string) (variant map[int][][]byte, af map[int][]float32) {
 
-	f, e := os.Open(file_name)
+	f, e := OpenCompressedInput(file_name)
 	if e != nil {
 		log.Panicf("Error: %s", e)
 	}
@@ -224,7 +299,7 @@ func SaveVarProf(file_name string, chr_pos []int, chr_name [][]byte, var_prof ma
 // GetGenome gets reference genome from FASTA files.
 //--------------------------------------------------------------------------------------------------
 func GetGenome(file_name string) (chr_pos []int, chr_name [][]byte, seq []byte) {
-	f, e := os.Open(file_name)
+	f, e := OpenCompressedInput(file_name)
 	if e != nil {
 		log.Panicf("Error: %s", e)
 	}
@@ -259,7 +334,7 @@ func GetGenome(file_name string) (chr_pos []int, chr_name [][]byte, seq []byte)
 //--------------------------------------------------------------------------------------------------
 func GetVarProfInfo(file_name string) map[string]map[int]VarProfInfo {
 
-	f, e := os.Open(file_name)
+	f, e := OpenCompressedInput(file_name)
 	if e != nil {
 		log.Panicf("Error: %s", e)
 	}
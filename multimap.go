@@ -0,0 +1,101 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: multimap.go
+// Multi-mapping read policy. A read is ambiguous if several alignments at different positions tie at
+// the minimum paired distance. Ambiguous reads can be resolved by keeping the first best alignment
+// (default), discarding the read, picking one of the tied alignments randomly (with mapping quality
+// 0), or distributing fractional evidence over all tied alignments. The ambiguity rate is reported.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	MULTI_MAP_FIRST      = "first"      // keep the first best alignment
+	MULTI_MAP_DISCARD    = "discard"    // discard ambiguous reads
+	MULTI_MAP_RANDOM     = "random"     // pick one of the tied alignments randomly, with mapping quality 0
+	MULTI_MAP_FRACTIONAL = "fractional" // distribute evidence over all tied alignments
+)
+
+//---------------------------------------------------------------------------------------------------
+// AlnCand represents an alignment candidate of a paired-end read.
+//---------------------------------------------------------------------------------------------------
+type AlnCand struct {
+	Vars1, Vars2         []*VarInfo // variant evidence of the two ends
+	CovStart1, CovStart2 int        // start positions of the two aligned ends
+	Strand1, Strand2     bool       // strands of the two aligned ends
+}
+
+// Numbers of aligned reads and ambiguous reads (updated atomically by aligning goroutines)
+var MULTI_MAP_ALN_NUM, MULTI_MAP_AMB_NUM int64
+
+//---------------------------------------------------------------------------------------------------
+// CheckMultiMap checks the multi-mapping policy.
+//---------------------------------------------------------------------------------------------------
+func CheckMultiMap(policy string) {
+	switch policy {
+	case MULTI_MAP_FIRST, MULTI_MAP_DISCARD, MULTI_MAP_RANDOM, MULTI_MAP_FRACTIONAL:
+	default:
+		log.Panicf("Error: unknown multi-mapping policy %s (supported policies: first, discard, random, fractional)", policy)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddTie adds an alignment candidate which ties with the best one (at cov_start1, cov_start2), unless
+// it is at the same positions as the best one or another tied candidate.
+//---------------------------------------------------------------------------------------------------
+func AddTie(ties []*AlnCand, cand *AlnCand, cov_start1, cov_start2 int) []*AlnCand {
+	if cand.CovStart1 == cov_start1 && cand.CovStart2 == cov_start2 {
+		return ties
+	}
+	for _, t := range ties {
+		if cand.CovStart1 == t.CovStart1 && cand.CovStart2 == t.CovStart2 {
+			return ties
+		}
+	}
+	return append(ties, cand)
+}
+
+//---------------------------------------------------------------------------------------------------
+// CountMultiMap counts an aligned read and whether it is ambiguous.
+//---------------------------------------------------------------------------------------------------
+func CountMultiMap(ambiguous bool) {
+	atomic.AddInt64(&MULTI_MAP_ALN_NUM, 1)
+	if ambiguous {
+		atomic.AddInt64(&MULTI_MAP_AMB_NUM, 1)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// SpreadTies returns variant evidence of all tied alignment candidates of a read, each evidence
+// carries the number of candidates so that its likelihood is shared among them.
+//---------------------------------------------------------------------------------------------------
+func SpreadTies(cands []*AlnCand) ([]*VarInfo, []*VarInfo) {
+	var vars1, vars2 []*VarInfo
+	for _, c := range cands {
+		for _, v := range c.Vars1 {
+			v.TieNum = len(cands)
+		}
+		for _, v := range c.Vars2 {
+			v.TieNum = len(cands)
+		}
+		vars1, vars2 = append(vars1, c.Vars1...), append(vars2, c.Vars2...)
+	}
+	return vars1, vars2
+}
+
+//---------------------------------------------------------------------------------------------------
+// LogMultiMap reports the number of ambiguous reads and the ambiguity rate.
+//---------------------------------------------------------------------------------------------------
+func LogMultiMap() {
+	aln_num, amb_num := atomic.LoadInt64(&MULTI_MAP_ALN_NUM), atomic.LoadInt64(&MULTI_MAP_AMB_NUM)
+	rate := 0.0
+	if aln_num > 0 {
+		rate = float64(amb_num) / float64(aln_num)
+	}
+	log.Printf("Number of ambiguous reads (tied best alignments, policy %s):\t%d (%.4f of aligned reads)", PARA.Multi_map, amb_num, rate)
+}
@@ -0,0 +1,98 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: output.go
+// Output files. Variant calls and auxiliary reports are written through buffered writers, and are
+// gzip-compressed on the fly in compressed-output mode (file names get the suffix .gz). Appending to
+// a compressed file adds a new gzip member, concatenated members are read as one stream by gzip tools.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"log"
+	"os"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// OutputFile represents a buffered writer of an output file (gzip-compressed if required).
+//---------------------------------------------------------------------------------------------------
+type OutputFile struct {
+	*bufio.Writer
+	f  *os.File
+	gz *gzip.Writer
+}
+
+//---------------------------------------------------------------------------------------------------
+// CreateOutput creates an output file (truncated if it exists).
+//---------------------------------------------------------------------------------------------------
+func CreateOutput(file_name string) *OutputFile {
+	return OpenOutput(file_name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+//---------------------------------------------------------------------------------------------------
+// AppendOutput opens an existing output file for appending.
+//---------------------------------------------------------------------------------------------------
+func AppendOutput(file_name string) *OutputFile {
+	return OpenOutput(file_name, os.O_APPEND|os.O_WRONLY)
+}
+
+//---------------------------------------------------------------------------------------------------
+// OpenOutput opens an output file with flags of os.OpenFile.
+//---------------------------------------------------------------------------------------------------
+func OpenOutput(file_name string, flag int) *OutputFile {
+	f, e := os.OpenFile(file_name, flag, 0666)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	O := &OutputFile{f: f}
+	if PARA != nil && PARA.Gzip_output {
+		O.gz, _ = gzip.NewWriterLevel(f, gzip.BestSpeed)
+		O.Writer = bufio.NewWriter(O.gz)
+	} else {
+		O.Writer = bufio.NewWriter(f)
+	}
+	return O
+}
+
+//---------------------------------------------------------------------------------------------------
+// Close flushes buffered data and closes the file.
+//---------------------------------------------------------------------------------------------------
+func (O *OutputFile) Close() {
+	if e := O.Flush(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if O.gz != nil {
+		if e := O.gz.Close(); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	if e := O.f.Close(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// OutputName returns name of an output file, with the suffix .gz in compressed-output mode.
+//---------------------------------------------------------------------------------------------------
+func OutputName(file_name string, compress bool) string {
+	if file_name == "" || !compress || strings.HasSuffix(file_name, ".gz") {
+		return file_name
+	}
+	return file_name + ".gz"
+}
+
+//---------------------------------------------------------------------------------------------------
+// AppendRecord appends fields of a record separated by tabs and ended by a newline to buf.
+//---------------------------------------------------------------------------------------------------
+func AppendRecord(buf []byte, fields []string) []byte {
+	for i, field := range fields {
+		if i > 0 {
+			buf = append(buf, '\t')
+		}
+		buf = append(buf, field...)
+	}
+	return append(buf, '\n')
+}
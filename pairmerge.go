@@ -0,0 +1,105 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: pairmerge.go
+// Merging of overlapping read pairs. If the insert is shorter than the sum of lengths of the two ends,
+// the end of the first read overlaps the start of the reverse complement of the second read. The best
+// overlap (the one with the smallest fraction of mismatches, as in FLASH) is found, and the two ends
+// are merged into a consensus fragment whose overlapping bases have combined qualities. The fragment
+// is split into two adjacent, non-overlapping halves which are aligned as a pair, so that bases of the
+// overlap are aligned and counted as evidence once.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+const (
+	MERGE_MIN_OVERLAP  = 10   // minimum length of overlaps of merged read pairs
+	MERGE_MAX_MISMATCH = 0.25 // maximum fraction of mismatches in overlaps of merged read pairs
+)
+
+//---------------------------------------------------------------------------------------------------
+// PairMerger represents buffers for merging overlapping read pairs.
+//---------------------------------------------------------------------------------------------------
+type PairMerger struct {
+	rc_read, rc_qual []byte // reverse complement of the second end
+	frag, frag_qual  []byte // merged fragment
+}
+
+//---------------------------------------------------------------------------------------------------
+// BestOverlap returns the length of the overlap between the end of read1 and the start of read2 with
+// the smallest fraction of mismatches (longer overlaps are preferred on ties), 0 if there is no
+// overlap of at least MERGE_MIN_OVERLAP bases with at most MERGE_MAX_MISMATCH mismatches. N bases
+// are not counted as mismatches.
+//---------------------------------------------------------------------------------------------------
+func BestOverlap(read1, read2 []byte) int {
+	best_len, best_rate := 0, MERGE_MAX_MISMATCH
+	for o := MinInt(len(read1), len(read2)); o >= MERGE_MIN_OVERLAP; o-- {
+		max_mis := int(best_rate * float64(o))
+		mis := 0
+		for i, j := len(read1)-o, 0; j < o && mis <= max_mis; i, j = i+1, j+1 {
+			if read1[i] != read2[j] && read1[i] != 'N' && read2[j] != 'N' {
+				mis++
+			}
+		}
+		if rate := float64(mis) / float64(o); mis <= max_mis && (best_len == 0 || rate < best_rate) {
+			best_len, best_rate = o, rate
+		}
+	}
+	return best_len
+}
+
+//---------------------------------------------------------------------------------------------------
+// ConsensusBase returns the base and the quality (Phred+33) of an overlapping position given bases
+// and qualities of the two ends. Qualities of agreeing bases are summed (up to QUAL_MAX), the base of
+// the higher quality is taken for disagreeing bases, with the difference of qualities (at least
+// QUAL_MIN).
+//---------------------------------------------------------------------------------------------------
+func ConsensusBase(b1, q1, b2, q2 byte) (byte, byte) {
+	if b1 == 'N' {
+		return b2, q2
+	}
+	if b2 == 'N' {
+		return b1, q1
+	}
+	if b1 == b2 {
+		return b1, byte(MinInt(int(q1)+int(q2)-33, QUAL_MAX+33))
+	}
+	if q1 < q2 {
+		b1, q1, b2, q2 = b2, q2, b1, q1
+	}
+	return b1, byte(MaxInt(int(q1)-int(q2), QUAL_MIN) + 33)
+}
+
+//---------------------------------------------------------------------------------------------------
+// MergePair merges the two ends of a read if they overlap, the consensus fragment is split into two
+// halves which replace the two ends (the second half is reverse complemented, as a second end). It
+// returns false (the read is not changed) if the ends do not overlap or halves would be too short for
+// seeds.
+//---------------------------------------------------------------------------------------------------
+func (M *PairMerger) MergePair(read_info *ReadInfo) bool {
+	len1, len2 := read_info.Len1, read_info.Len2
+	M.rc_read, M.rc_qual = append(M.rc_read[:0], read_info.Read2...), append(M.rc_qual[:0], read_info.Qual2...)
+	RevComp(read_info.Read2, read_info.Qual2, M.rc_read, M.rc_qual)
+	o := BestOverlap(read_info.Read1, M.rc_read)
+	if o == 0 {
+		return false
+	}
+	frag_len := len1 + len2 - o
+	half1 := MinInt((frag_len+1)/2, len1)
+	if frag_len-half1 > len2 {
+		half1 = frag_len - len2
+	}
+	if half1 <= PARA.Min_slen || frag_len-half1 <= PARA.Min_slen {
+		return false
+	}
+	M.frag, M.frag_qual = append(M.frag[:0], read_info.Read1[:len1-o]...), append(M.frag_qual[:0], read_info.Qual1[:len1-o]...)
+	for i, j := len1-o, 0; j < o; i, j = i+1, j+1 {
+		b, q := ConsensusBase(read_info.Read1[i], read_info.Qual1[i], M.rc_read[j], M.rc_qual[j])
+		M.frag, M.frag_qual = append(M.frag, b), append(M.frag_qual, q)
+	}
+	M.frag, M.frag_qual = append(M.frag, M.rc_read[o:]...), append(M.frag_qual, M.rc_qual[o:]...)
+	read_info.SetReadLen(half1, frag_len-half1)
+	copy(read_info.Read1, M.frag[:half1])
+	copy(read_info.Qual1, M.frag_qual[:half1])
+	RevComp(M.frag[half1:], M.frag_qual[half1:], read_info.Read2, read_info.Qual2)
+	return true
+}
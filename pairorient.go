@@ -0,0 +1,182 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: pairorient.go
+// Expected orientation of the two ends of read pairs. Conventional paired-end libraries (i.e. Illumina)
+// are F-R (the ends face each other), mate-pair and some legacy libraries are R-F (the ends face away
+// from each other) or F-F (the ends are on the same strand). Seeds of the two ends are only paired in
+// the expected orientation, and pairs anchored in other orientations are discordant. Mate-pair libraries
+// have multi-kb inserts and also contain paired-end read pairs in the opposite orientation: both regimes
+// are proper, and insert sizes of aligned pairs are scored by distributions estimated in the warm-up phase.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+const (
+	PAIR_ORIENT_FR = "fr" // the first end is forward and the second end is reverse (on the leftmost first end)
+	PAIR_ORIENT_RF = "rf" // the first end is reverse and the second end is forward (on the leftmost first end)
+	PAIR_ORIENT_FF = "ff" // both ends are forward (on the leftmost first end)
+
+	PE_MAX_INS        = 1500  // maximum insert size of paired-end libraries
+	MATE_PAIR_MAX_INS = 20000 // maximum insert size of mate-pair libraries
+	MATE_PAIR_WARM_UP = 10000 // number of read pairs aligned in the warm-up phase of mate-pair libraries (if not given)
+	INS_MIN_NUM       = 100   // minimum number of read pairs for estimating insert sizes of a regime
+	INS_DEV_NUM       = 3.0   // number of standard deviations from the mean insert size which costs one substitution
+)
+
+//---------------------------------------------------------------------------------------------------
+// CheckPairOrient checks the expected orientation of read pairs.
+//---------------------------------------------------------------------------------------------------
+func CheckPairOrient(orient string) {
+	switch orient {
+	case PAIR_ORIENT_FR, PAIR_ORIENT_RF, PAIR_ORIENT_FF:
+	default:
+		log.Panicf("Error: unknown orientation %s of read pairs (supported orientations: fr, rf, ff)", orient)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// PairRegime represents a regime of proper read pairs: their orientation and insert sizes.
+//---------------------------------------------------------------------------------------------------
+type PairRegime struct {
+	Orient   string  // orientation of read pairs
+	Max_ins  int     // maximum insert size
+	Ins_mean float64 // mean of insert sizes (estimated in the warm-up phase of mate-pair libraries)
+	Ins_sd   float64 // standard deviation of insert sizes (0: insert sizes are not scored)
+	Spliced  bool    // read pairs are only proper if an end is aligned with a reference skip (spliced mode)
+}
+
+// Regimes of proper read pairs: the expected orientation, and paired-end contamination of mate-pair libraries
+var PAIR_REGIMES []*PairRegime
+
+//---------------------------------------------------------------------------------------------------
+// SetupPairRegimes sets up regimes of proper read pairs. Mate-pair libraries also contain read pairs of
+// short fragments which were not circularized, they are proper in the opposite orientation (F-R for R-F
+// or F-F libraries, R-F for F-R libraries) with insert sizes of paired-end libraries. In spliced mode, the
+// last regime lets read pairs with an end spanning an intron be up to the maximum intron length farther.
+//---------------------------------------------------------------------------------------------------
+func SetupPairRegimes() {
+	PAIR_REGIMES = []*PairRegime{{Orient: PARA.Pair_orient, Max_ins: PARA.Max_ins}}
+	if PARA.Mate_pair {
+		orient := PAIR_ORIENT_FR
+		if PARA.Pair_orient == PAIR_ORIENT_FR {
+			orient = PAIR_ORIENT_RF
+		}
+		PAIR_REGIMES = append(PAIR_REGIMES, &PairRegime{Orient: orient, Max_ins: PE_MAX_INS})
+	}
+	if PARA.Spliced {
+		PAIR_REGIMES = append(PAIR_REGIMES, &PairRegime{Orient: PARA.Pair_orient, Max_ins: PARA.Max_ins + SPLICE_MAX_INTRON, Spliced: true})
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// PairLead returns which end of a read pair is leftmost (1 or 2) given strands of the two ends ("true"
+// for forward), or 0 if strands are not in the orientation. The second end is leftmost if both strands
+// are flipped (the pair comes from the reverse strand of the fragment).
+//---------------------------------------------------------------------------------------------------
+func PairLead(orient string, strand1, strand2 bool) int {
+	lead1, lead2 := true, false
+	switch orient {
+	case PAIR_ORIENT_RF:
+		lead1, lead2 = false, true
+	case PAIR_ORIENT_FF:
+		lead1, lead2 = true, true
+	}
+	if strand1 == lead1 && strand2 == lead2 {
+		return 1
+	}
+	if strand1 != lead1 && strand2 != lead2 {
+		return 2
+	}
+	return 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// ProperStrands checks if strands of the two ends of a read pair are in the orientation of a regime.
+//---------------------------------------------------------------------------------------------------
+func ProperStrands(strand1, strand2 bool) bool {
+	for _, r := range PAIR_REGIMES {
+		if PairLead(r.Orient, strand1, strand2) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// PairInsert returns the index of the first regime in which the two ends of a read pair starting at pos1
+// and pos2 are proper (-1 if there are no such regimes) and the insert size. The leftmost end must be
+// followed by the other end within the maximum insert size, the two ends can overlap if overlap is set.
+//---------------------------------------------------------------------------------------------------
+func PairInsert(pos1, pos2, len1, len2 int, strand1, strand2 bool, overlap bool) (int, int) {
+	for r_idx, r := range PAIR_REGIMES {
+		lead := PairLead(r.Orient, strand1, strand2)
+		dist, lead_len, trail_len := pos2-pos1, len1, len2
+		if lead == 2 {
+			dist, lead_len, trail_len = -dist, len2, len1
+		}
+		min_dist := lead_len
+		if overlap {
+			min_dist = 0
+		}
+		if lead != 0 && dist >= min_dist && dist <= lead_len+r.Max_ins {
+			return r_idx, dist + trail_len
+		}
+	}
+	return -1, 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// ProperPairDist checks if positions of the two ends of a read pair with given strands have a proper
+// distance in a regime: the leftmost end is followed by the other end within the maximum insert size.
+//---------------------------------------------------------------------------------------------------
+func ProperPairDist(pos1, pos2, len1, len2 int, strand1, strand2 bool) bool {
+	r_idx, _ := PairInsert(pos1, pos2, len1, len2, strand1, strand2, false)
+	return r_idx >= 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// InsPenalty returns the penalty of the insert size of an aligned read pair for scoring its alignment:
+// a deviation of INS_DEV_NUM standard deviations from the mean insert size of its regime costs one
+// substitution. Insert sizes are only scored in regimes with estimated insert sizes.
+//---------------------------------------------------------------------------------------------------
+func InsPenalty(pos1, pos2, len1, len2 int, strand1, strand2 bool) float64 {
+	r_idx, ins := PairInsert(pos1, pos2, len1, len2, strand1, strand2, true)
+	if r_idx < 0 || PAIR_REGIMES[r_idx].Ins_sd == 0 {
+		return 0
+	}
+	z := (float64(ins) - PAIR_REGIMES[r_idx].Ins_mean) / PAIR_REGIMES[r_idx].Ins_sd / INS_DEV_NUM
+	return PARA.Sub_cost * z * z
+}
+
+//---------------------------------------------------------------------------------------------------
+// EstimateInsSizes estimates means and standard deviations of insert sizes of regimes (median and scaled
+// median absolute deviation) from insert sizes of read pairs aligned in the warm-up phase.
+//---------------------------------------------------------------------------------------------------
+func EstimateInsSizes(ins_sizes [][]int) {
+	for r_idx, r := range PAIR_REGIMES {
+		if r.Spliced {
+			continue
+		}
+		if r_idx >= len(ins_sizes) || len(ins_sizes[r_idx]) < INS_MIN_NUM {
+			log.Printf("Too few read pairs are aligned in %s orientation in the warm-up phase, their insert sizes are not scored.", r.Orient)
+			continue
+		}
+		sizes := ins_sizes[r_idx]
+		sort.Ints(sizes)
+		median := sizes[len(sizes)/2]
+		devs := make([]int, len(sizes))
+		for i, ins := range sizes {
+			devs[i] = MaxInt(ins-median, median-ins)
+		}
+		sort.Ints(devs)
+		r.Ins_mean, r.Ins_sd = float64(median), math.Max(1.4826*float64(devs[len(devs)/2]), 1)
+		log.Printf("Insert sizes of read pairs in %s orientation:	%d pairs, median:	%g, standard deviation:	%.1f",
+			r.Orient, len(sizes), r.Ins_mean, r.Ins_sd)
+	}
+}
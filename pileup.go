@@ -0,0 +1,167 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: pileup.go
+// Pileup output (similar to samtools mpileup): observed bases and base qualities of aligned reads
+// at each covered position of the multigenome. Alignments of reads are reconstructed from their
+// start positions and variants (mismatches and indels). Bases are encoded as in mpileup: '.' and ','
+// for reference matches on forward and reverse strands, ACGT/acgt for mismatches, +N/-N followed by
+// inserted/deleted bases after the base before an indel, and '*' for deleted bases. Pileup sites are
+// sharded by ranges of positions as variant calls (see VarCallIndex.Calls), each shard has its own lock.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//---------------------------------------------------------------------------------------------------
+// PileupSite represents observed bases and qualities at a position.
+//---------------------------------------------------------------------------------------------------
+type PileupSite struct {
+	Depth int    // number of reads covering the position (including deletions)
+	Bases []byte // encoded bases
+	Quals []byte // base qualities (one per read)
+}
+
+//---------------------------------------------------------------------------------------------------
+// PileupShard represents pileup sites of a range of positions of the multigenome.
+//---------------------------------------------------------------------------------------------------
+type PileupShard struct {
+	Sites map[uint32]*PileupSite // pileup sites indexed by positions on the multigenome
+	mut   sync.Mutex             // mutex lock for updating pileup sites of the shard
+}
+
+// Shards of pileup sites (allocated if pileup output is required)
+var PILEUP []*PileupShard
+
+//---------------------------------------------------------------------------------------------------
+// InitPileup allocates PARA.Proc_num shards of pileup sites.
+//---------------------------------------------------------------------------------------------------
+func InitPileup() {
+	PILEUP = make([]*PileupShard, PARA.Proc_num)
+	for rid := range PILEUP {
+		PILEUP[rid] = &PileupShard{Sites: make(map[uint32]*PileupSite)}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddPileup adds bases of an aligned read-end (in the strand aligned to the reference) to pileup sites.
+// Only the lock of the shard of the current position is held (read-ends span at most a few shards).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddPileup(read, qual []byte, start int, strand bool, vars []*VarInfo) {
+	indels := make(map[int]*VarInfo)
+	for _, v := range vars {
+		if v.Type == 1 || v.Type == 2 {
+			indels[int(v.Pos)] = v
+		}
+	}
+	var shard *PileupShard
+	defer func() {
+		if shard != nil {
+			shard.mut.Unlock()
+		}
+	}()
+	site_at := func(pos int) *PileupSite {
+		if s := PILEUP[PARA.Proc_num*pos/VC.SeqLen]; s != shard {
+			if shard != nil {
+				shard.mut.Unlock()
+			}
+			shard = s
+			shard.mut.Lock()
+		}
+		site, ok := shard.Sites[uint32(pos)]
+		if !ok {
+			site = new(PileupSite)
+			shard.Sites[uint32(pos)] = site
+		}
+		return site
+	}
+	add := func(pos int, base, q byte) {
+		site := site_at(pos)
+		site.Depth++
+		site.Bases = append(site.Bases, base)
+		site.Quals = append(site.Quals, q)
+	}
+	for i, pos := 0, start; i < len(read) && pos < VC.SeqLen; i, pos = i+1, pos+1 {
+		if pos < 0 {
+			continue
+		}
+		base := read[i]
+		if bytes.EqualFold([]byte{base}, []byte{VC.RefBase(pos)[0]}) {
+			base = StrandBase('.', ',', strand)
+		} else {
+			base = StrandBase(bytes.ToUpper([]byte{base})[0], bytes.ToLower([]byte{base})[0], strand)
+		}
+		add(pos, base, qual[i])
+		v, ok := indels[pos]
+		if !ok {
+			continue
+		}
+		site := site_at(pos)
+		if var_arr := strings.Split(string(v.Bases), "|"); v.Type == 1 {
+			ins := var_arr[1][1:]
+			site.Bases = append(site.Bases, []byte("+"+strconv.Itoa(len(ins))+StrandSeq(ins, strand))...)
+			i += len(ins)
+		} else {
+			del := var_arr[0][1:]
+			site.Bases = append(site.Bases, []byte("-"+strconv.Itoa(len(del))+StrandSeq(del, strand))...)
+			for k := 1; k <= len(del) && pos+k < VC.SeqLen; k++ {
+				add(pos+k, '*', qual[i])
+			}
+			pos += len(del)
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandBase returns the forward base if strand is forward, the reverse base otherwise.
+//---------------------------------------------------------------------------------------------------
+func StrandBase(forward, reverse byte, strand bool) byte {
+	if strand {
+		return forward
+	}
+	return reverse
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandSeq returns a sequence in upper case if strand is forward, in lower case otherwise.
+//---------------------------------------------------------------------------------------------------
+func StrandSeq(seq string, strand bool) string {
+	if strand {
+		return strings.ToUpper(seq)
+	}
+	return strings.ToLower(seq)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WritePileup writes pileup sites sorted by positions to a file, each line has chromosome name,
+// position, reference base, depth, encoded bases and base qualities (tab-delimited).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WritePileup(file_name string) {
+	w := CreateOutput(file_name)
+	defer w.Close()
+	site_num := 0
+	// Shards are ranges of positions in increasing order
+	for _, shard := range PILEUP {
+		pos_arr := make([]int, 0, len(shard.Sites))
+		for pos := range shard.Sites {
+			pos_arr = append(pos_arr, int(pos))
+		}
+		sort.Ints(pos_arr)
+		for _, pos := range pos_arr {
+			site := shard.Sites[uint32(pos)]
+			chr_name, chr_pos := VC.ChrLoc(pos)
+			w.WriteString(strings.Join([]string{chr_name, strconv.Itoa(chr_pos), VC.RefBase(pos)[:1], strconv.Itoa(site.Depth),
+				string(site.Bases), string(site.Quals)}, "\t") + "\n")
+		}
+		site_num += len(pos_arr)
+	}
+	w.Flush()
+	log.Printf("Number of pileup sites:\t%d, written to:\t%s", site_num, file_name)
+}
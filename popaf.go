@@ -0,0 +1,97 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: popaf.go
+// External population allele-frequency priors. Allele frequencies from a population resource (a
+// gnomAD-style VCF with AF in INFO, or a table "chrom pos ref alt af") contribute to priors of
+// genotypes at known variant locations, weighted against allele frequencies of the variant profile.
+// Allele frequencies of the variant profile are still used for alignment.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Population allele frequencies at known variant locations, indexed by positions on the multigenome
+// and alternative alleles (nil: no population resource)
+var POP_AF map[int]map[string]float32
+
+//---------------------------------------------------------------------------------------------------
+// LoadPopAF reads population allele frequencies of alleles of the variant profile from a VCF file or
+// a table. Alleles which are not in the variant profile are ignored.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) LoadPopAF(file_name string) map[int]map[string]float32 {
+	scanner, done := OpenTextInput(file_name)
+	defer done()
+	chr_idx := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		chr_idx[string(chr_name)] = i
+	}
+	pop_af := make(map[int]map[string]float32)
+	af_num := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		var alts, afs []string
+		if len(tokens) >= 8 { // VCF
+			alts = strings.Split(tokens[4], ",")
+			for _, field := range strings.Split(tokens[7], ";") {
+				if strings.HasPrefix(field, "AF=") {
+					afs = strings.Split(field[3:], ",")
+				}
+			}
+		} else if len(tokens) == 5 { // table
+			alts, afs = []string{tokens[3]}, []string{tokens[4]}
+		} else {
+			log.Panicf("Error: invalid line in allele frequency file %s: %s", file_name, line)
+		}
+		chr_id, ok := chr_idx[tokens[0]]
+		if !ok || len(alts) != len(afs) {
+			continue
+		}
+		pos, e := strconv.Atoi(tokens[1])
+		if e != nil {
+			log.Panicf("Error: invalid position in allele frequency file %s: %s", file_name, line)
+		}
+		pos = VC.ChrPos[chr_id] + pos - 1
+		var_prof, is_known_var := VC.Variants[pos]
+		if !is_known_var || string(var_prof[0]) != strings.ToUpper(tokens[3]) {
+			continue
+		}
+		for i, alt := range alts {
+			af, e := strconv.ParseFloat(afs[i], 32)
+			if e != nil || af < 0 || af > 1 {
+				continue
+			}
+			if _, ok := pop_af[pos]; !ok {
+				pop_af[pos] = make(map[string]float32)
+			}
+			pop_af[pos][strings.ToUpper(alt)] = float32(af)
+			af_num++
+		}
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Number of population allele frequencies at known variant locations:\t%d", af_num)
+	return pop_af
+}
+
+//---------------------------------------------------------------------------------------------------
+// BlendAF returns allele frequencies of the reference and the alternative allele of a known variant,
+// combining frequencies of the variant profile (af) and the population frequency of the alternative
+// allele with weight w. The population frequency is used alone if the profile has no frequencies.
+//---------------------------------------------------------------------------------------------------
+func BlendAF(af []float32, pop_af float32, w float64) []float32 {
+	alt_af := float64(pop_af)
+	if len(af) >= 2 && af[0]+af[1] > 0 {
+		alt_af = (1-w)*float64(af[1]/(af[0]+af[1])) + w*alt_af
+	}
+	return []float32{float32(1 - alt_af), float32(alt_af)}
+}
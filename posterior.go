@@ -0,0 +1,117 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: posterior.go
+// Order-independent posterior probabilities of genotypes, from sums of likelihoods of aligned bases
+// (see caller/like.go) and priors of genotypes (see caller/prior.go). Genotypes of novel alleles are
+// added in sorted order, and posterior probabilities are computed once when variant calls are written.
+// Results are the same for any number of processes, except at positions with more aligned reads than
+// the depth cap (the first reads reaching a position are used) and with the identical-read cache
+// (alignments are replayed from whichever duplicate was aligned first).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/namsyvo/IVC/caller"
+)
+
+//---------------------------------------------------------------------------------------------------
+// ComputePosteriors sets up priors of genotypes of all alleles of aligned reads and computes
+// posterior probabilities and likelihoods of genotypes at all variant locations. The contamination
+// fraction is estimated first if required, and likelihoods are adjusted for it if required; haploid
+// positions of sex chromosomes have no heterozygous genotypes.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ComputePosteriors() {
+	VC.SetupPloidy()
+	CONTAM_FRAC = 0
+	if PARA.Contam {
+		CONTAM_FRAC = VC.EstimateContamination()
+		SUMMARY.ContamFrac = CONTAM_FRAC
+		if !PARA.Contam_adjust {
+			CONTAM_FRAC = 0
+		}
+	}
+	SUMMARY.CandidateNum = 0
+	for rid := 0; rid < PARA.Proc_num; rid++ {
+		VC.Calls[rid].Sites.EachAligned(func(pos uint32, site *CallSite) {
+			SUMMARY.CandidateNum++
+			var_num := site.RNum.Map()
+			priors := VC.SetupGenotypes(rid, pos, var_num)
+			if IsHaploid(int(pos)) {
+				caller.HaploidPriors(priors)
+			}
+			like_stat := VC.ContamAdjustedLike(pos, &site.Like, var_num)
+			like := caller.Posteriors(priors, like_stat)
+			VC.Calls[rid].VarProb.Set(pos, priors)
+			VC.Calls[rid].VarLike.Set(pos, like)
+			if MultiSample() {
+				for s, sample_stat := range VC.Calls[rid].SampleLikeStat[pos] {
+					VC.Calls[rid].SampleLike[pos][s] = make(map[string]float64)
+					sample_stat = VC.ContamAdjustedLike(pos, sample_stat, VC.Calls[rid].SampleRNum[pos][s])
+					for gt, _ := range like {
+						VC.Calls[rid].SampleLike[pos][s][gt] = caller.GenotypeLike(sample_stat, gt)
+					}
+				}
+			}
+		})
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupGenotypes sets up priors and types of genotypes at a location from alleles of aligned reads
+// (keys "ref|alt" of var_num), which are considered in sorted order, and returns the priors. Priors at
+// locations without known variants are initialized from the first alleles.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SetupGenotypes(rid int, pos uint32, var_num map[string]int) map[string]float64 {
+	var_bases := make([]string, 0, len(var_num))
+	for b, _ := range var_num {
+		var_bases = append(var_bases, b)
+	}
+	sort.Strings(var_bases)
+	var_type := &VC.Calls[rid].Sites.Get(pos).Type
+	var_probs, _ := VC.Calls[rid].VarProb.Get(pos)
+	priors := var_probs.Map()
+	if len(priors) == 0 {
+		vbase := strings.Split(var_bases[0], "|")
+		priors = caller.NovelGenotypePriors(vbase[0], vbase[1], NovelRates{})
+		if len(vbase[0]) == len(vbase[1]) { //SUB
+			var_type.Set(vbase[0]+"|"+vbase[0], 0)
+			var_type.Set(vbase[0]+"|"+vbase[1], 0)
+			var_type.Set(vbase[1]+"|"+vbase[1], 0)
+		} else if len(vbase[0]) < len(vbase[1]) { //INS
+			var_type.Set(vbase[0]+"|"+vbase[0], 0)
+			var_type.Set(vbase[0]+"|"+vbase[1], 1)
+			var_type.Set(vbase[1]+"|"+vbase[1], 1)
+		} else { //DEL
+			var_type.Set(vbase[0]+"|"+vbase[0], 2)
+			var_type.Set(vbase[0]+"|"+vbase[1], 2)
+			var_type.Set(vbase[1]+"|"+vbase[1], 0)
+		}
+	}
+	for _, b := range var_bases {
+		vbase := strings.Split(b, "|")
+		hap_map := make(map[string]bool)
+		for gt, _ := range priors {
+			hap_arr := strings.Split(gt, "|")
+			hap_map[hap_arr[0]], hap_map[hap_arr[1]] = true, true
+		}
+		if hap_map[vbase[1]] {
+			continue
+		}
+		caller.AddNovelAllele(priors, vbase[1], false, NovelRate(vbase[0], vbase[1]))
+		if len(vbase[0]) != len(vbase[1]) {
+			t := 1
+			if len(vbase[0]) > len(vbase[1]) {
+				t = 2
+			}
+			for hap, _ := range hap_map {
+				var_type.Set(hap+"|"+vbase[1], t)
+			}
+			var_type.Set(vbase[1]+"|"+vbase[1], t)
+		}
+	}
+	return priors
+}
@@ -0,0 +1,31 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: prior.go
+// Rates of novel alleles of the variant caller, used by the genotype model (see caller/prior.go).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+//---------------------------------------------------------------------------------------------------
+// NovelRates gives rates of novel alleles of the run to the genotype model (see NovelRate).
+//---------------------------------------------------------------------------------------------------
+type NovelRates struct{}
+
+func (NovelRates) NovelRate(ref_base, read_base string) float64 {
+	return NovelRate(ref_base, read_base)
+}
+
+//---------------------------------------------------------------------------------------------------
+// NovelRate returns prior probability of a novel allele: novel SNP rate for substitutions (weighted by
+// factors of transitions and transversions for single bases, see tstv.go), novel indel rate for
+// insertions and deletions.
+//---------------------------------------------------------------------------------------------------
+func NovelRate(ref_base, read_base string) float64 {
+	if len(ref_base) == 1 && len(read_base) == 1 {
+		return NEW_SNP_RATE * SubFactor(ref_base[0], read_base[0])
+	}
+	if len(ref_base) == len(read_base) {
+		return NEW_SNP_RATE
+	}
+	return NEW_INDEL_RATE
+}
@@ -0,0 +1,47 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: qual.go
+// Quality-score binning. Base qualities of reads can be binned into a few levels when reads are
+// read (as Illumina's reduced quality representations), which reduces the number of distinct values
+// kept with variant evidence and makes lookups of quality-based probabilities more cache-friendly.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+const (
+	QUAL_MIN = 2  // qualities lower than this (e.g. of N bases) are not binned
+	QUAL_MAX = 41 // qualities higher than this are put into the highest bin
+)
+
+//---------------------------------------------------------------------------------------------------
+// Binned quality (Phred+33) of each quality character (identity if qualities are not binned).
+//---------------------------------------------------------------------------------------------------
+var QUAL_BIN [256]byte
+
+//---------------------------------------------------------------------------------------------------
+// SetupQualBins divides qualities from QUAL_MIN to QUAL_MAX into bin_num bins of (nearly) equal
+// width, each quality is represented by the middle quality of its bin (no binning if bin_num is 0).
+//---------------------------------------------------------------------------------------------------
+func SetupQualBins(bin_num int) {
+	for c := 0; c < 256; c++ {
+		QUAL_BIN[c] = byte(c)
+	}
+	if bin_num <= 0 {
+		return
+	}
+	for q := QUAL_MIN; q+33 < 256; q++ {
+		bin := (MinInt(q, QUAL_MAX) - QUAL_MIN) * bin_num / (QUAL_MAX - QUAL_MIN + 1)
+		bin_start := QUAL_MIN + (bin*(QUAL_MAX-QUAL_MIN+1)+bin_num-1)/bin_num
+		bin_end := QUAL_MIN + ((bin+1)*(QUAL_MAX-QUAL_MIN+1)+bin_num-1)/bin_num - 1
+		QUAL_BIN[q+33] = byte((bin_start+bin_end)/2 + 33)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// BinQuals replaces base qualities by their binned qualities.
+//---------------------------------------------------------------------------------------------------
+func BinQuals(qual []byte) {
+	for i, q := range qual {
+		qual[i] = QUAL_BIN[q]
+	}
+}
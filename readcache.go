@@ -0,0 +1,196 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: readcache.go
+// Identical-read cache. Duplicated reads (same bases and base qualities of both ends, qualities are
+// binned if binning is used) have identical alignments, so alignments of recently seen reads are kept
+// in an LRU cache keyed on their sequences, and identical reads are not realigned. An alignment is
+// kept as its start position, strand and edits (evidence other than reference matches), and evidence
+// of identical reads is rebuilt from it against the current candidate variants, so that reference
+// matches at candidates found after the first read was aligned are also evidence.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"container/list"
+	"strconv"
+	"sync"
+)
+
+//---------------------------------------------------------------------------------------------------
+// CachedAln represents the alignment of a read which is replayed for identical reads.
+//---------------------------------------------------------------------------------------------------
+type CachedAln struct {
+	key                    string     // key of the read in the cache
+	Edits1, Edits2         []*VarInfo // edits of alignments of the two ends (see AlnEdits)
+	CovStart1, CovStart2   int        // start positions of the two aligned ends
+	Strand1, Strand2       bool       // strands of the two aligned ends
+	CandNum                int        // number of candidate alignments (for mapping quality)
+	Dist1, Dist2, PairDist float64    // alignment distances
+	Hits                   int        // number of identical reads whose alignments are replayed
+	Ambiguous              bool       // the read has tied best alignments at different positions
+	AltAln                 string     // alternative alignments of the read (see AltAlnString)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadCache represents an LRU cache of alignments of reads.
+//---------------------------------------------------------------------------------------------------
+type ReadCache struct {
+	size    int                      // maximum number of cached reads
+	order   *list.List               // cached alignments, from the most to the least recently used
+	entries map[string]*list.Element // cached alignments indexed by keys
+	hit_num int                      // number of reads whose alignments are replayed
+	mut     sync.Mutex               // mutex lock for accessing the cache
+}
+
+// Cache of alignments of reads (nil: no cache)
+var READ_CACHE *ReadCache
+
+//---------------------------------------------------------------------------------------------------
+// NewReadCache creates an LRU cache of at most size reads.
+//---------------------------------------------------------------------------------------------------
+func NewReadCache(size int) *ReadCache {
+	return &ReadCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadCacheKey returns the key of a paired-end read: bases and binned qualities of both ends, and the
+// expected alignment of chunk pairs of long reads (identical chunks of different reads are aligned at
+// different places of their chains).
+//---------------------------------------------------------------------------------------------------
+func ReadCacheKey(read_info *ReadInfo) string {
+	key := make([]byte, 0, 2*(read_info.Len1+read_info.Len2)+3)
+	key = append(key, read_info.Read1...)
+	key = append(key, '|')
+	key = append(key, read_info.Read2...)
+	key = append(key, '|')
+	for _, q := range read_info.Qual1 {
+		key = append(key, QUAL_BIN[q])
+	}
+	key = append(key, '|')
+	for _, q := range read_info.Qual2 {
+		key = append(key, QUAL_BIN[q])
+	}
+	if read_info.Anchor != nil {
+		key = append(key, '|')
+		key = strconv.AppendInt(key, int64(read_info.Anchor.Pos), 10)
+		key = strconv.AppendBool(key, read_info.Anchor.Strand)
+	}
+	return string(key)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns the cached alignment of a read and counts the replay.
+//---------------------------------------------------------------------------------------------------
+func (C *ReadCache) Get(key string) (*CachedAln, bool) {
+	C.mut.Lock()
+	defer C.mut.Unlock()
+	e, ok := C.entries[key]
+	if !ok {
+		return nil, false
+	}
+	C.order.MoveToFront(e)
+	aln := e.Value.(*CachedAln)
+	aln.Hits++
+	C.hit_num++
+	return aln, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// Put adds the alignment of a read to the cache, the least recently used one is removed if the cache
+// is full.
+//---------------------------------------------------------------------------------------------------
+func (C *ReadCache) Put(key string, aln *CachedAln) {
+	C.mut.Lock()
+	defer C.mut.Unlock()
+	if _, ok := C.entries[key]; ok {
+		return
+	}
+	aln.key = key
+	C.entries[key] = C.order.PushFront(aln)
+	if C.order.Len() > C.size {
+		last := C.order.Back()
+		C.order.Remove(last)
+		delete(C.entries, last.Value.(*CachedAln).key)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// HitNum returns the number of reads whose alignments are replayed from the cache.
+//---------------------------------------------------------------------------------------------------
+func (C *ReadCache) HitNum() int {
+	C.mut.Lock()
+	defer C.mut.Unlock()
+	return C.hit_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlnEdits returns edits of the alignment of a read-end: its evidence other than reference matches at
+// positions without known variants. Reference matches are only evidence at candidate variants, which
+// change while reads are processed, so they are rebuilt when the alignment is replayed (see ReplayAln).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AlnEdits(vars []*VarInfo) []*VarInfo {
+	edits := make([]*VarInfo, 0, len(vars))
+	for _, v := range vars {
+		if v.Type == 0 && len(v.Bases) == 3 && v.Bases[0] == v.Bases[2] && VC.Seq[v.Pos] != '*' {
+			continue
+		}
+		edits = append(edits, v)
+	}
+	return CloneVars(edits)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReplayAln rebuilds evidence of a read-end (in the strand aligned to the reference) from its cached
+// alignment: edits are copied, and reference matches are evidence at positions which are candidate
+// variants now, as in extension of seeds. The alignment is walked from its start position as in
+// AddPileup, an edit at a position takes as many bases of the read and the reference as its alleles.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ReplayAln(read, qual []byte, start int, strand bool, edits []*VarInfo) []*VarInfo {
+	edit_pos := make(map[uint32][]*VarInfo, len(edits))
+	for _, v := range edits {
+		edit_pos[v.Pos] = append(edit_pos[v.Pos], v)
+	}
+	vars := make([]*VarInfo, 0, len(edits))
+	for i, pos := 0, start; i < len(read) && pos < VC.SeqLen; {
+		if pos < 0 {
+			i, pos = i+1, pos+1
+			continue
+		}
+		if pos_edits, ok := edit_pos[uint32(pos)]; ok {
+			read_step, ref_step := 1, 1
+			for _, v := range CloneVars(pos_edits) {
+				vars = append(vars, v)
+				if alleles := bytes.SplitN(v.Bases, []byte{'|'}, 2); len(alleles) == 2 && len(alleles[0]) != len(alleles[1]) {
+					read_step, ref_step = MaxInt(len(alleles[1]), 1), MaxInt(len(alleles[0]), 1)
+				}
+			}
+			i, pos = i+read_step, pos+ref_step
+			continue
+		}
+		if ref_base := VC.Seq[pos]; ref_base != '*' && ref_base == read[i] {
+			if VC.HasSite(pos) {
+				var_info := new(VarInfo)
+				var_info.Pos, var_info.Bases, var_info.BQual, var_info.Type = uint32(pos), []byte{ref_base, '|', read[i]}, []byte{qual[i]}, 0
+				var_info.RPos, var_info.Cycle = ReadEndDist(i, len(read)), ReadCycle(i, len(read), !strand)
+				vars = append(vars, var_info)
+			}
+		}
+		i, pos = i+1, pos+1
+	}
+	return vars
+}
+
+//---------------------------------------------------------------------------------------------------
+// CloneVars returns copies of variant evidence, so that cached edits are not changed by processing
+// of replayed evidence.
+//---------------------------------------------------------------------------------------------------
+func CloneVars(vars []*VarInfo) []*VarInfo {
+	clone := make([]*VarInfo, len(vars))
+	for i, v := range vars {
+		c := *v
+		clone[i] = &c
+	}
+	return clone
+}
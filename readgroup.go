@@ -0,0 +1,214 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: readgroup.go
+// Read groups and samples. Each input FASTQ pair (comma-separated lists of files given to -1 and -2)
+// can have a read group "ID:id,SM:sample,LB:library,PL:platform". Read groups are carried through
+// reads and their variant evidence; read groups are written to the output header, and if reads come
+// from more than one sample, each sample has its own column with genotypes from its own reads.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// ReadGroup represents metadata of reads from an input FASTQ pair.
+//---------------------------------------------------------------------------------------------------
+type ReadGroup struct {
+	ID string // read group identifier
+	SM string // sample name
+	LB string // library
+	PL string // sequencing platform
+}
+
+var (
+	READ_GROUPS []*ReadGroup // read groups of input FASTQ pairs (in the order of input files)
+	SAMPLES     []string     // distinct sample names of read groups (in the order of appearance)
+	RG_SAMPLE   []int        // index of the sample of each read group
+)
+
+//---------------------------------------------------------------------------------------------------
+// ReadFiles returns file names from a comma-separated list.
+//---------------------------------------------------------------------------------------------------
+func ReadFiles(file_list string) []string {
+	if file_list == "" {
+		return nil
+	}
+	return strings.Split(file_list, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// AbsFiles returns absolute paths of files in a comma-separated list.
+//---------------------------------------------------------------------------------------------------
+func AbsFiles(file_list string) string {
+	files := ReadFiles(file_list)
+	for i, file_name := range files {
+		files[i], _ = filepath.Abs(file_name)
+	}
+	return strings.Join(files, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// ParseReadGroup parses a read group "ID:id,SM:sample,LB:library,PL:platform" (ID is required,
+// SM is the same as ID if not given).
+//---------------------------------------------------------------------------------------------------
+func ParseReadGroup(rg_str string) *ReadGroup {
+	rg := new(ReadGroup)
+	for _, field := range strings.Split(rg_str, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), ":", 2)
+		if len(kv) != 2 || kv[1] == "" || strings.ContainsAny(kv[1], "\t\n,<>") {
+			log.Panicf("Error: invalid read group %s (format: ID:id,SM:sample,LB:library,PL:platform)", rg_str)
+		}
+		switch kv[0] {
+		case "ID":
+			rg.ID = kv[1]
+		case "SM":
+			rg.SM = kv[1]
+		case "LB":
+			rg.LB = kv[1]
+		case "PL":
+			rg.PL = kv[1]
+		default:
+			log.Panicf("Error: unknown field %s in read group %s (supported fields: ID, SM, LB, PL)", kv[0], rg_str)
+		}
+	}
+	if rg.ID == "" {
+		log.Panicf("Error: read group %s has no ID", rg_str)
+	}
+	if rg.SM == "" {
+		rg.SM = rg.ID
+	}
+	return rg
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupReadGroups sets up read groups of input FASTQ pairs. If no read groups are given, all reads
+// belong to one read group; otherwise each FASTQ pair must have a read group with a distinct ID.
+//---------------------------------------------------------------------------------------------------
+func SetupReadGroups(rg_strs []string, pair_num int) {
+	READ_GROUPS, SAMPLES, RG_SAMPLE = nil, nil, nil
+	if len(rg_strs) == 0 {
+		READ_GROUPS = []*ReadGroup{&ReadGroup{ID: "1"}}
+		RG_SAMPLE = []int{0}
+		return
+	}
+	if len(rg_strs) != pair_num {
+		log.Panicf("Error: %d read groups are given for %d input FASTQ pairs", len(rg_strs), pair_num)
+	}
+	sample_idx := make(map[string]int)
+	rg_ids := make(map[string]bool)
+	for _, rg_str := range rg_strs {
+		rg := ParseReadGroup(rg_str)
+		if rg_ids[rg.ID] {
+			log.Panicf("Error: duplicate read group ID %s", rg.ID)
+		}
+		rg_ids[rg.ID] = true
+		if _, ok := sample_idx[rg.SM]; !ok {
+			sample_idx[rg.SM] = len(SAMPLES)
+			SAMPLES = append(SAMPLES, rg.SM)
+		}
+		READ_GROUPS = append(READ_GROUPS, rg)
+		RG_SAMPLE = append(RG_SAMPLE, sample_idx[rg.SM])
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiSample determines whether reads come from more than one sample.
+//---------------------------------------------------------------------------------------------------
+func MultiSample() bool {
+	return len(SAMPLES) > 1
+}
+
+//---------------------------------------------------------------------------------------------------
+// SampleIndex returns index of the sample of a read group.
+//---------------------------------------------------------------------------------------------------
+func SampleIndex(rg int) int {
+	if rg < 0 || rg >= len(RG_SAMPLE) {
+		return 0
+	}
+	return RG_SAMPLE[rg]
+}
+
+//---------------------------------------------------------------------------------------------------
+// RGID returns ID of a read group ("." if read groups are not set up).
+//---------------------------------------------------------------------------------------------------
+func RGID(rg int) string {
+	if rg < 0 || rg >= len(READ_GROUPS) {
+		return "."
+	}
+	return READ_GROUPS[rg].ID
+}
+
+//---------------------------------------------------------------------------------------------------
+// SampleColumns returns names of sample columns of the output header: sample names of read groups
+// if they are given, the default sample name otherwise.
+//---------------------------------------------------------------------------------------------------
+func SampleColumns(default_sample string) string {
+	if len(SAMPLES) == 0 {
+		return default_sample
+	}
+	return strings.Join(SAMPLES, "\t")
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteReadGroupHeader writes read groups given by users as meta-information lines.
+//---------------------------------------------------------------------------------------------------
+func WriteReadGroupHeader(w *bufio.Writer) {
+	if len(SAMPLES) == 0 {
+		return
+	}
+	for _, rg := range READ_GROUPS {
+		w.WriteString("##IVCReadGroup=<ID=" + rg.ID + ",SM=" + rg.SM + ",LB=" + rg.LB + ",PL=" + rg.PL + ">\n")
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// SampleFormat returns FORMAT values (GT:GQ:AD:DP:ADF:ADR:SB, and PL if with_pl is true) of a sample at a variant
+// call. The genotype of the sample is the most likely one of REF/REF, REF/ALT and ALT/ALT (REF and
+// ALT at haploid positions) given the sample's own reads; GQ is the Phred-scaled likelihood ratio of the second most likely genotype.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SampleFormat(rid int, pos uint32, s int, hap_arr []string, with_pl bool) string {
+	read_depth, var_depth := 0, 0
+	for var_base, var_num := range VC.Calls[rid].SampleRNum[pos][s] {
+		read_depth += var_num
+		var_arr := strings.Split(var_base, "|")
+		if (len(var_arr[0]) > len(var_arr[1]) && var_arr[0] == hap_arr[1]) || (len(var_arr[0]) <= len(var_arr[1]) && var_arr[1] == hap_arr[1]) {
+			var_depth += var_num
+		}
+	}
+	str_strand := StrandFormat(StrandCounts(VC.Calls[rid].SampleRNum[pos][s], VC.Calls[rid].SampleRev[pos][s], hap_arr[1]))
+	likes, ok := GenotypeLikes(VC.Calls[rid].SampleLike[pos][s], hap_arr)
+	if read_depth == 0 || !ok {
+		str_format := "./.:.:" + strconv.Itoa(var_depth) + ":" + strconv.Itoa(read_depth) + ":" + str_strand
+		if with_pl {
+			str_format += ":."
+		}
+		return str_format
+	}
+	gts := []string{"0/0", "0/1", "1/1"}
+	if IsHaploid(int(pos)) {
+		likes, gts = HaploidLikes(likes), []string{"0", "1"}
+	}
+	best, second := 0, -1
+	for i := 1; i < len(likes); i++ {
+		if likes[i] > likes[best] {
+			best, second = i, best
+		} else if second < 0 || likes[i] > likes[second] {
+			second = i
+		}
+	}
+	str_format := gts[best] + ":"
+	str_format += strconv.Itoa(int(math.Min(math.Floor(10*(likes[best]-likes[second])+0.5), 99))) + ":"
+	str_format += strconv.Itoa(var_depth) + ":" + strconv.Itoa(read_depth) + ":" + str_strand
+	if with_pl {
+		str_format += ":" + PLString(likes)
+	}
+	return str_format
+}
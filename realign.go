@@ -0,0 +1,353 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: realign.go
+// Local indel realignment. Variants found from aligned reads are buffered instead of being used to
+// update variant probabilities right away (see realnbuf.go). After all reads are aligned, buffered
+// read-ends are processed by genomic windows: indels supported by several reads are taken as
+// candidates, and reads which overlap a candidate indel but have mismatches around it are re-aligned
+// against the reference haplotype containing the indel. If the haplotype explains the read better,
+// mismatches around the indel are replaced by the indel. This reduces spurious SNPs adjacent to true
+// indels. Buffered variants are then used to update variant probabilities.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+const (
+	REALN_MIN_READS = 2  // minimum number of reads supporting an indel to be a candidate for realignment
+	REALN_WINDOW    = 20 // mismatches within this distance from a candidate indel are replaced if the read is re-aligned
+	REALN_ANCHOR    = 5  // minimum number of read bases on each side of a candidate indel
+)
+
+//---------------------------------------------------------------------------------------------------
+// RealnRead represents an aligned read-end and its variants, buffered for realignment.
+//---------------------------------------------------------------------------------------------------
+type RealnRead struct {
+	Read  []byte     // read sequence (in the strand aligned to the reference)
+	Qual  []byte     // quality sequence (in the strand aligned to the reference)
+	Start int        // position on the reference aligned to the first base of the read
+	MProb float64    // probability of mapping the read correctly (mapping quality)
+	Rev   bool       // the read is aligned to the reverse strand of the reference
+	Seed  int64      // seed of the read (see ReadSeed)
+	Vars  []*VarInfo // variants found from the alignment
+	Asm   bool       // variants have been replaced by those from local assembly (not re-aligned)
+}
+
+//---------------------------------------------------------------------------------------------------
+// RealnCand represents a candidate indel for realignment.
+//---------------------------------------------------------------------------------------------------
+type RealnCand struct {
+	Pos     int    // position of the indel (on the reference)
+	Ref     string // reference allele
+	Alt     string // alternative allele
+	Type    int    // type of the indel (1: ins, 2: del)
+	ReadNum int    // number of reads supporting the indel
+}
+
+//---------------------------------------------------------------------------------------------------
+// BufferRealnRead buffers an aligned read-end with its variants for realignment. Read-ends without
+// variants are only buffered for local assembly (they support reference haplotypes).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) BufferRealnRead(read, qual []byte, start int, map_qual float64, strand bool, seed int64, vars []*VarInfo) {
+	if len(vars) == 0 && !PARA.Assemble {
+		return
+	}
+	r := new(RealnRead)
+	r.Read, r.Qual = make([]byte, len(read)), make([]byte, len(qual))
+	copy(r.Read, read)
+	copy(r.Qual, qual)
+	DetachVars(vars)
+	r.Start, r.MProb, r.Rev, r.Seed, r.Vars = start, map_qual, !strand, seed, vars
+	REALN_BUF.Add(r)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ProcessBufferedReads assembles dense variant regions and re-aligns buffered read-ends around
+// candidate indels (as required), then updates variant probabilities with their variants. Windows of
+// read-ends are loaded in sorted order, and only the four windows around the one being processed are
+// kept in memory. After window f is loaded, dense regions starting in window f-1 are assembled from
+// read-ends of windows f-2 to f; read-ends of window f-2 are then final (no later regions overlap
+// them), and indels of them are counted. Indels at positions of window f-2 are only supported by
+// read-ends of windows f-3 and f-2, so candidate indels of window f-2 are complete; read-ends of window
+// f-3, which overlap candidate windows f-3 and f-2, are re-aligned and their variants are used.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ProcessBufferedReads() {
+	if PARA.Assemble {
+		log.Printf("Assembling dense variant regions...")
+	}
+	if PARA.Realign {
+		log.Printf("Realigning reads around candidate indels...")
+	}
+	region_num, asm_num, cand_num, realn_num := 0, 0, 0, 0
+	held := make(map[int][]*RealnRead)
+	support, cands := make(RealnSupport), make(map[int][]*RealnCand)
+	first, last, ok := REALN_BUF.Range()
+	for f := first; ok && f <= last+3; f++ {
+		if f <= last {
+			held[f] = REALN_BUF.Window(f)
+		}
+		if PARA.Assemble {
+			r_num, a_num := VC.AssembleRegions(WindowReads(held, f-2, f), f-1)
+			region_num, asm_num = region_num+r_num, asm_num+a_num
+		}
+		if PARA.Realign {
+			support.Count(held[f-2])
+			cands[f-2] = support.Candidates(f - 2)
+			cand_num += len(cands[f-2])
+			realn_num += VC.RealignReads(held[f-3], append(cands[f-3], cands[f-2]...))
+			delete(cands, f-3)
+		}
+		VC.CollectReads(held[f-3])
+		delete(held, f-3)
+	}
+	REALN_BUF.Close()
+	if PARA.Assemble {
+		log.Printf("Number of dense regions:\t%d, number of assembled regions:\t%d", region_num, asm_num)
+	}
+	if PARA.Realign {
+		log.Printf("Number of candidate indels:\t%d, number of realigned read-ends:\t%d", cand_num, realn_num)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// WindowReads returns read-ends of windows first to last.
+//---------------------------------------------------------------------------------------------------
+func WindowReads(held map[int][]*RealnRead, first, last int) []*RealnRead {
+	reads := make([]*RealnRead, 0)
+	for w := first; w <= last; w++ {
+		reads = append(reads, held[w]...)
+	}
+	return reads
+}
+
+//---------------------------------------------------------------------------------------------------
+// CollectReads updates variant probabilities with variants of processed read-ends (split among
+// PARA.Proc_num goroutines).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CollectReads(reads []*RealnRead) {
+	if len(reads) == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	chunk := (len(reads) + PARA.Proc_num - 1) / PARA.Proc_num
+	for start := 0; start < len(reads); start += chunk {
+		wg.Add(1)
+		go func(reads []*RealnRead) {
+			defer wg.Done()
+			baq := new(BAQBuf)
+			for _, r := range reads {
+				if PARA.BAQ && !r.Asm {
+					VC.ApplyBAQ(baq, r.Read, r.Qual, r.Start, r.Vars)
+				}
+				for _, v := range r.Vars {
+					VC.CollectVariant(v)
+				}
+			}
+		}(reads[start:MinInt(start+chunk, len(reads))])
+	}
+	wg.Wait()
+}
+
+//---------------------------------------------------------------------------------------------------
+// RealignReads re-aligns read-ends around candidate indels (sorted by positions), and returns the
+// number of realigned read-ends.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) RealignReads(reads []*RealnRead, cands []*RealnCand) int {
+	cand_pos := make([]int, len(cands))
+	for i, cand := range cands {
+		cand_pos[i] = cand.Pos
+	}
+	realn_num := 0
+	for _, r := range reads {
+		if r.Asm {
+			continue
+		}
+		// Candidates overlapped by the read
+		first := sort.SearchInts(cand_pos, r.Start+REALN_ANCHOR)
+		for k := first; k < len(cands) && cands[k].Pos < r.Start+len(r.Read)-REALN_ANCHOR; k++ {
+			if vars, ok := VC.RealignRead(r, cands[k]); ok {
+				r.Vars = vars
+				realn_num++
+				break
+			}
+		}
+	}
+	return realn_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// RealnSupport represents numbers of read-ends supporting indels, at positions of candidate windows
+// which are not complete yet.
+//---------------------------------------------------------------------------------------------------
+type RealnSupport map[uint32]map[string]*RealnCand
+
+//---------------------------------------------------------------------------------------------------
+// Count counts indels of read-ends.
+//---------------------------------------------------------------------------------------------------
+func (S RealnSupport) Count(reads []*RealnRead) {
+	for _, r := range reads {
+		for _, v := range r.Vars {
+			if v.Type != 1 && v.Type != 2 {
+				continue
+			}
+			if _, ok := S[v.Pos]; !ok {
+				S[v.Pos] = make(map[string]*RealnCand)
+			}
+			bases := string(v.Bases)
+			if _, ok := S[v.Pos][bases]; !ok {
+				var_arr := strings.Split(bases, "|")
+				S[v.Pos][bases] = &RealnCand{Pos: int(v.Pos), Ref: var_arr[0], Alt: var_arr[1], Type: v.Type}
+			}
+			S[v.Pos][bases].ReadNum++
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Candidates returns indels supported by at least REALN_MIN_READS read-ends at positions of windows up
+// to a complete window, sorted by positions, and removes their counts.
+//---------------------------------------------------------------------------------------------------
+func (S RealnSupport) Candidates(win int) []*RealnCand {
+	cands := make([]*RealnCand, 0)
+	for pos, pos_cands := range S {
+		if int(pos)/REALN_WIN_LEN > win {
+			continue
+		}
+		delete(S, pos)
+		// Only the most supported indel at each position is considered
+		var best *RealnCand
+		for _, cand := range pos_cands {
+			if best == nil || best.ReadNum < cand.ReadNum {
+				best = cand
+			}
+		}
+		if best.ReadNum >= REALN_MIN_READS {
+			cands = append(cands, best)
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].Pos < cands[j].Pos })
+	return cands
+}
+
+//---------------------------------------------------------------------------------------------------
+// RealignRead re-aligns a read-end against the reference haplotype containing a candidate indel.
+// Reads which already have indels around the candidate or no mismatches around it are not re-aligned.
+// It returns new variants of the read and true if the haplotype has fewer mismatches with the read.
+// Bases on the side of the indel away from the anchor of the read are shifted by the indel, so their
+// variants are replaced by mismatches with the haplotype.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) RealignRead(r *RealnRead, cand *RealnCand) ([]*VarInfo, bool) {
+	mis_num := 0
+	for _, v := range r.Vars {
+		if int(v.Pos) >= cand.Pos-REALN_WINDOW && int(v.Pos) <= cand.Pos+REALN_WINDOW {
+			if v.Type != 0 {
+				return nil, false
+			}
+			mis_num++
+		}
+	}
+	if mis_num == 0 {
+		return nil, false
+	}
+	shift := len(cand.Alt) - len(cand.Ref)
+	// The read is anchored at its start (left-anchored) or its end (right-anchored) on the reference
+	ref_mis := VC.HapMismatches(r, r.Start, nil)
+	best_start, best_mis := 0, -1
+	for _, hap_start := range []int{r.Start, r.Start + shift} {
+		hap_mis := VC.HapMismatches(r, hap_start, cand)
+		if hap_mis != nil && (best_mis < 0 || len(hap_mis) < best_mis) {
+			best_start, best_mis = hap_start, len(hap_mis)
+		}
+	}
+	if ref_mis == nil || best_mis < 0 || best_mis >= len(ref_mis) {
+		return nil, false
+	}
+	// Keep variants far from the candidate on the anchored side, add the indel and mismatches with the
+	// haplotype around it and on the other side
+	left_anchored := best_start == r.Start
+	vars := make([]*VarInfo, 0, len(r.Vars))
+	var template *VarInfo
+	for _, v := range r.Vars {
+		template = v
+		if left_anchored && int(v.Pos) < cand.Pos-REALN_WINDOW || !left_anchored && int(v.Pos) > cand.Pos+REALN_WINDOW {
+			vars = append(vars, v)
+		}
+	}
+	read_pos := cand.Pos - best_start // index of the read base aligned to the indel position
+	if read_pos < 0 || read_pos+len(cand.Alt) > len(r.Read) {
+		return nil, false
+	}
+	indel := *template
+	indel.Pos, indel.Bases, indel.Type = uint32(cand.Pos), []byte(cand.Ref+"|"+cand.Alt), cand.Type
+	indel.BQual = r.Qual[read_pos : read_pos+len(cand.Alt)]
+	if cand.Type == 2 && read_pos+1 < len(r.Qual) {
+		indel.BQual = []byte{align.DelQual(r.Qual[read_pos], r.Qual[read_pos+1])}
+	}
+	indel.RPos, indel.Cycle = ReadEndDist(read_pos, len(r.Read)), ReadCycle(read_pos, len(r.Read), r.Rev)
+	vars = append(vars, &indel)
+	for _, i := range VC.HapMismatches(r, best_start, cand) {
+		ref_pos := VC.HapRefPos(best_start, i, cand)
+		if ref_pos < 0 {
+			continue
+		}
+		if left_anchored && ref_pos >= cand.Pos-REALN_WINDOW || !left_anchored && ref_pos <= cand.Pos+REALN_WINDOW {
+			mis := *template
+			mis.Pos, mis.Bases, mis.Type = uint32(ref_pos), []byte(string(VC.Seq[ref_pos])+"|"+string(r.Read[i])), 0
+			mis.BQual = r.Qual[i : i+1]
+			mis.RPos, mis.Cycle = ReadEndDist(i, len(r.Read)), ReadCycle(i, len(r.Read), r.Rev)
+			vars = append(vars, &mis)
+		}
+	}
+	return vars, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// HapRefPos returns position on the reference of the base of a haplotype (the reference with a
+// candidate indel, or the reference itself if cand is nil) aligned to index i of a read which
+// starts at hap_start, or -1 if the base is inside the alternative allele.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HapRefPos(hap_start, i int, cand *RealnCand) int {
+	pos := hap_start + i
+	if cand == nil || pos <= cand.Pos {
+		return pos
+	}
+	if pos < cand.Pos+len(cand.Alt) {
+		return -1
+	}
+	return pos - len(cand.Alt) + len(cand.Ref)
+}
+
+//---------------------------------------------------------------------------------------------------
+// HapMismatches returns indexes of read bases which do not match an ungapped alignment of the read
+// against a haplotype (see HapRefPos). Positions of known variants ('*' on the multigenome) are not
+// counted as mismatches. It returns nil if the read is not inside the reference.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HapMismatches(r *RealnRead, hap_start int, cand *RealnCand) []int {
+	mis := make([]int, 0)
+	var hap_base byte
+	if hap_start < 0 {
+		return nil
+	}
+	for i := 0; i < len(r.Read); i++ {
+		ref_pos := VC.HapRefPos(hap_start, i, cand)
+		if ref_pos == -1 {
+			hap_base = cand.Alt[hap_start+i-cand.Pos]
+		} else if ref_pos < 0 || ref_pos >= VC.SeqLen {
+			return nil
+		} else {
+			hap_base = VC.Seq[ref_pos]
+		}
+		if hap_base != '*' && hap_base != r.Read[i] {
+			mis = append(mis, i)
+		}
+	}
+	return mis
+}
@@ -0,0 +1,146 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: realnbuf.go
+// Buffer of aligned read-ends for realignment and local assembly. Read-ends are kept by genomic windows
+// of REALN_WIN_LEN bases (windows of their start positions); when REALN_MEM_READS read-ends are in
+// memory, windows are spilled to a file in the temporary directory, so memory does not grow with the
+// number of reads. After alignment, windows are loaded back in sorted order and processed as soon as
+// the windows around them are loaded (see ProcessBufferedReads), so only a few windows are in memory
+// at a time.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	REALN_WIN_LEN   = 1 << 20 // length of genomic windows of buffered read-ends (longer read-ends are processed within the next window only)
+	REALN_MEM_READS = 1 << 20 // number of buffered read-ends in memory, at which windows are spilled to a temporary file
+)
+
+//---------------------------------------------------------------------------------------------------
+// RealnSpill represents a temporary file of spilled windows of read-ends.
+//---------------------------------------------------------------------------------------------------
+type RealnSpill struct {
+	File *os.File         // temporary file
+	Segs map[int][2]int64 // offsets and lengths of gob-encoded read-ends of windows in the file
+}
+
+//---------------------------------------------------------------------------------------------------
+// RealnBuffer represents buffered read-ends by genomic windows, in memory and in spilled files.
+//---------------------------------------------------------------------------------------------------
+type RealnBuffer struct {
+	Wins   map[int][]*RealnRead // read-ends in memory by windows
+	Num    int                  // number of read-ends in memory
+	Spills []*RealnSpill        // spilled files, in the order of spilling
+	mut    sync.Mutex           // mutex lock for buffering read-ends
+}
+
+// Buffer of read-ends for realignment and local assembly
+var REALN_BUF = NewRealnBuffer()
+
+//---------------------------------------------------------------------------------------------------
+// NewRealnBuffer returns an empty buffer of read-ends.
+//---------------------------------------------------------------------------------------------------
+func NewRealnBuffer() *RealnBuffer {
+	return &RealnBuffer{Wins: make(map[int][]*RealnRead)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds a read-end to its window, and spills windows in memory if there are too many read-ends.
+//---------------------------------------------------------------------------------------------------
+func (B *RealnBuffer) Add(r *RealnRead) {
+	w := MaxInt(r.Start, 0) / REALN_WIN_LEN
+	B.mut.Lock()
+	defer B.mut.Unlock()
+	B.Wins[w] = append(B.Wins[w], r)
+	B.Num++
+	if B.Num >= REALN_MEM_READS {
+		B.Spill()
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Spill writes windows in memory to a temporary file and removes them from memory.
+//---------------------------------------------------------------------------------------------------
+func (B *RealnBuffer) Spill() {
+	S := &RealnSpill{File: CreateTmpFile("realn-"), Segs: make(map[int][2]int64)}
+	var offset int64
+	var buf bytes.Buffer
+	for w, reads := range B.Wins {
+		buf.Reset()
+		if e := gob.NewEncoder(&buf).Encode(reads); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		if _, e := S.File.Write(buf.Bytes()); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		S.Segs[w] = [2]int64{offset, int64(buf.Len())}
+		offset += int64(buf.Len())
+	}
+	B.Spills = append(B.Spills, S)
+	B.Wins, B.Num = make(map[int][]*RealnRead), 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// Range returns the first and last windows with buffered read-ends, and false if there are none.
+//---------------------------------------------------------------------------------------------------
+func (B *RealnBuffer) Range() (int, int, bool) {
+	first, last, ok := 0, 0, false
+	add := func(w int) {
+		if !ok || w < first {
+			first = w
+		}
+		if !ok || w > last {
+			last = w
+		}
+		ok = true
+	}
+	for w, _ := range B.Wins {
+		add(w)
+	}
+	for _, S := range B.Spills {
+		for w, _ := range S.Segs {
+			add(w)
+		}
+	}
+	return first, last, ok
+}
+
+//---------------------------------------------------------------------------------------------------
+// Window returns read-ends of a window (spilled ones first) and removes them from memory.
+//---------------------------------------------------------------------------------------------------
+func (B *RealnBuffer) Window(w int) []*RealnRead {
+	reads := make([]*RealnRead, 0)
+	for _, S := range B.Spills {
+		if seg, ok := S.Segs[w]; ok {
+			var seg_reads []*RealnRead
+			if e := gob.NewDecoder(io.NewSectionReader(S.File, seg[0], seg[1])).Decode(&seg_reads); e != nil {
+				log.Panicf("Error: %s", e)
+			}
+			reads = append(reads, seg_reads...)
+		}
+	}
+	reads = append(reads, B.Wins[w]...)
+	B.Num -= len(B.Wins[w])
+	delete(B.Wins, w)
+	return reads
+}
+
+//---------------------------------------------------------------------------------------------------
+// Close removes spilled files and empties the buffer.
+//---------------------------------------------------------------------------------------------------
+func (B *RealnBuffer) Close() {
+	for _, S := range B.Spills {
+		S.File.Close()
+		os.Remove(S.File.Name())
+	}
+	B.Wins, B.Num, B.Spills = make(map[int][]*RealnRead), 0, nil
+}
@@ -0,0 +1,265 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: recal.go
+// Variant score recalibration (a lightweight equivalent of VQSR). After calling, a logistic regression
+// model is trained on annotations of variant calls (QD, MQ, FS, DP, ReadPosRankSum, BaseQRankSum),
+// with calls at known sites (KV calls of the variant profile, or sites of a known-variant VCF) as
+// positives and other calls as negatives. QUAL is rewritten as the Phred-scaled probability of the
+// model, and calls scoring below the threshold which keeps a given sensitivity on known sites are
+// filtered.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RECAL_FILTER    = "RecalLow" // filter name of calls scoring below the sensitivity threshold
+	RECAL_ITER_NUM  = 1000       // number of gradient descent iterations
+	RECAL_LEARN     = 0.5        // learning rate of gradient descent
+	RECAL_L2        = 1e-3       // L2 regularization of weights
+	RECAL_MAX_QUAL  = 1000       // maximum recalibrated QUAL
+	RECAL_MIN_CALLS = 10         // minimum number of positive and negative calls to train the model
+)
+
+// Annotations of variant calls used by the model (annotations which are not available are ignored)
+var RECAL_KEYS = []string{"QD", "MQ", "FS", "DP", "ReadPosRankSum", "BaseQRankSum"}
+
+//---------------------------------------------------------------------------------------------------
+// RecalModel represents a logistic regression model on standardized annotations.
+//---------------------------------------------------------------------------------------------------
+type RecalModel struct {
+	Keys    []string  // annotations used by the model
+	Mean    []float64 // means of annotations (missing values are replaced by means)
+	Std     []float64 // standard deviations of annotations
+	Weights []float64 // weights of standardized annotations
+	Bias    float64   // intercept
+}
+
+//---------------------------------------------------------------------------------------------------
+// Features returns standardized annotations of a call.
+//---------------------------------------------------------------------------------------------------
+func (M *RecalModel) Features(values map[string]float64) []float64 {
+	x := make([]float64, len(M.Keys))
+	for j, key := range M.Keys {
+		if value, ok := values[key]; ok && !math.IsNaN(value) && !math.IsInf(value, 0) {
+			x[j] = (value - M.Mean[j]) / M.Std[j]
+		}
+	}
+	return x
+}
+
+//---------------------------------------------------------------------------------------------------
+// Score returns the log-odds of a call being true given its standardized annotations.
+//---------------------------------------------------------------------------------------------------
+func (M *RecalModel) Score(x []float64) float64 {
+	s := M.Bias
+	for j, w := range M.Weights {
+		s += w * x[j]
+	}
+	return s
+}
+
+//---------------------------------------------------------------------------------------------------
+// TrainRecalModel trains the model on annotations of calls with labels (true: known sites), with
+// positive and negative calls weighted equally.
+//---------------------------------------------------------------------------------------------------
+func TrainRecalModel(values []map[string]float64, labels []bool) *RecalModel {
+	M := new(RecalModel)
+	for _, key := range RECAL_KEYS {
+		sum, sq_sum, n := 0.0, 0.0, 0
+		for _, v := range values {
+			if value, ok := v[key]; ok && !math.IsNaN(value) && !math.IsInf(value, 0) {
+				sum, sq_sum, n = sum+value, sq_sum+value*value, n+1
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		mean := sum / float64(n)
+		std := math.Sqrt(math.Max(sq_sum/float64(n)-mean*mean, 0))
+		if std == 0 {
+			continue
+		}
+		M.Keys, M.Mean, M.Std = append(M.Keys, key), append(M.Mean, mean), append(M.Std, std)
+	}
+	pos_num := 0
+	for _, label := range labels {
+		if label {
+			pos_num++
+		}
+	}
+	neg_num := len(labels) - pos_num
+	if pos_num < RECAL_MIN_CALLS || neg_num < RECAL_MIN_CALLS {
+		log.Panicf("Error: too few calls to train the recalibration model (%d at known sites, %d at other sites; at least %d of each are required)",
+			pos_num, neg_num, RECAL_MIN_CALLS)
+	}
+	if len(M.Keys) == 0 {
+		log.Panicf("Error: no annotations of variant calls are available for recalibration (%s)", strings.Join(RECAL_KEYS, ", "))
+	}
+	xs := make([][]float64, len(values))
+	for i, v := range values {
+		xs[i] = M.Features(v)
+	}
+	pos_weight, neg_weight := 0.5/float64(pos_num), 0.5/float64(neg_num)
+	M.Weights = make([]float64, len(M.Keys))
+	grad := make([]float64, len(M.Keys))
+	for iter := 0; iter < RECAL_ITER_NUM; iter++ {
+		for j := range grad {
+			grad[j] = RECAL_L2 * M.Weights[j]
+		}
+		grad_bias := 0.0
+		for i, x := range xs {
+			p := 1 / (1 + math.Exp(-M.Score(x)))
+			w, y := neg_weight, 0.0
+			if labels[i] {
+				w, y = pos_weight, 1.0
+			}
+			for j := range grad {
+				grad[j] += w * (p - y) * x[j]
+			}
+			grad_bias += w * (p - y)
+		}
+		for j := range grad {
+			M.Weights[j] -= RECAL_LEARN * grad[j]
+		}
+		M.Bias -= RECAL_LEARN * grad_bias
+	}
+	return M
+}
+
+//---------------------------------------------------------------------------------------------------
+// Recalibrate reads variant calls from call_file, trains the model with known sites (KV calls and
+// sites of known_file if given), and writes recalibrated calls to out_file. Calls scoring below the
+// score of the (1-sens) quantile of known sites are filtered.
+//---------------------------------------------------------------------------------------------------
+func Recalibrate(call_file, known_file, out_file string, sens float64) {
+	if sens <= 0 || sens > 1 {
+		log.Panicf("Error: invalid sensitivity %g (must be in (0, 1])", sens)
+	}
+	var known map[string]string
+	if known_file != "" {
+		known = LoadEvalVariants(known_file, nil, false)
+	}
+	scanner, done := OpenTextInput(call_file)
+	var header []string
+	var lines [][]string
+	var values []map[string]float64
+	var labels []bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			header = append(header, line)
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 8 {
+			log.Panicf("Error: invalid line in VCF file %s: %s", call_file, line)
+		}
+		lines = append(lines, tokens)
+		if tokens[4] == "." {
+			continue
+		}
+		qual, _ := strconv.ParseFloat(tokens[5], 64)
+		format_keys, format_values := "", ""
+		if len(tokens) > 9 {
+			format_keys, format_values = tokens[8], tokens[9]
+		}
+		v := FilterValues(qual, tokens[7], format_keys, format_values)
+		values = append(values, v)
+		labels = append(labels, v["KV"] == 1 || IsKnownCall(known, tokens))
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	done()
+	M := TrainRecalModel(values, labels)
+	log.Printf("Recalibration model (annotation: weight):\t%s", M.String())
+
+	// Threshold keeping the given sensitivity on known sites
+	scores := make([]float64, len(values))
+	pos_scores := make([]float64, 0)
+	for i, v := range values {
+		scores[i] = M.Score(M.Features(v))
+		if labels[i] {
+			pos_scores = append(pos_scores, scores[i])
+		}
+	}
+	sort.Float64s(pos_scores)
+	thres := pos_scores[int(math.Floor((1-sens)*float64(len(pos_scores)-1)))]
+
+	f, e := os.Create(out_file)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, h := range header {
+		if strings.HasPrefix(h, "#CHROM") {
+			w.WriteString("##INFO=<ID=RCS,Number=1,Type=Float,Description=\"Log-odds of the call being true from variant score recalibration\">\n")
+			w.WriteString("##FILTER=<ID=" + RECAL_FILTER + ",Description=\"Recalibrated score below the threshold of " +
+				strconv.FormatFloat(100*sens, 'g', -1, 64) + "% sensitivity on known sites (RCS<" + strconv.FormatFloat(thres, 'f', 4, 64) + ")\">\n")
+			w.WriteString("##IVCRecalibration=<Keys=" + strings.Join(M.Keys, ",") + ", Known_file=" + known_file +
+				", Sensitivity=" + strconv.FormatFloat(sens, 'g', -1, 64) + ">\n")
+		}
+		w.WriteString(h + "\n")
+	}
+	filtered_num, i := 0, 0
+	for _, tokens := range lines {
+		if tokens[4] != "." {
+			s := scores[i]
+			i++
+			p := 1 / (1 + math.Exp(-s))
+			tokens[5] = strconv.FormatFloat(math.Min(-10*math.Log10(math.Max(1-p, 1e-100)), RECAL_MAX_QUAL), 'f', 5, 64)
+			tokens[7] += ";RCS=" + strconv.FormatFloat(s, 'f', 4, 64)
+			if s < thres {
+				if tokens[6] == "PASS" || tokens[6] == "." {
+					tokens[6] = RECAL_FILTER
+				} else {
+					tokens[6] += ";" + RECAL_FILTER
+				}
+				filtered_num++
+			}
+		}
+		w.WriteString(strings.Join(tokens, "\t") + "\n")
+	}
+	w.Flush()
+	log.Printf("Number of recalibrated calls:\t%d, known sites:\t%d, filtered calls:\t%d", len(values), len(pos_scores), filtered_num)
+	log.Printf("Recalibrated variant calls are written to:\t%s", out_file)
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsKnownCall determines whether a call (VCF tokens) has an allele at known sites.
+//---------------------------------------------------------------------------------------------------
+func IsKnownCall(known map[string]string, tokens []string) bool {
+	pos, e := strconv.Atoi(tokens[1])
+	if known == nil || e != nil {
+		return false
+	}
+	for _, alt := range strings.Split(tokens[4], ",") {
+		v_pos, ref, alt := NormalizeAlleles(pos, strings.ToUpper(tokens[3]), strings.ToUpper(alt))
+		if _, ok := known[tokens[0]+":"+strconv.Itoa(v_pos)+":"+ref+":"+alt]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// String returns weights of annotations of the model.
+//---------------------------------------------------------------------------------------------------
+func (M *RecalModel) String() string {
+	strs := make([]string, len(M.Keys))
+	for j, key := range M.Keys {
+		strs[j] = key + ": " + strconv.FormatFloat(M.Weights[j], 'f', 4, 64)
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,134 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: refsite.go
+// Emit-all-sites mode: depth of aligned reads is tracked at every position of the multigenome,
+// so that covered positions without variant calls are reported as homozygous-reference calls
+// with their confidence (i.e. whether a position was callable).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const MAX_SITE_DEPTH = math.MaxUint16 // depth of aligned reads is saturated at this value
+
+//---------------------------------------------------------------------------------------------------
+// Depth of aligned reads at each position of the multigenome (allocated in emit-all-sites mode or
+// if coverage track output is required).
+//---------------------------------------------------------------------------------------------------
+var SiteDepth []uint16
+
+// Mutex locks of shards of SiteDepth, sharded by ranges of positions as variant calls (see VarCallIndex.Calls)
+var SiteDepthMut []sync.Mutex
+
+//---------------------------------------------------------------------------------------------------
+// AddCoverage increases depth of aligned reads at positions covered by an aligned read.
+// Only the lock of the shard of the current position is held (read-ends span at most a few shards).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddCoverage(start, length int) {
+	end := start + length
+	if start < 0 {
+		start = 0
+	}
+	if end > len(SiteDepth) {
+		end = len(SiteDepth)
+	}
+	for start < end {
+		rid := PARA.Proc_num * start / VC.SeqLen
+		// First position of the next shard: the smallest position p with PARA.Proc_num*p/VC.SeqLen > rid
+		shard_end := MinInt(end, ((rid+1)*VC.SeqLen+PARA.Proc_num-1)/PARA.Proc_num)
+		SiteDepthMut[rid].Lock()
+		for pos := start; pos < shard_end; pos++ {
+			if SiteDepth[pos] < MAX_SITE_DEPTH {
+				SiteDepth[pos]++
+			}
+		}
+		SiteDepthMut[rid].Unlock()
+		start = shard_end
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// RefConfidence computes probability of a homozygous-reference call at a position covered by
+// a number of reads which all agree with the reference, using the same genotype priors as new variants.
+//---------------------------------------------------------------------------------------------------
+func RefConfidence(depth int) float64 {
+	err := float64(PARA.Err_rate)
+	p_rr := (1 - 1.5*NEW_SNP_RATE) * math.Pow(1-err, float64(depth))
+	p_ra := NEW_SNP_RATE * math.Pow(0.5, float64(depth))
+	p_aa := 0.5 * NEW_SNP_RATE * math.Pow(err/3, float64(depth))
+	return p_rr / (p_rr + p_ra + p_aa)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteRefSite writes a homozygous-reference call at a position with its confidence.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteRefSite(w *bufio.Writer, pos int, ref_base string, ref_prob float64, depth int) {
+	chr_name, chr_pos := VC.ChrLoc(pos)
+	str_qual := strconv.FormatFloat(-10*math.Log10(1-ref_prob), 'f', 5, 64)
+	if str_qual == "+Inf" {
+		str_qual = "1000"
+	}
+	str_info := "DP=" + strconv.Itoa(depth)
+	str_gt := "0/0"
+	if IsHaploid(pos) {
+		str_gt = HaploidGT(str_gt)
+	}
+	str_format := str_gt + ":" + str_qual + ":" + strconv.Itoa(depth)
+	str_filter := "."
+	if len(FILTERS) > 0 {
+		qual, _ := strconv.ParseFloat(str_qual, 64)
+		str_filter = ApplyFilters(FILTERS, FilterValues(qual, str_info, "GT:GQ:DP", str_format))
+	}
+	str_filter = MASK.Filter(pos, str_filter)
+	w.WriteString(strings.Join([]string{chr_name, strconv.Itoa(chr_pos), ".", ref_base, ".", str_qual, str_filter,
+		str_info, "GT:GQ:DP", str_format}, "\t") + "\n")
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteRefSites writes homozygous-reference calls at covered positions in [start, end) which
+// have no variant calls.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteRefSites(w *bufio.Writer, start, end int) {
+	if end > len(SiteDepth) {
+		end = len(SiteDepth)
+	}
+	for pos := start; pos < end; pos++ {
+		if SiteDepth[pos] > 0 {
+			VC.WriteRefSite(w, pos, VC.RefBase(pos), RefConfidence(int(SiteDepth[pos])), int(SiteDepth[pos]))
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// RefBase returns the reference allele at a position (the first allele at known variant locations).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) RefBase(pos int) string {
+	if var_prof, is_known_var := VC.Variants[pos]; is_known_var {
+		return string(var_prof[0])
+	}
+	return string(VC.Seq[pos])
+}
+
+//---------------------------------------------------------------------------------------------------
+// CallDepth returns depth of aligned reads at a variant call position, taking the larger value of
+// the number of reads with aligned bases and the coverage of aligned reads.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CallDepth(rid int, pos uint32) int {
+	depth := 0
+	if site := VC.Calls[rid].Sites.Get(pos); site != nil {
+		for _, var_num := range site.RNum.Vals {
+			depth += var_num
+		}
+	}
+	if int(pos) < len(SiteDepth) && depth < int(SiteDepth[pos]) {
+		depth = int(SiteDepth[pos])
+	}
+	return depth
+}
@@ -0,0 +1,134 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: refwindow.go
+// Cache of reference windows of seed extensions. Left and right flanks of the multigenome (original, or
+// reduced at known deletions) are built for each seed by walking the multigenome and looking up known
+// variants; reads piling up at a hotspot extend seeds anchored at the same positions again and again.
+// Each aligning goroutine keeps recently used windows together with their precomputed annotations
+// (positions on the multigenome and X-drop rows of known variants) in a direct-mapped cache.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+const REF_WINDOW_CACHE_SIZE = 1024 // number of reference windows cached by each aligning goroutine
+
+//---------------------------------------------------------------------------------------------------
+// RefWindowKey identifies a reference window: its anchor (the base next to the seed), its length
+// (without known deletions), its direction and whether it is reduced at known deletions.
+//---------------------------------------------------------------------------------------------------
+type RefWindowKey struct {
+	Anchor, Len int
+	Left, Del   bool
+}
+
+//---------------------------------------------------------------------------------------------------
+// RefWindow represents a reference window of a seed extension and its annotations. Windows are shared
+// by extensions of many reads, they must not be modified.
+//---------------------------------------------------------------------------------------------------
+type RefWindow struct {
+	Key      RefWindowKey
+	Ref      []byte // bases of the window in original direction
+	PosMap   []int  // positions of bases of the window on the multigenome
+	Start    int    // position of the first base of the window (first base of the alignment for left windows)
+	DropRows int    // X-drop rows of known variants of the window (see XDropRows)
+	Ok       bool   // false if a left window cannot be reduced at a known deletion
+}
+
+//---------------------------------------------------------------------------------------------------
+// RefWindowCache represents a direct-mapped cache of reference windows. Methods of a nil cache build
+// windows without caching them.
+//---------------------------------------------------------------------------------------------------
+type RefWindowCache struct {
+	windows []*RefWindow
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewRefWindowCache creates a cache of size reference windows.
+//---------------------------------------------------------------------------------------------------
+func NewRefWindowCache(size int) *RefWindowCache {
+	return &RefWindowCache{windows: make([]*RefWindow, size)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns the reference window of key, it is built if it is not cached.
+//---------------------------------------------------------------------------------------------------
+func (C *RefWindowCache) Get(VC *VarCallIndex, key RefWindowKey) *RefWindow {
+	if C == nil {
+		return VC.BuildRefWindow(key)
+	}
+	h := uint(key.Anchor)*4 + uint(key.Len)*31
+	if key.Left {
+		h += 1
+	}
+	if key.Del {
+		h += 2
+	}
+	slot := &C.windows[h%uint(len(C.windows))]
+	if *slot == nil || (*slot).Key != key {
+		*slot = VC.BuildRefWindow(key)
+	}
+	return *slot
+}
+
+//---------------------------------------------------------------------------------------------------
+// BuildRefWindow builds the reference window of key. Left windows end at the anchor and right windows
+// start at it; known deletions of reduced windows are skipped (left windows fail if a deletion is not
+// shorter than the rest of the window).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) BuildRefWindow(key RefWindowKey) *RefWindow {
+	var i, j, del_len int
+	var is_var, is_del bool
+	win := &RefWindow{Key: key, Ok: true}
+	win.Ref, win.PosMap = make([]byte, 0, key.Len), make([]int, 0, key.Len)
+	if key.Left {
+		i = key.Anchor
+		j = 0 // to check length of the window
+		for j < key.Len && i >= 0 {
+			if _, is_var = VC.Variants[i]; is_var && key.Del {
+				if del_len, is_del = VC.DelVar[i]; is_del {
+					if del_len < j && del_len < len(win.Ref) {
+						win.Ref = win.Ref[:len(win.Ref)-del_len]
+						win.PosMap = win.PosMap[:len(win.PosMap)-del_len]
+						j -= del_len
+					} else {
+						win.Ok = false
+						return win
+					}
+				}
+			}
+			win.PosMap = append(win.PosMap, i)
+			win.Ref = append(win.Ref, VC.Seq[i])
+			j++
+			i--
+		}
+		win.Start = i + 1
+		// Reverse the window to get it in original direction
+		for i, j = 0, len(win.Ref)-1; i < j; i, j = i+1, j-1 {
+			win.PosMap[i], win.PosMap[j] = win.PosMap[j], win.PosMap[i]
+			win.Ref[i], win.Ref[j] = win.Ref[j], win.Ref[i]
+		}
+	} else {
+		win.Start = key.Anchor
+		i = key.Anchor
+		j = 0 // to check length of the window
+		for j < key.Len && i < VC.SeqLen {
+			win.PosMap = append(win.PosMap, i)
+			win.Ref = append(win.Ref, VC.Seq[i])
+			if _, is_var = VC.Variants[i]; is_var && key.Del {
+				if del_len, is_del = VC.DelVar[i]; is_del {
+					if del_len < key.Len-PARA.Indel_backup-j && i+del_len < VC.SeqLen {
+						i += del_len
+					} else {
+						//continue to align without remaning part of read and ref
+						win.Ref = win.Ref[:len(win.Ref)-1]
+						break
+					}
+				}
+			}
+			j++
+			i++
+		}
+	}
+	win.DropRows = VC.Genome().XDropRows(win.PosMap)
+	return win
+}
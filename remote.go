@@ -0,0 +1,129 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: remote.go
+// Remote input files: reads, reference genomes and variant profiles are streamed from http(s)://,
+// s3:// and gs:// locations, index files are downloaded once into a local cache directory.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"fmt"
+	"github.com/namsyvo/IVC/fmi"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Files of FM-index which are cached from remote index directories
+var FMI_FILES = []string{"others", "sa", "occ.A", "occ.C", "occ.G", "occ.T"}
+
+//---------------------------------------------------------------------------------------------------
+// IsRemote determines whether a file name is an URL of a remote file.
+//---------------------------------------------------------------------------------------------------
+func IsRemote(file_name string) bool {
+	return strings.HasPrefix(file_name, "http://") || strings.HasPrefix(file_name, "https://") ||
+		strings.HasPrefix(file_name, "s3://") || strings.HasPrefix(file_name, "gs://")
+}
+
+//---------------------------------------------------------------------------------------------------
+// RemoteURL converts s3:// and gs:// locations to their public HTTPS endpoints.
+// Private objects can be accessed by presigned/signed https:// URLs.
+//---------------------------------------------------------------------------------------------------
+func RemoteURL(file_name string) string {
+	if strings.HasPrefix(file_name, "s3://") {
+		bucket_key := strings.SplitN(strings.TrimPrefix(file_name, "s3://"), "/", 2)
+		if len(bucket_key) == 2 {
+			return "https://" + bucket_key[0] + ".s3.amazonaws.com/" + bucket_key[1]
+		}
+	} else if strings.HasPrefix(file_name, "gs://") {
+		return "https://storage.googleapis.com/" + strings.TrimPrefix(file_name, "gs://")
+	}
+	return file_name
+}
+
+//---------------------------------------------------------------------------------------------------
+// JoinPath joins a directory and a file name, keeps "//" of URLs which path.Join would remove.
+//---------------------------------------------------------------------------------------------------
+func JoinPath(dir, name string) string {
+	if IsRemote(dir) {
+		return strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	return path.Join(dir, name)
+}
+
+//---------------------------------------------------------------------------------------------------
+// OpenInput opens a local file or a stream of a remote file for reading.
+//---------------------------------------------------------------------------------------------------
+func OpenInput(file_name string) (io.ReadCloser, error) {
+	if !IsRemote(file_name) {
+		return os.Open(file_name)
+	}
+	resp, e := http.Get(RemoteURL(file_name))
+	if e != nil {
+		return nil, e
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot get %s (%s)", file_name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+//---------------------------------------------------------------------------------------------------
+// CacheRemoteFile downloads a remote file to the cache directory (if it is not cached yet) and
+// returns name of the local file. Local files are returned unchanged.
+//---------------------------------------------------------------------------------------------------
+func CacheRemoteFile(file_name, cache_dir string) string {
+	if !IsRemote(file_name) {
+		return file_name
+	}
+	url := RemoteURL(file_name)
+	local_file := filepath.Join(cache_dir, strings.NewReplacer("://", "/", ":", "_").Replace(url))
+	if _, e := os.Stat(local_file); e == nil {
+		log.Printf("Using cached file %s for %s", local_file, file_name)
+		return local_file
+	}
+	if e := os.MkdirAll(filepath.Dir(local_file), 0777); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Downloading %s to %s...", file_name, local_file)
+	r, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer r.Close()
+	// Download to a temporary file first, so that incomplete downloads are not used as cached files
+	f := CreateTmpFile(filepath.Base(local_file) + ".part")
+	if _, e = io.Copy(f, r); e != nil {
+		f.Close()
+		log.Panicf("Error: %s", e)
+	}
+	f.Close()
+	if e = MoveFile(f.Name(), local_file); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return local_file
+}
+
+//---------------------------------------------------------------------------------------------------
+// CacheRemoteIndex downloads files of a remote FM-index directory to the cache directory and returns
+// name of the local index directory. Local directories are returned unchanged.
+//---------------------------------------------------------------------------------------------------
+func CacheRemoteIndex(dir_name, cache_dir string) string {
+	if !IsRemote(dir_name) {
+		return dir_name
+	}
+	var local_file string
+	for _, fn := range FMI_FILES {
+		local_file = CacheRemoteFile(JoinPath(dir_name, fn), cache_dir)
+	}
+	if fmi.Sampled(filepath.Dir(local_file)) {
+		CacheRemoteFile(JoinPath(dir_name, fmi.SA_MARK_FILE), cache_dir)
+	}
+	return filepath.Dir(local_file) + "/"
+}
@@ -2,15 +2,25 @@
 // IVC: seed.go
 // Searching for seeds of alignment betwwen reads and multigenomes.
 // Searching is perfomed from a random position on reads forwardly using an FM-index of reverse multigenomes.
+// With a bidirectional FM-index, seeds which are too short or have too many positions are also extended
+// backwardly on reads from their start positions.
+// Random positions can be biased toward high-quality regions of reads (quality-aware seeding), so that
+// seeds rarely start in low-quality tails. Seeds which are too short because of an early sequencing error
+// can be rescued by a branching search allowing one mismatch (mismatch-tolerant seeding).
 // Copyright 2015 Nam Sy Vo.
 //--------------------------------------------------------------------------------------------------
 
 package ivc
 
 import (
+	"math"
 	"math/rand"
+	"sort"
 )
 
+// Maximum alignment cost of a base in weights of seed start positions (bases of quality 0 have infinite cost)
+const SEED_MAX_COST = 1.0
+
 //--------------------------------------------------------------------------------------------------
 // ForwardSearchFrom searches for exact matches between a pattern and the reference using FM-index.
 // It starts to search forwardly on the pattern from any position to match backwardly on the reference.
@@ -47,25 +57,197 @@ func (VC *VarCallIndex) ForwardSearchFrom(pattern []byte, s_pos int) (int, int,
 	return int(sp), int(ep), i - 1
 }
 
+//--------------------------------------------------------------------------------------------------
+// BiSearchFrom searches for exact matches between a pattern and the reference using the bidirectional
+// FM-index. Matches are extended forwardly on the pattern from s_pos as in ForwardSearchFrom, then
+// backwardly from s_pos while they are shorter than Min_slen or have more than Max_snum positions (and
+// not longer than Max_slen). It returns starting position of matches on the pattern, their range on the
+// FM-index of the reverse multigenome and their ending position on the pattern.
+//--------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) BiSearchFrom(pattern []byte, s_pos int) (int, int, int, int) {
+	r, ok := VC.BiFMI.Start(pattern[s_pos])
+	if !ok {
+		return -1, -1, -1, -1
+	}
+	var i int
+	for i = s_pos + 1; i < len(pattern) && i <= s_pos+PARA.Max_slen; i++ {
+		if r, ok = VC.BiFMI.ExtendRight(r, pattern[i]); !ok {
+			break
+		}
+	}
+	e_pos := i - 1
+	for s_pos > 0 && e_pos-s_pos < PARA.Max_slen && (e_pos-s_pos < PARA.Min_slen || int(r.Ep-r.Sp+1) > PARA.Max_snum) {
+		if r, ok = VC.BiFMI.ExtendLeft(r, pattern[s_pos-1]); !ok {
+			break
+		}
+		s_pos--
+	}
+	return s_pos, int(r.Sp), int(r.Ep), e_pos
+}
+
+//--------------------------------------------------------------------------------------------------
+// SeedBranch represents a range of the FM-index matching a pattern with at most one mismatch, and the
+// position of the mismatch on the pattern (-1: exact match).
+//--------------------------------------------------------------------------------------------------
+type SeedBranch struct {
+	sp, ep uint32
+	mm_pos int
+}
+
+//--------------------------------------------------------------------------------------------------
+// ForwardSearchFrom1 searches for matches with at most one mismatch between a pattern and the reference,
+// forwardly on the pattern from s_pos, branching on the other bases at each position. It returns the
+// ranges of the longest matches and the ending position of these matches on the pattern. Mismatches
+// must be within Seed_backup bases of the ends of matches, so that they are realigned when seeds are
+// extended.
+//--------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ForwardSearchFrom1(pattern []byte, s_pos int) ([]SeedBranch, int) {
+	c := pattern[s_pos]
+	sp, ok := VC.RevFMI.C[c]
+	if !ok {
+		return nil, -1
+	}
+	branches := []SeedBranch{{sp, VC.RevFMI.EP[c], -1}}
+	extend := func(b SeedBranch, c byte) (SeedBranch, bool) {
+		offset, ok := VC.RevFMI.C[c]
+		if !ok {
+			return b, false
+		}
+		sp0, ep0 := offset+VC.RevFMI.OCC[c][b.sp-1], offset+VC.RevFMI.OCC[c][b.ep]-1
+		return SeedBranch{sp0, ep0, b.mm_pos}, sp0 <= ep0
+	}
+	var best []SeedBranch
+	e_pos := -1
+	for i := s_pos + 1; i < len(pattern) && i <= s_pos+PARA.Max_slen; i++ {
+		next := make([]SeedBranch, 0, len(branches))
+		for _, b := range branches {
+			if nb, ok := extend(b, pattern[i]); ok {
+				next = append(next, nb)
+			}
+			if b.mm_pos >= 0 {
+				continue
+			}
+			for _, c := range []byte("ACGT") {
+				if c == pattern[i] {
+					continue
+				}
+				if nb, ok := extend(b, c); ok {
+					nb.mm_pos = i
+					next = append(next, nb)
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		branches = next
+		var valid []SeedBranch
+		for _, b := range branches {
+			if b.mm_pos < 0 || b.mm_pos < s_pos+PARA.Seed_backup || b.mm_pos > i-PARA.Seed_backup {
+				valid = append(valid, b)
+			}
+		}
+		if len(valid) > 0 {
+			best, e_pos = valid, i
+		}
+	}
+	return best, e_pos
+}
+
+//--------------------------------------------------------------------------------------------------
+// SearchSeeds1 returns positions and distances of seeds with at most one mismatch between a read and the
+// reference (as SearchSeeds).
+//--------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SearchSeeds1(read []byte, s_pos int, m_pos []int) (int, int, bool) {
+	branches, e_pos := VC.ForwardSearchFrom1(read, s_pos)
+	if e_pos-s_pos < PARA.Min_slen {
+		return e_pos, 0, false
+	}
+	m_num := 0
+	for _, b := range branches {
+		m_num += int(b.ep - b.sp + 1)
+	}
+	if m_num > PARA.Max_snum {
+		return e_pos, m_num, false
+	}
+	m_num = 0
+	for _, b := range branches {
+		for idx := b.sp; idx <= b.ep; idx++ {
+			m_pos[m_num] = VC.SeqLen - 1 - int(VC.RevFMI.Locate(uint32(idx))) - (e_pos - s_pos)
+			m_num++
+		}
+	}
+	return e_pos, m_num, true
+}
+
 //--------------------------------------------------------------------------------------------------
 // SearchSeeds returns positions and distances of seeds between a read and the reference.
 // It searches forwardly on read to match backwardly on reverse of the reference.
 //--------------------------------------------------------------------------------------------------
 func (VC *VarCallIndex) SearchSeeds(read []byte, s_pos int, m_pos []int) (int, int, int, bool) {
 
-	sp, ep, e_pos := VC.ForwardSearchFrom(read, s_pos)
+	if s_pos >= len(read) { // possible with short reads in deterministic mode
+		return -1, -1, -1, false
+	}
+	if VC.KmerIdx != nil {
+		return VC.SearchKmerSeeds(read, s_pos, m_pos)
+	}
+
+	var sp, ep, e_pos int
+	if VC.BiFMI != nil {
+		s_pos, sp, ep, e_pos = VC.BiSearchFrom(read, s_pos)
+	} else {
+		sp, ep, e_pos = VC.ForwardSearchFrom(read, s_pos)
+	}
 	if e_pos >= 0 {
 		if ep-sp+1 <= PARA.Max_snum && e_pos-s_pos >= PARA.Min_slen {
 			for idx := sp; idx <= ep; idx++ {
-				m_pos[idx-sp] = VC.SeqLen - 1 - int(VC.RevFMI.SA[idx]) - (e_pos - s_pos)
+				m_pos[idx-sp] = VC.SeqLen - 1 - int(VC.RevFMI.Locate(uint32(idx))) - (e_pos - s_pos)
 			}
 			return s_pos, e_pos, ep - sp + 1, true
 		}
+		// Exact seeds which are too short (e.g. because of an early sequencing error) are rescued by
+		// seeds with one mismatch in mismatch-tolerant seeding
+		if PARA.Mismatch_seeds && e_pos-s_pos < PARA.Min_slen {
+			if e_pos1, m_num, ok := VC.SearchSeeds1(read, s_pos, m_pos); ok {
+				return s_pos, e_pos1, m_num, true
+			}
+		}
 		return s_pos, e_pos, ep - sp + 1, false
 	}
 	return -1, -1, -1, false // will be changed later
 }
 
+//---------------------------------------------------------------------------------------------------
+// SeedStartCum returns cumulative weights of start positions of seeds on a read (positions from which
+// seeds of minimum length fit in the read). The weight of a position is the probability that the
+// Min_slen bases from it have no sequencing errors given their qualities.
+//---------------------------------------------------------------------------------------------------
+func SeedStartCum(qual []byte) []float64 {
+	cum := make([]float64, len(qual)-PARA.Min_slen)
+	sum := 0.0
+	for i := range cum {
+		cost := 0.0
+		for _, q := range qual[i : i+PARA.Min_slen] {
+			cost += math.Min(Q2C[q], SEED_MAX_COST)
+		}
+		sum += math.Pow(10, -cost)
+		cum[i] = sum
+	}
+	return cum
+}
+
+//---------------------------------------------------------------------------------------------------
+// SeedStart returns a random start position of seeds on a read of length read_len, drawn with weights
+// of positions if they are given (cum), or uniformly otherwise.
+//---------------------------------------------------------------------------------------------------
+func SeedStart(read_len int, cum []float64, rand_gen *rand.Rand) int {
+	if cum == nil || cum[len(cum)-1] <= 0 {
+		return rand_gen.Intn(read_len - PARA.Min_slen)
+	}
+	return MinInt(sort.SearchFloat64s(cum, rand_gen.Float64()*cum[len(cum)-1]), len(cum)-1)
+}
+
 //---------------------------------------------------------------------------------------------------
 // SearchSeedsPE searches for all pairs of seeds which have proper chromosome distances.
 //---------------------------------------------------------------------------------------------------
@@ -79,12 +261,18 @@ func (VC *VarCallIndex) SearchSeedsPE(read_info *ReadInfo, seed_pos [][]int, ran
 	var i, j int
 
 	var r_pos_r1_or, r_pos_r1_rc, r_pos_r2_or, r_pos_r2_rc int
+	// Random positions are drawn with weights of base qualities in quality-aware seeding
+	var cum_r1_or, cum_r1_rc, cum_r2_or, cum_r2_rc []float64
+	if PARA.Search_mode == 1 && PARA.Qual_seeds {
+		cum_r1_or, cum_r1_rc = SeedStartCum(read_info.Qual1), SeedStartCum(read_info.Rev_qual1)
+		cum_r2_or, cum_r2_rc = SeedStartCum(read_info.Qual2), SeedStartCum(read_info.Rev_qual2)
+	}
 	//Take an initial position to search
 	if PARA.Search_mode == 1 {
-		r_pos_r1_or = rand_gen.Intn(len(read_info.Read1) - PARA.Min_slen)
-		r_pos_r1_rc = rand_gen.Intn(len(read_info.Read1) - PARA.Min_slen)
-		r_pos_r2_or = rand_gen.Intn(len(read_info.Read2) - PARA.Min_slen)
-		r_pos_r2_rc = rand_gen.Intn(len(read_info.Read2) - PARA.Min_slen)
+		r_pos_r1_or = SeedStart(read_info.Len1, cum_r1_or, rand_gen)
+		r_pos_r1_rc = SeedStart(read_info.Len1, cum_r1_rc, rand_gen)
+		r_pos_r2_or = SeedStart(read_info.Len2, cum_r2_or, rand_gen)
+		r_pos_r2_rc = SeedStart(read_info.Len2, cum_r2_rc, rand_gen)
 	} else {
 		r_pos_r1_or = PARA.Start_pos
 		r_pos_r1_rc = PARA.Start_pos
@@ -133,56 +321,40 @@ func (VC *VarCallIndex) SearchSeedsPE(read_info *ReadInfo, seed_pos [][]int, ran
 					s_pos_r2_rc, e_pos_r2_rc, m_num_r2_rc, seed_pos[3])
 			}
 		}
-		if has_seeds_r1_or && has_seeds_r2_rc {
-			if PARA.Debug_mode {
-				PrintExtendTraceInfo("r1_or(F1R2)", read_info.Read1[s_pos_r1_or:e_pos_r1_or+1],
-					s_pos_r1_or, e_pos_r1_or, m_num_r1_or, seed_pos[0])
-				PrintExtendTraceInfo("r2_rc(F1R2)", read_info.Read2[s_pos_r2_rc:e_pos_r2_rc+1],
-					s_pos_r2_rc, e_pos_r2_rc, m_num_r2_rc, seed_pos[3])
-			}
-			for i = 0; i < m_num_r1_or; i++ {
-				for j = 0; j < m_num_r2_rc; j++ {
-					//Check if alignments are likely pair-end alignments
-					if (seed_pos[3][j]-seed_pos[0][i]) >= PARA.Read_len &&
-						(seed_pos[3][j]-seed_pos[0][i]) <= PARA.Read_len+PARA.Max_ins {
-						if PARA.Debug_mode {
-							PrintPairedSeedInfo("r1_or, r2_rc, paired pos", seed_pos[0][i], seed_pos[3][j])
-						}
-						s_pos_r1 = append(s_pos_r1, s_pos_r1_or)
-						e_pos_r1 = append(e_pos_r1, e_pos_r1_or)
-						s_pos_r2 = append(s_pos_r2, s_pos_r2_rc)
-						e_pos_r2 = append(e_pos_r2, e_pos_r2_rc)
-						m_pos_r1 = append(m_pos_r1, seed_pos[0][i])
-						m_pos_r2 = append(m_pos_r2, seed_pos[3][j])
-						strand_r1 = append(strand_r1, true)
-						strand_r2 = append(strand_r2, false)
-					}
+		// Seeds of the two ends are paired in orientations of regimes of proper read pairs (see PairInsert),
+		// seed_pos[t1] and seed_pos[2+t2] are positions of the first and second ends (t = 0: original, 1: reverse complement)
+		has_seeds_r1, has_seeds_r2 := [2]bool{has_seeds_r1_or, has_seeds_r1_rc}, [2]bool{has_seeds_r2_or, has_seeds_r2_rc}
+		s_pos1, e_pos1, m_num1 := [2]int{s_pos_r1_or, s_pos_r1_rc}, [2]int{e_pos_r1_or, e_pos_r1_rc}, [2]int{m_num_r1_or, m_num_r1_rc}
+		s_pos2, e_pos2, m_num2 := [2]int{s_pos_r2_or, s_pos_r2_rc}, [2]int{e_pos_r2_or, e_pos_r2_rc}, [2]int{m_num_r2_or, m_num_r2_rc}
+		reads1, reads2 := [2][]byte{read_info.Read1, read_info.Rev_comp_read1}, [2][]byte{read_info.Read2, read_info.Rev_comp_read2}
+		seed_types := [2]string{"or", "rc"}
+		for t1 := 0; t1 < 2; t1++ {
+			for t2 := 0; t2 < 2; t2++ {
+				if !has_seeds_r1[t1] || !has_seeds_r2[t2] || !ProperStrands(t1 == 0, t2 == 0) {
+					continue
 				}
-			}
-		}
-		if has_seeds_r1_rc && has_seeds_r2_or {
-			if PARA.Debug_mode {
-				PrintExtendTraceInfo("r1_rc (F2R1)", read_info.Read1[s_pos_r1_rc:e_pos_r1_rc+1],
-					s_pos_r1_rc, e_pos_r1_rc, m_num_r1_rc, seed_pos[1])
-				PrintExtendTraceInfo("r2_or (F2R1)", read_info.Read2[s_pos_r2_or:e_pos_r2_or+1],
-					s_pos_r2_or, e_pos_r2_or, m_num_r2_or, seed_pos[2])
-			}
-			for i = 0; i < m_num_r1_rc; i++ {
-				for j = 0; j < m_num_r2_or; j++ {
-					//Check if alignments are likely pair-end alignments
-					if (seed_pos[1][i]-seed_pos[2][j]) >= PARA.Read_len &&
-						(seed_pos[1][i]-seed_pos[2][j]) <= PARA.Read_len+PARA.Max_ins {
-						if PARA.Debug_mode {
-							PrintPairedSeedInfo("r1_rc, r2_or, paired pos", seed_pos[1][i], seed_pos[2][j])
+				if PARA.Debug_mode {
+					PrintExtendTraceInfo("r1_"+seed_types[t1]+" (paired)", reads1[t1][s_pos1[t1]:e_pos1[t1]+1],
+						s_pos1[t1], e_pos1[t1], m_num1[t1], seed_pos[t1])
+					PrintExtendTraceInfo("r2_"+seed_types[t2]+" (paired)", reads2[t2][s_pos2[t2]:e_pos2[t2]+1],
+						s_pos2[t2], e_pos2[t2], m_num2[t2], seed_pos[2+t2])
+				}
+				for i = 0; i < m_num1[t1]; i++ {
+					for j = 0; j < m_num2[t2]; j++ {
+						//Check if alignments are likely pair-end alignments
+						if ProperPairDist(seed_pos[t1][i], seed_pos[2+t2][j], read_info.Len1, read_info.Len2, t1 == 0, t2 == 0) {
+							if PARA.Debug_mode {
+								PrintPairedSeedInfo("r1_"+seed_types[t1]+", r2_"+seed_types[t2]+", paired pos", seed_pos[t1][i], seed_pos[2+t2][j])
+							}
+							s_pos_r1 = append(s_pos_r1, s_pos1[t1])
+							e_pos_r1 = append(e_pos_r1, e_pos1[t1])
+							s_pos_r2 = append(s_pos_r2, s_pos2[t2])
+							e_pos_r2 = append(e_pos_r2, e_pos2[t2])
+							m_pos_r1 = append(m_pos_r1, seed_pos[t1][i])
+							m_pos_r2 = append(m_pos_r2, seed_pos[2+t2][j])
+							strand_r1 = append(strand_r1, t1 == 0)
+							strand_r2 = append(strand_r2, t2 == 0)
 						}
-						s_pos_r1 = append(s_pos_r1, s_pos_r1_rc)
-						e_pos_r1 = append(e_pos_r1, e_pos_r1_rc)
-						s_pos_r2 = append(s_pos_r2, s_pos_r2_or)
-						e_pos_r2 = append(e_pos_r2, e_pos_r2_or)
-						m_pos_r1 = append(m_pos_r1, seed_pos[1][i])
-						m_pos_r2 = append(m_pos_r2, seed_pos[2][j])
-						strand_r1 = append(strand_r1, false)
-						strand_r2 = append(strand_r2, true)
 					}
 				}
 			}
@@ -192,10 +364,10 @@ func (VC *VarCallIndex) SearchSeedsPE(read_info *ReadInfo, seed_pos [][]int, ran
 		}
 		//Take a new position to search
 		if PARA.Search_mode == 1 { //random search
-			r_pos_r1_or = rand_gen.Intn(len(read_info.Read1) - PARA.Min_slen)
-			r_pos_r1_rc = rand_gen.Intn(len(read_info.Read1) - PARA.Min_slen)
-			r_pos_r2_or = rand_gen.Intn(len(read_info.Read2) - PARA.Min_slen)
-			r_pos_r2_rc = rand_gen.Intn(len(read_info.Read2) - PARA.Min_slen)
+			r_pos_r1_or = SeedStart(read_info.Len1, cum_r1_or, rand_gen)
+			r_pos_r1_rc = SeedStart(read_info.Len1, cum_r1_rc, rand_gen)
+			r_pos_r2_or = SeedStart(read_info.Len2, cum_r2_or, rand_gen)
+			r_pos_r2_rc = SeedStart(read_info.Len2, cum_r2_rc, rand_gen)
 		} else {
 			r_pos_r1_or = r_pos_r1_or + PARA.Search_step
 			r_pos_r1_rc = r_pos_r1_rc + PARA.Search_step
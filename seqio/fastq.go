@@ -0,0 +1,245 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: seqio/fastq.go
+// Validating FASTQ parser. Records can have lines of any length, sequences and qualities can be wrapped
+// on several lines, blank lines between records are skipped and line ends can be CRLF. Malformed
+// records (headers not starting with '@', missing '+' lines, quality strings of different lengths
+// than sequences, truncated records) are reported with their line numbers, or are skipped in resync
+// mode: parsing resumes at the next line starting with '@' and skipped records are counted; truncated
+// inputs (e.g. truncated gzip files) then end at their last complete record. Names of paired records
+// of two FASTQ files can be checked to match, to catch read files of different samples or runs.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package seqio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+//---------------------------------------------------------------------------------------------------
+// FastqReader represents a FASTQ parser, the current record is kept in Info (the header line), Read
+// and Qual, whose buffers are reused for next records.
+//---------------------------------------------------------------------------------------------------
+type FastqReader struct {
+	Info, Read, Qual []byte
+	name             string        // name of the input, used in error messages
+	r                *bufio.Reader // input
+	line             []byte        // current line
+	line_num         int           // number of the current line
+	rec_line         int           // line number of the header of the current record
+	rec_num          int           // number of parsed records
+	err              error         // first error of reading or parsing
+	resync           bool          // malformed records are skipped (resync mode)
+	held             bool          // the current line is not consumed yet (it is returned again by nextLine)
+	read_err         bool          // err is an error of reading (not of parsing)
+	skip_num         int           // number of skipped malformed records (resync mode)
+	skip_err         error         // error of the last skipped malformed record or of the truncated input
+	truncated        bool          // the input ended with an error of reading (resync mode)
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewFastqReader creates a FASTQ parser of an input with a name used in error messages.
+//---------------------------------------------------------------------------------------------------
+func NewFastqReader(r io.Reader, name string) *FastqReader {
+	return &FastqReader{name: name, r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// nextLine reads the next line (without line ends) into F.line, it returns false at the end of input.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) nextLine() bool {
+	if F.held {
+		F.held = false
+		return true
+	}
+	F.line = F.line[:0]
+	for {
+		part, e := F.r.ReadSlice('\n')
+		F.line = append(F.line, part...)
+		if e == bufio.ErrBufferFull {
+			continue
+		}
+		if e != nil && e != io.EOF {
+			F.err, F.read_err = fmt.Errorf("%s: line %d: %s", F.name, F.line_num+1, e), true
+			return false
+		}
+		if e == io.EOF && len(F.line) == 0 {
+			return false
+		}
+		break
+	}
+	F.line_num++
+	F.line = bytes.TrimRight(F.line, "\r\n")
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// errorf sets the error of a malformed record at the current line.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) errorf(format string, args ...interface{}) bool {
+	F.err = fmt.Errorf("%s: line %d: malformed FASTQ record %d: %s", F.name, F.line_num, F.rec_num+1, fmt.Sprintf(format, args...))
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetResync sets resync mode: malformed records are skipped instead of ending parsing with an error.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) SetResync(resync bool) {
+	F.resync = resync
+}
+
+//---------------------------------------------------------------------------------------------------
+// Next parses the next record, it returns false at the end of input or at the first error (see Err).
+// In resync mode, a malformed record is skipped up to the next line starting with '@' (a malformed
+// record can take the next record with it if its end cannot be recognized), and an error of reading
+// ends the input.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) Next() bool {
+	for !F.next() {
+		if F.err == nil || !F.resync {
+			return false
+		}
+		F.skip_num++
+		F.skip_err, F.err = F.err, nil
+		if F.read_err {
+			F.truncated = true
+			return false
+		}
+		// Resynchronize at the next header
+		for {
+			if !F.nextLine() {
+				if F.err != nil {
+					F.skip_err, F.err, F.truncated = F.err, nil, true
+				}
+				return false
+			}
+			if len(F.line) > 0 && F.line[0] == '@' {
+				F.held = true
+				break
+			}
+		}
+	}
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// next parses the next record, it returns false at the end of input or at an error.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) next() bool {
+	if F.err != nil || F.truncated {
+		return false
+	}
+	// Skip blank lines between records
+	for {
+		if !F.nextLine() {
+			return false
+		}
+		if len(F.line) > 0 {
+			break
+		}
+	}
+	if F.line[0] != '@' {
+		return F.errorf("header does not start with '@'")
+	}
+	F.Info, F.rec_line = append(F.Info[:0], F.line...), F.line_num
+	F.Read = F.Read[:0]
+	for {
+		if !F.nextLine() {
+			if F.err != nil {
+				return false
+			}
+			return F.errorf("truncated record (no '+' line)")
+		}
+		if len(F.line) > 0 && F.line[0] == '+' {
+			break
+		}
+		F.Read = append(F.Read, F.line...)
+	}
+	F.Qual = F.Qual[:0]
+	for len(F.Qual) < len(F.Read) {
+		if !F.nextLine() {
+			if F.err != nil {
+				return false
+			}
+			return F.errorf("truncated record (%d quality values for %d bases)", len(F.Qual), len(F.Read))
+		}
+		F.Qual = append(F.Qual, F.line...)
+	}
+	if len(F.Qual) != len(F.Read) {
+		return F.errorf("%d quality values for %d bases", len(F.Qual), len(F.Read))
+	}
+	F.rec_num++
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// Err returns the first error of reading or parsing the input, nil at the end of input.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) Err() error {
+	return F.err
+}
+
+//---------------------------------------------------------------------------------------------------
+// SkipNum returns the number of skipped malformed records, including the incomplete last record of a
+// truncated input (resync mode).
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) SkipNum() int {
+	return F.skip_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// SkipErr returns the error of the last skipped malformed record or of the truncated input (resync mode).
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) SkipErr() error {
+	return F.skip_err
+}
+
+//---------------------------------------------------------------------------------------------------
+// Truncated checks if the input ended with an error of reading (resync mode).
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) Truncated() bool {
+	return F.truncated
+}
+
+//---------------------------------------------------------------------------------------------------
+// RecordNum returns the number of parsed records.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) RecordNum() int {
+	return F.rec_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// RecordLine returns the line number of the header of the current record.
+//---------------------------------------------------------------------------------------------------
+func (F *FastqReader) RecordLine() int {
+	return F.rec_line
+}
+
+//---------------------------------------------------------------------------------------------------
+// MateName returns the name of a read from its header line: the first word without '@' and the
+// suffix /1 or /2 of the end.
+//---------------------------------------------------------------------------------------------------
+func MateName(info []byte) []byte {
+	name := bytes.TrimPrefix(info, []byte{'@'})
+	if i := bytes.IndexAny(name, " \t"); i >= 0 {
+		name = name[:i]
+	}
+	if n := len(name); n >= 2 && name[n-2] == '/' && (name[n-1] == '1' || name[n-1] == '2') {
+		name = name[:n-2]
+	}
+	return name
+}
+
+//---------------------------------------------------------------------------------------------------
+// MateError returns the error of the current records of two FASTQ parsers if their names differ.
+//---------------------------------------------------------------------------------------------------
+func MateError(F1, F2 *FastqReader) error {
+	if bytes.Equal(MateName(F1.Info), MateName(F2.Info)) {
+		return nil
+	}
+	return fmt.Errorf("names of paired records differ (%s: line %d: %s, %s: line %d: %s), read files may be from different samples",
+		F1.name, F1.rec_line, F1.Info, F2.name, F2.rec_line, F2.Info)
+}
@@ -0,0 +1,45 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: seqio/gzip.go
+// Gzip-compressed inputs, detected by their magic bytes and decompressed on the fly.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package seqio
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+//---------------------------------------------------------------------------------------------------
+// CompressedReader represents a reader of a possibly gzip-compressed input.
+//---------------------------------------------------------------------------------------------------
+type CompressedReader struct {
+	io.Reader
+	f io.Closer
+}
+
+//---------------------------------------------------------------------------------------------------
+// Close closes the underlying input.
+//---------------------------------------------------------------------------------------------------
+func (C *CompressedReader) Close() error {
+	return C.f.Close()
+}
+
+//---------------------------------------------------------------------------------------------------
+// Decompress returns a reader of an input which decompresses it if it is gzip-compressed, closing the
+// reader closes the input (the input is closed if it cannot be decompressed).
+//---------------------------------------------------------------------------------------------------
+func Decompress(f io.ReadCloser) (io.ReadCloser, error) {
+	r := bufio.NewReaderSize(f, 64*1024)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, e := gzip.NewReader(r)
+		if e != nil {
+			f.Close()
+			return nil, e
+		}
+		return &CompressedReader{Reader: bufio.NewReaderSize(gz, 64*1024), f: f}, nil
+	}
+	return &CompressedReader{Reader: r, f: f}, nil
+}
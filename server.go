@@ -0,0 +1,143 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: server.go
+// Server mode: the index is loaded once and variants are called for batches of reads sent over HTTP.
+// Each request has its own variant calls, so reads of independent requests are aligned concurrently;
+// only computing posteriors and writing variant calls, which use run-wide state (e.g. run summary,
+// ploidy, filters), is serialized.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//---------------------------------------------------------------------------------------------------
+// CallRequest represents a request for calling variants from a batch of paired-end reads.
+//---------------------------------------------------------------------------------------------------
+type CallRequest struct {
+	Sample string `json:"sample"` // sample name in the header line of output
+	Reads1 string `json:"reads1"` // first ends in FASTQ format
+	Reads2 string `json:"reads2"` // second ends in FASTQ format
+}
+
+// Maximum size of request bodies (bytes), larger requests are rejected
+const SERVER_MAX_REQUEST = 1 << 30
+
+// Mutex lock for steps of requests which use run-wide state (see HandleCall)
+var SERVER_MUT = &sync.Mutex{}
+
+//---------------------------------------------------------------------------------------------------
+// ServerConcurrent checks if reads of requests can be aligned concurrently. Options which keep state of
+// aligned reads of a run in global data structures (read cache, realignment buffers, coverage of all
+// positions, pileup, SV/CNV candidates, barcodes, debug outputs, ...) make requests processed one by one.
+//---------------------------------------------------------------------------------------------------
+func ServerConcurrent() bool {
+	return PARA.Read_cache == 0 && !PARA.Auto_tune && !PARA.Realign && !PARA.Assemble && !PARA.All_sites && !PARA.Linked_reads &&
+		!PARA.Debug_mode && PARA.Debug_file == "" && ALN_DUMP == nil && PARA.Unaligned_file == "" && PARA.Bedgraph_file == "" &&
+		PARA.CNV_file == "" && PARA.Pileup_file == "" && PARA.SV_file == ""
+}
+
+//---------------------------------------------------------------------------------------------------
+// Serve listens on addr and handles requests for calling variants until the server is stopped.
+//
+//	POST /call: takes a CallRequest (JSON) and returns variant calls (VCF).
+//	GET /status: returns "OK" if the server is ready.
+//
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) Serve(addr string) {
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK\n"))
+	})
+	http.HandleFunc("/call", VC.HandleCall)
+	log.Printf("Listening on %s...", addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+//---------------------------------------------------------------------------------------------------
+// HandleCall calls variants from reads in a request and writes variant calls to the response.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) HandleCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Error: only POST method is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	req := new(CallRequest)
+	if e := json.NewDecoder(http.MaxBytesReader(w, r.Body, SERVER_MAX_REQUEST)).Decode(req); e != nil {
+		var max_err *http.MaxBytesError
+		if errors.As(e, &max_err) {
+			http.Error(w, "Error: "+e.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Error: "+e.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if req.Reads1 == "" || req.Reads2 == "" {
+		http.Error(w, "Error: both reads1 and reads2 are required", http.StatusBadRequest)
+		return
+	}
+	if req.Sample == "" {
+		req.Sample = "sample"
+	}
+
+	start_time := time.Now()
+	var read_err error
+	read_reads := func(read_data chan *ReadInfo, read_pool *ReadPool) {
+		read_err = VC.ReadPairedReads(strings.NewReader(req.Reads1), strings.NewReader(req.Reads2), "reads1", "reads2", 0, read_data, read_pool)
+		close(read_data)
+	}
+	// The index is shared by requests, variant calls are not
+	req_vc := *VC
+	if ServerConcurrent() {
+		req_vc.InitVarCall()
+		req_vc.AlignReads(read_reads)
+		SERVER_MUT.Lock()
+	} else {
+		SERVER_MUT.Lock()
+		req_vc.InitVarCall()
+		req_vc.CallVariantsFrom(read_reads)
+	}
+	defer SERVER_MUT.Unlock()
+	if read_err != nil {
+		http.Error(w, "Error: "+read_err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	bw := bufio.NewWriter(w)
+	WriteVCFHeader(bw, req.Sample)
+	req_vc.WriteVarCalls(bw)
+	bw.Flush()
+	log.Printf("Time for processing request from %s:\t%s", r.RemoteAddr, time.Since(start_time))
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlignReads aligns reads and updates variant calls of a request with their aligned bases. Unlike
+// CallVariantsFrom, it neither resets nor logs statistics of the run, which are shared by requests.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AlignReads(read_reads func(chan *ReadInfo, *ReadPool)) {
+	read_data := make(chan *ReadInfo, READ_POOL_MAX)
+	read_pool := NewReadPool(PARA.Proc_num)
+	uar_info := make(chan *UnAlnReadInfo)
+	go read_reads(read_data, read_pool)
+	var wg sync.WaitGroup
+	for i := 0; i < PARA.Proc_num; i++ {
+		wg.Add(1)
+		go VC.SearchVariants(read_data, read_pool, uar_info, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(uar_info)
+	}()
+	// Unaligned reads are not used
+	for range uar_info {
+	}
+	VC.ApplyKeptBases()
+}
@@ -0,0 +1,226 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: sex.go
+// Sex-aware ploidy of sex chromosomes. For male samples (given, or inferred from depth of aligned reads
+// on chrX and chrY relative to autosomes), chrX and chrY outside pseudoautosomal regions (PARs) are
+// called with haploid genotypes: heterozygous genotypes get prior 0, and genotypes are written with
+// one allele. PARs of GRCh37 and GRCh38 are recognized by the length of chrX.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	SEX_FEMALE  = "female"
+	SEX_MALE    = "male"
+	SEX_AUTO    = "auto"
+	SEX_X_RATIO = 0.75 // maximum ratio of depth on chrX to autosomal depth of male samples
+	SEX_Y_RATIO = 0.1  // minimum ratio of depth on chrY to autosomal depth of male samples
+	PAR_LEN_TOL = 10   // tolerance of lengths of chrX for recognizing genome builds
+)
+
+//---------------------------------------------------------------------------------------------------
+// ParBuild represents PARs (1-based, inclusive) of chrX and chrY of a genome build.
+//---------------------------------------------------------------------------------------------------
+type ParBuild struct {
+	Name       string
+	XLen       int      // length of chrX
+	XPar, YPar [][2]int // PARs of chrX and chrY
+}
+
+// PARs of known genome builds
+var PAR_BUILDS = []ParBuild{
+	{"GRCh38", 156040895, [][2]int{{10001, 2781479}, {155701383, 156030895}}, [][2]int{{10001, 2781479}, {56887903, 57217415}}},
+	{"GRCh37", 155270560, [][2]int{{60001, 2699520}, {154931044, 155260560}}, [][2]int{{10001, 2649520}, {59034050, 59363566}}},
+}
+
+// Sorted intervals [start, end) of haploid positions on the multigenome (nil: diploid genome-wide)
+var HAPLOID_REGIONS [][2]int
+
+//---------------------------------------------------------------------------------------------------
+// CheckSex checks if the sex of the sample is valid.
+//---------------------------------------------------------------------------------------------------
+func CheckSex(sex string) {
+	switch sex {
+	case "", SEX_FEMALE, SEX_MALE, SEX_AUTO:
+	default:
+		log.Panicf("Error: unknown sex %s (supported values: female, male, auto)", sex)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// SexChr returns "X" or "Y" for sex chromosomes (names with or without the prefix "chr"), and the
+// empty string for other chromosomes.
+//---------------------------------------------------------------------------------------------------
+func SexChr(chr_name string) string {
+	name := strings.ToUpper(strings.TrimPrefix(chr_name, "chr"))
+	if name == "X" || name == "Y" {
+		return name
+	}
+	return ""
+}
+
+//---------------------------------------------------------------------------------------------------
+// SexChrRegions returns intervals of chrX and chrY outside PARs on the multigenome, grouped by sex
+// chromosomes. Whole chromosomes are used if the genome build is not recognized.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SexChrRegions() map[string][][2]int {
+	chr_id := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		if s := SexChr(string(chr_name)); s != "" {
+			chr_id[s] = i
+		}
+	}
+	chr_len := func(i int) int {
+		if i+1 < len(VC.ChrPos) {
+			return VC.ChrPos[i+1] - VC.ChrPos[i]
+		}
+		return VC.SeqLen - VC.ChrPos[i]
+	}
+	var build *ParBuild
+	if x, ok := chr_id["X"]; ok {
+		for i := range PAR_BUILDS {
+			if d := chr_len(x) - PAR_BUILDS[i].XLen; d >= -PAR_LEN_TOL && d <= PAR_LEN_TOL {
+				build = &PAR_BUILDS[i]
+			}
+		}
+		if build != nil {
+			log.Printf("PARs of sex chromosomes of %s are used.", build.Name)
+		} else {
+			log.Printf("Genome build is not recognized from the length of chrX, sex chromosomes are used without PARs.")
+		}
+	}
+	regions := make(map[string][][2]int)
+	for s, i := range chr_id {
+		start, end := VC.ChrPos[i], VC.ChrPos[i]+chr_len(i)
+		var pars [][2]int
+		if build != nil {
+			pars = build.XPar
+			if s == "Y" {
+				pars = build.YPar
+			}
+		}
+		for _, par := range pars {
+			par_start, par_end := MinInt(VC.ChrPos[i]+par[0]-1, end), MinInt(VC.ChrPos[i]+par[1], end)
+			if par_start > start {
+				regions[s] = append(regions[s], [2]int{start, par_start})
+			}
+			start = MaxInt(start, par_end)
+		}
+		if start < end {
+			regions[s] = append(regions[s], [2]int{start, end})
+		}
+	}
+	return regions
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupPloidy sets up haploid regions from the sex of the sample (inferred if required).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SetupPloidy() {
+	HAPLOID_REGIONS = nil
+	if PARA.Sex == "" || PARA.Sex == SEX_FEMALE {
+		SUMMARY.Sex = PARA.Sex
+		return
+	}
+	regions := VC.SexChrRegions()
+	sex := PARA.Sex
+	if sex == SEX_AUTO {
+		sex = VC.InferSex(regions)
+	}
+	SUMMARY.Sex = sex
+	if sex != SEX_MALE {
+		return
+	}
+	HAPLOID_REGIONS = append(regions["X"], regions["Y"]...)
+	sort.Slice(HAPLOID_REGIONS, func(i, j int) bool { return HAPLOID_REGIONS[i][0] < HAPLOID_REGIONS[j][0] })
+	log.Printf("Sex chromosomes outside PARs (%d regions) are called with haploid genotypes.", len(HAPLOID_REGIONS))
+}
+
+//---------------------------------------------------------------------------------------------------
+// InferSex infers the sex of the sample from mean depth of aligned reads at known variant locations
+// of chrX and chrY (outside PARs) relative to autosomes. The sample is female if the depth ratio of
+// chrX is at least SEX_X_RATIO and the depth ratio of chrY is below SEX_Y_RATIO, male if both are the
+// other way; the sex is unknown (diploid model) otherwise.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) InferSex(regions map[string][][2]int) string {
+	class := func(pos int) string {
+		for s, regs := range regions {
+			i := sort.Search(len(regs), func(i int) bool { return regs[i][1] > pos })
+			if i < len(regs) && regs[i][0] <= pos {
+				return s
+			}
+		}
+		chr_name, _ := VC.ChrLoc(pos)
+		if SexChr(chr_name) != "" {
+			return "" // PARs
+		}
+		return "A"
+	}
+	site_num, read_num := make(map[string]float64), make(map[string]float64)
+	for var_pos, _ := range VC.Variants {
+		site_num[class(var_pos)]++
+	}
+	for rid := 0; rid < PARA.Proc_num; rid++ {
+		VC.Calls[rid].Sites.EachAligned(func(pos uint32, site *CallSite) {
+			if _, is_known_var := VC.Variants[int(pos)]; !is_known_var {
+				return
+			}
+			c := class(int(pos))
+			for _, n := range site.RNum.Vals {
+				read_num[c] += float64(n)
+			}
+		})
+	}
+	if site_num["A"] == 0 || read_num["A"] == 0 || site_num["X"] == 0 {
+		log.Printf("Sex of the sample cannot be inferred (no aligned reads at known variant locations of autosomes or chrX), diploid genotypes are used.")
+		return ""
+	}
+	depth_a := read_num["A"] / site_num["A"]
+	x_ratio := read_num["X"] / site_num["X"] / depth_a
+	y_ratio := 0.0
+	if site_num["Y"] > 0 {
+		y_ratio = read_num["Y"] / site_num["Y"] / depth_a
+	}
+	log.Printf("Depth ratios of chrX and chrY to autosomes (at known variant locations):\t%g\t%g", x_ratio, y_ratio)
+	sex := ""
+	if x_ratio >= SEX_X_RATIO && y_ratio < SEX_Y_RATIO {
+		sex = SEX_FEMALE
+	} else if x_ratio < SEX_X_RATIO && y_ratio >= SEX_Y_RATIO {
+		sex = SEX_MALE
+	}
+	if sex == "" {
+		log.Printf("Sex of the sample cannot be inferred (depth ratios are inconsistent), diploid genotypes are used.")
+	} else {
+		log.Printf("Inferred sex of the sample:\t%s", sex)
+	}
+	return sex
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsHaploid checks if a position of the multigenome is called with haploid genotypes.
+//---------------------------------------------------------------------------------------------------
+func IsHaploid(pos int) bool {
+	i := sort.Search(len(HAPLOID_REGIONS), func(i int) bool { return HAPLOID_REGIONS[i][1] > pos })
+	return i < len(HAPLOID_REGIONS) && HAPLOID_REGIONS[i][0] <= pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// HaploidLikes returns likelihoods of haploid genotypes REF and ALT from likelihoods of diploid
+// genotypes REF/REF, REF/ALT and ALT/ALT.
+//---------------------------------------------------------------------------------------------------
+func HaploidLikes(likes []float64) []float64 {
+	return []float64{likes[0], likes[2]}
+}
+
+//---------------------------------------------------------------------------------------------------
+// HaploidGT returns the haploid genotype of a diploid genotype string ("0/0" -> "0", "1/1" -> "1").
+//---------------------------------------------------------------------------------------------------
+func HaploidGT(gt string) string {
+	return gt[:1]
+}
@@ -0,0 +1,86 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: shard.go
+// Region-sharded execution. The multigenome is split into shards of equal length, and each process
+// (e.g. on a node of a cluster) aligns all reads but only accepts alignments of read pairs whose best
+// position (leftmost aligned position of the pair) falls into its shard. Each read pair is thus counted
+// by exactly one shard; variant call states of shards (-save-state) are combined by the merge
+// subcommand, which serves as the coordinator.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// ShardInfo represents the shard of the multigenome handled by the process.
+//---------------------------------------------------------------------------------------------------
+type ShardInfo struct {
+	ID  int // index of the shard (1-based)
+	Num int // number of shards
+}
+
+// Shard of the current process (nil: sharding is off)
+var SHARD *ShardInfo
+
+//---------------------------------------------------------------------------------------------------
+// SetupShard sets up the shard of the process from a string "k/n" (the k-th of n shards).
+//---------------------------------------------------------------------------------------------------
+func SetupShard(shard_str string) {
+	SHARD = nil
+	if shard_str == "" {
+		return
+	}
+	parts := strings.Split(shard_str, "/")
+	if len(parts) != 2 {
+		log.Panicf("Error: invalid shard %s (format: k/n)", shard_str)
+	}
+	id, e1 := strconv.Atoi(parts[0])
+	num, e2 := strconv.Atoi(parts[1])
+	if e1 != nil || e2 != nil || num < 1 || id < 1 || id > num {
+		log.Panicf("Error: invalid shard %s (format: k/n, 1 <= k <= n)", shard_str)
+	}
+	SHARD = &ShardInfo{ID: id, Num: num}
+	log.Printf("Sharded execution:\tshard %d of %d", id, num)
+}
+
+//---------------------------------------------------------------------------------------------------
+// InShard checks if a position of the multigenome falls into the shard of the process.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) InShard(pos int) bool {
+	if SHARD == nil {
+		return true
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	return int(int64(pos)*int64(SHARD.Num)/int64(VC.SeqLen))+1 == SHARD.ID
+}
+
+//---------------------------------------------------------------------------------------------------
+// CheckShards checks if variant call states of shards cover all shards exactly once.
+//---------------------------------------------------------------------------------------------------
+func CheckShards(states []*VarCallState) {
+	shard_num, seen := 0, make(map[int]int)
+	for _, S := range states {
+		if S.ShardNum == 0 {
+			continue
+		}
+		if shard_num != 0 && S.ShardNum != shard_num {
+			log.Panicf("Error: variant call states are created with different numbers of shards (%d, %d)", shard_num, S.ShardNum)
+		}
+		shard_num = S.ShardNum
+		seen[S.Shard]++
+	}
+	for id := 1; id <= shard_num; id++ {
+		if seen[id] == 0 {
+			log.Printf("Warning: variant call state of shard %d of %d is missing.", id, shard_num)
+		} else if seen[id] > 1 {
+			log.Printf("Warning: variant call state of shard %d of %d is given %d times.", id, shard_num, seen[id])
+		}
+	}
+}
@@ -9,6 +9,7 @@ package ivc
 import (
 	"bufio"
 	"bytes"
+	"hash/fnv"
 	"log"
 	"os"
 	"path"
@@ -18,27 +19,69 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/namsyvo/IVC/align"
+	"github.com/namsyvo/IVC/index"
+	"github.com/namsyvo/IVC/seqio"
 )
 
 //--------------------------------------------------------------------------------------------------
 // Global constants
 //--------------------------------------------------------------------------------------------------
 const (
-	NEW_SNP_RATE   = 0.001  // probability of new alleles
-	NEW_INDEL_RATE = 0.0001 // probability of new indels
+	MAX_LINE_SIZE     = 1 << 26 // maximum size of a line in read files (long reads can be tens of kilobases)
+	PEEK_READ_NUM     = 1000    // number of reads used to determine read length and header length
+	OUTPUT_REGION_LEN = 1 << 20 // length of regions of the multigenome whose variant calls are formatted in parallel
+	OUTPUT_BUF_SIZE   = 1 << 16 // size of buffered writers of regions whose variant calls are formatted in parallel
+	OUTPUT_LINE_LEN   = 1024    // initial capacity of buffers of VCF records (grown for longer records)
+)
+
+//--------------------------------------------------------------------------------------------------
+// Novel variant rates (priors of novel SNPs and indels, see prior.go) and indel error rate.
+// Indel rates can be raised by presets for sequencing technologies with high indel error rates.
+//--------------------------------------------------------------------------------------------------
+var (
+	NEW_SNP_RATE   = 0.001  // probability of novel SNPs
+	NEW_INDEL_RATE = 0.0001 // probability of novel indels
 	INDEL_ERR_RATE = 0.0001 // probability of indel error
 )
 
+//--------------------------------------------------------------------------------------------------
+// Presets for long reads. Long reads are split into chunks of Chunk_len bases, two chunks which
+// are Chunk_gap bases apart are aligned as a pair of read-ends, with a band of Aln_band bases.
+//--------------------------------------------------------------------------------------------------
+type PresetInfo struct {
+	Chunk_len      int     // length of chunks which long reads are split into
+	Chunk_gap      int     // distance between two chunks of a pair
+	Aln_band       int     // band of the alignment DP around the diagonal of seeds
+	Min_slen       int     // minimum length of seeds
+	Max_slen       int     // maximum length of seeds
+	Dist_thres     float64 // threshold for distances between chunks and multigenomes
+	Sub_cost       float64 // cost of substitution
+	Gap_open       float64 // cost of gap open
+	Gap_ext        float64 // cost of gap extension
+	New_indel_rate float64 // probability of new indels
+	Indel_err_rate float64 // probability of indel error
+}
+
+var PRESETS = map[string]PresetInfo{
+	"pacbio": {Chunk_len: 150, Chunk_gap: 50, Aln_band: 32, Min_slen: 12, Max_slen: 20, Dist_thres: 60,
+		Sub_cost: 4, Gap_open: 2, Gap_ext: 1, New_indel_rate: 0.001, Indel_err_rate: 0.01},
+	"ont": {Chunk_len: 150, Chunk_gap: 50, Aln_band: 48, Min_slen: 11, Max_slen: 18, Dist_thres: 72,
+		Sub_cost: 4, Gap_open: 2, Gap_ext: 0.5, New_indel_rate: 0.001, Indel_err_rate: 0.03},
+}
+
 //--------------------------------------------------------------------------------------------------
 // Global variables for calculating variant quality.
 //--------------------------------------------------------------------------------------------------
 var (
-	PARA *ParaInfo        // all parameters of the program
-	L2E  []float64        // indel error rate corresponding to lengths of indels
-	Q2C  map[byte]float64 // alignment cost based on Phred-scale quality
-	Q2E  map[byte]float64 // error probability based on Phred-scale quality
-	Q2P  map[byte]float64 // non-error probability based on Phred-scale quality
-	MUT  = &sync.Mutex{}  // mutex lock for reading/writing from/to the map of variant calls
+	PARA *ParaInfo       // all parameters of the program
+	L2E  []float64       // indel error rate corresponding to lengths of indels
+	Q2C  [256]float64    // alignment cost based on Phred-scale quality
+	Q2E  [256]float64    // error probability based on Phred-scale quality
+	Q2P  [256]float64    // non-error probability based on Phred-scale quality
+	MUT  = &sync.Mutex{} // mutex lock for updating coverage of windows
 )
 
 //--------------------------------------------------------------------------------------------------
@@ -46,32 +89,112 @@ var (
 //--------------------------------------------------------------------------------------------------
 type ParaInfo struct {
 	//Input file names:
-	Ref_file       string // reference multigenome
-	Var_prof_file  string // variant profile
-	Index_file     string // index of original reference genomes
-	Rev_index_file string // index of reverse reference genomes
-	Read_file_1    string // first end of read
-	Read_file_2    string // second end of read
-	Var_call_file  string // store Var call
+	Genome_file        string // reference genome given in input (-R, index files are named after it)
+	Var_file           string // variant profile given in input (-V, index files are named after it)
+	Index_dir          string // index directory given in input (-I)
+	Ref_file           string // reference multigenome
+	Var_prof_file      string // variant profile
+	Index_file         string // index of original reference genomes
+	Rev_index_file     string // index of reverse reference genomes
+	Read_file_1        string // first end of read (comma-separated files for several FASTQ pairs)
+	Read_file_2        string // second end of read (comma-separated files for several FASTQ pairs)
+	Var_call_file      string // store Var call
+	Debug_file         string // file for writing evidence of variant calls (aligned bases and read info), optional
+	Cache_dir          string // directory for caching remote index files
+	Tmp_dir            string // directory for temporary directories of runs (intermediate files), the system temporary directory by default
+	Filter_file        string // file of hard-filter expressions (one per line), optional
+	Filter_expr        string // comma-separated hard-filter expressions (e.g. "LowQual:QUAL<20,DP<5"), optional
+	Context_file       string // context error table (see context.go), optional
+	Learn_context_file string // file for writing context error table learned from aligned reads, optional
+	Preset             string // preset for long reads ("ont" or "pacbio"), empty for short paired-end reads
 
 	// Input paras:
-	Search_mode int     // searching mode for finding seeds
-	Start_pos   int     // starting postion on reads for finding seeds
-	Search_step int     // step for searching in deterministic mode
-	Max_snum    int     // maximum number of seeds
-	Max_psnum   int     // maximum number of paired-seeds
-	Min_slen    int     // minimum length of seeds
-	Max_slen    int     // maximum length of seeds
-	Dist_thres  float64 // threshold for distances between reads and multigenomes
-	Iter_num    int     // number of random iterations to find proper alignments
-	Sub_cost    float64 // cost of substitution for Hamming and Edit distance
-	Gap_open    float64 // cost of gap open for Edit distance
-	Gap_ext     float64 // cost of gap extension for Edit distance
-	Proc_num    int     // maximum number of CPUs using by Go
-	Debug_mode  bool    // debug mode for output
+	Search_mode    int      // searching mode for finding seeds
+	Start_pos      int      // starting postion on reads for finding seeds
+	Search_step    int      // step for searching in deterministic mode
+	Max_snum       int      // maximum number of seeds
+	Max_psnum      int      // maximum number of paired-seeds
+	Min_slen       int      // minimum length of seeds
+	Max_slen       int      // maximum length of seeds
+	Dist_thres     float64  // threshold for distances between reads and multigenomes
+	Iter_num       int      // number of random iterations to find proper alignments
+	Sub_cost       float64  // cost of substitution for Hamming and Edit distance
+	Gap_open       float64  // cost of gap open for Edit distance
+	Gap_ext        float64  // cost of gap extension for Edit distance
+	New_snp_rate   float64  // prior probability of novel SNPs (0: default)
+	Ts_tv          float64  // Ts/Tv ratio of novel SNPs for priors and substitution costs (0: all substitutions are equally likely)
+	New_indel_rate float64  // prior probability of novel indels (0: default or preset value)
+	Indel_err_rate float64  // probability of indel sequencing errors (0: default or preset value)
+	Proc_num       int      // maximum number of CPUs using by Go
+	Auto_tune      bool     // tune the number of alignment goroutines and read buffering by profiling the first seconds of calling
+	Max_depth      int      // maximum number of aligned reads used at each position (0: the hard cap MAX_EVIDENCE_NUM)
+	Min_qual       float64  // minimum QUAL of written variant calls, calls below it are omitted (0: all calls)
+	All_sites      bool     // emit-all-sites mode: output homozygous-reference calls at all covered positions
+	Context_model  bool     // use context error model (homopolymer and dinucleotide contexts) with the default table
+	Realign        bool     // realign reads around candidate indels before updating variant probabilities
+	Assemble       bool     // assemble haplotypes of dense variant regions before updating variant probabilities
+	SV_file        string   // file for writing structural variant breakpoint candidates from unaligned reads
+	CNV_file       string   // file for writing copy number variant candidate regions from depth of aligned reads
+	Pileup_file    string   // file for writing pileup of observed bases and qualities of aligned reads
+	Bedgraph_file  string   // file for writing depth of aligned reads in BedGraph format
+	Summary_file   string   // file for writing the run summary in JSON format
+	Timing_file    string   // file for writing timing records of stages in JSON lines format
+	Dump_aln       string   // read pairs whose alignments are dumped (file of read names, or region chr:start-end)
+	Dump_file      string   // file for writing the alignment dump (variant call file with suffix .aln.txt by default)
+	Support_file   string   // file for writing names of reads supporting alleles of emitted calls
+	Calib_report   string   // file for writing the calibration report of QUAL of emitted calls
+	Calib_truth    string   // truth set of the calibration report (allele balance of calls is used if it is not given)
+	Calib_bed      string   // confident regions of the truth set of the calibration report
+	Unaligned_file string   // file for writing unaligned read pairs in FASTQ format (interleaved ends)
+	Trio           string   // samples of a trio "father,mother,child" for joint calling (trio mode)
+	Read_groups    []string // read groups of input FASTQ pairs ("ID:id,SM:sample,LB:library,PL:platform")
+	Primer_file    string   // BED file of amplicon primers (amplicon mode: primer trimming and calling in amplicon inserts)
+	Mask_file      string   // BED file of masked regions (blacklist)
+	Mask_mode      string   // handling of masked regions: drop (evidence is dropped) or filter (calls are filtered)
+	Qual_bins      int      // number of bins of base qualities (0: no binning)
+	No_gaps        bool     // no-gaps mode: reads are aligned with mismatches only, reads requiring gapped alignment are skipped
+	Read_cache     int      // maximum number of reads in the identical-read cache (0: no cache)
+	Multi_map      string   // multi-mapping policy for reads with tied best alignments (first, discard, random, fractional)
+	Seed           int64    // seed of random choices of reads for reproducible runs (0: taken from the current time)
+	AF_file        string   // population allele-frequency resource (VCF with AF in INFO, or table of chrom, pos, ref, alt, af) for priors at known variant locations
+	AF_weight      float64  // weight of population allele frequencies against allele frequencies of the variant profile in priors
+	Prof_trust     float64  // trust in allele frequencies of the variant profile: exponent of priors at known variant locations (1: as given, 0: flat)
+	BAQ            bool     // cap qualities of mismatches by base alignment qualities (BAQ) before updating variant probabilities
+	Gzip_output    bool     // gzip-compress variant calls and auxiliary reports on the fly
+	Per_contig     bool     // write variant calls of each contig to its own file
+	Merge_contigs  bool     // concatenate per-contig files into the variant call file at the end
+	Warm_up        int      // number of read pairs aligned in the warm-up phase for estimating the error rate (0: no estimation)
+	Two_pass       float64  // minimum QUAL of novel calls of the first pass added to known variants for the second pass (0: one pass)
+	Save_state     string   // file for saving the variant call state (sufficient statistics of aligned reads)
+	Load_state     string   // file of a variant call state which is loaded as the starting point of calling
+	Shard          string   // shard "k/n" of the multigenome handled by the run in sharded execution (empty: whole multigenome)
+	Aln_backend    string   // backend of the alignment DP (cpu, or a registered accelerated backend)
+	Prescreen      bool     // skip read pairs without k-mers of the multigenome before seeding
+	Contam         bool     // estimate the cross-sample contamination fraction from allele balances at homozygous known SNPs
+	Contam_adjust  bool     // adjust genotype likelihoods at known variant locations for the estimated contamination
+	Heteroplasmy   string   // comma-separated contigs called with the continuous allele-fraction model (e.g. chrM)
+	Sex            string   // sex of the sample (female, male, auto: inferred from depth on sex chromosomes; empty: diploid genome-wide)
+	Qual_seeds     bool     // quality-aware seeding: random start positions of seeds are weighted by base qualities
+	Mismatch_seeds bool     // mismatch-tolerant seeding: seeds which are too short are rescued by seeds with one mismatch
+	Alt_delta      float64  // maximum paired-distance delta from the best alignment of reported alternative alignments (0: not reported)
+	Merge_pairs    bool     // merge overlapping read pairs into consensus fragments before alignment
+	Mate_check     string   // mode of checking names of paired records of the two read files (error, warn, off)
+	Pair_orient    string   // expected orientation of the two ends of read pairs (fr, rf, ff)
+	Linked_reads   bool     // linked reads: barcodes (BX:Z: tags of comments of headers) resolve ambiguous reads and phase heterozygous calls
+	Mate_pair      bool     // mate-pair library: multi-kb inserts, paired-end read pairs in the opposite orientation, scored insert sizes
+	Cycle_err      bool     // estimate error rates of sequencing cycles in the warm-up phase and use them in alignment and variant probabilities
+	Skip_bad_reads bool     // skip malformed FASTQ records (resynchronizing at the next header) and end truncated read files at their last complete record
+	Seed_index     string   // index of seeds (fm: FM-index of the reverse multigenome, kmer: k-mer index of the multigenome)
+	Spliced        bool     // spliced mode for RNA-seq reads: read-ends can be aligned with reference skips at exon junctions
+	Dry_run        bool     // dry run: check inputs, estimate memory and print the plan of the run without processing reads
+	Debug_mode     bool     // debug mode for output
 
 	// Estimated paras:
-	Read_len        int     // read length, calculated from read files
+	Read_len        int     // read length, calculated from read files (chunk length for long reads), the larger of lengths of the two ends
+	Read_len_1      int     // maximum length of the first ends, calculated from first-end read files
+	Read_len_2      int     // maximum length of the second ends, calculated from second-end read files
+	Chunk_gap       int     // distance between two chunks of a long read which are aligned as a pair
+	Aln_band        int     // band of the alignment DP around the diagonal of seeds (0: not banded), for chunks of long reads
 	Info_len        int     // maximum size of array to store read headers
 	Max_ins         int     // maximum insert size of two aligned ends
 	Err_rate        float32 // average sequencing error rate, estmated from reads with real reads
@@ -82,6 +205,8 @@ type ParaInfo struct {
 	Seed_backup     int     // number of backup bases from seeds
 	Ham_backup      int     // number of backup bases from Hamming alignment
 	Indel_backup    int     // number of backup bases from known indels
+	Auto_dist_thres bool    // threshold of alignment distance is not given in input (it can be estimated)
+	Auto_iter_num   bool    // number of random iterations is not given in input (it can be estimated)
 }
 
 //--------------------------------------------------------------------------------------------------
@@ -91,40 +216,79 @@ func Setup(input_para *ParaInfo) {
 
 	log.Printf("----------------------------------------------------------------------------------------")
 	log.Printf("Checking input information and seting up parameters...")
+	start_time := time.Now()
 
-	//Check input files
-	var f *os.File
 	var e error
-	if _, e = os.Stat(input_para.Ref_file); e != nil {
-		log.Panicf("Error: %s", e)
+	// Intermediate files are written to the temporary directory of the run
+	SetupTmpDir(input_para.Tmp_dir)
+	//Check input files
+	CheckSetupInput(input_para)
+	TIMING = nil
+	if input_para.Timing_file != "" {
+		TIMING = CreateTimingLog(input_para.Timing_file)
 	}
-	if _, e = os.Stat(input_para.Var_prof_file); e != nil {
-		log.Panicf("Error: %s", e)
+	// Index files are loaded while setting up other parameters
+	LOADER = StartLoading(input_para.Ref_file, input_para.Var_prof_file, input_para.Rev_index_file, input_para.Seed_index)
+	// Random choices of each read are derived from the seed and the read header (see ReadSeed), the seed
+	// of a run without a given seed is taken from the current time
+	if input_para.Seed == 0 {
+		input_para.Seed = time.Now().UnixNano()
 	}
-	if _, e = os.Stat(input_para.Rev_index_file); e != nil {
-		log.Panicf("Error: %s", e)
+	PARA = SetupPara(input_para)
+	// Variant calls and auxiliary reports are gzip-compressed in compressed-output mode
+	PARA.Var_call_file = OutputName(PARA.Var_call_file, PARA.Gzip_output)
+	PARA.SV_file, PARA.CNV_file = OutputName(PARA.SV_file, PARA.Gzip_output), OutputName(PARA.CNV_file, PARA.Gzip_output)
+	PARA.Pileup_file, PARA.Bedgraph_file = OutputName(PARA.Pileup_file, PARA.Gzip_output), OutputName(PARA.Bedgraph_file, PARA.Gzip_output)
+	PARA.Unaligned_file = OutputName(PARA.Unaligned_file, PARA.Gzip_output)
+	// Alignments of selected reads are dumped next to variant calls by default
+	SetupAlnDump(PARA.Dump_aln)
+	if ALN_DUMP != nil && PARA.Dump_file == "" {
+		base_name := strings.TrimSuffix(PARA.Var_call_file, ".gz")
+		PARA.Dump_file = strings.TrimSuffix(base_name, path.Ext(base_name)) + ".aln.txt"
 	}
-	if _, e = os.Stat(input_para.Read_file_1); e != nil {
-		log.Panicf("Error: %s", e)
+	PARA.Dump_file = OutputName(PARA.Dump_file, PARA.Gzip_output)
+	PARA.Support_file = OutputName(PARA.Support_file, PARA.Gzip_output)
+	FILTERS = SetupFilters(PARA.Filter_expr, PARA.Filter_file)
+	SetupReadGroups(PARA.Read_groups, len(ReadFiles(PARA.Read_file_1)))
+	SetupBarcodes(PARA.Linked_reads)
+	SetupTrio(PARA.Trio)
+	if TRIO != nil && PARA.Sex != "" {
+		log.Panicf("Error: sex of the sample is not supported in trio mode")
 	}
-	if _, e = os.Stat(input_para.Read_file_2); e != nil {
-		log.Panicf("Error: %s", e)
+	SetupShard(PARA.Shard)
+	SetupAlnBackend(PARA.Aln_backend)
+	if PARA.Context_file != "" {
+		CONTEXT = LoadContextModel(PARA.Context_file)
+		PARA.Context_model = true
+	} else if PARA.Context_model {
+		CONTEXT = DefaultContextModel()
 	}
-	PARA = SetupPara(input_para)
+	CYCLE = nil // estimated in the warm-up phase (-cycle-err)
 
 	if PARA.Debug_mode {
+		// Profiles are written next to variant calls, or to the working directory in server mode
+		prof_file := PARA.Var_call_file
+		if prof_file == "" {
+			prof_file = "ivc-serve"
+		}
 		MEM_STATS = new(runtime.MemStats)
-		if CPU_FILE, e = os.Create(PARA.Var_call_file + ".cprof"); e != nil {
+		if CPU_FILE, e = os.Create(prof_file + ".cprof"); e != nil {
 			log.Panicf("Error: %s", e)
 		}
 		pprof.StartCPUProfile(CPU_FILE)
 
-		if MEM_FILE, e = os.Create(PARA.Var_call_file + ".mprof"); e != nil {
+		if MEM_FILE, e = os.Create(prof_file + ".mprof"); e != nil {
 			log.Panicf("Error: %s", e)
 		}
-		log.Printf("Debug mode:\tCpu_prof_file: %s, Mem_prof_file: %s", PARA.Var_call_file+".cprof", PARA.Var_call_file+".mprof")
+		log.Printf("Debug mode:\tCpu_prof_file: %s, Mem_prof_file: %s", prof_file+".cprof", prof_file+".mprof")
 	}
 
+	// There is no output file in server mode, variant calls are written to responses
+	if PARA.Var_call_file == "" {
+		SUMMARY.AddStageTime("setup", time.Since(start_time))
+		log.Printf("Finish checking input information and seting up parameters.")
+		return
+	}
 	result_dir := path.Dir(PARA.Var_call_file)
 	if _, e = os.Stat(result_dir); e != nil {
 		if os.IsNotExist(e) {
@@ -135,41 +299,150 @@ func Setup(input_para *ParaInfo) {
 			log.Panicf("Error: %s", e)
 		}
 	}
-    if f, e = os.Create(PARA.Var_call_file); e != nil {
+	w := CreateOutput(PARA.Var_call_file)
+	base_file_name := strings.TrimSuffix(path.Base(PARA.Var_call_file), ".gz")
+	WriteVCFHeader(w.Writer, strings.TrimSuffix(base_file_name, path.Ext(base_file_name)))
+	w.Close()
+
+	SUMMARY.AddStageTime("setup", time.Since(start_time))
+	log.Printf("Finish checking input information and seting up parameters.")
+}
+
+//--------------------------------------------------------------------------------------------------
+// CheckSetupInput checks input files (remote files are cached or resolved first) and combinations of
+// options which are not supported together.
+//--------------------------------------------------------------------------------------------------
+func CheckSetupInput(input_para *ParaInfo) {
+	var e error
+	// Remote index files are downloaded to the cache directory, remote reads are streamed
+	if input_para.Cache_dir == "" {
+		input_para.Cache_dir = filepath.Join(os.TempDir(), "ivc-cache")
+	}
+	// Names of sidecar files of a remote reference are derived from its URL, not from its cached file
+	remote_ref_file := input_para.Ref_file
+	CacheRemoteFile(remote_ref_file+".idx", input_para.Cache_dir)
+	input_para.Ref_file = CacheRemoteFile(remote_ref_file, input_para.Cache_dir)
+	input_para.Var_prof_file = CacheRemoteFile(input_para.Var_prof_file, input_para.Cache_dir)
+	CheckSeedIndex(input_para.Seed_index)
+	if input_para.Seed_index == SEED_INDEX_KMER {
+		CacheRemoteFile(index.KmerIndexFile(remote_ref_file), input_para.Cache_dir)
+	} else {
+		input_para.Rev_index_file = CacheRemoteIndex(input_para.Rev_index_file, input_para.Cache_dir)
+	}
+	input_para.Read_file_1, input_para.Read_file_2 = ResolveHtsgetReads(input_para.Read_file_1, input_para.Read_file_2, input_para.Cache_dir)
+	if _, e = os.Stat(CompressedName(input_para.Ref_file)); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if _, e = os.Stat(CompressedName(input_para.Var_prof_file)); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if input_para.Seed_index == SEED_INDEX_KMER {
+		if _, e = os.Stat(index.KmerIndexFile(input_para.Ref_file)); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+		if input_para.Mismatch_seeds {
+			log.Panicf("Error: mismatch-tolerant seeding is only supported with the FM-index")
+		}
+	} else if _, e = os.Stat(input_para.Rev_index_file); e != nil {
 		log.Panicf("Error: %s", e)
 	}
-	w := bufio.NewWriter(f)
+	for _, read_file := range ReadFiles(input_para.Read_file_1) {
+		if _, e = os.Stat(read_file); e != nil && !IsRemote(read_file) {
+			log.Panicf("Error: %s", e)
+		}
+	}
+	if input_para.Preset == "" {
+		for _, read_file := range ReadFiles(input_para.Read_file_2) {
+			if _, e = os.Stat(read_file); e != nil && !IsRemote(read_file) {
+				log.Panicf("Error: %s", e)
+			}
+		}
+		if len(ReadFiles(input_para.Read_file_1)) != len(ReadFiles(input_para.Read_file_2)) {
+			log.Panicf("Error: numbers of first-end and second-end read files are different")
+		}
+	} else if len(ReadFiles(input_para.Read_file_1)) > 1 {
+		log.Panicf("Error: only one read file is supported with presets")
+	} else if _, ok := PRESETS[input_para.Preset]; !ok {
+		log.Panicf("Error: unknown preset %s (supported presets: ont, pacbio)", input_para.Preset)
+	}
+	CheckMultiMap(input_para.Multi_map)
+	CheckSex(input_para.Sex)
+	CheckMateCheck(input_para.Mate_check)
+	CheckPairOrient(input_para.Pair_orient)
+	if input_para.Pair_orient != PAIR_ORIENT_FR && (input_para.Preset != "" || input_para.Merge_pairs) {
+		log.Panicf("Error: orientation %s of read pairs is not supported with presets or merging read pairs", input_para.Pair_orient)
+	}
+	if input_para.Mate_pair && input_para.Preset != "" {
+		log.Panicf("Error: mate-pair libraries are not supported with presets")
+	}
+	if input_para.Cycle_err && input_para.Preset != "" {
+		log.Panicf("Error: per-cycle error rates are not supported with presets")
+	}
+}
+
+//--------------------------------------------------------------------------------------------------
+// WriteVCFHeader writes meta-information lines and the header line of variant call output.
+//--------------------------------------------------------------------------------------------------
+func WriteVCFHeader(w *bufio.Writer, sample string) {
 	w.WriteString("##fileformat=VCFv4.2\n")
 	w.WriteString("##INFO=<ID=KV,Number=0,Type=Flag,Description=\"Known variants (from input)\">\n")
 	w.WriteString("##INFO=<ID=VP,Number=0,Type=Flag,Description=\"Probability of variants\">\n")
 	w.WriteString("##INFO=<ID=MP,Number=0,Type=Flag,Description=\"Probablility of mapping\">\n")
 	w.WriteString("##INFO=<ID=CP,Number=0,Type=Flag,Description=\"Combination probability of mapping and variants\">\n")
+	w.WriteString("##INFO=<ID=MQ,Number=1,Type=Float,Description=\"RMS mapping quality\">\n")
+	w.WriteString("##INFO=<ID=QD,Number=1,Type=Float,Description=\"Variant confidence (QUAL) divided by depth of aligned reads\">\n")
+	w.WriteString("##INFO=<ID=BaseQRankSum,Number=1,Type=Float,Description=\"Z-score from Wilcoxon rank sum test of alt vs. ref base qualities\">\n")
+	w.WriteString("##INFO=<ID=ReadPosRankSum,Number=1,Type=Float,Description=\"Z-score from Wilcoxon rank sum test of alt vs. ref read position bias\">\n")
+	w.WriteString("##INFO=<ID=FS,Number=1,Type=Float,Description=\"Phred-scaled p-value of Fisher's exact test of strand bias\">\n")
+	w.WriteString("##INFO=<ID=DS,Number=0,Type=Flag,Description=\"Aligned reads were downsampled (more reads than the cap of reads used at the position)\">\n")
+	if TRIO != nil {
+		w.WriteString("##INFO=<ID=MV,Number=0,Type=Flag,Description=\"Mendelian violation of independently called genotypes of the trio\">\n")
+		w.WriteString("##INFO=<ID=DN,Number=0,Type=Flag,Description=\"De novo candidate of the child\">\n")
+		w.WriteString("##INFO=<ID=DNP,Number=1,Type=Float,Description=\"Posterior probability of a de novo mutation of the child\">\n")
+	}
+	if PARA.All_sites && PARA.Heteroplasmy == "" {
+		w.WriteString("##INFO=<ID=DP,Number=1,Type=Integer,Description=\"Depth of aligned reads at homozygous-reference sites\">\n")
+	}
+	if PARA.Heteroplasmy != "" {
+		w.WriteString("##INFO=<ID=DP,Number=1,Type=Integer,Description=\"Depth of aligned reads at homozygous-reference sites and allele-fraction calls\">\n")
+		w.WriteString("##INFO=<ID=HP,Number=0,Type=Flag,Description=\"Call of the continuous allele-fraction (heteroplasmy) model\">\n")
+	}
+	for _, filter := range FILTERS {
+		w.WriteString("##FILTER=<ID=" + filter.Name + ",Description=\"" + filter.Expr + "\">\n")
+	}
+	if PARA.Mask_file != "" && PARA.Mask_mode == MASK_MODE_FILTER {
+		w.WriteString("##FILTER=<ID=" + MASK_FILTER + ",Description=\"Within masked regions\">\n")
+	}
 	w.WriteString("##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n")
 	w.WriteString("##FORMAT=<ID=GQ,Number=1,Type=Integer,Description=\"Genotype Quality\">\n")
 	w.WriteString("##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Allelic depths for the ref and alt alleles in the order listed\">\n")
 	w.WriteString("##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Approximate read depth\">\n")
+	w.WriteString("##FORMAT=<ID=ADF,Number=R,Type=Integer,Description=\"Allelic depths on the forward strand for the ref and alt alleles\">\n")
+	w.WriteString("##FORMAT=<ID=ADR,Number=R,Type=Integer,Description=\"Allelic depths on the reverse strand for the ref and alt alleles\">\n")
+	w.WriteString("##FORMAT=<ID=SB,Number=4,Type=Integer,Description=\"Per-strand allelic depths: ref forward, ref reverse, alt forward, alt reverse\">\n")
+	w.WriteString("##FORMAT=<ID=PL,Number=G,Type=Integer,Description=\"Normalized, Phred-scaled likelihoods for genotypes as defined in the VCF specification\">\n")
+	if PARA.Heteroplasmy != "" {
+		w.WriteString("##FORMAT=<ID=AF,Number=A,Type=Float,Description=\"Fraction of aligned reads of the alt allele\">\n")
+		w.WriteString("##FORMAT=<ID=AFCI,Number=2,Type=Float,Description=\"95% confidence interval of the fraction of aligned reads of the alt allele\">\n")
+	}
+	if BARCODES != nil {
+		w.WriteString("##FORMAT=<ID=PS,Number=1,Type=Integer,Description=\"Phase set of the phased genotype (position of the first call of its phase block, phased by barcodes of linked reads)\">\n")
+	}
 	w.WriteString("##IVCCommandLine=<" + strings.Join(os.Args, " ") + ">\n")
 	ref_file, _ := filepath.Abs(PARA.Ref_file)
 	var_prof_file, _ := filepath.Abs(PARA.Var_prof_file)
 	index_file, _ := filepath.Abs(PARA.Rev_index_file)
-	read_file_1, _ := filepath.Abs(PARA.Read_file_1)
-	read_file_2, _ := filepath.Abs(PARA.Read_file_2)
+	read_file_1, read_file_2 := AbsFiles(PARA.Read_file_1), AbsFiles(PARA.Read_file_2)
 	var_call_file, _ := filepath.Abs(PARA.Var_call_file)
-	w.WriteString("##IVCFullParameters=<Ref_file=" + ref_file + ", Var_prof_file=" + var_prof_file + ", Index_dir=" + index_file + ", Read_file_1=" + read_file_1 + ", Read_file_2=" + read_file_2 + ", Var_call_file=" + var_call_file + ", Dist_thres=" + strconv.FormatFloat(PARA.Dist_thres, 'f', 1, 64) + ", Proc_num=" + strconv.Itoa(PARA.Proc_num) + ", Iter_num=" + strconv.Itoa(PARA.Iter_num) + ", Sub_cost=" + strconv.FormatFloat(PARA.Sub_cost, 'f', 1, 64) + ", Gap_open=" + strconv.FormatFloat(PARA.Gap_open, 'f', 1, 64) + ", Gap_ext=" + strconv.FormatFloat(PARA.Gap_ext, 'f', 1, 64) + ", Search_mode=" + strconv.Itoa(PARA.Search_mode) + ", Start_pos=" + strconv.Itoa(PARA.Start_pos) + ", Search_step=" + strconv.Itoa(PARA.Search_step) + ", Max_snum=" + strconv.Itoa(PARA.Max_snum) + ", Max_psnum=" + strconv.Itoa(PARA.Max_psnum) + ", Min_slen=" + strconv.Itoa(PARA.Min_slen) + ", Max_slen=" + strconv.Itoa(PARA.Max_slen) + ", Debug_mode=" + strconv.FormatBool(PARA.Debug_mode) + ">\n")
+	w.WriteString("##IVCFullParameters=<Ref_file=" + ref_file + ", Var_prof_file=" + var_prof_file + ", Index_dir=" + index_file + ", Read_file_1=" + read_file_1 + ", Read_file_2=" + read_file_2 + ", Var_call_file=" + var_call_file + ", Preset=" + PARA.Preset + ", Dist_thres=" + strconv.FormatFloat(PARA.Dist_thres, 'f', 1, 64) + ", Proc_num=" + strconv.Itoa(PARA.Proc_num) + ", Auto_tune=" + strconv.FormatBool(PARA.Auto_tune) + ", Iter_num=" + strconv.Itoa(PARA.Iter_num) + ", Sub_cost=" + strconv.FormatFloat(PARA.Sub_cost, 'f', 1, 64) + ", Gap_open=" + strconv.FormatFloat(PARA.Gap_open, 'f', 1, 64) + ", Gap_ext=" + strconv.FormatFloat(PARA.Gap_ext, 'f', 1, 64) + ", New_snp_rate=" + strconv.FormatFloat(PARA.New_snp_rate, 'g', -1, 64) + ", Ts_tv=" + strconv.FormatFloat(PARA.Ts_tv, 'g', -1, 64) + ", New_indel_rate=" + strconv.FormatFloat(PARA.New_indel_rate, 'g', -1, 64) + ", Indel_err_rate=" + strconv.FormatFloat(PARA.Indel_err_rate, 'g', -1, 64) + ", Search_mode=" + strconv.Itoa(PARA.Search_mode) + ", Start_pos=" + strconv.Itoa(PARA.Start_pos) + ", Search_step=" + strconv.Itoa(PARA.Search_step) + ", Max_snum=" + strconv.Itoa(PARA.Max_snum) + ", Max_psnum=" + strconv.Itoa(PARA.Max_psnum) + ", Min_slen=" + strconv.Itoa(PARA.Min_slen) + ", Max_slen=" + strconv.Itoa(PARA.Max_slen) + ", Max_depth=" + strconv.Itoa(PARA.Max_depth) + ", Min_qual=" + strconv.FormatFloat(PARA.Min_qual, 'g', -1, 64) + ", All_sites=" + strconv.FormatBool(PARA.All_sites) + ", Context_model=" + strconv.FormatBool(PARA.Context_model) + ", Realign=" + strconv.FormatBool(PARA.Realign) + ", Assemble=" + strconv.FormatBool(PARA.Assemble) + ", Qual_bins=" + strconv.Itoa(PARA.Qual_bins) + ", No_gaps=" + strconv.FormatBool(PARA.No_gaps) + ", Read_cache=" + strconv.Itoa(PARA.Read_cache) + ", Multi_map=" + PARA.Multi_map + ", Seed=" + strconv.FormatInt(PARA.Seed, 10) + ", Summary_file=" + PARA.Summary_file + ", Unaligned_file=" + PARA.Unaligned_file + ", Trio=" + PARA.Trio + ", AF_file=" + PARA.AF_file + ", AF_weight=" + strconv.FormatFloat(PARA.AF_weight, 'g', -1, 64) + ", Prof_trust=" + strconv.FormatFloat(PARA.Prof_trust, 'g', -1, 64) + ", BAQ=" + strconv.FormatBool(PARA.BAQ) + ", Gzip_output=" + strconv.FormatBool(PARA.Gzip_output) + ", Per_contig=" + strconv.FormatBool(PARA.Per_contig) + ", Merge_contigs=" + strconv.FormatBool(PARA.Merge_contigs) + ", Warm_up=" + strconv.Itoa(PARA.Warm_up) + ", Two_pass=" + strconv.FormatFloat(PARA.Two_pass, 'g', -1, 64) + ", Save_state=" + PARA.Save_state + ", Load_state=" + PARA.Load_state + ", Shard=" + PARA.Shard + ", Aln_backend=" + PARA.Aln_backend + ", Prescreen=" + strconv.FormatBool(PARA.Prescreen) + ", Contam=" + strconv.FormatBool(PARA.Contam) + ", Contam_adjust=" + strconv.FormatBool(PARA.Contam_adjust) + ", Sex=" + PARA.Sex + ", Heteroplasmy=" + PARA.Heteroplasmy + ", Qual_seeds=" + strconv.FormatBool(PARA.Qual_seeds) + ", Mismatch_seeds=" + strconv.FormatBool(PARA.Mismatch_seeds) + ", Alt_delta=" + strconv.FormatFloat(PARA.Alt_delta, 'g', -1, 64) + ", Merge_pairs=" + strconv.FormatBool(PARA.Merge_pairs) + ", Mate_check=" + PARA.Mate_check + ", Pair_orient=" + PARA.Pair_orient + ", Mate_pair=" + strconv.FormatBool(PARA.Mate_pair) + ", Cycle_err=" + strconv.FormatBool(PARA.Cycle_err) + ", Linked_reads=" + strconv.FormatBool(PARA.Linked_reads) + ", Skip_bad_reads=" + strconv.FormatBool(PARA.Skip_bad_reads) + ", Seed_index=" + PARA.Seed_index + ", Timing_file=" + PARA.Timing_file + ", Dump_aln=" + PARA.Dump_aln + ", Support_file=" + PARA.Support_file + ", Calib_report=" + PARA.Calib_report + ", Calib_truth=" + PARA.Calib_truth + ", Calib_bed=" + PARA.Calib_bed + ", Mask_file=" + PARA.Mask_file + ", Mask_mode=" + PARA.Mask_mode + ", Spliced=" + strconv.FormatBool(PARA.Spliced) + ", Debug_mode=" + strconv.FormatBool(PARA.Debug_mode) + ">\n")
 	w.WriteString("##reference=file://" + ref_file + "\n")
-	
-	base_file_name := path.Base(PARA.Var_call_file)
-	sample := strings.TrimSuffix(base_file_name, path.Ext(base_file_name))
+	WriteReadGroupHeader(w)
 	if PARA.Debug_mode == false {
-		w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + sample + "\n")
+		w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + SampleColumns(sample) + "\n")
 	} else {
-		w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + sample  +
+		w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + SampleColumns(sample) +
 			"\tBASE_QUAL\tCHR_DIS\tCHR_DIFF\tMAP_PROB\tALN_PROB\tPAIR_PROB\tS_POS1\tBRANCH1\tS_POS2\tBRANCH2\tREAD_HEADER\tALN_BASE\tBASE_NUM\n")
 	}
-	w.Flush()
-	f.Close()
-
-	log.Printf("Finish checking input information and seting up parameters.")
 }
 
 //--------------------------------------------------------------------------------------------------
@@ -179,34 +452,108 @@ func SetupPara(input_para *ParaInfo) *ParaInfo {
 
 	para := input_para
 
-	f, e := os.Open(para.Read_file_1)
-	if e != nil {
-		log.Panicf("Error: %s", e)
+	// Read lengths of the two ends and header length are derived from the first records of input reads,
+	// or taken from input if there are no read files (e.g. in server mode)
+	for _, read_file := range ReadFiles(para.Read_file_1) {
+		read_len, info_len := PeekReadFile(read_file, PEEK_READ_NUM, para.Skip_bad_reads)
+		para.Read_len_1, para.Info_len = MaxInt(para.Read_len_1, read_len), MaxInt(para.Info_len, info_len)
 	}
-	s := bufio.NewScanner(f)
-	s.Scan()
-	header := s.Bytes()
-	if len(header) > 0 {
-		para.Info_len = len(header) + 20 //there might be longer header, is that case, ignore the longer part
-	} else {
+	if para.Preset == "" {
+		for _, read_file := range ReadFiles(para.Read_file_2) {
+			read_len, info_len := PeekReadFile(read_file, PEEK_READ_NUM, para.Skip_bad_reads)
+			para.Read_len_2, para.Info_len = MaxInt(para.Read_len_2, read_len), MaxInt(para.Info_len, info_len)
+		}
+	}
+	if para.Read_len_1 == 0 {
+		para.Read_len_1 = para.Read_len
+	}
+	if para.Read_len_2 == 0 {
+		para.Read_len_2 = para.Read_len
+	}
+	para.Read_len = MaxInt(para.Read_len_1, para.Read_len_2)
+	if para.Read_len == 0 {
+		log.Panicf("Something is wrong with input read sequence.")
+	}
+	if para.Info_len == 0 {
 		para.Info_len = 100
 		log.Printf("Possibly missing header")
-	}
-	s.Scan()
-	read := s.Bytes()
-	if len(read) > 0 {
-		para.Read_len = len(read)
 	} else {
-		log.Panicf("Something is wrong with input read sequence.")
+		para.Info_len += 20 //there might be longer header, is that case, ignore the longer part
+	}
+
+	// Long reads are aligned by chunks, with preset values for parameters which are not specified
+	if preset, ok := PRESETS[para.Preset]; ok {
+		para.Read_len, para.Chunk_gap, para.Aln_band = preset.Chunk_len, preset.Chunk_gap, preset.Aln_band
+		para.Read_len_1, para.Read_len_2 = preset.Chunk_len, preset.Chunk_len
+		if para.Min_slen == 0 {
+			para.Min_slen = preset.Min_slen
+		}
+		if para.Max_slen == 0 {
+			para.Max_slen = preset.Max_slen
+		}
+		if para.Dist_thres == 0 {
+			para.Dist_thres = preset.Dist_thres
+		}
+		if para.Sub_cost == 0 {
+			para.Sub_cost = preset.Sub_cost
+		}
+		if para.Gap_open == 0 {
+			para.Gap_open = preset.Gap_open
+		}
+		if para.Gap_ext == 0 {
+			para.Gap_ext = preset.Gap_ext
+		}
+		NEW_INDEL_RATE, INDEL_ERR_RATE = preset.New_indel_rate, preset.Indel_err_rate
+		log.Printf("Long-read preset %s: Chunk_len=%d, Chunk_gap=%d, Aln_band=%d, New_indel_rate=%.4f, Indel_err_rate=%.4f",
+			para.Preset, para.Read_len, para.Chunk_gap, para.Aln_band, NEW_INDEL_RATE, INDEL_ERR_RATE)
+	}
+
+	// Novel variant rates and indel error rate from input override default and preset values.
+	// Rates must be less than 2/3 so that priors of reference genotypes (1 - 1.5*rate) are positive.
+	for _, rate := range []float64{para.New_snp_rate, para.New_indel_rate, para.Indel_err_rate} {
+		if rate < 0 || rate >= 2.0/3.0 {
+			log.Panicf("Error: invalid input for novel variant rates or indel error rate (%f), must be in [0, 2/3).", rate)
+		}
+	}
+	if para.New_snp_rate != 0 {
+		NEW_SNP_RATE = para.New_snp_rate
+	}
+	if para.New_indel_rate != 0 {
+		NEW_INDEL_RATE = para.New_indel_rate
+	}
+	if para.Indel_err_rate != 0 {
+		INDEL_ERR_RATE = para.Indel_err_rate
+	}
+	para.New_snp_rate, para.New_indel_rate, para.Indel_err_rate = NEW_SNP_RATE, NEW_INDEL_RATE, INDEL_ERR_RATE
+	if para.Prof_trust < 0 || para.Prof_trust > 1 {
+		log.Panicf("Error: invalid input for trust in the variant profile (%f), must be in [0, 1].", para.Prof_trust)
 	}
-	f.Close()
+	// Novel transitions and transversions have different rates if a Ts/Tv ratio is given
+	if para.Ts_tv < 0 {
+		log.Panicf("Error: invalid input for Ts/Tv ratio (%f), must be non-negative.", para.Ts_tv)
+	}
+	SetupTsTv(para.Ts_tv)
 
 	// 1500 is asigned based on insert size of paired-end testing reads
 	// will be estimated based on input reads (= 3*avg_ins_size)
-	para.Max_ins = 1500
+	para.Max_ins = PE_MAX_INS
+	// Insert sizes of mate-pair libraries are estimated in the warm-up phase
+	if para.Mate_pair {
+		para.Max_ins = MATE_PAIR_MAX_INS
+		if para.Warm_up == 0 {
+			para.Warm_up = MATE_PAIR_WARM_UP
+		}
+	}
+	// Error rates of sequencing cycles are estimated in the warm-up phase
+	if para.Cycle_err && para.Warm_up == 0 {
+		para.Warm_up = CYCLE_WARM_UP
+	}
+	if para.Preset != "" {
+		para.Max_ins = 2 * para.Chunk_gap
+	}
 
-	// 0.0015 is maximum sequencing error rate of testing reads, 0.01 is mutation rate of testing data,
-	// will be set up based on input reads
+	// 0.0015 is maximum sequencing error rate of testing reads, it is estimated from input reads in the
+	// warm-up phase; mutation rate is estimated from the variant profile (see EstimateMutRate)
 	para.Err_rate = 0.0015
 	para.Mut_rate = 0.01
 
@@ -261,22 +608,16 @@ func SetupPara(input_para *ParaInfo) *ParaInfo {
 		log.Printf("No or invalid input for gap extension cost of alignment, use default value (%.1f).", para.Gap_ext)
 	}
 
+	// Threshold of alignment distance and number of iterations are recomputed from the mutation rate
+	// estimated from the variant profile and the error rate estimated from reads in the warm-up phase
+	// if they are not given (see AlnDistThres)
 	if input_para.Dist_thres == 0 {
-		/*
-			err := float64(para.Err_rate)
-			rlen := float64(para.Read_len)
-			mut := float64(para.Mut_rate)
-			k1 := float64(para.Err_var_factor)
-			k2 := float64(para.Mut_var_factor)
-			var_dist = int(math.Ceil(err*rlen+k1*math.Sqrt(rlen*err*(1-err)))) + int(math.Ceil(mut*rlen+k2*math.Sqrt(rlen*mut*(1-mut))))
-			para.Dist_thres = -float64(var_dist)*math.Log10(1-err) - float64(var_dist)*math.Log10(NEW_INDEL_RATE)
-		*/
-		para.Dist_thres = 36
+		para.Dist_thres, para.Auto_dist_thres = 36, true
 		log.Printf("No or invalid input for threshold of alignment distance, calculate based on input data (%.1f).", para.Dist_thres)
 	}
 	if input_para.Iter_num == 0 {
 		//para.Iter_num = para.Iter_num_factor * (para.Dist_thres + 1)
-		para.Iter_num = 12
+		para.Iter_num, para.Auto_iter_num = 12, true
 		log.Printf("No or invalid input for numbers of random iterations, calculate based on input data (%d).", para.Iter_num)
 	}
 
@@ -285,55 +626,128 @@ func SetupPara(input_para *ParaInfo) *ParaInfo {
 		log.Printf("No or invalid input for number of threads, use maximum number of CPUs of the current machine (%d).", para.Proc_num)
 	}
 
-	log.Printf("Input files:\tGenome_file: %s, Var_file: %s, Index_file=%s, Read_file_1=%s, Read_file_2=%s, Var_call_file=%s",
-		para.Ref_file, para.Var_prof_file, para.Rev_index_file, para.Read_file_1, para.Read_file_2, para.Var_call_file)
+	log.Printf("Input files:\tGenome_file: %s, Var_file: %s, Index_file=%s, Read_file_1=%s, Read_file_2=%s, Var_call_file=%s, Debug_file=%s",
+		para.Ref_file, para.Var_prof_file, para.Rev_index_file, para.Read_file_1, para.Read_file_2, para.Var_call_file, para.Debug_file)
 
 	log.Printf("Input paras:\tSearch_mode=%d, Start_pos=%d, Search_step=%d, Max_snum=%d, Max_psnum=%d, "+
-		"Min_slen=%d, Max_slen=%d, Dist_thres=%.1f, Iter_num=%d, Sub_cost=%.1f, Gap_open=%.1f, Gap_ext=%.1f, Proc_num=%d, Debug_mode=%t",
+		"Min_slen=%d, Max_slen=%d, Dist_thres=%.1f, Iter_num=%d, Sub_cost=%.1f, Gap_open=%.1f, Gap_ext=%.1f, New_snp_rate=%g, New_indel_rate=%g, Indel_err_rate=%g, Proc_num=%d, Max_depth=%d, Debug_mode=%t",
 		para.Search_mode, para.Start_pos, para.Search_step, para.Max_snum, para.Max_psnum, para.Min_slen, para.Max_slen,
-		para.Dist_thres, para.Iter_num, para.Sub_cost, para.Gap_open, para.Gap_ext, para.Proc_num, para.Debug_mode)
+		para.Dist_thres, para.Iter_num, para.Sub_cost, para.Gap_open, para.Gap_ext, para.New_snp_rate, para.New_indel_rate,
+		para.Indel_err_rate, para.Proc_num, para.Max_depth, para.Debug_mode)
 
 	log.Printf("Prog paras:\tMax_ins=%d, Max_err=%.5f, Mut_rate=%.5f, Err_var_factor=%d, Mut_var_factor=%d, Iter_num_factor=%d, "+
-		"Read_len=%d, Info_len=%d, Seed_backup=%d, Ham_backup=%d, Indel_backup=%d", para.Max_ins, para.Err_rate, para.Mut_rate,
-		para.Err_var_factor, para.Mut_var_factor, para.Iter_num_factor, para.Read_len, para.Info_len,
+		"Read_len=%d, Read_len_1=%d, Read_len_2=%d, Info_len=%d, Seed_backup=%d, Ham_backup=%d, Indel_backup=%d", para.Max_ins, para.Err_rate, para.Mut_rate,
+		para.Err_var_factor, para.Mut_var_factor, para.Iter_num_factor, para.Read_len, para.Read_len_1, para.Read_len_2, para.Info_len,
 		para.Seed_backup, para.Ham_backup, para.Indel_backup)
 
 	return para
 }
 
+//--------------------------------------------------------------------------------------------------
+// PeekReadFile returns maximum lengths of reads and headers of the first read_num reads in a FASTQ file,
+// malformed records are skipped if resync is set.
+//--------------------------------------------------------------------------------------------------
+func PeekReadFile(file_name string, read_num int, resync bool) (read_len, info_len int) {
+	f, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	fq := seqio.NewFastqReader(f, file_name)
+	fq.SetResync(resync)
+	for fq.RecordNum() < read_num && fq.Next() {
+		if info_len < len(fq.Info) {
+			info_len = len(fq.Info)
+		}
+		if read_len < len(fq.Read) {
+			read_len = len(fq.Read)
+		}
+	}
+	if e = fq.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return read_len, info_len
+}
+
 //--------------------------------------------------------------------------------------------------
 // Information of input reads
 //--------------------------------------------------------------------------------------------------
 type ReadInfo struct {
-	Read1, Read2                   []byte // first and second ends
-	Qual1, Qual2                   []byte // quality info of the first read and second ends
-	Rev_read1, Rev_read2           []byte // reverse of the first and second ends
-	Rev_comp_read1, Rev_comp_read2 []byte // reverse complement of the first and second ends
-	Comp_read1, Comp_read2         []byte // complement of the first and second ends
-	Rev_qual1, Rev_qual2           []byte // quality of reverse of the first and second ends
-	Info1, Info2                   []byte // info of the first and second ends
+	Len1, Len2                     int          // actual lengths of the first and second ends
+	Read1, Read2                   []byte       // first and second ends
+	Qual1, Qual2                   []byte       // quality info of the first read and second ends
+	Rev_read1, Rev_read2           []byte       // reverse of the first and second ends
+	Rev_comp_read1, Rev_comp_read2 []byte       // reverse complement of the first and second ends
+	Comp_read1, Comp_read2         []byte       // complement of the first and second ends
+	Rev_qual1, Rev_qual2           []byte       // quality of reverse of the first and second ends
+	Info1, Info2                   []byte       // info of the first and second ends
+	RGroup                         int          // index of the read group of the read
+	Barcode                        uint32       // identifier of the barcode of the read (linked reads, 0: no barcode)
+	Cycles1, Cycles2               [2]int       // ranges [start, end) of cycles of the two ends whose bases are used as evidence
+	Anchor                         *ChunkAnchor // expected alignment of the first end from the seed chain of its long read (nil: none)
 }
 
 //--------------------------------------------------------------------------------------------------
-// InitReadInfo creates a ReadInfo object and initializes its content
+// InitReadInfo creates a ReadInfo object and initializes its content, buffers of the two ends are
+// allocated with their own maximum lengths
 //--------------------------------------------------------------------------------------------------
-func InitReadInfo(read_len, info_len int) *ReadInfo {
+func InitReadInfo(read_len_1, read_len_2, info_len int) *ReadInfo {
 	read_info := new(ReadInfo)
-	read_info.Read1, read_info.Read2 = make([]byte, read_len), make([]byte, read_len)
-	read_info.Qual1, read_info.Qual2 = make([]byte, read_len), make([]byte, read_len)
-	read_info.Rev_read1, read_info.Rev_read2 = make([]byte, read_len), make([]byte, read_len)
-	read_info.Rev_comp_read1, read_info.Rev_comp_read2 = make([]byte, read_len), make([]byte, read_len)
-	read_info.Comp_read1, read_info.Comp_read2 = make([]byte, read_len), make([]byte, read_len)
-	read_info.Rev_qual1, read_info.Rev_qual2 = make([]byte, read_len), make([]byte, read_len)
+	read_info.Read1, read_info.Read2 = make([]byte, read_len_1), make([]byte, read_len_2)
+	read_info.Qual1, read_info.Qual2 = make([]byte, read_len_1), make([]byte, read_len_2)
+	read_info.Rev_read1, read_info.Rev_read2 = make([]byte, read_len_1), make([]byte, read_len_2)
+	read_info.Rev_comp_read1, read_info.Rev_comp_read2 = make([]byte, read_len_1), make([]byte, read_len_2)
+	read_info.Comp_read1, read_info.Comp_read2 = make([]byte, read_len_1), make([]byte, read_len_2)
+	read_info.Rev_qual1, read_info.Rev_qual2 = make([]byte, read_len_1), make([]byte, read_len_2)
 	read_info.Info1, read_info.Info2 = make([]byte, info_len), make([]byte, info_len)
+	read_info.Len1, read_info.Len2 = read_len_1, read_len_2
 	return read_info
 }
 
+//--------------------------------------------------------------------------------------------------
+// SetReadLen sets actual lengths of the two ends and reslices all read and quality buffers to these lengths,
+// so that no stale bases from previous (longer) reads remain in the buffers. Buffers are grown for ends
+// longer than them. All bases of the two ends are used as evidence.
+//--------------------------------------------------------------------------------------------------
+func (read_info *ReadInfo) SetReadLen(len1, len2 int) {
+	if len1 > cap(read_info.Read1) {
+		read_info.Read1, read_info.Qual1, read_info.Rev_read1 = make([]byte, len1), make([]byte, len1), make([]byte, len1)
+		read_info.Rev_comp_read1, read_info.Comp_read1, read_info.Rev_qual1 = make([]byte, len1), make([]byte, len1), make([]byte, len1)
+	}
+	if len2 > cap(read_info.Read2) {
+		read_info.Read2, read_info.Qual2, read_info.Rev_read2 = make([]byte, len2), make([]byte, len2), make([]byte, len2)
+		read_info.Rev_comp_read2, read_info.Comp_read2, read_info.Rev_qual2 = make([]byte, len2), make([]byte, len2), make([]byte, len2)
+	}
+	read_info.Len1, read_info.Len2 = len1, len2
+	read_info.Cycles1, read_info.Cycles2 = [2]int{0, len1}, [2]int{0, len2}
+	read_info.Read1, read_info.Read2 = read_info.Read1[:len1], read_info.Read2[:len2]
+	read_info.Qual1, read_info.Qual2 = read_info.Qual1[:len1], read_info.Qual2[:len2]
+	read_info.Rev_read1, read_info.Rev_read2 = read_info.Rev_read1[:len1], read_info.Rev_read2[:len2]
+	read_info.Rev_comp_read1, read_info.Rev_comp_read2 = read_info.Rev_comp_read1[:len1], read_info.Rev_comp_read2[:len2]
+	read_info.Comp_read1, read_info.Comp_read2 = read_info.Comp_read1[:len1], read_info.Comp_read2[:len2]
+	read_info.Rev_qual1, read_info.Rev_qual2 = read_info.Rev_qual1[:len1], read_info.Rev_qual2[:len2]
+}
+
+//--------------------------------------------------------------------------------------------------
+// SetInfo copies headers of the two ends to read_info, longer headers are truncated to the buffer size.
+//--------------------------------------------------------------------------------------------------
+func (read_info *ReadInfo) SetInfo(info1, info2 []byte) {
+	if len(info1) > cap(read_info.Info1) {
+		info1 = info1[:cap(read_info.Info1)]
+	}
+	if len(info2) > cap(read_info.Info2) {
+		info2 = info2[:cap(read_info.Info2)]
+	}
+	read_info.Info1, read_info.Info2 = read_info.Info1[:len(info1)], read_info.Info2[:len(info2)]
+	copy(read_info.Info1, info1)
+	copy(read_info.Info2, info2)
+}
+
 //--------------------------------------------------------------------------------------------------
 // RevComp computes reverse, reverse complement, and complement of a read.
 //--------------------------------------------------------------------------------------------------
 func RevComp(read, qual []byte, rev_comp_read, rev_qual []byte) {
-	read_len := len(read)
+	read_len := len(read) // rev_comp_read and rev_qual must have the same length with read
 	for i, elem := range read {
 		rev_qual[i] = qual[read_len-1-i]
 		if elem == 'A' {
@@ -364,11 +778,17 @@ type SeedInfo struct {
 // Alignment information, served as shared variables between functions for alignment process
 //--------------------------------------------------------------------------------------------------
 type EditAlnInfo struct {
-	l_Trace_K, r_Trace_K              [][][]byte  //backtrace matrix for known locations
-	l_Dist_D, l_Dist_IS, l_Dist_IT    [][]float64 // distance matrix for backward alignment
-	l_Trace_D, l_Trace_IS, l_Trace_IT [][][]int   // backtrace matrix for backward alignment
-	r_Dist_D, r_Dist_IS, r_Dist_IT    [][]float64 // distance matrix for forward alignment
-	r_Trace_D, r_Trace_IS, r_Trace_IT [][][]int   // backtrace matrix for forward alignment
+	l_Trace_K, r_Trace_K              [][][]byte      //backtrace matrix for known locations
+	l_Dist_D, l_Dist_IS, l_Dist_IT    [][]float64     // distance matrix for backward alignment
+	l_Trace_D, l_Trace_IS, l_Trace_IT [][][]int       // backtrace matrix for backward alignment
+	r_Dist_D, r_Dist_IS, r_Dist_IT    [][]float64     // distance matrix for forward alignment
+	r_Trace_D, r_Trace_IS, r_Trace_IT [][][]int       // backtrace matrix for forward alignment
+	arena                             *Arena          // arena of per-read temporaries (nil: allocated on the heap)
+	aligner                           *align.Aligner  // aligner of the goroutine (see VC.Aligner)
+	windows                           *RefWindowCache // cache of reference windows of seed extensions (nil: not cached)
+	aln_tasks                         [4]AlnTask      // alignment tasks of flanks of a seed candidate (reused by the goroutine)
+	aln_batch                         []*AlnTask      // pointers to alignment tasks, passed to the backend
+	baq                               *BAQBuf         // matrices of base alignment qualities (reused by the goroutine)
 }
 
 //--------------------------------------------------------------------------------------------------
@@ -383,9 +803,28 @@ func InitEditAlnInfo(arr_len int) *EditAlnInfo {
 	aln_info.r_Dist_D, aln_info.r_Trace_D = InitEditAlnMat(arr_len)
 	aln_info.r_Dist_IS, aln_info.r_Trace_IS = InitEditAlnMat(arr_len)
 	aln_info.r_Dist_IT, aln_info.r_Trace_IT = InitEditAlnMat(arr_len)
+	aln_info.aln_batch = []*AlnTask{&aln_info.aln_tasks[0], &aln_info.aln_tasks[1], &aln_info.aln_tasks[2], &aln_info.aln_tasks[3]}
+	aln_info.baq = new(BAQBuf)
 	return aln_info
 }
 
+//--------------------------------------------------------------------------------------------------
+// Grow reallocates the alignment matrices if they are smaller than arr_len (reads longer than the read
+// length of the run), other buffers are kept.
+//--------------------------------------------------------------------------------------------------
+func (aln_info *EditAlnInfo) Grow(arr_len int) {
+	if arr_len < len(aln_info.l_Dist_D) {
+		return
+	}
+	aln_info.l_Trace_K, aln_info.r_Trace_K = InitTraceKMat(arr_len), InitTraceKMat(arr_len)
+	aln_info.l_Dist_D, aln_info.l_Trace_D = InitEditAlnMat(arr_len)
+	aln_info.l_Dist_IS, aln_info.l_Trace_IS = InitEditAlnMat(arr_len)
+	aln_info.l_Dist_IT, aln_info.l_Trace_IT = InitEditAlnMat(arr_len)
+	aln_info.r_Dist_D, aln_info.r_Trace_D = InitEditAlnMat(arr_len)
+	aln_info.r_Dist_IS, aln_info.r_Trace_IS = InitEditAlnMat(arr_len)
+	aln_info.r_Dist_IT, aln_info.r_Trace_IT = InitEditAlnMat(arr_len)
+}
+
 //--------------------------------------------------------------------------------------------------
 // InitEditAlnMat initializes variables for computing distance and alignment between reads and multi-genomes.
 //--------------------------------------------------------------------------------------------------
@@ -491,3 +930,68 @@ func IntervalHasVariants(A []int, i, j int) bool {
 	}
 	return i <= j && L < len(A) && i <= A[L] && j >= A[L]
 }
+
+//--------------------------------------------------------------------------------------------------
+// MinInt returns the smaller value of two integers.
+//--------------------------------------------------------------------------------------------------
+func MinInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//--------------------------------------------------------------------------------------------------
+// MaxInt returns the larger value of two integers.
+//--------------------------------------------------------------------------------------------------
+func MaxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//--------------------------------------------------------------------------------------------------
+// AbsInt returns the absolute value of an integer.
+//--------------------------------------------------------------------------------------------------
+func AbsInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+//--------------------------------------------------------------------------------------------------
+// ReadSeed returns the seed of the random generator for a read, derived from the global seed and the
+// read header, so that random choices for a read do not depend on which goroutine processes it.
+//--------------------------------------------------------------------------------------------------
+func ReadSeed(seed int64, info []byte) int64 {
+	h := fnv.New64a()
+	h.Write(info)
+	return seed ^ int64(h.Sum64())
+}
+
+//--------------------------------------------------------------------------------------------------
+// ReadRand is the source of random numbers of a read (splitmix64), used with rand.New. Its state is
+// set from the seed of each read (see ReadSeed), so no generator is shared between reads and reseeding
+// it for each read is as cheap as an assignment, unlike reseeding a rand.Source.
+//--------------------------------------------------------------------------------------------------
+type ReadRand struct {
+	state uint64
+}
+
+func (R *ReadRand) Seed(seed int64) {
+	R.state = uint64(seed)
+}
+
+func (R *ReadRand) Uint64() uint64 {
+	R.state += 0x9e3779b97f4a7c15
+	z := R.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (R *ReadRand) Int63() int64 {
+	return int64(R.Uint64() >> 1)
+}
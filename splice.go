@@ -0,0 +1,134 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: splice.go
+// Splice-aware extension for RNA-seq reads. If a read-end spanning an exon junction cannot be
+// extended from its seed within the distance threshold, the rest of the read-end is re-seeded; a
+// unique seed downstream (or upstream) of the first seed within an intron distance anchors the other
+// exon. The read-end is split at the junction which best fits both exons (canonical GT-AG junctions
+// are preferred) and each part is extended from its own seed. The reference skip (N operation) costs
+// SPLICE_COST, which is much lower than the cost of a long deletion.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+)
+
+const (
+	SPLICE_MIN_INTRON = 50     // minimum length of reference skips (shorter ones are aligned as deletions)
+	SPLICE_MAX_INTRON = 500000 // maximum length of reference skips
+	SPLICE_COST       = 1.0    // cost of a reference skip at a canonical junction
+)
+
+//---------------------------------------------------------------------------------------------------
+// ExtendSeedsSpliced extends a seed as ExtendSeeds does; if the extension fails in spliced mode, it
+// tries to align the read-end with a reference skip. It also returns whether the read-end is aligned with
+// a reference skip.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ExtendSeedsSpliced(s_pos, e_pos, m_pos int, read, qual []byte, cyc_cost []float64, edit_aln_info_1, edit_aln_info_2 *EditAlnInfo) ([]*VarInfo, int, int, float64, bool) {
+	defer TIMING.Add(TIMING_EXTENSION, TIMING.Start(), 1)
+	vars, l_aln_s_pos, r_aln_s_pos, aln_dist := VC.ExtendSeeds(s_pos, e_pos, m_pos, read, qual, cyc_cost, edit_aln_info_1, edit_aln_info_2)
+	if aln_dist != -1 || !PARA.Spliced {
+		return vars, l_aln_s_pos, r_aln_s_pos, aln_dist, false
+	}
+	m_buf := make([]int, PARA.Max_snum)
+	// The other exon is downstream of the seed
+	for p := e_pos + 1; p+PARA.Min_slen < len(read); p += PARA.Min_slen {
+		s, e, m_num, ok := VC.SearchSeeds(read, p, m_buf)
+		if !ok || m_num != 1 {
+			continue
+		}
+		if skip := (m_buf[0] - s) - (m_pos - s_pos); skip >= SPLICE_MIN_INTRON && skip <= SPLICE_MAX_INTRON {
+			vars, l_aln_s_pos, r_aln_s_pos, aln_dist = VC.ExtendJunction(s_pos, e_pos, m_pos, s, e, m_buf[0], read, qual, cyc_cost, edit_aln_info_1, edit_aln_info_2)
+			return vars, l_aln_s_pos, r_aln_s_pos, aln_dist, aln_dist != -1
+		}
+	}
+	// The other exon is upstream of the seed
+	for p := 0; p+PARA.Min_slen < s_pos; p += PARA.Min_slen {
+		s, e, m_num, ok := VC.SearchSeeds(read, p, m_buf)
+		if !ok || m_num != 1 || e >= s_pos {
+			continue
+		}
+		if skip := (m_pos - s_pos) - (m_buf[0] - s); skip >= SPLICE_MIN_INTRON && skip <= SPLICE_MAX_INTRON {
+			vars, l_aln_s_pos, r_aln_s_pos, aln_dist = VC.ExtendJunction(s, e, m_buf[0], s_pos, e_pos, m_pos, read, qual, cyc_cost, edit_aln_info_1, edit_aln_info_2)
+			return vars, l_aln_s_pos, r_aln_s_pos, aln_dist, aln_dist != -1
+		}
+	}
+	return nil, -1, -1, -1, false
+}
+
+//---------------------------------------------------------------------------------------------------
+// SkipPairDist checks if a read pair whose ends start at pos1 and pos2 is only proper in the regime of
+// spliced mode, i.e. only if one of its ends is aligned with a reference skip.
+//---------------------------------------------------------------------------------------------------
+func SkipPairDist(pos1, pos2, len1, len2 int, strand1, strand2 bool) bool {
+	r_idx, _ := PairInsert(pos1, pos2, len1, len2, strand1, strand2, false)
+	return r_idx >= 0 && PAIR_REGIMES[r_idx].Spliced
+}
+
+//---------------------------------------------------------------------------------------------------
+// ExtendJunction aligns a read-end with a reference skip between an upstream seed (read [a_s, a_e]
+// at a_m on the reference) and a downstream seed (read [b_s, b_e] at b_m). The read-end is split at
+// the junction with the fewest mismatches between the seeds, and each part is extended from its seed.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ExtendJunction(a_s, a_e, a_m, b_s, b_e, b_m int, read, qual []byte, cyc_cost []float64, edit_aln_info_1, edit_aln_info_2 *EditAlnInfo) ([]*VarInfo, int, int, float64) {
+	a_off, b_off := a_m-a_s, b_m-b_s // reference position of a read base is offset + read position
+	junc, junc_cost := -1, 0.0
+	for x := a_e + 1; x <= b_s; x++ {
+		cost := 0.0
+		for i := a_e + 1; i < b_s; i++ {
+			off := a_off
+			if i >= x {
+				off = b_off
+			}
+			if off+i < 0 || off+i >= VC.SeqLen || !bytes.EqualFold([]byte{read[i]}, []byte{VC.Seq[off+i]}) {
+				cost += PARA.Sub_cost
+			}
+		}
+		if !VC.CanonicalJunction(a_off+x, b_off+x) {
+			cost += PARA.Gap_open
+		}
+		if junc == -1 || cost < junc_cost {
+			junc, junc_cost = x, cost
+		}
+	}
+	if junc == -1 {
+		return nil, -1, -1, -1
+	}
+	l_vars, l_aln_s_pos, _, l_dist := VC.ExtendSeeds(a_s, a_e, a_m, read[:junc], qual[:junc], SubCosts(cyc_cost, 0, junc), edit_aln_info_1, edit_aln_info_2)
+	if l_dist == -1 {
+		return nil, -1, -1, -1
+	}
+	r_vars, _, r_aln_s_pos, r_dist := VC.ExtendSeeds(b_s-junc, b_e-junc, b_m, read[junc:], qual[junc:], SubCosts(cyc_cost, junc, len(read)), edit_aln_info_1, edit_aln_info_2)
+	if r_dist == -1 {
+		return nil, -1, -1, -1
+	}
+	aln_dist := l_dist + r_dist + SPLICE_COST
+	if !VC.CanonicalJunction(a_off+junc, b_off+junc) {
+		aln_dist += PARA.Gap_open
+	}
+	if aln_dist > PARA.Dist_thres {
+		return nil, -1, -1, -1
+	}
+	// Distances to read ends are relative to the whole read-end
+	for _, v := range l_vars {
+		v.RPos, v.Cycle = ReadEndDist(int(v.Pos)-a_off, len(read)), int(v.Pos)-a_off
+	}
+	for _, v := range r_vars {
+		v.RPos, v.Cycle = ReadEndDist(int(v.Pos)-b_off, len(read)), int(v.Pos)-b_off
+	}
+	return append(l_vars, r_vars...), l_aln_s_pos, r_aln_s_pos, aln_dist
+}
+
+//---------------------------------------------------------------------------------------------------
+// CanonicalJunction determines whether an intron [donor, acceptor) on the reference starts with GT
+// and ends with AG (or CT...AC on the reverse strand).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CanonicalJunction(donor, acceptor int) bool {
+	if donor < 0 || acceptor-2 < donor || acceptor > VC.SeqLen {
+		return false
+	}
+	motif := string(bytes.ToUpper([]byte{VC.Seq[donor], VC.Seq[donor+1], VC.Seq[acceptor-2], VC.Seq[acceptor-1]}))
+	return motif == "GTAG" || motif == "CTAC"
+}
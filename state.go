@@ -0,0 +1,313 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: state.go
+// Serialization of the variant call state. Sufficient statistics of aligned reads at variant locations
+// (numbers of reads of alleles, site statistics and sums of likelihoods) are additive, they are saved
+// in a compact binary format (gzip-compressed gob) so that they can be inspected, or loaded as the
+// starting point of a later run with additional reads. Priors are not saved, they are set up from the
+// variant profile when the state is loaded, and posteriors are computed from them at output.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/namsyvo/IVC/caller"
+)
+
+//---------------------------------------------------------------------------------------------------
+// VarCallState represents the variant call state of all variant locations of a multigenome.
+//---------------------------------------------------------------------------------------------------
+type VarCallState struct {
+	SeqLen    int                   // length of the multi-sequence
+	ChrName   []string              // chromosome names
+	ChrPos    []int                 // positions of chromosomes on the multi-sequence
+	SampleNum int                   // number of samples (0: single-sample calling)
+	Shard     int                   // index of the shard of the run (sharded execution)
+	ShardNum  int                   // number of shards (0: the state covers the whole multigenome)
+	Sites     map[uint32]*SiteState // states of variant locations (positions on the multi-sequence)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SiteState represents sufficient statistics of aligned reads at a variant location.
+//---------------------------------------------------------------------------------------------------
+type SiteState struct {
+	RNum       map[string]int                  // numbers of aligned reads of alleles ("ref|alt")
+	RevNum     map[string]int                  // numbers of aligned reads on the reverse strand of alleles
+	Depth      int                             // number of aligned reads seen (before downsampling)
+	Stat       *SiteStat                       // statistics of aligned reads (for INFO annotations)
+	Like       map[string]*caller.AlleleLike   // sums of likelihoods of aligned bases of key alleles
+	SampleRNum []map[string]int                // numbers of aligned reads of alleles of each sample
+	SampleRev  []map[string]int                // numbers of aligned reads on the reverse strand of alleles of each sample
+	SampleLike []map[string]*caller.AlleleLike // sums of likelihoods of aligned bases of each sample
+}
+
+//---------------------------------------------------------------------------------------------------
+// GetVarCallState collects the state of variant locations from the variant call data structure.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) GetVarCallState() *VarCallState {
+	S := &VarCallState{SeqLen: VC.SeqLen, ChrPos: VC.ChrPos, Sites: make(map[uint32]*SiteState)}
+	for _, chr_name := range VC.ChrName {
+		S.ChrName = append(S.ChrName, string(chr_name))
+	}
+	if MultiSample() {
+		S.SampleNum = len(SAMPLES)
+	}
+	if SHARD != nil {
+		S.Shard, S.ShardNum = SHARD.ID, SHARD.Num
+	}
+	for rid := 0; rid < PARA.Proc_num; rid++ {
+		VC.Calls[rid].Sites.EachAligned(func(pos uint32, call_site *CallSite) {
+			stat := call_site.Stat
+			site := &SiteState{RNum: call_site.RNum.Map(), RevNum: call_site.RevNum.Map(), Stat: &stat, Like: call_site.Like.Map()}
+			if VC.Calls[rid].VarDepth != nil {
+				site.Depth = VC.Calls[rid].VarDepth[pos]
+			}
+			if MultiSample() {
+				site.SampleRNum, site.SampleRev = VC.Calls[rid].SampleRNum[pos], VC.Calls[rid].SampleRev[pos]
+				for _, sample_stat := range VC.Calls[rid].SampleLikeStat[pos] {
+					site.SampleLike = append(site.SampleLike, sample_stat.Map())
+				}
+			}
+			S.Sites[pos] = site
+		})
+	}
+	return S
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddVarCallState adds a state to the variant call data structure (statistics are summed).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddVarCallState(S *VarCallState) {
+	if S.SeqLen != VC.SeqLen || len(S.ChrName) != len(VC.ChrName) {
+		log.Panicf("Error: variant call state does not match the reference (length %d, %d chromosomes)", S.SeqLen, len(S.ChrName))
+	}
+	if (MultiSample() && S.SampleNum != len(SAMPLES)) || (!MultiSample() && S.SampleNum != 0) {
+		log.Panicf("Error: variant call state does not match samples of read groups (%d samples)", S.SampleNum)
+	}
+	for pos, site := range S.Sites {
+		rid := PARA.Proc_num * int(pos) / VC.SeqLen
+		if !VC.Calls[rid].VarProb.Has(pos) {
+			VC.Calls[rid].VarProb.Set(pos, nil)
+		}
+		call_site := VC.Calls[rid].Sites.Add(pos)
+		call_site.RNum.AddMap(site.RNum)
+		call_site.RevNum.AddMap(site.RevNum)
+		if VC.Calls[rid].VarDepth != nil {
+			VC.Calls[rid].VarDepth[pos] += site.Depth
+		}
+		if site.Stat != nil {
+			call_site.Stat.Merge(site.Stat)
+		}
+		call_site.Like.AddMap(site.Like)
+		if MultiSample() && len(site.SampleRNum) == len(SAMPLES) {
+			if _, sample_exist := VC.Calls[rid].SampleRNum[pos]; !sample_exist {
+				VC.Calls[rid].SampleRNum[pos] = make([]map[string]int, len(SAMPLES))
+				VC.Calls[rid].SampleRev[pos] = make([]map[string]int, len(SAMPLES))
+				VC.Calls[rid].SampleLike[pos] = make([]map[string]float64, len(SAMPLES))
+				VC.Calls[rid].SampleLikeStat[pos] = make([]*caller.SiteLike, len(SAMPLES))
+				for s := 0; s < len(SAMPLES); s++ {
+					VC.Calls[rid].SampleRNum[pos][s] = make(map[string]int)
+					VC.Calls[rid].SampleRev[pos][s] = make(map[string]int)
+					VC.Calls[rid].SampleLikeStat[pos][s] = new(caller.SiteLike)
+				}
+			}
+			for s := 0; s < len(SAMPLES); s++ {
+				AddRNum(VC.Calls[rid].SampleRNum[pos][s], site.SampleRNum[s])
+				if len(site.SampleRev) == len(SAMPLES) {
+					AddRNum(VC.Calls[rid].SampleRev[pos][s], site.SampleRev[s])
+				}
+				VC.Calls[rid].SampleLikeStat[pos][s].AddMap(site.SampleLike[s])
+			}
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddRNum adds numbers of aligned reads of alleles.
+//---------------------------------------------------------------------------------------------------
+func AddRNum(dst, src map[string]int) {
+	for b, n := range src {
+		dst[b] += n
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddLike adds sums of likelihoods of key alleles.
+//---------------------------------------------------------------------------------------------------
+func AddLike(dst, src map[string]*caller.AlleleLike) {
+	for key, like := range src {
+		if _, allele_exist := dst[key]; !allele_exist {
+			dst[key] = new(caller.AlleleLike)
+		}
+		dst[key].Both += like.Both
+		dst[key].One += like.One
+		dst[key].None += like.None
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Merge adds statistics of aligned reads of another site statistics.
+//---------------------------------------------------------------------------------------------------
+func (S *SiteStat) Merge(T *SiteStat) {
+	S.MQSquareSum += T.MQSquareSum
+	S.ReadNum += T.ReadNum
+	S.RefBQual = append(S.RefBQual, T.RefBQual...)
+	S.AltBQual = append(S.AltBQual, T.AltBQual...)
+	S.RefRPos = append(S.RefRPos, T.RefRPos...)
+	S.AltRPos = append(S.AltRPos, T.AltRPos...)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Merge adds statistics of another state of the same multigenome and samples (e.g. a state of a run
+// with another part of reads).
+//---------------------------------------------------------------------------------------------------
+func (S *VarCallState) Merge(T *VarCallState) {
+	if S.SeqLen != T.SeqLen || len(S.ChrName) != len(T.ChrName) || S.SampleNum != T.SampleNum {
+		log.Panicf("Error: variant call states are created with different multigenomes or samples")
+	}
+	for pos, t_site := range T.Sites {
+		site, site_exist := S.Sites[pos]
+		if !site_exist {
+			S.Sites[pos] = t_site
+			continue
+		}
+		AddRNum(site.RNum, t_site.RNum)
+		if site.RevNum == nil {
+			site.RevNum = make(map[string]int)
+		}
+		AddRNum(site.RevNum, t_site.RevNum)
+		site.Depth += t_site.Depth
+		if site.Stat == nil {
+			site.Stat = new(SiteStat)
+		}
+		if t_site.Stat != nil {
+			site.Stat.Merge(t_site.Stat)
+		}
+		if site.Like == nil {
+			site.Like = make(map[string]*caller.AlleleLike)
+		}
+		AddLike(site.Like, t_site.Like)
+		for s := 0; s < len(site.SampleRNum) && s < len(t_site.SampleRNum); s++ {
+			// Empty maps are decoded as nil
+			if site.SampleRNum[s] == nil {
+				site.SampleRNum[s] = make(map[string]int)
+			}
+			if site.SampleLike[s] == nil {
+				site.SampleLike[s] = make(map[string]*caller.AlleleLike)
+			}
+			AddRNum(site.SampleRNum[s], t_site.SampleRNum[s])
+			if len(site.SampleRev) == len(site.SampleRNum) && s < len(t_site.SampleRev) {
+				if site.SampleRev[s] == nil {
+					site.SampleRev[s] = make(map[string]int)
+				}
+				AddRNum(site.SampleRev[s], t_site.SampleRev[s])
+			}
+			AddLike(site.SampleLike[s], t_site.SampleLike[s])
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// MergeVarCallStates loads variant call states from files and merges them into one state.
+//---------------------------------------------------------------------------------------------------
+func MergeVarCallStates(file_names []string) *VarCallState {
+	states := make([]*VarCallState, 0, len(file_names))
+	for _, file_name := range file_names {
+		T := LoadVarCallState(file_name)
+		log.Printf("Variant call state of %d variant locations is loaded from:\t%s", len(T.Sites), file_name)
+		states = append(states, T)
+	}
+	CheckShards(states)
+	S := states[0]
+	for _, T := range states[1:] {
+		S.Merge(T)
+	}
+	S.Shard, S.ShardNum = 0, 0
+	return S
+}
+
+//---------------------------------------------------------------------------------------------------
+// SaveVarCallState saves the variant call state to a file.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SaveVarCallState(file_name string) {
+	SaveState(VC.GetVarCallState(), file_name)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SaveState saves a variant call state to a file.
+//---------------------------------------------------------------------------------------------------
+func SaveState(S *VarCallState, file_name string) {
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	gz, _ := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if e = gob.NewEncoder(gz).Encode(S); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	if e = gz.Close(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Variant call state of %d variant locations is saved to:\t%s", len(S.Sites), file_name)
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadVarCallState loads a variant call state from a file.
+//---------------------------------------------------------------------------------------------------
+func LoadVarCallState(file_name string) *VarCallState {
+	f, e := OpenInput(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	gz, e := gzip.NewReader(f)
+	if e != nil {
+		log.Panicf("Error: %s (%s)", e, file_name)
+	}
+	S := new(VarCallState)
+	if e = gob.NewDecoder(gz).Decode(S); e != nil {
+		log.Panicf("Error: %s (%s)", e, file_name)
+	}
+	return S
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteStateTable writes a variant call state in tab-delimited text format, one variant location per
+// line: chromosome, position (1-based), number of reads seen, and numbers of reads of alleles.
+//---------------------------------------------------------------------------------------------------
+func WriteStateTable(w io.Writer, S *VarCallState) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	fmt.Fprintf(bw, "#SeqLen=%d, Chromosomes=%d, Samples=%d, Sites=%d\n", S.SeqLen, len(S.ChrName), S.SampleNum, len(S.Sites))
+	fmt.Fprintf(bw, "#CHROM\tPOS\tDEPTH\tALLELES\n")
+	positions := make([]int, 0, len(S.Sites))
+	for pos, _ := range S.Sites {
+		positions = append(positions, int(pos))
+	}
+	sort.Ints(positions)
+	for _, pos := range positions {
+		site := S.Sites[uint32(pos)]
+		chr_id := sort.SearchInts(S.ChrPos, pos+1) - 1
+		alleles := make([]string, 0, len(site.RNum))
+		for b, n := range site.RNum {
+			alleles = append(alleles, fmt.Sprintf("%s:%d", b, n))
+		}
+		sort.Strings(alleles)
+		depth := site.Depth
+		if depth == 0 && site.Stat != nil {
+			depth = site.Stat.ReadNum
+		}
+		fmt.Fprintf(bw, "%s\t%d\t%d\t%s\n", S.ChrName[chr_id], pos+1-S.ChrPos[chr_id], depth, strings.Join(alleles, ","))
+	}
+}
@@ -0,0 +1,123 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: strand.go
+// Per-strand allele counts. The strand of the read-end of each aligned base is kept with its evidence,
+// and numbers of aligned reads on the reverse strand are counted per allele besides numbers of all
+// aligned reads, so that forward- and reverse-strand support of the reference and the alternative
+// allele of calls is written (FORMAT ADF, ADR and SB) for strand-bias filtering by downstream tools,
+// with FS (INFO), the Phred-scaled p-value of Fisher's exact test of strand bias, for hard filters.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+//---------------------------------------------------------------------------------------------------
+// SetStrand records the strand of a read-end in its variants (strand is true if the read-end is
+// aligned to the forward strand of the reference).
+//---------------------------------------------------------------------------------------------------
+func SetStrand(vars []*VarInfo, strand bool) {
+	for _, v := range vars {
+		v.Rev = !strand
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddRevNum counts an aligned base in numbers of reads on the reverse strand of alleles if its
+// read-end is aligned to the reverse strand.
+//---------------------------------------------------------------------------------------------------
+func AddRevNum(rev_num map[string]int, var_info *VarInfo) {
+	if var_info.Rev {
+		rev_num[string(var_info.Bases)]++
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandCounts returns numbers of aligned reads of the reference and the alternative allele of a call
+// on the forward and reverse strands, in the order of SB: REF forward, REF reverse, ALT forward, ALT
+// reverse. Reads of the reference have keys "ref|ref"; reads of the alternative allele alt are
+// identified by AlleleIndex.
+//---------------------------------------------------------------------------------------------------
+func StrandCounts(var_num, rev_num map[string]int, alt string) [4]int {
+	var counts [4]int
+	for var_base, n := range var_num {
+		if i := 2 * AlleleIndex(var_base, alt); i >= 0 {
+			counts[i] += n - rev_num[var_base]
+			counts[i+1] += rev_num[var_base]
+		}
+	}
+	return counts
+}
+
+//---------------------------------------------------------------------------------------------------
+// AlleleIndex returns the allele of a call which aligned bases var_base support: 0 for the reference
+// ("ref|ref"), 1 for the alternative allele alt (deletions identified by the first allele of keys),
+// and -1 for other alleles.
+//---------------------------------------------------------------------------------------------------
+func AlleleIndex(var_base, alt string) int {
+	var_arr := strings.Split(var_base, "|")
+	if var_arr[0] == var_arr[1] {
+		return 0
+	}
+	if (len(var_arr[0]) > len(var_arr[1]) && var_arr[0] == alt) || (len(var_arr[0]) <= len(var_arr[1]) && var_arr[1] == alt) {
+		return 1
+	}
+	return -1
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandFormat returns FORMAT values ADF:ADR:SB of a call given per-strand counts.
+//---------------------------------------------------------------------------------------------------
+func StrandFormat(counts [4]int) string {
+	str := make([]string, 4)
+	for i, n := range counts {
+		str[i] = strconv.Itoa(n)
+	}
+	return str[0] + "," + str[2] + ":" + str[1] + "," + str[3] + ":" + strings.Join(str, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// MultiStrandCounts returns per-strand counts of a multi-allelic record like StrandCounts, reads of all
+// alternative alleles are counted together.
+//---------------------------------------------------------------------------------------------------
+func MultiStrandCounts(var_num, rev_num map[string]int, alleles []string) [4]int {
+	var counts [4]int
+	for var_base, n := range var_num {
+		if i := MultiAlleleIndex(var_base, alleles); i >= 0 {
+			j := 2 * MinInt(i, 1)
+			counts[j] += n - rev_num[var_base]
+			counts[j+1] += rev_num[var_base]
+		}
+	}
+	return counts
+}
+
+//---------------------------------------------------------------------------------------------------
+// FisherStrand returns FS, the Phred-scaled p-value of the two-sided Fisher's exact test of strand
+// bias, from per-strand counts (reference forward, reference reverse, alternative forward, alternative
+// reverse).
+//---------------------------------------------------------------------------------------------------
+func FisherStrand(n [4]int) float64 {
+	// Hypergeometric probabilities of tables with the margins of the observed table
+	row1, col1, total := n[0]+n[1], n[0]+n[2], n[0]+n[1]+n[2]+n[3]
+	log_fact := func(x int) float64 {
+		v, _ := math.Lgamma(float64(x + 1))
+		return v
+	}
+	log_prob := func(a int) float64 {
+		return log_fact(row1) + log_fact(total-row1) + log_fact(col1) + log_fact(total-col1) -
+			log_fact(total) - log_fact(a) - log_fact(row1-a) - log_fact(col1-a) - log_fact(total-row1-col1+a)
+	}
+	obs, p := log_prob(n[0]), 0.0
+	for a := MaxInt(0, row1+col1-total); a <= MinInt(row1, col1); a++ {
+		if lp := log_prob(a); lp <= obs+1e-7 {
+			p += math.Exp(lp)
+		}
+	}
+	fs := math.Round(-10*math.Log10(math.Min(math.Max(p, 1e-30), 1))*1000) / 1000 // rounded as in VCF
+	return math.Max(fs, 0)
+}
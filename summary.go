@@ -0,0 +1,96 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: summary.go
+// Machine-readable run summary. Read counts, alignment rates, numbers of candidate and emitted
+// variants, runtime of each stage and memory usage are collected during a run and written to a JSON
+// file at the end, so that pipelines can validate runs without parsing logs.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+//---------------------------------------------------------------------------------------------------
+// RunSummary represents statistics of a run.
+//---------------------------------------------------------------------------------------------------
+type RunSummary struct {
+	ReadNum        int64              `json:"reads"`                 // number of processed (paired-end) reads
+	AlignedNum     int64              `json:"aligned_reads"`         // number of aligned reads
+	UnalignedNum   int64              `json:"unaligned_reads"`       // number of un-aligned reads
+	AlignRate      float64            `json:"alignment_rate"`        // fraction of aligned reads
+	ProperPairNum  int64              `json:"properly_paired_reads"` // number of reads whose ends are aligned in the expected orientation within the maximum insert size
+	ProperPairRate float64            `json:"properly_paired_rate"`  // fraction of properly paired reads
+	SkippedNum     int64              `json:"skipped_reads"`         // number of reads skipped by the k-mer prescreen
+	MergedNum      int64              `json:"merged_pairs"`          // number of overlapping read pairs merged before alignment
+	MalformedNum   int64              `json:"malformed_records"`     // number of malformed FASTQ records skipped (-skip-bad-reads)
+	ContamFrac     float64            `json:"contamination"`         // estimated cross-sample contamination fraction
+	ContamSiteNum  int64              `json:"contamination_sites"`   // number of homozygous known SNPs used for estimating contamination
+	Sex            string             `json:"sex,omitempty"`         // sex of the sample (given or inferred) used for ploidy of sex chromosomes
+	CandidateNum   int64              `json:"candidate_variants"`    // number of positions with aligned evidence
+	EmittedNum     int64              `json:"emitted_variants"`      // number of written variant calls (without homozygous-reference sites)
+	LowQualNum     int64              `json:"low_quality_variants"`  // number of variant calls omitted for QUAL below the minimum (-min-qual)
+	StageTime      map[string]float64 `json:"stage_seconds"`         // runtime (in seconds) of each stage
+	PeakMemory     uint64             `json:"peak_memory_bytes"`     // memory obtained from the OS (the peak of memory usage of the Go runtime)
+}
+
+// Statistics of the current run
+var SUMMARY = &RunSummary{StageTime: make(map[string]float64)}
+
+//---------------------------------------------------------------------------------------------------
+// ResetReadStats resets read and variant counts (e.g. before a new batch of reads in server mode).
+//---------------------------------------------------------------------------------------------------
+func (S *RunSummary) ResetReadStats() {
+	S.ReadNum, S.AlignedNum, S.UnalignedNum, S.ProperPairNum, S.CandidateNum, S.EmittedNum, S.SkippedNum, S.MergedNum = 0, 0, 0, 0, 0, 0, 0, 0
+	S.LowQualNum, S.MalformedNum = 0, 0
+	S.AlignRate, S.ProperPairRate = 0, 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddStageTime records runtime of a stage.
+//---------------------------------------------------------------------------------------------------
+func (S *RunSummary) AddStageTime(stage string, d time.Duration) {
+	S.StageTime[stage] += d.Seconds()
+}
+
+//---------------------------------------------------------------------------------------------------
+// CountProperPair counts an aligned read whose ends start at cov_start1 and cov_start2 (aligned ends
+// always have the expected orientation) if its insert size is within the maximum insert size.
+//---------------------------------------------------------------------------------------------------
+func (S *RunSummary) CountProperPair(cov_start1, len1, cov_start2, len2 int) {
+	ins_size := MaxInt(cov_start1+len1, cov_start2+len2) - MinInt(cov_start1, cov_start2)
+	if ins_size <= PARA.Max_ins {
+		atomic.AddInt64(&S.ProperPairNum, 1)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Write computes rates and memory usage and writes the summary to a JSON file.
+//---------------------------------------------------------------------------------------------------
+func (S *RunSummary) Write(file_name string) {
+	S.ReadNum = S.AlignedNum + S.UnalignedNum
+	if S.ReadNum > 0 {
+		S.AlignRate = float64(S.AlignedNum) / float64(S.ReadNum)
+		S.ProperPairRate = float64(S.ProperPairNum) / float64(S.ReadNum)
+	}
+	mem_stats := new(runtime.MemStats)
+	runtime.ReadMemStats(mem_stats)
+	S.PeakMemory = mem_stats.Sys
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if e = enc.Encode(S); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	log.Printf("Run summary is written to:\t%s", file_name)
+}
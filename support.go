@@ -0,0 +1,114 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: support.go
+// Supporting reads of variant calls. Names of aligned reads (from headers of FASTQ records) are kept
+// with their aligned bases, and for each emitted call the names of reads supporting its reference and
+// alternative alleles are written to a sidecar file, so that candidate variants can be reviewed
+// manually with the reads they are called from.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/namsyvo/IVC/seqio"
+)
+
+// Names of alleles of calls in the supporting-read file
+var SUPPORT_ALLELES = [2]string{"REF", "ALT"}
+
+//---------------------------------------------------------------------------------------------------
+// SupportReads collects lines of supporting reads of emitted calls, which are formatted in parallel
+// and written in order of positions.
+//---------------------------------------------------------------------------------------------------
+type SupportReads struct {
+	lines map[uint32]string // lines of supporting reads of the emitted call at each position
+	mut   sync.Mutex
+}
+
+// Supporting reads of calls being written (nil if they are not required)
+var SUPPORT *SupportReads
+
+//---------------------------------------------------------------------------------------------------
+// NewSupportReads creates a collection of supporting reads of emitted calls.
+//---------------------------------------------------------------------------------------------------
+func NewSupportReads() *SupportReads {
+	return &SupportReads{lines: make(map[uint32]string)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddReadName keeps the name of the read of an aligned base with the aligned bases of its allele.
+// Names are kept at every position with aligned bases, since calls are only known after all reads are
+// aligned. Names share the copy of the header of their read-end, but each aligned base costs a slice
+// header of 24 bytes (up to twice that with slice growth), i.e. 50-100 GB per 10 million read pairs of
+// 100 bases.
+//---------------------------------------------------------------------------------------------------
+func AddReadName(var_reads map[string][][]byte, var_info *VarInfo) {
+	if len(var_info.RInfo) == 0 {
+		return
+	}
+	var_str := string(var_info.Bases)
+	var_reads[var_str] = append(var_reads[var_str], seqio.MateName(var_info.RInfo))
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add adds supporting reads of the call at a variant location from names of aligned reads of each
+// variant at the location, one line per allele (CHROM, POS, REF, ALT, allele, number of reads,
+// comma-separated names of reads). allele returns the allele of the call (as AlleleIndex does) which
+// aligned bases support. Both ends of a read pair are counted once.
+//---------------------------------------------------------------------------------------------------
+func (S *SupportReads) Add(pos uint32, var_reads map[string][][]byte, fields []string, allele func(string) int) {
+	var names [2][]string
+	var seen [2]map[string]bool
+	for var_base, reads := range var_reads {
+		i := allele(var_base)
+		if i < 0 {
+			continue
+		}
+		if seen[i] == nil {
+			seen[i] = make(map[string]bool)
+		}
+		for _, name := range reads {
+			if !seen[i][string(name)] {
+				seen[i][string(name)] = true
+				names[i] = append(names[i], string(name))
+			}
+		}
+	}
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		sort.Strings(names[i])
+		str_names := strings.Join(names[i], ",")
+		if str_names == "" {
+			str_names = "."
+		}
+		buf.WriteString(strings.Join(fields, "\t") + "\t" + SUPPORT_ALLELES[i] + "\t" + strconv.Itoa(len(names[i])) + "\t" + str_names + "\n")
+	}
+	S.mut.Lock()
+	S.lines[pos] = buf.String()
+	S.mut.Unlock()
+}
+
+//---------------------------------------------------------------------------------------------------
+// Write writes supporting reads of emitted calls in order of positions.
+//---------------------------------------------------------------------------------------------------
+func (S *SupportReads) Write(file_name string) {
+	Pos := make([]int, 0, len(S.lines))
+	for pos, _ := range S.lines {
+		Pos = append(Pos, int(pos))
+	}
+	sort.Ints(Pos)
+	out := CreateOutput(file_name)
+	defer out.Close()
+	out.Writer.WriteString("#CHROM\tPOS\tREF\tALT\tALLELE\tREADS\tNAMES\n")
+	for _, pos := range Pos {
+		out.Writer.WriteString(S.lines[uint32(pos)])
+	}
+	log.Printf("Supporting reads of %d variant calls are written to:\t%s", len(Pos), file_name)
+}
@@ -0,0 +1,173 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: sv.go
+// Structural variant signals from paired-end reads which cannot be aligned as proper pairs.
+// Read-ends are anchored by unique exact matches of their heads and tails: split reads (head and
+// tail of a read-end anchored far apart), discordant pairs (both ends anchored but not as a proper
+// pair) and one-end-anchored pairs are collected, binned into breakpoint candidates and reported
+// with their supporting read counts.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SV_ANCHOR_LEN  = 25  // minimum length of unique exact matches anchoring read-ends
+	SV_MIN_SIZE    = 50  // minimum distance between split anchors of a read-end (on the reference)
+	SV_BIN         = 500 // breakpoint signals in bins of this size are combined
+	SV_MIN_SUPPORT = 2   // minimum number of supporting reads of reported breakpoint candidates
+)
+
+//---------------------------------------------------------------------------------------------------
+// SVCand represents a breakpoint candidate joining two locations of the reference.
+//---------------------------------------------------------------------------------------------------
+type SVCand struct {
+	Pos1, Pos2 int    // positions of the breakpoint on the multigenome (Pos2 is -1 for one-end-anchored pairs)
+	Orient     string // strands of the anchors at Pos1 and Pos2 ('.' if not anchored)
+	Pos1Sum    int    // sum of positions of signals at the first location
+	Pos2Sum    int    // sum of positions of signals at the second location
+	SplitNum   int    // number of split reads
+	DiscNum    int    // number of discordant pairs
+	OneEndNum  int    // number of pairs with only one anchored end
+}
+
+var (
+	SV_CANDS = make(map[string]*SVCand) // breakpoint candidates, indexed by bins and orientation
+	SV_MUT   sync.Mutex                 // mutex lock for updating breakpoint candidates
+)
+
+//---------------------------------------------------------------------------------------------------
+// SVAnchor returns position on the reference of a read base at s_pos and index of the last base
+// of the exact match starting at s_pos, if the match is unique and not shorter than SV_ANCHOR_LEN.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SVAnchor(read []byte, s_pos int, m_pos []int) (int, int, bool) {
+	s, e, m_num, ok := VC.SearchSeeds(read, s_pos, m_pos)
+	if !ok || m_num != 1 || e-s+1 < SV_ANCHOR_LEN {
+		return -1, -1, false
+	}
+	return m_pos[0], e, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// SearchSVSignals searches for breakpoint signals of an unaligned paired-end read.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) SearchSVSignals(read_info *ReadInfo, seed_pos [][]int) {
+	ends := [2][2][]byte{{read_info.Read1, read_info.Rev_comp_read1}, {read_info.Read2, read_info.Rev_comp_read2}}
+	anchor_pos, anchor_strand, anchored := [2]int{}, [2]bool{}, [2]bool{}
+	for k := 0; k < 2; k++ {
+		for t, read := range ends[k] {
+			head_pos, head_end, ok := VC.SVAnchor(read, 0, seed_pos[0])
+			if !ok {
+				continue
+			}
+			anchor_pos[k], anchor_strand[k], anchored[k] = head_pos, t == 0, true
+			// Split read: the tail is anchored far from the expected position
+			tail_start := MaxInt(head_end+1, len(read)-SV_ANCHOR_LEN)
+			if tail_pos, _, ok := VC.SVAnchor(read, tail_start, seed_pos[1]); ok {
+				if diff := tail_pos - head_pos - tail_start; diff > SV_MIN_SIZE || diff < -SV_MIN_SIZE {
+					strand := StrandChar(t == 0)
+					VC.AddSVSignal(head_pos+head_end, tail_pos, strand+strand, 0)
+				}
+			}
+			break
+		}
+	}
+	if anchored[0] && anchored[1] {
+		// Proper pairs (in the orientation of a regime with proper distance) are not discordant
+		if r_idx, _ := PairInsert(anchor_pos[0], anchor_pos[1], read_info.Len1, read_info.Len2, anchor_strand[0], anchor_strand[1], true); r_idx < 0 {
+			VC.AddSVSignal(anchor_pos[0], anchor_pos[1], StrandChar(anchor_strand[0])+StrandChar(anchor_strand[1]), 1)
+		}
+	} else if anchored[0] {
+		VC.AddSVSignal(anchor_pos[0], -1, StrandChar(anchor_strand[0])+".", 2)
+	} else if anchored[1] {
+		VC.AddSVSignal(anchor_pos[1], -1, StrandChar(anchor_strand[1])+".", 2)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddSVSignal adds a breakpoint signal (type 0: split read, 1: discordant pair, 2: one-end-anchored
+// pair) to the breakpoint candidate of its bins. Locations are ordered by positions.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddSVSignal(pos1, pos2 int, orient string, sig_type int) {
+	if pos2 >= 0 && pos2 < pos1 {
+		pos1, pos2 = pos2, pos1
+		orient = orient[1:] + orient[:1]
+	}
+	bin2 := -1
+	if pos2 >= 0 {
+		bin2 = pos2 / SV_BIN
+	}
+	key := strconv.Itoa(pos1/SV_BIN) + ":" + strconv.Itoa(bin2) + ":" + orient
+	SV_MUT.Lock()
+	defer SV_MUT.Unlock()
+	cand, ok := SV_CANDS[key]
+	if !ok {
+		cand = &SVCand{Orient: orient}
+		SV_CANDS[key] = cand
+	}
+	cand.Pos1Sum += pos1
+	cand.Pos2Sum += pos2
+	switch sig_type {
+	case 0:
+		cand.SplitNum++
+	case 1:
+		cand.DiscNum++
+	case 2:
+		cand.OneEndNum++
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteSVCandidates writes breakpoint candidates with at least SV_MIN_SUPPORT supporting reads to a
+// tab-delimited file. Positions are averages of positions of their signals.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteSVCandidates(file_name string) {
+	cands := make([]*SVCand, 0)
+	for _, cand := range SV_CANDS {
+		if sig_num := cand.SplitNum + cand.DiscNum + cand.OneEndNum; sig_num >= SV_MIN_SUPPORT {
+			cand.Pos1, cand.Pos2 = cand.Pos1Sum/sig_num, -1
+			if cand.Pos2Sum >= 0 {
+				cand.Pos2 = cand.Pos2Sum / sig_num
+			}
+			cands = append(cands, cand)
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].Pos1 != cands[j].Pos1 {
+			return cands[i].Pos1 < cands[j].Pos1
+		}
+		return cands[i].Pos2 < cands[j].Pos2
+	})
+	w := CreateOutput(file_name)
+	defer w.Close()
+	w.WriteString("#CHROM1\tPOS1\tCHROM2\tPOS2\tORIENT\tSPLIT_READS\tDISCORDANT_PAIRS\tONE_END_PAIRS\n")
+	for _, cand := range cands {
+		chr1, pos1 := VC.ChrLoc(cand.Pos1)
+		chr2, pos2 := ".", "."
+		if cand.Pos2 >= 0 {
+			chr_name, chr_pos := VC.ChrLoc(cand.Pos2)
+			chr2, pos2 = chr_name, strconv.Itoa(chr_pos)
+		}
+		w.WriteString(strings.Join([]string{chr1, strconv.Itoa(pos1), chr2, pos2, cand.Orient, strconv.Itoa(cand.SplitNum),
+			strconv.Itoa(cand.DiscNum), strconv.Itoa(cand.OneEndNum)}, "\t") + "\n")
+	}
+	w.Flush()
+	log.Printf("Number of breakpoint candidates:\t%d, written to:\t%s", len(cands), file_name)
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandChar returns '+' for forward strand and '-' for reverse strand.
+//---------------------------------------------------------------------------------------------------
+func StrandChar(strand bool) string {
+	if strand {
+		return "+"
+	}
+	return "-"
+}
@@ -0,0 +1,366 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: sweep.go
+// Sweep of hard-filter thresholds against a truth set. Thresholds of QUAL, DP, FS and MQ are taken
+// at quantiles of annotations of variant calls, and all combinations of thresholds (including no
+// threshold for each annotation) are evaluated with the truth set within confident regions. The
+// precision/recall frontier (combinations which are not worse than another one in both precision and
+// recall) is reported, and a hard-filter preset is recommended: the combination of maximum F1, or of
+// maximum recall at a minimum precision. FS is computed from FORMAT SB if calls do not have it.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Annotations swept by the tool and operators of their filters (calls fail filters below/above thresholds)
+var SWEEP_KEYS = []string{"QUAL", "DP", "FS", "MQ"}
+var SWEEP_OPS = []string{"<", "<", ">", "<"}
+
+// Quantiles of annotations of calls used as thresholds (fractions of calls failing each threshold)
+var SWEEP_QUANTILES = []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.3}
+
+//---------------------------------------------------------------------------------------------------
+// SweepPoint represents a combination of thresholds (nil: no filter of the annotation) and its
+// concordance counts with the truth set.
+//---------------------------------------------------------------------------------------------------
+type SweepPoint struct {
+	Filters []*FilterInfo
+	Stat    EvalStat
+}
+
+//---------------------------------------------------------------------------------------------------
+// Expr returns comma-separated filter expressions of the point ("none" if there is no filter).
+//---------------------------------------------------------------------------------------------------
+func (P *SweepPoint) Expr() string {
+	exprs := make([]string, 0)
+	for _, f := range P.Filters {
+		if f != nil {
+			exprs = append(exprs, f.Expr)
+		}
+	}
+	if len(exprs) == 0 {
+		return "none"
+	}
+	return strings.Join(exprs, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// SweepCall represents annotations of a call and its numbers of true and false alleles.
+//---------------------------------------------------------------------------------------------------
+type SweepCall struct {
+	Values map[string]float64
+	TP, FP int
+}
+
+//---------------------------------------------------------------------------------------------------
+// SweepFilters evaluates combinations of thresholds of calls of call_file with the truth set within
+// confident regions (all regions if bed_file is empty), and returns the frontier sorted by recall
+// (decreasing). Existing FILTER values of calls are ignored.
+//---------------------------------------------------------------------------------------------------
+func SweepFilters(call_file, truth_file, bed_file string) []*SweepPoint {
+	var regions map[string][][2]int
+	if bed_file != "" {
+		regions = LoadEvalRegions(bed_file)
+	}
+	truths := LoadEvalVariants(truth_file, regions, false)
+	calls, found := LoadSweepCalls(call_file, truths, regions)
+	log.Printf("Number of calls:\t%d, alleles in the truth set:\t%d, found:\t%d", len(calls), len(truths), found)
+
+	// Thresholds of annotations, and levels of calls: the number of thresholds each call passes
+	thres := make([][]float64, len(SWEEP_KEYS))
+	levels := make([][]int, len(calls))
+	for i := range calls {
+		levels[i] = make([]int, len(SWEEP_KEYS))
+	}
+	for k, key := range SWEEP_KEYS {
+		thres[k] = SweepThresholds(calls, key, SWEEP_OPS[k])
+		for i, c := range calls {
+			levels[i][k] = len(thres[k]) // filters are not applied to calls without the annotation
+			for l, t := range thres[k] {
+				if (&FilterInfo{Key: key, Op: SWEEP_OPS[k], Value: t}).Fail(c.Values) {
+					levels[i][k] = l
+					break
+				}
+			}
+		}
+	}
+
+	// Counts of calls by levels, summed over calls passing at least the given levels (suffix sums), so
+	// that calls passing a combination of thresholds are counted in the cell of the combination
+	dims := make([]int, len(SWEEP_KEYS))
+	size := 1
+	for k := range dims {
+		dims[k] = len(thres[k]) + 1
+		size *= dims[k]
+	}
+	cell := func(l []int) int {
+		c := 0
+		for k := range l {
+			c = c*dims[k] + l[k]
+		}
+		return c
+	}
+	tp, fp := make([]int, size), make([]int, size)
+	for i, c := range calls {
+		tp[cell(levels[i])] += c.TP
+		fp[cell(levels[i])] += c.FP
+	}
+	stride := size
+	for k := range dims {
+		stride /= dims[k]
+		for c := size - 1; c >= 0; c-- {
+			if (c/stride)%dims[k] < dims[k]-1 {
+				tp[c] += tp[c+stride]
+				fp[c] += fp[c+stride]
+			}
+		}
+	}
+
+	// Combinations of thresholds: level 0 of an annotation is no filter, level l > 0 is its l-th
+	// threshold, which calls pass if they pass at least l thresholds
+	points := make([]*SweepPoint, 0, size)
+	l := make([]int, len(SWEEP_KEYS))
+	for c := 0; c < size; c++ {
+		for k, r := len(dims)-1, c; k >= 0; k-- {
+			l[k], r = r%dims[k], r/dims[k]
+		}
+		P := &SweepPoint{Filters: make([]*FilterInfo, len(SWEEP_KEYS))}
+		for k, key := range SWEEP_KEYS {
+			if l[k] > 0 {
+				P.Filters[k] = ParseFilter(key + SWEEP_OPS[k] + strconv.FormatFloat(thres[k][l[k]-1], 'g', 6, 64))
+			}
+		}
+		P.Stat = EvalStat{TP: tp[c], FP: fp[c], FN: len(truths) - tp[c]}
+		points = append(points, P)
+	}
+	return SweepFrontier(points)
+}
+
+//---------------------------------------------------------------------------------------------------
+// LoadSweepCalls reads annotations of calls within regions, with numbers of their alleles which are
+// (TP) or are not (FP) in the truth set, and returns calls and the number of alleles found.
+// Homozygous-reference records are ignored.
+//---------------------------------------------------------------------------------------------------
+func LoadSweepCalls(call_file string, truths map[string]string, regions map[string][][2]int) ([]*SweepCall, int) {
+	scanner, done := OpenTextInput(call_file)
+	defer done()
+	calls := make([]*SweepCall, 0)
+	found := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if len(tokens) < 8 {
+			log.Panicf("Error: invalid line in VCF file %s: %s", call_file, line)
+		}
+		if tokens[4] == "." {
+			continue
+		}
+		pos, e := strconv.Atoi(tokens[1])
+		if e != nil {
+			log.Panicf("Error: invalid position in VCF file %s: %s", call_file, line)
+		}
+		if regions != nil && !InIntervals(regions[tokens[0]], pos-1) {
+			continue
+		}
+		format_keys, format_values := "", ""
+		if len(tokens) > 9 {
+			format_keys, format_values = tokens[8], tokens[9]
+			if gt := strings.Split(format_values, ":")[0]; strings.HasPrefix(format_keys, "GT") && strings.Trim(gt, "0/|.") == "" {
+				continue
+			}
+		}
+		qual, _ := strconv.ParseFloat(tokens[5], 64)
+		c := &SweepCall{Values: FilterValues(qual, tokens[7], format_keys, format_values)}
+		if _, ok := c.Values["FS"]; !ok {
+			if sb, ok := FormatField(format_keys, format_values, "SB"); ok {
+				if fs, ok := StrandBiasFS(sb); ok {
+					c.Values["FS"] = fs
+				}
+			}
+		}
+		for _, alt := range strings.Split(tokens[4], ",") {
+			if alt == "." || alt == "*" || strings.HasPrefix(alt, "<") {
+				continue
+			}
+			v_pos, ref, alt := NormalizeAlleles(pos, strings.ToUpper(tokens[3]), strings.ToUpper(alt))
+			if ref == alt {
+				continue
+			}
+			if _, ok := truths[tokens[0]+":"+strconv.Itoa(v_pos)+":"+ref+":"+alt]; ok {
+				c.TP++
+			} else {
+				c.FP++
+			}
+		}
+		found += c.TP
+		calls = append(calls, c)
+	}
+	if e := scanner.Err(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return calls, found
+}
+
+//---------------------------------------------------------------------------------------------------
+// FormatField returns the value of a FORMAT field of a call.
+//---------------------------------------------------------------------------------------------------
+func FormatField(format_keys, format_values, key string) (string, bool) {
+	keys, fields := strings.Split(format_keys, ":"), strings.Split(format_values, ":")
+	for i := 0; i < len(keys) && i < len(fields); i++ {
+		if keys[i] == key {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+//---------------------------------------------------------------------------------------------------
+// StrandBiasFS returns FS, the Phred-scaled p-value of the two-sided Fisher's exact test of strand
+// bias, from SB counts "REF forward,REF reverse,ALT forward,ALT reverse".
+//---------------------------------------------------------------------------------------------------
+func StrandBiasFS(sb string) (float64, bool) {
+	strs := strings.Split(sb, ",")
+	if len(strs) != 4 {
+		return 0, false
+	}
+	var n [4]int
+	for i, s := range strs {
+		var e error
+		if n[i], e = strconv.Atoi(s); e != nil || n[i] < 0 {
+			return 0, false
+		}
+	}
+	return FisherStrand(n), true
+}
+
+//---------------------------------------------------------------------------------------------------
+// SweepThresholds returns distinct thresholds of an annotation at SWEEP_QUANTILES of its values among
+// calls, from the least to the most strict (values above the quantiles of largest values for ">").
+//---------------------------------------------------------------------------------------------------
+func SweepThresholds(calls []*SweepCall, key, op string) []float64 {
+	values := make([]float64, 0, len(calls))
+	for _, c := range calls {
+		if value, ok := c.Values[key]; ok && !math.IsNaN(value) {
+			values = append(values, value)
+		}
+	}
+	thres := make([]float64, 0)
+	if len(values) == 0 {
+		return thres
+	}
+	sort.Float64s(values)
+	for _, q := range SWEEP_QUANTILES {
+		i := int(q * float64(len(values)))
+		if op == ">" {
+			i = len(values) - 1 - i
+		}
+		t, _ := strconv.ParseFloat(strconv.FormatFloat(values[MaxInt(0, MinInt(i, len(values)-1))], 'g', 6, 64), 64)
+		if len(thres) == 0 || t != thres[len(thres)-1] {
+			thres = append(thres, t)
+		}
+	}
+	return thres
+}
+
+//---------------------------------------------------------------------------------------------------
+// SweepFrontier returns points which are not dominated by another point (with at least the same
+// precision and recall, and one of them higher), sorted by recall (decreasing). Of points with the
+// same counts, the point with the fewest filters is kept.
+//---------------------------------------------------------------------------------------------------
+func SweepFrontier(points []*SweepPoint) []*SweepPoint {
+	filter_num := func(P *SweepPoint) int {
+		n := 0
+		for _, f := range P.Filters {
+			if f != nil {
+				n++
+			}
+		}
+		return n
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		ri, rj := points[i].Stat.Recall(), points[j].Stat.Recall()
+		if ri != rj {
+			return ri > rj
+		}
+		pi, pj := points[i].Stat.Precision(), points[j].Stat.Precision()
+		if pi != pj {
+			return pi > pj
+		}
+		return filter_num(points[i]) < filter_num(points[j])
+	})
+	frontier := make([]*SweepPoint, 0)
+	for _, P := range points {
+		if len(frontier) == 0 || P.Stat.Precision() > frontier[len(frontier)-1].Stat.Precision() {
+			frontier = append(frontier, P)
+		}
+	}
+	return frontier
+}
+
+//---------------------------------------------------------------------------------------------------
+// RecommendFilters returns the point of the frontier of maximum F1 (min_prec = 0), or of maximum
+// recall with precision at least min_prec (nil if no point reaches it).
+//---------------------------------------------------------------------------------------------------
+func RecommendFilters(frontier []*SweepPoint, min_prec float64) *SweepPoint {
+	var best *SweepPoint
+	for _, P := range frontier {
+		if min_prec > 0 {
+			if P.Stat.Precision() >= min_prec {
+				return P
+			}
+		} else if best == nil || P.Stat.F1() > best.Stat.F1() {
+			best = P
+		}
+	}
+	return best
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteSweepReport writes concordance counts and metrics of points of the frontier in tab-delimited
+// format, followed by the recommended preset (as a -filter option).
+//---------------------------------------------------------------------------------------------------
+func WriteSweepReport(w io.Writer, frontier []*SweepPoint, best *SweepPoint) {
+	fmt.Fprintln(w, "FILTERS\tTP\tFP\tFN\tPRECISION\tRECALL\tF1")
+	for _, P := range frontier {
+		s := P.Stat
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.4f\t%.4f\t%.4f\n", P.Expr(), s.TP, s.FP, s.FN, s.Precision(), s.Recall(), s.F1())
+	}
+	if best == nil {
+		fmt.Fprintln(w, "#Recommended preset: none (no thresholds reach the minimum precision)")
+	} else if best.Expr() == "none" {
+		fmt.Fprintln(w, "#Recommended preset: no hard filters")
+	} else {
+		fmt.Fprintf(w, "#Recommended preset: -filter \"%s\"\n", best.Expr())
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// WritePresetFile writes filter expressions of a point to a filter file (for -filter-file).
+//---------------------------------------------------------------------------------------------------
+func WritePresetFile(file_name string, P *SweepPoint) {
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	defer f.Close()
+	fmt.Fprintln(f, "# Hard filters recommended by the filter-threshold sweep")
+	for _, filter := range P.Filters {
+		if filter != nil {
+			fmt.Fprintln(f, filter.Expr)
+		}
+	}
+	log.Printf("Recommended hard filters are written to:\t%s", file_name)
+}
@@ -0,0 +1,150 @@
+//----------------------------------------------------------------------------------------
+// Test for local haplotype assembly of dense variant regions
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// RandomSeq returns a random sequence of n bases
+func RandomSeq(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	seq := make([]byte, n)
+	for i := range seq {
+		seq[i] = "ACGT"[r.Intn(4)]
+	}
+	return seq
+}
+
+// Variants of haplotypes are substitutions and left-aligned indels, reported at the base before
+// inserted or deleted bases
+func TestHapVariants(t *testing.T) {
+	ivc.PARA = &ivc.ParaInfo{Sub_cost: 4, Gap_open: 4.1, Gap_ext: 1}
+	ref := "ACGTTTTGCAGCATCG"
+	for _, test := range []struct {
+		hap  string
+		vars []ivc.RealnCand
+	}{
+		{"ACGTTTTGCAGCATCG", []ivc.RealnCand{}},
+		{"ACGTTTTGCTGCATCG", []ivc.RealnCand{{Pos: 109, Ref: "A", Alt: "T", Type: 0}}},
+		{"ACGTTTGCAGCATCG", []ivc.RealnCand{{Pos: 102, Ref: "GT", Alt: "G", Type: 2}}},
+		{"ACGTTTTTTGCAGCATCG", []ivc.RealnCand{{Pos: 102, Ref: "G", Alt: "GTT", Type: 1}}},
+		{"ACGTTTTGCAGCTCG", []ivc.RealnCand{{Pos: 111, Ref: "CA", Alt: "C", Type: 2}}},
+		{"ACCTTTTGCAGCAGTCG", []ivc.RealnCand{{Pos: 102, Ref: "G", Alt: "C", Type: 0}, {Pos: 112, Ref: "A", Alt: "AG", Type: 1}}},
+	} {
+		vars := make([]ivc.RealnCand, 0)
+		for _, v := range ivc.HapVariants([]byte(ref), []byte(test.hap), 100) {
+			vars = append(vars, *v)
+		}
+		if !reflect.DeepEqual(vars, test.vars) {
+			t.Errorf("%s: got variants %v, expected %v", test.hap, vars, test.vars)
+		}
+	}
+}
+
+// Haplotypes are assembled from k-mers found in at least two reads, with k increased for references
+// with repeated k-mers
+func TestAssembleHaplotypes(t *testing.T) {
+	ref := RandomSeq(60, 1)
+	alt := append(append([]byte{}, ref[:30]...), ref[31:]...)
+	err := append([]byte{}, ref...)
+	err[20] = "CGTA"[strings.IndexByte("ACGT", err[20])]
+	haps := ivc.AssembleHaplotypes(ref, [][]byte{alt[:45], alt[10:], err[5:50]})
+	if len(haps) != 2 || string(haps[0]) != string(ref) || string(haps[1]) != string(alt) {
+		t.Errorf("got haplotypes %q, expected the reference and the deletion haplotype", haps)
+	}
+
+	// The reference has repeated 15-mers (but no repeated 25-mers)
+	rep_ref := append(append(append([]byte{}, ref[:40]...), ref[10:30]...), RandomSeq(40, 2)...)
+	rep_alt := append([]byte{}, rep_ref...)
+	rep_alt[50] = "CGTA"[strings.IndexByte("ACGT", rep_alt[50])]
+	haps = ivc.AssembleHaplotypes(rep_ref, [][]byte{rep_alt[15:75], rep_alt[25:90]})
+	if len(haps) != 2 || string(haps[1]) != string(rep_alt) {
+		t.Errorf("got %d haplotypes of a reference with repeats, expected the reference and the substitution haplotype", len(haps))
+	}
+	// The reference has repeated 45-mers
+	if haps := ivc.AssembleHaplotypes(append(append([]byte{}, ref[:50]...), ref[:50]...), [][]byte{ref}); haps != nil {
+		t.Errorf("got %d haplotypes of a reference with long repeats, expected none", len(haps))
+	}
+}
+
+// Dense regions have at least 3 candidate variants supported by 2 reads within 60 bases, padded by
+// k-mer length, and long regions are not assembled
+func TestDenseRegions(t *testing.T) {
+	VC := &ivc.VarCallIndex{SeqLen: 2000}
+	read := func(bases string, pos ...int) *ivc.RealnRead {
+		r := &ivc.RealnRead{}
+		for _, p := range pos {
+			r.Vars = append(r.Vars, &ivc.VarInfo{Pos: uint32(p), Bases: []byte(bases)})
+		}
+		return r
+	}
+	reads := []*ivc.RealnRead{
+		read("A|C", 100, 120, 150), read("A|C", 100, 120), read("A|C", 150, 500),
+		read("A|C", 300, 310, 320), read("A|A", 300, 310, 320), // candidates need 2 reads with variants
+		read("A|C", 500, 600), read("A|C", 600),
+	}
+	for pos := 1000; pos <= 1400; pos += 20 {
+		reads = append(reads, read("AC|A", pos), read("AC|A", pos))
+	}
+	if regions, expected := VC.DenseRegions(reads), [][2]int{{85, 166}}; !reflect.DeepEqual(regions, expected) {
+		t.Errorf("got dense regions %v, expected %v", regions, expected)
+	}
+}
+
+// Dense regions ending with deletions are padded after deleted bases, variants of reads in assembled
+// regions are replaced by variants of their haplotypes or reference alleles, variants outside the
+// regions are kept
+func TestAssembleRegion(t *testing.T) {
+	ivc.PARA = &ivc.ParaInfo{Sub_cost: 4, Gap_open: 4.1, Gap_ext: 1}
+	ref := RandomSeq(300, 3)
+	copy(ref[118:], "TACG")
+	ref[100], ref[110] = 'A', 'G'
+	VC := &ivc.VarCallIndex{Seq: ref, SeqLen: len(ref)}
+	alt := append(append([]byte{}, ref[:120]...), ref[121:]...)
+	alt[100], alt[110] = 'C', 'T'
+	qual := []byte(strings.Repeat("I", 80))
+	outside := &ivc.VarInfo{Pos: 200, Bases: []byte("A|A"), BQual: []byte{'I'}}
+	reads := make([]*ivc.RealnRead, 0)
+	for i := 0; i < 12; i++ {
+		s := 60 + 2*i
+		r := &ivc.RealnRead{Read: ref[s : s+80], Qual: qual, Start: s, Vars: []*ivc.VarInfo{outside}}
+		if i%2 == 0 {
+			r.Read = alt[s : s+80]
+			r.Vars = []*ivc.VarInfo{{Pos: 100, Bases: []byte("A|C")}, {Pos: 110, Bases: []byte("G|T")},
+				{Pos: 119, Type: 2, Bases: []byte("AC|A")}, outside}
+		}
+		reads = append(reads, r)
+	}
+	regions := VC.DenseRegions(reads)
+	if expected := [][2]int{{85, 136}}; !reflect.DeepEqual(regions, expected) {
+		t.Fatalf("got dense regions %v, expected %v", regions, expected)
+	}
+	if VC.AssembleRegion(85, 136, reads[1:2]) {
+		t.Errorf("got an assembled region of reference reads")
+	}
+	if !VC.AssembleRegion(85, 136, reads) {
+		t.Fatalf("got no assembled region")
+	}
+	for i, r := range reads {
+		expected := map[uint32]string{100: "A|A", 110: "G|G", 119: "A|A", 200: "A|A"}
+		if i%2 == 0 {
+			expected[100], expected[110], expected[119] = "A|C", "G|T", "AC|A"
+		}
+		vars := make(map[uint32]string)
+		for _, v := range r.Vars {
+			vars[v.Pos] = string(v.Bases)
+		}
+		if !reflect.DeepEqual(vars, expected) || !r.Asm {
+			t.Errorf("read %d: got variants %v, expected %v", i, vars, expected)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+//----------------------------------------------------------------------------------------
+// Test for the AVX2 backend of the alignment DP (go test -tags avx2)
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+//go:build cgo && avx2
+// +build cgo,avx2
+
+package ivc_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// MutateFlank returns a copy of a flank with random substitutions, insertions and deletions.
+func MutateFlank(rand_gen *rand.Rand, flank []byte) []byte {
+	var read []byte
+	for _, b := range flank {
+		switch r := rand_gen.Intn(100); {
+		case r < 4:
+			read = append(read, "ACGT"[rand_gen.Intn(4)])
+		case r < 6:
+			read = append(read, b, "ACGT"[rand_gen.Intn(4)])
+		case r < 8:
+		default:
+			read = append(read, b)
+		}
+	}
+	return read
+}
+
+// Edit parts of random flank alignments get the same results with the AVX2 and the CPU backends
+func TestAVX2Backend(t *testing.T) {
+	new_backend, ok := ivc.ALN_BACKENDS["avx2"]
+	if !ok {
+		t.Skip("AVX2 is not supported")
+	}
+	rand_gen := rand.New(rand.NewSource(1))
+	seq := make([]byte, 1000)
+	for i := range seq {
+		seq[i] = "ACGT"[rand_gen.Intn(4)]
+	}
+	VC := &ivc.VarCallIndex{Seq: seq, SeqLen: len(seq), Variants: make(map[int][][]byte), VarAF: make(map[int][]float32),
+		SameLenVar: make(map[int]int), DelVar: make(map[int]int)}
+	// Known SNPs make some edit parts filled in Go
+	for pos := 50; pos < len(seq); pos += 97 {
+		VC.Variants[pos], VC.VarAF[pos], VC.SameLenVar[pos] = [][]byte{{seq[pos]}, {"ACGT"[(rand_gen.Intn(3)+1+int(seq[pos]))%4]}}, []float32{0.5, 0.5}, 1
+		seq[pos] = '*'
+	}
+	VC.Calls = []*ivc.VarProf{{Sites: ivc.NewSiteStore()}}
+	ivc.SetupTsTv(2.1)
+	defer ivc.SetupTsTv(0)
+	cpu, avx2 := ivc.CPUBackend{}, new_backend()
+	cpu_info, avx2_info := ivc.InitEditAlnInfo(200), ivc.InitEditAlnInfo(200)
+	task_num, edit_num := 0, 0
+	for _, band := range []int{0, 5} {
+		ivc.PARA = &ivc.ParaInfo{Proc_num: 1, Dist_thres: 24, Sub_cost: 4, Gap_open: 4.1, Gap_ext: 1, Ham_backup: 5, Indel_backup: 10, Aln_band: band}
+		A := VC.Aligner()
+		for k := 0; k < 500; k++ {
+			var cpu_tasks, avx2_tasks [4]ivc.AlnTask
+			for i := range cpu_tasks {
+				start, flank_len := 10+rand_gen.Intn(len(seq)-200), 20+rand_gen.Intn(100)
+				ref_pos_map := make([]int, flank_len)
+				ref := make([]byte, flank_len)
+				for j := range ref_pos_map {
+					ref_pos_map[j] = start + j
+					if ref[j] = seq[start+j]; ref[j] == '*' {
+						ref[j] = VC.Variants[start+j][0][0]
+					}
+				}
+				read := MutateFlank(rand_gen, ref)
+				qual, cyc_cost := make([]byte, len(read)), make([]float64, len(read))
+				for j := range qual {
+					qual[j], cyc_cost[j] = 'I', rand_gen.Float64()-0.5
+				}
+				cpu_tasks[i] = ivc.AlnTask{Read: read, Qual: qual, Ref: ref, Pos: start, RefPosMap: ref_pos_map, DropRows: A.XDropRows(ref_pos_map),
+					Left: i%2 == 0, Info: cpu_info}
+				if k%2 == 0 {
+					cpu_tasks[i].CycCost = cyc_cost
+				}
+				avx2_tasks[i] = cpu_tasks[i]
+				avx2_tasks[i].Info = avx2_info
+			}
+			cpu.AlignBatch(A, []*ivc.AlnTask{&cpu_tasks[0], &cpu_tasks[1], &cpu_tasks[2], &cpu_tasks[3]})
+			avx2.AlignBatch(A, []*ivc.AlnTask{&avx2_tasks[0], &avx2_tasks[1], &avx2_tasks[2], &avx2_tasks[3]})
+			for i := range cpu_tasks {
+				c, a := &cpu_tasks[i], &avx2_tasks[i]
+				task_num++
+				if c.M > 0 && c.N > 0 && c.HamDist <= A.Para.Dist_thres {
+					edit_num++
+				}
+				if c.HamDist != a.HamDist || c.EditDist != a.EditDist || c.BtMat != a.BtMat || c.M != a.M || c.N != a.N || len(c.VarPos) != len(a.VarPos) {
+					t.Fatalf("band %d, task %d: got %g, %g, %d, %d, %d, expected %g, %g, %d, %d, %d", band, task_num,
+						a.HamDist, a.EditDist, a.BtMat, a.M, a.N, c.HamDist, c.EditDist, c.BtMat, c.M, c.N)
+				}
+			}
+		}
+	}
+	if edit_num < task_num/4 {
+		t.Errorf("only %d of %d tasks have edit parts", edit_num, task_num)
+	}
+}
@@ -0,0 +1,86 @@
+//----------------------------------------------------------------------------------------
+// Test for base alignment qualities of mismatches
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+const BAQ_REF = "GATTACAGCTGACCTAGGCATCGTAGCTTGACGGATCCATGCAAGTCGTACGGTCTAGCATGCA"
+
+// SetupBaseQuals sets up error probabilities of Phred-scaled qualities
+func SetupBaseQuals() {
+	for q := 33; q < 105; q++ {
+		ivc.Q2E[q] = math.Pow(10, -(float64(q)-33)/10.0) / 3.0
+		ivc.Q2P[q] = 1.0 - math.Pow(10, -(float64(q)-33)/10.0)
+	}
+}
+
+// Mismatches of reads aligned as substitutions (from position start of the reference)
+func Mismatches(read string, start int) []*ivc.VarInfo {
+	vars := make([]*ivc.VarInfo, 0)
+	for i := range read {
+		if read[i] != BAQ_REF[start+i] {
+			vars = append(vars, &ivc.VarInfo{Pos: uint32(start + i), Bases: []byte(BAQ_REF[start+i:start+i+1] + "|" + read[i:i+1]),
+				BQual: []byte{'I'}})
+		}
+	}
+	return vars
+}
+
+// Posterior probabilities of bases of a read matching the reference are concentrated on the diagonal
+func TestBAQPosterior(t *testing.T) {
+	SetupBaseQuals()
+	B := new(ivc.BAQBuf)
+	read := BAQ_REF[17:57]
+	ivc.BAQPosterior(B, []byte(read), []byte(strings.Repeat("I", len(read))), []byte(BAQ_REF[10:64]), 7, 0, 0)
+	for i := 0; i < len(read); i++ {
+		sum := 0.0
+		for k := 0; k < 54; k++ {
+			sum += B.Post(i, k)
+		}
+		if post := B.Post(i, i+7); post < 0.99 || sum > 1+1e-9 {
+			t.Errorf("read base %d: got posterior probability %g on the diagonal, %g in total", i, post, sum)
+		}
+	}
+}
+
+// Qualities of mismatches explained by a misaligned deletion are capped, qualities of mismatches away
+// from indels (after an insertion of the read) are not, and buffers are reused for reads of any length
+func TestApplyBAQ(t *testing.T) {
+	SetupBaseQuals()
+	VC := &ivc.VarCallIndex{Seq: []byte(BAQ_REF), SeqLen: len(BAQ_REF)}
+	B := new(ivc.BAQBuf)
+
+	// Deletion of a base near the end of the read, aligned as mismatches
+	read := BAQ_REF[10:45] + BAQ_REF[46:51]
+	vars := Mismatches(read, 10)
+	if len(vars) != 5 {
+		t.Fatalf("got %d mismatches, expected 5", len(vars))
+	}
+	VC.ApplyBAQ(B, []byte(read), []byte(strings.Repeat("I", len(read))), 10, vars)
+	for _, v := range vars {
+		if v.BQual[0] > '!'+3 {
+			t.Errorf("position %d: got quality %d of a misaligned mismatch, expected at most 3", v.Pos, v.BQual[0]-33)
+		}
+	}
+
+	// Insertion of a base, followed by a mismatch in the middle of the read
+	for _, B := range []*ivc.BAQBuf{B, new(ivc.BAQBuf)} {
+		read := []byte(BAQ_REF[20:30] + "G" + BAQ_REF[30:50])
+		read[21] = 'A'
+		ins := &ivc.VarInfo{Pos: 29, Type: 1, Bases: []byte(BAQ_REF[29:30] + "|" + BAQ_REF[29:30] + "G"), BQual: []byte{'I', 'I'}}
+		sub := &ivc.VarInfo{Pos: 40, Bases: []byte(BAQ_REF[40:41] + "|A"), BQual: []byte{'I'}}
+		VC.ApplyBAQ(B, read, []byte(strings.Repeat("I", len(read))), 20, []*ivc.VarInfo{ins, sub})
+		if sub.BQual[0] != 'I' || string(ins.BQual) != "II" {
+			t.Errorf("got qualities %d of the mismatch, %s of the insertion, expected 40, II", sub.BQual[0]-33, ins.BQual)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+//----------------------------------------------------------------------------------------
+// Test for the calibration report of QUAL of variant calls
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Allele balances fit heterozygous genotypes within [0.2, 0.8] and homozygous or haploid genotypes from
+// 0.8, with depths of all alleles or of alternative alleles and DP
+func TestAlleleBalanceFits(t *testing.T) {
+	for _, test := range []struct {
+		gt, ad, dp string
+		fits, ok   bool
+	}{
+		{"0/1", "10,10", "", true, true},
+		{"0|1", "18,2", "", false, true},
+		{"1/2", "2,9,9", "", false, true},
+		{"1/1", "2,18", "", true, true},
+		{"1/1", "5,15", "", false, true},
+		{"1", "9", "10", true, true},
+		{"1", "5", "10", false, true},
+		{"0/1", "5", ".", false, false},
+		{"0/1", "", "20", false, false},
+		{"0/1", "0,0", "", false, false},
+		{"", "10,10", "20", false, false},
+	} {
+		if fits, ok := ivc.AlleleBalanceFits(test.gt, test.ad, test.dp); fits != test.fits || ok != test.ok {
+			t.Errorf("GT %s, AD %s, DP %s: got %v, %v, expected %v, %v", test.gt, test.ad, test.dp, fits, ok, test.fits, test.ok)
+		}
+	}
+}
+
+// Calls are binned by QUAL and counted as correct if they are in the truth set, or if their allele
+// balances fit their genotypes without a truth set
+func TestCalibrate(t *testing.T) {
+	dir := t.TempDir()
+	call_file := WriteTestFile(t, dir, "calls.vcf",
+		"##fileformat=VCFv4.2",
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE",
+		"chr1\t10\t.\tA\tC\t5\tPASS\t.\tGT:AD\t0/1:10,10",
+		"chr1\t20\t.\tA\tG\t8\tPASS\t.\tGT:AD\t0/1:18,2",
+		"chr1\t30\t.\tA\tT\t25\tPASS\t.\tGT:AD\t1/1:0,20",
+		"chr1\t40\t.\tA\tT\t250\tLowQual\t.\tGT:AD\t0/1:2,18",
+		"chr1\t50\t.\tA\t.\t30\tPASS\t.\tGT:AD\t0/0:20",
+		"chr1\t60\t.\tA\tC\t30\tPASS\t.\tGT:AD\t0/0:20,0",
+		"chr1\t70\t.\tA\tC\t.\tPASS\t.\tGT:AD\t0/1:10,10",
+		"chr1\t900\t.\tA\tC\t40\tPASS\t.\tGT:AD\t0/1:10,10")
+	truth_file := WriteTestFile(t, dir, "truth.vcf",
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO",
+		"chr1\t10\t.\tA\tC\t.\tPASS\t.",
+		"chr1\t30\t.\tA\tT\t.\tPASS\t.",
+		"chr1\t40\t.\tA\tT\t.\tPASS\t.",
+		"chr1\t900\t.\tA\tC\t.\tPASS\t.")
+	bed_file := WriteTestFile(t, dir, "regions.bed", "chr1\t0\t500")
+	for _, test := range []struct {
+		truth_file, bed_file string
+		bins                 map[int]ivc.CalibBin
+	}{
+		{truth_file, bed_file, map[int]ivc.CalibBin{0: {Num: 2, Correct: 1}, 2: {Num: 1, Correct: 1}, 9: {Num: 1, Correct: 1}}},
+		{truth_file, "", map[int]ivc.CalibBin{0: {Num: 2, Correct: 1}, 2: {Num: 1, Correct: 1}, 4: {Num: 1, Correct: 1}, 9: {Num: 1, Correct: 1}}},
+		{"", bed_file, map[int]ivc.CalibBin{0: {Num: 2, Correct: 1}, 2: {Num: 1, Correct: 1}, 4: {Num: 1, Correct: 1}, 9: {Num: 1}}},
+	} {
+		bins := ivc.Calibrate(call_file, test.truth_file, test.bed_file)
+		if len(bins) != ivc.CALIB_BIN_NUM {
+			t.Fatalf("got %d bins, expected %d", len(bins), ivc.CALIB_BIN_NUM)
+		}
+		for i, b := range bins {
+			expected := test.bins[i]
+			if b.Num != expected.Num || b.Correct != expected.Correct {
+				t.Errorf("truth set %q, regions %q, bin %d: got %d calls, %d correct, expected %d, %d", test.truth_file, test.bed_file,
+					i, b.Num, b.Correct, expected.Num, expected.Correct)
+			}
+		}
+		if pred := (2 - math.Pow(10, -0.5) - math.Pow(10, -0.8)) / 2; math.Abs(bins[0].PredAcc()-pred) > 1e-9 {
+			t.Errorf("got predicted accuracy %g of bin 0, expected %g", bins[0].PredAcc(), pred)
+		}
+	}
+}
+
+// Reports have accuracies and Phred scores of non-empty bins and the expected calibration error
+func TestWriteCalibReport(t *testing.T) {
+	bins := make([]*ivc.CalibBin, ivc.CALIB_BIN_NUM)
+	for i := range bins {
+		bins[i] = new(ivc.CalibBin)
+	}
+	bins[0] = &ivc.CalibBin{Num: 2, Correct: 1, PredSum: 1.8}
+	bins[9] = &ivc.CalibBin{Num: 2, Correct: 2, PredSum: 2}
+	var report bytes.Buffer
+	ivc.WriteCalibReport(&report, bins, true)
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != ivc.CALIB_BIN_NUM+3 {
+		t.Fatalf("got %d lines, expected %d", len(lines), ivc.CALIB_BIN_NUM+3)
+	}
+	for i, expected := range map[int]string{
+		0:  "#Empirical accuracy: fraction of calls in the truth set",
+		2:  "0-10\t2\t1\t0.9000\t0.5000\t10.0\t3.0",
+		3:  "10-20\t0\t0\t.\t.\t.\t.",
+		11: ">=90\t2\t2\t1.0000\t1.0000\t1000.0\t1000.0",
+		12: "ECE\t4\t.\t.\t0.2000\t.\t.",
+	} {
+		if lines[i] != expected {
+			t.Errorf("line %d: got %q, expected %q", i, lines[i], expected)
+		}
+	}
+}
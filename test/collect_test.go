@@ -0,0 +1,122 @@
+//----------------------------------------------------------------------------------------
+// Test for concurrent updates of variant calls by aligning goroutines
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// NewCollectIndex returns a variant caller of a 5000-base genome with empty variant calls of 4 shards,
+// keeping at most 3 aligned bases per position
+func NewCollectIndex() *ivc.VarCallIndex {
+	ivc.PARA = &ivc.ParaInfo{Proc_num: 4, Read_len: 100, Max_depth: 3}
+	ivc.L2E = []float64{1, 0.0001}
+	VC := &ivc.VarCallIndex{SeqLen: 5000}
+	VC.InitVarCall()
+	return VC
+}
+
+// CollectBases returns aligned bases of n reads at positions spread over the genome
+func CollectBases(n int) []*ivc.VarInfo {
+	vars := make([]*ivc.VarInfo, n)
+	for i := range vars {
+		bases := "A|A"
+		if i%3 == 0 {
+			bases = "A|C"
+		}
+		vars[i] = &ivc.VarInfo{Pos: uint32(i * 7919 % 5000), Bases: []byte(bases), BQual: []byte{'I'}, Rev: i%2 == 0,
+			RSeed: ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(i)))}
+	}
+	return vars
+}
+
+// Aligned bases collected by concurrent goroutines, while others look up candidate positions, give the
+// same variant calls as bases collected one by one (run with -race to check locking)
+func TestConcurrentCollect(t *testing.T) {
+	serial := NewCollectIndex()
+	for _, v := range CollectBases(20000) {
+		serial.CollectVariant(v)
+	}
+	serial.ApplyKeptBases()
+
+	VC := NewCollectIndex()
+	vars := CollectBases(20000)
+	var wg, lookup_wg, started sync.WaitGroup
+	done := make(chan bool)
+	for g := 0; g < 2; g++ {
+		lookup_wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer lookup_wg.Done()
+			started.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for pos := 0; pos < VC.SeqLen; pos += 7 {
+					VC.HasSite(pos)
+				}
+			}
+		}()
+	}
+	started.Wait()
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(vars); i += 8 {
+				VC.CollectVariant(vars[i])
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(done)
+	lookup_wg.Wait()
+	VC.ApplyKeptBases()
+
+	for pos := 0; pos < VC.SeqLen; pos++ {
+		if !VC.HasSite(pos) {
+			t.Fatalf("position %d is not a candidate variant position", pos)
+		}
+	}
+	for rid := range VC.Calls {
+		if !reflect.DeepEqual(VC.Calls[rid].VarDepth, serial.Calls[rid].VarDepth) {
+			t.Errorf("shard %d: got depths %v, expected %v", rid, VC.Calls[rid].VarDepth, serial.Calls[rid].VarDepth)
+		}
+	}
+	for pos := uint32(0); pos < uint32(VC.SeqLen); pos++ {
+		rid := 4 * int(pos) / VC.SeqLen
+		site, serial_site := VC.Calls[rid].Sites.Get(pos), serial.Calls[rid].Sites.Get(pos)
+		if !reflect.DeepEqual(site.RNum.Map(), serial_site.RNum.Map()) || !reflect.DeepEqual(site.RevNum.Map(), serial_site.RevNum.Map()) {
+			t.Errorf("position %d: got numbers of reads %v, %v, expected %v, %v", pos, site.RNum.Map(), site.RevNum.Map(),
+				serial_site.RNum.Map(), serial_site.RevNum.Map())
+		}
+	}
+}
+
+// BenchmarkCollectVariant measures updates of variant calls by parallel goroutines, which contend only for
+// locks of shards (go test -race -bench CollectVariant checks locking)
+func BenchmarkCollectVariant(b *testing.B) {
+	VC := NewCollectIndex()
+	vars := CollectBases(100000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			v := *vars[i%len(vars)]
+			VC.CollectVariant(&v)
+			VC.HasSite(int(v.Pos))
+			i++
+		}
+	})
+}
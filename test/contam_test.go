@@ -0,0 +1,141 @@
+//----------------------------------------------------------------------------------------
+// Test for estimation of cross-sample contamination
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+	"github.com/namsyvo/IVC/caller"
+)
+
+// NewContamIndex returns a variant caller of a genome with known SNPs A/G at all positions, with given
+// frequencies of G (variant calls are not initialized)
+func NewContamIndex(afs ...float32) *ivc.VarCallIndex {
+	ivc.PARA = &ivc.ParaInfo{Proc_num: 2, Read_len: 100, Err_rate: 0.003, AF_weight: 0.5}
+	ivc.L2E = []float64{1, 0.0001}
+	VC := &ivc.VarCallIndex{Seq: []byte(strings.Repeat("*", len(afs))), SeqLen: len(afs), Variants: make(map[int][][]byte),
+		VarAF: make(map[int][]float32)}
+	for pos, af := range afs {
+		VC.Variants[pos], VC.VarAF[pos] = [][]byte{[]byte("A"), []byte("G")}, []float32{1 - af, af}
+	}
+	return VC
+}
+
+// Frequencies of alternative alleles are taken at known biallelic SNPs, blended with population
+// frequencies
+func TestKnownAltAF(t *testing.T) {
+	defer func() { ivc.POP_AF = nil }()
+	VC := NewContamIndex(0.5, 0.5, 0.2, 0)
+	VC.Variants[1] = [][]byte{[]byte("A"), []byte("G"), []byte("T")}
+	VC.Variants[2] = [][]byte{[]byte("A"), []byte("AG")}
+	VC.VarAF[3] = []float32{0, 0}
+	ivc.POP_AF = map[int]map[string]float32{0: {"G": 0.9}}
+	for _, test := range []struct {
+		pos int
+		af  float64
+		ok  bool
+	}{
+		{0, 0.7, true},
+		{1, 0, false},
+		{2, 0, false},
+		{3, 0, false},
+		{4, 0, false},
+	} {
+		ref, alt, af, ok := VC.KnownAltAF(test.pos)
+		if ok != test.ok || math.Abs(af-test.af) > 1e-6 || ok && (ref != "A" || alt != "G") {
+			t.Errorf("position %d: got %s/%s, frequency %g, %v, expected frequency %g, %v", test.pos, ref, alt, af, ok, test.af, test.ok)
+		}
+	}
+}
+
+// Contamination is estimated from reads of the other allele at homozygous known SNPs, ignoring
+// heterozygous sites, low-depth sites and sites of rare alleles
+func TestEstimateContamination(t *testing.T) {
+	afs := make([]float32, 0)
+	nums := make([][2]int, 0)
+	add := func(n int, af float32, ref_num, alt_num int) {
+		for i := 0; i < n; i++ {
+			afs, nums = append(afs, af), append(nums, [2]int{ref_num, alt_num})
+		}
+	}
+	add(150, 0.5, 95, 5) // homozygous reference, 10% contamination
+	add(50, 0.5, 5, 95)  // homozygous alternative
+	add(20, 0.5, 50, 50) // heterozygous
+	add(20, 0.5, 9, 0)   // low depth
+	add(20, 0.01, 95, 5) // rare alternative allele
+	for _, test := range []struct {
+		site_num int
+		min, max float64
+	}{
+		{200, 0.09, 0.1},
+		{99, 0, 0},
+	} {
+		VC := NewContamIndex(afs...)
+		VC.InitVarCall()
+		r := 0
+		for pos, num := range nums {
+			if pos >= test.site_num && pos < 200 {
+				continue
+			}
+			for i := 0; i < num[0]+num[1]; i++ {
+				bases := "A|A"
+				if i >= num[0] {
+					bases = "A|G"
+				}
+				VC.CollectVariant(&ivc.VarInfo{Pos: uint32(pos), Bases: []byte(bases), BQual: []byte{'I'},
+					RSeed: ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(r)))})
+				r++
+			}
+		}
+		VC.ApplyKeptBases()
+		if c := VC.EstimateContamination(); c < test.min || c > test.max || ivc.SUMMARY.ContamSiteNum != int64(test.site_num) {
+			t.Errorf("got contamination %g from %d sites, expected within [%g, %g] from %d sites", c, ivc.SUMMARY.ContamSiteNum,
+				test.min, test.max, test.site_num)
+		}
+	}
+}
+
+// Likelihoods of alleles of known SNPs are scaled by fractions of reads kept after removing expected
+// contaminant reads
+func TestContamAdjustedLike(t *testing.T) {
+	defer func() { ivc.CONTAM_FRAC = 0 }()
+	VC := NewContamIndex(0.5)
+	VC.Variants[1] = [][]byte{[]byte("A"), []byte("AG")}
+	like := new(caller.SiteLike)
+	*like.Allele("A") = caller.AlleleLike{Both: 900, One: 90, None: 18}
+	*like.Allele("G") = caller.AlleleLike{Both: 100, One: 10, None: 2}
+	*like.Allele("T") = caller.AlleleLike{Both: 3, One: 2, None: 1}
+	var_num := map[string]int{"A|A": 90, "A|G": 10}
+	if adj_like := VC.ContamAdjustedLike(0, like, var_num); adj_like != like {
+		t.Errorf("got adjusted likelihoods without contamination")
+	}
+	ivc.CONTAM_FRAC = 0.1
+	if adj_like := VC.ContamAdjustedLike(1, like, var_num); adj_like != like {
+		t.Errorf("got adjusted likelihoods at a known indel")
+	}
+	expected := map[string]*caller.AlleleLike{"A": {Both: 850, One: 85, None: 17}, "G": {Both: 50, One: 5, None: 1},
+		"T": {Both: 3, One: 2, None: 1}}
+	if adj_like := VC.ContamAdjustedLike(0, like, var_num).Map(); !reflect.DeepEqual(adj_like, expected) {
+		t.Errorf("got adjusted likelihoods %v, expected %v", adj_like, expected)
+	}
+	for _, test := range []struct {
+		read_num         int
+		contam_num, keep float64
+	}{
+		{0, 5, 1},
+		{10, 5, 0.5},
+		{4, 5, 0},
+	} {
+		if keep := ivc.ContamKeep(test.read_num, test.contam_num); keep != test.keep {
+			t.Errorf("%d reads, %g contaminant reads: got %g kept, expected %g", test.read_num, test.contam_num, keep, test.keep)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+//----------------------------------------------------------------------------------------
+// Test for the context-aware sequencing error model
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Homopolymers containing positions or starting right after them are found at run boundaries, next to
+// known variant loci and at ends of the genome
+func TestHomopolymerLen(t *testing.T) {
+	seq := "AACCCGTA*AAA" + "TTTTTTTTTTTTTTT" + "G"
+	VC := &ivc.VarCallIndex{Seq: []byte(seq), SeqLen: len(seq)}
+	for _, test := range []struct {
+		pos, hp_len int
+	}{
+		{0, 2},   // start of the genome
+		{1, 3},   // last base of a run followed by a longer run
+		{2, 3},   // first base of a run
+		{4, 3},   // last base of a run followed by a shorter run
+		{5, 1},   // single base
+		{7, 1},   // base before a known variant locus
+		{8, 3},   // known variant locus followed by a run
+		{11, 10}, // base before a long run (capped)
+		{19, 10}, // base within a long run (capped)
+		{27, 1},  // end of the genome
+	} {
+		if hp_len := VC.HomopolymerLen(test.pos); hp_len != test.hp_len {
+			t.Errorf("position %d: got homopolymer length %d, expected %d", test.pos, hp_len, test.hp_len)
+		}
+	}
+}
+
+// Saved context tables are loaded with the same factors, dinucleotides missing from the table do
+// not take factors of the default table
+func TestContextModelSaveLoad(t *testing.T) {
+	C := &ivc.ContextModel{HPFactor: []float64{1, 1, 1.25, 2.5, 4, 8, 8.5, 9, 12.125, 20, 33.5}, DNFactor: map[string]float64{"AC": 0.5, "TG": 3}}
+	file_name := filepath.Join(t.TempDir(), "context.txt")
+	C.Save(file_name)
+	L := ivc.LoadContextModel(file_name)
+	for hp_len := 1; hp_len <= ivc.MAX_HOMOPOLYMER_LEN; hp_len++ {
+		if L.HPFactor[hp_len] != C.HPFactor[hp_len] {
+			t.Errorf("homopolymer length %d: got factor %g, expected %g", hp_len, L.HPFactor[hp_len], C.HPFactor[hp_len])
+		}
+	}
+	for _, b1 := range ivc.STD_BASES {
+		for _, b2 := range ivc.STD_BASES {
+			dn, expected := string(b1)+string(b2), 1.0
+			if factor, ok := C.DNFactor[dn]; ok {
+				expected = factor
+			}
+			if L.DNFactor[dn] != expected {
+				t.Errorf("dinucleotide %s: got factor %g, expected %g", dn, L.DNFactor[dn], expected)
+			}
+		}
+	}
+}
+
+// Context costs of alignment are reduced by factors, but not below the gap extension cost and 0
+func TestContextCostClamping(t *testing.T) {
+	defer func() { ivc.CONTEXT = nil }()
+	ivc.PARA = &ivc.ParaInfo{Gap_open: 4.1, Gap_ext: 1, Sub_cost: 4}
+	seq := "ACGGGAAAAAAAAAAT"
+	VC := &ivc.VarCallIndex{Seq: []byte(seq), SeqLen: len(seq)}
+	ivc.CONTEXT = &ivc.ContextModel{HPFactor: []float64{1, 1, 1, 10, 1, 1, 1, 1, 1, 1, 1e6}, DNFactor: map[string]float64{"GG": 100, "AC": 1e9}}
+	for _, test := range []struct {
+		pos                int
+		gap_open, sub_cost float64
+	}{
+		{0, 4.1, 4}, // no context factors
+		{3, 3.1, 2}, // homopolymer of length 3 and dinucleotide GG
+		{1, 3.1, 0}, // clamped substitution cost
+		{8, 1, 4},   // clamped gap open cost
+	} {
+		if gap_open := VC.ContextGapOpen(test.pos); math.Abs(gap_open-test.gap_open) > 1e-9 {
+			t.Errorf("position %d: got gap open cost %g, expected %g", test.pos, gap_open, test.gap_open)
+		}
+		if sub_cost := VC.ContextSubCost(test.pos); math.Abs(sub_cost-test.sub_cost) > 1e-9 {
+			t.Errorf("position %d: got substitution cost %g, expected %g", test.pos, sub_cost, test.sub_cost)
+		}
+	}
+}
+
+// Context tables are learned from positions mostly agreeing with the reference, contexts without
+// observations get factors of 1
+func TestLearnContextModel(t *testing.T) {
+	ivc.CONTEXT = nil
+	ivc.PARA = &ivc.ParaInfo{Proc_num: 1, Read_len: 100}
+	ivc.L2E = []float64{1, 0.0001}
+	seq := "ACGTAAAAACGT"
+	VC := &ivc.VarCallIndex{Seq: []byte(seq), SeqLen: len(seq)}
+	VC.InitVarCall()
+	i := 0
+	collect := func(pos uint32, bases string, n int) {
+		for ; n > 0; n-- {
+			VC.CollectVariant(&ivc.VarInfo{Pos: pos, Bases: []byte(bases), BQual: []byte{'I'}, RSeed: ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(i)))})
+			i++
+		}
+	}
+	collect(6, "A|A", 18) // homopolymer of length 5, dinucleotide AA
+	collect(6, "AA|A", 2)
+	collect(1, "C|C", 20) // homopolymer of length 1, dinucleotide AC
+	collect(1, "C|T", 1)
+	collect(10, "G|G", 5) // variant site (less than 80% of reads agree with the reference)
+	collect(10, "G|T", 5)
+	VC.ApplyKeptBases()
+	C := VC.LearnContextModel()
+	for _, test := range []struct {
+		name             string
+		factor, expected float64
+	}{
+		{"HP1", C.HPFactor[1], 1},
+		{"HP2", C.HPFactor[2], 1},
+		{"HP5", C.HPFactor[5], (2 + 10.0/23) / 30 * 23},         // baseline rate 1/23
+		{"AC", C.DNFactor["AC"], (1 + 10*2.0/43) / 31 * 43 / 2}, // baseline rate 2/43
+		{"AA", C.DNFactor["AA"], (10 * 2.0 / 43) / 30 * 43 / 2},
+	} {
+		if math.Abs(test.factor-test.expected) > 1e-9 {
+			t.Errorf("%s: got factor %g, expected %g", test.name, test.factor, test.expected)
+		}
+	}
+	if _, ok := C.DNFactor["CG"]; ok {
+		t.Errorf("got factor of CG from a variant site")
+	}
+}
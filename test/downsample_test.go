@@ -0,0 +1,112 @@
+//----------------------------------------------------------------------------------------
+// Test for downsampling of aligned reads at positions with too many of them
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// KeptReads adds one aligned base of each read (in the given order) to a reservoir of 10 bases and
+// returns indexes of the kept reads
+func KeptReads(order []int) []int {
+	R := new(ivc.Reservoir)
+	for _, i := range order {
+		seed := ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(i)))
+		R.Add(ivc.BasePriority(seed, 100), &ivc.VarInfo{Pos: 100, Cycle: i}, 10)
+	}
+	var kept []int
+	for _, v := range R.Kept() {
+		kept = append(kept, v.Cycle)
+	}
+	sort.Ints(kept)
+	return kept
+}
+
+// The same reads are kept whatever order they come in, and reads past the cap can be kept
+func TestReservoir(t *testing.T) {
+	order := make([]int, 1000)
+	for i := range order {
+		order[i] = i
+	}
+	kept := KeptReads(order)
+	if len(kept) != 10 || kept[len(kept)-1] < 10 {
+		t.Errorf("got kept reads %v", kept)
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	if rev_kept := KeptReads(order); !reflect.DeepEqual(rev_kept, kept) {
+		t.Errorf("got kept reads %v in reverse order, %v in input order", rev_kept, kept)
+	}
+	// Reads of later parts of the input are kept as often as reads of earlier parts
+	late_num := 0
+	for pos := uint32(0); pos < 1000; pos++ {
+		R := new(ivc.Reservoir)
+		for i := 0; i < 100; i++ {
+			seed := ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(i)))
+			R.Add(ivc.BasePriority(seed, pos), &ivc.VarInfo{Pos: pos, Cycle: i}, 10)
+		}
+		for _, v := range R.Kept() {
+			if v.Cycle >= 50 {
+				late_num++
+			}
+		}
+	}
+	if late_num < 4500 || late_num > 5500 {
+		t.Errorf("got %d kept reads of the second half of 10000 kept reads", late_num)
+	}
+}
+
+// Aligned bases beyond the cap are counted, only the kept ones update the variant location
+func TestUpdateVariantProb(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Proc_num, ivc.PARA.Max_depth = 1, 5
+	ivc.L2E = []float64{0, 0.001}
+	VC := &ivc.VarCallIndex{SeqLen: 1000}
+	VC.Calls = []*ivc.VarProf{{VarProb: ivc.NewGenoStore(), Sites: ivc.NewSiteStore(), VarDepth: make(map[uint32]int),
+		Kept: make(map[uint32]*ivc.Reservoir)}}
+	for i := 0; i < 20; i++ {
+		VC.CollectVariant(&ivc.VarInfo{Pos: 100, Bases: []byte("A|C"), BQual: []byte("I"), RSeed: int64(i)})
+	}
+	if n := VC.Calls[0].Sites.Get(100).RNum.Get("A|C"); n != 0 {
+		t.Errorf("got %d reads before kept bases are applied", n)
+	}
+	VC.ApplyKeptBases()
+	if n := VC.Calls[0].Sites.Get(100).RNum.Get("A|C"); n != 5 || !VC.Downsampled(0, 100) {
+		t.Errorf("got %d reads (downsampled: %t)", n, VC.Downsampled(0, 100))
+	}
+}
+
+// The cap of -max-depth is lowered to the hard cap, reads past the cap of -max-depth can be used
+func TestMaxDepth(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	for _, c := range [][2]int{{0, ivc.MAX_EVIDENCE_NUM}, {250, 250}, {2 * ivc.MAX_EVIDENCE_NUM, ivc.MAX_EVIDENCE_NUM}} {
+		if ivc.PARA.Max_depth = c[0]; ivc.DepthCap() != c[1] {
+			t.Errorf("%d: got cap %d, expected %d", c[0], ivc.DepthCap(), c[1])
+		}
+	}
+	ivc.PARA.Max_depth = 3
+	late_num := 0
+	for pos := uint32(0); pos < 100; pos++ {
+		R := new(ivc.Reservoir)
+		for i := 0; i < 30; i++ {
+			R.Add(ivc.BasePriority(int64(i), pos), &ivc.VarInfo{Pos: pos, Cycle: i}, ivc.DepthCap())
+		}
+		for _, v := range R.Kept() {
+			if v.Cycle >= ivc.DepthCap() {
+				late_num++
+			}
+		}
+	}
+	if late_num == 0 {
+		t.Errorf("reads past the cap are never used")
+	}
+}
@@ -0,0 +1,96 @@
+//----------------------------------------------------------------------------------------
+// Test for concordance of variant calls with a truth set
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// WriteTestFile writes lines to a file of a directory (gzip-compressed if its name ends with .gz) and
+// returns the file name
+func WriteTestFile(t *testing.T, dir, name string, lines ...string) string {
+	file_name := filepath.Join(dir, name)
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	if strings.HasSuffix(name, ".gz") {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		data = buf.Bytes()
+	}
+	if e := os.WriteFile(file_name, data, 0644); e != nil {
+		t.Fatal(e)
+	}
+	return file_name
+}
+
+// Calls are compared with truths by normalized alleles within confident regions, calls with
+// non-passing filters and homozygous-reference genotypes are ignored
+func TestEvaluate(t *testing.T) {
+	dir := t.TempDir()
+	calls := WriteTestFile(t, dir, "calls.vcf",
+		"##fileformat=VCFv4.2",
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE",
+		"chr1\t10\t.\tA\tG\t50\tPASS\t.\tGT\t0/1",          // TP SNP
+		"chr1\t20\t.\tATT\tAT\t50\t.\t.\tGT\t1/1",          // TP indel (trimmed suffix)
+		"chr1\t30\t.\tC\tT\t50\tPASS\t.\tGT\t0/1",          // FP SNP
+		"chr1\t40\t.\tG\tA\t5\tLowQual\t.\tGT\t0/1",        // filtered (truth is FN)
+		"chr1\t50\t.\tT\tC\t50\tPASS\t.\tGT\t0/0",          // homozygous reference (ignored)
+		"chr1\t60\t.\tA\tC,AG\t50\tPASS\t.\tGT\t1/2",       // TP SNP, FP indel
+		"chr1\t500\t.\tA\tT\t50\tPASS\t.\tGT\t0/1",         // outside confident regions
+		"chr2\t10\t.\tCAAA\tCA\t50\tPASS\t.\tGT:DP\t1/1:9") // TP indel
+	truths := WriteTestFile(t, dir, "truth.vcf.gz",
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE",
+		"chr1\t10\t.\tA\tG\t.\tPASS\t.\tGT\t0|1",
+		"chr1\t20\t.\tAT\tA\t.\tPASS\t.\tGT\t1|1",
+		"chr1\t40\t.\tG\tA\t.\tPASS\t.\tGT\t0|1",
+		"chr1\t60\t.\tA\tC\t.\tPASS\t.\tGT\t0|1",
+		"chr1\t70\t.\tA\tACC\t.\tPASS\t.\tGT\t0|1", // FN indel
+		"chr1\t600\t.\tA\tT\t.\tPASS\t.\tGT\t0|1",  // outside confident regions
+		"chr2\t10\t.\tCAA\tC\t.\tPASS\t.\tGT\t1|1")
+	bed := WriteTestFile(t, dir, "regions.bed", "track name=confident", "chr1\t0\t100", "chr1\t50\t200", "chr2\t0\t100")
+	stats := ivc.Evaluate(calls, truths, bed)
+	if *stats["SNP"] != (ivc.EvalStat{TP: 2, FP: 1, FN: 1}) || *stats["INDEL"] != (ivc.EvalStat{TP: 2, FP: 1, FN: 1}) {
+		t.Errorf("got SNPs %+v, indels %+v", *stats["SNP"], *stats["INDEL"])
+	}
+	// All variants are compared without confident regions
+	stats = ivc.Evaluate(calls, truths, "")
+	if *stats["SNP"] != (ivc.EvalStat{TP: 2, FP: 2, FN: 2}) {
+		t.Errorf("got SNPs %+v without regions", *stats["SNP"])
+	}
+	var w bytes.Buffer
+	ivc.WriteEvalReport(&w, map[string]*ivc.EvalStat{"SNP": {TP: 3, FP: 1, FN: 2}, "INDEL": {}})
+	if expected := "TYPE\tTP\tFP\tFN\tPRECISION\tRECALL\tF1\nSNP\t3\t1\t2\t0.7500\t0.6000\t0.6667\nINDEL\t0\t0\t0\t0.0000\t0.0000\t0.0000\n"; w.String() != expected {
+		t.Errorf("got report %q, expected %q", w.String(), expected)
+	}
+}
+
+// Common suffixes and then common prefixes of alleles are trimmed, keeping one base of each allele
+func TestNormalizeAlleles(t *testing.T) {
+	for _, test := range []struct {
+		pos          int
+		ref, alt     string
+		n_pos        int
+		n_ref, n_alt string
+	}{
+		{10, "A", "G", 10, "A", "G"},
+		{10, "ATT", "AT", 10, "AT", "A"},
+		{10, "CAAA", "CA", 10, "CAA", "C"},
+		{10, "GCAT", "GCTT", 12, "A", "T"},
+		{10, "AC", "ATGC", 10, "A", "ATG"},
+	} {
+		if pos, ref, alt := ivc.NormalizeAlleles(test.pos, test.ref, test.alt); pos != test.n_pos || ref != test.n_ref || alt != test.n_alt {
+			t.Errorf("%d %s %s: got %d %s %s, expected %d %s %s", test.pos, test.ref, test.alt, pos, ref, alt, test.n_pos, test.n_ref, test.n_alt)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//----------------------------------------------------------------------------------------
+// Test for parsing FASTQ files
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/namsyvo/IVC/seqio"
+)
+
+// FastqRecords parses all records of a FASTQ input and returns headers, reads and qualities.
+func FastqRecords(F *seqio.FastqReader) []string {
+	var recs []string
+	for F.Next() {
+		recs = append(recs, string(F.Info)+" "+string(F.Read)+" "+string(F.Qual))
+	}
+	return recs
+}
+
+// Records with wrapped sequences and qualities, blank lines, CRLF line ends and quality lines
+// starting with '@'
+func TestFastqReader(t *testing.T) {
+	in := "@r1 a\nACGT\nAC\n+\nIIII\nII\n\n@r2\r\nGGCC\r\n+r2\r\n@III\r\n"
+	F := seqio.NewFastqReader(strings.NewReader(in), "in.fq")
+	recs := FastqRecords(F)
+	expected := []string{"@r1 a ACGTAC IIIIII", "@r2 GGCC @III"}
+	if strings.Join(recs, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, expected %v", recs, expected)
+	}
+	if F.Err() != nil || F.RecordNum() != 2 || F.RecordLine() != 8 {
+		t.Errorf("got error %v, %d records, last record at line %d", F.Err(), F.RecordNum(), F.RecordLine())
+	}
+}
+
+// Malformed records end parsing with errors of their lines
+func TestFastqReaderErrors(t *testing.T) {
+	test_cases := []struct {
+		in, err string
+	}{
+		{"r1\nACGT\n+\nIIII\n", "line 1: malformed FASTQ record 1: header does not start with '@'"},
+		{"@r1\nACGT\n+\nIIII\n@r2\nACGT\n", "line 6: malformed FASTQ record 2: truncated record (no '+' line)"},
+		{"@r1\nACGT\n+\nII\n", "line 4: malformed FASTQ record 1: truncated record (2 quality values for 4 bases)"},
+		{"@r1\nACGT\n+\nIIIII\n", "line 4: malformed FASTQ record 1: 5 quality values for 4 bases"},
+	}
+	for _, c := range test_cases {
+		F := seqio.NewFastqReader(strings.NewReader(c.in), "in.fq")
+		FastqRecords(F)
+		if F.Err() == nil || F.Err().Error() != "in.fq: "+c.err {
+			t.Errorf("%q: got error %v, expected %s", c.in, F.Err(), c.err)
+		}
+	}
+}
+
+// Malformed records are skipped in resync mode, and truncated inputs end at their last complete record
+func TestFastqReaderResync(t *testing.T) {
+	in := "@r1\nACGT\n+\nIIIII\n@r2\nAC\n+\nII\n"
+	F := seqio.NewFastqReader(strings.NewReader(in), "in.fq")
+	F.SetResync(true)
+	if recs := FastqRecords(F); len(recs) != 1 || recs[0] != "@r2 AC II" {
+		t.Errorf("got %v", recs)
+	}
+	if F.Err() != nil || F.SkipNum() != 1 || F.SkipErr() == nil || F.Truncated() {
+		t.Errorf("got error %v, %d skipped records (%v), truncated %v", F.Err(), F.SkipNum(), F.SkipErr(), F.Truncated())
+	}
+
+	r := io.MultiReader(strings.NewReader("@r1\nACGT\n+\nIIII\n@r2\nAC"), iotest.ErrReader(errors.New("unexpected EOF")))
+	F = seqio.NewFastqReader(r, "in.fq.gz")
+	F.SetResync(true)
+	if recs := FastqRecords(F); len(recs) != 1 || recs[0] != "@r1 ACGT IIII" {
+		t.Errorf("got %v", recs)
+	}
+	if F.Err() != nil || F.SkipNum() != 1 || !F.Truncated() {
+		t.Errorf("got error %v, %d skipped records, truncated %v", F.Err(), F.SkipNum(), F.Truncated())
+	}
+}
+
+// Names of paired records are compared without /1 and /2 suffixes and comments
+func TestMateName(t *testing.T) {
+	F1 := seqio.NewFastqReader(strings.NewReader("@read7/1 x\nA\n+\nI\n@read8/1\nA\n+\nI\n"), "r1.fq")
+	F2 := seqio.NewFastqReader(strings.NewReader("@read7/2\tBX:Z:1\nA\n+\nI\n@read9/2\nA\n+\nI\n"), "r2.fq")
+	F1.Next()
+	F2.Next()
+	if string(seqio.MateName(F1.Info)) != "read7" || seqio.MateError(F1, F2) != nil {
+		t.Errorf("mates %s and %s are not paired", F1.Info, F2.Info)
+	}
+	F1.Next()
+	F2.Next()
+	if seqio.MateError(F1, F2) == nil {
+		t.Errorf("mates %s and %s are paired", F1.Info, F2.Info)
+	}
+}
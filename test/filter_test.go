@@ -0,0 +1,88 @@
+//----------------------------------------------------------------------------------------
+// Test for hard filters of variant calls
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Calls pass or fail filters on QUAL, INFO and FORMAT values, with indexed and summed values of
+// multi-valued fields
+func TestApplyFilters(t *testing.T) {
+	info := "KV;VP=0.9;MQ=60.00;QD=12.50;FS=70.123"
+	format_keys, format_values := "GT:GQ:AD:DP:ADF:ADR:SB:PL", "0/1:35:10,3,2:15:6,2,1:4,1,1:6,4,3,2:90,0,120,40,60,200"
+	values := ivc.FilterValues(25, info, format_keys, format_values)
+	for _, test := range []struct {
+		expr, filter string
+	}{
+		{"LowQual:QUAL<30", "LowQual"},
+		{"QUAL<20", "PASS"},
+		{"GQ<40", "GQ_lt_40"},
+		{"DP<=15", "DP_le_15"},
+		{"FS>60", "FS_gt_60"},
+		{"MQ<40", "PASS"},
+		{"KV==1", "KV_eq_1"},
+		{"AD<=5", "AD_le_5"},
+		{"AD<5", "PASS"},
+		{"AD[0]<5", "PASS"},
+		{"AD[2]==2", "AD[2]_eq_2"},
+		{"ADR>1", "ADR_gt_1"},
+		{"SB[3]>=2", "SB[3]_ge_2"},
+		{"PL[0]>=90", "PL[0]_ge_90"},
+		{"PL[5]>100", "PL[5]_gt_100"},
+		{"BaseQRankSum<-3", "PASS"},
+		{"LowQual:QUAL<30,LowGQ:GQ<40,FS>100", "LowQual;LowGQ"},
+	} {
+		if filter := ivc.ApplyFilters(ivc.SetupFilters(test.expr, ""), values); filter != test.filter {
+			t.Errorf("%s: got %s, expected %s", test.expr, filter, test.filter)
+		}
+	}
+}
+
+// Filters with unknown keys, invalid indexes or multi-valued keys without an index are rejected
+func TestInvalidFilters(t *testing.T) {
+	for _, expr := range []string{"FOO<3", "MQ0<1", "QUAL[0]<3", "PL<10", "SB>2", "AD[x]<2", "AD[-1]<2", "DP<x", "<3"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected to be rejected", expr)
+				}
+			}()
+			ivc.ParseFilter(expr)
+		}()
+	}
+}
+
+// FS is the Phred-scaled p-value of Fisher's exact test of per-strand counts, and is the same as FS
+// computed from SB of written calls
+func TestFisherStrand(t *testing.T) {
+	for _, test := range []struct {
+		counts   [4]int
+		min, max float64
+	}{
+		{[4]int{10, 10, 10, 10}, 0, 0},
+		{[4]int{10, 0, 0, 0}, 0, 0},
+		{[4]int{20, 20, 20, 0}, 44.507, 44.507},
+		{[4]int{20, 0, 0, 20}, 60, 1000},
+	} {
+		fs := ivc.FisherStrand(test.counts)
+		if fs < test.min || fs > test.max {
+			t.Errorf("%v: got FS %g, expected within [%g, %g]", test.counts, fs, test.min, test.max)
+		}
+		if sb_fs, ok := ivc.StrandBiasFS(fmt.Sprintf("%d,%d,%d,%d", test.counts[0], test.counts[1], test.counts[2], test.counts[3])); !ok || sb_fs != fs {
+			t.Errorf("%v: got FS %g from SB, expected %g", test.counts, sb_fs, fs)
+		}
+	}
+	// Reads of all alternative alleles of multi-allelic records are counted together
+	var_num, rev_num := map[string]int{"A|A": 8, "A|C": 5, "A|G": 3, "A|T": 1},
+		map[string]int{"A|A": 4, "A|C": 5, "A|G": 0}
+	if counts, expected := ivc.MultiStrandCounts(var_num, rev_num, []string{"A", "C", "G"}), [4]int{4, 4, 3, 5}; counts != expected {
+		t.Errorf("got counts %v, expected %v", counts, expected)
+	}
+}
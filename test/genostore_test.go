@@ -0,0 +1,75 @@
+//----------------------------------------------------------------------------------------
+// Test for storing genotypes and read statistics of variant locations
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Locations of several blocks, added in no particular order, and updated
+func TestGenoStore(t *testing.T) {
+	S := ivc.NewGenoStore()
+	positions := []uint32{50, 3, 1, ivc.GENO_BLOCK_LEN + 1, 2, ivc.GENO_BLOCK_LEN - 1}
+	for k, pos := range positions {
+		S.Set(pos, map[string]float64{"A|A": float64(k), "A|C": 0.5})
+	}
+	S.Set(3, map[string]float64{"C|C": 1})
+	S.Set(7, nil)
+	if S.Len() != len(positions)+1 {
+		t.Errorf("got %d locations", S.Len())
+	}
+	for k, pos := range positions {
+		T, ok := S.Get(pos)
+		if pos == 3 {
+			if !reflect.DeepEqual(T.Map(), map[string]float64{"C|C": 1}) {
+				t.Errorf("position %d: got %v", pos, T.Map())
+			}
+			continue
+		}
+		if p, _ := T.Get("A|A"); !ok || p != float64(k) || !reflect.DeepEqual(T.Gts, []string{"A|A", "A|C"}) {
+			t.Errorf("position %d: got %v", pos, T)
+		}
+	}
+	if T, ok := S.Get(7); !ok || len(T.Gts) != 0 || !S.Has(7) {
+		t.Errorf("position 7: got %v", T)
+	}
+	if _, ok := S.Get(4); ok || S.Has(ivc.GENO_BLOCK_LEN) {
+		t.Errorf("positions 4 and %d are variant locations", ivc.GENO_BLOCK_LEN)
+	}
+	pos := S.Positions(nil)
+	sort.Ints(pos)
+	if !reflect.DeepEqual(pos, []int{1, 2, 3, 7, 50, ivc.GENO_BLOCK_LEN - 1, ivc.GENO_BLOCK_LEN + 1}) {
+		t.Errorf("got positions %v", pos)
+	}
+}
+
+// Counts are kept sorted by keys, locations with aligned reads are visited in order of positions
+func TestSiteStore(t *testing.T) {
+	S := ivc.NewSiteStore()
+	S.Add(ivc.GENO_BLOCK_LEN+2).RNum.Add("A|C", 1)
+	S.Add(9)
+	site := S.Add(4)
+	site.RNum.AddMap(map[string]int{"A|T": 2, "A|A": 3})
+	site.RNum.Add("A|T", 1)
+	site.Type.Set("A|T", 0)
+	if S.Add(4) != site || S.Get(4) != site || S.Get(5) != nil || S.Len() != 3 {
+		t.Errorf("location 4 is not found")
+	}
+	if !reflect.DeepEqual(site.RNum.Keys, []string{"A|A", "A|T"}) || site.RNum.Get("A|T") != 3 || site.RNum.Get("A|G") != 0 {
+		t.Errorf("got counts %v", site.RNum)
+	}
+	var aligned []uint32
+	S.EachAligned(func(pos uint32, site *ivc.CallSite) {
+		aligned = append(aligned, pos)
+	})
+	if !reflect.DeepEqual(aligned, []uint32{4, ivc.GENO_BLOCK_LEN + 2}) || S.Get(9).Aligned() {
+		t.Errorf("got locations with aligned reads %v", aligned)
+	}
+}
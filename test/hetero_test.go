@@ -0,0 +1,129 @@
+//----------------------------------------------------------------------------------------
+// Test for heteroplasmy mode
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Regions of given contigs are whole chromosomes on the multigenome, sorted, and unknown contigs are
+// ignored
+func TestSetupHeteroplasmy(t *testing.T) {
+	defer func() { ivc.HETERO_REGIONS = nil }()
+	VC := &ivc.VarCallIndex{SeqLen: 3000, ChrPos: []int{0, 1000, 2500}, ChrName: [][]byte{[]byte("chr1"), []byte("chrM"), []byte("chrEBV")}}
+	VC.SetupHeteroplasmy("chrEBV,chrUn,chrM")
+	if expected := [][2]int{{1000, 2500}, {2500, 3000}}; !reflect.DeepEqual(ivc.HETERO_REGIONS, expected) {
+		t.Errorf("got regions %v, expected %v", ivc.HETERO_REGIONS, expected)
+	}
+	for pos, expected := range map[int]bool{0: false, 999: false, 1000: true, 2999: true, 3000: false} {
+		if hetero := ivc.IsHeteroplasmic(pos); hetero != expected {
+			t.Errorf("position %d: got %v, expected %v", pos, hetero, expected)
+		}
+	}
+	if VC.SetupHeteroplasmy(""); ivc.HETERO_REGIONS != nil || ivc.IsHeteroplasmic(1000) {
+		t.Errorf("got regions %v without contigs", ivc.HETERO_REGIONS)
+	}
+}
+
+// Allele fractions have Wilson score intervals clipped to [0, 1], and QUALs are likelihood ratios of
+// allele fractions against sequencing errors
+func TestWilsonIntervalHeteroQual(t *testing.T) {
+	for _, test := range []struct {
+		k, n   int
+		lo, hi float64
+	}{
+		{10, 100, 0.055229, 0.174367},
+		{97, 100, 0.915479, 0.989746},
+		{0, 20, 0, 0.161130},
+		{20, 20, 0.838870, 1},
+		{0, 0, 0, 1},
+	} {
+		if lo, hi := ivc.WilsonInterval(test.k, test.n); math.Abs(lo-test.lo) > 1e-6 || math.Abs(hi-test.hi) > 1e-6 {
+			t.Errorf("%d in %d: got interval [%g, %g], expected [%g, %g]", test.k, test.n, lo, hi, test.lo, test.hi)
+		}
+	}
+	for _, test := range []struct {
+		k, n int
+		qual float64
+	}{
+		{0, 10, 0},
+		{2, 100, 17.838074},
+		{10, 100, 159.207782},
+		{1000, 1000, 1000},
+	} {
+		if qual := ivc.HeteroQual(test.k, test.n, 0.001); math.Abs(qual-test.qual) > 1e-6 {
+			t.Errorf("%d in %d: got QUAL %g, expected %g", test.k, test.n, qual, test.qual)
+		}
+	}
+}
+
+// The most supported alternative allele (ties are broken by keys) has AD, DP, AF and AFCI of all reads,
+// and GT 1 from allele fraction 0.95
+func TestHeteroFormat(t *testing.T) {
+	for _, test := range []struct {
+		var_num         map[string]int
+		alt_key, format string
+	}{
+		{map[string]int{"A|A": 90, "A|G": 10}, "A|G", "0/1:90,10:100:0.1000:0.0552,0.1744"},
+		{map[string]int{"A|A": 90, "A|T": 5, "A|G": 5}, "A|G", "0/1:90,5:100:0.0500:0.0215,0.1118"},
+		{map[string]int{"A|A": 3, "A|G": 97}, "A|G", "1:3,97:100:0.9700:0.9155,0.9897"},
+		{map[string]int{"A|A": 20}, "", "0/1:20,0:20:0.0000:0.0000,0.1611"},
+		{map[string]int{}, "", "./.:0,0:0:.:."},
+	} {
+		alt_key, _ := ivc.HeteroAlleles(test.var_num)
+		if format := ivc.HeteroFormat(test.var_num, alt_key); alt_key != test.alt_key || format != test.format {
+			t.Errorf("%v: got %s, %s, expected %s, %s", test.var_num, alt_key, format, test.alt_key, test.format)
+		}
+	}
+}
+
+// Calls are written with allele fractions at positions of enough reads of the alternative allele, of
+// enough fraction and QUAL
+func TestWriteHeteroCall(t *testing.T) {
+	ivc.PARA = &ivc.ParaInfo{Proc_num: 1, Read_len: 100, Err_rate: 0.003, Min_qual: 20}
+	ivc.L2E = []float64{1, 0.0001}
+	VC := &ivc.VarCallIndex{SeqLen: 1100, ChrPos: []int{0, 1000}, ChrName: [][]byte{[]byte("chr1"), []byte("chrM")},
+		Variants: make(map[int][][]byte)}
+	VC.InitVarCall()
+	r := 0
+	for pos, nums := range map[int][2]int{1049: {90, 10}, 1059: {99, 1}, 1069: {999, 2}, 1079: {98, 2}} {
+		for i := 0; i < nums[0]+nums[1]; i++ {
+			bases := "A|A"
+			if i >= nums[0] {
+				bases = "A|G"
+			}
+			VC.CollectVariant(&ivc.VarInfo{Pos: uint32(pos), Bases: []byte(bases), BQual: []byte{'I'},
+				RSeed: ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(r)))})
+			r++
+		}
+	}
+	VC.ApplyKeptBases()
+	for _, test := range []struct {
+		pos  uint32
+		line string
+	}{
+		{1049, "chrM\t50\t.\tA\tG\t" + strconv.FormatFloat(ivc.HeteroQual(10, 100, 0.001), 'f', 5, 64) +
+			"\t.\tHP;DP=100\tGT:AD:DP:AF:AFCI\t0/1:90,10:100:0.1000:0.0552,0.1744\n"},
+		{1059, ""}, // one read of the alternative allele
+		{1069, ""}, // allele fraction below 0.01
+		{1079, ""}, // QUAL below 20
+	} {
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		written := VC.WriteHeteroCall(w, 0, test.pos)
+		w.Flush()
+		if written != (test.line != "") || out.String() != test.line {
+			t.Errorf("position %d: got %v, %q, expected %q", test.pos, written, out.String(), test.line)
+		}
+	}
+}
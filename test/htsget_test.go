@@ -0,0 +1,102 @@
+//----------------------------------------------------------------------------------------
+// Test for parsing BAM records of htsget servers
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// BamRecord encodes a BAM record (without block size) of a read with a flag, bases and qualities
+// (Phred+33, nil: not available) and one CIGAR operation.
+func BamRecord(name string, flag uint16, seq, qual string) []byte {
+	codes := map[byte]byte{'=': 0, 'A': 1, 'C': 2, 'G': 4, 'T': 8, 'N': 15}
+	data := make([]byte, 32)
+	data[8] = byte(len(name) + 1)
+	binary.LittleEndian.PutUint16(data[12:14], 1)
+	binary.LittleEndian.PutUint16(data[14:16], flag)
+	binary.LittleEndian.PutUint32(data[16:20], uint32(len(seq)))
+	data = append(append(data, name...), 0)
+	data = append(data, 0, 0, 0, 0)
+	for i := 0; i < len(seq); i += 2 {
+		b := codes[seq[i]] << 4
+		if i+1 < len(seq) {
+			b |= codes[seq[i+1]]
+		}
+		data = append(data, b)
+	}
+	for i := 0; i < len(seq); i++ {
+		if qual == "" {
+			data = append(data, 0xff)
+		} else {
+			data = append(data, qual[i]-33)
+		}
+	}
+	return data
+}
+
+// Records are returned in the read strand, unpaired reads and non-primary alignments are skipped
+func TestParseBamRecord(t *testing.T) {
+	test_cases := []struct {
+		flag      uint16
+		seq, qual string
+		end       int
+		rec_seq   string
+		rec_qual  string
+	}{
+		{0x1 | 0x40, "ACGTN", "ABCDE", 0, "ACGTN", "ABCDE"},
+		{0x1 | 0x80 | 0x10, "AACGT", "ABCDE", 1, "ACGTT", "EDCBA"},
+		{0x1 | 0x40, "ACG", "", 0, "ACG", "III"},
+		{0x40, "ACG", "III", 0, "", ""},               // unpaired
+		{0x1 | 0x40 | 0x100, "ACG", "III", 0, "", ""}, // secondary
+		{0x1 | 0x80 | 0x800, "ACG", "III", 0, "", ""}, // supplementary
+		{0x1 | 0x40 | 0x80, "ACG", "III", 0, "", ""},  // both ends
+	}
+	for _, c := range test_cases {
+		rec, end := ivc.ParseBamRecord(BamRecord("r1", c.flag, c.seq, c.qual))
+		if c.rec_seq == "" {
+			if rec != nil {
+				t.Errorf("flag %x: got record %v, expected none", c.flag, rec)
+			}
+			continue
+		}
+		if rec == nil || rec.Name != "r1" || string(rec.Seq) != c.rec_seq || string(rec.Qual) != c.rec_qual || end != c.end {
+			t.Errorf("flag %x: got record %v of end %d", c.flag, rec, end)
+		}
+	}
+}
+
+// Ends of paired reads of a BAM stream are written to two FASTQ files, whichever end comes first
+func TestWriteBamPairs(t *testing.T) {
+	var in bytes.Buffer
+	in.WriteString("BAM\x01")
+	binary.Write(&in, binary.LittleEndian, int32(0)) // header text
+	binary.Write(&in, binary.LittleEndian, int32(1)) // references
+	binary.Write(&in, binary.LittleEndian, int32(5))
+	in.WriteString("chr1\x00")
+	binary.Write(&in, binary.LittleEndian, int32(1000))
+	for _, rec := range [][]byte{
+		BamRecord("r1", 0x1|0x80, "GG", "II"),
+		BamRecord("r2", 0x1|0x40, "TT", "II"),
+		BamRecord("r1", 0x1|0x40, "AC", "II"),
+		BamRecord("r3", 0x1|0x40, "AA", "II"),
+		BamRecord("r2", 0x1|0x80|0x10, "CA", "HI"),
+	} {
+		binary.Write(&in, binary.LittleEndian, int32(len(rec)))
+		in.Write(rec)
+	}
+	var w1, w2 bytes.Buffer
+	pair_num, orphan_num := ivc.WriteBamPairs(&in, &w1, &w2)
+	if pair_num != 2 || orphan_num != 1 {
+		t.Errorf("got %d pairs and %d orphan read-ends", pair_num, orphan_num)
+	}
+	if w1.String() != "@r1\nAC\n+\nII\n@r2\nTT\n+\nII\n" || w2.String() != "@r1\nGG\n+\nII\n@r2\nTG\n+\nIH\n" {
+		t.Errorf("got first ends %q and second ends %q", w1.String(), w2.String())
+	}
+}
@@ -0,0 +1,41 @@
+//----------------------------------------------------------------------------------------
+// Test for names of index files
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// The FM-index directory is named after the multi-sequence, directories of earlier versions named after
+// the reverse multi-sequence are used only if the new one does not exist
+func TestFMIndexDir(t *testing.T) {
+	idx_dir := t.TempDir()
+	multi_seq_file, fm_index_dir, var_prof_file := ivc.IndexFileNames("refs/ref.fasta", "refs/var.vcf", idx_dir)
+	if multi_seq_file != filepath.Join(idx_dir, "ref.fasta.mgf") || fm_index_dir != filepath.Join(idx_dir, "ref.fasta.mgf.index")+"/" ||
+		var_prof_file != filepath.Join(idx_dir, "var.vcf.idx") {
+		t.Fatalf("got %s, %s, %s", multi_seq_file, fm_index_dir, var_prof_file)
+	}
+	if dir := ivc.FMIndexDir(fm_index_dir); dir != fm_index_dir {
+		t.Errorf("got %s without index directories, expected %s", dir, fm_index_dir)
+	}
+	old_dir := filepath.Join(idx_dir, "ref.fasta.rev.mgf.index") + "/"
+	if e := os.Mkdir(old_dir, 0777); e != nil {
+		t.Fatal(e)
+	}
+	if dir := ivc.FMIndexDir(fm_index_dir); dir != old_dir {
+		t.Errorf("got %s with an old index directory, expected %s", dir, old_dir)
+	}
+	if e := os.Mkdir(fm_index_dir, 0777); e != nil {
+		t.Fatal(e)
+	}
+	if dir := ivc.FMIndexDir(fm_index_dir); dir != fm_index_dir {
+		t.Errorf("got %s with both index directories, expected %s", dir, fm_index_dir)
+	}
+}
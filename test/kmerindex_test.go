@@ -0,0 +1,102 @@
+//----------------------------------------------------------------------------------------
+// Test for seeding with the k-mer index
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+	"github.com/namsyvo/IVC/index"
+)
+
+// K-mers with non-standard bases are not indexed, positions of k-mers are found by lookups of reads,
+// and indexes are loaded as saved
+func TestBuildKmerIndex(t *testing.T) {
+	KI := index.BuildKmerIndex([]byte("ACGTACG*ACGTN"), 3)
+	expected := &index.KmerIndex{K: 3, Keys: []uint64{6, 27, 44, 49}, Offs: []uint32{0, 3, 5, 6, 7}, Pos: []uint32{0, 4, 8, 1, 9, 2, 3}}
+	if !reflect.DeepEqual(KI, expected) {
+		t.Errorf("got index %v, expected %v", KI, expected)
+	}
+	for _, test := range []struct {
+		read string
+		i    int
+		pos  []uint32
+	}{
+		{"ACG", 0, []uint32{0, 4, 8}},
+		{"TTCGT", 2, []uint32{1, 9}},
+		{"AAA", 0, nil},
+		{"ANG", 0, nil},
+		{"ACGT", 2, nil},
+	} {
+		if pos := KI.Lookup([]byte(test.read), test.i); !reflect.DeepEqual(pos, test.pos) {
+			t.Errorf("%s at %d: got positions %v, expected %v", test.read, test.i, pos, test.pos)
+		}
+	}
+	file_name := index.KmerIndexFile(filepath.Join(t.TempDir(), "ref.fasta"))
+	KI.Save(file_name)
+	if loaded := ivc.LoadKmerIndex(file_name); !reflect.DeepEqual(loaded, KI) {
+		t.Errorf("got loaded index %v, expected %v", loaded, KI)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected k-mers longer than %d bases to be rejected", index.KMER_MAX_K)
+		}
+	}()
+	index.BuildKmerIndex([]byte("ACGT"), index.KMER_MAX_K+1)
+}
+
+// Seeds start at k-mers of reads and end at the longest matches of the multigenome at positions of the
+// k-mers, up to Max_slen bases
+func TestSearchKmerSeeds(t *testing.T) {
+	ref := RandomSeq(400, 5)
+	copy(ref[200:230], ref[100:130])
+	ref[230] = "CGTA"[strings.IndexByte("ACGT", ref[130])]
+	read := append([]byte{}, ref[100:150]...)
+	for _, b := range []byte("ACGT") {
+		if b != ref[130] && b != ref[230] {
+			read[30] = b
+			break
+		}
+	}
+	VC := &ivc.VarCallIndex{Seq: ref, SeqLen: len(ref), KmerIdx: index.BuildKmerIndex(ref, 15)}
+	for _, test := range []struct {
+		s_pos, max_snum, min_slen, max_slen int
+		start, end, m_num                   int
+		m_pos                               []int
+		ok                                  bool
+	}{
+		{0, 4, 15, 100, 0, 29, 2, []int{100, 200}, true},
+		{0, 1, 15, 100, 0, 29, 2, []int{100}, false},
+		{0, 4, 15, 20, 0, 20, 2, []int{100, 200}, true},
+		{31, 4, 15, 100, 31, 49, 1, []int{131}, true},
+		{31, 4, 20, 100, 31, 49, 1, []int{131}, false},
+		{16, 4, 15, 100, -1, -1, -1, []int{}, false},
+	} {
+		ivc.PARA = &ivc.ParaInfo{Max_snum: test.max_snum, Min_slen: test.min_slen, Max_slen: test.max_slen}
+		m_pos := make([]int, test.max_snum)
+		start, end, m_num, ok := VC.SearchKmerSeeds(read, test.s_pos, m_pos)
+		if start != test.start || end != test.end || m_num != test.m_num || ok != test.ok ||
+			!reflect.DeepEqual(m_pos[:len(test.m_pos)], test.m_pos) {
+			t.Errorf("seed at %d: got %d-%d, %d matches at %v, %v, expected %d-%d, %d matches at %v, %v", test.s_pos, start, end,
+				m_num, m_pos, ok, test.start, test.end, test.m_num, test.m_pos, test.ok)
+		}
+	}
+}
+
+// Indexes of seeds are the FM-index or the k-mer index
+func TestCheckSeedIndex(t *testing.T) {
+	ivc.CheckSeedIndex(ivc.SEED_INDEX_FM)
+	ivc.CheckSeedIndex(ivc.SEED_INDEX_KMER)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected unknown index of seeds to be rejected")
+		}
+	}()
+	ivc.CheckSeedIndex("bwt")
+}
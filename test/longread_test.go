@@ -0,0 +1,95 @@
+//----------------------------------------------------------------------------------------
+// Test for chunks and seed chains of long reads
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Chunk pairs cover the read, the last one overlaps its predecessor and bases of the overlap are
+// used as evidence once
+func TestChunkStarts(t *testing.T) {
+	test_cases := []struct {
+		read_len int
+		starts   []int
+	}{
+		{349, nil},
+		{350, []int{0}},
+		{700, []int{0, 350}},
+		{800, []int{0, 350, 450}},
+	}
+	for _, c := range test_cases {
+		if starts := ivc.ChunkStarts(c.read_len, 350); !reflect.DeepEqual(starts, c.starts) {
+			t.Errorf("%d: got %v, expected %v", c.read_len, starts, c.starts)
+		}
+	}
+	// The last chunk pair (450-800) overlaps the previous one (350-700) by 250 bases: the first chunk
+	// (450-600) is covered, the second chunk (650-800, reverse complemented) is used for 700-800
+	cycles1, cycles2 := ivc.ChunkCycles(450, 700, 150, 350)
+	if cycles1 != [2]int{150, 150} || cycles2 != [2]int{0, 100} {
+		t.Errorf("got cycles %v, %v", cycles1, cycles2)
+	}
+	cycles1, cycles2 = ivc.ChunkCycles(560, 700, 150, 350)
+	if cycles1 != [2]int{140, 150} || cycles2 != [2]int{0, 150} {
+		t.Errorf("got cycles %v, %v", cycles1, cycles2)
+	}
+	cycles1, cycles2 = ivc.ChunkCycles(350, 350, 150, 350)
+	if cycles1 != [2]int{0, 150} || cycles2 != [2]int{0, 150} {
+		t.Errorf("got cycles %v, %v", cycles1, cycles2)
+	}
+	vars := []*ivc.VarInfo{{Cycle: 0}, {Cycle: 99}, {Cycle: 100}, {Cycle: 149}}
+	if vars = ivc.TrimCycles(vars, [2]int{0, 100}); len(vars) != 2 || vars[1].Cycle != 99 {
+		t.Errorf("got %d variants", len(vars))
+	}
+}
+
+// Co-linear anchors are chained, anchors of repeats off the diagonal are not
+func TestChainAnchors(t *testing.T) {
+	anchors := []ivc.ChainAnchor{
+		{Q: 80, R: 10085, Len: 20},
+		{Q: 0, R: 10000, Len: 20},
+		{Q: 40, R: 50040, Len: 20}, // repeat
+		{Q: 40, R: 10040, Len: 20},
+		{Q: 120, R: 10120, Len: 20},
+		{Q: 120, R: 90000, Len: 30}, // repeat
+		{Q: 50, R: 10050, Len: 20},  // overlapping anchor
+	}
+	chain, score := ivc.ChainAnchors(anchors)
+	expected := []ivc.ChainAnchor{{Q: 0, R: 10000, Len: 20}, {Q: 40, R: 10040, Len: 20}, {Q: 50, R: 10050, Len: 20}, {Q: 80, R: 10085, Len: 20}, {Q: 120, R: 10120, Len: 20}}
+	if !reflect.DeepEqual(chain, expected) || score != 90 {
+		t.Errorf("got chain %v (score %d)", chain, score)
+	}
+	if chain, score = ivc.ChainAnchors(nil); chain != nil || score != 0 {
+		t.Errorf("got chain %v (score %d)", chain, score)
+	}
+}
+
+// Chunks are expected at diagonals of the nearest anchors on the strand of the chain
+func TestChunkAnchor(t *testing.T) {
+	C := &ivc.LongReadChain{Anchors: []ivc.ChainAnchor{{Q: 0, R: 10000, Len: 20}, {Q: 1000, R: 11010, Len: 20}}, Strand: true, Len: 2000}
+	if A := C.ChunkAnchor(900, 150); *A != (ivc.ChunkAnchor{Pos: 10910, Strand: true}) || !A.Near(10950, true) || A.Near(10910, false) || A.Near(11100, true) {
+		t.Errorf("got %+v", A)
+	}
+	if A := C.ChunkAnchor(400, 150); A.Pos != 10400 {
+		t.Errorf("got %+v", A)
+	}
+	// Chunks far from the chain are not anchored
+	if A := C.ChunkAnchor(7000, 150); A != nil {
+		t.Errorf("got %+v", A)
+	}
+	// Chains of the reverse complement: the chunk 1850-2000 starts the reverse complement
+	C.Strand = false
+	if A := C.ChunkAnchor(1850, 150); A.Pos != 10000 || A.Strand {
+		t.Errorf("got %+v", A)
+	}
+	var N *ivc.LongReadChain
+	if A := N.ChunkAnchor(0, 150); A != nil || !A.Near(5, false) {
+		t.Errorf("got %+v", A)
+	}
+}
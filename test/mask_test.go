@@ -0,0 +1,84 @@
+//----------------------------------------------------------------------------------------
+// Test for masked regions
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Masked regions are moved to positions of chromosomes on the multigenome, clipped at ends of
+// chromosomes and merged (also across chromosomes); regions of other chromosomes are ignored
+func TestLoadMask(t *testing.T) {
+	VC := &ivc.VarCallIndex{SeqLen: 3000, ChrPos: []int{0, 1000, 2500}, ChrName: [][]byte{[]byte("chr1"), []byte("chr2"), []byte("chrM")}}
+	bed_file := WriteTestFile(t, t.TempDir(), "mask.bed.gz",
+		"#masked regions",
+		"chr1\t100\t200",
+		"chr1\t150\t300",
+		"chr2\t1400\t2000",
+		"chr2\t10\t20",
+		"chrM\t0\t10",
+		"chrUn\t0\t100")
+	M := VC.LoadMask(bed_file, ivc.MASK_MODE_DROP)
+	if expected := [][2]int{{100, 300}, {1010, 1020}, {2400, 2510}}; !reflect.DeepEqual(M.Regions, expected) || !M.Drop {
+		t.Errorf("got masked regions %v (drop mode %v), expected %v in drop mode", M.Regions, M.Drop, expected)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected unknown mask mode to be rejected")
+		}
+	}()
+	VC.LoadMask(bed_file, "skip")
+}
+
+// Evidence within masked regions is dropped in drop mode, and calls within them are filtered in filter
+// mode only
+func TestMaskTrimFilter(t *testing.T) {
+	vars := func(pos ...int) []*ivc.VarInfo {
+		vs := make([]*ivc.VarInfo, len(pos))
+		for i, p := range pos {
+			vs[i] = &ivc.VarInfo{Pos: uint32(p)}
+		}
+		return vs
+	}
+	positions := func(vs []*ivc.VarInfo) []int {
+		pos := make([]int, 0)
+		for _, v := range vs {
+			pos = append(pos, int(v.Pos))
+		}
+		return pos
+	}
+	regions := [][2]int{{100, 200}, {500, 600}}
+	drop, filter := &ivc.MaskInfo{Regions: regions, Drop: true}, &ivc.MaskInfo{Regions: regions}
+	var no_mask *ivc.MaskInfo
+
+	if pos := positions(drop.Trim(vars(99, 100, 199, 200, 550, 700))); !reflect.DeepEqual(pos, []int{99, 200, 700}) || drop.DropNum != 3 {
+		t.Errorf("got positions %v, %d dropped bases, expected [99 200 700], 3 dropped bases", pos, drop.DropNum)
+	}
+	for _, M := range []*ivc.MaskInfo{filter, no_mask} {
+		if pos := positions(M.Trim(vars(100, 550))); len(pos) != 2 {
+			t.Errorf("got positions %v, expected no dropped bases", pos)
+		}
+	}
+	for _, test := range []struct {
+		M                  *ivc.MaskInfo
+		pos                int
+		str_filter, filter string
+	}{
+		{filter, 150, "PASS", "MASKED"},
+		{filter, 150, ".", "MASKED"},
+		{filter, 550, "LowQual", "LowQual;MASKED"},
+		{filter, 200, "PASS", "PASS"},
+		{drop, 150, "PASS", "PASS"},
+		{no_mask, 150, "PASS", "PASS"},
+	} {
+		if filter := test.M.Filter(test.pos, test.str_filter); filter != test.filter {
+			t.Errorf("position %d, filter %s: got %s, expected %s", test.pos, test.str_filter, filter, test.filter)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//----------------------------------------------------------------------------------------
+// Test for merging overlapping read pairs
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Overlaps between the end of read1 and the start of read2
+func TestBestOverlap(t *testing.T) {
+	frag := "ACGTTGCAAGGCTTACCGATAGGCATTCAGCTAACG"
+	test_cases := []struct {
+		read1, read2 string
+		o            int
+	}{
+		{frag[:24], frag[12:], 12},
+		{frag[:30], frag[10:], 20},
+		{frag[:24], frag[12:18] + "NN" + frag[20:], 12},        // N bases are not mismatches
+		{frag[:24], frag[12:16] + "T" + frag[17:], 12},         // one mismatch
+		{frag[:24], frag[15:], 0},                              // overlap of 9 bases
+		{frag[:24], strings.Repeat("T", 24), 0},                // no overlap
+		{strings.Repeat("A", 20), strings.Repeat("A", 15), 15}, // longest of equal overlaps
+		{frag[:20], "TTTTTTTTTTTTTTTTTTTT", 0},
+	}
+	for _, c := range test_cases {
+		if o := ivc.BestOverlap([]byte(c.read1), []byte(c.read2)); o != c.o {
+			t.Errorf("%s, %s: got overlap %d, expected %d", c.read1, c.read2, o, c.o)
+		}
+	}
+}
+
+// Agreeing bases have summed qualities, disagreeing bases the difference of qualities
+func TestConsensusBase(t *testing.T) {
+	test_cases := []struct {
+		b1, q1, b2, q2, b, q byte
+	}{
+		{'A', 33 + 20, 'A', 33 + 15, 'A', 33 + 35},
+		{'A', 33 + 30, 'A', 33 + 30, 'A', 33 + ivc.QUAL_MAX},
+		{'A', 33 + 20, 'C', 33 + 30, 'C', 33 + 10},
+		{'A', 33 + 20, 'C', 33 + 21, 'C', 33 + ivc.QUAL_MIN},
+		{'N', 33 + 2, 'G', 33 + 25, 'G', 33 + 25},
+	}
+	for _, c := range test_cases {
+		if b, q := ivc.ConsensusBase(c.b1, c.q1, c.b2, c.q2); b != c.b || q != c.q {
+			t.Errorf("%c%d, %c%d: got %c%d, expected %c%d", c.b1, c.q1-33, c.b2, c.q2-33, b, q-33, c.b, c.q-33)
+		}
+	}
+}
+
+// A pair of a 40-base fragment with 30-base ends is replaced by two 20-base halves
+func TestMergePair(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Min_slen = 5
+	frag := "ACGTTGCAAGGCTTACCGATAGGCATTCAGCTAACGTGCA"
+	rc := func(s string) string {
+		r := make([]byte, len(s))
+		comp := map[byte]byte{'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A'}
+		for i := 0; i < len(s); i++ {
+			r[len(s)-1-i] = comp[s[i]]
+		}
+		return string(r)
+	}
+	read_info := ivc.InitReadInfo(30, 30, 10)
+	copy(read_info.Read1, frag[:30])
+	copy(read_info.Read2, rc(frag[10:]))
+	copy(read_info.Qual1, strings.Repeat("5", 30))
+	copy(read_info.Qual2, strings.Repeat("5", 30))
+	M := new(ivc.PairMerger)
+	if !M.MergePair(read_info) {
+		t.Fatalf("pair is not merged")
+	}
+	if read_info.Len1 != 20 || read_info.Len2 != 20 || string(read_info.Read1) != frag[:20] || string(read_info.Read2) != rc(frag[20:]) {
+		t.Errorf("got halves %s (%d), %s (%d)", read_info.Read1, read_info.Len1, read_info.Read2, read_info.Len2)
+	}
+	// Bases of the overlap (the last 10 bases of the first half, the first 10 bases of the second one)
+	// have summed qualities
+	if string(read_info.Qual1) != strings.Repeat("5", 10)+strings.Repeat("I", 10) || string(read_info.Qual2) != strings.Repeat("5", 10)+strings.Repeat("I", 10) {
+		t.Errorf("got qualities %s, %s", read_info.Qual1, read_info.Qual2)
+	}
+
+	read_info = ivc.InitReadInfo(30, 30, 10)
+	copy(read_info.Read1, frag[:30])
+	copy(read_info.Read2, strings.Repeat("A", 30))
+	if M.MergePair(read_info) || read_info.Len1 != 30 || read_info.Len2 != 30 {
+		t.Errorf("pair without overlap is merged")
+	}
+}
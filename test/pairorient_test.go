@@ -0,0 +1,39 @@
+//----------------------------------------------------------------------------------------
+// Test for orientations and insert sizes of read pairs
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// In spliced mode, read pairs farther apart than the maximum insert size are only proper across a
+// reference skip
+func TestSkipPairDist(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Pair_orient, ivc.PARA.Max_ins = ivc.PAIR_ORIENT_FR, 500
+	ivc.SetupPairRegimes()
+	if ivc.ProperPairDist(1000, 5000, 100, 100, true, false) || ivc.SkipPairDist(1000, 5000, 100, 100, true, false) {
+		t.Errorf("distant pair is proper without spliced mode")
+	}
+	ivc.PARA.Spliced = true
+	ivc.SetupPairRegimes()
+	test_cases := []struct {
+		pos2   int
+		proper bool
+		skip   bool
+	}{
+		{1300, true, false},
+		{5000, true, true},
+		{1000 + 100 + 500 + ivc.SPLICE_MAX_INTRON + 1, false, false},
+	}
+	for _, c := range test_cases {
+		if ivc.ProperPairDist(1000, c.pos2, 100, 100, true, false) != c.proper || ivc.SkipPairDist(1000, c.pos2, 100, 100, true, false) != c.skip {
+			t.Errorf("%d: expected proper %v, skip %v", c.pos2, c.proper, c.skip)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+//----------------------------------------------------------------------------------------
+// Test for reads longer than the first reads of input files
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+	"github.com/namsyvo/IVC/align"
+)
+
+// FastqOfLens returns FASTQ records of given lengths
+func FastqOfLens(lens ...int) string {
+	var fq strings.Builder
+	for i, l := range lens {
+		fq.WriteString("@read" + strconv.Itoa(i) + "\n" + strings.Repeat("ACGT", l)[:l] + "\n+\n" + strings.Repeat("I", l) + "\n")
+	}
+	return fq.String()
+}
+
+// Reads longer than the buffers sized from the first reads are passed with all their bases
+func TestLongerReads(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Read_len_1, ivc.PARA.Read_len_2, ivc.PARA.Info_len, ivc.PARA.Min_slen = 20, 20, 100, 5
+	ivc.PARA.Mate_check = ivc.MATE_CHECK_OFF
+	lens_1, lens_2 := []int{20, 35, 18, 20}, []int{20, 20, 42, 20}
+	read_data := make(chan *ivc.ReadInfo, len(lens_1))
+	VC := new(ivc.VarCallIndex)
+	e := VC.ReadPairedReads(strings.NewReader(FastqOfLens(lens_1...)), strings.NewReader(FastqOfLens(lens_2...)), "r1.fq", "r2.fq", 0,
+		read_data, ivc.NewReadPool(len(lens_1)+1))
+	if e != nil {
+		t.Fatal(e)
+	}
+	close(read_data)
+	i := 0
+	for r := range read_data {
+		if r.Len1 != lens_1[i] || r.Len2 != lens_2[i] || string(r.Read1) != strings.Repeat("ACGT", lens_1[i])[:lens_1[i]] ||
+			len(r.Qual2) != lens_2[i] || len(r.Rev_comp_read2) != lens_2[i] {
+			t.Errorf("read %d: got lengths %d/%d, expected %d/%d", i, r.Len1, r.Len2, lens_1[i], lens_2[i])
+		}
+		i++
+	}
+	if i != len(lens_1) {
+		t.Errorf("got %d reads, expected %d", i, len(lens_1))
+	}
+	// Alignment matrices are grown for longer reads
+	seq := strings.Repeat("ACGTTGCA", 10)
+	A := align.New(align.Params{Dist_thres: 10, Sub_cost: 4, Gap_open: 4, Gap_ext: 1, Indel_err_rate: 0.0001},
+		align.Genome{Seq: []byte(seq), SeqLen: len(seq)}, make(TraceSites))
+	read := []byte(seq)
+	read[75] = 'C'
+	ref_pos_map := make([]int, len(seq))
+	for j := range ref_pos_map {
+		ref_pos_map[j] = j
+	}
+	aln_info := ivc.InitEditAlnInfo(40)
+	aln_info.Grow(2 * len(read))
+	task := &ivc.AlnTask{Read: read, Qual: []byte(strings.Repeat("I", len(read))), Ref: []byte(seq), RefPosMap: ref_pos_map, DropRows: 1,
+		Left: true, Info: aln_info}
+	ivc.CPUBackend{}.AlignBatch(A, []*ivc.AlnTask{task})
+	if task.M < 40 || task.HamDist+task.EditDist != 4 {
+		t.Errorf("got an edit part of %d bases, distance %g", task.M, task.HamDist+task.EditDist)
+	}
+}
@@ -0,0 +1,119 @@
+//----------------------------------------------------------------------------------------
+// Test for local realignment of reads around candidate indels
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// RealnTestRead returns a read-end of a haplotype aligned without gaps to the reference from start,
+// with its mismatches as variants
+func RealnTestRead(ref, hap []byte, hap_start, start, l int) *ivc.RealnRead {
+	r := &ivc.RealnRead{Read: hap[hap_start : hap_start+l], Qual: []byte(strings.Repeat("I", l)), Start: start}
+	for i, b := range r.Read {
+		if b != ref[start+i] {
+			r.Vars = append(r.Vars, &ivc.VarInfo{Pos: uint32(start + i), Bases: []byte(string(ref[start+i]) + "|" + string(b)),
+				BQual: r.Qual[i : i+1]})
+		}
+	}
+	return r
+}
+
+// Candidates are the most supported indels of positions with at least 2 supporting reads, up to a
+// complete window
+func TestRealnCandidates(t *testing.T) {
+	indel := func(pos int, bases string, typ int) *ivc.VarInfo {
+		return &ivc.VarInfo{Pos: uint32(pos), Bases: []byte(bases), Type: typ}
+	}
+	next := ivc.REALN_WIN_LEN + 10
+	reads := []*ivc.RealnRead{
+		{Vars: []*ivc.VarInfo{indel(119, "AC|A", 2), indel(150, "A|AT", 1), indel(160, "A|C", 0)}},
+		{Vars: []*ivc.VarInfo{indel(119, "AC|A", 2), indel(next, "G|GA", 1), indel(160, "A|C", 0)}},
+		{Vars: []*ivc.VarInfo{indel(119, "ACG|A", 2), indel(next, "G|GA", 1)}},
+		{Vars: []*ivc.VarInfo{indel(119, "AC|A", 2)}},
+	}
+	S := make(ivc.RealnSupport)
+	S.Count(reads)
+	for _, test := range []struct {
+		win   int
+		cands []ivc.RealnCand
+	}{
+		{0, []ivc.RealnCand{{Pos: 119, Ref: "AC", Alt: "A", Type: 2, ReadNum: 3}}},
+		{0, []ivc.RealnCand{}},
+		{1, []ivc.RealnCand{{Pos: next, Ref: "G", Alt: "GA", Type: 1, ReadNum: 2}}},
+	} {
+		cands := make([]ivc.RealnCand, 0)
+		for _, cand := range S.Candidates(test.win) {
+			cands = append(cands, *cand)
+		}
+		if !reflect.DeepEqual(cands, test.cands) {
+			t.Errorf("window %d: got candidates %v, expected %v", test.win, cands, test.cands)
+		}
+	}
+	if len(S) != 0 {
+		t.Errorf("got %d positions with counts, expected none", len(S))
+	}
+}
+
+// Read-ends with mismatches around a candidate indel are re-aligned against its haplotype, anchored at
+// their start or their end; read-ends with indels or without mismatches around it are not
+func TestRealignRead(t *testing.T) {
+	ref := RandomSeq(300, 3)
+	copy(ref[118:], "TACG")
+	VC := &ivc.VarCallIndex{Seq: ref, SeqLen: len(ref)}
+	del := &ivc.RealnCand{Pos: 119, Ref: "AC", Alt: "A", Type: 2, ReadNum: 3}
+	ins := &ivc.RealnCand{Pos: 119, Ref: "A", Alt: "AGG", Type: 1, ReadNum: 3}
+	del_hap := append(append([]byte{}, ref[:120]...), ref[121:]...)
+	ins_hap := append(append(append([]byte{}, ref[:120]...), "GG"...), ref[120:]...)
+	far_snp := RealnTestRead(ref, del_hap, 80, 80, 60)
+	far_snp.Read = append([]byte{}, far_snp.Read...)
+	far_snp.Read[2] = "CGTA"[strings.IndexByte("ACGT", far_snp.Read[2])]
+	far_snp = RealnTestRead(ref, far_snp.Read, 0, 80, 60)
+	shifted_snp := append([]byte{}, ins_hap...)
+	shifted_snp[147] = "CGTA"[strings.IndexByte("ACGT", shifted_snp[147])] // reference position 145
+	for _, test := range []struct {
+		name string
+		r    *ivc.RealnRead
+		cand *ivc.RealnCand
+		vars map[uint32]string
+	}{
+		{"left-anchored deletion", far_snp, del, map[uint32]string{82: string(ref[82:83]) + "|" + string(far_snp.Read[2:3]), 119: "AC|A"}},
+		{"right-anchored deletion", RealnTestRead(ref, del_hap, 100, 101, 60), del, map[uint32]string{119: "AC|A"}},
+		{"left-anchored insertion", RealnTestRead(ref, shifted_snp, 90, 90, 60), ins,
+			map[uint32]string{119: "A|AGG", 145: string(ref[145:146]) + "|" + string(shifted_snp[147:148])}},
+		{"right-anchored insertion", RealnTestRead(ref, ins_hap, 100, 98, 60), ins, map[uint32]string{119: "A|AGG"}},
+		{"reference", RealnTestRead(ref, ref, 100, 100, 60), del, nil},
+		{"other indel", &ivc.RealnRead{Read: del_hap[100:160], Start: 100,
+			Vars: []*ivc.VarInfo{{Pos: 119, Bases: []byte("AC|A"), Type: 2}}}, del, nil},
+	} {
+		vars, ok := VC.RealignRead(test.r, test.cand)
+		if !ok {
+			if test.vars != nil {
+				t.Errorf("%s: got no realignment", test.name)
+			}
+			continue
+		}
+		got := make(map[uint32]string)
+		for _, v := range vars {
+			got[v.Pos] = string(v.Bases)
+		}
+		if !reflect.DeepEqual(got, test.vars) {
+			t.Errorf("%s: got variants %v, expected %v", test.name, got, test.vars)
+		}
+	}
+
+	// Read-ends overlapping candidates with anchors are re-aligned, but not those of assembled regions
+	reads := []*ivc.RealnRead{RealnTestRead(ref, del_hap, 100, 101, 60), RealnTestRead(ref, del_hap, 100, 100, 22),
+		RealnTestRead(ref, del_hap, 80, 80, 60)}
+	reads[2].Asm = true
+	if realn_num := VC.RealignReads(reads, []*ivc.RealnCand{del}); realn_num != 1 {
+		t.Errorf("got %d realigned read-ends, expected 1", realn_num)
+	}
+}
@@ -0,0 +1,118 @@
+//----------------------------------------------------------------------------------------
+// Test for variant score recalibration
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// RecalCallLine returns a VCF line of a call with annotations, known calls have higher QD
+func RecalCallLine(pos int, known bool, filter string) string {
+	info := "QD=" + strconv.Itoa(2+pos%4) + ";MQ=60.00;FS=" + strconv.Itoa(pos%3)
+	if known {
+		info = "KV;QD=" + strconv.Itoa(15+pos%5) + ";MQ=60.00;FS=" + strconv.Itoa(pos%3)
+	}
+	return "chr1\t" + strconv.Itoa(pos) + "\t.\tA\tG\t30\t" + filter + "\t" + info + "\tGT:DP\t0/1:" + strconv.Itoa(20+pos%7)
+}
+
+// The model separates known and novel calls by informative annotations, constant annotations are not
+// used, and too few calls are rejected
+func TestTrainRecalModel(t *testing.T) {
+	var values []map[string]float64
+	var labels []bool
+	for i := 0; i < 40; i++ {
+		tokens := strings.Split(RecalCallLine(i, i%2 == 0, "PASS"), "\t")
+		values = append(values, ivc.FilterValues(30, tokens[7], tokens[8], tokens[9]))
+		labels = append(labels, i%2 == 0)
+	}
+	M := ivc.TrainRecalModel(values, labels)
+	if strings.Join(M.Keys, ",") != "QD,FS,DP" {
+		t.Errorf("got annotations %v, expected QD, FS, DP", M.Keys)
+	}
+	if M.Weights[0] <= 0 {
+		t.Errorf("got weight %g of QD, expected a positive weight", M.Weights[0])
+	}
+	min_pos, max_neg := 1e9, -1e9
+	for i, v := range values {
+		if s := M.Score(M.Features(v)); labels[i] && s < min_pos {
+			min_pos = s
+		} else if !labels[i] && s > max_neg {
+			max_neg = s
+		}
+	}
+	if min_pos <= max_neg {
+		t.Errorf("got scores of known calls from %g, of novel calls up to %g", min_pos, max_neg)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected too few calls to be rejected")
+		}
+	}()
+	ivc.TrainRecalModel(values[:10], labels[:10])
+}
+
+// Recalibrated calls get scores and QUAL from the model, and calls below the threshold keeping the
+// sensitivity on known sites (KV calls and sites of the known-variant file) are filtered
+func TestRecalibrate(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"##fileformat=VCFv4.2", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE"}
+	for pos := 1; pos <= 40; pos++ {
+		filter := "PASS"
+		if pos == 3 {
+			filter = "LowQual"
+		}
+		lines = append(lines, RecalCallLine(pos, pos%2 == 0, filter))
+	}
+	lines = append(lines, "chr1\t100\t.\tA\t.\t0\tPASS\tDP=30\tGT\t0/0") // reference site (not recalibrated)
+	call_file := WriteTestFile(t, dir, "calls.vcf", lines...)
+	known_file := WriteTestFile(t, dir, "known.vcf", "chr1\t7\t.\tA\tG\t.\t.\t.") // a novel call at a known site
+	out_file := filepath.Join(dir, "recal.vcf")
+	ivc.Recalibrate(call_file, known_file, out_file, 0.9)
+	data, e := os.ReadFile(out_file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	known_filtered, novel_filtered := 0, 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.HasPrefix(line, "##FILTER=<ID=RecalLow") || strings.HasPrefix(line, "##INFO=<ID=RCS") || line[0] == '#' {
+			continue
+		}
+		tokens := strings.Split(line, "\t")
+		if tokens[4] == "." {
+			if tokens[5] != "0" || tokens[7] != "DP=30" {
+				t.Errorf("reference site is changed: %s", line)
+			}
+			continue
+		}
+		if !strings.Contains(tokens[7], ";RCS=") || tokens[5] == "30" {
+			t.Errorf("call is not recalibrated: %s", line)
+		}
+		pos, _ := strconv.Atoi(tokens[1])
+		if strings.Contains(tokens[6], "RecalLow") {
+			if strings.Contains(tokens[7], "KV") || pos == 7 {
+				known_filtered++
+			} else {
+				novel_filtered++
+			}
+		}
+		if pos == 3 && tokens[6] != "LowQual;RecalLow" {
+			t.Errorf("got filter %s of a filtered novel call", tokens[6])
+		}
+	}
+	// 21 known sites: the threshold is the score of the third lowest one
+	if known_filtered > 2 || novel_filtered != 19 {
+		t.Errorf("got %d filtered known calls, %d filtered novel calls", known_filtered, novel_filtered)
+	}
+	if !strings.Contains(string(data), "##FILTER=<ID=RecalLow") || !strings.Contains(string(data), "##INFO=<ID=RCS") {
+		t.Errorf("header lines of recalibration are missing")
+	}
+}
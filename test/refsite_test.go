@@ -0,0 +1,37 @@
+//----------------------------------------------------------------------------------------
+// Test for depth of aligned reads at reference sites
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Depth is increased at positions of reads spanning several shards, concurrently, and reads are
+// clipped to the multigenome
+func TestAddCoverage(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Proc_num = 3
+	VC := &ivc.VarCallIndex{SeqLen: 10}
+	ivc.SiteDepth, ivc.SiteDepthMut = make([]uint16, 10), make([]sync.Mutex, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			VC.AddCoverage(2, 6)
+			VC.AddCoverage(-2, 3)
+			VC.AddCoverage(8, 5)
+		}()
+	}
+	wg.Wait()
+	if expected := []uint16{100, 0, 100, 100, 100, 100, 100, 100, 100, 100}; !reflect.DeepEqual(ivc.SiteDepth, expected) {
+		t.Errorf("got depth %v, expected %v", ivc.SiteDepth, expected)
+	}
+}
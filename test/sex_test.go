@@ -0,0 +1,124 @@
+//----------------------------------------------------------------------------------------
+// Test for sex-aware ploidy of sex chromosomes
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+	"github.com/namsyvo/IVC/caller"
+)
+
+// Sex chromosomes are recognized with or without the prefix chr
+func TestSexChr(t *testing.T) {
+	for name, expected := range map[string]string{"chrX": "X", "Y": "Y", "chrx": "X", "chr1": "", "chrXY": "", "X1": ""} {
+		if s := ivc.SexChr(name); s != expected {
+			t.Errorf("%s: got %q, expected %q", name, s, expected)
+		}
+	}
+}
+
+// Haploid regions of sex chromosomes exclude PARs of the genome build recognized by the length of chrX,
+// or are whole chromosomes for other builds
+func TestSexChrRegions(t *testing.T) {
+	x_len, y_len := 155270560, 59373566 // GRCh37
+	x, y := 1000, 1000+x_len
+	VC := &ivc.VarCallIndex{SeqLen: y + y_len, ChrPos: []int{0, x, y}, ChrName: [][]byte{[]byte("chr1"), []byte("chrX"), []byte("chrY")}}
+	expected := map[string][][2]int{
+		"X": {{x, x + 60000}, {x + 2699520, x + 154931043}, {x + 155260560, y}},
+		"Y": {{y, y + 10000}, {y + 2649520, y + 59034049}, {y + 59363566, y + y_len}},
+	}
+	if regions := VC.SexChrRegions(); !reflect.DeepEqual(regions, expected) {
+		t.Errorf("got regions %v, expected %v", regions, expected)
+	}
+	VC = &ivc.VarCallIndex{SeqLen: 3000, ChrPos: []int{0, 1000, 2000}, ChrName: [][]byte{[]byte("1"), []byte("X"), []byte("Y")}}
+	if regions, expected := VC.SexChrRegions(), map[string][][2]int{"X": {{1000, 2000}}, "Y": {{2000, 3000}}}; !reflect.DeepEqual(regions, expected) {
+		t.Errorf("got regions %v, expected %v", regions, expected)
+	}
+}
+
+// Sex is inferred from depth of aligned reads at known variant locations of chrX and chrY relative to
+// autosomes, and haploid regions are set up for males
+func TestInferSex(t *testing.T) {
+	defer func() { ivc.HAPLOID_REGIONS = nil }()
+	for _, test := range []struct {
+		sex              string
+		x_depth, y_depth int
+		inferred         string
+	}{
+		{ivc.SEX_AUTO, 10, 10, ivc.SEX_MALE},
+		{ivc.SEX_AUTO, 20, 0, ivc.SEX_FEMALE},
+		{ivc.SEX_AUTO, 20, 10, ""},
+		{ivc.SEX_AUTO, 0, 0, ""},
+		{ivc.SEX_MALE, 20, 0, ivc.SEX_MALE},
+		{ivc.SEX_FEMALE, 10, 10, ivc.SEX_FEMALE},
+	} {
+		ivc.PARA = &ivc.ParaInfo{Proc_num: 2, Read_len: 100, Sex: test.sex}
+		ivc.L2E = []float64{1, 0.0001}
+		VC := &ivc.VarCallIndex{SeqLen: 3000, ChrPos: []int{0, 1000, 2000}, ChrName: [][]byte{[]byte("chr1"), []byte("chrX"), []byte("chrY")},
+			Variants: make(map[int][][]byte)}
+		depths := make(map[int]int)
+		for i := 0; i < 60; i++ {
+			VC.Variants[10*i], depths[10*i] = [][]byte{[]byte("A"), []byte("C")}, 20
+		}
+		for i := 0; i < 30; i++ {
+			VC.Variants[1000+10*i], depths[1000+10*i] = [][]byte{[]byte("A"), []byte("C")}, test.x_depth
+		}
+		for i := 0; i < 20; i++ {
+			VC.Variants[2000+10*i], depths[2000+10*i] = [][]byte{[]byte("A"), []byte("C")}, test.y_depth
+		}
+		VC.InitVarCall()
+		r := 0
+		for pos, depth := range depths {
+			for i := 0; i < depth; i++ {
+				VC.CollectVariant(&ivc.VarInfo{Pos: uint32(pos), Bases: []byte("A|A"), BQual: []byte{'I'},
+					RSeed: ivc.ReadSeed(7, []byte("@read"+strconv.Itoa(r)))})
+				r++
+			}
+		}
+		VC.ApplyKeptBases()
+		VC.SetupPloidy()
+		if ivc.SUMMARY.Sex != test.inferred {
+			t.Errorf("sex %s, depths %d, %d: got sex %q, expected %q", test.sex, test.x_depth, test.y_depth, ivc.SUMMARY.Sex, test.inferred)
+		}
+		if haploid := ivc.IsHaploid(1500) && ivc.IsHaploid(2500) && !ivc.IsHaploid(500); haploid != (test.inferred == ivc.SEX_MALE) {
+			t.Errorf("sex %s, depths %d, %d: got haploid regions %v", test.sex, test.x_depth, test.y_depth, ivc.HAPLOID_REGIONS)
+		}
+	}
+}
+
+// Haploid genotypes and likelihoods are taken from homozygous diploid genotypes
+func TestHaploidGenotypes(t *testing.T) {
+	if likes := ivc.HaploidLikes([]float64{-1, -0.5, -3}); !reflect.DeepEqual(likes, []float64{-1, -3}) {
+		t.Errorf("got haploid likelihoods %v, expected [-1 -3]", likes)
+	}
+	if pl := ivc.PLString([]float64{-1, -3}); pl != "0,20" {
+		t.Errorf("got PL %s, expected 0,20", pl)
+	}
+	for gt, expected := range map[string]string{"0/0": "0", "1/1": "1", "1|1": "1"} {
+		if hap_gt := ivc.HaploidGT(gt); hap_gt != expected {
+			t.Errorf("%s: got haploid genotype %s, expected %s", gt, hap_gt, expected)
+		}
+	}
+}
+
+// Priors of heterozygous genotypes are removed at haploid locations
+func TestHaploidPriors(t *testing.T) {
+	priors := map[string]float64{"A|A": 0.6, "A|C": 0.3, "C|C": 0.1}
+	caller.HaploidPriors(priors)
+	if math.Abs(priors["A|A"]-6.0/7) > 1e-12 || priors["A|C"] != 0 || math.Abs(priors["C|C"]-1.0/7) > 1e-12 {
+		t.Errorf("got %v", priors)
+	}
+	// Priors are left unnormalized if there are no homozygous genotypes with positive priors
+	priors = map[string]float64{"A|C": 1, "C|C": 0}
+	caller.HaploidPriors(priors)
+	if priors["A|C"] != 0 || priors["C|C"] != 0 {
+		t.Errorf("got %v", priors)
+	}
+}
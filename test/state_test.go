@@ -0,0 +1,72 @@
+//----------------------------------------------------------------------------------------
+// Test for saving, loading and merging variant call states
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+	"github.com/namsyvo/IVC/caller"
+)
+
+// NewTestState returns a state of two samples with reads of alleles at a location, and reads of the
+// reference at another location.
+func NewTestState(pos uint32, rnum map[string]int, ref_pos uint32) *ivc.VarCallState {
+	S := &ivc.VarCallState{SeqLen: 1000, ChrName: []string{"chr1"}, ChrPos: []int{0}, SampleNum: 2, Sites: make(map[uint32]*ivc.SiteState)}
+	S.Sites[pos] = &ivc.SiteState{
+		RNum:       rnum,
+		RevNum:     map[string]int{"A|A": 1},
+		Depth:      3,
+		Stat:       &ivc.SiteStat{MQSquareSum: 3600, ReadNum: 1, RefBQual: []int{30}, RefRPos: []int{5}},
+		Like:       map[string]*caller.AlleleLike{"A": {Both: -10, One: -20, None: -30}},
+		SampleRNum: []map[string]int{rnum, {"A|A": 1}},
+		SampleRev:  []map[string]int{{"A|A": 1}, {"A|A": 1}},
+		SampleLike: []map[string]*caller.AlleleLike{{"A": {Both: -1}}, {"A": {Both: -2}}},
+	}
+	S.Sites[ref_pos] = &ivc.SiteState{RNum: map[string]int{"G|G": 2}, RevNum: map[string]int{"G|G": 1}, Depth: 2,
+		Stat: &ivc.SiteStat{ReadNum: 2}, Like: map[string]*caller.AlleleLike{"G": {Both: -5}},
+		SampleRNum: []map[string]int{{"G|G": 2}, {"G|G": 1}}, SampleRev: []map[string]int{{"G|G": 1}, {"G|G": 1}},
+		SampleLike: []map[string]*caller.AlleleLike{{"G": {Both: -5}}, {"G": {Both: -1}}}}
+	return S
+}
+
+// States are saved and loaded unchanged
+func TestStateSaveLoad(t *testing.T) {
+	S := NewTestState(10, map[string]int{"A|A": 2, "A|C": 1}, 20)
+	file_name := filepath.Join(t.TempDir(), "state.gz")
+	ivc.SaveState(S, file_name)
+	if T := ivc.LoadVarCallState(file_name); !reflect.DeepEqual(S, T) {
+		t.Errorf("got %+v, expected %+v", T, S)
+	}
+}
+
+// Statistics of locations of both states are added, other locations are taken as they are
+func TestStateMerge(t *testing.T) {
+	dir := t.TempDir()
+	ivc.SaveState(NewTestState(10, map[string]int{"A|A": 2, "A|C": 1}, 20), filepath.Join(dir, "s1.gz"))
+	ivc.SaveState(NewTestState(10, map[string]int{"A|C": 4}, 30), filepath.Join(dir, "s2.gz"))
+	S := ivc.MergeVarCallStates([]string{filepath.Join(dir, "s1.gz"), filepath.Join(dir, "s2.gz")})
+	if len(S.Sites) != 3 || S.Sites[20] == nil || S.Sites[30] == nil || S.Sites[20].Depth != 2 || S.Sites[30].Depth != 2 {
+		t.Fatalf("got locations %v", S.Sites)
+	}
+	site := S.Sites[10]
+	if !reflect.DeepEqual(site.RNum, map[string]int{"A|A": 2, "A|C": 5}) || !reflect.DeepEqual(site.RevNum, map[string]int{"A|A": 2}) || site.Depth != 6 {
+		t.Errorf("got numbers of reads %v, %v, depth %d", site.RNum, site.RevNum, site.Depth)
+	}
+	if site.Stat.ReadNum != 2 || site.Stat.MQSquareSum != 7200 || !reflect.DeepEqual(site.Stat.RefBQual, []int{30, 30}) {
+		t.Errorf("got statistics %+v", site.Stat)
+	}
+	if *site.Like["A"] != (caller.AlleleLike{Both: -20, One: -40, None: -60}) {
+		t.Errorf("got likelihoods %+v", site.Like["A"])
+	}
+	if !reflect.DeepEqual(site.SampleRNum, []map[string]int{{"A|A": 2, "A|C": 5}, {"A|A": 2}}) ||
+		!reflect.DeepEqual(site.SampleRev, []map[string]int{{"A|A": 2}, {"A|A": 2}}) ||
+		site.SampleLike[0]["A"].Both != -2 || site.SampleLike[1]["A"].Both != -4 {
+		t.Errorf("got statistics of samples %v, %v, %v", site.SampleRNum, site.SampleRev, site.SampleLike)
+	}
+}
@@ -0,0 +1,176 @@
+//----------------------------------------------------------------------------------------
+// Test for the sweep of hard-filter thresholds against a truth set
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Calls are loaded with numbers of true and false alleles within regions, FS is computed from SB if
+// calls do not have it, homozygous-reference records are ignored
+func TestLoadSweepCalls(t *testing.T) {
+	dir := t.TempDir()
+	call_file := WriteTestFile(t, dir, "calls.vcf",
+		"##fileformat=VCFv4.2",
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE",
+		"chr1\t10\t.\tA\tC\t50\tPASS\tDP=20\tGT:SB\t0/1:10,10,20,0",
+		"chr1\t20\t.\tA\tC,G\t40\tLowQual\tDP=30;FS=12.5\tGT:SB\t1/2:10,10,10,10",
+		"chr1\t30\t.\tA\t.\t60\tPASS\tDP=40\tGT\t0/0",
+		"chr1\t40\t.\tA\tT\t60\tPASS\tDP=40\tGT\t0/0",
+		"chr1\t500\t.\tA\tT\t60\tPASS\tDP=40\tGT\t0/1")
+	truths := map[string]string{"chr1:10:A:C": "", "chr1:20:A:G": "", "chr1:500:A:T": ""}
+	calls, found := ivc.LoadSweepCalls(call_file, truths, map[string][][2]int{"chr1": {{0, 100}}})
+	if len(calls) != 2 || found != 2 {
+		t.Fatalf("got %d calls, %d alleles found, expected 2 calls, 2 alleles found", len(calls), found)
+	}
+	if calls[0].TP != 1 || calls[0].FP != 0 || calls[1].TP != 1 || calls[1].FP != 1 {
+		t.Errorf("got TP/FP %d/%d and %d/%d, expected 1/0 and 1/1", calls[0].TP, calls[0].FP, calls[1].TP, calls[1].FP)
+	}
+	if fs := ivc.FisherStrand([4]int{10, 10, 20, 0}); calls[0].Values["FS"] != fs || calls[0].Values["QUAL"] != 50 || calls[0].Values["DP"] != 20 {
+		t.Errorf("got values %v, expected QUAL 50, DP 20, FS %g", calls[0].Values, fs)
+	}
+	if calls[1].Values["FS"] != 12.5 {
+		t.Errorf("got FS %g, expected FS of INFO 12.5", calls[1].Values["FS"])
+	}
+}
+
+// Thresholds are distinct values at quantiles of annotations, from the least to the most strict, and
+// calls without the annotation are ignored
+func TestSweepThresholds(t *testing.T) {
+	calls := make([]*ivc.SweepCall, 0)
+	for i := 1; i <= 100; i++ {
+		calls = append(calls, &ivc.SweepCall{Values: map[string]float64{"QUAL": float64(i), "FS": float64(i) / 3, "MQ": 60}})
+	}
+	calls = append(calls, &ivc.SweepCall{Values: map[string]float64{"FS": math.NaN()}}, &ivc.SweepCall{Values: map[string]float64{}})
+	for _, test := range []struct {
+		key, op string
+		thres   []float64
+	}{
+		{"QUAL", "<", []float64{2, 3, 6, 11, 21, 31}},
+		{"FS", ">", []float64{33, 32.6667, 31.6667, 30, 26.6667, 23.3333}},
+		{"MQ", "<", []float64{60}},
+		{"DP", "<", []float64{}},
+	} {
+		if thres := ivc.SweepThresholds(calls, test.key, test.op); !reflect.DeepEqual(thres, test.thres) {
+			t.Errorf("%s: got thresholds %v, expected %v", test.key, thres, test.thres)
+		}
+	}
+}
+
+// The frontier keeps points not dominated in precision and recall, with the fewest filters of points
+// with the same counts, and presets of maximum F1 or of maximum recall at a minimum precision are
+// recommended
+func TestSweepFrontier(t *testing.T) {
+	point := func(expr string, tp, fp, fn int) *ivc.SweepPoint {
+		P := &ivc.SweepPoint{Filters: make([]*ivc.FilterInfo, len(ivc.SWEEP_KEYS)), Stat: ivc.EvalStat{TP: tp, FP: fp, FN: fn}}
+		if expr != "" {
+			for i, f := range ivc.SetupFilters(expr, "") {
+				P.Filters[i] = f
+			}
+		}
+		return P
+	}
+	points := []*ivc.SweepPoint{
+		point("QUAL<10,DP<5", 80, 10, 20),
+		point("", 90, 30, 10),
+		point("QUAL<10", 80, 10, 20),
+		point("QUAL<20", 60, 2, 40),
+		point("DP<5", 85, 40, 15),   // dominated
+		point("QUAL<30", 50, 5, 50), // dominated
+		point("QUAL<40", 20, 0, 80),
+	}
+	var exprs []string
+	for _, P := range ivc.SweepFrontier(points) {
+		exprs = append(exprs, P.Expr())
+	}
+	if expected := []string{"none", "QUAL<10", "QUAL<20", "QUAL<40"}; !reflect.DeepEqual(exprs, expected) {
+		t.Fatalf("got frontier %v, expected %v", exprs, expected)
+	}
+	frontier := ivc.SweepFrontier(points)
+	for _, test := range []struct {
+		min_prec float64
+		expr     string
+	}{
+		{0, "QUAL<10"},
+		{0.95, "QUAL<20"},
+		{1, "QUAL<40"},
+	} {
+		if best := ivc.RecommendFilters(frontier, test.min_prec); best == nil || best.Expr() != test.expr {
+			t.Errorf("minimum precision %g: got %v, expected %s", test.min_prec, best, test.expr)
+		}
+	}
+	if best := ivc.RecommendFilters(frontier[:2], 0.95); best != nil {
+		t.Errorf("got preset %s, expected none reaching the minimum precision", best.Expr())
+	}
+}
+
+// Sweeps of calls against a truth set recommend thresholds removing most false calls, and the preset
+// file gives the same filters
+func TestSweepFilters(t *testing.T) {
+	dir := t.TempDir()
+	call_lines := []string{"##fileformat=VCFv4.2", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tSAMPLE"}
+	truth_lines := []string{"##fileformat=VCFv4.2", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO"}
+	for i := 0; i < 20; i++ {
+		qual, alt := float64(50+i), "C" // true calls
+		switch i {
+		case 16, 17, 18:
+			qual, alt = float64(i-11), "G" // false calls of low quality
+		case 19:
+			qual, alt = 55.5, "G" // false call of high quality
+		}
+		call_lines = append(call_lines, fmt.Sprintf("chr1\t%d\t.\tA\t%s\t%g\tPASS\tDP=30;MQ=60\tGT:SB\t0/1:10,10,10,10", 10*(i+1), alt, qual))
+		if alt == "C" {
+			truth_lines = append(truth_lines, fmt.Sprintf("chr1\t%d\t.\tA\tC\t.\tPASS\t.", 10*(i+1)))
+		}
+	}
+	truth_lines = append(truth_lines, "chr1\t300\t.\tA\tC\t.\tPASS\t.", "chr1\t310\t.\tA\tC\t.\tPASS\t.", "chr1\t900\t.\tA\tC\t.\tPASS\t.")
+	call_lines = append(call_lines, "chr1\t900\t.\tA\tT\t5\tPASS\tDP=30;MQ=60\tGT:SB\t0/1:10,10,10,10")
+	call_file, truth_file := WriteTestFile(t, dir, "calls.vcf", call_lines...), WriteTestFile(t, dir, "truth.vcf.gz", truth_lines...)
+	bed_file := WriteTestFile(t, dir, "regions.bed", "chr1\t0\t500")
+
+	// QUAL thresholds 5, 6, 7, 51, 53: QUAL<7 removes 2 false calls, QUAL<51 removes 3 and 1 true call
+	frontier := ivc.SweepFilters(call_file, truth_file, bed_file)
+	var report bytes.Buffer
+	ivc.WriteSweepReport(&report, frontier, ivc.RecommendFilters(frontier, 0))
+	expected := "FILTERS\tTP\tFP\tFN\tPRECISION\tRECALL\tF1\n" +
+		"QUAL<7\t16\t2\t2\t0.8889\t0.8889\t0.8889\n" +
+		"QUAL<51\t15\t1\t3\t0.9375\t0.8333\t0.8824\n" +
+		"#Recommended preset: -filter \"QUAL<7\"\n"
+	if report.String() != expected {
+		t.Errorf("got report\n%s\nexpected\n%s", report.String(), expected)
+	}
+	if best := ivc.RecommendFilters(frontier, 0.9); best == nil || best.Expr() != "QUAL<51" {
+		t.Errorf("got preset %v at minimum precision 0.9, expected QUAL<51", best)
+	}
+	report.Reset()
+	ivc.WriteSweepReport(&report, frontier, ivc.RecommendFilters(frontier, 0.99))
+	if !strings.HasSuffix(report.String(), "#Recommended preset: none (no thresholds reach the minimum precision)\n") {
+		t.Errorf("got report\n%s\nexpected no recommended preset", report.String())
+	}
+
+	preset_file := filepath.Join(dir, "preset.txt")
+	ivc.WritePresetFile(preset_file, frontier[0])
+	filters := ivc.SetupFilters("", preset_file)
+	for _, test := range []struct {
+		qual   float64
+		filter string
+	}{
+		{6, "QUAL_lt_7"},
+		{7, "PASS"},
+	} {
+		if filter := ivc.ApplyFilters(filters, ivc.FilterValues(test.qual, "DP=30", "", "")); filter != test.filter {
+			t.Errorf("QUAL %g: got %s, expected %s", test.qual, filter, test.filter)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+//----------------------------------------------------------------------------------------
+// Test for tracing back alignments between reads and multi-genomes
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+// A step of an alignment path: state of the traceback when the step is reached (0: D, 1: IS, 2: IT),
+// matrix holding its backtrace (known loci without an allele are traced back through IT), and the
+// allele of a known variant locus aligned with D
+type TraceStep struct {
+	State  int
+	Mat    int
+	Allele string
+}
+
+// A variant reported by a traceback
+type TraceVar struct {
+	Pos  int
+	Base string
+	Qual string
+	Type int
+}
+
+// Positions of new variant locations of tracebacks
+type TraceSites map[int]bool
+
+func (S TraceSites) HasSite(pos int) bool { return S[pos] }
+
+// SetupTraceBack sets up an aligner used by tracebacks: a multi-genome of given ref and known variants,
+// and new variant locations at given positions.
+func SetupTraceBack(ref string, variants map[int][]string, same_len, del map[int]int, new_var []int) *align.Aligner {
+	G := align.Genome{Seq: []byte(ref), SeqLen: len(ref), Variants: make(map[int][][]byte), SameLenVar: same_len, DelVar: del}
+	for pos, alleles := range variants {
+		for _, a := range alleles {
+			G.Variants[pos] = append(G.Variants[pos], []byte(a))
+		}
+	}
+	sites := make(TraceSites)
+	for _, pos := range new_var {
+		sites[pos] = true
+	}
+	return align.New(align.Params{Indel_err_rate: 0.0001}, G, sites)
+}
+
+// TraceMatrices builds backtrace matrices of an alignment path given from the first base of the read
+// and the ref. Left tracebacks start at the last step and follow backtraces to previous steps, right
+// tracebacks start at the first step (with numbers of remaining bases) and follow them to next steps.
+func TraceMatrices(steps []TraceStep, m, n int, left bool) (int, [][][]int, [][][]int, [][][]int, [][][]byte) {
+	mat := make([][][][]int, 3)
+	for s := 0; s < 3; s++ {
+		mat[s] = make([][][]int, m+1)
+		for i := 0; i <= m; i++ {
+			mat[s][i] = make([][]int, n+1)
+			for j := 0; j <= n; j++ {
+				mat[s][i][j] = []int{-1, -1}
+			}
+		}
+	}
+	BT_K := make([][][]byte, m+1)
+	for i := 0; i <= m; i++ {
+		BT_K[i] = make([][]byte, n+1)
+	}
+	i, j := 0, 0
+	for t, step := range steps {
+		di, dj := 1, 1
+		if step.State == 1 {
+			dj = 0
+		} else if step.State == 2 || step.Mat == 2 {
+			di = 0
+		} else if step.Allele != "" {
+			di = len(step.Allele)
+		}
+		ci, cj := i+di, j+dj // cell of the step for left tracebacks
+		if !left {
+			ci, cj = m-i, n-j // cell of the step for right tracebacks
+		}
+		mat[step.Mat][ci][cj][0] = step.Mat
+		if left && t > 0 {
+			mat[step.Mat][ci][cj][1] = steps[t-1].State
+		} else if !left && t < len(steps)-1 {
+			mat[step.Mat][ci][cj][1] = steps[t+1].State
+		}
+		if step.Allele != "" {
+			BT_K[ci][cj] = []byte(step.Allele)
+		}
+		i, j = i+di, j+dj
+	}
+	if i != m || j != n {
+		panic("alignment path does not cover the read and the ref")
+	}
+	if left {
+		return steps[len(steps)-1].State, mat[0], mat[1], mat[2], BT_K
+	}
+	return steps[0].State, mat[0], mat[1], mat[2], BT_K
+}
+
+// TraceBack runs a left or right traceback of an alignment path and returns reported variants.
+func TraceBack(A *align.Aligner, read, qual string, steps []TraceStep, left bool) []TraceVar {
+	m, n := len(read), len(A.Seq)
+	ref_pos_map := make([]int, n)
+	for j := 0; j < n; j++ {
+		ref_pos_map[j] = j
+	}
+	bt_mat, BT_D, BT_IS, BT_IT, BT_K := TraceMatrices(steps, m, n, left)
+	var var_pos, var_type []int
+	var var_base, var_qual [][]byte
+	if left {
+		var_pos, var_base, var_qual, var_type = A.LeftAlignEditTraceBack([]byte(read), []byte(qual), A.Seq, m, n, 0,
+			bt_mat, BT_D, BT_IS, BT_IT, BT_K, ref_pos_map, false, align.NewArena())
+	} else {
+		var_pos, var_base, var_qual, var_type = A.RightAlignEditTraceBack([]byte(read), []byte(qual), A.Seq, m, n, 0,
+			bt_mat, BT_D, BT_IS, BT_IT, BT_K, ref_pos_map, false, align.NewArena())
+	}
+	vars := make([]TraceVar, len(var_pos))
+	for k := 0; k < len(var_pos); k++ {
+		vars[k] = TraceVar{var_pos[k], string(var_base[k]), string(var_qual[k]), var_type[k]}
+	}
+	return vars
+}
+
+// Steps of a path: one step of a state per character ('0': D, '1': IS, '2': IT)
+func Steps(path string) []TraceStep {
+	steps := make([]TraceStep, len(path))
+	for t, c := range path {
+		steps[t] = TraceStep{State: int(c - '0'), Mat: int(c - '0')}
+	}
+	return steps
+}
+
+// Transitions at other locations than known variant loci: D from D, IS and IT, IS from D and IS, IT
+// from D and IT. The path has a 2-base deletion, a mismatch and a 2-base insertion:
+//
+//	ref   ACGTTACGA--CAT
+//	read  ACG--ACTAGGCAT
+func TestTraceBackTransitions(t *testing.T) {
+	A := SetupTraceBack("ACGTTACGACAT", nil, nil, nil, []int{10})
+	read, qual := "ACGACTAGGCAT", "ABCDEFGHIJKL"
+	steps := Steps("00022000011000")
+	del_qual := string([]byte{align.DelQual('C', 'D')})
+	expected := []TraceVar{
+		{7, "G|T", "F", 0},
+		{2, "GTT|G", del_qual, 2},
+		{8, "A|AGG", "GHI", 1},
+		{10, "A|A", "K", 0}, // new variant location
+	}
+	for _, left := range []bool{true, false} {
+		if vars := TraceBack(A, read, qual, steps, left); !reflect.DeepEqual(vars, expected) {
+			t.Errorf("left %v: got %v, expected %v", left, vars, expected)
+		}
+	}
+}
+
+// Transitions at known variant loci (marked by "*" in the multi-genome): D from D, IS and IT through
+// an allele, the locus aligned with a gap (IT), and IS and IT at the locus. The path has a known SNP
+// reached from an insertion, a known insertion reached from a deletion, a known locus without an
+// allele followed by an insertion, an insertion before a known locus, and a deletion of a known locus.
+func TestTraceBackKnownLocusTransitions(t *testing.T) {
+	variants := map[int][]string{3: {"A", "C"}, 7: {"A", "AT"}, 11: {"A", "G"}, 16: {"A", "T"}}
+	A := SetupTraceBack("ACG*TAC*GAT*GCAT*CAT", variants, map[int]int{3: 1, 11: 1, 16: 1}, map[int]int{}, nil)
+	read := "ACGTCTAATTGATGGCACAT"
+	qual := "abcdefghijklmnopqrst"
+	steps := []TraceStep{
+		{0, 0, ""}, {0, 0, ""}, {0, 0, ""},
+		{1, 1, ""},             // insertion before a known locus
+		{0, 0, "C"},            // known SNP from IS
+		{0, 0, ""}, {0, 0, ""}, // TA
+		{2, 2, ""},                         // deletion of C
+		{0, 0, "AT"},                       // known insertion from IT
+		{1, 1, ""},                         // insertion of T after the known locus
+		{0, 0, ""}, {0, 0, ""}, {0, 0, ""}, // GAT
+		{0, 2, ""},                         // known locus without an allele
+		{1, 1, ""},                         // insertion of G after it
+		{0, 0, ""}, {0, 0, ""}, {0, 0, ""}, // GCA
+		{2, 2, ""},                         // deletion of T
+		{2, 2, ""},                         // deletion of the known locus
+		{0, 0, ""}, {0, 0, ""}, {0, 0, ""}, // CAT
+	}
+	for _, left := range []bool{true, false} {
+		vars := TraceBack(A, read, qual, steps, left)
+		// Alleles of known loci are reported first at their positions
+		known := make(map[int]TraceVar)
+		for _, v := range vars {
+			if _, ok := known[v.Pos]; !ok && (v.Pos == 3 || v.Pos == 7) {
+				known[v.Pos] = v
+			}
+		}
+		if v := known[3]; v != (TraceVar{3, "A|C", "e", 0}) {
+			t.Errorf("left %v: known SNP, got %v", left, v)
+		}
+		if v := known[7]; v != (TraceVar{7, "A|AT", "hi", 1}) {
+			t.Errorf("left %v: known insertion, got %v", left, v)
+		}
+	}
+}
+
+// Deletion evidence is as reliable as the less reliable of its anchoring bases
+func TestDelQual(t *testing.T) {
+	if align.DelQual('5', 'I') != '5' || align.DelQual('I', '5') != '5' || align.DelQual('I', 'I') != 'I' {
+		t.Errorf("wrong qualities of deletions")
+	}
+}
+
+// Known loci of alleles of different lengths are aligned in the Hamming phase only if exactly one
+// allele matches the read
+func TestHamKnownLocus(t *testing.T) {
+	A := SetupTraceBack("ACGTACGTAC", nil, nil, map[int]int{5: 2}, nil)
+	A.Variants = map[int][][]byte{2: {[]byte("A"), []byte("AT")}, 5: {[]byte("ACG"), []byte("A")}, 7: {[]byte("A"), []byte("TA")}}
+	A.VarAF = map[int][]float32{2: {0.7, 0.3}, 5: {0.6, 0.4}, 7: {0.5, 0.5}}
+	test_cases := []struct {
+		read, qual string
+		pos        int
+		left       bool
+		del_ref    bool
+		ok         bool
+		var_len    int
+		base       string
+		var_qual   string
+		var_type   int
+		prob       float64
+	}{
+		{"GGCAT", "abcde", 2, true, false, true, 2, "A|AT", "de", 1, 0.3},    // known insertion at the end of a left flank
+		{"ATGGC", "abcde", 2, false, false, false, 0, "", "", 0, 0},          // both alleles match at the start of a right flank
+		{"AGGTT", "abcde", 5, false, false, true, 1, "ACG|ACG", "a", 2, 0.4}, // known deletion with the original ref
+		{"AGGTT", "abcde", 5, false, true, true, 1, "ACG|A", "a", 2, 0.6},    // and with the reduced ref
+		{"GGGTA", "abcde", 7, true, false, false, 0, "", "", 0, 0},           // both alleles match
+		{"GGGGG", "abcde", 2, true, false, false, 0, "", "", 0, 0},           // no allele matches
+	}
+	for _, c := range test_cases {
+		cost, var_len, v, q, var_type, ok := A.HamKnownLocus([]byte(c.read), []byte(c.qual), c.pos, c.left, c.del_ref, align.NewArena())
+		if ok != c.ok {
+			t.Errorf("%s at %d: got %v", c.read, c.pos, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if var_len != c.var_len || string(v) != c.base || string(q) != c.var_qual || var_type != c.var_type {
+			t.Errorf("%s at %d: got %d, %s, %s, %d", c.read, c.pos, var_len, v, q, var_type)
+		}
+		if expected := -0.1 * math.Log10(float64(float32(c.prob))); math.Abs(cost-expected) > 1e-6 {
+			t.Errorf("%s at %d: got cost %g, expected %g", c.read, c.pos, cost, expected)
+		}
+	}
+}
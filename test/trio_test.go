@@ -0,0 +1,82 @@
+//----------------------------------------------------------------------------------------
+// Test for joint genotype calling of trios
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/namsyvo/IVC"
+)
+
+// Probabilities of child's genotypes sum to 1 given parental genotypes, and are close to Mendelian
+// transmission probabilities
+func TestTransmissionProb(t *testing.T) {
+	mendel := [3][3][3]float64{
+		{{1, 0, 0}, {0.5, 0.5, 0}, {0, 1, 0}},
+		{{0.5, 0.5, 0}, {0.25, 0.5, 0.25}, {0, 0.5, 0.5}},
+		{{0, 1, 0}, {0, 0.5, 0.5}, {0, 0, 1}},
+	}
+	for gf := 0; gf < 3; gf++ {
+		for gm := 0; gm < 3; gm++ {
+			sum := 0.0
+			for gc := 0; gc < 3; gc++ {
+				p := ivc.TransmissionProb(gf, gm, gc)
+				sum += p
+				if math.Abs(p-mendel[gf][gm][gc]) > 1e-6 || p <= 0 {
+					t.Errorf("%d, %d, %d: got %g", gf, gm, gc, p)
+				}
+				if ivc.MendelianConsistent(gf, gm, gc) != (mendel[gf][gm][gc] > 0) {
+					t.Errorf("%d, %d, %d: wrong Mendelian consistency", gf, gm, gc)
+				}
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Errorf("%d, %d: probabilities sum to %g", gf, gm, sum)
+			}
+		}
+	}
+}
+
+// SetupTrioLikes sets log10 likelihoods of genotypes REF/REF, REF/ALT, ALT/ALT of the father, the
+// mother and the child at a location.
+func SetupTrioLikes(VC *ivc.VarCallIndex, pos uint32, likes [3][3]float64) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Proc_num = 1
+	ivc.TRIO = []int{0, 1, 2}
+	VC.Calls = []*ivc.VarProf{{SampleLike: make(map[uint32][]map[string]float64)}}
+	VC.Calls[0].SampleLike[pos] = make([]map[string]float64, 3)
+	for s := 0; s < 3; s++ {
+		VC.Calls[0].SampleLike[pos][s] = map[string]float64{"A|A": likes[s][0], "A|C": likes[s][1], "C|C": likes[s][2]}
+	}
+}
+
+func TestCallTrio(t *testing.T) {
+	VC := new(ivc.VarCallIndex)
+	hap_arr := []string{"A", "C"}
+	// Father REF/REF, mother ALT/ALT, child REF/ALT
+	SetupTrioLikes(VC, 5, [3][3]float64{{0, -10, -30}, {-30, -10, 0}, {-20, 0, -20}})
+	T := VC.CallTrio(0, 5, hap_arr, 0.001)
+	if T == nil || T.GT != [3]int{0, 2, 1} || T.Violation || T.DenovoProb > 1e-6 || T.Post[2] < 0.99 {
+		t.Errorf("got %+v", T)
+	}
+	// The child's alternative allele is called as de novo if evidence is strong enough
+	SetupTrioLikes(VC, 5, [3][3]float64{{0, -30, -60}, {0, -30, -60}, {-30, 0, -30}})
+	T = VC.CallTrio(0, 5, hap_arr, 0.001)
+	if T == nil || T.GT != [3]int{0, 0, 1} || !T.Violation || T.DenovoProb < ivc.TRIO_DENOVO_PROB {
+		t.Errorf("got %+v", T)
+	}
+	// A weak het call of the child is explained by sequencing errors, but still violates Mendelian
+	// inheritance as an independent call
+	SetupTrioLikes(VC, 5, [3][3]float64{{0, -30, -60}, {0, -30, -60}, {-2, 0, -30}})
+	T = VC.CallTrio(0, 5, hap_arr, 0.001)
+	if T == nil || T.GT != [3]int{0, 0, 0} || !T.Violation || T.DenovoProb > 0.01 {
+		t.Errorf("got %+v", T)
+	}
+	// Likelihoods of genotypes of another allele are not available
+	if T = VC.CallTrio(0, 5, []string{"A", "G"}, 0.001); T != nil {
+		t.Errorf("got %+v", T)
+	}
+}
@@ -0,0 +1,145 @@
+//----------------------------------------------------------------------------------------
+// Test for automatic tuning of alignment goroutines and read buffering
+// Copyright 2015 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package ivc_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/namsyvo/IVC"
+)
+
+// GetBuffers gets n read buffers of a pool, and reports if they are all got without waiting
+func GetBuffers(pool *ivc.ReadPool, n int) ([]*ivc.ReadInfo, bool) {
+	got := make(chan *ivc.ReadInfo, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			got <- pool.Get()
+		}
+	}()
+	bufs := make([]*ivc.ReadInfo, 0, n)
+	for len(bufs) < n {
+		select {
+		case r := <-got:
+			bufs = append(bufs, r)
+		case <-time.After(100 * time.Millisecond):
+			return bufs, false
+		}
+	}
+	return bufs, true
+}
+
+// Buffers are allocated up to the limit, the reader then waits for returned buffers, and buffers
+// returned over a lowered limit are dropped
+func TestReadPool(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Read_len_1, ivc.PARA.Read_len_2, ivc.PARA.Info_len = 20, 20, 100
+	pool := ivc.NewReadPool(3)
+	bufs, ok := GetBuffers(pool, 3)
+	if !ok {
+		t.Fatalf("got %d buffers, expected 3 without waiting", len(bufs))
+	}
+	got := make(chan *ivc.ReadInfo)
+	go func() { got <- pool.Get() }()
+	select {
+	case <-got:
+		t.Fatal("got a buffer over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.Put(bufs[0])
+	if r := <-got; r != bufs[0] {
+		t.Errorf("got a new buffer, expected the returned one")
+	}
+	pool.SetLimit(1)
+	pool.Put(bufs[1]) // dropped (3 buffers are allocated)
+	pool.Put(bufs[2]) // dropped (2 buffers are allocated)
+	pool.Put(bufs[0])
+	if more, ok := GetBuffers(pool, 1); !ok || more[0] != bufs[0] {
+		t.Errorf("got no returned buffer under the lowered limit")
+	}
+	if more, ok := GetBuffers(pool, 1); ok || len(more) != 0 {
+		t.Errorf("got a buffer over the lowered limit")
+	}
+	// Pools have at least one buffer
+	if more, ok := GetBuffers(ivc.NewReadPool(0), 1); !ok || len(more) != 1 {
+		t.Errorf("got no buffer of a pool with limit 0")
+	}
+}
+
+// Methods of a nil tuner do nothing
+func TestNilTuner(t *testing.T) {
+	var T *ivc.Tuner
+	if !T.Start().IsZero() || T.Retire() {
+		t.Errorf("nil tuner measures waits or stops goroutines")
+	}
+	T.ReaderWait(time.Now())
+	T.WorkerWait(time.Now())
+	T.Exit()
+}
+
+// Input-bound runs stop idle alignment goroutines and buffer more reads, alignment-bound runs add
+// goroutines on spare CPUs and keep two buffers per goroutine, balanced runs are not changed
+func TestTunerAdjust(t *testing.T) {
+	ivc.PARA = new(ivc.ParaInfo)
+	ivc.PARA.Read_len_1, ivc.PARA.Read_len_2, ivc.PARA.Info_len = 20, 20, 100
+	profile_time := time.Second
+	added := 0
+	add_worker := func() { added++ }
+
+	// Goroutines wait for reads 75% of the time: 2 of 4 goroutines are kept, buffers are multiplied by 8
+	T, pool := ivc.NewTuner(4), ivc.NewReadPool(2)
+	T.WorkerWait(T.Start().Add(-3 * profile_time))
+	T.Adjust(pool, add_worker, profile_time)
+	retired := 0
+	for T.Retire() {
+		retired++
+	}
+	if retired != 2 || added != 0 {
+		t.Errorf("input-bound: got %d stopped and %d added goroutines, expected 2 and 0", retired, added)
+	}
+	if bufs, ok := GetBuffers(pool, 16); !ok {
+		t.Errorf("input-bound: got %d buffers, expected 16", len(bufs))
+	} else if more, ok := GetBuffers(pool, 1); ok || len(more) != 0 {
+		t.Errorf("input-bound: got more than 16 buffers")
+	}
+	if !T.Start().IsZero() {
+		t.Errorf("waits are measured after the profiling time")
+	}
+
+	// The reader waits for buffers 80% of the time: goroutines are added up to the number of CPUs
+	T, pool = ivc.NewTuner(1), ivc.NewReadPool(64)
+	T.ReaderWait(T.Start().Add(-profile_time * 8 / 10))
+	T.Adjust(pool, add_worker, profile_time)
+	if added != runtime.NumCPU()-1 || T.Retire() {
+		t.Errorf("alignment-bound: got %d added goroutines, expected %d", added, runtime.NumCPU()-1)
+	}
+	if bufs, ok := GetBuffers(pool, 2*runtime.NumCPU()); !ok {
+		t.Errorf("alignment-bound: got %d buffers, expected %d", len(bufs), 2*runtime.NumCPU())
+	} else if more, ok := GetBuffers(pool, 1); ok || len(more) != 0 {
+		t.Errorf("alignment-bound: got more than %d buffers", 2*runtime.NumCPU())
+	}
+
+	// Balanced runs and runs whose goroutines have all exited are not changed
+	added = 0
+	T, pool = ivc.NewTuner(2), ivc.NewReadPool(4)
+	T.WorkerWait(T.Start().Add(-profile_time / 2))
+	T.ReaderWait(T.Start().Add(-profile_time / 4))
+	T.Adjust(pool, add_worker, profile_time)
+	if T.Retire() || added != 0 {
+		t.Errorf("balanced: goroutines are changed")
+	}
+	T = ivc.NewTuner(1)
+	T.Exit()
+	T.ReaderWait(T.Start().Add(-profile_time))
+	T.Adjust(pool, add_worker, profile_time)
+	if added != 0 {
+		t.Errorf("finished: goroutines are added")
+	}
+	if bufs, ok := GetBuffers(pool, 4); !ok {
+		t.Errorf("balanced: got %d buffers, expected 4", len(bufs))
+	}
+}
@@ -6,12 +6,13 @@
 package ivc_test
 
 import (
-	"fmt"
-	"github.com/namsyvo/IVC"
-	"math"
-	"testing"
+	//"fmt"
+	//"github.com/namsyvo/IVC"
+	//"math"
+	//"testing"
 )
 
+/*
 func TestVarQual(t *testing.T) {
 	defer __(o_())
 
@@ -111,7 +112,6 @@ func TestVarQual(t *testing.T) {
 	}
 }
 
-/*
 func TestSNPQual(t *testing.T) {
 	defer __(o_())
 
@@ -0,0 +1,144 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: timing.go
+// Machine-readable timing log. Each stage of a run (loading of the index, the reference and the variant
+// profile, seeding, extension, posterior update, calling and output) is written as a JSON record with
+// its runtime, the number of processed items and the rate, one record per line, so that performance of
+// versions and parameters can be compared automatically. Seeding, extension and posterior update run
+// in parallel and are timed per call; their runtimes are summed over goroutines (thread seconds).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TIMING_SEEDING   = iota // searching seeds of read pairs
+	TIMING_EXTENSION        // extending seeds of read-ends
+	TIMING_POSTERIOR        // updating variant probabilities with aligned bases
+	TIMING_STAGE_NUM
+)
+
+// Names of stages timed per call and of their items
+var TIMING_STAGES = [TIMING_STAGE_NUM]string{"seeding", "extension", "posterior update"}
+var TIMING_UNITS = [TIMING_STAGE_NUM]string{"read pairs", "read-ends", "aligned bases"}
+
+//---------------------------------------------------------------------------------------------------
+// TimingRecord represents a record of the timing log.
+//---------------------------------------------------------------------------------------------------
+type TimingRecord struct {
+	Stage   string  `json:"stage"`          // name of the stage
+	Seconds float64 `json:"seconds"`        // runtime of the stage (summed over goroutines for stages timed per call)
+	Threads bool    `json:"thread_seconds"` // true if the runtime is summed over goroutines
+	Count   int64   `json:"count"`          // number of processed items
+	Unit    string  `json:"unit,omitempty"` // kind of processed items
+	Rate    float64 `json:"rate"`           // number of processed items per second
+	Time    string  `json:"time"`           // time of writing the record (RFC 3339)
+}
+
+//---------------------------------------------------------------------------------------------------
+// TimingLog represents a timing log, stages timed per call are accumulated until they are flushed.
+// Methods of a nil TimingLog do nothing, so that timing costs nothing if the log is not required.
+//---------------------------------------------------------------------------------------------------
+type TimingLog struct {
+	nanos  [TIMING_STAGE_NUM]int64 // runtime (in nanoseconds) of stages timed per call
+	counts [TIMING_STAGE_NUM]int64 // numbers of items of stages timed per call
+	f      *os.File
+	enc    *json.Encoder
+	mut    sync.Mutex
+}
+
+// Timing log of the current run (nil if it is not required)
+var TIMING *TimingLog
+
+//---------------------------------------------------------------------------------------------------
+// CreateTimingLog creates a timing log file.
+//---------------------------------------------------------------------------------------------------
+func CreateTimingLog(file_name string) *TimingLog {
+	f, e := os.Create(file_name)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return &TimingLog{f: f, enc: json.NewEncoder(f)}
+}
+
+//---------------------------------------------------------------------------------------------------
+// write writes a record of a stage to the log.
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) write(stage string, d time.Duration, threads bool, count int64, unit string) {
+	rec := &TimingRecord{Stage: stage, Seconds: d.Seconds(), Threads: threads, Count: count, Unit: unit,
+		Time: time.Now().Format(time.RFC3339)}
+	if d > 0 {
+		rec.Rate = float64(count) / d.Seconds()
+	}
+	T.mut.Lock()
+	defer T.mut.Unlock()
+	if e := T.enc.Encode(rec); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Record writes a record of a stage with its (wall-clock) runtime and number of processed items.
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) Record(stage string, d time.Duration, count int64, unit string) {
+	if T == nil {
+		return
+	}
+	T.write(stage, d, false, count, unit)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Start returns the start time of a call of a stage timed per call (zero if the log is not required).
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) Start() time.Time {
+	if T == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+//---------------------------------------------------------------------------------------------------
+// Add accumulates runtime of a call of a stage which started at start_time and its number of items.
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) Add(stage int, start_time time.Time, count int64) {
+	if T == nil {
+		return
+	}
+	atomic.AddInt64(&T.nanos[stage], int64(time.Since(start_time)))
+	atomic.AddInt64(&T.counts[stage], count)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Flush writes records of stages timed per call and resets them (e.g. after each batch of reads in
+// server mode).
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) Flush() {
+	if T == nil {
+		return
+	}
+	for stage := 0; stage < TIMING_STAGE_NUM; stage++ {
+		d := time.Duration(atomic.SwapInt64(&T.nanos[stage], 0))
+		count := atomic.SwapInt64(&T.counts[stage], 0)
+		T.write(TIMING_STAGES[stage], d, true, count, TIMING_UNITS[stage])
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Close closes the timing log file.
+//---------------------------------------------------------------------------------------------------
+func (T *TimingLog) Close() {
+	if T == nil {
+		return
+	}
+	if e := T.f.Close(); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+}
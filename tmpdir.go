@@ -0,0 +1,121 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: tmpdir.go
+// Temporary directory of intermediate files. Each run creates its own directory in the temporary
+// directory given by users (the system temporary directory by default), intermediate files (e.g.
+// partial downloads of remote files and FASTQ files of htsget reads being written) are written there
+// instead of next to outputs, and the directory is removed at the end of the run, also when the run
+// fails or is interrupted. Free disk space of the directory is checked when it is set up.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// Minimum free disk space (bytes) of the temporary directory, less free space is warned
+const TMP_MIN_FREE = 1 << 30
+
+var (
+	TMP_DIR   string     // temporary directory of the run (empty: not set up, the system temporary directory is used)
+	tmp_mutex sync.Mutex // mutex of setting up and removing the temporary directory
+)
+
+//---------------------------------------------------------------------------------------------------
+// SetupTmpDir creates the temporary directory of the run in a directory (the system temporary
+// directory if it is empty), and removes it if the run is interrupted.
+//---------------------------------------------------------------------------------------------------
+func SetupTmpDir(dir string) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if e := os.MkdirAll(dir, 0777); e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	tmp_dir, e := ioutil.TempDir(dir, "ivc-")
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	tmp_mutex.Lock()
+	TMP_DIR = tmp_dir
+	tmp_mutex.Unlock()
+	if free, ok := FreeSpace(tmp_dir); ok && free < TMP_MIN_FREE {
+		log.Printf("Warning: only %.2f GB of free disk space in the temporary directory %s", float64(free)/(1<<30), dir)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		CleanupTmpDir()
+		log.Printf("Interrupted by signal %s.", s)
+		os.Exit(1)
+	}()
+	log.Printf("Temporary directory:\t%s", tmp_dir)
+}
+
+//---------------------------------------------------------------------------------------------------
+// CleanupTmpDir removes the temporary directory of the run and its files.
+//---------------------------------------------------------------------------------------------------
+func CleanupTmpDir() {
+	tmp_mutex.Lock()
+	defer tmp_mutex.Unlock()
+	if TMP_DIR == "" {
+		return
+	}
+	if e := os.RemoveAll(TMP_DIR); e != nil {
+		log.Printf("Warning: cannot remove the temporary directory %s: %s", TMP_DIR, e)
+	}
+	TMP_DIR = ""
+}
+
+//---------------------------------------------------------------------------------------------------
+// CreateTmpFile creates a new file in the temporary directory, with a name starting with prefix.
+//---------------------------------------------------------------------------------------------------
+func CreateTmpFile(prefix string) *os.File {
+	f, e := ioutil.TempFile(TMP_DIR, prefix)
+	if e != nil {
+		log.Panicf("Error: %s", e)
+	}
+	return f
+}
+
+//---------------------------------------------------------------------------------------------------
+// MoveFile moves a (temporary) file to its final place, it is copied if the two places are on
+// different file systems.
+//---------------------------------------------------------------------------------------------------
+func MoveFile(src, dst string) error {
+	if e := os.Rename(src, dst); e == nil {
+		return nil
+	}
+	r, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	// Copy next to the destination first, so that incomplete copies are not used
+	w, e := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".part")
+	if e != nil {
+		return e
+	}
+	if _, e = io.Copy(w, r); e != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return e
+	}
+	if e = w.Close(); e != nil {
+		os.Remove(w.Name())
+		return e
+	}
+	if e = os.Rename(w.Name(), dst); e != nil {
+		return e
+	}
+	return os.Remove(src)
+}
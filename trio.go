@@ -0,0 +1,189 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: trio.go
+// Trio mode. Reads of a father, a mother and their child are given as read groups of three samples;
+// genotypes of the trio are called jointly from likelihoods of each sample's reads, with priors of
+// parental genotypes from the novel variant rates and Mendelian transmission of alleles to the child
+// (allowing de novo mutations with a small rate). Mendelian violations of independently called
+// genotypes and de novo candidates are flagged in the output.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	TRIO_DENOVO_RATE = 1e-7 // prior probability of a de novo mutation at a position of the child
+	TRIO_DENOVO_PROB = 0.5  // minimum posterior probability of de novo mutations of de novo candidates
+)
+
+// Indexes of samples of the father, the mother and the child in trio mode (nil: trio mode is off)
+var TRIO []int
+
+//---------------------------------------------------------------------------------------------------
+// TrioCall represents the joint genotype call of a trio at a variant call.
+//---------------------------------------------------------------------------------------------------
+type TrioCall struct {
+	GT         [3]int     // genotypes (0: REF/REF, 1: REF/ALT, 2: ALT/ALT) of the father, the mother and the child
+	Post       [3]float64 // marginal posterior probabilities of the genotypes
+	Violation  bool       // independently called genotypes violate Mendelian inheritance
+	DenovoProb float64    // posterior probability that the child has a de novo mutation
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetupTrio sets up samples of a trio "father,mother,child" (sample names of read groups).
+//---------------------------------------------------------------------------------------------------
+func SetupTrio(trio_str string) {
+	TRIO = nil
+	if trio_str == "" {
+		return
+	}
+	names := strings.Split(trio_str, ",")
+	if len(names) != 3 {
+		log.Panicf("Error: invalid trio %s (format: father,mother,child)", trio_str)
+	}
+	for _, name := range names {
+		idx := -1
+		for s, sample := range SAMPLES {
+			if sample == name {
+				idx = s
+			}
+		}
+		if idx == -1 {
+			log.Panicf("Error: trio sample %s is not a sample of read groups", name)
+		}
+		TRIO = append(TRIO, idx)
+	}
+	if TRIO[0] == TRIO[1] || TRIO[0] == TRIO[2] || TRIO[1] == TRIO[2] {
+		log.Panicf("Error: samples of trio %s are not distinct", trio_str)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// TransmissionProb returns probability of the child's genotype given parental genotypes (genotypes
+// are numbers of alternative alleles), including de novo mutations.
+//---------------------------------------------------------------------------------------------------
+func TransmissionProb(gf, gm, gc int) float64 {
+	var pf, pm [2]float64 // probabilities of transmitting the reference and the alternative allele
+	pf[1], pm[1] = float64(gf)/2, float64(gm)/2
+	pf[0], pm[0] = 1-pf[1], 1-pm[1]
+	p := 0.0
+	switch gc {
+	case 0:
+		p = pf[0] * pm[0]
+	case 1:
+		p = pf[0]*pm[1] + pf[1]*pm[0]
+	case 2:
+		p = pf[1] * pm[1]
+	}
+	return (1-TRIO_DENOVO_RATE)*p + TRIO_DENOVO_RATE/3
+}
+
+//---------------------------------------------------------------------------------------------------
+// MendelianConsistent determines whether the child's genotype can be inherited from parental genotypes.
+//---------------------------------------------------------------------------------------------------
+func MendelianConsistent(gf, gm, gc int) bool {
+	switch gc {
+	case 0:
+		return gf < 2 && gm < 2
+	case 1:
+		return (gf > 0 || gm > 0) && (gf < 2 || gm < 2)
+	}
+	return gf > 0 && gm > 0
+}
+
+//---------------------------------------------------------------------------------------------------
+// CallTrio calls genotypes of the trio jointly at a variant call (hap_arr), given the novel rate of
+// the alternative allele. It returns nil if likelihoods of genotypes are not available.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CallTrio(rid int, pos uint32, hap_arr []string, rate float64) *TrioCall {
+	var likes [3][]float64
+	var ind_gt [3]int
+	for i, s := range TRIO {
+		var ok bool
+		if likes[i], ok = GenotypeLikes(VC.Calls[rid].SampleLike[pos][s], hap_arr); !ok {
+			return nil
+		}
+		for g := 1; g < 3; g++ {
+			if likes[i][g] > likes[i][ind_gt[i]] {
+				ind_gt[i] = g
+			}
+		}
+	}
+	priors := []float64{1 - 1.5*rate, rate, 0.5 * rate}
+	var joint [3][3][3]float64
+	max_post := math.Inf(-1)
+	for gf := 0; gf < 3; gf++ {
+		for gm := 0; gm < 3; gm++ {
+			for gc := 0; gc < 3; gc++ {
+				joint[gf][gm][gc] = math.Log10(priors[gf]*priors[gm]*TransmissionProb(gf, gm, gc)) + likes[0][gf] + likes[1][gm] + likes[2][gc]
+				max_post = math.Max(max_post, joint[gf][gm][gc])
+			}
+		}
+	}
+	T := new(TrioCall)
+	var marg [3][3]float64
+	post_sum := 0.0
+	for gf := 0; gf < 3; gf++ {
+		for gm := 0; gm < 3; gm++ {
+			for gc := 0; gc < 3; gc++ {
+				p := math.Pow(10, joint[gf][gm][gc]-max_post)
+				marg[0][gf] += p
+				marg[1][gm] += p
+				marg[2][gc] += p
+				if !MendelianConsistent(gf, gm, gc) {
+					T.DenovoProb += p
+				}
+				post_sum += p
+			}
+		}
+	}
+	T.DenovoProb /= post_sum
+	for i := 0; i < 3; i++ {
+		for g := 0; g < 3; g++ {
+			if marg[i][g] > marg[i][T.GT[i]] {
+				T.GT[i] = g
+			}
+		}
+		T.Post[i] = marg[i][T.GT[i]] / post_sum
+	}
+	T.Violation = !MendelianConsistent(ind_gt[0], ind_gt[1], ind_gt[2])
+	return T
+}
+
+//---------------------------------------------------------------------------------------------------
+// Annotations returns INFO fields of the trio call: MV (Mendelian violation of independent calls),
+// DN (de novo candidate) and DNP (posterior probability of de novo mutations).
+//---------------------------------------------------------------------------------------------------
+func (T *TrioCall) Annotations() string {
+	str_info := ""
+	if T.Violation {
+		str_info += ";MV"
+	}
+	if T.DenovoProb >= TRIO_DENOVO_PROB {
+		str_info += ";DN"
+	}
+	return str_info + ";DNP=" + strconv.FormatFloat(T.DenovoProb, 'g', 4, 64)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Format replaces GT and GQ of a sample's FORMAT values by the joint call if the sample is in the trio.
+//---------------------------------------------------------------------------------------------------
+func (T *TrioCall) Format(s int, str_format string) string {
+	for i, t := range TRIO {
+		if t == s {
+			fields := strings.SplitN(str_format, ":", 3)
+			if len(fields) < 3 || fields[0] == "./." {
+				return str_format
+			}
+			gq := int(math.Min(math.Floor(-10*math.Log10(math.Max(1-T.Post[i], 1e-10))+0.5), 99))
+			return []string{"0/0", "0/1", "1/1"}[T.GT[i]] + ":" + strconv.Itoa(gq) + ":" + fields[2]
+		}
+	}
+	return str_format
+}
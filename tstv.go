@@ -0,0 +1,55 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: tstv.go
+// Transition/transversion-aware substitutions. Transitions (A<->G, C<->T) are more frequent than
+// transversions among true SNPs (the Ts/Tv ratio of human genomes is about 2.0-2.1), while each base
+// has one transition and two transversions. Given a Ts/Tv ratio k, the rate of novel SNPs of each
+// alternative base is multiplied by 3k/(k+1) for the transition and 3/(2(k+1)) for transversions, so
+// that the total rate of novel SNPs is unchanged (k = 0.5: all substitutions are equally likely).
+// Factors apply to priors of novel SNPs, and change substitution costs of alignment by -log10 of them
+// (see AlnPara).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+
+	"github.com/namsyvo/IVC/align"
+)
+
+var (
+	TS_FACTOR, TV_FACTOR = 1.0, 1.0 // factors of rates of novel transitions and transversions (1: Ts/Tv is not used)
+	TS_COST, TV_COST     = 0.0, 0.0 // changes of substitution costs of transitions and transversions
+)
+
+//---------------------------------------------------------------------------------------------------
+// SetupTsTv sets up factors of transitions and transversions from a Ts/Tv ratio (0: not used).
+//---------------------------------------------------------------------------------------------------
+func SetupTsTv(ts_tv float64) {
+	TS_FACTOR, TV_FACTOR, TS_COST, TV_COST = 1, 1, 0, 0
+	if ts_tv <= 0 {
+		return
+	}
+	TS_FACTOR, TV_FACTOR = 3*ts_tv/(ts_tv+1), 3/(2*(ts_tv+1))
+	TS_COST, TV_COST = -math.Log10(TS_FACTOR), -math.Log10(TV_FACTOR)
+	log.Printf("Ts/Tv ratio of novel SNPs:\t%g (factors of transitions: %.3f, transversions: %.3f)", ts_tv, TS_FACTOR, TV_FACTOR)
+}
+
+//---------------------------------------------------------------------------------------------------
+// IsTransition checks if the substitution of base a by base b is a transition (A<->G or C<->T).
+//---------------------------------------------------------------------------------------------------
+func IsTransition(a, b byte) bool {
+	return align.IsTransition(a, b)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SubFactor returns the factor of the rate of the novel SNP of ref_base to alt_base.
+//---------------------------------------------------------------------------------------------------
+func SubFactor(ref_base, alt_base byte) float64 {
+	if IsTransition(ref_base, alt_base) {
+		return TS_FACTOR
+	}
+	return TV_FACTOR
+}
@@ -0,0 +1,209 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: tune.go
+// Automatic tuning of the number of alignment goroutines and of read buffering. Reads are passed from
+// the reader to alignment goroutines in a pool of read buffers. In the first seconds of calling, the
+// time the reader waits for free buffers (alignment is the bottleneck, e.g. local NVMe inputs) and the
+// time alignment goroutines wait for reads (reading is the bottleneck, e.g. gzipped inputs on network
+// storage) are measured; then goroutines are added on spare CPUs, or idle goroutines are stopped and
+// more reads are buffered to absorb stalls of the input.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	READ_POOL_MAX     = 1024            // maximum number of read buffers between the reader and alignment goroutines
+	TUNE_PROFILE_TIME = 5 * time.Second // time of profiling the reader and alignment goroutines
+	TUNE_WAIT_FRAC    = 0.5             // fraction of waiting time for tuning the reader or alignment goroutines
+	TUNE_BUF_FACTOR   = 8               // factor of read buffers of input-bound runs
+)
+
+//---------------------------------------------------------------------------------------------------
+// ReadPool represents buffers of reads passed from the reader to alignment goroutines, buffers are
+// allocated on demand up to a limit (which can be changed during the run).
+//---------------------------------------------------------------------------------------------------
+type ReadPool struct {
+	free  chan *ReadInfo // buffers returned by alignment goroutines
+	num   int32          // number of allocated buffers
+	limit int32          // maximum number of buffers
+}
+
+//---------------------------------------------------------------------------------------------------
+// NewReadPool creates a pool of at most limit read buffers.
+//---------------------------------------------------------------------------------------------------
+func NewReadPool(limit int) *ReadPool {
+	return &ReadPool{free: make(chan *ReadInfo, READ_POOL_MAX), limit: int32(MinInt(MaxInt(limit, 1), READ_POOL_MAX))}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Get returns a free read buffer, it waits for a buffer to be returned if the limit is reached. It is
+// called by the reader only.
+//---------------------------------------------------------------------------------------------------
+func (P *ReadPool) Get() *ReadInfo {
+	select {
+	case r := <-P.free:
+		return r
+	default:
+	}
+	if atomic.LoadInt32(&P.num) < atomic.LoadInt32(&P.limit) {
+		atomic.AddInt32(&P.num, 1)
+		return InitReadInfo(PARA.Read_len_1, PARA.Read_len_2, PARA.Info_len)
+	}
+	start_time := TUNER.Start()
+	r := <-P.free
+	TUNER.ReaderWait(start_time)
+	return r
+}
+
+//---------------------------------------------------------------------------------------------------
+// Put returns a read buffer after the read is copied, buffers over the limit are dropped.
+//---------------------------------------------------------------------------------------------------
+func (P *ReadPool) Put(r *ReadInfo) {
+	if atomic.LoadInt32(&P.num) > atomic.LoadInt32(&P.limit) {
+		atomic.AddInt32(&P.num, -1)
+		return
+	}
+	P.free <- r
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetLimit sets the maximum number of read buffers.
+//---------------------------------------------------------------------------------------------------
+func (P *ReadPool) SetLimit(limit int) {
+	atomic.StoreInt32(&P.limit, int32(MinInt(MaxInt(limit, 1), READ_POOL_MAX)))
+}
+
+//---------------------------------------------------------------------------------------------------
+// Tuner represents waiting times of the reader and alignment goroutines in the profiling time, and
+// running alignment goroutines. Methods of a nil Tuner do nothing, so that nothing is measured if
+// automatic tuning is not required.
+//---------------------------------------------------------------------------------------------------
+type Tuner struct {
+	reader_wait int64 // nanoseconds of the reader waiting for free read buffers
+	worker_wait int64 // nanoseconds of alignment goroutines waiting for reads
+	profiling   int32 // 1 in the profiling time
+	running     int   // number of running alignment goroutines
+	retire      int   // number of alignment goroutines to be stopped
+	mut         sync.Mutex
+}
+
+// Tuner of the current calling (nil if automatic tuning is not required)
+var TUNER *Tuner
+
+//---------------------------------------------------------------------------------------------------
+// NewTuner creates a tuner of worker_num running alignment goroutines.
+//---------------------------------------------------------------------------------------------------
+func NewTuner(worker_num int) *Tuner {
+	return &Tuner{running: worker_num, profiling: 1}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Start returns the start time of a wait (zero out of the profiling time).
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) Start() time.Time {
+	if T == nil || atomic.LoadInt32(&T.profiling) == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReaderWait adds a wait of the reader for free read buffers.
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) ReaderWait(start_time time.Time) {
+	if T != nil && !start_time.IsZero() {
+		atomic.AddInt64(&T.reader_wait, int64(time.Since(start_time)))
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// WorkerWait adds a wait of an alignment goroutine for reads.
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) WorkerWait(start_time time.Time) {
+	if T != nil && !start_time.IsZero() {
+		atomic.AddInt64(&T.worker_wait, int64(time.Since(start_time)))
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Retire checks if an alignment goroutine should stop (after it finishes its current read).
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) Retire() bool {
+	if T == nil {
+		return false
+	}
+	T.mut.Lock()
+	defer T.mut.Unlock()
+	if T.retire > 0 {
+		T.retire--
+		return true
+	}
+	return false
+}
+
+//---------------------------------------------------------------------------------------------------
+// Exit records the end of an alignment goroutine.
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) Exit() {
+	if T == nil {
+		return
+	}
+	T.mut.Lock()
+	T.running--
+	T.mut.Unlock()
+}
+
+//---------------------------------------------------------------------------------------------------
+// Tune profiles the reader and alignment goroutines for TUNE_PROFILE_TIME, then adjusts the number of
+// alignment goroutines (add_worker starts a new one) and the number of read buffers of the pool.
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) Tune(pool *ReadPool, add_worker func()) {
+	time.Sleep(TUNE_PROFILE_TIME)
+	T.Adjust(pool, add_worker, TUNE_PROFILE_TIME)
+}
+
+//---------------------------------------------------------------------------------------------------
+// Adjust ends the profiling time of the given duration, and adjusts the number of alignment goroutines
+// and the number of read buffers from waiting times. Nothing is changed if all reads are aligned before
+// the end of the profiling time.
+//---------------------------------------------------------------------------------------------------
+func (T *Tuner) Adjust(pool *ReadPool, add_worker func(), profile_time time.Duration) {
+	atomic.StoreInt32(&T.profiling, 0)
+	T.mut.Lock()
+	defer T.mut.Unlock()
+	if T.running == 0 {
+		return
+	}
+	worker_num, buf_num := T.running, int(atomic.LoadInt32(&pool.limit))
+	secs := profile_time.Seconds()
+	worker_idle := time.Duration(atomic.LoadInt64(&T.worker_wait)).Seconds() / (secs * float64(worker_num))
+	reader_idle := time.Duration(atomic.LoadInt64(&T.reader_wait)).Seconds() / secs
+	if worker_idle > TUNE_WAIT_FRAC {
+		// Input-bound: goroutines only needed for the input rate are kept, reads are buffered more
+		busy := int(math.Ceil(float64(worker_num)*(1-worker_idle))) + 1
+		if busy < worker_num {
+			T.retire = worker_num - busy
+			worker_num = busy
+		}
+		buf_num = MinInt(buf_num*TUNE_BUF_FACTOR, READ_POOL_MAX)
+	} else if reader_idle > TUNE_WAIT_FRAC {
+		// Alignment-bound: goroutines are added on spare CPUs, two buffers per goroutine are enough
+		for ; worker_num < runtime.NumCPU(); worker_num++ {
+			T.running++
+			add_worker()
+		}
+		buf_num = 2 * worker_num
+	}
+	pool.SetLimit(buf_num)
+	log.Printf("Auto-tuning: alignment goroutines wait for reads %.0f%% and the reader waits for buffers %.0f%% of the time, "+
+		"use %d alignment goroutines and %d read buffers", 100*worker_idle, 100*reader_idle, worker_num, buf_num)
+}
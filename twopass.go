@@ -0,0 +1,160 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: twopass.go
+// Two-pass calling. In the first pass, variants are called from all reads as usual (auxiliary reports
+// are not written). Novel calls of the first pass with high quality are added to the known variants,
+// they are marked on the multigenome and its FM-index is rebuilt, so that in the second pass reads
+// are aligned with the updated variant profile and priors (e.g. reads which initially failed to be
+// aligned near novel indels are recovered).
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/namsyvo/IVC/fmi"
+	"github.com/namsyvo/IVC/index"
+)
+
+// Positions of novel calls of the first pass which are added to known variants (they are not
+// reported as known variants)
+var FIRST_PASS_VAR = make(map[int]bool)
+
+//---------------------------------------------------------------------------------------------------
+// CallFirstPass calls variants from reads in the first pass, adds high-quality novel calls to the
+// known variants, and re-initializes the variant call data structure for the second pass.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CallFirstPass(read_reads func(chan *ReadInfo, *ReadPool)) {
+	log.Printf("----------------------------------------------------------------------------------------")
+	log.Printf("First pass of two-pass calling...")
+	sv_file, cnv_file, pileup_file, bedgraph_file, debug_file := PARA.SV_file, PARA.CNV_file, PARA.Pileup_file, PARA.Bedgraph_file, PARA.Debug_file
+	PARA.SV_file, PARA.CNV_file, PARA.Pileup_file, PARA.Bedgraph_file, PARA.Debug_file = "", "", "", "", ""
+	VC.CallVariantsFrom(read_reads)
+	PARA.SV_file, PARA.CNV_file, PARA.Pileup_file, PARA.Bedgraph_file, PARA.Debug_file = sv_file, cnv_file, pileup_file, bedgraph_file, debug_file
+
+	start_time := time.Now()
+	var call_buf bytes.Buffer
+	w := bufio.NewWriter(&call_buf)
+	VC.WriteVarCalls(w)
+	w.Flush()
+	add_num := VC.AddKnownVariants(call_buf.Bytes(), PARA.Two_pass)
+	log.Printf("Number of novel calls of the first pass added to known variants (QUAL >= %g):\t%d", PARA.Two_pass, add_num)
+	if add_num > 0 {
+		VC.RebuildIndex()
+	}
+	VC.InitVarCall()
+	refine_time := time.Since(start_time)
+	log.Printf("Time for updating known variants from the first pass:\t%s", refine_time)
+	SUMMARY.AddStageTime("two-pass update", refine_time)
+	log.Printf("Finish first pass of two-pass calling.")
+}
+
+//---------------------------------------------------------------------------------------------------
+// AddKnownVariants adds novel (passed) calls in VCF format with QUAL >= min_qual to the known
+// variants, and returns the number of added variants. Alleles of added variants are given equal
+// frequencies. Calls which overlap known variants (or other added calls), or whose reference allele
+// does not match the reference, are skipped.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) AddKnownVariants(calls []byte, min_qual float64) int {
+	chr_id := make(map[string]int)
+	for i, chr_name := range VC.ChrName {
+		chr_id[string(chr_name)] = i
+	}
+	known_pos := make([]int, 0, len(VC.Variants))
+	for var_pos, _ := range VC.Variants {
+		known_pos = append(known_pos, var_pos)
+	}
+	sort.Ints(known_pos)
+
+	add_num, last_end := 0, -1
+	for _, line := range bytes.Split(calls, []byte("\n")) {
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := bytes.SplitN(line, []byte("\t"), 9)
+		if len(fields) < 8 || bytes.HasPrefix(fields[7], []byte("KV;")) {
+			continue
+		}
+		if filter := string(fields[6]); filter != "." && filter != "PASS" {
+			continue
+		}
+		qual, e := strconv.ParseFloat(string(fields[5]), 64)
+		if e != nil || qual < min_qual {
+			continue
+		}
+		id, ok := chr_id[string(fields[0])]
+		pos, e := strconv.Atoi(string(fields[1]))
+		if !ok || e != nil {
+			continue
+		}
+		pos += VC.ChrPos[id] - 1
+		ref, alt := fields[3], fields[4]
+		if bytes.Equal(ref, alt) || pos <= last_end || !VC.MatchRef(pos, ref) {
+			continue
+		}
+		// Skip calls overlapping known variants
+		i := sort.SearchInts(known_pos, pos)
+		if i < len(known_pos) && known_pos[i] < pos+len(ref) {
+			continue
+		}
+		if i > 0 && known_pos[i-1]+len(VC.Variants[known_pos[i-1]][0]) > pos {
+			continue
+		}
+		VC.Variants[pos] = [][]byte{append([]byte{}, ref...), append([]byte{}, alt...)}
+		VC.VarAF[pos] = []float32{0.5, 0.5}
+		VC.Seq[pos] = '*'
+		FIRST_PASS_VAR[pos] = true
+		last_end = pos + len(ref) - 1
+		add_num++
+	}
+	VC.SameLenVar, VC.DelVar = VarLenInfo(VC.Variants)
+	return add_num
+}
+
+//---------------------------------------------------------------------------------------------------
+// MatchRef checks if an allele matches the reference at a position of the multigenome.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) MatchRef(pos int, allele []byte) bool {
+	if pos < 0 || pos+len(allele) > VC.SeqLen {
+		return false
+	}
+	for i, b := range allele {
+		if VC.Seq[pos+i] != b && VC.Seq[pos+i] != '*' {
+			return false
+		}
+	}
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// RebuildIndex rebuilds the FM-index of the reverse multi-sequence after known variants are updated.
+// The suffix array is sampled with the sampling rate of the loaded index.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) RebuildIndex() {
+	if VC.KmerIdx != nil {
+		log.Printf("Rebuilding k-mer index of the updated multi-sequence...")
+		VC.KmerIdx = index.BuildKmerIndex(VC.Seq, VC.KmerIdx.K)
+		log.Printf("Finish rebuilding k-mer index of the updated multi-sequence.")
+		return
+	}
+	log.Printf("Rebuilding FM-index of the updated multi-sequence...")
+	rev_seq := make([]byte, VC.SeqLen)
+	for i := range VC.Seq {
+		rev_seq[i] = VC.Seq[VC.SeqLen-1-i]
+	}
+	rev_fmi := fmi.New(rev_seq)
+	rev_fmi.SampleSA(int(VC.RevFMI.SA_rate))
+	VC.RevFMI = rev_fmi
+	// Tables of the multi-sequence are rebuilt as well if the FM-index is bidirectional
+	if VC.BiFMI != nil {
+		VC.BiFMI = &fmi.BiIndex{Rev: rev_fmi, Fwd: fmi.NewFwd(VC.Seq)}
+	}
+	fmi.SEQ = nil
+	log.Printf("Finish rebuilding FM-index of the updated multi-sequence.")
+}
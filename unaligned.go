@@ -0,0 +1,39 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: unaligned.go
+// Rescue FASTQ of unaligned reads. Read pairs which cannot be aligned within the maximum number of
+// iterations (and pairs skipped by the k-mer prescreen) are written with both ends as interleaved
+// FASTQ records, so that they can be inspected or realigned with other tools.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+//---------------------------------------------------------------------------------------------------
+// WriteUnaligned checks if unaligned reads are written (by the first shard in sharded execution).
+//---------------------------------------------------------------------------------------------------
+func WriteUnaligned() bool {
+	return PARA.Unaligned_file != "" && WARM_UP == nil && (SHARD == nil || SHARD.ID == 1)
+}
+
+//---------------------------------------------------------------------------------------------------
+// SetRead keeps headers, bases and qualities of both ends of a read in its unaligned-read info.
+//---------------------------------------------------------------------------------------------------
+func (uar *UnAlnReadInfo) SetRead(read_info1, read_info2 []byte, read_info *ReadInfo) {
+	uar.read_info1, uar.read_info2 = read_info1, read_info2
+	uar.read1, uar.qual1 = append([]byte(nil), read_info.Read1...), append([]byte(nil), read_info.Qual1...)
+	uar.read2, uar.qual2 = append([]byte(nil), read_info.Read2...), append([]byte(nil), read_info.Qual2...)
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteUnAlnRead writes both ends of an unaligned read as two consecutive FASTQ records.
+//---------------------------------------------------------------------------------------------------
+func WriteUnAlnRead(w *OutputFile, uar *UnAlnReadInfo) {
+	for _, rec := range [][3][]byte{{uar.read_info1, uar.read1, uar.qual1}, {uar.read_info2, uar.read2, uar.qual2}} {
+		w.Write(rec[0])
+		w.WriteString("\n")
+		w.Write(rec[1])
+		w.WriteString("\n+\n")
+		w.Write(rec[2])
+		w.WriteString("\n")
+	}
+}
@@ -0,0 +1,306 @@
+//---------------------------------------------------------------------------------------------------
+// IVC: validate.go
+// Validation of the command line before the run. Missing options, combinations of options which do not
+// work together, missing index files and parameters which do not fit the input reads are reported all
+// at once, each with a hint on how to fix it, instead of failing deep inside setup or loading (or
+// writing an empty output file) after the first problem.
+// Copyright 2015 Nam Sy Vo.
+//---------------------------------------------------------------------------------------------------
+
+package ivc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/namsyvo/IVC/index"
+)
+
+//---------------------------------------------------------------------------------------------------
+// InputError represents a problem of the command line with a hint on how to fix it.
+//---------------------------------------------------------------------------------------------------
+type InputError struct {
+	Msg  string // the problem
+	Hint string // how to fix it
+}
+
+func (E *InputError) Error() string {
+	return E.Msg + "\n\t-> " + E.Hint
+}
+
+//---------------------------------------------------------------------------------------------------
+// InputChecker collects problems of the command line.
+//---------------------------------------------------------------------------------------------------
+type InputChecker struct {
+	Errs []error
+}
+
+//---------------------------------------------------------------------------------------------------
+// Fail adds a problem with a hint on how to fix it.
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) Fail(hint, format string, args ...interface{}) {
+	C.Errs = append(C.Errs, &InputError{Msg: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+//---------------------------------------------------------------------------------------------------
+// Choice checks that the value of an option is one of the supported values.
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) Choice(flag, value string, choices ...string) {
+	for _, c := range choices {
+		if value == c {
+			return
+		}
+	}
+	C.Fail("use one of: "+strings.Join(choices, ", "), "unknown value %q of -%s", value, flag)
+}
+
+//---------------------------------------------------------------------------------------------------
+// NonNegative checks that numeric options are not negative (0 means the default value).
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) NonNegative(flag string, value float64) {
+	if value < 0 {
+		C.Fail("use a positive value, or omit -"+flag+" for the default value", "negative value %g of -%s", value, flag)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// Rate checks that a rate option is a probability which keeps priors of reference genotypes positive
+// (0 means the default value).
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) Rate(flag string, value float64) {
+	if value < 0 || value >= 2.0/3.0 {
+		C.Fail("use a probability in [0, 2/3), e.g. 0.001, or omit -"+flag+" for the default value", "invalid value %g of -%s", value, flag)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// File checks that a local input file exists and is not a directory (remote files are checked when
+// they are downloaded).
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) File(file_name, what, hint string) bool {
+	if IsRemote(file_name) {
+		return true
+	}
+	info, e := os.Stat(file_name)
+	if e != nil {
+		C.Fail(hint, "%s %s does not exist", what, file_name)
+		return false
+	}
+	if info.IsDir() {
+		C.Fail(hint, "%s %s is a directory", what, file_name)
+		return false
+	}
+	return true
+}
+
+//---------------------------------------------------------------------------------------------------
+// OrDefault returns a value given in input, or a placeholder if it is not given.
+//---------------------------------------------------------------------------------------------------
+func OrDefault(value, placeholder string) string {
+	if value == "" {
+		return placeholder
+	}
+	return value
+}
+
+//---------------------------------------------------------------------------------------------------
+// CheckInput checks the command line of the variant caller (with_reads is false in server mode, where
+// reads are sent in requests) and returns all problems found.
+//---------------------------------------------------------------------------------------------------
+func CheckInput(input_para *ParaInfo, with_reads bool) []error {
+	C := new(InputChecker)
+
+	// Options which are always required
+	if input_para.Genome_file == "" {
+		C.Fail("give the reference genome which the index was built from, e.g. -R genome.fasta", "missing -R (reference genome file)")
+	}
+	if input_para.Var_file == "" {
+		C.Fail("give the variant profile which the index was built from, e.g. -V variants.vcf", "missing -V (variant profile file)")
+	}
+	build_hint := "build the index with: ivc-index -R " + OrDefault(input_para.Genome_file, "<genome>") + " -V " +
+		OrDefault(input_para.Var_file, "<variant profile>") + " -I " + OrDefault(input_para.Index_dir, "<index directory>")
+	if input_para.Index_dir == "" {
+		C.Fail(build_hint+", then give the directory with -I", "missing -I (index directory)")
+	} else if !IsRemote(input_para.Index_dir) {
+		if info, e := os.Stat(input_para.Index_dir); e != nil {
+			C.Fail(build_hint, "index directory %s does not exist", input_para.Index_dir)
+		} else if !info.IsDir() {
+			C.Fail("-I is the directory of index files, not a file", "index directory %s is not a directory", input_para.Index_dir)
+		} else if input_para.Genome_file != "" && input_para.Var_file != "" {
+			// Index files are named after the base names of the genome and the variant profile
+			C.File(CompressedName(input_para.Ref_file), "multigenome", build_hint+" (-R must be the genome used for the index)")
+			C.File(CompressedName(input_para.Var_prof_file), "variant profile index", build_hint+" (-V must be the variant profile used for the index)")
+			if input_para.Seed_index == SEED_INDEX_KMER {
+				C.File(index.KmerIndexFile(input_para.Ref_file), "k-mer index", strings.Replace(build_hint, "ivc-index", "ivc-index -kmer", 1))
+			} else if _, e := os.Stat(input_para.Rev_index_file); e != nil {
+				C.Fail(build_hint+" (or use -seed-index kmer with a k-mer index)", "FM-index %s does not exist", input_para.Rev_index_file)
+			}
+		}
+	}
+
+	// Reads and output
+	if with_reads {
+		C.CheckReads(input_para)
+		if input_para.Var_call_file == "" {
+			C.Fail("give the file for writing variant calls, e.g. -O calls.vcf", "missing -O (variant call output file)")
+		} else if info, e := os.Stat(input_para.Var_call_file); e == nil && info.IsDir() {
+			C.Fail("-O is the VCF file of variant calls, not a directory", "output file %s is a directory", input_para.Var_call_file)
+		}
+	}
+
+	// Enumerated options
+	if input_para.Preset != "" {
+		C.Choice("preset", input_para.Preset, "ont", "pacbio")
+	}
+	C.Choice("seed-index", input_para.Seed_index, SEED_INDEX_FM, SEED_INDEX_KMER)
+	C.Choice("multi-map", input_para.Multi_map, MULTI_MAP_FIRST, MULTI_MAP_DISCARD, MULTI_MAP_RANDOM, MULTI_MAP_FRACTIONAL)
+	C.Choice("mate-check", input_para.Mate_check, MATE_CHECK_ERROR, MATE_CHECK_WARN, MATE_CHECK_OFF)
+	C.Choice("pair-orient", input_para.Pair_orient, PAIR_ORIENT_FR, PAIR_ORIENT_RF, PAIR_ORIENT_FF)
+	if input_para.Sex != "" {
+		C.Choice("sex", input_para.Sex, SEX_FEMALE, SEX_MALE, SEX_AUTO)
+	}
+	if input_para.Mask_file != "" {
+		C.Choice("mask-mode", input_para.Mask_mode, MASK_MODE_DROP, MASK_MODE_FILTER)
+	}
+
+	// Numeric options
+	C.NonNegative("t", float64(input_para.Proc_num))
+	C.NonNegative("maxs", float64(input_para.Max_snum))
+	C.NonNegative("maxp", float64(input_para.Max_psnum))
+	C.NonNegative("lmin", float64(input_para.Min_slen))
+	C.NonNegative("lmax", float64(input_para.Max_slen))
+	C.NonNegative("d", input_para.Dist_thres)
+	C.NonNegative("r", float64(input_para.Iter_num))
+	C.NonNegative("s", input_para.Sub_cost)
+	C.NonNegative("o", input_para.Gap_open)
+	C.NonNegative("e", input_para.Gap_ext)
+	C.NonNegative("max-depth", float64(input_para.Max_depth))
+	C.NonNegative("min-qual", input_para.Min_qual)
+	C.NonNegative("warm-up", float64(input_para.Warm_up))
+	C.NonNegative("read-cache", float64(input_para.Read_cache))
+	C.NonNegative("qual-bins", float64(input_para.Qual_bins))
+	C.Rate("snp-rate", input_para.New_snp_rate)
+	C.NonNegative("ts-tv", input_para.Ts_tv)
+	C.Rate("indel-rate", input_para.New_indel_rate)
+	C.Rate("indel-err-rate", input_para.Indel_err_rate)
+	if input_para.AF_weight < 0 || input_para.AF_weight > 1 {
+		C.Fail("use a weight in [0, 1]", "invalid value %g of -af-weight", input_para.AF_weight)
+	}
+	if input_para.Prof_trust < 0 || input_para.Prof_trust > 1 {
+		C.Fail("use a trust in [0, 1]", "invalid value %g of -prof-trust", input_para.Prof_trust)
+	}
+	if input_para.Min_slen > 0 && input_para.Max_slen > 0 && input_para.Min_slen > input_para.Max_slen {
+		C.Fail("give -lmin not larger than -lmax", "minimum length of seeds (-lmin %d) is larger than the maximum length (-lmax %d)",
+			input_para.Min_slen, input_para.Max_slen)
+	}
+
+	// Combinations of options
+	if input_para.Seed_index == SEED_INDEX_KMER && input_para.Mismatch_seeds {
+		C.Fail("drop -mismatch-seeds, or use -seed-index fm", "-mismatch-seeds is only supported with the FM-index (-seed-index fm)")
+	}
+	if input_para.Pair_orient != PAIR_ORIENT_FR && input_para.Preset != "" {
+		C.Fail("drop -pair-orient with presets", "chunk pairs of long reads are F-R oriented, -pair-orient %s is not supported with presets (-preset)", input_para.Pair_orient)
+	}
+	if input_para.Mate_pair && input_para.Preset != "" {
+		C.Fail("drop -mate-pair with presets", "-mate-pair is not supported with presets (-preset)")
+	}
+	if input_para.Cycle_err && input_para.Preset != "" {
+		C.Fail("drop -cycle-err with presets", "cycles of chunks of long reads are not sequencing cycles, -cycle-err is not supported with presets (-preset)")
+	}
+	if input_para.Pair_orient != PAIR_ORIENT_FR && input_para.Merge_pairs {
+		C.Fail("drop -merge-pairs, or use -pair-orient fr", "-merge-pairs is only supported for F-R oriented read pairs (-pair-orient fr)")
+	}
+	if (input_para.Calib_truth != "" || input_para.Calib_bed != "") && input_para.Calib_report == "" {
+		C.Fail("give the report file with -calib-report", "-calib-truth and -calib-bed are only used with -calib-report")
+	}
+	if input_para.Mask_file != "" {
+		C.File(input_para.Mask_file, "mask file", "check the path of -mask")
+	}
+	if input_para.Calib_truth != "" {
+		C.File(input_para.Calib_truth, "truth variant file", "check the path of -calib-truth")
+	}
+	if input_para.Calib_bed != "" {
+		C.File(input_para.Calib_bed, "confident region file", "check the path of -calib-bed")
+	}
+	if input_para.Merge_contigs && !input_para.Per_contig {
+		C.Fail("add -per-contig, or drop -merge-contigs", "-merge-contigs is only used with -per-contig")
+	}
+	if input_para.Per_contig && !input_para.Merge_contigs && input_para.Calib_report != "" {
+		C.Fail("add -merge-contigs, or run the subcommand calib on per-contig files", "-calib-report needs the variant call file, which is not written with -per-contig without -merge-contigs")
+	}
+	if input_para.Sex != "" && input_para.Trio != "" {
+		C.Fail("drop -sex in trio mode", "-sex is not supported in trio mode (-trio)")
+	}
+	return C.Errs
+}
+
+//---------------------------------------------------------------------------------------------------
+// CheckReads checks read files, and thresholds which depend on lengths of reads.
+//---------------------------------------------------------------------------------------------------
+func (C *InputChecker) CheckReads(input_para *ParaInfo) {
+	files_1, files_2 := ReadFiles(input_para.Read_file_1), ReadFiles(input_para.Read_file_2)
+	if input_para.Read_file_1 == "" {
+		C.Fail("give the FASTQ file of first ends, e.g. -1 reads_1.fastq", "missing -1 (first-end read file)")
+		return
+	}
+	if input_para.Preset == "" {
+		if input_para.Read_file_2 == "" {
+			C.Fail("give the FASTQ file of second ends with -2 (IVC calls variants from paired-end reads), or use -preset ont|pacbio for long reads in -1",
+				"missing -2 (second-end read file)")
+			return
+		}
+		if len(files_1) != len(files_2) {
+			C.Fail("give one second-end file per first-end file, in the same order", "%d first-end read files (-1) but %d second-end read files (-2)",
+				len(files_1), len(files_2))
+		}
+	} else if len(files_1) > 1 {
+		C.Fail("give one long-read file with -1", "only one read file is supported with -preset")
+	} else if input_para.Read_file_2 != "" {
+		C.Fail("drop -2, long reads are taken from -1", "-2 is not used with -preset")
+	}
+	if len(input_para.Read_groups) > 0 && len(input_para.Read_groups) != len(files_1) {
+		C.Fail("give -rg once per FASTQ pair, in the same order as the read files", "%d read groups (-rg) for %d FASTQ pairs",
+			len(input_para.Read_groups), len(files_1))
+	}
+	read_files := files_1
+	if input_para.Preset == "" {
+		read_files = append(read_files, files_2...)
+	}
+	read_len, local := 0, true
+	for _, read_file := range read_files {
+		if IsRemote(read_file) {
+			local = false
+			continue
+		}
+		if !C.File(read_file, "read file", "check the path of the read file") {
+			local = false
+			continue
+		}
+		if info, _ := os.Stat(read_file); info.Size() == 0 {
+			C.Fail("check that the read file was completely written", "read file %s is empty", read_file)
+			local = false
+			continue
+		}
+		l, _ := PeekReadFile(read_file, PEEK_READ_NUM, input_para.Skip_bad_reads)
+		read_len = MaxInt(read_len, l)
+	}
+	// Thresholds are checked against lengths of the first reads of local read files (long reads are
+	// aligned by chunks of preset lengths)
+	if !local || read_len == 0 || input_para.Preset != "" {
+		return
+	}
+	if input_para.Min_slen >= read_len {
+		C.Fail(fmt.Sprintf("use -lmin smaller than the read length %d", read_len), "minimum length of seeds (-lmin %d) is not shorter than reads (%d bases)",
+			input_para.Min_slen, read_len)
+	}
+	sub_cost := input_para.Sub_cost
+	if sub_cost == 0 {
+		sub_cost = 4
+	}
+	if max_dist := float64(read_len) * sub_cost; input_para.Dist_thres >= max_dist {
+		C.Fail(fmt.Sprintf("use -d well below %.1f, or omit -d so that it is estimated from the data", max_dist),
+			"threshold of alignment distances (-d %.1f) accepts alignments of %d-base reads with all bases mismatched (distance %.1f)",
+			input_para.Dist_thres, read_len, max_dist)
+	}
+}
@@ -11,7 +11,12 @@ package ivc
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"github.com/namsyvo/IVC/caller"
 	"github.com/namsyvo/IVC/fmi"
+	"github.com/namsyvo/IVC/index"
+	"github.com/namsyvo/IVC/seqio"
+	"io"
 	"log"
 	"math"
 	"math/rand"
@@ -21,12 +26,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"fmt"
 )
 
-var mapMutex = sync.RWMutex{}
-
 //---------------------------------------------------------------------------------------------------
 // VarCallIndex represents preprocessed information of the reference genome and variant profile,
 // includes an FM-index of (reverse of) the multigenome, which is used to speed up variant calling.
@@ -42,6 +45,9 @@ type VarCallIndex struct {
 	SameLenVar map[int]int       // indicate if variants has same length (SNPs or MNPs)
 	DelVar     map[int]int       // length of deletions if variants are deletion
 	RevFMI     *fmi.Index        // FM-index of reverse multi-sequence (to do forward search)
+	BiFMI      *fmi.BiIndex      // bidirectional FM-index of multi-sequence (nil: the FM-index is not bidirectional)
+	KmerIdx    *index.KmerIndex  // k-mer index of multi-sequence (nil: seeds are searched with the FM-index)
+	Calls      []*VarProf        // variant calls, each element covers a range of positions on the multigenome (one per core to run parallel updates)
 }
 
 //--------------------------------------------------------------------------------------------------
@@ -51,20 +57,35 @@ type VarProf struct {
 	// VarProb stores all possible variants at each position and their confident probablilities.
 	// Prior probablities will be obtained from reference genomes and variant profiles.
 	// Posterior probabilities will be updated during alignment phase based on incomming aligned bases
-	VarProb   map[uint32]map[string]float64   // probability of the variant call
-	VarType   map[uint32]map[string]int       // pype of variants (0: sub, 1: ins, 2: del; other types will be considered in future)
-	VarRNum   map[uint32]map[string]int       // numer of aligned reads corresponding to each variant
-	ChrDis    map[uint32]map[string][]int     // chromosomal distance between two aligned read-ends
-	ChrDiff   map[uint32]map[string][]int     // chromosomal distance betwwen the aligned postion and true postion (for simulated data)
-	MapProb   map[uint32]map[string][]float64 // probability of mapping read to be corect (mapping quality)
-	AlnProb   map[uint32]map[string][]float64 // probability of aligning read to be correct (alignment quality)
-	ChrProb   map[uint32]map[string][]float64 // probability of insert size to be correct (for pair-end reads)
-	StartPos1 map[uint32]map[string][]int     // start position (on read) of alignment of the first end
-	StartPos2 map[uint32]map[string][]int     // start position (on read) of alignment of the second end
-	Strand1   map[uint32]map[string][]bool    // strand indicator of the first end ("true" if read has same strand with ref, "false" otherwise)
-	Strand2   map[uint32]map[string][]bool    // strand indicator of the second end ("true" if read has same strand with ref, "false" otherwise)
-	VarBQual  map[uint32]map[string][][]byte  // quality sequences (in FASTQ format) of aligned bases at the variant call position
-	ReadInfo  map[uint32]map[string][][]byte  // information sequences (in FASTQ format) of aligned reads (header of reads in FASTQ format)
+	VarProb    *GenoStore                      // probability of the variant call
+	Sites      *SiteStore                      // types of variants, numbers of aligned reads of each variant, statistics and likelihoods of aligned reads at each position
+	VarDepth   map[uint32]int                  // number of aligned reads seen at each position (used for downsampling and flagged at output)
+	Kept       map[uint32]*Reservoir           // aligned bases kept at each position until alignment is done (see downsample.go)
+	VarLike    *GenoStore                      // log10 likelihood of aligned bases given each genotype (used for PL)
+	SampleLike map[uint32][]map[string]float64 // log10 likelihood of aligned bases of each sample given each genotype (multi-sample)
+	SampleRNum map[uint32][]map[string]int     // number of aligned reads of each sample corresponding to each variant (multi-sample)
+	SampleRev  map[uint32][]map[string]int     // number of aligned reads on the reverse strand of each sample corresponding to each variant (multi-sample)
+	ChrDis     map[uint32]map[string][]int     // chromosomal distance between two aligned read-ends
+	ChrDiff    map[uint32]map[string][]int     // chromosomal distance betwwen the aligned postion and true postion (for simulated data)
+	MapProb    map[uint32]map[string][]float64 // probability of mapping read to be corect (mapping quality)
+	AlnProb    map[uint32]map[string][]float64 // probability of aligning read to be correct (alignment quality)
+	ChrProb    map[uint32]map[string][]float64 // probability of insert size to be correct (for pair-end reads)
+	StartPos1  map[uint32]map[string][]int     // start position (on read) of alignment of the first end
+	StartPos2  map[uint32]map[string][]int     // start position (on read) of alignment of the second end
+	Strand1    map[uint32]map[string][]bool    // strand indicator of the first end ("true" if read has same strand with ref, "false" otherwise)
+	Strand2    map[uint32]map[string][]bool    // strand indicator of the second end ("true" if read has same strand with ref, "false" otherwise)
+	VarBQual   map[uint32]map[string][][]byte  // quality sequences (in FASTQ format) of aligned bases at the variant call position
+	ReadInfo   map[uint32]map[string][][]byte  // information sequences (in FASTQ format) of aligned reads (header of reads in FASTQ format)
+	VarReads   map[uint32]map[string][][]byte  // names of aligned reads corresponding to each variant (supporting-read output, kept at all aligned positions)
+	VarBarcode map[uint32]map[string][]uint32  // barcodes of aligned reads corresponding to each variant (linked reads)
+
+	// Sums of log10 likelihoods of aligned bases of each key allele (of all samples in Sites), VarProb,
+	// VarLike and SampleLike are computed from them when variant calls are written
+	SampleLikeStat map[uint32][]*caller.SiteLike // sums of aligned bases of each sample (multi-sample)
+
+	// Mutex lock for updating variant calls of the shard (positions of the shard's range), alignment
+	// read-locks it for looking up candidate variant positions in Sites (see HasSite)
+	mut sync.RWMutex
 }
 
 //---------------------------------------------------------------------------------------------------
@@ -85,6 +106,14 @@ type VarInfo struct {
 	Strand1 bool    // strand (backward/forward) of read1 of exact match
 	Strand2 bool    // strand (backward/forward) of read2 of exact match
 	RInfo   []byte  // information sequences (in FASTQ format) of aligned reads (header of reads in FASTQ format)
+	RPos    int     // distance from the variant to the nearest end of the read
+	Cycle   int     // sequencing cycle of the variant (index of its base in the read-end as it was sequenced)
+	RGroup  int     // index of the read group of the read
+	Barcode uint32  // identifier of the barcode of the read (linked reads, 0: no barcode)
+	TieNum  int     // number of tied alignments the evidence is distributed over (0 or 1: not distributed)
+	Rev     bool    // the read-end is aligned to the reverse strand of the reference
+	AltAln  string  // alternative alignments of the read within the distance delta of the best one (XA-style)
+	RSeed   int64   // seed of the read (see ReadSeed), which gives priorities of its bases for downsampling
 }
 
 //---------------------------------------------------------------------------------------------------
@@ -93,15 +122,16 @@ type VarInfo struct {
 type UnAlnReadInfo struct {
 	read_info1 []byte // info of first-end of read
 	read_info2 []byte // info of second-end of read
+	seeded     bool   // pairs of seeds of the read are found, but the read cannot be aligned
+	skipped    bool   // the read is skipped by the k-mer prescreen
+	read1      []byte // bases of first-end of read (kept if unaligned reads are written)
+	qual1      []byte // qualities of first-end of read
+	read2      []byte // bases of second-end of read
+	qual2      []byte // qualities of second-end of read
 }
 
-//---------------------------------------------------------------------------------------------------
-// Set of variant calls, each element cover a certain region on the multigenome.
-//---------------------------------------------------------------------------------------------------
-var VarCall []*VarProf // number of elements will be set equal to number of cores to run parallel updates
-
 func recoverName() {
-	if r := recover(); r!= nil {
+	if r := recover(); r != nil {
 		fmt.Println("recovered from ", r)
 	}
 }
@@ -117,58 +147,54 @@ func NewVariantCaller() *VarCallIndex {
 
 	VC := new(VarCallIndex)
 
-	log.Printf("Loading FM-index of the reference...")
-	VC.RevFMI = fmi.Load(PARA.Rev_index_file)
-	log.Printf("Finish loading FM-index of the reference.")
+	// Loading is started when input files are checked, or here if it has not been started
+	if LOADER == nil {
+		LOADER = StartLoading(PARA.Ref_file, PARA.Var_prof_file, PARA.Rev_index_file, PARA.Seed_index)
+	}
+	LOADER.Wait()
+	VC.RevFMI, VC.BiFMI, VC.KmerIdx = LOADER.RevFMI, LOADER.BiFMI, LOADER.KmerIdx
+	VC.ChrPos, VC.ChrName, VC.Seq = LOADER.ChrPos, LOADER.ChrName, LOADER.Seq
+	VC.SeqLen = len(VC.Seq)
+	VC.Variants, VC.VarAF = LOADER.Variants, LOADER.VarAF
+	VC.SameLenVar, VC.DelVar = LOADER.SameLenVar, LOADER.DelVar
+	LOADER = nil
+	VC.SetupHeteroplasmy(PARA.Heteroplasmy)
+	if PARA.Prescreen {
+		KMER_FILTER = NewKmerFilter(VC.Seq, PRESCREEN_K, PARA.Proc_num)
+	}
+	PARA.Mut_rate = VC.EstimateMutRate()
+	log.Printf("Estimated mutation rate (density of known variants):\t%g", PARA.Mut_rate)
+	SetupAlnThres()
+	SetupPairRegimes()
 	if PARA.Debug_mode {
 		log.Printf("Memstats (golang name):\tAlloc\tTotalAlloc\tSys\tHeapAlloc\tHeapSys")
-		PrintMemStats("Memstats after loading index of multi-sequence")
+		PrintMemStats("Memstats after loading index, multi-sequence and variant profile")
 	}
 
-	log.Printf("Loading the reference...")
-	VC.ChrPos, VC.ChrName, VC.Seq = LoadMultiSeq(PARA.Ref_file)
-	VC.SeqLen = len(VC.Seq)
-	log.Printf("Finish loading the reference.")
-	if PARA.Debug_mode {
-		PrintMemStats("Memstats after loading multi-sequence")
+	if PARA.Primer_file != "" {
+		log.Printf("Loading amplicon primers...")
+		AMPLICONS = VC.LoadAmplicons(PARA.Primer_file)
 	}
 
-	log.Printf("Loading the variant profile...")
-	VC.Variants, VC.VarAF = LoadVarProf(PARA.Var_prof_file)
-	log.Printf("Finish loading the variant profile.")
-	if PARA.Debug_mode {
-		PrintMemStats("Memstats after loading variant profile")
+	MASK = nil
+	if PARA.Mask_file != "" {
+		log.Printf("Loading masked regions...")
+		MASK = VC.LoadMask(PARA.Mask_file, PARA.Mask_mode)
 	}
 
-	log.Printf("Creating auxiliary data structures...")
-	VC.SameLenVar = make(map[int]int)
-	VC.DelVar = make(map[int]int)
-	var same_len_flag, del_flag bool
-	var var_len int
-	for var_pos, var_bases := range VC.Variants {
-		var_len = len(var_bases[0])
-		same_len_flag, del_flag = true, true
-		for _, val := range var_bases[1:] {
-			if var_len != len(val) {
-				same_len_flag = false
-			}
-			if var_len <= len(val) {
-				del_flag = false
-			}
-		}
-		if same_len_flag {
-			VC.SameLenVar[var_pos] = var_len
-		}
-		if del_flag {
-			VC.DelVar[var_pos] = var_len - 1
+	POP_AF = nil
+	if PARA.AF_file != "" {
+		if PARA.AF_weight < 0 || PARA.AF_weight > 1 {
+			log.Panicf("Error: invalid weight of population allele frequencies %g (must be in [0, 1])", PARA.AF_weight)
 		}
+		log.Printf("Loading population allele frequencies...")
+		POP_AF = VC.LoadPopAF(PARA.AF_file)
 	}
 
+	log.Printf("Creating auxiliary data structures...")
 	// Set up pre-calculated cost
 	// Notice: Phred-encoding factor is set to 33 here. It is better to be determined from input data.
-	Q2C = make(map[byte]float64)           // alignment cost based on Phred-scale quality
-	Q2E = make(map[byte]float64)           // error probability based on Phred-scale quality
-	Q2P = make(map[byte]float64)           // non-error probability based on Phred-scale quality
+	// Tables are indexed by quality characters, so that no map lookups are needed when updating variants.
 	L2E = make([]float64, PARA.Read_len+1) // indel-error rate based on indel-length
 	var q byte
 	for i := 33; i < 105; i++ {
@@ -180,32 +206,69 @@ func NewVariantCaller() *VarCallIndex {
 	for i := 0; i < PARA.Read_len+1; i++ {
 		L2E[i] = math.Pow(INDEL_ERR_RATE, float64(i))
 	}
+	SetupQualBins(PARA.Qual_bins)
 
 	log.Printf("Finish creating auxiliary data structures.")
 	if PARA.Debug_mode {
 		PrintMemStats("Memstats after creating auxiliary data structures")
 	}
 
-	// Initialize VarCallIndex object for calling variants
+	VC.InitVarCall()
+
+	index_time := time.Since(start_time)
+	log.Printf("Time for initializing the variant caller:\t%s", index_time)
+	SUMMARY.AddStageTime("initializing", index_time)
+	log.Printf("Finish initializing the variant caller.")
+	return VC
+}
+
+//---------------------------------------------------------------------------------------------------
+// InitVarCall initializes variant call data structure with prior probabilities of known variants.
+// It is called once for each set of reads which variants are called from.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) InitVarCall() {
 	log.Printf("Initializing variant call data structure...")
-	VarCall = make([]*VarProf, PARA.Proc_num)
+	VC.Calls = make([]*VarProf, PARA.Proc_num)
+	if PARA.All_sites || PARA.Bedgraph_file != "" {
+		SiteDepth, SiteDepthMut = make([]uint16, VC.SeqLen), make([]sync.Mutex, PARA.Proc_num)
+	}
+	if PARA.CNV_file != "" {
+		WindowDepth = make([]uint32, VC.SeqLen/CNV_WINDOW+1)
+	}
+	if PARA.Pileup_file != "" {
+		InitPileup()
+	}
 	for rid := 0; rid < PARA.Proc_num; rid++ {
-		VarCall[rid] = new(VarProf)
-		VarCall[rid].VarProb = make(map[uint32]map[string]float64)
-		VarCall[rid].VarType = make(map[uint32]map[string]int)
-		VarCall[rid].VarRNum = make(map[uint32]map[string]int)
+		VC.Calls[rid] = new(VarProf)
+		VC.Calls[rid].VarProb = NewGenoStore()
+		VC.Calls[rid].Sites = NewSiteStore()
+		VC.Calls[rid].VarLike = NewGenoStore()
+		if MultiSample() {
+			VC.Calls[rid].SampleLike = make(map[uint32][]map[string]float64)
+			VC.Calls[rid].SampleLikeStat = make(map[uint32][]*caller.SiteLike)
+			VC.Calls[rid].SampleRNum = make(map[uint32][]map[string]int)
+			VC.Calls[rid].SampleRev = make(map[uint32][]map[string]int)
+		}
+		VC.Calls[rid].VarDepth = make(map[uint32]int)
+		VC.Calls[rid].Kept = make(map[uint32]*Reservoir)
+		if PARA.Support_file != "" {
+			VC.Calls[rid].VarReads = make(map[uint32]map[string][][]byte)
+		}
+		if BARCODES != nil {
+			VC.Calls[rid].VarBarcode = make(map[uint32]map[string][]uint32)
+		}
 		if PARA.Debug_mode {
-			VarCall[rid].ChrDis = make(map[uint32]map[string][]int)
-			VarCall[rid].ChrDiff = make(map[uint32]map[string][]int)
-			VarCall[rid].MapProb = make(map[uint32]map[string][]float64)
-			VarCall[rid].AlnProb = make(map[uint32]map[string][]float64)
-			VarCall[rid].ChrProb = make(map[uint32]map[string][]float64)
-			VarCall[rid].StartPos1 = make(map[uint32]map[string][]int)
-			VarCall[rid].StartPos2 = make(map[uint32]map[string][]int)
-			VarCall[rid].Strand1 = make(map[uint32]map[string][]bool)
-			VarCall[rid].Strand2 = make(map[uint32]map[string][]bool)
-			VarCall[rid].VarBQual = make(map[uint32]map[string][][]byte)
-			VarCall[rid].ReadInfo = make(map[uint32]map[string][][]byte)
+			VC.Calls[rid].ChrDis = make(map[uint32]map[string][]int)
+			VC.Calls[rid].ChrDiff = make(map[uint32]map[string][]int)
+			VC.Calls[rid].MapProb = make(map[uint32]map[string][]float64)
+			VC.Calls[rid].AlnProb = make(map[uint32]map[string][]float64)
+			VC.Calls[rid].ChrProb = make(map[uint32]map[string][]float64)
+			VC.Calls[rid].StartPos1 = make(map[uint32]map[string][]int)
+			VC.Calls[rid].StartPos2 = make(map[uint32]map[string][]int)
+			VC.Calls[rid].Strand1 = make(map[uint32]map[string][]bool)
+			VC.Calls[rid].Strand2 = make(map[uint32]map[string][]bool)
+			VC.Calls[rid].VarBQual = make(map[uint32]map[string][][]byte)
+			VC.Calls[rid].ReadInfo = make(map[uint32]map[string][][]byte)
 		}
 	}
 
@@ -216,39 +279,39 @@ func NewVariantCaller() *VarCallIndex {
 	for var_pos, var_prof := range VC.Variants {
 		pos = uint32(var_pos)
 		rid = PARA.Proc_num * var_pos / VC.SeqLen
-		VarCall[rid].VarProb[pos] = make(map[string]float64)
-		rbase, vbase := string(var_prof[0]), string(var_prof[1])
-		VarCall[rid].VarProb[pos][rbase+"|"+rbase] = float64(VC.VarAF[var_pos][0]) * 2.0 / 3.0
-		VarCall[rid].VarProb[pos][rbase+"|"+vbase] = float64(VC.VarAF[var_pos][0])/3.0 + float64(VC.VarAF[var_pos][1])/3.0
-		VarCall[rid].VarProb[pos][vbase+"|"+vbase] = float64(VC.VarAF[var_pos][1]) * 2.0 / 3.0
-		VarCall[rid].VarType[pos] = make(map[string]int)
+		var_af := VC.VarAF[var_pos]
+		if pop_af, ok := POP_AF[var_pos][string(var_prof[1])]; ok {
+			var_af = BlendAF(var_af, pop_af, PARA.AF_weight)
+		}
+		VC.Calls[rid].VarProb.Set(pos, caller.KnownGenotypePriors(string(var_prof[0]), string(var_prof[1]), var_af, PARA.Prof_trust, NovelRates{}))
+		VC.Calls[rid].Sites.Add(pos)
 		if PARA.Debug_mode {
-			VarCall[rid].ChrDis[pos] = make(map[string][]int)
-			VarCall[rid].ChrDiff[pos] = make(map[string][]int)
-			VarCall[rid].MapProb[pos] = make(map[string][]float64)
-			VarCall[rid].AlnProb[pos] = make(map[string][]float64)
-			VarCall[rid].ChrProb[pos] = make(map[string][]float64)
-			VarCall[rid].StartPos1[pos] = make(map[string][]int)
-			VarCall[rid].StartPos2[pos] = make(map[string][]int)
-			VarCall[rid].Strand1[pos] = make(map[string][]bool)
-			VarCall[rid].Strand2[pos] = make(map[string][]bool)
-			VarCall[rid].VarBQual[pos] = make(map[string][][]byte)
-			VarCall[rid].ReadInfo[pos] = make(map[string][][]byte)
+			VC.Calls[rid].ChrDis[pos] = make(map[string][]int)
+			VC.Calls[rid].ChrDiff[pos] = make(map[string][]int)
+			VC.Calls[rid].MapProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].AlnProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].ChrProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].StartPos1[pos] = make(map[string][]int)
+			VC.Calls[rid].StartPos2[pos] = make(map[string][]int)
+			VC.Calls[rid].Strand1[pos] = make(map[string][]bool)
+			VC.Calls[rid].Strand2[pos] = make(map[string][]bool)
+			VC.Calls[rid].VarBQual[pos] = make(map[string][][]byte)
+			VC.Calls[rid].ReadInfo[pos] = make(map[string][][]byte)
 		}
 		if (c+1)%(len(VC.Variants)/10) == 0 {
 			log.Println("Finish initializing", (c+1)/(len(VC.Variants)/100), "% of variant call data structure.")
 		}
 		c++
 	}
+	if PARA.Load_state != "" {
+		S := LoadVarCallState(PARA.Load_state)
+		VC.AddVarCallState(S)
+		log.Printf("Variant call state of %d variant locations is loaded from:\t%s", len(S.Sites), PARA.Load_state)
+	}
 	log.Printf("Finish initializing variant call data structure.")
 	if PARA.Debug_mode {
-		PrintMemStats("Memstats after initializing the variant caller")
+		PrintMemStats("Memstats after initializing variant call data structure")
 	}
-
-	index_time := time.Since(start_time)
-	log.Printf("Time for initializing the variant caller:\t%s", index_time)
-	log.Printf("Finish initializing the variant caller.")
-	return VC
 }
 
 //---------------------------------------------------------------------------------------------------
@@ -256,58 +319,146 @@ func NewVariantCaller() *VarCallIndex {
 // This function will be called from main program.
 //---------------------------------------------------------------------------------------------------
 func (VC *VarCallIndex) CallVariants() {
+	if PARA.Warm_up > 0 && PARA.Preset == "" {
+		VC.EstimateErrRate()
+	}
+	read_reads := VC.ReadReads
+	if PARA.Preset != "" {
+		read_reads = VC.ReadLongReads
+	}
+	if PARA.Two_pass > 0 {
+		VC.CallFirstPass(read_reads)
+	}
+	VC.CallVariantsFrom(read_reads)
+	if PARA.Save_state != "" {
+		VC.SaveVarCallState(PARA.Save_state)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// CallVariantsFrom searches for variants from reads which are put into data channel by read_reads.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CallVariantsFrom(read_reads func(chan *ReadInfo, *ReadPool)) {
 	log.Printf("----------------------------------------------------------------------------------------")
 	log.Printf("Calling variants...")
 	start_time := time.Now()
 
-	read_data := make(chan *ReadInfo, PARA.Proc_num)
-	// The channel read_signal is used for signaling between goroutines which run ReadReads and SearchVariants.
-	// When a SearchVariants goroutine finish copying a read to its own memory, it signals ReadReads goroutine
-	// to scan next reads.
-	read_signal := make(chan bool)
-
-	var_info := make([]chan *VarInfo, PARA.Proc_num)
-	for i := 0; i < PARA.Proc_num; i++ {
-		var_info[i] = make(chan *VarInfo)
+	if PARA.Read_cache > 0 {
+		READ_CACHE = NewReadCache(PARA.Read_cache)
 	}
+	SUMMARY.ResetReadStats()
+	MULTI_MAP_ALN_NUM, MULTI_MAP_AMB_NUM = 0, 0
+	read_data := make(chan *ReadInfo, READ_POOL_MAX)
+	// Reads are put into data channel in buffers of read_pool. When a SearchVariants goroutine finishes
+	// copying a read to its own memory, it returns the buffer to the pool for scanning next reads.
+	read_pool := NewReadPool(PARA.Proc_num)
+
 	uar_info := make(chan *UnAlnReadInfo)
 
+	// Write evidence of variant calls if required
+	EVIDENCE = nil
+	evidence_done := make(chan bool)
+	if PARA.Debug_file != "" {
+		EVIDENCE = make(chan *VarInfo, 1024*PARA.Proc_num)
+		go VC.WriteEvidence(EVIDENCE, evidence_done)
+	}
+
+	if ALN_DUMP != nil {
+		ALN_DUMP.Open(PARA.Dump_file)
+	}
+
 	// Read input reads
-	go VC.ReadReads(read_data, read_signal)
+	go read_reads(read_data, read_pool)
 
 	var wg sync.WaitGroup
 	// Search for variants
+	TUNER = nil
+	if PARA.Auto_tune {
+		TUNER = NewTuner(PARA.Proc_num)
+	}
 	for i := 0; i < PARA.Proc_num; i++ {
 		wg.Add(1)
-		go VC.SearchVariants(read_data, read_signal, var_info, uar_info, &wg)
+		go VC.SearchVariants(read_data, read_pool, uar_info, &wg)
 	}
-
-	//Collect variants from results channel and update variant probabilities
-	for i := 0; i < PARA.Proc_num; i++ {
-		go func(i int) {
-			for vi := range var_info[i] {
-				VC.UpdateVariantProb(vi)
-			}
-		}(i)
+	if TUNER != nil {
+		// Goroutines are only added while others are running (see Tuner.Tune), so wg is not done yet
+		add_worker := func() {
+			wg.Add(1)
+			go VC.SearchVariants(read_data, read_pool, uar_info, &wg)
+		}
+		go TUNER.Tune(read_pool, add_worker)
 	}
 
+	// Variant probabilities are updated by the goroutines which align reads (see CollectVariant)
 	go func() {
 		wg.Wait()
-		for i := 0; i < PARA.Proc_num; i++ {
-			close(var_info[i])
+		if PARA.Realign || PARA.Assemble {
+			VC.ProcessBufferedReads()
 		}
 		close(uar_info)
 	}()
 
 	// Get unaligned reads and related info
-	i := 0
+	var unaln_file *OutputFile
+	if WriteUnaligned() {
+		unaln_file = CreateOutput(PARA.Unaligned_file)
+	}
+	i, skip_num := 0, 0
 	for uar := range uar_info {
+		if unaln_file != nil {
+			WriteUnAlnRead(unaln_file, uar)
+		}
+		if uar.skipped {
+			continue
+		}
 		i++
+		if uar.seeded {
+			skip_num++
+		}
 		if PARA.Debug_mode {
 			UNALIGN_READ_INFO = append(UNALIGN_READ_INFO, uar)
 		}
 	}
+	// Variant locations are updated with aligned bases kept by downsampling once all reads are aligned
+	VC.ApplyKeptBases()
 	log.Printf("Number of un-aligned reads:\t%d", i)
+	if unaln_file != nil {
+		unaln_file.Close()
+		log.Printf("Unaligned reads are written to:\t%s", PARA.Unaligned_file)
+	}
+	if KMER_FILTER != nil {
+		log.Printf("Number of reads skipped by k-mer prescreen:\t%d", SUMMARY.SkippedNum)
+	}
+	SUMMARY.AlignedNum, SUMMARY.UnalignedNum = MULTI_MAP_ALN_NUM, int64(i)
+	if READ_CACHE != nil {
+		log.Printf("Number of reads replayed from read cache:\t%d", READ_CACHE.HitNum())
+	}
+	LogMultiMap()
+	BARCODES.LogBarcodes()
+	MASK.LogMask()
+	if PARA.No_gaps {
+		log.Printf("Number of skipped reads (seeded, but requiring gapped alignment in no-gaps mode):\t%d", skip_num)
+	}
+	if ALN_DUMP != nil {
+		ALN_DUMP.Close()
+	}
+	if PARA.SV_file != "" {
+		VC.WriteSVCandidates(PARA.SV_file)
+	}
+	if PARA.CNV_file != "" {
+		VC.WriteCNVCandidates(PARA.CNV_file)
+	}
+	if PARA.Pileup_file != "" {
+		VC.WritePileup(PARA.Pileup_file)
+	}
+	if PARA.Bedgraph_file != "" {
+		VC.WriteBedGraph(PARA.Bedgraph_file)
+	}
+	if EVIDENCE != nil {
+		close(EVIDENCE)
+		<-evidence_done
+		log.Printf("Evidence of variant calls is written to:\t%s", PARA.Debug_file)
+	}
 
 	if PARA.Debug_mode {
 		ProcessNoAlignReadInfo()
@@ -315,55 +466,126 @@ func (VC *VarCallIndex) CallVariants() {
 	}
 	call_var_time := time.Since(start_time)
 	log.Printf("Time for calling variants:\t%s", call_var_time)
+	SUMMARY.AddStageTime("calling", call_var_time)
+	TIMING.Record("calling", call_var_time, SUMMARY.AlignedNum+SUMMARY.UnalignedNum, "read pairs")
+	TIMING.Flush()
 	log.Printf("Finish calling variants.")
 }
 
 //---------------------------------------------------------------------------------------------------
 // ReadReads reads all reads from input FASTQ files and put them into data channel.
 //---------------------------------------------------------------------------------------------------
-func (VC *VarCallIndex) ReadReads(read_data chan *ReadInfo, read_signal chan bool) {
+func (VC *VarCallIndex) ReadReads(read_data chan *ReadInfo, read_pool *ReadPool) {
 
-	fn1, fn2 := PARA.Read_file_1, PARA.Read_file_2
-	f1, e1 := os.Open(fn1)
-	if e1 != nil {
-		log.Printf("Error: Open read_file_1 %s, (err: %s)", fn1, e1)
-		os.Exit(1)
-	}
-	defer f1.Close()
-	f2, e2 := os.Open(fn2)
-	if e2 != nil {
-		log.Printf("Error: Open read_file_2 %s, (err: %s)", fn1, e2)
-		os.Exit(1)
+	files_1, files_2 := ReadFiles(PARA.Read_file_1), ReadFiles(PARA.Read_file_2)
+	for rg, fn1 := range files_1 {
+		fn2 := files_2[rg]
+		f1, e1 := OpenInput(fn1)
+		if e1 != nil {
+			log.Printf("Error: Open read_file_1 %s, (err: %s)", fn1, e1)
+			os.Exit(1)
+		}
+		f2, e2 := OpenInput(fn2)
+		if e2 != nil {
+			log.Printf("Error: Open read_file_2 %s, (err: %s)", fn2, e2)
+			os.Exit(1)
+		}
+		if len(files_1) > 1 {
+			log.Printf("Reading reads of read group %s from %s, %s", RGID(rg), fn1, fn2)
+		}
+		if e := VC.ReadPairedReads(f1, f2, fn1, fn2, rg, read_data, read_pool); e != nil {
+			log.Printf("Error: %s", e)
+			os.Exit(1)
+		}
+		f1.Close()
+		f2.Close()
 	}
-	defer f2.Close()
-
-	read_num := 0
-	scanner1 := bufio.NewScanner(f1)
-	scanner2 := bufio.NewScanner(f2)
-	read_info := InitReadInfo(PARA.Read_len, PARA.Info_len)
-	for scanner1.Scan() && scanner2.Scan() {
-		read_info.Info1 = read_info.Info1[:len(scanner1.Bytes())]
-		read_info.Info2 = read_info.Info2[:len(scanner2.Bytes())]
-		copy(read_info.Info1, scanner1.Bytes()) // use 1st line in 1st FASTQ file
-		copy(read_info.Info2, scanner2.Bytes()) // use 1st line in 2nd FASTQ file
-		scanner1.Scan()
-		scanner2.Scan()
-		read_info.Read1 = read_info.Read1[:len(scanner1.Bytes())]
-		read_info.Read2 = read_info.Read2[:len(scanner2.Bytes())]
-		copy(read_info.Read1, scanner1.Bytes()) // use 2nd line in 1st FASTQ file
-		copy(read_info.Read2, scanner2.Bytes()) // use 2nd line in 2nd FASTQ file
-		scanner1.Scan()                         // ignore 3rd line in 1st FASTQ file
-		scanner2.Scan()                         // ignore 3rd line in 2nd FASTQ file
-		scanner1.Scan()
-		scanner2.Scan()
-		read_info.Qual1 = read_info.Qual1[:len(scanner1.Bytes())]
-		read_info.Qual2 = read_info.Qual2[:len(scanner2.Bytes())]
-		copy(read_info.Qual1, scanner1.Bytes()) // use 4th line in 1st FASTQ file
-		copy(read_info.Qual2, scanner2.Bytes()) // use 4th line in 2nd FASTQ file
-		if len(read_info.Read1) > 0 && len(read_info.Read2) > 0 {
+	close(read_data)
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadPairedReads reads all paired-end reads of a read group from two FASTQ streams (with names used in
+// error messages) and put them into data channel. The channel is not closed, so that reads from
+// several streams can be put into it. It returns the first error of malformed records, of streams
+// with different numbers of records, or of paired records with different names (if names are checked).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ReadPairedReads(f1, f2 io.Reader, fn1, fn2 string, rg int, read_data chan *ReadInfo, read_pool *ReadPool) error {
+
+	read_num, long_read_num, mate_err_num := 0, 0, 0
+	fq1, fq2 := seqio.NewFastqReader(f1, fn1), seqio.NewFastqReader(f2, fn2)
+	fq1.SetResync(PARA.Skip_bad_reads)
+	fq2.SetResync(PARA.Skip_bad_reads)
+	drop_num := 0
+	read_info := read_pool.Get()
+	defer func() { read_pool.Put(read_info) }()
+	merger := new(PairMerger)
+	for {
+		skip_num1, skip_num2 := fq1.SkipNum(), fq2.SkipNum()
+		ok1, ok2 := fq1.Next(), fq2.Next()
+		skip1, skip2 := fq1.SkipNum() > skip_num1, fq2.SkipNum() > skip_num2
+		if skip1 {
+			LogSkipped(fq1)
+		}
+		if skip2 {
+			LogSkipped(fq2)
+		}
+		if !ok1 || !ok2 {
+			if e := fq1.Err(); e != nil {
+				return e
+			}
+			if e := fq2.Err(); e != nil {
+				return e
+			}
+			// Remaining records of the other file are dropped if a file ends with skipped records
+			if (ok1 || ok2) && !skip1 && !skip2 {
+				return fmt.Errorf("%s and %s have different numbers of reads", fn1, fn2)
+			}
+			break
+		}
+		// Records skipped in one file are dropped from the other file by names of paired records
+		if skip1 || skip2 {
+			n, ok := RepairMates(fq1, fq2, skip1, skip2)
+			if drop_num += n; !ok {
+				return fmt.Errorf("records of %s and %s cannot be re-paired by their names after skipping malformed records", fn1, fn2)
+			}
+		}
+		if PARA.Mate_check != MATE_CHECK_OFF {
+			if e := seqio.MateError(fq1, fq2); e != nil {
+				if PARA.Mate_check == MATE_CHECK_ERROR {
+					return e
+				}
+				if mate_err_num++; mate_err_num <= MATE_WARN_NUM {
+					log.Printf("Warning: %s", e)
+				}
+			}
+		}
+		// Reads can have different lengths (e.g. trimmed reads, or ends of different lengths), buffers of
+		// each end are allocated based on the maximum length of its first reads and grown for longer reads
+		if len(fq1.Read) > PARA.Read_len_1 || len(fq2.Read) > PARA.Read_len_2 {
+			if long_read_num++; long_read_num == 1 {
+				log.Printf("Warning: %s has reads longer than its first reads (%d/%d bases at the first/second end), buffers are grown for them",
+					fn1, PARA.Read_len_1, PARA.Read_len_2)
+			}
+		}
+		read_info.SetInfo(fq1.Info, fq2.Info)
+		read_info.SetReadLen(len(fq1.Read), len(fq2.Read))
+		read_info.RGroup = rg
+		read_info.Barcode = BARCODES.ID(fq1.Info, fq2.Info)
+		copy(read_info.Read1, fq1.Read)
+		copy(read_info.Read2, fq2.Read)
+		copy(read_info.Qual1, fq1.Qual)
+		copy(read_info.Qual2, fq2.Qual)
+		if PARA.Merge_pairs && merger.MergePair(read_info) {
+			atomic.AddInt64(&SUMMARY.MergedNum, 1)
+		}
+		if PARA.Qual_bins > 0 {
+			BinQuals(read_info.Qual1)
+			BinQuals(read_info.Qual2)
+		}
+		if read_info.Len1 > PARA.Min_slen && read_info.Len2 > PARA.Min_slen {
 			read_num++
 			read_data <- read_info
-			read_signal <- true
+			read_info = read_pool.Get()
 		}
 		if read_num%100000 == 0 {
 			log.Println("Processed " + strconv.Itoa(read_num) + " reads.")
@@ -374,45 +596,168 @@ func (VC *VarCallIndex) ReadReads(read_data chan *ReadInfo, read_signal chan boo
 		}
 	}
 	log.Printf("Number of reads:\t%d", read_num)
+	if long_read_num > 0 {
+		log.Printf("Number of reads longer than the first reads (%d/%d bases at the first/second end):\t%d", PARA.Read_len_1, PARA.Read_len_2, long_read_num)
+	}
+	if PARA.Merge_pairs {
+		log.Printf("Number of merged overlapping read pairs:\t%d", atomic.LoadInt64(&SUMMARY.MergedNum))
+	}
+	if mate_err_num > 0 {
+		log.Printf("Number of read pairs with different names of the two ends:\t%d", mate_err_num)
+	}
+	if skip_num := fq1.SkipNum() + fq2.SkipNum(); skip_num > 0 {
+		atomic.AddInt64(&SUMMARY.MalformedNum, int64(skip_num))
+		log.Printf("Number of skipped malformed FASTQ records:\t%d (%s: %d, %s: %d; dropped mates: %d)", skip_num, fn1, fq1.SkipNum(), fn2, fq2.SkipNum(), drop_num)
+	}
+	return nil
+}
+
+//---------------------------------------------------------------------------------------------------
+// ReadLongReads reads long reads from the first input FASTQ file, splits them into chunks and puts
+// pairs of chunks into data channel. The second chunk of a pair starts Chunk_gap bases after the end
+// of the first chunk and is reverse complemented, so that each pair can be aligned as paired-end reads.
+// The rest of a read after its last full chunk pair is aligned as a chunk pair overlapping its
+// predecessor (see ChunkStarts), and chunk pairs are aligned near positions expected from the chain of
+// seeds of the read (see ChainLongRead).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ReadLongReads(read_data chan *ReadInfo, read_pool *ReadPool) {
+
+	fn := PARA.Read_file_1
+	f, e := OpenInput(fn)
+	if e != nil {
+		log.Printf("Error: Open read_file_1 %s, (err: %s)", fn, e)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	read_num, chunk_num, chain_num, short_num := 0, 0, 0, 0
+	chunk_len, pair_len := PARA.Read_len, 2*PARA.Read_len+PARA.Chunk_gap
+	m_pos := make([]int, PARA.Max_snum)
+	var rev_comp_read, rev_qual []byte
+	fq := seqio.NewFastqReader(f, fn)
+	fq.SetResync(PARA.Skip_bad_reads)
+	for {
+		skip_num := fq.SkipNum()
+		ok := fq.Next()
+		if fq.SkipNum() > skip_num {
+			LogSkipped(fq)
+		}
+		if !ok {
+			break
+		}
+		info, read, qual := fq.Info, fq.Read, fq.Qual
+		if PARA.Qual_bins > 0 {
+			BinQuals(qual)
+		}
+		read_num++
+		starts := ChunkStarts(len(read), pair_len)
+		if len(starts) == 0 {
+			short_num++
+			continue
+		}
+		if cap(rev_comp_read) < len(read) {
+			rev_comp_read, rev_qual = make([]byte, len(read)), make([]byte, len(read))
+		}
+		rev_comp_read, rev_qual = rev_comp_read[:len(read)], rev_qual[:len(read)]
+		RevComp(read, qual, rev_comp_read, rev_qual)
+		chain := VC.ChainLongRead(read, rev_comp_read, m_pos)
+		if chain != nil {
+			chain_num++
+		}
+		prev_end := 0
+		for _, s_pos := range starts {
+			read_info := read_pool.Get()
+			read_info.SetInfo(info, info)
+			read_info.SetReadLen(chunk_len, chunk_len)
+			read_info.Cycles1, read_info.Cycles2 = ChunkCycles(s_pos, prev_end, chunk_len, pair_len)
+			read_info.Anchor = chain.ChunkAnchor(s_pos, chunk_len)
+			copy(read_info.Read1, read[s_pos:s_pos+chunk_len])
+			copy(read_info.Qual1, qual[s_pos:s_pos+chunk_len])
+			RevComp(read[s_pos+pair_len-chunk_len:s_pos+pair_len], qual[s_pos+pair_len-chunk_len:s_pos+pair_len],
+				read_info.Read2, read_info.Qual2)
+			prev_end = s_pos + pair_len
+			chunk_num++
+			read_data <- read_info
+		}
+		if read_num%10000 == 0 {
+			log.Println("Processed " + strconv.Itoa(read_num) + " long reads.")
+		}
+	}
+	if e = fq.Err(); e != nil {
+		log.Printf("Error: %s", e)
+		os.Exit(1)
+	}
+	log.Printf("Number of long reads:\t%d", read_num)
+	if fq.SkipNum() > 0 {
+		atomic.AddInt64(&SUMMARY.MalformedNum, int64(fq.SkipNum()))
+		log.Printf("Number of skipped malformed FASTQ records:\t%d", fq.SkipNum())
+	}
+	log.Printf("Number of chunk pairs:\t%d", chunk_num)
+	log.Printf("Number of long reads with chains of seeds:\t%d", chain_num)
+	if short_num > 0 {
+		log.Printf("Number of ignored long reads (shorter than a chunk pair of %d bases):\t%d", pair_len, short_num)
+	}
 	close(read_data)
 }
 
 //---------------------------------------------------------------------------------------------------
-// SearchVariants takes data from data channel, searches for variants and put them into results channel.
+// SearchVariants takes data from data channel, searches for variants and updates variant probabilities
+// with them.
 //---------------------------------------------------------------------------------------------------
-func (VC *VarCallIndex) SearchVariants(read_data chan *ReadInfo, read_signal chan bool,
-	var_info []chan *VarInfo, uar_info chan *UnAlnReadInfo, wg *sync.WaitGroup) {
+func (VC *VarCallIndex) SearchVariants(read_data chan *ReadInfo, read_pool *ReadPool,
+	uar_info chan *UnAlnReadInfo, wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	defer TUNER.Exit()
 
 	// Initialize inter-function share variables
-	read_info := InitReadInfo(PARA.Read_len, PARA.Info_len)
+	read_info := InitReadInfo(PARA.Read_len_1, PARA.Read_len_2, PARA.Info_len)
+	// Alignment matrices are shared by the two ends, so they are allocated for the longer end
 	edit_aln_info_1 := InitEditAlnInfo(2 * PARA.Read_len)
 	edit_aln_info_2 := InitEditAlnInfo(2 * PARA.Read_len)
+	// Per-read temporaries of alignment are allocated from an arena, which is reset for each read
+	arena := NewArena()
+	edit_aln_info_1.arena, edit_aln_info_2.arena = arena, arena
+	// Reads are aligned by the aligner of the goroutine
+	edit_aln_info_1.aligner = VC.Aligner()
+	// Reference windows of seed extensions are cached for the reads of the goroutine
+	edit_aln_info_1.windows = NewRefWindowCache(REF_WINDOW_CACHE_SIZE)
 	seed_pos := make([][]int, 4)
 	for i := 0; i < 4; i++ {
 		seed_pos[i] = make([]int, PARA.Max_snum)
 	}
-	rand_gen := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for read := range read_data {
-		read_info.Info1 = read_info.Info1[:len(read.Info1)]
-		read_info.Info2 = read_info.Info2[:len(read.Info2)]
-		copy(read_info.Info1, read.Info1)
-		copy(read_info.Info2, read.Info2)
-		read_info.Read1 = read_info.Read1[:len(read.Read1)]
-		read_info.Read2 = read_info.Read2[:len(read.Read2)]
+	rand_gen := rand.New(new(ReadRand))
+	for {
+		wait_time := TUNER.Start()
+		read, ok := <-read_data
+		if !ok {
+			break
+		}
+		TUNER.WorkerWait(wait_time)
+		read_info.SetInfo(read.Info1, read.Info2)
+		// Random choices of each read depend only on the seed and the read, not on other reads processed
+		// by the goroutine
+		rand_gen.Seed(ReadSeed(PARA.Seed, read.Info1))
+		read_info.SetReadLen(read.Len1, read.Len2)
+		// Alignment matrices are grown for reads longer than the read length of the run
+		edit_aln_info_1.Grow(2 * MaxInt(read.Len1, read.Len2))
+		edit_aln_info_2.Grow(2 * MaxInt(read.Len1, read.Len2))
+		read_info.RGroup, read_info.Barcode = read.RGroup, read.Barcode
+		read_info.Cycles1, read_info.Cycles2, read_info.Anchor = read.Cycles1, read.Cycles2, read.Anchor
 		copy(read_info.Read1, read.Read1)
 		copy(read_info.Read2, read.Read2)
-		read_info.Qual1 = read_info.Qual1[:len(read.Qual1)]
-		read_info.Qual2 = read_info.Qual2[:len(read.Qual2)]
 		copy(read_info.Qual1, read.Qual1)
 		copy(read_info.Qual2, read.Qual2)
-		<-read_signal
+		read_pool.Put(read)
+		arena.Reset()
 
 		RevComp(read_info.Read1, read_info.Qual1, read_info.Rev_comp_read1, read_info.Rev_qual1)
 		RevComp(read_info.Read2, read_info.Qual2, read_info.Rev_comp_read2, read_info.Rev_qual2)
 
-		VC.SearchVariantsPE(read_info, edit_aln_info_1, edit_aln_info_2, seed_pos, rand_gen, var_info, uar_info)
+		VC.SearchVariantsPE(read_info, edit_aln_info_1, edit_aln_info_2, seed_pos, rand_gen, uar_info)
+		if TUNER.Retire() {
+			return
+		}
 	}
 }
 
@@ -421,27 +766,28 @@ func (VC *VarCallIndex) SearchVariants(read_data chan *ReadInfo, read_signal cha
 // It uses seed-and-extend strategy and looks for the best alignment candidates through several iterations.
 //---------------------------------------------------------------------------------------------------
 func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, edit_aln_info_2 *EditAlnInfo, seed_pos [][]int,
-	rand_gen *rand.Rand, var_info []chan *VarInfo, uar_info chan *UnAlnReadInfo) {
+	rand_gen *rand.Rand, uar_info chan *UnAlnReadInfo) {
 
 	//-----------------------------------------------------------------------------------------------
-	// in case of simulated reads, get info with specific format of testing dataset
+	// in case of simulated reads, get info with specific format of testing dataset (only used in debug mode)
 	true_pos1, true_pos2 := 0, 0
+	read_evidence := PARA.Debug_mode || PARA.Debug_file != "" || PARA.Support_file != ""
 	if PARA.Debug_mode {
 		read_info1_tokens := bytes.Split(read_info.Info1, []byte{'_'})
 		var tmp int64
 		var err error
-		if read_info1_tokens[0][1] != 'r' && len(read_info1_tokens) >= 4 {
-			if tmp, err = strconv.ParseInt(string(read_info1_tokens[2]), 10, 64); err != nil {
+		if len(read_info1_tokens[0]) > 1 && read_info1_tokens[0][1] != 'r' && len(read_info1_tokens) >= 4 {
+			if tmp, err = strconv.ParseInt(string(read_info1_tokens[2]), 10, 64); err == nil {
 				true_pos1 = int(tmp)
 			}
-			if tmp, err = strconv.ParseInt(string(read_info1_tokens[3]), 10, 64); err != nil {
+			if tmp, err = strconv.ParseInt(string(read_info1_tokens[3]), 10, 64); err == nil {
 				true_pos2 = int(tmp)
 			}
 		} else if len(read_info1_tokens) >= 3 {
-			if tmp, err = strconv.ParseInt(string(read_info1_tokens[1]), 10, 64); err != nil {
+			if tmp, err = strconv.ParseInt(string(read_info1_tokens[1]), 10, 64); err == nil {
 				true_pos1 = int(tmp)
 			}
-			if tmp, err = strconv.ParseInt(string(read_info1_tokens[2]), 10, 64); err != nil {
+			if tmp, err = strconv.ParseInt(string(read_info1_tokens[2]), 10, 64); err == nil {
 				true_pos2 = int(tmp)
 			}
 		}
@@ -454,56 +800,132 @@ func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, e
 	var vars1, vars2, vars_get1, vars_get2 []*VarInfo
 	var l_aln_pos1, l_aln_pos2 int
 	var seed_info1, seed_info2 *SeedInfo
-	var has_seeds bool
+	var has_seeds, seeded bool
 	var aln_dist1, aln_dist2 float64
 	var cand_num []int
 	var p_idx, s_idx, c_num int
+	var cov_start1, cov_start2 int
+	var strand1, strand2, skip1, skip2 bool
+	var ties []*AlnCand
+	var alt_alns []*AltAln
 
 	paired_dist := math.MaxFloat64
 	loop_has_cand := 0
-	for loop_num := 1; loop_num <= PARA.Iter_num; loop_num++ {
+	// Alignments of identical reads are replayed from the read cache if it is used
+	var cache_key string
+	var cache_aln *CachedAln
+	if READ_CACHE != nil {
+		cache_key = ReadCacheKey(read_info)
+		if cache_aln, _ = READ_CACHE.Get(cache_key); cache_aln != nil {
+			cov_start1, cov_start2, strand1, strand2 = cache_aln.CovStart1, cache_aln.CovStart2, cache_aln.Strand1, cache_aln.Strand2
+			if strand1 {
+				vars_get1 = VC.ReplayAln(read_info.Read1, read_info.Qual1, cov_start1, strand1, cache_aln.Edits1)
+			} else {
+				vars_get1 = VC.ReplayAln(read_info.Rev_comp_read1, read_info.Rev_qual1, cov_start1, strand1, cache_aln.Edits1)
+			}
+			if strand2 {
+				vars_get2 = VC.ReplayAln(read_info.Read2, read_info.Qual2, cov_start2, strand2, cache_aln.Edits2)
+			} else {
+				vars_get2 = VC.ReplayAln(read_info.Rev_comp_read2, read_info.Rev_qual2, cov_start2, strand2, cache_aln.Edits2)
+			}
+			aln_dist1, aln_dist2, paired_dist = cache_aln.Dist1, cache_aln.Dist2, cache_aln.PairDist
+			cand_num, loop_has_cand = []int{cache_aln.CandNum}, 1
+			if read_evidence {
+				for _, var1 := range vars_get1 {
+					var1.RInfo = read_info1
+				}
+				for _, var2 := range vars_get2 {
+					var2.RInfo = read_info2
+				}
+				SetAltAln(vars_get1, cache_aln.AltAln)
+				SetAltAln(vars_get2, cache_aln.AltAln)
+			}
+		}
+	}
+	// Read pairs without k-mers of the multigenome are skipped before seeding if the prescreen is used
+	if KMER_FILTER != nil && cache_aln == nil && !PassPrescreen(read_info) {
+		atomic.AddInt64(&SUMMARY.SkippedNum, 1)
+		if WriteUnaligned() {
+			uar := &UnAlnReadInfo{skipped: true}
+			uar.SetRead(read_info1, read_info2, read_info)
+			uar_info <- uar
+		}
+		return
+	}
+	for loop_num := 1; loop_num <= PARA.Iter_num && cache_aln == nil; loop_num++ {
+		seed_start_time := TIMING.Start()
 		seed_info1, seed_info2, has_seeds = VC.SearchSeedsPE(read_info, seed_pos, rand_gen)
+		TIMING.Add(TIMING_SEEDING, seed_start_time, 1)
 		if !has_seeds {
 			cand_num = append(cand_num, 0)
 			continue
 		}
+		seeded = true
 		c_num = 0
 		for p_idx = 0; p_idx < len(seed_info1.s_pos); p_idx++ {
-			// For conventional paired-end sequencing (i.e. Illumina) the directions should be F-R
-			// For other kinds of variants (e.g inversions) or other technologies, they can be F-F or R-R
-			// For mate-pair, they can be R-F (need to be confirmed)
-			if seed_info1.strand[p_idx] == seed_info2.strand[p_idx] {
+			// Directions of the two ends should be in the expected orientation of read pairs (-pair-orient):
+			// F-R for conventional paired-end sequencing (i.e. Illumina), R-F or F-F for mate-pair libraries
+			// (read pairs of mate-pair libraries can also be in the opposite orientation, see PAIR_REGIMES)
+			if !ProperStrands(seed_info1.strand[p_idx], seed_info2.strand[p_idx]) {
+				continue
+			}
+			// Chunks of long reads are only aligned near positions expected from seed chains of their reads
+			if !read_info.Anchor.Near(seed_info1.m_pos[p_idx]-seed_info1.s_pos[p_idx], seed_info1.strand[p_idx]) {
 				continue
 			}
 			// Search variants for the first end
 			if seed_info1.strand[p_idx] == true {
-				vars1, _, _, aln_dist1 = VC.ExtendSeeds(seed_info1.s_pos[p_idx], seed_info1.e_pos[p_idx],
-					seed_info1.m_pos[p_idx], read_info.Read1, read_info.Qual1, edit_aln_info_1, edit_aln_info_2)
+				vars1, _, _, aln_dist1, skip1 = VC.ExtendSeedsSpliced(seed_info1.s_pos[p_idx], seed_info1.e_pos[p_idx],
+					seed_info1.m_pos[p_idx], read_info.Read1, read_info.Qual1, CYCLE.Costs(len(read_info.Read1), false), edit_aln_info_1, edit_aln_info_2)
 			} else {
-				vars1, _, _, aln_dist1 = VC.ExtendSeeds(seed_info1.s_pos[p_idx], seed_info1.e_pos[p_idx],
-					seed_info1.m_pos[p_idx], read_info.Rev_comp_read1, read_info.Rev_qual1, edit_aln_info_1, edit_aln_info_2)
+				vars1, _, _, aln_dist1, skip1 = VC.ExtendSeedsSpliced(seed_info1.s_pos[p_idx], seed_info1.e_pos[p_idx],
+					seed_info1.m_pos[p_idx], read_info.Rev_comp_read1, read_info.Rev_qual1, CYCLE.Costs(len(read_info.Read1), true), edit_aln_info_1, edit_aln_info_2)
 			}
 			// Search variants for the second end
 			if seed_info2.strand[p_idx] == true {
-				vars2, _, _, aln_dist2 = VC.ExtendSeeds(seed_info2.s_pos[p_idx], seed_info2.e_pos[p_idx],
-					seed_info2.m_pos[p_idx], read_info.Read2, read_info.Qual2, edit_aln_info_1, edit_aln_info_2)
+				vars2, _, _, aln_dist2, skip2 = VC.ExtendSeedsSpliced(seed_info2.s_pos[p_idx], seed_info2.e_pos[p_idx],
+					seed_info2.m_pos[p_idx], read_info.Read2, read_info.Qual2, CYCLE.Costs(len(read_info.Read2), false), edit_aln_info_1, edit_aln_info_2)
 			} else {
-				vars2, _, _, aln_dist2 = VC.ExtendSeeds(seed_info2.s_pos[p_idx], seed_info2.e_pos[p_idx],
-					seed_info2.m_pos[p_idx], read_info.Rev_comp_read2, read_info.Rev_qual2, edit_aln_info_1, edit_aln_info_2)
+				vars2, _, _, aln_dist2, skip2 = VC.ExtendSeedsSpliced(seed_info2.s_pos[p_idx], seed_info2.e_pos[p_idx],
+					seed_info2.m_pos[p_idx], read_info.Rev_comp_read2, read_info.Rev_qual2, CYCLE.Costs(len(read_info.Read2), true), edit_aln_info_1, edit_aln_info_2)
 			}
+			// Indices of variants in read-ends as they are aligned are converted to sequencing cycles
+			SetCycles(vars1, len(read_info.Read1), !seed_info1.strand[p_idx])
+			SetCycles(vars2, len(read_info.Read2), !seed_info2.strand[p_idx])
 			// Currently, variants can be called iff both read-ends can be aligned
 			if aln_dist1 != -1 && aln_dist2 != -1 {
+				// Read-ends farther apart than the maximum insert size are only paired across a reference skip
+				if PARA.Spliced && !skip1 && !skip2 && SkipPairDist(seed_info1.m_pos[p_idx]-seed_info1.s_pos[p_idx],
+					seed_info2.m_pos[p_idx]-seed_info2.s_pos[p_idx], len(read_info.Read1), len(read_info.Read2),
+					seed_info1.strand[p_idx], seed_info2.strand[p_idx]) {
+					continue
+				}
 				c_num++
+				// Alignments of read pairs of mate-pair libraries are also scored by their insert sizes
+				pair_dist := aln_dist1 + aln_dist2
+				if PARA.Mate_pair {
+					pair_dist += InsPenalty(seed_info1.m_pos[p_idx]-seed_info1.s_pos[p_idx], seed_info2.m_pos[p_idx]-seed_info2.s_pos[p_idx],
+						len(read_info.Read1), len(read_info.Read2), seed_info1.strand[p_idx], seed_info2.strand[p_idx])
+				}
+				if read_evidence && PARA.Alt_delta > 0 {
+					alt_alns = AddAltAln(alt_alns, &AltAln{seed_info1.m_pos[p_idx] - seed_info1.s_pos[p_idx],
+						seed_info2.m_pos[p_idx] - seed_info2.s_pos[p_idx], seed_info1.strand[p_idx], seed_info2.strand[p_idx],
+						pair_dist})
+				}
 				ins_prob := -math.Log10(math.Exp(-math.Pow(math.Abs(float64(l_aln_pos1-l_aln_pos2))-400.0, 2.0) / (2 * 50 * 50)))
-				if paired_dist > aln_dist1+aln_dist2 {
-					paired_dist = aln_dist1 + aln_dist2
+				if paired_dist > pair_dist {
+					paired_dist = pair_dist
+					ties = nil
 					//PrintGetVariants("Find_min", paired_dist, aln_dist1, aln_dist2, vars1, vars2)
 					vars_get1 = make([]*VarInfo, len(vars1)) // need to reset vars_get1 here
 					vars_get2 = make([]*VarInfo, len(vars2)) // need to reset vars_get2 here
 					loop_has_cand = loop_num
+					cov_start1 = seed_info1.m_pos[p_idx] - seed_info1.s_pos[p_idx]
+					cov_start2 = seed_info2.m_pos[p_idx] - seed_info2.s_pos[p_idx]
+					strand1, strand2 = seed_info1.strand[p_idx], seed_info2.strand[p_idx]
 					for s_idx = 0; s_idx < len(vars1); s_idx++ {
 						vars_get1[s_idx] = vars1[s_idx]
-						if PARA.Debug_mode {
+						if read_evidence {
 							// Update vars_get1 with other info
 							vars_get1[s_idx].CDis = l_aln_pos1 - l_aln_pos2
 							vars_get1[s_idx].CDiff = l_aln_pos1 - true_pos1
@@ -518,7 +940,7 @@ func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, e
 					}
 					for s_idx = 0; s_idx < len(vars2); s_idx++ {
 						vars_get2[s_idx] = vars2[s_idx]
-						if PARA.Debug_mode {
+						if read_evidence {
 							// Update vars_get2 with other info
 							vars_get2[s_idx].CDis = l_aln_pos1 - l_aln_pos2
 							vars_get2[s_idx].CDiff = l_aln_pos2 - true_pos2
@@ -531,6 +953,10 @@ func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, e
 							vars_get2[s_idx].RInfo = read_info2
 						}
 					}
+				} else if paired_dist == pair_dist {
+					ties = AddTie(ties, &AlnCand{vars1, vars2, seed_info1.m_pos[p_idx] - seed_info1.s_pos[p_idx],
+						seed_info2.m_pos[p_idx] - seed_info2.s_pos[p_idx], seed_info1.strand[p_idx], seed_info2.strand[p_idx]},
+						cov_start1, cov_start2)
 				}
 			}
 		}
@@ -539,30 +965,188 @@ func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, e
 			break
 		}
 	}
-	var rid int
 	if loop_has_cand != 0 {
 		map_qual := 1.0 / float64(cand_num[loop_has_cand-1]) // a simple mapping quality estimation, might be changed later
 		if PARA.Debug_mode {
 			PrintGetVariants("Final_var", paired_dist, aln_dist1, aln_dist2, vars_get1, vars_get2)
 		}
+		// Aligned reads are only counted for estimating the error rate in the warm-up phase
+		if WARM_UP != nil {
+			VC.AddWarmUp(len(read_info.Read1), len(read_info.Read2), vars_get1, vars_get2)
+			if PARA.Mate_pair {
+				WARM_UP.AddInsSize(cov_start1, cov_start2, len(read_info.Read1), len(read_info.Read2), strand1, strand2)
+			}
+			return
+		}
+		// Ties of linked reads are resolved in favor of alignments co-located with reads of the same barcode
+		if BARCODES != nil && len(ties) > 0 {
+			cands := append([]*AlnCand{{vars_get1, vars_get2, cov_start1, cov_start2, strand1, strand2}}, ties...)
+			if c := BARCODES.Resolve(read_info.Barcode, cands); c >= 0 {
+				vars_get1, vars_get2 = cands[c].Vars1, cands[c].Vars2
+				cov_start1, cov_start2, strand1, strand2 = cands[c].CovStart1, cands[c].CovStart2, cands[c].Strand1, cands[c].Strand2
+				ties = nil
+				if read_evidence && c > 0 {
+					for _, var1 := range vars_get1 {
+						var1.RInfo = read_info1
+					}
+					for _, var2 := range vars_get2 {
+						var2.RInfo = read_info2
+					}
+				}
+			}
+		}
+		// Only read pairs whose best positions fall into the shard are accepted in sharded execution
+		if !VC.InShard(MinInt(cov_start1, cov_start2)) {
+			return
+		}
+		// Reads whose best alignments tie at different positions are resolved by the multi-mapping policy
+		ambiguous := len(ties) > 0 || (cache_aln != nil && cache_aln.Ambiguous)
+		CountMultiMap(ambiguous)
+		if !ambiguous {
+			BARCODES.Add(read_info.Barcode, MinInt(cov_start1, cov_start2))
+		}
+		SUMMARY.CountProperPair(cov_start1, len(read_info.Read1), cov_start2, len(read_info.Read2))
+		if ambiguous {
+			if PARA.Multi_map == MULTI_MAP_DISCARD {
+				return
+			} else if PARA.Multi_map == MULTI_MAP_RANDOM {
+				map_qual = 0
+			}
+		}
+		// Strands of read-ends are kept with their evidence for per-strand allele counts
+		SetStrand(vars_get1, strand1)
+		SetStrand(vars_get2, strand2)
+		for _, tie := range ties {
+			SetStrand(tie.Vars1, tie.Strand1)
+			SetStrand(tie.Vars2, tie.Strand2)
+		}
+		if len(ties) > 0 && PARA.Multi_map == MULTI_MAP_RANDOM {
+			if t := rand_gen.Intn(len(ties) + 1); t < len(ties) {
+				vars_get1, vars_get2 = ties[t].Vars1, ties[t].Vars2
+				cov_start1, cov_start2, strand1, strand2 = ties[t].CovStart1, ties[t].CovStart2, ties[t].Strand1, ties[t].Strand2
+			}
+		} else if len(ties) > 0 && PARA.Multi_map == MULTI_MAP_FRACTIONAL {
+			vars_get1, vars_get2 = SpreadTies(append([]*AlnCand{{vars_get1, vars_get2, cov_start1, cov_start2, strand1, strand2}}, ties...))
+		}
+		if read_evidence && len(ties) > 0 {
+			for _, var1 := range vars_get1 {
+				var1.RInfo = read_info1
+			}
+			for _, var2 := range vars_get2 {
+				var2.RInfo = read_info2
+			}
+		}
+		// Alternative alignments are kept with evidence of the read if required
+		var alt_aln string
+		if read_evidence && PARA.Alt_delta > 0 && cache_aln == nil {
+			alt_aln = VC.AltAlnString(alt_alns, paired_dist, cov_start1, cov_start2)
+			SetAltAln(vars_get1, alt_aln)
+			SetAltAln(vars_get2, alt_aln)
+		}
+		// Alignments are cached as edits, except evidence spread over tied alignments, which has no
+		// single alignment to replay
+		if READ_CACHE != nil && cache_aln == nil && !(len(ties) > 0 && PARA.Multi_map == MULTI_MAP_FRACTIONAL) {
+			DetachVars(vars_get1)
+			DetachVars(vars_get2)
+			READ_CACHE.Put(cache_key, &CachedAln{Edits1: VC.AlnEdits(vars_get1), Edits2: VC.AlnEdits(vars_get2), CovStart1: cov_start1,
+				CovStart2: cov_start2, Strand1: strand1, Strand2: strand2, CandNum: cand_num[loop_has_cand-1],
+				Dist1: aln_dist1, Dist2: aln_dist2, PairDist: paired_dist, Ambiguous: ambiguous, AltAln: alt_aln})
+		}
+		if PARA.All_sites || PARA.Bedgraph_file != "" {
+			VC.AddCoverage(cov_start1, len(read_info.Read1))
+			VC.AddCoverage(cov_start2, len(read_info.Read2))
+		}
+		if PARA.CNV_file != "" {
+			VC.AddWindowCoverage(cov_start1, len(read_info.Read1))
+			VC.AddWindowCoverage(cov_start2, len(read_info.Read2))
+		}
+		if PARA.Pileup_file != "" {
+			if strand1 {
+				VC.AddPileup(read_info.Read1, read_info.Qual1, cov_start1, strand1, vars_get1)
+			} else {
+				VC.AddPileup(read_info.Rev_comp_read1, read_info.Rev_qual1, cov_start1, strand1, vars_get1)
+			}
+			if strand2 {
+				VC.AddPileup(read_info.Read2, read_info.Qual2, cov_start2, strand2, vars_get2)
+			} else {
+				VC.AddPileup(read_info.Rev_comp_read2, read_info.Rev_qual2, cov_start2, strand2, vars_get2)
+			}
+		}
+		if ALN_DUMP != nil && VC.DumpSelected(read_info1, cov_start1, len(read_info.Read1), cov_start2, len(read_info.Read2)) {
+			VC.DumpAlignment(read_info, read_info1, cov_start1, cov_start2, strand1, strand2, aln_dist1, aln_dist2, map_qual, len(ties),
+				vars_get1, vars_get2)
+		}
+		read_seed := ReadSeed(PARA.Seed, read_info.Info1)
 		for _, var1 := range vars_get1 {
-			var1.MProb = map_qual
-			rid = PARA.Proc_num * int(var1.Pos) / VC.SeqLen
-			var_info[rid] <- var1
+			var1.MProb, var1.RGroup, var1.Barcode, var1.RSeed = map_qual, read_info.RGroup, read_info.Barcode, read_seed
 		}
 		for _, var2 := range vars_get2 {
-			var2.MProb = map_qual
-			rid = PARA.Proc_num * int(var2.Pos) / VC.SeqLen
-			var_info[rid] <- var2
+			var2.MProb, var2.RGroup, var2.Barcode, var2.RSeed = map_qual, read_info.RGroup, read_info.Barcode, read_seed
+		}
+		// Primer bases are soft-clipped and only amplicon inserts are called in amplicon mode
+		if AMPLICONS != nil {
+			vars_get1, vars_get2 = AMPLICONS.Trim(vars_get1, vars_get2, cov_start1, len(read_info.Read1), cov_start2,
+				len(read_info.Read2))
+		}
+		// Evidence within masked regions is dropped in drop mode
+		vars_get1, vars_get2 = MASK.Trim(vars_get1), MASK.Trim(vars_get2)
+		// Bases of overlapping chunk pairs of long reads are used as evidence once
+		if PARA.Preset != "" {
+			vars_get1, vars_get2 = TrimCycles(vars_get1, read_info.Cycles1), TrimCycles(vars_get2, read_info.Cycles2)
+		}
+		// Qualities of mismatches are capped by base alignment qualities (after realignment if required)
+		if PARA.BAQ && !PARA.Realign && !PARA.Assemble {
+			if strand1 {
+				VC.ApplyBAQ(edit_aln_info_1.baq, read_info.Read1, read_info.Qual1, cov_start1, vars_get1)
+			} else {
+				VC.ApplyBAQ(edit_aln_info_1.baq, read_info.Rev_comp_read1, read_info.Rev_qual1, cov_start1, vars_get1)
+			}
+			if strand2 {
+				VC.ApplyBAQ(edit_aln_info_1.baq, read_info.Read2, read_info.Qual2, cov_start2, vars_get2)
+			} else {
+				VC.ApplyBAQ(edit_aln_info_1.baq, read_info.Rev_comp_read2, read_info.Rev_qual2, cov_start2, vars_get2)
+			}
+		}
+		// Variants are buffered for realignment around candidate indels or local assembly if required
+		if PARA.Realign || PARA.Assemble {
+			if strand1 {
+				VC.BufferRealnRead(read_info.Read1, read_info.Qual1, cov_start1, map_qual, strand1, read_seed, vars_get1)
+			} else {
+				VC.BufferRealnRead(read_info.Rev_comp_read1, read_info.Rev_qual1, cov_start1, map_qual, strand1, read_seed, vars_get1)
+			}
+			if strand2 {
+				VC.BufferRealnRead(read_info.Read2, read_info.Qual2, cov_start2, map_qual, strand2, read_seed, vars_get2)
+			} else {
+				VC.BufferRealnRead(read_info.Rev_comp_read2, read_info.Rev_qual2, cov_start2, map_qual, strand2, read_seed, vars_get2)
+			}
+			return
+		}
+		for _, var1 := range vars_get1 {
+			VC.CollectVariant(var1)
+		}
+		for _, var2 := range vars_get2 {
+			VC.CollectVariant(var2)
 		}
 		return
 	}
+	if WARM_UP != nil {
+		return
+	}
+	// Collect structural variant signals from unaligned paired-end reads if required
+	// (by the first shard in sharded execution)
+	if PARA.SV_file != "" && (SHARD == nil || SHARD.ID == 1) {
+		VC.SearchSVSignals(read_info, seed_pos)
+	}
 	// Get unaligned paired-end reads
 	uar := new(UnAlnReadInfo)
+	uar.seeded = seeded
 	if PARA.Debug_mode {
 		uar.read_info1 = read_info1
 		uar.read_info2 = read_info2
 	}
+	if WriteUnaligned() {
+		uar.SetRead(read_info1, read_info2, read_info)
+	}
 	uar_info <- uar
 }
 
@@ -570,121 +1154,69 @@ func (VC *VarCallIndex) SearchVariantsPE(read_info *ReadInfo, edit_aln_info_1, e
 // ExtendSeeds performs alignment between extensions from seeds on reads and multigenomes
 // and determines variants from the alignment of both left and right extensions.
 //---------------------------------------------------------------------------------------------------
-func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte, edit_aln_info_1, edit_aln_info_2 *EditAlnInfo) ([]*VarInfo, int, int, float64) {
+func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte, cyc_cost []float64, edit_aln_info_1, edit_aln_info_2 *EditAlnInfo) ([]*VarInfo, int, int, float64) {
 
 	defer recoverName()
 
-	var i, j, del_len int
-	var is_var, is_del bool
-
-	l_read_flank_len := s_pos + PARA.Seed_backup
-	l_read_flank, l_qual_flank := read[:l_read_flank_len], qual[:l_read_flank_len]
-
-	l_ref_flank_del := make([]byte, 0)
-	l_ref_pos_del_map := make([]int, 0)
-	i = m_pos - 1 + PARA.Seed_backup
-	j = 0 // to check length of l_ref_flank_del
-	for j < l_read_flank_len+PARA.Indel_backup && i >= 0 {
-		if _, is_var = VC.Variants[i]; is_var {
-			if del_len, is_del = VC.DelVar[i]; is_del {
-				if del_len < j && del_len < len(l_ref_flank_del) {
-					l_ref_flank_del = l_ref_flank_del[:len(l_ref_flank_del)-del_len]
-					l_ref_pos_del_map = l_ref_pos_del_map[:len(l_ref_pos_del_map)-del_len]
-					j -= del_len
-				} else {
-					return nil, -1, -1, -1
-				}
-			}
+	// Exact-match fast path: reads matching the reference on the seed diagonal need no extension.
+	// In no-gaps mode, mismatches are also allowed on the diagonal and reads requiring gaps are skipped.
+	aligner := edit_aln_info_1.aligner
+	if var_pos, var_base, var_qual, read_pos, aln_dist, ok := aligner.DiagonalMatch(s_pos, m_pos, read, qual, cyc_cost, PARA.No_gaps, edit_aln_info_1.arena); ok {
+		var vars []*VarInfo
+		for k := 0; k < len(var_pos); k++ {
+			var_info := new(VarInfo)
+			var_info.Pos, var_info.Bases, var_info.BQual, var_info.Type = uint32(var_pos[k]), var_base[k], var_qual[k], 0
+			var_info.RPos, var_info.Cycle = ReadEndDist(read_pos[k], len(read)), read_pos[k]
+			vars = append(vars, var_info)
 		}
-		l_ref_pos_del_map = append(l_ref_pos_del_map, i)
-		l_ref_flank_del = append(l_ref_flank_del, VC.Seq[i])
-		j++
-		i--
-	}
-	l_aln_s_pos_del := i + 1
-	// Reverse l_ref_pos_del_map and l_ref_flank_del to get them in original direction
-	for i, j = 0, len(l_ref_pos_del_map)-1; i < j; i, j = i+1, j-1 {
-		l_ref_pos_del_map[i], l_ref_pos_del_map[j] = l_ref_pos_del_map[j], l_ref_pos_del_map[i]
-	}
-	for i, j = 0, len(l_ref_flank_del)-1; i < j; i, j = i+1, j-1 {
-		l_ref_flank_del[i], l_ref_flank_del[j] = l_ref_flank_del[j], l_ref_flank_del[i]
-	}
-
-	l_ref_flank_ori := make([]byte, 0)
-	l_ref_pos_ori_map := make([]int, 0)
-	l_aln_e_pos_ori := m_pos - 1 + PARA.Seed_backup
-	i = l_aln_e_pos_ori
-	j = 0 // to check length of l_ref_flank_ori
-	for j < l_read_flank_len+PARA.Indel_backup && i >= 0 {
-		l_ref_pos_ori_map = append(l_ref_pos_ori_map, i)
-		l_ref_flank_ori = append(l_ref_flank_ori, VC.Seq[i])
-		j++
-		i--
-	}
-	l_aln_s_pos_ori := i + 1
-	// Reverse l_ref_pos_ori_map and l_ref_flank_ori to get them in original direction
-	for i, j = 0, len(l_ref_pos_ori_map)-1; i < j; i, j = i+1, j-1 {
-		l_ref_pos_ori_map[i], l_ref_pos_ori_map[j] = l_ref_pos_ori_map[j], l_ref_pos_ori_map[i]
-	}
-	for i, j = 0, len(l_ref_flank_ori)-1; i < j; i, j = i+1, j-1 {
-		l_ref_flank_ori[i], l_ref_flank_ori[j] = l_ref_flank_ori[j], l_ref_flank_ori[i]
+		return vars, -1, -1, aln_dist
+	} else if PARA.No_gaps {
+		return nil, -1, -1, -1
 	}
 
+	l_read_flank_len := s_pos + PARA.Seed_backup
+	l_read_flank, l_qual_flank := read[:l_read_flank_len], qual[:l_read_flank_len]
 	seed_len := e_pos - s_pos + 1
 	r_read_flank_len := len(read) - e_pos - 1 + PARA.Seed_backup
 	r_read_flank, r_qual_flank := read[len(read)-r_read_flank_len:], qual[len(read)-r_read_flank_len:]
+	l_cyc_cost, r_cyc_cost := SubCosts(cyc_cost, 0, l_read_flank_len), SubCosts(cyc_cost, len(read)-r_read_flank_len, len(read))
 
-	r_ref_flank_del := make([]byte, 0)
-	r_ref_pos_del_map := make([]int, 0)
-	r_aln_s_pos_del := m_pos + seed_len - PARA.Seed_backup
-	i = r_aln_s_pos_del
-	j = 0 //to check length of r_ref_flank_del
-	for j < r_read_flank_len+PARA.Indel_backup && i < VC.SeqLen {
-		r_ref_pos_del_map = append(r_ref_pos_del_map, i)
-		r_ref_flank_del = append(r_ref_flank_del, VC.Seq[i])
-		if _, is_var = VC.Variants[i]; is_var {
-			if del_len, is_del = VC.DelVar[i]; is_del {
-				if del_len < r_read_flank_len-j && i+del_len < VC.SeqLen {
-					i += del_len
-				} else {
-					//continue to align without remaning part of read and ref
-					r_ref_flank_del = r_ref_flank_del[:len(r_ref_flank_del)-1]
-					break
-				}
-			}
-		}
-		j++
-		i++
-	}
-	r_ref_flank_ori := make([]byte, 0)
-	r_ref_pos_ori_map := make([]int, 0)
-	r_aln_s_pos_ori := m_pos + seed_len - PARA.Seed_backup
-	i = r_aln_s_pos_ori
-	j = 0 //to check length of r_ref_flank_ori
-	for j < r_read_flank_len+PARA.Indel_backup && i < VC.SeqLen {
-		r_ref_pos_ori_map = append(r_ref_pos_ori_map, i)
-		r_ref_flank_ori = append(r_ref_flank_ori, VC.Seq[i])
-		j++
-		i++
+	// Reference windows (reduced at known deletions and original) are taken from the cache of the goroutine
+	l_anchor, r_anchor := m_pos-1+PARA.Seed_backup, m_pos+seed_len-PARA.Seed_backup
+	l_win_del := edit_aln_info_1.windows.Get(VC, RefWindowKey{l_anchor, l_read_flank_len + PARA.Indel_backup, true, true})
+	if !l_win_del.Ok {
+		return nil, -1, -1, -1
 	}
+	l_win_ori := edit_aln_info_1.windows.Get(VC, RefWindowKey{l_anchor, l_read_flank_len + PARA.Indel_backup, true, false})
+	r_win_del := edit_aln_info_1.windows.Get(VC, RefWindowKey{r_anchor, r_read_flank_len + PARA.Indel_backup, false, true})
+	r_win_ori := edit_aln_info_1.windows.Get(VC, RefWindowKey{r_anchor, r_read_flank_len + PARA.Indel_backup, false, false})
+	l_ref_flank_del, l_ref_pos_del_map, l_aln_s_pos_del := l_win_del.Ref, l_win_del.PosMap, l_win_del.Start
+	l_ref_flank_ori, l_ref_pos_ori_map, l_aln_s_pos_ori := l_win_ori.Ref, l_win_ori.PosMap, l_win_ori.Start
+	r_ref_flank_del, r_ref_pos_del_map, r_aln_s_pos_del := r_win_del.Ref, r_win_del.PosMap, r_win_del.Start
+	r_ref_flank_ori, r_ref_pos_ori_map, r_aln_s_pos_ori := r_win_ori.Ref, r_win_ori.PosMap, r_win_ori.Start
 
 	if PARA.Debug_mode {
 		PrintComparedReadRef(l_read_flank, l_ref_flank_del, r_read_flank, r_ref_flank_del)
 		PrintComparedReadRef(l_read_flank, l_ref_flank_ori, r_read_flank, r_ref_flank_ori)
 	}
+	// Flanks are aligned with reduced (at known deletions) and original reference flanks by the backend,
+	// tasks of the goroutine are reused and their results are copied before the next extension
+	aln_tasks := &edit_aln_info_1.aln_tasks
+	aln_tasks[0] = AlnTask{Read: l_read_flank, Qual: l_qual_flank, CycCost: l_cyc_cost, Ref: l_ref_flank_del, Pos: l_aln_s_pos_del, RefPosMap: l_ref_pos_del_map, DropRows: l_win_del.DropRows, DelRef: true, Left: true, Info: edit_aln_info_1}
+	aln_tasks[1] = AlnTask{Read: r_read_flank, Qual: r_qual_flank, CycCost: r_cyc_cost, Ref: r_ref_flank_del, Pos: r_aln_s_pos_del, RefPosMap: r_ref_pos_del_map, DropRows: r_win_del.DropRows, DelRef: true, Left: false, Info: edit_aln_info_1}
+	aln_tasks[2] = AlnTask{Read: l_read_flank, Qual: l_qual_flank, CycCost: l_cyc_cost, Ref: l_ref_flank_ori, Pos: l_aln_s_pos_ori, RefPosMap: l_ref_pos_ori_map, DropRows: l_win_ori.DropRows, DelRef: false, Left: true, Info: edit_aln_info_2}
+	aln_tasks[3] = AlnTask{Read: r_read_flank, Qual: r_qual_flank, CycCost: r_cyc_cost, Ref: r_ref_flank_ori, Pos: r_aln_s_pos_ori, RefPosMap: r_ref_pos_ori_map, DropRows: r_win_ori.DropRows, DelRef: false, Left: false, Info: edit_aln_info_2}
+	ALN_BACKEND.AlignBatch(aligner, edit_aln_info_1.aln_batch)
+	l1, r1, l2, r2 := &aln_tasks[0], &aln_tasks[1], &aln_tasks[2], &aln_tasks[3]
 	l_Ham_dist_1, l_Edit_dist_1, l_bt_mat_1, l_m_1, l_n_1, l_var_pos_1, l_var_base_1, l_var_qual_1, l_var_type_1 :=
-		VC.LeftAlign(l_read_flank, l_qual_flank, l_ref_flank_del, l_aln_s_pos_del, edit_aln_info_1.l_Dist_D, edit_aln_info_1.l_Dist_IS, edit_aln_info_1.l_Dist_IT,
-			edit_aln_info_1.l_Trace_D, edit_aln_info_1.l_Trace_IS, edit_aln_info_1.l_Trace_IT, edit_aln_info_1.l_Trace_K, l_ref_pos_del_map, true)
+		l1.HamDist, l1.EditDist, l1.BtMat, l1.M, l1.N, l1.VarPos, l1.VarBase, l1.VarQual, l1.VarType
 	r_Ham_dist_1, r_Edit_dist_1, r_bt_mat_1, r_m_1, r_n_1, r_var_pos_1, r_var_base_1, r_var_qual_1, r_var_type_1 :=
-		VC.RightAlign(r_read_flank, r_qual_flank, r_ref_flank_del, r_aln_s_pos_del, edit_aln_info_1.r_Dist_D, edit_aln_info_1.r_Dist_IS, edit_aln_info_1.r_Dist_IT,
-			edit_aln_info_1.r_Trace_D, edit_aln_info_1.r_Trace_IS, edit_aln_info_1.r_Trace_IT, edit_aln_info_1.r_Trace_K, r_ref_pos_del_map, true)
+		r1.HamDist, r1.EditDist, r1.BtMat, r1.M, r1.N, r1.VarPos, r1.VarBase, r1.VarQual, r1.VarType
 
 	l_Ham_dist_2, l_Edit_dist_2, l_bt_mat_2, l_m_2, l_n_2, l_var_pos_2, l_var_base_2, l_var_qual_2, l_var_type_2 :=
-		VC.LeftAlign(l_read_flank, l_qual_flank, l_ref_flank_ori, l_aln_s_pos_ori, edit_aln_info_2.l_Dist_D, edit_aln_info_2.l_Dist_IS, edit_aln_info_2.l_Dist_IT,
-			edit_aln_info_2.l_Trace_D, edit_aln_info_2.l_Trace_IS, edit_aln_info_2.l_Trace_IT, edit_aln_info_2.l_Trace_K, l_ref_pos_ori_map, false)
+		l2.HamDist, l2.EditDist, l2.BtMat, l2.M, l2.N, l2.VarPos, l2.VarBase, l2.VarQual, l2.VarType
 	r_Ham_dist_2, r_Edit_dist_2, r_bt_mat_2, r_m_2, r_n_2, r_var_pos_2, r_var_base_2, r_var_qual_2, r_var_type_2 :=
-		VC.RightAlign(r_read_flank, r_qual_flank, r_ref_flank_ori, r_aln_s_pos_ori, edit_aln_info_2.r_Dist_D, edit_aln_info_2.r_Dist_IS, edit_aln_info_2.r_Dist_IT,
-			edit_aln_info_2.r_Trace_D, edit_aln_info_2.r_Trace_IS, edit_aln_info_2.r_Trace_IT, edit_aln_info_2.r_Trace_K, r_ref_pos_ori_map, false)
+		r2.HamDist, r2.EditDist, r2.BtMat, r2.M, r2.N, r2.VarPos, r2.VarBase, r2.VarQual, r2.VarType
 
 	aln_dist := l_Ham_dist_1 + l_Edit_dist_1 + r_Ham_dist_1 + r_Edit_dist_1
 	del_ref := true
@@ -705,8 +1237,8 @@ func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte,
 	}
 	if aln_dist <= PARA.Dist_thres {
 		if l_m > 0 && l_n > 0 {
-			l_pos, l_base, l_qual, l_type := VC.LeftAlignEditTraceBack(l_read_flank, l_qual_flank, l_ref_flank, l_m, l_n, l_aln_s_pos, l_bt_mat,
-				edit_aln_info.l_Trace_D, edit_aln_info.l_Trace_IS, edit_aln_info.l_Trace_IT, edit_aln_info.l_Trace_K, l_ref_pos_map, del_ref)
+			l_pos, l_base, l_qual, l_type := aligner.LeftAlignEditTraceBack(l_read_flank, l_qual_flank, l_ref_flank, l_m, l_n, l_aln_s_pos, l_bt_mat,
+				edit_aln_info.l_Trace_D, edit_aln_info.l_Trace_IS, edit_aln_info.l_Trace_IT, edit_aln_info.l_Trace_K, l_ref_pos_map, del_ref, edit_aln_info.arena)
 			if PARA.Debug_mode {
 				PrintVarInfo("LeftAlnitTraceBack, variant info", l_pos, l_base, l_qual)
 			}
@@ -719,8 +1251,8 @@ func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte,
 			PrintMatchTraceInfo(m_pos, l_aln_s_pos, aln_dist, l_var_pos, read)
 		}
 		if r_m > 0 && r_n > 0 {
-			r_pos, r_base, r_qual, r_type := VC.RightAlignEditTraceBack(r_read_flank, r_qual_flank, r_ref_flank, r_m, r_n, r_aln_s_pos, r_bt_mat,
-				edit_aln_info.r_Trace_D, edit_aln_info.r_Trace_IS, edit_aln_info.r_Trace_IT, edit_aln_info.r_Trace_K, r_ref_pos_map, del_ref)
+			r_pos, r_base, r_qual, r_type := aligner.RightAlignEditTraceBack(r_read_flank, r_qual_flank, r_ref_flank, r_m, r_n, r_aln_s_pos, r_bt_mat,
+				edit_aln_info.r_Trace_D, edit_aln_info.r_Trace_IS, edit_aln_info.r_Trace_IT, edit_aln_info.r_Trace_K, r_ref_pos_map, del_ref, edit_aln_info.arena)
 			if PARA.Debug_mode {
 				PrintVarInfo("RightAlnEditTraceBack, variant info", r_pos, r_base, r_qual)
 			}
@@ -737,11 +1269,13 @@ func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte,
 		for k = 0; k < len(l_var_pos); k++ {
 			var_info := new(VarInfo)
 			var_info.Pos, var_info.Bases, var_info.BQual, var_info.Type = uint32(l_var_pos[k]), l_var_base[k], l_var_qual[k], l_var_type[k]
+			var_info.RPos, var_info.Cycle = ReadEndDist(s_pos+l_var_pos[k]-m_pos, len(read)), s_pos+l_var_pos[k]-m_pos
 			vars_arr = append(vars_arr, var_info)
 		}
 		for k = 0; k < len(r_var_pos); k++ {
 			var_info := new(VarInfo)
 			var_info.Pos, var_info.Bases, var_info.BQual, var_info.Type = uint32(r_var_pos[k]), r_var_base[k], r_var_qual[k], r_var_type[k]
+			var_info.RPos, var_info.Cycle = ReadEndDist(s_pos+r_var_pos[k]-m_pos, len(read)), s_pos+r_var_pos[k]-m_pos
 			vars_arr = append(vars_arr, var_info)
 		}
 		return vars_arr, l_aln_s_pos, r_aln_s_pos, aln_dist
@@ -749,130 +1283,94 @@ func (VC *VarCallIndex) ExtendSeeds(s_pos, e_pos, m_pos int, read, qual []byte,
 	return nil, -1, -1, -1
 }
 
+// Hard cap on the number of aligned reads used at each position: positions in collapsed repeats attract
+// huge numbers of reads, whose aligned bases are kept until all reads are aligned
+const MAX_EVIDENCE_NUM = 10000
+
 //---------------------------------------------------------------------------------------------------
-// UpdateVariantProb updates probablilities of variants at a variant location using Bayesian update.
+// DepthCap returns the maximum number of aligned reads used at each position: Max_depth if it is
+// given and below the hard cap, MAX_EVIDENCE_NUM otherwise.
+//---------------------------------------------------------------------------------------------------
+func DepthCap() int {
+	if PARA.Max_depth > 0 && PARA.Max_depth < MAX_EVIDENCE_NUM {
+		return PARA.Max_depth
+	}
+	return MAX_EVIDENCE_NUM
+}
+
+//---------------------------------------------------------------------------------------------------
+// Downsampled checks if aligned reads at a variant location exceeded the cap and only a sample of
+// them was used (flagged by DS in INFO).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) Downsampled(rid int, pos uint32) bool {
+	return VC.Calls[rid].VarDepth[pos] > DepthCap()
+}
+
+//---------------------------------------------------------------------------------------------------
+// CollectVariant keeps an aligned base in the reservoir of its variant location and sends it to the
+// evidence writer (if required). It is called by goroutines which align reads, updates of the same
+// location are serialized by the lock of its shard only for the short update of the reservoir.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CollectVariant(var_info *VarInfo) {
+	start_time := TIMING.Start()
+	// Kept bases outlive their reads
+	DetachVars([]*VarInfo{var_info})
+	VC.UpdateVariantProb(var_info)
+	TIMING.Add(TIMING_POSTERIOR, start_time, 1)
+	if EVIDENCE != nil {
+		EVIDENCE <- var_info
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// UpdateVariantProb adds an aligned base to the reservoir of its variant location (see downsample.go),
+// statistics and likelihoods of the location are updated with kept bases when alignment is done (see
+// ApplyKeptBases). New variant locations are added at once, since they are used by alignment.
 //---------------------------------------------------------------------------------------------------
 func (VC *VarCallIndex) UpdateVariantProb(var_info *VarInfo) {
 	pos := var_info.Pos
-	//vtype := var_info.Type
-	vbase := strings.Split(string(var_info.Bases), "|")
 	rid := PARA.Proc_num * int(pos) / VC.SeqLen
-	MUT.Lock()
-	// if new variant locations
-	if _, var_call_exist := VarCall[rid].VarProb[pos]; !var_call_exist {
-		VarCall[rid].VarProb[pos] = make(map[string]float64)
-		if len(vbase[0]) == len(vbase[1]) { // SUB
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[0]] = 1 - 1.5*NEW_SNP_RATE
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[1]] = NEW_SNP_RATE
-			VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = 0.5 * NEW_SNP_RATE
-		} else if len(vbase[0]) < len(vbase[1]) { // INS
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[0]] = 1 - 1.5*NEW_INDEL_RATE
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[1]] = NEW_INDEL_RATE
-			VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = 0.5 * NEW_INDEL_RATE
-		} else {
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[0]] = 0.5 * NEW_INDEL_RATE
-			VarCall[rid].VarProb[pos][vbase[0]+"|"+vbase[1]] = NEW_INDEL_RATE
-			VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = 1 - 1.5*NEW_INDEL_RATE
-		}
-		mapMutex.Lock()
-		VarCall[rid].VarType[pos] = make(map[string]int)
-		if len(vbase[0]) == len(vbase[1]) { //SUB
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[0]] = 0
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[1]] = 0
-			VarCall[rid].VarType[pos][vbase[1]+"|"+vbase[1]] = 0
-		} else if len(vbase[0]) < len(vbase[1]) { //INS
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[0]] = 0
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[1]] = 1
-			VarCall[rid].VarType[pos][vbase[1]+"|"+vbase[1]] = 1
-		} else { //DEL
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[0]] = 2
-			VarCall[rid].VarType[pos][vbase[0]+"|"+vbase[1]] = 2
-			VarCall[rid].VarType[pos][vbase[1]+"|"+vbase[1]] = 0
-		}
-		mapMutex.Unlock()
+	// Variant calls are sharded by position ranges, updates of different shards run concurrently
+	VC.Calls[rid].mut.Lock()
+	// if new variant locations, priors of genotypes are set up from alleles of aligned reads when
+	// posterior probabilities are computed
+	if !VC.Calls[rid].VarProb.Has(pos) {
+		VC.Calls[rid].VarProb.Set(pos, nil)
+		VC.Calls[rid].Sites.Add(pos)
 		if PARA.Debug_mode {
-			VarCall[rid].ChrDis[pos] = make(map[string][]int)
-			VarCall[rid].ChrDiff[pos] = make(map[string][]int)
-			VarCall[rid].MapProb[pos] = make(map[string][]float64)
-			VarCall[rid].AlnProb[pos] = make(map[string][]float64)
-			VarCall[rid].ChrProb[pos] = make(map[string][]float64)
-			VarCall[rid].StartPos1[pos] = make(map[string][]int)
-			VarCall[rid].StartPos2[pos] = make(map[string][]int)
-			VarCall[rid].Strand1[pos] = make(map[string][]bool)
-			VarCall[rid].Strand2[pos] = make(map[string][]bool)
-			VarCall[rid].VarBQual[pos] = make(map[string][][]byte)
-			VarCall[rid].ReadInfo[pos] = make(map[string][][]byte)
-		}
-	} else { // if existing variant locations
-		var l1, l2 float64
-		var b, hap string
-		hap_map := make(map[string]bool)
-		for b, _ = range VarCall[rid].VarProb[pos] {
-			hap_arr := strings.Split(b, "|")
-			hap_map[hap_arr[0]], hap_map[hap_arr[1]] = true, true
-		}
-		// if new variants at existing locations
-		if _, var_exist := hap_map[vbase[1]]; !var_exist {
-			l1 = float64(len(hap_map) + 1)
-			l2 = float64(len(VarCall[rid].VarProb[pos]))
-			min_prob := 1.0
-			for b, _ = range VarCall[rid].VarProb[pos] {
-				if min_prob > VarCall[rid].VarProb[pos][b] {
-					min_prob = VarCall[rid].VarProb[pos][b]
-				}
-			}
-			mapMutex.Lock()
-			if len(vbase[0]) == len(vbase[1]) {
-				for b, _ = range VarCall[rid].VarProb[pos] {
-					VarCall[rid].VarProb[pos][b] = VarCall[rid].VarProb[pos][b] - (l1/l2)*min_prob*NEW_SNP_RATE
-				}
-				for hap, _ = range hap_map {
-					VarCall[rid].VarProb[pos][hap+"|"+vbase[1]] = min_prob * NEW_SNP_RATE
-				}
-				VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = min_prob * NEW_SNP_RATE
-			} else if len(vbase[0]) < len(vbase[1]) {
-				for b, _ = range VarCall[rid].VarProb[pos] {
-					VarCall[rid].VarProb[pos][b] = VarCall[rid].VarProb[pos][b] - (l1/l2)*min_prob*NEW_INDEL_RATE
-				}
-				for hap, _ = range hap_map {
-					VarCall[rid].VarProb[pos][hap+"|"+vbase[1]] = min_prob * NEW_INDEL_RATE
-					VarCall[rid].VarType[pos][hap+"|"+vbase[1]] = 1
-				}
-				VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = min_prob * NEW_INDEL_RATE
-				VarCall[rid].VarType[pos][vbase[1]+"|"+vbase[1]] = 1
-			} else {
-				for b, _ = range VarCall[rid].VarProb[pos] {
-					VarCall[rid].VarProb[pos][b] = VarCall[rid].VarProb[pos][b] - (l1/l2)*min_prob*NEW_INDEL_RATE
-				}
-				for hap, _ = range hap_map {
-					VarCall[rid].VarProb[pos][hap+"|"+vbase[1]] = min_prob * NEW_INDEL_RATE
-					VarCall[rid].VarType[pos][hap+"|"+vbase[1]] = 2
-				}
-				VarCall[rid].VarProb[pos][vbase[1]+"|"+vbase[1]] = min_prob * NEW_INDEL_RATE
-				VarCall[rid].VarType[pos][vbase[1]+"|"+vbase[1]] = 2
-			}
-			mapMutex.Unlock()
+			VC.Calls[rid].ChrDis[pos] = make(map[string][]int)
+			VC.Calls[rid].ChrDiff[pos] = make(map[string][]int)
+			VC.Calls[rid].MapProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].AlnProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].ChrProb[pos] = make(map[string][]float64)
+			VC.Calls[rid].StartPos1[pos] = make(map[string][]int)
+			VC.Calls[rid].StartPos2[pos] = make(map[string][]int)
+			VC.Calls[rid].Strand1[pos] = make(map[string][]bool)
+			VC.Calls[rid].Strand2[pos] = make(map[string][]bool)
+			VC.Calls[rid].VarBQual[pos] = make(map[string][][]byte)
+			VC.Calls[rid].ReadInfo[pos] = make(map[string][][]byte)
 		}
 	}
-	if _, var_num_exist := VarCall[rid].VarRNum[pos]; !var_num_exist {
-		VarCall[rid].VarRNum[pos] = make(map[string]int)
-	}
-	VarCall[rid].VarRNum[pos][string(var_info.Bases)] += 1
-	if PARA.Debug_mode {
-		var_str := string(var_info.Bases)
-		VarCall[rid].ChrDis[pos][var_str] = append(VarCall[rid].ChrDis[pos][var_str], var_info.CDis)
-		VarCall[rid].ChrDiff[pos][var_str] = append(VarCall[rid].ChrDiff[pos][var_str], var_info.CDiff)
-		VarCall[rid].MapProb[pos][var_str] = append(VarCall[rid].MapProb[pos][var_str], var_info.MProb)
-		VarCall[rid].AlnProb[pos][var_str] = append(VarCall[rid].AlnProb[pos][var_str], var_info.AProb)
-		VarCall[rid].ChrProb[pos][var_str] = append(VarCall[rid].ChrProb[pos][var_str], var_info.IProb)
-		VarCall[rid].StartPos1[pos][var_str] = append(VarCall[rid].StartPos1[pos][var_str], var_info.SPos1)
-		VarCall[rid].StartPos2[pos][var_str] = append(VarCall[rid].StartPos2[pos][var_str], var_info.SPos2)
-		VarCall[rid].Strand1[pos][var_str] = append(VarCall[rid].Strand1[pos][var_str], var_info.Strand1)
-		VarCall[rid].Strand2[pos][var_str] = append(VarCall[rid].Strand2[pos][var_str], var_info.Strand2)
-		VarCall[rid].VarBQual[pos][var_str] = append(VarCall[rid].VarBQual[pos][var_str], var_info.BQual)
-		VarCall[rid].ReadInfo[pos][var_str] = append(VarCall[rid].ReadInfo[pos][var_str], var_info.RInfo)
+	// All aligned reads are counted to flag the position as downsampled, at most DepthCap() of them
+	// (chosen by priorities of reads) are kept
+	VC.Calls[rid].VarDepth[pos]++
+	R := VC.Calls[rid].Kept[pos]
+	if R == nil {
+		R = new(Reservoir)
+		VC.Calls[rid].Kept[pos] = R
 	}
+	R.Add(BasePriority(var_info.RSeed, pos), var_info, DepthCap())
+	VC.Calls[rid].mut.Unlock()
+}
 
+//---------------------------------------------------------------------------------------------------
+// ApplyBase updates statistics of variants at a variant location of shard rid with a kept aligned
+// base, its likelihoods given genotypes are accumulated for computing posterior probabilities at
+// output. Bases of a shard are applied by one goroutine.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ApplyBase(rid int, pos uint32, var_info *VarInfo) {
+	//vtype := var_info.Type
+	vbase := strings.Split(string(var_info.Bases), "|")
 	pm := 0.0
 	for _, q := range var_info.BQual {
 		pm += Q2P[q]
@@ -884,56 +1382,157 @@ func (VC *VarCallIndex) UpdateVariantProb(var_info *VarInfo) {
 	}
 	pi = pi / float64(len(var_info.BQual))
 	pd := L2E[1]
-	p_a := 0.0
-	p_ab := make(map[string]float64)
-	_, is_known_del := VC.DelVar[int(pos)]
-	if PARA.Debug_mode {
-		//log.Println("Before: pos, var_prof, vbase, pm, pi, pd", pos, VarCall[rid].VarProb[pos], vbase, pm, pi, pd, string(var_info.RInfo))
-	}
-	for b, p_b := range VarCall[rid].VarProb[pos] {
-		d := strings.Split(b, "|")
-		if len(vbase[0]) > len(vbase[1]) { //DEL
-			if vbase[0] == d[0] && vbase[0] == d[1] {
-				p_ab[b] = pm
-			} else if vbase[0] != d[0] && vbase[0] != d[1] {
-				p_ab[b] = pd
-			} else {
-				p_ab[b] = pm/2.0 + pd/2.0
-			}
+	// Error rates depend on reference context if context error model is used
+	if CONTEXT != nil {
+		if len(vbase[0]) != len(vbase[1]) {
+			factor := VC.IndelErrFactor(int(pos))
+			pd, pi = math.Min(pd*factor, 1), math.Min(pi*factor, 1)
 		} else {
-			if is_known_del { //Known DEL
-				if len(vbase[0]) == len(vbase[1]) { //got SNP
-					if string(vbase[1][0]) == d[0] && string(vbase[1][0]) == d[1] {
-						p_ab[b] = pm
-					} else if string(vbase[1][0]) != d[0] && string(vbase[1][0]) != d[1] {
-						p_ab[b] = pd
-					} else {
-						p_ab[b] = pm/2.0 + pd/2.0
-					}
-				}
-			} else {
-				if vbase[1] == d[0] && vbase[1] == d[1] {
-					p_ab[b] = pm
-				} else if vbase[1] != d[0] && vbase[1] != d[1] {
-					p_ab[b] = pi
-				} else {
-					p_ab[b] = pm/2.0 + pi/2.0
-				}
+			pi = math.Min(pi*VC.SubErrFactor(int(pos)), 1)
+		}
+	}
+	// Substitution error rates also depend on the sequencing cycle if per-cycle error model is used
+	if CYCLE != nil && len(vbase[0]) == len(vbase[1]) {
+		pi = math.Min(pi*CYCLE.ErrFactor(var_info.Cycle), 1)
+	}
+	// Likelihoods of the read given genotypes only depend on whether its key allele is on both, one or
+	// none of the haplotypes, they are summed per key allele (posteriors are computed at output)
+	key, p_both, p_none := vbase[1], pm, pi
+	if len(vbase[0]) > len(vbase[1]) { //DEL
+		key, p_none = vbase[0], pd
+	} else if _, is_known_del := VC.DelVar[int(pos)]; is_known_del { //Known DEL
+		if len(vbase[0]) == len(vbase[1]) { //got SNP
+			key, p_none = string(vbase[1][0]), pd
+		} else {
+			key, p_both, p_none = "", 0, 0
+		}
+	}
+	site := VC.Calls[rid].Sites.Get(pos)
+	site.RNum.Add(string(var_info.Bases), 1)
+	if var_info.Rev {
+		site.RevNum.Add(string(var_info.Bases), 1)
+	}
+	if MultiSample() {
+		if _, sample_exist := VC.Calls[rid].SampleRNum[pos]; !sample_exist {
+			VC.Calls[rid].SampleRNum[pos] = make([]map[string]int, len(SAMPLES))
+			VC.Calls[rid].SampleRev[pos] = make([]map[string]int, len(SAMPLES))
+			VC.Calls[rid].SampleLike[pos] = make([]map[string]float64, len(SAMPLES))
+			VC.Calls[rid].SampleLikeStat[pos] = make([]*caller.SiteLike, len(SAMPLES))
+			for s := 0; s < len(SAMPLES); s++ {
+				VC.Calls[rid].SampleRNum[pos][s] = make(map[string]int)
+				VC.Calls[rid].SampleRev[pos][s] = make(map[string]int)
+				VC.Calls[rid].SampleLikeStat[pos][s] = new(caller.SiteLike)
 			}
 		}
-		p_a += p_b * p_ab[b]
-		if PARA.Debug_mode {
-			//log.Println("Update: b, p_b, p_ab[b], p_a", b, p_b, p_ab[b], p_a)
+		VC.Calls[rid].SampleRNum[pos][SampleIndex(var_info.RGroup)][string(var_info.Bases)] += 1
+		AddRevNum(VC.Calls[rid].SampleRev[pos][SampleIndex(var_info.RGroup)], var_info)
+	}
+	site.Stat.Add(var_info, vbase[0] == vbase[1])
+	if PARA.Support_file != "" {
+		if _, var_reads_exist := VC.Calls[rid].VarReads[pos]; !var_reads_exist {
+			VC.Calls[rid].VarReads[pos] = make(map[string][][]byte)
 		}
+		AddReadName(VC.Calls[rid].VarReads[pos], var_info)
 	}
-	for b, p_b := range VarCall[rid].VarProb[pos] {
-		VarCall[rid].VarProb[pos][b] = p_b * p_ab[b] / p_a
+	if BARCODES != nil {
+		if _, var_bc_exist := VC.Calls[rid].VarBarcode[pos]; !var_bc_exist {
+			VC.Calls[rid].VarBarcode[pos] = make(map[string][]uint32)
+		}
+		AddBarcode(VC.Calls[rid].VarBarcode[pos], var_info)
 	}
 	if PARA.Debug_mode {
-		//log.Println("After:", VarCall[rid].VarProb[pos])
-		//log.Println()
+		var_str := string(var_info.Bases)
+		VC.Calls[rid].ChrDis[pos][var_str] = append(VC.Calls[rid].ChrDis[pos][var_str], var_info.CDis)
+		VC.Calls[rid].ChrDiff[pos][var_str] = append(VC.Calls[rid].ChrDiff[pos][var_str], var_info.CDiff)
+		VC.Calls[rid].MapProb[pos][var_str] = append(VC.Calls[rid].MapProb[pos][var_str], var_info.MProb)
+		VC.Calls[rid].AlnProb[pos][var_str] = append(VC.Calls[rid].AlnProb[pos][var_str], var_info.AProb)
+		VC.Calls[rid].ChrProb[pos][var_str] = append(VC.Calls[rid].ChrProb[pos][var_str], var_info.IProb)
+		VC.Calls[rid].StartPos1[pos][var_str] = append(VC.Calls[rid].StartPos1[pos][var_str], var_info.SPos1)
+		VC.Calls[rid].StartPos2[pos][var_str] = append(VC.Calls[rid].StartPos2[pos][var_str], var_info.SPos2)
+		VC.Calls[rid].Strand1[pos][var_str] = append(VC.Calls[rid].Strand1[pos][var_str], var_info.Strand1)
+		VC.Calls[rid].Strand2[pos][var_str] = append(VC.Calls[rid].Strand2[pos][var_str], var_info.Strand2)
+		VC.Calls[rid].VarBQual[pos][var_str] = append(VC.Calls[rid].VarBQual[pos][var_str], append([]byte(nil), var_info.BQual...))
+		VC.Calls[rid].ReadInfo[pos][var_str] = append(VC.Calls[rid].ReadInfo[pos][var_str], var_info.RInfo)
+	}
+	site.Like.Allele(key).Add(p_both, p_none, var_info.TieNum)
+	if MultiSample() {
+		VC.Calls[rid].SampleLikeStat[pos][SampleIndex(var_info.RGroup)].Allele(key).Add(p_both, p_none, var_info.TieNum)
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// PhredLikelihoods returns Phred-scaled genotype likelihoods (PL, normalized so that the most likely
+// genotype has value 0) of genotypes REF/REF, REF/ALT and ALT/ALT of a variant call, given its
+// haplotypes (REF and ALT at haploid positions). The empty string is returned if any of these
+// genotypes is not available.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) PhredLikelihoods(rid int, pos uint32, hap_arr []string) string {
+	var_like, ok := VC.Calls[rid].VarLike.Get(pos)
+	if !ok {
+		return ""
+	}
+	likes, ok := GenotypeLikes(var_like.Map(), hap_arr)
+	if !ok {
+		return ""
+	}
+	if IsHaploid(int(pos)) {
+		likes = HaploidLikes(likes)
+	}
+	return PLString(likes)
+}
+
+//---------------------------------------------------------------------------------------------------
+// GenotypeLikes returns log10 likelihoods of genotypes REF/REF, REF/ALT and ALT/ALT of a variant call
+// given its haplotypes, and false if any of these genotypes is not available.
+//---------------------------------------------------------------------------------------------------
+func GenotypeLikes(var_like map[string]float64, hap_arr []string) ([]float64, bool) {
+	if var_like == nil {
+		return nil, false
+	}
+	alt, ref := hap_arr[1], hap_arr[0]
+	if ref == alt {
+		// homozygous calls: the reference allele is the other allele of heterozygous genotypes
+		ref = ""
+		for b, _ := range var_like {
+			if d := strings.Split(b, "|"); d[1] == alt && d[0] != alt {
+				ref = d[0]
+				break
+			}
+		}
+	}
+	l_rr, ok_rr := var_like[ref+"|"+ref]
+	l_ra, ok_ra := var_like[ref+"|"+alt]
+	l_aa, ok_aa := var_like[alt+"|"+alt]
+	if ref == "" || !ok_rr || !ok_ra || !ok_aa {
+		return nil, false
+	}
+	return []float64{l_rr, l_ra, l_aa}, true
+}
+
+//---------------------------------------------------------------------------------------------------
+// PLString returns Phred-scaled likelihoods normalized so that the most likely genotype has value 0.
+//---------------------------------------------------------------------------------------------------
+func PLString(likes []float64) string {
+	max_like := math.Inf(-1)
+	for _, l := range likes {
+		max_like = math.Max(max_like, l)
+	}
+	pl := make([]string, len(likes))
+	for i, l := range likes {
+		pl[i] = strconv.Itoa(int(math.Floor(-10*(l-max_like) + 0.5)))
 	}
-	MUT.Unlock()
+	return strings.Join(pl, ",")
+}
+
+//---------------------------------------------------------------------------------------------------
+// ChrLoc returns name of the chromosome and 1-based position on the chromosome of a position on the multigenome.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) ChrLoc(pos int) (string, int) {
+	chr_id := sort.Search(len(VC.ChrPos), func(i int) bool { return VC.ChrPos[i] > pos }) - 1
+	if chr_id < 0 {
+		return "", pos + 1
+	}
+	return string(VC.ChrName[chr_id]), pos + 1 - VC.ChrPos[chr_id]
 }
 
 //---------------------------------------------------------------------------------------------------
@@ -943,39 +1542,180 @@ func (VC *VarCallIndex) OutputVarCalls() {
 	log.Printf("----------------------------------------------------------------------------------------")
 	log.Printf("Outputing variant calls...")
 	start_time := time.Now()
-	f, e := os.OpenFile(PARA.Var_call_file, os.O_APPEND|os.O_WRONLY, 0666)
-	if e != nil {
-		log.Panicf("Error: %s", e)
+	if PARA.Support_file != "" {
+		SUPPORT = NewSupportReads()
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
+	if PARA.Per_contig {
+		contig_files := VC.WriteContigCalls()
+		log.Printf("Variant calls of contigs are written to per-contig files:\t%s", ContigFilePattern(PARA.Var_call_file))
+		if PARA.Merge_contigs {
+			MergeContigCalls(PARA.Var_call_file, contig_files)
+		} else if e := os.Remove(PARA.Var_call_file); e != nil {
+			log.Panicf("Error: %s", e)
+		}
+	} else {
+		w := AppendOutput(PARA.Var_call_file)
+		VC.WriteVarCalls(w.Writer)
+		w.Close()
+	}
+	if PARA.Min_qual > 0 {
+		log.Printf("Number of variant calls omitted for QUAL < %g:\t%d", PARA.Min_qual, SUMMARY.LowQualNum)
+	}
+	if SUPPORT != nil {
+		SUPPORT.Write(PARA.Support_file)
+		SUPPORT = nil
+	}
+	if PARA.Learn_context_file != "" {
+		VC.LearnContextModel().Save(PARA.Learn_context_file)
+		log.Printf("Context error table learned from aligned reads is written to: %s", PARA.Learn_context_file)
+	}
+	output_var_time := time.Since(start_time)
+	if PARA.Debug_mode {
+		PrintMemStats("Memstats after outputing variant calls")
+		pprof.StopCPUProfile()
+		CPU_FILE.Close()
+		MEM_FILE.Close()
+	}
+	log.Printf("Time for outputing variant calls:\t%s", output_var_time)
+	SUMMARY.AddStageTime("output", output_var_time)
+	TIMING.Record("output", output_var_time, SUMMARY.EmittedNum, "variant calls")
+	if PARA.Summary_file != "" {
+		SUMMARY.Write(PARA.Summary_file)
+	}
+	if PARA.Calib_report != "" {
+		WriteCalibration(PARA.Var_call_file, PARA.Calib_truth, PARA.Calib_bed, PARA.Calib_report)
+	}
+	log.Printf("Finish outputing variant calls.")
+	log.Printf("------------------------------------------------------")
+	if PARA.Per_contig && !PARA.Merge_contigs {
+		log.Printf("Check results in the files: %s", ContigFilePattern(PARA.Var_call_file))
+	} else {
+		log.Printf("Check results in the file: %s", PARA.Var_call_file)
+	}
+}
 
-	var var_pos uint32
+//---------------------------------------------------------------------------------------------------
+// WriteVarCalls determines variant calls and writes them in VCF format (without header).
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteVarCalls(w *bufio.Writer) {
+	VC.FormatRegions(VC.CallPositions(), VC.OutputRegions(false), func(r int, calls []byte) {
+		w.Write(calls)
+	})
+}
+
+//---------------------------------------------------------------------------------------------------
+// CallPositions computes posterior probabilities of genotypes and returns sorted positions of
+// variant calls.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) CallPositions() []int {
+	VC.ComputePosteriors()
 	Var_Pos := make([]int, 0)
 	for i := 0; i < PARA.Proc_num; i++ {
-		for var_pos, _ = range VarCall[i].VarProb {
-			Var_Pos = append(Var_Pos, int(var_pos))
-		}
+		Var_Pos = VC.Calls[i].VarProb.Positions(Var_Pos)
 	}
 	sort.Ints(Var_Pos)
-	var var_base, var_call, str_aln, str_qual, str_info, str_format string
+	return Var_Pos
+}
+
+//---------------------------------------------------------------------------------------------------
+// OutputRegions partitions the multigenome into regions of OUTPUT_REGION_LEN positions, which are
+// also split at starts of chromosomes if by_chr is true.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) OutputRegions(by_chr bool) [][2]int {
+	bounds := []int{0, VC.SeqLen}
+	if by_chr {
+		bounds = append(append([]int{}, VC.ChrPos...), VC.SeqLen)
+	}
+	regions := make([][2]int, 0)
+	for i := 0; i+1 < len(bounds); i++ {
+		for start := bounds[i]; start < bounds[i+1]; start += OUTPUT_REGION_LEN {
+			regions = append(regions, [2]int{start, MinInt(start+OUTPUT_REGION_LEN, bounds[i+1])})
+		}
+	}
+	return regions
+}
+
+//---------------------------------------------------------------------------------------------------
+// FormatRegions formats variant calls at sorted positions Var_Pos of regions, and passes calls of
+// each region (in VCF format) to write in order of regions. Calls of Proc_num regions are formatted
+// in parallel at a time.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) FormatRegions(Var_Pos []int, regions [][2]int, write func(r int, calls []byte)) {
+	// Buffers and writers of regions are allocated once and reused for all regions
+	region_buf := make([]bytes.Buffer, PARA.Proc_num)
+	region_w := make([]*bufio.Writer, PARA.Proc_num)
+	for k := 0; k < PARA.Proc_num; k++ {
+		region_w[k] = bufio.NewWriterSize(&region_buf[k], OUTPUT_BUF_SIZE)
+	}
+	for r := 0; r < len(regions); r += PARA.Proc_num {
+		var wg sync.WaitGroup
+		for k := 0; k < PARA.Proc_num && r+k < len(regions); k++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				start, end := regions[r+k][0], regions[r+k][1]
+				region_buf[k].Reset()
+				region_w[k].Reset(&region_buf[k])
+				VC.WriteRegionCalls(region_w[k], Var_Pos[sort.SearchInts(Var_Pos, start):sort.SearchInts(Var_Pos, end)], start, end)
+				region_w[k].Flush()
+			}(k)
+		}
+		wg.Wait()
+		for k := 0; k < PARA.Proc_num && r+k < len(regions); k++ {
+			write(r+k, region_buf[k].Bytes())
+		}
+	}
+}
+
+//---------------------------------------------------------------------------------------------------
+// WriteRegionCalls writes variant calls at sorted positions Var_Pos of the region [start, end).
+// INFO fields and records are formatted by appending to byte buffers which are reused for all calls
+// of the region.
+//---------------------------------------------------------------------------------------------------
+func (VC *VarCallIndex) WriteRegionCalls(w *bufio.Writer, Var_Pos []int, start, end int) {
+	var var_pos uint32
+	var var_base, var_call, str_aln, str_qual, str_info, str_format, str_pl string
 	var var_arr, hap_arr []string
 	var line_aln, line_base, line_ivc []string
-	var p, var_prob, var_call_prob, map_prob, comb_prob float64
+	var p, var_prob, var_call_prob, var_qual, map_prob, comb_prob float64
 	var i, chr_id, var_num, var_depth, read_depth int
 	var is_known_var, is_known_del bool
+	var strand_counts [4]int
+	info_buf, line_buf := make([]byte, 0, OUTPUT_LINE_LEN), make([]byte, 0, OUTPUT_LINE_LEN)
+	// Heterozygous calls of linked reads are phased by barcodes
+	var phased map[int]*PhasedGT
+	if BARCODES != nil && !MultiSample() {
+		phased = VC.PhaseRegion(Var_Pos)
+	}
+	next_pos := start // next position to be checked for homozygous-reference calls in emit-all-sites mode
 	for _, pos := range Var_Pos {
 		var_pos = uint32(pos)
 		rid := PARA.Proc_num * pos / VC.SeqLen
+		if PARA.All_sites {
+			VC.WriteRefSites(w, next_pos, pos)
+			next_pos = pos + 1
+		}
 		// Get variant call by considering maximum prob
 		var_call_prob = 0
-		for var_base, var_prob = range VarCall[rid].VarProb[var_pos] {
-			if var_call_prob < var_prob {
+		var_probs, _ := VC.Calls[rid].VarProb.Get(var_pos)
+		for i, var_base = range var_probs.Gts {
+			if var_prob = var_probs.Vals[i]; var_call_prob < var_prob {
 				var_call_prob = var_prob
 				var_call = var_base
 			}
 		}
-		if _, var_num_exist := VarCall[rid].VarRNum[var_pos]; !var_num_exist { // do not report variants without aligned reads (happen at known locations)
+		site := VC.Calls[rid].Sites.Get(var_pos)
+		if !site.Aligned() { // do not report variants without aligned reads (happen at known locations)
+			if PARA.All_sites {
+				VC.WriteRefSites(w, pos, pos+1)
+			}
+			continue
+		}
+		// Contigs of heteroplasmy mode are called with allele fractions instead of genotypes
+		if IsHeteroplasmic(pos) {
+			if !VC.WriteHeteroCall(w, rid, var_pos) && PARA.All_sites {
+				VC.WriteRefSite(w, pos, VC.RefBase(pos), var_call_prob, VC.CallDepth(rid, var_pos))
+			}
 			continue
 		}
 		// Start getting variant call info
@@ -995,6 +1735,9 @@ func (VC *VarCallIndex) OutputVarCalls() {
 			if _, is_known_del = VC.DelVar[pos]; is_known_del {
 				//Do not report known variants which are identical with the reference
 				if hap_arr[0] == string(VC.Variants[pos][0][0]) && hap_arr[1] == string(VC.Variants[pos][0][0]) {
+					if PARA.All_sites {
+						VC.WriteRefSite(w, pos, VC.RefBase(pos), var_call_prob, VC.CallDepth(rid, var_pos))
+					}
 					continue
 				}
 				line_aln = append(line_aln, hap_arr[0])
@@ -1002,6 +1745,9 @@ func (VC *VarCallIndex) OutputVarCalls() {
 			} else {
 				//Do not report known variants which are identical with the reference
 				if hap_arr[0] == string(VC.Variants[pos][0]) && hap_arr[1] == string(VC.Variants[pos][0]) {
+					if PARA.All_sites {
+						VC.WriteRefSite(w, pos, VC.RefBase(pos), var_call_prob, VC.CallDepth(rid, var_pos))
+					}
 					continue
 				}
 				line_aln = append(line_aln, string(VC.Variants[pos][0]))
@@ -1010,10 +1756,13 @@ func (VC *VarCallIndex) OutputVarCalls() {
 		} else {
 			//Do not report variants which are identical with the reference
 			if hap_arr[0] == string(VC.Seq[pos]) && hap_arr[1] == string(VC.Seq[pos]) {
+				if PARA.All_sites {
+					VC.WriteRefSite(w, pos, VC.RefBase(pos), var_call_prob, VC.CallDepth(rid, var_pos))
+				}
 				continue
 			}
-			if VarCall[rid].VarType[var_pos][var_call] >= 0 {
-				if VarCall[rid].VarType[var_pos][var_call] == 2 { //DEL
+			if site.Type.Get(var_call) >= 0 {
+				if site.Type.Get(var_call) == 2 { //DEL
 					line_aln = append(line_aln, hap_arr[0])
 					line_aln = append(line_aln, hap_arr[1])
 				} else { //SUB or INS
@@ -1024,33 +1773,64 @@ func (VC *VarCallIndex) OutputVarCalls() {
 				continue
 			}
 		}
+		// Other non-reference alleles with substantial posterior probability are reported in a single
+		// multi-allelic record
+		alleles := VC.MultiAlleles(rid, var_pos, line_aln[3], hap_arr)
+		if alleles != nil {
+			line_aln[4] = strings.Join(alleles[1:], ",")
+		}
 		// QUAL
+		var_qual = math.Min(-10*math.Log10(1-var_call_prob), 1000)
+		if var_qual < PARA.Min_qual {
+			atomic.AddInt64(&SUMMARY.LowQualNum, 1)
+			continue
+		}
 		str_qual = strconv.FormatFloat(-10*math.Log10(1-var_call_prob), 'f', 5, 64)
 		if str_qual != "+Inf" {
 			line_aln = append(line_aln, str_qual)
 		} else {
 			line_aln = append(line_aln, "1000")
 		}
-		// FILTER
+		// FILTER (determined after INFO and FORMAT are computed)
 		line_aln = append(line_aln, ".")
 		// INFO
-		str_info = ""
-		if _, is_known_var = VC.Variants[pos]; is_known_var {
-			str_info += "KV;"
+		info_buf = info_buf[:0]
+		if _, is_known_var = VC.Variants[pos]; is_known_var && !FIRST_PASS_VAR[pos] {
+			info_buf = append(info_buf, "KV;"...)
 		}
-		str_info += "VP=" + strconv.FormatFloat(var_call_prob, 'f', 20, 64) + ";"
+		info_buf = strconv.AppendFloat(append(info_buf, "VP="...), var_call_prob, 'f', 20, 64)
 		map_prob = 1.0
-		for _, p = range VarCall[rid].MapProb[var_pos][var_call] {
+		for _, p = range VC.Calls[rid].MapProb[var_pos][var_call] {
 			map_prob *= p
 		}
-		str_info += "MP=" + strconv.FormatFloat(map_prob, 'f', 20, 64) + ";"
-		comb_prob = var_call_prob*map_prob
-		str_info += "CP=" + strconv.FormatFloat(comb_prob, 'f', 20, 64)
+		info_buf = strconv.AppendFloat(append(info_buf, ";MP="...), map_prob, 'f', 20, 64)
+		comb_prob = var_call_prob * map_prob
+		info_buf = strconv.AppendFloat(append(info_buf, ";CP="...), comb_prob, 'f', 20, 64)
+		info_buf = append(info_buf, site.Stat.Annotations(var_qual)...)
+		// FS from per-strand counts of the reference and the alternative alleles
+		if alleles != nil {
+			strand_counts = MultiStrandCounts(site.RNum.Map(), site.RevNum.Map(), alleles)
+		} else {
+			strand_counts = StrandCounts(site.RNum.Map(), site.RevNum.Map(), hap_arr[1])
+		}
+		info_buf = strconv.AppendFloat(append(info_buf, ";FS="...), FisherStrand(strand_counts), 'f', 3, 64)
+		if VC.Downsampled(rid, var_pos) {
+			info_buf = append(info_buf, ";DS"...)
+		}
+		// Genotypes of the trio are called jointly in trio mode
+		var trio *TrioCall
+		if TRIO != nil {
+			if trio = VC.CallTrio(rid, var_pos, hap_arr, NovelRate(line_aln[3], line_aln[4])); trio != nil {
+				info_buf = append(info_buf, trio.Annotations()...)
+			}
+		}
+		str_info = string(info_buf)
 		line_aln = append(line_aln, str_info)
 		// FORMAT
 		read_depth = 0
 		var_depth = math.MaxInt64
-		for var_base, var_num = range VarCall[rid].VarRNum[var_pos] {
+		for k, n := range site.RNum.Vals {
+			var_base, var_num = site.RNum.Keys[k], n
 			read_depth += var_num
 			var_arr = strings.Split(var_base, "|")
 			if len(var_arr[0]) > len(var_arr[1]) { //DEL
@@ -1067,59 +1847,106 @@ func (VC *VarCallIndex) OutputVarCalls() {
 				}
 			}
 		}
-		line_aln = append(line_aln, "GT:GQ:AD:DP")
-		str_format = ""
-		if hap_arr[0] == hap_arr[1] {
-			str_format += "1/1:"
+		if alleles != nil {
+			str_pl = VC.MultiPhredLikelihoods(rid, var_pos, alleles)
+		} else {
+			str_pl = VC.PhredLikelihoods(rid, var_pos, hap_arr)
+		}
+		if str_pl != "" {
+			line_aln = append(line_aln, "GT:GQ:AD:DP:ADF:ADR:SB:PL")
 		} else {
-			str_format += "0/1:"
+			line_aln = append(line_aln, "GT:GQ:AD:DP:ADF:ADR:SB")
 		}
+		str_format = "0/1"
+		if hap_arr[0] == hap_arr[1] {
+			str_format = "1/1"
+		}
+		if alleles != nil {
+			str_format = MultiGT(alleles, hap_arr)
+		}
+		if IsHaploid(pos) {
+			str_format = HaploidGT(str_format)
+		}
+		phase_set := 0
+		if phased_gt, is_phased := phased[pos]; is_phased && str_format == "0/1" {
+			str_format, phase_set = phased_gt.GT, phased_gt.PhaseSet
+		}
+		str_format += ":"
 		str_qual = strconv.FormatFloat(-10*math.Log10(1-comb_prob), 'f', 5, 64)
 		if str_qual != "+Inf" {
 			str_format += str_qual + ":"
 		} else {
 			str_format += "1000:"
 		}
-		str_format += strconv.Itoa(var_depth) + ":"
-		str_format += strconv.Itoa(read_depth)
-		line_aln = append(line_aln, str_format)
+		if alleles != nil {
+			str_format += MultiAlleleCounts(site.RNum.Map(), site.RevNum.Map(), alleles)
+		} else {
+			str_format += strconv.Itoa(var_depth) + ":"
+			str_format += strconv.Itoa(read_depth) + ":"
+			str_format += StrandFormat(strand_counts)
+		}
+		if str_pl != "" {
+			str_format += ":" + str_pl
+		}
+		if phase_set > 0 {
+			line_aln[8] += ":PS"
+			str_format += ":" + strconv.Itoa(phase_set)
+		}
+		if MultiSample() {
+			sample_formats := make([]string, len(SAMPLES))
+			for s := 0; s < len(SAMPLES); s++ {
+				sample_formats[s] = VC.SampleFormat(rid, var_pos, s, hap_arr, str_pl != "")
+				if trio != nil {
+					sample_formats[s] = trio.Format(s, sample_formats[s])
+				}
+			}
+			line_aln = append(line_aln, strings.Join(sample_formats, "\t"))
+		} else {
+			line_aln = append(line_aln, str_format)
+		}
+		if len(FILTERS) > 0 {
+			line_aln[6] = ApplyFilters(FILTERS, FilterValues(var_qual, str_info, line_aln[8], str_format))
+		}
+		line_aln[6] = MASK.Filter(pos, line_aln[6])
 
-		str_aln = strings.Join(line_aln, "\t")
+		atomic.AddInt64(&SUMMARY.EmittedNum, 1)
+		if SUPPORT != nil {
+			SUPPORT.Add(var_pos, VC.Calls[rid].VarReads[var_pos], []string{line_aln[0], line_aln[1], line_aln[3], line_aln[4]}, func(var_base string) int {
+				if alleles != nil {
+					return MinInt(MultiAlleleIndex(var_base, alleles), 1)
+				}
+				return AlleleIndex(var_base, hap_arr[1])
+			})
+		}
 		if !PARA.Debug_mode {
-			w.WriteString(str_aln + "\n")
+			line_buf = AppendRecord(line_buf[:0], line_aln)
+			w.Write(line_buf)
 		} else {
+			str_aln = strings.Join(line_aln, "\t")
 			line_base = make([]string, 0)
-			for var_base, var_num = range VarCall[rid].VarRNum[var_pos] {
+			for k, n := range site.RNum.Vals {
+				var_base, var_num = site.RNum.Keys[k], n
 				line_base = append(line_base, var_base)
 				line_base = append(line_base, strconv.Itoa(var_num))
 			}
-			for i = 0; i < len(VarCall[rid].VarBQual[var_pos][var_call]); i++ {
+			for i = 0; i < len(VC.Calls[rid].VarBQual[var_pos][var_call]); i++ {
 				line_ivc = make([]string, 0)
-				line_ivc = append(line_ivc, string(VarCall[rid].VarBQual[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.Itoa(VarCall[rid].ChrDis[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.Itoa(VarCall[rid].ChrDiff[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.FormatFloat(VarCall[rid].MapProb[var_pos][var_call][i], 'f', 20, 64))
-				line_ivc = append(line_ivc, strconv.FormatFloat(VarCall[rid].AlnProb[var_pos][var_call][i], 'f', 20, 64))
-				line_ivc = append(line_ivc, strconv.FormatFloat(VarCall[rid].ChrProb[var_pos][var_call][i], 'f', 20, 64))
-				line_ivc = append(line_ivc, strconv.Itoa(VarCall[rid].StartPos1[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.FormatBool(VarCall[rid].Strand1[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.Itoa(VarCall[rid].StartPos2[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, strconv.FormatBool(VarCall[rid].Strand2[var_pos][var_call][i]))
-				line_ivc = append(line_ivc, string(VarCall[rid].ReadInfo[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, string(VC.Calls[rid].VarBQual[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.Itoa(VC.Calls[rid].ChrDis[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.Itoa(VC.Calls[rid].ChrDiff[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.FormatFloat(VC.Calls[rid].MapProb[var_pos][var_call][i], 'f', 20, 64))
+				line_ivc = append(line_ivc, strconv.FormatFloat(VC.Calls[rid].AlnProb[var_pos][var_call][i], 'f', 20, 64))
+				line_ivc = append(line_ivc, strconv.FormatFloat(VC.Calls[rid].ChrProb[var_pos][var_call][i], 'f', 20, 64))
+				line_ivc = append(line_ivc, strconv.Itoa(VC.Calls[rid].StartPos1[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.FormatBool(VC.Calls[rid].Strand1[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.Itoa(VC.Calls[rid].StartPos2[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, strconv.FormatBool(VC.Calls[rid].Strand2[var_pos][var_call][i]))
+				line_ivc = append(line_ivc, string(VC.Calls[rid].ReadInfo[var_pos][var_call][i]))
 				w.WriteString(str_aln + "\t" + strings.Join(line_ivc, "\t") + "\t" + strings.Join(line_base, "\t") + "\n")
 			}
 		}
 	}
-	w.Flush()
-	output_var_time := time.Since(start_time)
-	if PARA.Debug_mode {
-		PrintMemStats("Memstats after outputing variant calls")
-		pprof.StopCPUProfile()
-		CPU_FILE.Close()
-		MEM_FILE.Close()
+	if PARA.All_sites {
+		VC.WriteRefSites(w, next_pos, end)
 	}
-	log.Printf("Time for outputing variant calls:\t%s", output_var_time)
-	log.Printf("Finish outputing variant calls.")
-	log.Printf("------------------------------------------------------")
-	log.Printf("Check results in the file: %s", PARA.Var_call_file)
 }